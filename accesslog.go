@@ -0,0 +1,114 @@
+package redeo
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// AccessLogEntry describes an executed command, see Config.AccessLog.
+type AccessLogEntry struct {
+	// Time is the time at which the execution started
+	Time time.Time
+	// Duration is the execution time
+	Duration time.Duration
+	// ClientID is the ID of the client which sent the command
+	ClientID uint64
+	// ClientAddr is the remote address of the client
+	ClientAddr string
+	// Name is the normalised (lower-case) command name
+	Name string
+	// NumArgs is the number of arguments, zero for streamed commands
+	NumArgs int
+	// ArgsSize is the total size of the arguments in bytes
+	ArgsSize int64
+	// Error is the error reply, empty if the command succeeded
+	Error string
+}
+
+// String formats the entry as a single logfmt line, without a trailing
+// newline.
+func (e *AccessLogEntry) String() string {
+	buf := make([]byte, 0, 128)
+	buf = append(buf, "time="...)
+	buf = e.Time.AppendFormat(buf, time.RFC3339Nano)
+	buf = append(buf, " client="...)
+	buf = strconv.AppendUint(buf, e.ClientID, 10)
+	buf = append(buf, " addr="...)
+	buf = append(buf, e.ClientAddr...)
+	buf = append(buf, " cmd="...)
+	buf = append(buf, e.Name...)
+	buf = append(buf, " args="...)
+	buf = strconv.AppendInt(buf, int64(e.NumArgs), 10)
+	buf = append(buf, " bytes="...)
+	buf = strconv.AppendInt(buf, e.ArgsSize, 10)
+	buf = append(buf, " duration="...)
+	buf = append(buf, e.Duration.String()...)
+	if e.Error != "" {
+		buf = append(buf, " error="...)
+		buf = strconv.AppendQuote(buf, e.Error)
+	}
+	return string(buf)
+}
+
+// AccessLogger receives access log entries, see Config.AccessLog. It is
+// called synchronously after each logged command and must be safe for
+// concurrent use.
+type AccessLogger interface {
+	LogAccess(e *AccessLogEntry)
+}
+
+// AccessLoggerFunc is a callback function, implementing AccessLogger.
+type AccessLoggerFunc func(e *AccessLogEntry)
+
+// LogAccess calls fn(e).
+func (fn AccessLoggerFunc) LogAccess(e *AccessLogEntry) { fn(e) }
+
+// NewAccessLogger returns an AccessLogger, which writes a line per entry
+// to w, formatted by format. Pass a nil format to use
+// AccessLogEntry.String.
+func NewAccessLogger(w io.Writer, format func(*AccessLogEntry) string) AccessLogger {
+	if format == nil {
+		format = (*AccessLogEntry).String
+	}
+
+	var mu sync.Mutex
+	return AccessLoggerFunc(func(e *AccessLogEntry) {
+		line := format(e) + "\n"
+		mu.Lock()
+		_, _ = io.WriteString(w, line)
+		mu.Unlock()
+	})
+}
+
+// logAccess records a command in the access log, if configured and
+// selected by Config.AccessLogSampling.
+func (srv *Server) logAccess(c *Client, name string, args []resp.CommandArgument, start time.Time, d time.Duration, rw *replyWriter) {
+	conf := srv.conf()
+	if conf.AccessLog == nil {
+		return
+	}
+	if n := conf.AccessLogSampling; n > 1 && atomic.AddUint64(&srv.accesses, 1)%uint64(n) != 1 {
+		return
+	}
+
+	e := &AccessLogEntry{
+		Time:       start,
+		Duration:   d,
+		ClientID:   c.id,
+		ClientAddr: c.RemoteAddr().String(),
+		Name:       name,
+		NumArgs:    len(args),
+	}
+	for _, arg := range args {
+		e.ArgsSize += int64(len(arg))
+	}
+	if rw.failed {
+		e.Error = rw.errMsg
+	}
+	conf.AccessLog.LogAccess(e)
+}
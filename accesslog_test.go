@@ -0,0 +1,79 @@
+package redeo
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AccessLog", func() {
+
+	It("should format entries", func() {
+		e := &AccessLogEntry{
+			Time:       time.Date(2018, 1, 5, 11, 25, 15, 0, time.UTC),
+			Duration:   1500 * time.Microsecond,
+			ClientID:   7,
+			ClientAddr: "1.2.3.4:10001",
+			Name:       "get",
+			NumArgs:    1,
+			ArgsSize:   3,
+		}
+		Expect(e.String()).To(Equal("time=2018-01-05T11:25:15Z client=7 addr=1.2.3.4:10001 cmd=get args=1 bytes=3 duration=1.5ms"))
+
+		e.Error = "ERR bad"
+		Expect(e.String()).To(HaveSuffix(` duration=1.5ms error="ERR bad"`))
+
+		buf := new(bytes.Buffer)
+		NewAccessLogger(buf, func(e *AccessLogEntry) string { return e.Name }).LogAccess(e)
+		Expect(buf.String()).To(Equal("get\n"))
+	})
+
+	It("should log sampled commands", func() {
+		var entries []*AccessLogEntry
+		var mu sync.Mutex
+
+		srv := NewServer(&Config{
+			AccessLogSampling: 2,
+			AccessLog: AccessLoggerFunc(func(e *AccessLogEntry) {
+				mu.Lock()
+				entries = append(entries, e)
+				mu.Unlock()
+			}),
+		})
+		srv.Handle("echo", Echo())
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go srv.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmdString("ECHO", "hello")
+		cw.WriteCmdString("ECHO", "skipped")
+		cw.WriteCmdString("ECHO")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadBulkString()).To(Equal("hello"))
+		Expect(cr.ReadBulkString()).To(Equal("skipped"))
+		Expect(cr.ReadError()).To(Equal("ERR wrong number of arguments for 'ECHO' command"))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Name).To(Equal("echo"))
+		Expect(entries[0].NumArgs).To(Equal(1))
+		Expect(entries[0].ArgsSize).To(Equal(int64(5)))
+		Expect(entries[0].ClientAddr).To(Equal(cn.LocalAddr().String()))
+		Expect(entries[0].Error).To(BeEmpty())
+		Expect(entries[1].Error).To(Equal("ERR wrong number of arguments for 'ECHO' command"))
+	})
+
+})
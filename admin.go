@@ -0,0 +1,175 @@
+package redeo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errNotListening = errors.New("redeo: not accepting connections")
+	errAcceptFailed = errors.New("redeo: failing to accept connections")
+)
+
+// AdminHandler returns an http.Handler serving administrative endpoints,
+// e.g. for orchestration systems which cannot speak RESP:
+//
+//	/healthz       200 OK while connections are accepted, 503 otherwise
+//	/info          the stats as JSON, see ServerInfo.Expvar
+//	/metrics       the stats in the Prometheus text format
+//	/debug/pprof/  the runtime profiles, see net/http/pprof
+//
+// It is served via Config.AdminAddr, if set.
+func (srv *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := srv.Healthy(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err.Error())
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, srv.info.Expvar().String())
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(srv.info.appendMetrics(nil))
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Healthy returns nil while the server accepts connections, i.e. it is
+// serving at least one listener and no accept loop is backing off after
+// errors. Otherwise it returns the reason.
+func (srv *Server) Healthy() error {
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	srv.lisMu.Lock()
+	n := len(srv.listeners)
+	srv.lisMu.Unlock()
+
+	if n == 0 {
+		return errNotListening
+	}
+	if atomic.LoadInt32(&srv.backoff) != 0 {
+		return errAcceptFailed
+	}
+	return nil
+}
+
+// AdminAddr returns the address of the admin server, nil unless it is
+// running, see Config.AdminAddr.
+func (srv *Server) AdminAddr() net.Addr {
+	srv.adminMu.Lock()
+	defer srv.adminMu.Unlock()
+
+	if srv.adminLn == nil {
+		return nil
+	}
+	return srv.adminLn.Addr()
+}
+
+// startAdmin starts the admin server, if configured and not yet running.
+func (srv *Server) startAdmin() error {
+	addr := srv.conf().AdminAddr
+	if addr == "" {
+		return nil
+	}
+
+	srv.adminMu.Lock()
+	defer srv.adminMu.Unlock()
+
+	if srv.admin != nil {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("redeo: admin server: %v", err)
+	}
+	hs := &http.Server{Handler: srv.AdminHandler(), ReadHeaderTimeout: 10 * time.Second}
+	srv.admin, srv.adminLn = hs, ln
+	go func() { _ = hs.Serve(ln) }()
+	return nil
+}
+
+// closeAdmin stops the admin server, if running.
+func (srv *Server) closeAdmin() {
+	srv.adminMu.Lock()
+	hs := srv.admin
+	srv.admin, srv.adminLn = nil, nil
+	srv.adminMu.Unlock()
+
+	if hs != nil {
+		_ = hs.Close()
+	}
+}
+
+// --------------------------------------------------------------------
+
+// appendMetrics appends the stats in the Prometheus text format.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+func (i *ServerInfo) appendMetrics(b []byte) []byte {
+	buf := bytes.NewBuffer(b)
+	metric := func(name, typ, help string, v int64) {
+		fmt.Fprintf(buf, "# HELP redeo_%s %s\n# TYPE redeo_%s %s\nredeo_%s %d\n", name, help, name, typ, name, v)
+	}
+
+	metric("connected_clients", "gauge", "Number of connected clients.", int64(i.NumClients()))
+	metric("connected_clients_peak", "gauge", "Peak number of connected clients.", i.PeakClients())
+	metric("blocked_clients", "gauge", "Number of clients blocked by commands.", int64(i.NumBlocked()))
+	metric("connections_received_total", "counter", "Number of accepted connections.", i.TotalConnections())
+	metric("rejected_connections_total", "counter", "Number of connections rejected over the client limit.", i.RejectedConnections())
+	metric("evicted_clients_total", "counter", "Number of clients disconnected by the server.", i.EvictedClients())
+	metric("commands_processed_total", "counter", "Number of processed commands.", i.TotalCommands())
+	metric("unknown_commands_total", "counter", "Number of unknown commands.", i.UnknownCommands())
+	metric("handler_panics_total", "counter", "Number of recovered handler panics.", i.TotalPanics())
+	metric("net_input_bytes_total", "counter", "Number of bytes read from clients.", i.TotalNetInputBytes())
+	metric("net_output_bytes_total", "counter", "Number of bytes written to clients.", i.TotalNetOutputBytes())
+
+	stats := i.CommandStats()
+	if len(stats) == 0 {
+		return buf.Bytes()
+	}
+
+	buf.WriteString("# HELP redeo_command_calls_total Number of calls by command.\n# TYPE redeo_command_calls_total counter\n")
+	for _, st := range stats {
+		fmt.Fprintf(buf, "redeo_command_calls_total{command=%q} %d\n", st.Name, st.Calls)
+	}
+	buf.WriteString("# HELP redeo_command_failed_calls_total Number of calls which replied with an error by command.\n# TYPE redeo_command_failed_calls_total counter\n")
+	for _, st := range stats {
+		fmt.Fprintf(buf, "redeo_command_failed_calls_total{command=%q} %d\n", st.Name, st.Errors)
+	}
+	buf.WriteString("# HELP redeo_command_duration_seconds Execution time by command.\n# TYPE redeo_command_duration_seconds histogram\n")
+	for _, st := range stats {
+		var sum int64
+		for n, calls := range st.Histogram {
+			sum += calls
+			le := "+Inf"
+			if n < len(LatencyBuckets) {
+				le = strconv.FormatFloat(LatencyBuckets[n].Seconds(), 'g', -1, 64)
+			}
+			fmt.Fprintf(buf, "redeo_command_duration_seconds_bucket{command=%q,le=%q} %d\n", st.Name, le, sum)
+		}
+		fmt.Fprintf(buf, "redeo_command_duration_seconds_sum{command=%q} %s\n", st.Name, strconv.FormatFloat(st.Total.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(buf, "redeo_command_duration_seconds_count{command=%q} %d\n", st.Name, st.Calls)
+	}
+	return buf.Bytes()
+}
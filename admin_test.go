@@ -0,0 +1,129 @@
+package redeo
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Admin", func() {
+	var subject *Server
+	var admin *httptest.Server
+
+	BeforeEach(func() {
+		subject = NewServer(nil)
+		subject.Handle("ping", Ping())
+		admin = httptest.NewServer(subject.AdminHandler())
+	})
+
+	AfterEach(func() {
+		admin.Close()
+	})
+
+	get := func(path string) (int, string) {
+		res, err := http.Get(admin.URL + path)
+		Expect(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return res.StatusCode, string(body)
+	}
+
+	healthz := func() int {
+		status, _ := get("/healthz")
+		return status
+	}
+
+	It("should report health", func() {
+		status, body := get("/healthz")
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(Equal("redeo: not accepting connections\n"))
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go subject.Serve(lis)
+		Eventually(healthz).Should(Equal(http.StatusOK))
+
+		Expect(subject.Shutdown(context.Background())).To(Succeed())
+		Expect(subject.Healthy()).To(Equal(ErrServerClosed))
+		Expect(healthz()).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should report failing accept loops", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+
+		tmp := &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+		go subject.Serve(&faultyListener{Listener: lis, errs: []error{tmp, tmp, tmp, tmp}})
+		Eventually(subject.Healthy).Should(MatchError(errAcceptFailed))
+		Expect(healthz()).To(Equal(http.StatusServiceUnavailable))
+
+		// recovers once connections are accepted again
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+		Eventually(subject.Healthy, time.Second).Should(Succeed())
+	})
+
+	It("should serve info and metrics", func() {
+		subject.info.observe("ping", 20*time.Microsecond, false)
+
+		status, body := get("/info")
+		Expect(status).To(Equal(http.StatusOK))
+
+		var info map[string]interface{}
+		Expect(json.Unmarshal([]byte(body), &info)).To(Succeed())
+		Expect(info).To(HaveKeyWithValue("connected_clients", 0.0))
+		Expect(info).To(HaveKey("commands"))
+
+		status, body = get("/metrics")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring("# TYPE redeo_connected_clients gauge\nredeo_connected_clients 0\n"))
+		Expect(body).To(ContainSubstring(`redeo_command_calls_total{command="ping"} 1`))
+		Expect(body).To(ContainSubstring(`redeo_command_duration_seconds_bucket{command="ping",le="1e-05"} 0`))
+		Expect(body).To(ContainSubstring(`redeo_command_duration_seconds_bucket{command="ping",le="5e-05"} 1`))
+		Expect(body).To(ContainSubstring(`redeo_command_duration_seconds_bucket{command="ping",le="+Inf"} 1`))
+		Expect(body).To(ContainSubstring(`redeo_command_duration_seconds_count{command="ping"} 1`))
+	})
+
+	It("should serve profiles", func() {
+		status, body := get("/debug/pprof/")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring("goroutine"))
+	})
+
+	It("should listen on the admin address", func() {
+		srv := NewServer(&Config{AdminAddr: "127.0.0.1:0"})
+		Expect(srv.AdminAddr()).To(BeNil())
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go srv.Serve(lis)
+		Eventually(srv.AdminAddr).ShouldNot(BeNil())
+
+		url := "http://" + srv.AdminAddr().String() + "/healthz"
+		Eventually(func() int {
+			res, err := http.Get(url)
+			if err != nil {
+				return 0
+			}
+			res.Body.Close()
+			return res.StatusCode
+		}).Should(Equal(http.StatusOK))
+
+		Expect(srv.Close()).To(Succeed())
+		Expect(srv.AdminAddr()).To(BeNil())
+		_, err = http.Get(url)
+		Expect(err).To(HaveOccurred())
+	})
+})
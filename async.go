@@ -0,0 +1,378 @@
+package redeo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+const (
+	// defaultAsyncHighWaterMark is the default for Config.AsyncHighWaterMark.
+	defaultAsyncHighWaterMark = 1 << 20
+
+	// promiseBufferSize is the initial reply buffer size of promises.
+	promiseBufferSize = 512
+)
+
+var (
+	// ErrPromiseTimeout is returned by Promise.Err when Config.AsyncTimeout
+	// has expired.
+	ErrPromiseTimeout = errors.New("redeo: promise timed out")
+
+	// ErrPromiseCancelled is returned by Promise.Err when the client has
+	// disconnected or was closed before the promise was resolved.
+	ErrPromiseCancelled = errors.New("redeo: promise cancelled")
+)
+
+// AsyncHandler is an interface for commands which reply asynchronously.
+type AsyncHandler interface {
+	// ServeRedeoAsync serves a request. It is called on a separate
+	// goroutine and must reply by resolving p, e.g. from yet another
+	// goroutine.
+	ServeRedeoAsync(p *Promise, c *resp.Command)
+}
+
+// AsyncHandlerFunc is a callback function, implementing AsyncHandler.
+type AsyncHandlerFunc func(p *Promise, c *resp.Command)
+
+// ServeRedeoAsync calls f(p, c).
+func (f AsyncHandlerFunc) ServeRedeoAsync(p *Promise, c *resp.Command) { f(p, c) }
+
+// Promise is the pending reply of an asynchronous command, see
+// AsyncHandler.
+type Promise struct {
+	srv    *Server
+	client *Client
+	name   string
+	entry  *handlerEntry
+	cmd    *resp.Command
+	start  time.Time
+	ran    bool // true once the handler was called
+	inline bool // true for commands executed within transactions
+	end    func(error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	timer  *time.Timer
+
+	buf  bytes.Buffer
+	rw   replyWriter
+	err  error
+	done chan struct{}
+	mu   sync.Mutex
+}
+
+func (srv *Server) newPromise(c *Client, name string, entry *handlerEntry, cmd *resp.Command) *Promise {
+	p := &Promise{
+		srv:    srv,
+		client: c,
+		name:   name,
+		entry:  entry,
+		cmd:    cmd,
+		start:  time.Now(),
+		done:   make(chan struct{}),
+	}
+	p.rw.ResponseWriter = resp.NewResponseWriterSize(&p.buf, promiseBufferSize)
+	p.rw.SetProtocol(c.wr.Protocol())
+	p.rw.bufferTo(&p.buf)
+	return p
+}
+
+// Context returns the command context. It is cancelled once the promise is
+// done, so handlers may abandon work once the client has disconnected.
+func (p *Promise) Context() context.Context { return p.ctx }
+
+// Resolve writes the reply via fn. It may be called from any goroutine.
+// Returns false if the promise has already been resolved, has timed out
+// or was cancelled.
+func (p *Promise) Resolve(fn func(w resp.ResponseWriter)) bool {
+	return p.finish(fn, nil)
+}
+
+// Done returns a channel which is closed once the promise is resolved,
+// has timed out or was cancelled.
+func (p *Promise) Done() <-chan struct{} { return p.done }
+
+// Err returns nil if the promise was resolved, ErrPromiseTimeout or
+// ErrPromiseCancelled. It must only be called once Done is closed.
+func (p *Promise) Err() error {
+	p.mu.Lock()
+	err := p.err
+	p.mu.Unlock()
+	return err
+}
+
+// run sets up the context and the timeout and calls the handler.
+func (p *Promise) run(h AsyncHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resolved() {
+		return
+	}
+
+	p.ctx, p.cancel = context.WithCancel(p.cmd.Context())
+	p.cmd.SetContext(p.ctx)
+	if d := p.srv.conf().AsyncTimeout; d > 0 {
+		p.timer = time.AfterFunc(d, func() { p.finish(nil, ErrPromiseTimeout) })
+	}
+	p.ran = true
+
+	go p.serve(h)
+}
+
+func (p *Promise) serve(h AsyncHandler) {
+	defer func() {
+		if r := recover(); r != nil {
+			// re-panic within reply, to convert r into an error reply
+			p.finish(func(resp.ResponseWriter) { panic(r) }, nil)
+		}
+	}()
+
+	// share the transaction lock, see Server.serveCmd; commands replayed
+	// by EXEC already hold it
+	if !p.inline && p.srv.conf().Transactions {
+		p.srv.txMu.RLock()
+		defer p.srv.txMu.RUnlock()
+	}
+	h.ServeRedeoAsync(p, p.cmd)
+}
+
+func (p *Promise) finish(fn func(resp.ResponseWriter), err error) bool {
+	if !p.complete(fn, err) {
+		return false
+	}
+	if !p.inline {
+		p.client.deliver()
+	}
+	return true
+}
+
+func (p *Promise) complete(fn func(resp.ResponseWriter), err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resolved() {
+		return false
+	}
+
+	switch err {
+	case nil:
+		p.reply(fn)
+	case ErrPromiseTimeout:
+		p.rw.AppendError("ERR command timed out")
+	}
+	_ = p.rw.Flush()
+
+	p.err = err
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if p.cancel != nil {
+		p.cancel()
+	}
+	if !p.inline {
+		p.srv.observePromise(p)
+	}
+	close(p.done)
+	return true
+}
+
+// resolved returns true once the promise is done.
+func (p *Promise) resolved() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// reply writes the reply via fn, converting panics to error replies.
+func (p *Promise) reply(fn func(resp.ResponseWriter)) {
+	defer p.srv.recoverPanic(&p.rw, p.name)
+	fn(&p.rw)
+}
+
+// --------------------------------------------------------------------
+
+// performAsync starts an asynchronous command. Its reply is appended
+// once all preceding commands have been replied to.
+func (srv *Server) performAsync(c *Client, name string, entry *handlerEntry, h AsyncHandler) (err error) {
+	if c.cmd, err = c.readCmd(c.cmd); err != nil {
+		return err
+	}
+
+	p := srv.newPromise(c, name, entry, copyCommand(c.cmd))
+	p.cmd.SetContext(withTraceParent(c.cmdContext(), c.cmd))
+	if srv.conf().Hooks.OnCommandStart != nil {
+		p.end = srv.startCommand(c, p.cmd, name, nil)
+	}
+	c.queuePromise(p)
+
+	if msg := entry.spec.check(p.cmd); msg != "" {
+		p.finish(func(w resp.ResponseWriter) { w.AppendError(msg) }, nil)
+	} else if err := srv.authorize(c, name, p.cmd.Args); err != nil {
+		p.finish(func(w resp.ResponseWriter) { w.AppendError(err.Error()) }, nil)
+	} else {
+		srv.feedMonitors(c, p.cmd.Name, p.cmd.Args)
+		p.run(h)
+	}
+
+	// pause reading while too many replies are outstanding
+	limit := srv.conf().AsyncHighWaterMark
+	if limit <= 0 {
+		limit = defaultAsyncHighWaterMark
+	}
+	for srv.appendResolved(c) >= limit || len(c.promises) >= maxDispatched {
+		<-c.promises[0].done
+	}
+	return srv.autoFlush(c)
+}
+
+// appendResolved appends the replies of the resolved promises at the head
+// of the queue. Returns the number of bytes held by resolved promises
+// which are still waiting for preceding commands.
+func (srv *Server) appendResolved(c *Client) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.drainPromises()
+}
+
+// settle waits for all outstanding promises of the client and appends
+// their replies in request order.
+func (srv *Server) settle(c *Client) {
+	for len(c.promises) != 0 {
+		<-c.promises[0].done
+		srv.appendResolved(c)
+	}
+}
+
+// asyncable returns the handler entry if the command can be performed
+// asynchronously.
+func (srv *Server) asyncable(c *Client, norm string) (*handlerEntry, bool) {
+	entry, ok := srv.deferrable(c, norm)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := entry.served.(AsyncHandler); !ok {
+		return nil, false
+	}
+	return entry, true
+}
+
+// execAsync performs an asynchronous command within a transaction and
+// waits for its reply.
+func (srv *Server) execAsync(c *Client, norm string, entry *handlerEntry, h AsyncHandler, cmd *resp.Command) {
+	p := srv.newPromise(c, norm, entry, cmd)
+	p.inline = true
+	c.queuePromise(p)
+	p.run(h)
+	<-p.done
+
+	c.mu.Lock()
+	c.shiftPromises(1)
+	c.mu.Unlock()
+
+	c.rw.AppendRaw(p.buf.Bytes())
+	c.rw.failed, c.rw.errMsg = p.rw.failed, p.rw.errMsg
+}
+
+// observePromise records the execution of an asynchronous command.
+func (srv *Server) observePromise(p *Promise) {
+	srv.record(p.client, p.entry, p.name, p.cmd.Args, p.start, p.ran, &p.rw)
+	if p.end != nil {
+		p.end(p.rw.result(nil))
+	}
+}
+
+// --------------------------------------------------------------------
+
+func (c *Client) queuePromise(p *Promise) {
+	c.mu.Lock()
+	c.promises = append(c.promises, p)
+	closed := c.closed
+	c.mu.Unlock()
+
+	if closed {
+		p.finish(nil, ErrPromiseCancelled)
+	}
+}
+
+// drainPromises writes the replies of the resolved promises at the head
+// of the queue. Returns the number of bytes held by resolved promises
+// which are still waiting for preceding commands. Must be called with
+// the lock held.
+func (c *Client) drainPromises() (held int) {
+	n := 0
+	for n < len(c.promises) && c.promises[n].resolved() {
+		c.wr.AppendRaw(c.promises[n].buf.Bytes())
+		n++
+	}
+	c.shiftPromises(n)
+
+	for _, p := range c.promises {
+		if p.resolved() {
+			held += p.buf.Len()
+		}
+	}
+	atomic.StoreInt64(&c.held, int64(held))
+	return held
+}
+
+// deliver writes the replies of resolved promises while the client is
+// waiting for the next pipeline. Busy clients collect them once the
+// current command has completed.
+func (c *Client) deliver() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.busy || len(c.promises) == 0 || !c.promises[0].resolved() {
+		return
+	}
+	c.drainPromises()
+	c.setWriteDeadline()
+	_ = c.wr.Flush()
+}
+
+// shiftPromises removes the first n promises. Must be called with the
+// lock held.
+func (c *Client) shiftPromises(n int) {
+	if n == 0 {
+		return
+	}
+
+	m := copy(c.promises, c.promises[n:])
+	for i := m; i < len(c.promises); i++ {
+		c.promises[i] = nil
+	}
+	c.promises = c.promises[:m]
+}
+
+// cancelPromises cancels all outstanding promises. Must be called with
+// the lock held, promises are finished asynchronously as their completion
+// requires the lock.
+func (c *Client) cancelPromises() {
+	for _, p := range c.promises {
+		go p.finish(nil, ErrPromiseCancelled)
+	}
+}
+
+// --------------------------------------------------------------------
+
+// HandleAsync registers a handler for a command which replies
+// asynchronously. Consecutive asynchronous commands of a pipeline are
+// executed concurrently, replies are always written in request order.
+func (srv *Server) HandleAsync(name string, h AsyncHandler, opts ...HandlerOption) {
+	srv.handle(name, h, opts)
+}
+
+// HandleAsyncFunc registers a handler func for an asynchronous command.
+func (srv *Server) HandleAsyncFunc(name string, fn AsyncHandlerFunc, opts ...HandlerOption) {
+	srv.HandleAsync(name, fn, opts...)
+}
@@ -0,0 +1,200 @@
+package redeo
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/wangaoone/redeo/client"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AsyncHandler", func() {
+	var subject *Server
+	var lis net.Listener
+	var gate chan struct{}
+	var promises chan *Promise
+
+	var dial = func() client.Conn {
+		c, err := client.Dial(lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		return c
+	}
+
+	var serve = func(config *Config) {
+		subject = NewServer(config)
+		subject.Handle("ping", Ping())
+		subject.HandleAsyncFunc("wait", func(p *Promise, c *resp.Command) {
+			promises <- p
+			arg := c.Arg(0).String()
+			go func() {
+				select {
+				case <-gate:
+					p.Resolve(func(w resp.ResponseWriter) { w.AppendBulkString(arg) })
+				case <-p.Context().Done():
+				}
+			}()
+		}, Arity(2))
+		subject.HandleAsyncFunc("now", func(p *Promise, c *resp.Command) {
+			p.Resolve(func(w resp.ResponseWriter) { w.AppendBulkString(c.Arg(0).String()) })
+		}, Arity(2))
+		subject.HandleAsyncFunc("boom", func(p *Promise, c *resp.Command) {
+			panic("boom")
+		})
+		subject.HandleAsyncFunc("partial", func(p *Promise, c *resp.Command) {
+			p.Resolve(func(w resp.ResponseWriter) {
+				w.AppendArrayLen(2)
+				w.AppendInt(1)
+				panic("partial")
+			})
+		})
+		go subject.Serve(lis)
+	}
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		gate = make(chan struct{})
+		promises = make(chan *Promise, 10)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should reply in request order", func() {
+		serve(nil)
+
+		c := dial()
+		defer c.Close()
+
+		c.Append("WAIT", "a")
+		c.Append("NOW", "b")
+		c.Append("NOW")
+		c.Append("BOOM")
+		c.Append("PING")
+		c.Append("NOW", "c")
+		Expect(c.Flush()).To(Succeed())
+
+		Eventually(subject.Info().TotalCommands).Should(Equal(int64(4)))
+		Consistently(subject.Info().TotalCommands, "50ms").Should(Equal(int64(4)))
+		close(gate)
+
+		for _, exp := range []string{"a", "b"} {
+			r, err := c.Receive()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Str()).To(Equal(exp))
+		}
+
+		r, err := c.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("ERR wrong number of arguments for 'NOW' command"))
+
+		r, err = c.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("ERR internal error: boom"))
+		Expect(subject.Info().TotalPanics()).To(Equal(int64(1)))
+
+		for _, exp := range []string{"PONG", "c"} {
+			r, err := c.Receive()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Str()).To(Equal(exp))
+		}
+	})
+
+	It("should discard partial replies on panics", func() {
+		serve(nil)
+		c := dial()
+		defer c.Close()
+
+		c.Append("PARTIAL")
+		c.Append("PING")
+		Expect(c.Flush()).To(Succeed())
+
+		r, err := c.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("ERR internal error: partial"))
+
+		r, err = c.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("PONG"))
+	})
+
+	It("should time out promises", func() {
+		serve(&Config{AsyncTimeout: 20 * time.Millisecond})
+
+		c := dial()
+		defer c.Close()
+
+		r, err := c.Cmd("WAIT", "a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("ERR command timed out"))
+
+		var p *Promise
+		Expect(promises).To(Receive(&p))
+		Expect(p.Err()).To(Equal(ErrPromiseTimeout))
+		Expect(p.Resolve(func(w resp.ResponseWriter) { w.AppendOK() })).To(BeFalse())
+	})
+
+	It("should cancel promises on disconnect", func() {
+		serve(nil)
+
+		c := dial()
+		c.Append("WAIT", "a")
+		Expect(c.Flush()).To(Succeed())
+
+		var p *Promise
+		Eventually(promises).Should(Receive(&p))
+		Expect(c.Close()).To(Succeed())
+
+		Eventually(p.Done()).Should(BeClosed())
+		Expect(p.Err()).To(Equal(ErrPromiseCancelled))
+		Expect(p.Context().Err()).To(HaveOccurred())
+		Eventually(subject.Info().NumClients).Should(Equal(0))
+	})
+
+	It("should pause reading once the high-water mark is exceeded", func() {
+		serve(&Config{AsyncHighWaterMark: 100})
+
+		c := dial()
+		defer c.Close()
+
+		large := strings.Repeat("x", 60)
+		c.Append("WAIT", "a")
+		c.Append("NOW", large)
+		c.Append("NOW", large)
+		Expect(c.Flush()).To(Succeed())
+		Eventually(func() int64 {
+			stats := subject.Info().CommandStats()
+			for _, s := range stats {
+				if s.Name == "now" {
+					return s.Calls
+				}
+			}
+			return 0
+		}).Should(Equal(int64(2)))
+
+		for i := 0; i < 3; i++ {
+			c.Append("NOW", large)
+		}
+		Expect(c.Flush()).To(Succeed())
+		Eventually(subject.Info().TotalCommands).Should(Equal(int64(4)))
+		Consistently(subject.Info().TotalCommands, "50ms").Should(Equal(int64(4)))
+		close(gate)
+
+		r, err := c.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("a"))
+		for i := 0; i < 5; i++ {
+			r, err := c.Receive()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Str()).To(Equal(large))
+		}
+		Expect(subject.Info().TotalCommands()).To(Equal(int64(6)))
+	})
+
+})
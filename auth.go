@@ -0,0 +1,223 @@
+package redeo
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// Authorizer decides whether clients may execute commands, see
+// Config.Authorizer.
+type Authorizer interface {
+	// Authorize is called with the normalised command name and the
+	// arguments before a command is executed. Stream handlers are
+	// authorized without arguments. A non-nil error is sent as reply
+	// instead of executing the command.
+	Authorize(c *Client, name string, args []resp.CommandArgument) error
+}
+
+// AuthorizerFunc is a callback function, implementing Authorizer.
+type AuthorizerFunc func(c *Client, name string, args []resp.CommandArgument) error
+
+// Authorize calls f(c, name, args).
+func (f AuthorizerFunc) Authorize(c *Client, name string, args []resp.CommandArgument) error {
+	return f(c, name, args)
+}
+
+// CommandRules is a rule-based Authorizer, which restricts the commands
+// clients may execute by name.
+type CommandRules struct {
+	// Allow lists glob-style patterns of permitted commands. If empty,
+	// all commands not matching Deny are permitted.
+	Allow []string
+
+	// Deny lists glob-style patterns of forbidden commands. It takes
+	// precedence over Allow.
+	Deny []string
+}
+
+// Authorize implements Authorizer.
+func (r *CommandRules) Authorize(c *Client, name string, _ []resp.CommandArgument) error {
+	if r.permits(name) {
+		return nil
+	}
+	return errNoPerm(c, name)
+}
+
+func (r *CommandRules) permits(name string) bool {
+	for _, pattern := range r.Deny {
+		if MatchPattern(strings.ToLower(pattern), name) {
+			return false
+		}
+	}
+	if len(r.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range r.Allow {
+		if MatchPattern(strings.ToLower(pattern), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserRules is an Authorizer which applies CommandRules by the username
+// clients have authenticated with, see Client.User. Unauthenticated
+// clients are subject to the rules of the "default" user. Users without
+// rules may not execute any commands.
+type UserRules map[string]*CommandRules
+
+// Authorize implements Authorizer.
+func (r UserRules) Authorize(c *Client, name string, args []resp.CommandArgument) error {
+	if rules, ok := r[clientUser(c)]; ok {
+		return rules.Authorize(c, name, args)
+	}
+	return errNoPerm(c, name)
+}
+
+func clientUser(c *Client) string {
+	if user := c.User(); user != "" {
+		return user
+	}
+	return "default"
+}
+
+func errNoPerm(c *Client, name string) error {
+	return NewError("NOPERM", "User "+clientUser(c)+" has no permissions to run the '"+name+"' command")
+}
+
+// authorize checks the command against Config.ReadOnly and with
+// Config.KeyRouter and Config.Authorizer. Returns the error reply if the
+// command is denied.
+func (srv *Server) authorize(c *Client, norm string, args []resp.CommandArgument) error {
+	conf := srv.conf()
+	if conf.ReadOnly || conf.KeyRouter != nil {
+		if entry, ok := srv.lookup(norm); ok {
+			if conf.ReadOnly && entry.spec.hasFlag("write") {
+				return ErrReadOnly
+			}
+			if conf.KeyRouter != nil {
+				if keys := entry.spec.keys.Locate(args); len(keys) != 0 {
+					if err := conf.KeyRouter(c, norm, keys); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if a := conf.Authorizer; a != nil {
+		return a.Authorize(c, norm, args)
+	}
+	return nil
+}
+
+// authRequired returns true if clients must authenticate before
+// issuing commands.
+func (srv *Server) authRequired() bool {
+	return srv.conf().RequirePass != "" || srv.conf().AuthFunc != nil
+}
+
+// authenticate verifies the credentials.
+func (srv *Server) authenticate(username, password string) bool {
+	if fn := srv.conf().AuthFunc; fn != nil {
+		return fn(username, password)
+	}
+	return username == "default" &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(srv.conf().RequirePass)) == 1
+}
+
+// performAuth handles AUTH and HELLO with AUTH and rejects all other
+// commands, except QUIT, until the client is authenticated. Returns false
+// if the command is not subject to authentication handling.
+func (srv *Server) performAuth(c *Client, name, norm string) (bool, error) {
+	switch norm {
+	case "auth":
+		var err error
+		if c.cmd, err = c.readCmd(c.cmd); err != nil {
+			return true, err
+		}
+		srv.info.command(c.id, norm)
+
+		user := "default"
+		switch c.cmd.ArgN() {
+		case 1:
+			if !srv.authenticate(user, c.cmd.Arg(0).String()) {
+				c.wr.AppendError("ERR invalid password")
+				return true, nil
+			}
+		case 2:
+			user = c.cmd.Arg(0).String()
+			if !srv.authenticate(user, c.cmd.Arg(1).String()) {
+				c.wr.AppendError(errWrongPass)
+				return true, nil
+			}
+		default:
+			c.wr.AppendError(WrongNumberOfArgs(norm))
+			return true, nil
+		}
+		c.login(user)
+		c.wr.AppendOK()
+		return true, nil
+	case "hello":
+		return true, srv.performHello(c, name, norm)
+	case "quit":
+		return false, nil
+	}
+
+	if c.authed {
+		return false, nil
+	}
+	c.wr.AppendError(errNoAuth)
+	return true, c.rd.SkipCmd()
+}
+
+// performHello authenticates HELLO commands with the AUTH option before
+// passing them on to the registered handler.
+func (srv *Server) performHello(c *Client, name, norm string) error {
+	entry, ok := srv.lookup(norm)
+
+	if !ok {
+		srv.info.unknown.Inc(1)
+		c.wr.AppendError(UnknownCommand(name))
+		return c.rd.SkipCmd()
+	}
+
+	var err error
+	if c.cmd, err = c.readCmd(c.cmd); err != nil {
+		return err
+	}
+
+	// HELLO protover [AUTH username password] [SETNAME clientname]
+	user := ""
+	for i := 1; i < c.cmd.ArgN(); i++ {
+		if !strings.EqualFold(c.cmd.Arg(i).String(), "auth") {
+			continue
+		}
+		if i+2 >= c.cmd.ArgN() {
+			c.wr.AppendError("ERR Syntax error in HELLO option 'auth'")
+			return nil
+		}
+		if !srv.authenticate(c.cmd.Arg(i+1).String(), c.cmd.Arg(i+2).String()) {
+			c.wr.AppendError(errWrongPass)
+			return nil
+		}
+		user = c.cmd.Arg(i + 1).String()
+		break
+	}
+
+	if user != "" {
+		c.login(user)
+	} else if !c.authed {
+		c.wr.AppendError(errNoAuth)
+		return nil
+	}
+
+	srv.execCmd(c, norm, entry, c.cmd)
+	return nil
+}
+
+const (
+	errNoAuth    = "NOAUTH Authentication required."
+	errWrongPass = "WRONGPASS invalid username-password pair or user is disabled."
+)
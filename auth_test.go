@@ -0,0 +1,267 @@
+package redeo
+
+import (
+	"net"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authentication", func() {
+	var subject *Server
+
+	var serve = func(fn func(*resp.RequestWriter, resp.ResponseReader)) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go subject.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		fn(resp.NewRequestWriter(cn), resp.NewResponseReader(cn))
+	}
+
+	var readHello = func(cr resp.ResponseReader) int64 {
+		Expect(cr.ReadMapLen()).To(Equal(6))
+		Expect(cr.ReadBulkString()).To(Equal("server"))
+		Expect(cr.ReadBulkString()).To(Equal("redeo"))
+		Expect(cr.ReadBulkString()).To(Equal("proto"))
+		proto, err := cr.ReadInt()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cr.ReadBulkString()).To(Equal("id"))
+		Expect(cr.ReadInt()).To(BeNumerically(">", 0))
+		Expect(cr.ReadBulkString()).To(Equal("mode"))
+		Expect(cr.ReadBulkString()).To(Equal("standalone"))
+		Expect(cr.ReadBulkString()).To(Equal("role"))
+		Expect(cr.ReadBulkString()).To(Equal("master"))
+		Expect(cr.ReadBulkString()).To(Equal("modules"))
+		Expect(cr.ReadArrayLen()).To(Equal(0))
+		return proto
+	}
+
+	BeforeEach(func() {
+		subject = NewServer(&Config{RequirePass: "secret"})
+		subject.Handle("ping", Ping())
+		subject.Handle("quit", Quit())
+		subject.Handle("hello", Hello())
+		subject.HandleStreamFunc("len", func(w resp.ResponseWriter, c *resp.CommandStream) {
+			w.AppendInt(int64(c.ArgN()))
+		})
+	})
+
+	It("should reject commands until authenticated", func() {
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmdString("PING", "a")
+			cw.WriteCmdString("LEN", "a", "b")
+			cw.WriteCmdString("AUTH", "wrong")
+			cw.WriteCmdString("AUTH", "default", "wrong")
+			cw.WriteCmdString("PING")
+			cw.WriteCmdString("AUTH", "secret")
+			cw.WriteCmdString("PING", "b")
+			cw.WriteCmdString("LEN", "a", "b")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadError()).To(Equal("NOAUTH Authentication required."))
+			Expect(cr.ReadError()).To(Equal("NOAUTH Authentication required."))
+			Expect(cr.ReadError()).To(Equal("ERR invalid password"))
+			Expect(cr.ReadError()).To(Equal("WRONGPASS invalid username-password pair or user is disabled."))
+			Expect(cr.ReadError()).To(Equal("NOAUTH Authentication required."))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadBulkString()).To(Equal("b"))
+			Expect(cr.ReadInt()).To(Equal(int64(2)))
+		})
+	})
+
+	It("should allow QUIT without authentication", func() {
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("QUIT")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+		})
+	})
+
+	It("should authenticate via HELLO", func() {
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmdString("HELLO", "3")
+			cw.WriteCmdString("HELLO", "3", "AUTH", "default", "wrong")
+			cw.WriteCmdString("HELLO", "3", "AUTH", "default")
+			cw.WriteCmdString("HELLO", "3", "auth", "default", "secret")
+			cw.WriteCmdString("PING")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadError()).To(Equal("NOAUTH Authentication required."))
+			Expect(cr.ReadError()).To(Equal("WRONGPASS invalid username-password pair or user is disabled."))
+			Expect(cr.ReadError()).To(Equal("ERR Syntax error in HELLO option 'auth'"))
+			Expect(readHello(cr)).To(Equal(int64(3)))
+			Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		})
+	})
+
+	It("should support custom credential checks", func() {
+		subject.config.AuthFunc = func(username, password string) bool {
+			return username == "alice" && password == "pass"
+		}
+
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmdString("AUTH", "secret")
+			cw.WriteCmdString("AUTH", "alice", "pass")
+			cw.WriteCmdString("PING")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadError()).To(Equal("ERR invalid password"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		})
+	})
+
+	It("should expose the authenticated user to handlers", func() {
+		subject.config.AuthFunc = func(username, password string) bool {
+			return password == "pass"
+		}
+		subject.HandleFunc("whoami", func(w resp.ResponseWriter, c *resp.Command) {
+			cl := GetClient(c.Context())
+			w.AppendArrayLen(2)
+			w.AppendBulkString(cl.User())
+			if cl.Authenticated() {
+				w.AppendInt(1)
+			} else {
+				w.AppendInt(0)
+			}
+		})
+
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmdString("AUTH", "alice", "pass")
+			cw.WriteCmdString("WHOAMI")
+			cw.WriteCmdString("HELLO", "3", "AUTH", "bob", "pass")
+			cw.WriteCmdString("WHOAMI")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadArrayLen()).To(Equal(2))
+			Expect(cr.ReadBulkString()).To(Equal("alice"))
+			Expect(cr.ReadInt()).To(Equal(int64(1)))
+
+			Expect(readHello(cr)).To(Equal(int64(3)))
+			Expect(cr.ReadArrayLen()).To(Equal(2))
+			Expect(cr.ReadBulkString()).To(Equal("bob"))
+			Expect(cr.ReadInt()).To(Equal(int64(1)))
+		})
+	})
+
+})
+
+var _ = Describe("Authorizer", func() {
+	var subject *Server
+
+	BeforeEach(func() {
+		subject = NewServer(&Config{
+			AuthFunc: func(username, password string) bool { return password == "pass" },
+			Authorizer: UserRules{
+				"default": {Allow: []string{"ping", "auth"}},
+				"admin":   {Deny: []string{"flush*"}},
+			},
+		})
+		subject.Handle("ping", Ping())
+		subject.HandleFunc("flushall", func(w resp.ResponseWriter, _ *resp.Command) { w.AppendOK() })
+		subject.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) { w.AppendBulk(c.Arg(0)) })
+		subject.HandleAsyncFunc("aget", func(p *Promise, c *resp.Command) {
+			key := c.Arg(0).String()
+			p.Resolve(func(w resp.ResponseWriter) { w.AppendBulkString(key) })
+		})
+	})
+
+	It("should deny commands by user", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go subject.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmdString("AUTH", "default", "pass")
+		cw.WriteCmdString("PING")
+		cw.WriteCmdString("GET", "key")
+		cw.WriteCmdString("AGET", "key")
+		cw.WriteCmdString("AUTH", "admin", "pass")
+		cw.WriteCmdString("GET", "key")
+		cw.WriteCmdString("AGET", "key")
+		cw.WriteCmdString("FLUSHALL")
+		Expect(cw.Flush()).To(Succeed())
+
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		Expect(cr.ReadError()).To(Equal("NOPERM User default has no permissions to run the 'get' command"))
+		Expect(cr.ReadError()).To(Equal("NOPERM User default has no permissions to run the 'aget' command"))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadBulkString()).To(Equal("key"))
+		Expect(cr.ReadBulkString()).To(Equal("key"))
+		Expect(cr.ReadError()).To(Equal("NOPERM User admin has no permissions to run the 'flushall' command"))
+	})
+
+})
+
+var _ = Describe("Read-only mode", func() {
+	var subject *Server
+
+	BeforeEach(func() {
+		subject = NewServer(&Config{ReadOnly: true})
+		subject.Handle("ping", Ping())
+		subject.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) { w.AppendBulk(c.Arg(0)) }, Flags("readonly"))
+		subject.HandleFunc("set", func(w resp.ResponseWriter, _ *resp.Command) { w.AppendOK() }, Flags("write"))
+		subject.HandleAsyncFunc("aset", func(p *Promise, _ *resp.Command) {
+			p.Resolve(func(w resp.ResponseWriter) { w.AppendOK() })
+		}, Flags("write"))
+	})
+
+	It("should reject writes", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go subject.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmdString("PING")
+		cw.WriteCmdString("GET", "key")
+		cw.WriteCmdString("SET", "key", "val")
+		cw.WriteCmdString("ASET", "key", "val")
+		Expect(cw.Flush()).To(Succeed())
+
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		Expect(cr.ReadBulkString()).To(Equal("key"))
+		Expect(cr.ReadError()).To(Equal("READONLY You can't write against a read only replica."))
+		Expect(cr.ReadError()).To(Equal("READONLY You can't write against a read only replica."))
+
+		subject.SetReadOnly(false)
+		Expect(subject.GetConfig("replica-read-only")).To(HaveKeyWithValue("replica-read-only", "no"))
+		cw.WriteCmdString("SET", "key", "val")
+		cw.WriteCmdString("ASET", "key", "val")
+		Expect(cw.Flush()).To(Succeed())
+
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+	})
+
+})
+
+var _ = DescribeTable("CommandRules",
+	func(rules CommandRules, name string, exp bool) {
+		Expect(rules.permits(name)).To(Equal(exp))
+	},
+
+	Entry("no rules", CommandRules{}, "get", true),
+	Entry("allowed", CommandRules{Allow: []string{"get", "h*"}}, "hget", true),
+	Entry("not allowed", CommandRules{Allow: []string{"get", "h*"}}, "set", false),
+	Entry("denied", CommandRules{Deny: []string{"FLUSH*"}}, "flushdb", false),
+	Entry("deny precedence", CommandRules{Allow: []string{"*"}, Deny: []string{"debug"}}, "debug", false),
+)
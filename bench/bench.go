@@ -0,0 +1,353 @@
+// Package bench implements a RESP load generator, similar to
+// redis-benchmark, for benchmarking handlers and server configurations.
+package bench
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// Placeholders substituted in command arguments.
+const (
+	// Key is replaced by a random key of Config.Keyspace.
+	Key = "__key__"
+	// Payload is replaced by a value of Config.PayloadSize bytes.
+	Payload = "__data__"
+)
+
+// Command is an entry of the command mix.
+type Command struct {
+	Name string
+	Args []string
+
+	// Weight is the relative frequency of the command in the mix.
+	// Default: 1
+	Weight int
+}
+
+// Config configures a benchmark run.
+type Config struct {
+	// Addr is the TCP address of the server.
+	// Default: 127.0.0.1:6379
+	Addr string
+
+	// Dial opens connections, it takes precedence over Addr.
+	Dial func() (net.Conn, error)
+
+	// Connections is the number of parallel connections.
+	// Default: 50
+	Connections int
+
+	// Pipeline is the number of commands sent per round trip.
+	// Default: 1
+	Pipeline int
+
+	// Requests is the total number of commands to send.
+	// Default: 100000, unless Duration is set
+	Requests int
+
+	// Duration limits the run to a period of time.
+	Duration time.Duration
+
+	// Commands is the command mix.
+	// Default: PING
+	Commands []Command
+
+	// PayloadSize is the size of the Payload placeholder.
+	// Default: 3
+	PayloadSize int
+
+	// Keyspace is the number of distinct keys of the Key placeholder,
+	// which are named "key:<n>".
+	// Default: 1, i.e. "key:0" only
+	Keyspace int
+
+	// Seed seeds the random choice of commands and keys.
+	Seed int64
+}
+
+func (c *Config) norm() *Config {
+	conf := *c
+	if conf.Addr == "" {
+		conf.Addr = "127.0.0.1:6379"
+	}
+	if conf.Dial == nil {
+		addr := conf.Addr
+		conf.Dial = func() (net.Conn, error) { return net.Dial("tcp", addr) }
+	}
+	if conf.Connections < 1 {
+		conf.Connections = 50
+	}
+	if conf.Pipeline < 1 {
+		conf.Pipeline = 1
+	}
+	if conf.Requests < 1 && conf.Duration <= 0 {
+		conf.Requests = 100000
+	}
+	if len(conf.Commands) == 0 {
+		conf.Commands = []Command{{Name: "PING"}}
+	}
+	if conf.PayloadSize < 1 {
+		conf.PayloadSize = 3
+	}
+	if conf.Keyspace < 1 {
+		conf.Keyspace = 1
+	}
+	return &conf
+}
+
+// Run runs a benchmark and reports the latencies of the commands. The
+// run is aborted on network errors. Error replies are counted, see
+// Report.Errors. If ctx is cancelled, the commands completed so far are
+// reported along with the error of ctx.
+func Run(ctx context.Context, conf *Config) (*Report, error) {
+	conf = conf.norm()
+
+	mix, err := newCommandMix(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	runCtx := ctx
+	if conf.Duration > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, conf.Duration)
+		defer cancel()
+	}
+
+	// dial first, so connecting does not count towards latencies
+	conns := make([]net.Conn, 0, conf.Connections)
+	defer func() {
+		for _, cn := range conns {
+			_ = cn.Close()
+		}
+	}()
+	for i := 0; i < conf.Connections; i++ {
+		cn, err := conf.Dial()
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, cn)
+	}
+
+	r := &run{conf: conf, mix: mix, ctx: runCtx, unlimited: conf.Requests < 1, remaining: int64(conf.Requests)}
+
+	var wg sync.WaitGroup
+	workers := make([]*worker, len(conns))
+	start := time.Now()
+	for i, cn := range conns {
+		w := &worker{
+			run:  r,
+			rnd:  rand.New(rand.NewSource(conf.Seed + int64(i))),
+			rw:   resp.NewRequestWriter(cn),
+			rr:   resp.NewResponseReader(cn),
+			stat: newReport(),
+		}
+		workers[i] = w
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop()
+		}()
+	}
+
+	// unblock workers waiting for replies on cancellation
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-runCtx.Done():
+			for _, cn := range conns {
+				_ = cn.SetDeadline(time.Now())
+			}
+		case <-stop:
+		}
+	}()
+	wg.Wait()
+	close(stop)
+
+	rep := newReport()
+	rep.Elapsed = time.Since(start)
+	for _, w := range workers {
+		rep.merge(w.stat)
+	}
+	rep.finish()
+
+	if err := r.failure(); err != nil {
+		return rep, err
+	}
+	return rep, ctx.Err()
+}
+
+// --------------------------------------------------------------------
+
+type run struct {
+	conf      *Config
+	mix       *commandMix
+	ctx       context.Context
+	unlimited bool
+	remaining int64 // accessed atomically
+
+	err error
+	mu  sync.Mutex
+}
+
+// claim claims up to n requests, it returns 0 once the run is complete.
+func (r *run) claim(n int) int {
+	if r.ctx.Err() != nil || r.failure() != nil {
+		return 0
+	}
+	if r.unlimited {
+		return n
+	}
+
+	for {
+		left := atomic.LoadInt64(&r.remaining)
+		if left <= 0 {
+			return 0
+		}
+		if m := int64(n); left < m {
+			n = int(left)
+		}
+		if atomic.CompareAndSwapInt64(&r.remaining, left, left-int64(n)) {
+			return n
+		}
+	}
+}
+
+func (r *run) fail(err error) {
+	// errors after cancellation are caused by the deadline
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.mu.Unlock()
+}
+
+func (r *run) failure() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+type worker struct {
+	*run
+	rnd  *rand.Rand
+	rw   *resp.RequestWriter
+	rr   resp.ResponseReader
+	stat *Report
+	cmds []*mixEntry
+}
+
+func (w *worker) loop() {
+	for {
+		n := w.claim(w.conf.Pipeline)
+		if n == 0 {
+			return
+		}
+
+		w.cmds = w.cmds[:0]
+		for i := 0; i < n; i++ {
+			cmd := w.mix.pick(w.rnd)
+			w.cmds = append(w.cmds, cmd)
+			w.rw.WriteCmdString(cmd.Name, cmd.args(w.rnd, w.mix)...)
+		}
+
+		start := time.Now()
+		if err := w.rw.Flush(); err != nil {
+			w.fail(err)
+			return
+		}
+		for _, cmd := range w.cmds {
+			reply, err := resp.ReadReply(w.rr)
+			if err != nil {
+				w.fail(err)
+				return
+			}
+			w.stat.add(cmd.Name, time.Since(start), reply.Err() != nil)
+		}
+	}
+}
+
+// --------------------------------------------------------------------
+
+type mixEntry struct {
+	Command
+	dynamic bool // true if arguments contain placeholders
+}
+
+func (e *mixEntry) args(rnd *rand.Rand, m *commandMix) []string {
+	if !e.dynamic {
+		return e.Args
+	}
+
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		if strings.Contains(arg, Key) {
+			arg = strings.Replace(arg, Key, "key:"+strconv.Itoa(rnd.Intn(m.keyspace)), -1)
+		}
+		args[i] = strings.Replace(arg, Payload, m.payload, -1)
+	}
+	return args
+}
+
+type commandMix struct {
+	entries  []*mixEntry
+	weights  []int // cumulative
+	total    int
+	keyspace int
+	payload  string
+}
+
+func newCommandMix(conf *Config) (*commandMix, error) {
+	m := &commandMix{
+		keyspace: conf.Keyspace,
+		payload:  strings.Repeat("x", conf.PayloadSize),
+	}
+	for _, cmd := range conf.Commands {
+		if cmd.Name == "" {
+			return nil, errors.New("bench: command name is empty")
+		}
+
+		weight := cmd.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		m.total += weight
+
+		e := &mixEntry{Command: cmd}
+		for _, arg := range cmd.Args {
+			e.dynamic = e.dynamic || strings.Contains(arg, Key) || strings.Contains(arg, Payload)
+		}
+		m.entries = append(m.entries, e)
+		m.weights = append(m.weights, m.total)
+	}
+	return m, nil
+}
+
+func (m *commandMix) pick(rnd *rand.Rand) *mixEntry {
+	if len(m.entries) == 1 {
+		return m.entries[0]
+	}
+
+	n := rnd.Intn(m.total)
+	for i, w := range m.weights {
+		if n < w {
+			return m.entries[i]
+		}
+	}
+	return m.entries[len(m.entries)-1]
+}
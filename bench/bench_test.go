@@ -0,0 +1,204 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wangaoone/redeo"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run", func() {
+	var lis net.Listener
+
+	BeforeEach(func() {
+		var err error
+		lis, err = serve(&redeo.Config{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+		testData.Range(func(key, _ interface{}) bool {
+			testData.Delete(key)
+			return true
+		})
+	})
+
+	It("should send commands", func() {
+		rep, err := Run(context.Background(), &Config{Addr: lis.Addr().String(), Connections: 4, Requests: 1000})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rep.Requests).To(Equal(int64(1000)))
+		Expect(rep.Errors).To(Equal(int64(0)))
+		Expect(rep.Commands).To(HaveKey("PING"))
+		Expect(rep.Throughput()).To(BeNumerically(">", 0))
+		Expect(rep.Min()).To(BeNumerically("<=", rep.Percentile(50)))
+		Expect(rep.Percentile(50)).To(BeNumerically("<=", rep.Percentile(99)))
+		Expect(rep.Percentile(99)).To(BeNumerically("<=", rep.Max()))
+		Expect(rep.String()).To(HavePrefix("total: 1000 requests, 0 errors, "))
+	})
+
+	It("should send command mixes", func() {
+		rep, err := Run(context.Background(), &Config{
+			Addr:        lis.Addr().String(),
+			Connections: 3,
+			Pipeline:    16,
+			Requests:    1001,
+			PayloadSize: 8,
+			Keyspace:    10,
+			Commands: []Command{
+				{Name: "SET", Args: []string{Key, Payload}},
+				{Name: "GET", Args: []string{Key}, Weight: 3},
+				{Name: "FAIL"},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rep.Requests).To(Equal(int64(1001)))
+		Expect(rep.Commands).To(HaveLen(3))
+		Expect(rep.Commands["SET"].Requests + rep.Commands["GET"].Requests + rep.Commands["FAIL"].Requests).To(Equal(int64(1001)))
+		Expect(rep.Commands["GET"].Requests).To(BeNumerically(">", rep.Commands["SET"].Requests))
+		Expect(rep.Errors).To(Equal(rep.Commands["FAIL"].Requests))
+		Expect(rep.String()).To(ContainSubstring("\nFAIL: "))
+
+		n := 0
+		testData.Range(func(key, val interface{}) bool {
+			Expect(key).To(HavePrefix("key:"))
+			Expect(val).To(Equal("xxxxxxxx"))
+			n++
+			return true
+		})
+		Expect(n).To(BeNumerically("~", 10, 9))
+	})
+
+	It("should run for a duration", func() {
+		start := time.Now()
+		rep, err := Run(context.Background(), &Config{Addr: lis.Addr().String(), Connections: 2, Duration: 50 * time.Millisecond})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(rep.Requests).To(BeNumerically(">", 0))
+	})
+
+	It("should stop on cancellation", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		rep, err := Run(ctx, &Config{
+			Addr:        lis.Addr().String(),
+			Connections: 2,
+			Requests:    100,
+			Commands:    []Command{{Name: "SLEEP"}},
+		})
+		Expect(err).To(Equal(context.Canceled))
+		Expect(rep.Requests).To(BeNumerically("<", 100))
+	})
+
+	It("should fail on network errors", func() {
+		_, err := Run(context.Background(), &Config{Dial: func() (net.Conn, error) {
+			return nil, fmt.Errorf("dial failed")
+		}})
+		Expect(err).To(MatchError("dial failed"))
+
+		_, err = Run(context.Background(), &Config{Addr: lis.Addr().String(), Commands: []Command{{Name: "QUIT"}}})
+		Expect(err).To(HaveOccurred())
+
+		_, err = Run(context.Background(), &Config{Addr: lis.Addr().String(), Commands: []Command{{}}})
+		Expect(err).To(MatchError("bench: command name is empty"))
+	})
+
+})
+
+var _ = Describe("Report", func() {
+
+	It("should calculate percentiles", func() {
+		rep := newReport()
+		for i := 100; i > 0; i-- {
+			rep.add("GET", time.Duration(i)*time.Millisecond, i%10 == 0)
+		}
+		rep.Elapsed = 2 * time.Second
+		rep.finish()
+
+		Expect(rep.Requests).To(Equal(int64(100)))
+		Expect(rep.Errors).To(Equal(int64(10)))
+		Expect(rep.Throughput()).To(Equal(50.0))
+		Expect(rep.Min()).To(Equal(time.Millisecond))
+		Expect(rep.Percentile(50)).To(Equal(50 * time.Millisecond))
+		Expect(rep.Percentile(99)).To(Equal(99 * time.Millisecond))
+		Expect(rep.Max()).To(Equal(100 * time.Millisecond))
+		Expect(rep.Mean()).To(Equal(50500 * time.Microsecond))
+		Expect(rep.Commands["GET"].Percentile(90)).To(Equal(90 * time.Millisecond))
+		Expect(strings.Count(rep.String(), "\n")).To(Equal(1))
+	})
+
+})
+
+// --------------------------------------------------------------------
+
+var testData sync.Map
+
+func serve(conf *redeo.Config) (net.Listener, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	srv := redeo.NewServer(conf)
+	srv.Handle("ping", redeo.Ping())
+	srv.Handle("quit", redeo.Quit())
+	srv.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+		testData.Store(c.Arg(0).String(), c.Arg(1).String())
+		w.AppendOK()
+	})
+	srv.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+		if val, ok := testData.Load(c.Arg(0).String()); ok {
+			w.AppendBulkString(val.(string))
+			return
+		}
+		w.AppendNil()
+	})
+	srv.HandleFunc("fail", func(w resp.ResponseWriter, c *resp.Command) {
+		w.AppendError("ERR failed")
+	})
+	srv.HandleFunc("sleep", func(w resp.ResponseWriter, c *resp.Command) {
+		time.Sleep(10 * time.Millisecond)
+		w.AppendOK()
+	})
+
+	go srv.Serve(lis)
+	return lis, nil
+}
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "redeo/bench")
+}
+
+func BenchmarkServer(b *testing.B) {
+	for _, workers := range []int{0, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			lis, err := serve(&redeo.Config{Workers: workers})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer lis.Close()
+
+			b.ResetTimer()
+			rep, err := Run(context.Background(), &Config{
+				Addr:        lis.Addr().String(),
+				Connections: 16,
+				Pipeline:    8,
+				Requests:    b.N,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(rep.Percentile(99).Microseconds()), "p99-µs")
+		})
+	}
+}
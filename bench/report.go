@@ -0,0 +1,139 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report holds the results of a run, or of a command of the mix.
+type Report struct {
+	// Requests is the number of completed commands.
+	Requests int64
+	// Errors is the number of error replies.
+	Errors int64
+	// Elapsed is the duration of the run.
+	Elapsed time.Duration
+	// Commands holds the reports by command name, reports of runs only.
+	Commands map[string]*Report
+
+	latencies []time.Duration // sorted once finished
+}
+
+func newReport() *Report {
+	return &Report{Commands: make(map[string]*Report)}
+}
+
+// Throughput returns the number of commands per second.
+func (r *Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// Percentile returns the latency percentile p, e.g. 99 for the latency
+// which 99% of the commands did not exceed.
+func (r *Report) Percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+
+	i := int(math.Ceil(p/100*float64(len(r.latencies)))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(r.latencies) {
+		i = len(r.latencies) - 1
+	}
+	return r.latencies[i]
+}
+
+// Min returns the lowest latency.
+func (r *Report) Min() time.Duration { return r.Percentile(0) }
+
+// Max returns the highest latency.
+func (r *Report) Max() time.Duration { return r.Percentile(100) }
+
+// Mean returns the average latency.
+func (r *Report) Mean() time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+
+	var sum time.Duration
+	for _, d := range r.latencies {
+		sum += d
+	}
+	return sum / time.Duration(len(r.latencies))
+}
+
+// String returns a summary of the report.
+func (r *Report) String() string {
+	var sb strings.Builder
+	r.summarize(&sb, "total")
+
+	names := make([]string, 0, len(r.Commands))
+	for name := range r.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) > 1 {
+		for _, name := range names {
+			r.Commands[name].summarize(&sb, name)
+		}
+	}
+	return sb.String()
+}
+
+func (r *Report) summarize(sb *strings.Builder, name string) {
+	fmt.Fprintf(sb, "%s: %d requests, %d errors, %.0f requests/s, latency min=%s mean=%s p50=%s p90=%s p99=%s max=%s\n",
+		name, r.Requests, r.Errors, r.Throughput(),
+		r.Min(), r.Mean(), r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Max())
+}
+
+func (r *Report) add(name string, latency time.Duration, failed bool) {
+	r.record(latency, failed)
+
+	cmd, ok := r.Commands[name]
+	if !ok {
+		cmd = new(Report)
+		r.Commands[name] = cmd
+	}
+	cmd.record(latency, failed)
+}
+
+func (r *Report) record(latency time.Duration, failed bool) {
+	r.Requests++
+	if failed {
+		r.Errors++
+	}
+	r.latencies = append(r.latencies, latency)
+}
+
+// merge adds the results of o.
+func (r *Report) merge(o *Report) {
+	r.Requests += o.Requests
+	r.Errors += o.Errors
+	r.latencies = append(r.latencies, o.latencies...)
+
+	for name, oc := range o.Commands {
+		cmd, ok := r.Commands[name]
+		if !ok {
+			cmd = new(Report)
+			r.Commands[name] = cmd
+		}
+		cmd.merge(oc)
+	}
+}
+
+// finish sorts the latencies and sets the elapsed time of commands.
+func (r *Report) finish() {
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	for _, cmd := range r.Commands {
+		cmd.Elapsed = r.Elapsed
+		cmd.finish()
+	}
+}
@@ -0,0 +1,293 @@
+package redeo
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+var (
+	// ErrBlockTimeout is returned by Blocker.Err when the timeout has expired.
+	ErrBlockTimeout = errors.New("redeo: block timed out")
+
+	// ErrBlockCancelled is returned by Blocker.Err when the client has
+	// disconnected or was closed while blocked.
+	ErrBlockCancelled = errors.New("redeo: block cancelled")
+)
+
+// Blocker suspends a client until it is resolved, see Client.Block.
+type Blocker struct {
+	timeout time.Duration
+	fn      func(resp.ResponseWriter)
+	err     error
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+// Resolve writes the reply via fn, flushes it and resumes the client.
+// It may be called from any goroutine. Returns false if the blocker has
+// already been resolved, has timed out or was cancelled.
+func (b *Blocker) Resolve(fn func(w resp.ResponseWriter)) bool {
+	return b.finish(fn, nil)
+}
+
+// Done returns a channel which is closed once the blocker is resolved,
+// has timed out or was cancelled.
+func (b *Blocker) Done() <-chan struct{} { return b.done }
+
+// Err returns nil if the blocker was resolved, ErrBlockTimeout or
+// ErrBlockCancelled. It must only be called once Done is closed.
+func (b *Blocker) Err() error {
+	b.mu.Lock()
+	err := b.err
+	b.mu.Unlock()
+	return err
+}
+
+func (b *Blocker) finish(fn func(resp.ResponseWriter), err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return false
+	default:
+	}
+	b.fn, b.err = fn, err
+	close(b.done)
+	return true
+}
+
+// --------------------------------------------------------------------
+
+// Block suspends the client once the current handler has returned. No
+// further commands are read until the returned blocker is resolved or the
+// timeout expires, in which case a nil reply is sent. A zero timeout
+// blocks indefinitely. Handlers must not reply to blocked commands
+// directly and may only block their own client. Within transactions,
+// blocked commands time out immediately. Commands dispatched to shard
+// workers must not block. See KeyWaiters to resume clients blocked on keys.
+func (c *Client) Block(timeout time.Duration) *Blocker {
+	b := &Blocker{timeout: timeout, done: make(chan struct{})}
+	c.blocker = b
+	return b
+}
+
+// cancelBlock cancels the blocker the client is waiting for, if any.
+// Must be called with the lock held.
+func (c *Client) cancelBlock() {
+	if c.blocked != nil {
+		c.blocked.finish(nil, ErrBlockCancelled)
+	}
+}
+
+// await suspends the client until the blocker set by the handler is done.
+func (srv *Server) await(c *Client) error {
+	b := c.blocker
+	c.blocker = nil
+
+	// send the replies to the preceding commands
+	if err := c.flush(); err != nil {
+		b.finish(nil, ErrBlockCancelled)
+		return err
+	}
+
+	c.mu.Lock()
+	closed := c.closed
+	c.blocked = b
+	c.mu.Unlock()
+	if closed {
+		b.finish(nil, ErrBlockCancelled)
+	}
+	defer func() {
+		c.mu.Lock()
+		c.blocked = nil
+		c.mu.Unlock()
+	}()
+
+	srv.info.blocked.Inc(1)
+	defer srv.info.blocked.Inc(-1)
+
+	// watch for disconnects, unless further commands are buffered
+	var watch chan error
+	if c.rd.Buffered() == 0 {
+		watch = make(chan error, 1)
+		_ = c.cn.SetReadDeadline(time.Time{})
+		c.mc.active = false // blocked clients do not time out
+		go func() {
+			_, err := c.rd.PeekCmd()
+			watch <- err
+		}()
+	}
+
+	var expired <-chan time.Time
+	if b.timeout > 0 {
+		timer := time.NewTimer(b.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	// blocked clients do not occupy a worker
+	srv.workers.release(c)
+
+	var err error
+	for waiting := true; waiting; {
+		select {
+		case <-b.done:
+			waiting = false
+		case <-expired:
+			b.finish(nil, ErrBlockTimeout)
+		case err = <-watch:
+			watch = nil
+			if err != nil && !resp.IsProtocolError(err) && !isTimeout(err) {
+				b.finish(nil, ErrBlockCancelled)
+			} else {
+				err = nil
+			}
+		}
+	}
+
+	// stop watching
+	if watch != nil {
+		_ = c.cn.SetReadDeadline(time.Now())
+		<-watch
+	}
+	c.mc.active = true
+	srv.workers.acquire(c)
+	if err != nil {
+		return err
+	}
+	srv.resumeDeadline(c)
+
+	switch b.Err() {
+	case nil:
+		b.fn(&c.rw)
+	case ErrBlockTimeout:
+		c.rw.AppendNil()
+	}
+	return nil
+}
+
+// resumeDeadline applies the read deadline after the client was blocked.
+func (srv *Server) resumeDeadline(c *Client) {
+	if d := srv.conf().Timeout; d > 0 {
+		_ = c.cn.SetDeadline(time.Now().Add(d))
+	} else {
+		_ = c.cn.SetReadDeadline(time.Time{})
+	}
+}
+
+// --------------------------------------------------------------------
+
+// WakeFunc is called with a key once it was signalled via
+// KeyWaiters.Notify. It returns the reply of the woken command, e.g. the
+// element popped by BLPOP, or false if the key has nothing to offer.
+type WakeFunc func(key string) (func(resp.ResponseWriter), bool)
+
+// KeyWaiters parks blocked clients by key, so commands that modify keys
+// can resume them, e.g. LPUSH resuming clients blocked by BLPOP.
+type KeyWaiters struct {
+	keys map[string][]*keyWaiter
+	mu   sync.Mutex
+}
+
+type keyWaiter struct {
+	blocker *Blocker
+	wake    WakeFunc
+	keys    []string
+}
+
+// NewKeyWaiters inits a new waiter registry.
+func NewKeyWaiters() *KeyWaiters {
+	return &KeyWaiters{keys: make(map[string][]*keyWaiter)}
+}
+
+// Wait parks b on keys until it is woken by Notify, times out or is
+// cancelled. Waiters of a key are woken in the order they were parked.
+func (q *KeyWaiters) Wait(b *Blocker, wake WakeFunc, keys ...string) {
+	w := &keyWaiter{blocker: b, wake: wake, keys: keys}
+
+	q.mu.Lock()
+	for _, key := range keys {
+		q.keys[key] = append(q.keys[key], w)
+	}
+	q.mu.Unlock()
+
+	go func() {
+		<-b.Done()
+		q.mu.Lock()
+		q.remove(w)
+		q.mu.Unlock()
+	}()
+}
+
+// Notify signals that key was modified. Waiters are woken in order until
+// one of their WakeFunc returns false. Returns the number of woken waiters.
+func (q *KeyWaiters) Notify(key string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := 0
+	for len(q.keys[key]) != 0 {
+		w := q.keys[key][0]
+		done, woken := w.blocker.wake(key, w.wake)
+		if !done {
+			break
+		}
+		q.remove(w)
+		if woken {
+			n++
+		}
+	}
+	return n
+}
+
+// Len returns the number of waiters parked on key.
+func (q *KeyWaiters) Len(key string) int {
+	q.mu.Lock()
+	n := len(q.keys[key])
+	q.mu.Unlock()
+	return n
+}
+
+// remove removes w from all its keys. Must be called with the lock held.
+func (q *KeyWaiters) remove(w *keyWaiter) {
+	for _, key := range w.keys {
+		list := q.keys[key]
+		for i, x := range list {
+			if x == w {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(q.keys, key)
+		} else {
+			q.keys[key] = list
+		}
+	}
+}
+
+// wake resolves b with the reply returned by fn, unless b is already done.
+// The blocker is locked while fn is called, so the reply is never lost
+// to a concurrent timeout. Returns true as done if b is done, afterwards.
+func (b *Blocker) wake(key string, fn WakeFunc) (done, woken bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return true, false
+	default:
+	}
+
+	reply, ok := fn(key)
+	if !ok {
+		return false, false
+	}
+	b.fn = reply
+	close(b.done)
+	return true, true
+}
@@ -0,0 +1,219 @@
+package redeo
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Blocker", func() {
+	var subject *Server
+	var lis net.Listener
+	var blockers chan *Blocker
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		blockers = make(chan *Blocker, 1)
+
+		subject = NewServer(nil)
+		subject.Handle("ping", Ping())
+		subject.HandleFunc("wait", func(w resp.ResponseWriter, c *resp.Command) {
+			ms, err := strconv.Atoi(c.Arg(0).String())
+			if err != nil {
+				w.AppendError("ERR timeout is not an integer or out of range")
+				return
+			}
+			blockers <- GetClient(c.Context()).Block(time.Duration(ms) * time.Millisecond)
+		}, Arity(2))
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should suspend clients until resolved", func() {
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmd("PING")
+		cw.WriteCmdString("WAIT", "0")
+		cw.WriteCmd("PING")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+
+		var b *Blocker
+		Eventually(blockers).Should(Receive(&b))
+		Eventually(subject.Info().NumBlocked).Should(Equal(1))
+		Expect(subject.Info().String()).To(ContainSubstring("blocked_clients:1\n"))
+
+		Expect(b.Resolve(func(w resp.ResponseWriter) { w.AppendBulkString("done") })).To(BeTrue())
+		Expect(b.Resolve(func(w resp.ResponseWriter) { w.AppendBulkString("again") })).To(BeFalse())
+		Expect(cr.ReadBulkString()).To(Equal("done"))
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		Expect(b.Err()).NotTo(HaveOccurred())
+		Eventually(subject.Info().NumBlocked).Should(Equal(0))
+	})
+
+	It("should reply with nil on timeout", func() {
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmdString("WAIT", "20")
+		cw.WriteCmd("PING")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadNil()).To(Succeed())
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+
+		var b *Blocker
+		Expect(blockers).To(Receive(&b))
+		Expect(b.Done()).To(BeClosed())
+		Expect(b.Err()).To(Equal(ErrBlockTimeout))
+		Expect(b.Resolve(func(w resp.ResponseWriter) {})).To(BeFalse())
+	})
+
+	It("should cancel on disconnect", func() {
+		cn, cw, _ := dialRaw(lis)
+		cw.WriteCmdString("WAIT", "0")
+		Expect(cw.Flush()).To(Succeed())
+
+		var b *Blocker
+		Eventually(blockers).Should(Receive(&b))
+		Expect(cn.Close()).To(Succeed())
+
+		Eventually(b.Done()).Should(BeClosed())
+		Expect(b.Err()).To(Equal(ErrBlockCancelled))
+		Eventually(subject.Info().NumBlocked).Should(Equal(0))
+		Eventually(subject.Info().NumClients).Should(Equal(0))
+	})
+
+	It("should cancel when closed by the server", func() {
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmdString("WAIT", "0")
+		cw.WriteCmd("PING")
+		Expect(cw.Flush()).To(Succeed())
+
+		var b *Blocker
+		Eventually(blockers).Should(Receive(&b))
+		Eventually(subject.Info().NumBlocked).Should(Equal(1))
+		for _, c := range subject.Info().Clients() {
+			c.Close()
+		}
+
+		Eventually(b.Done()).Should(BeClosed())
+		Expect(b.Err()).To(Equal(ErrBlockCancelled))
+		_, err := cr.PeekType()
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("KeyWaiters", func() {
+		var waiters *KeyWaiters
+		var lists map[string][]string
+		var mu sync.Mutex
+
+		var pop = func(key string) (func(resp.ResponseWriter), bool) {
+			if len(lists[key]) == 0 {
+				return nil, false
+			}
+			v := lists[key][0]
+			lists[key] = lists[key][1:]
+			return func(w resp.ResponseWriter) {
+				w.AppendArrayLen(2)
+				w.AppendBulkString(key)
+				w.AppendBulkString(v)
+			}, true
+		}
+
+		BeforeEach(func() {
+			waiters = NewKeyWaiters()
+			lists = make(map[string][]string)
+
+			subject.HandleFunc("lpush", func(w resp.ResponseWriter, c *resp.Command) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				key := c.Arg(0).String()
+				for _, arg := range c.Args[1:] {
+					lists[key] = append(lists[key], arg.String())
+				}
+				w.AppendInt(int64(len(lists[key])))
+				waiters.Notify(key)
+			}, Arity(-3))
+			subject.HandleFunc("blpop", func(w resp.ResponseWriter, c *resp.Command) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				keys := make([]string, 0, c.ArgN()-1)
+				for _, arg := range c.Args[:c.ArgN()-1] {
+					if reply, ok := pop(arg.String()); ok {
+						reply(w)
+						return
+					}
+					keys = append(keys, arg.String())
+				}
+
+				secs, _ := strconv.Atoi(c.Arg(c.ArgN() - 1).String())
+				b := GetClient(c.Context()).Block(time.Duration(secs) * time.Second)
+				waiters.Wait(b, pop, keys...)
+			}, Arity(-3))
+		})
+
+		It("should wake waiters in order", func() {
+			cn1, cw1, cr1 := dialRaw(lis)
+			defer cn1.Close()
+			cn2, cw2, cr2 := dialRaw(lis)
+			defer cn2.Close()
+			cn3, cw3, cr3 := dialRaw(lis)
+			defer cn3.Close()
+
+			cw1.WriteCmdString("BLPOP", "a", "b", "0")
+			Expect(cw1.Flush()).To(Succeed())
+			Eventually(func() int { return waiters.Len("b") }).Should(Equal(1))
+			cw2.WriteCmdString("BLPOP", "b", "0")
+			Expect(cw2.Flush()).To(Succeed())
+			Eventually(func() int { return waiters.Len("b") }).Should(Equal(2))
+
+			cw3.WriteCmdString("LPUSH", "b", "x")
+			Expect(cw3.Flush()).To(Succeed())
+			Expect(cr3.ReadInt()).To(Equal(int64(1)))
+			Expect(cr1.ReadArrayLen()).To(Equal(2))
+			Expect(cr1.ReadBulkString()).To(Equal("b"))
+			Expect(cr1.ReadBulkString()).To(Equal("x"))
+			Expect(waiters.Len("a")).To(Equal(0))
+			Expect(waiters.Len("b")).To(Equal(1))
+
+			cw3.WriteCmdString("LPUSH", "b", "y", "z")
+			Expect(cw3.Flush()).To(Succeed())
+			Expect(cr3.ReadInt()).To(Equal(int64(2)))
+			Expect(cr2.ReadArrayLen()).To(Equal(2))
+			Expect(cr2.ReadBulkString()).To(Equal("b"))
+			Expect(cr2.ReadBulkString()).To(Equal("y"))
+			Expect(waiters.Len("b")).To(Equal(0))
+
+			mu.Lock()
+			Expect(lists["b"]).To(Equal([]string{"z"}))
+			mu.Unlock()
+		})
+
+		It("should remove waiters on timeout", func() {
+			cn, cw, cr := dialRaw(lis)
+			defer cn.Close()
+
+			cw.WriteCmdString("BLPOP", "a", "1")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadNil()).To(Succeed())
+			Eventually(func() int { return waiters.Len("a") }).Should(Equal(0))
+		})
+	})
+
+})
@@ -1,18 +1,28 @@
 package redeo
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"github.com/wangaoone/redeo/resp"
 	"io"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+var errClientClosed = errors.New("redeo: client closed")
+
+// ErrConnClosed is returned by the writers passed to handlers once the
+// client connection is gone, so long-running handlers can abort early.
+var ErrConnClosed = errors.New("redeo: client connection closed")
+
 var (
-	clientInc  = uint64(0)
-	readerPool sync.Pool
-	writerPool sync.Pool
+	clientInc      = uint64(0)
+	defaultBuffers = newBufferPool(0, 0)
 )
 
 type ctxKeyClient struct{}
@@ -21,26 +31,83 @@ type ctxKeyClient struct{}
 type Client struct {
 	id uint64
 	cn net.Conn
+	mc meteredConn // wraps cn, counts traffic
+	ic idleConn    // wraps mc, see Server.awaitRequest
 
-	rd *resp.RequestReader
-	wr resp.ResponseWriter
+	proxy *proxyProtoConn // wrapped by cn, see Config.AcceptProxyProtocol
+
+	rd      *resp.RequestReader
+	wr      resp.ResponseWriter
+	rw      replyWriter // wraps wr, passed to handlers
+	buffers *bufferPool // recycles rd and wr on release
+	async   bool        // true if replies are also written by handleResponses
 
 	ctx    context.Context
+	cancel context.CancelFunc // cancels ctx, see Context
+	cmdCtx context.Context    // ctx with the client attached, see cmdContext
+	name   string
+	db     int
+	vals   map[interface{}]interface{}
 	closed bool
+	killed bool              // true if disconnected without reply, see kill
+	authed bool              // true once authenticated, see Config.RequirePass
+	user   string            // the authenticated username
+	resume *StreamCheckpoint // announced via RESUME CONTINUE, see Resumable
+	busy   bool              // true while a pipeline is being processed
+	mu     sync.Mutex
 	done   chan struct{}
 
-	cmd  *resp.Command
-	scmd *resp.CommandStream
+	writeTimeout time.Duration // see Config.WriteTimeout
+	traceArg     string        // see Config.TraceArg
+	werr         error         // the write error which ended the connection
+	deadline     time.Time     // the connection deadline of the current pipeline, see Config.Timeout
+
+	pending []func(resp.ResponseWriter) // pushes deferred while busy
+	hooks   []func()                    // called on release
+
+	cmd     *resp.Command
+	scmd    *resp.CommandStream
+	cmdName string                 // the normalised name of the current command
+	args    []resp.CommandArgument // the arguments of the current command
+	ran     bool                   // true once the handler of the current command was called
+	tx      *transaction           // the open transaction, if any
+
+	watched  []watchKey // keys watched via WATCH, guarded by the server's watchSet
+	modified bool       // true once watched keys were modified, see Server.Touch
+
+	dispatched []*shardTask // commands in flight on shard workers
+	readAhead  bool         // true if cmd was read, but not performed by performSharded
+	promises   []*Promise   // pending replies of asynchronous commands
+	held       int64        // bytes held by resolved promises, see drainPromises
+	omem       int64        // output buffer size as of the last check or flush
+	softSince  time.Time    // since when the output is over the soft limit, see checkOutput
+	unflushed  int          // commands since the last flush, see FlushPolicy
+	replOffset int64        // replication offset after the last replicated write, see WAIT
+
+	rl      rateLimiter // see Config.RateLimit
+	working bool        // true while holding a worker, see Config.Workers
+
+	blocker *Blocker // set by handlers, see Block
+	blocked *Blocker // the blocker the client is waiting for
 
 	responses chan interface{}
 }
 
 func newClient(cn net.Conn) *Client {
 	c := new(Client)
-	c.reset(cn)
+	c.reset(cn, defaultBuffers)
 	return c
 }
 
+// ClientDB returns the database index selected by the client of the
+// command context, or 0 if the client is not set.
+func ClientDB(ctx context.Context) int {
+	if c := GetClient(ctx); c != nil {
+		return c.DB()
+	}
+	return 0
+}
+
 // GetClient retrieves the client from a the context.
 // This function may return nil if a client is not set.
 func GetClient(ctx context.Context) *Client {
@@ -52,20 +119,137 @@ func GetClient(ctx context.Context) *Client {
 	return nil
 }
 
+// CommandName returns the normalised (lower-case) name of the command
+// that is currently being served. It may be used by middleware to
+// identify commands. Returns an empty string if the context does not
+// belong to a client command.
+func CommandName(ctx context.Context) string {
+	if c := GetClient(ctx); c != nil {
+		return c.cmdName
+	}
+	return ""
+}
+
 // ID return the unique client id
 func (c *Client) ID() uint64 { return c.id }
 
-// Context return the client context
+// Protocol returns the protocol version negotiated by the client,
+// resp.RESP2 unless the client has upgraded via HELLO.
+func (c *Client) Protocol() int { return c.wr.Protocol() }
+
+// Name returns the client name, as set via SetName.
+func (c *Client) Name() string {
+	c.mu.Lock()
+	name := c.name
+	c.mu.Unlock()
+	return name
+}
+
+// SetName sets the client name.
+func (c *Client) SetName(name string) {
+	c.mu.Lock()
+	c.name = name
+	c.mu.Unlock()
+}
+
+// Authenticated returns true once the client has authenticated via AUTH
+// or HELLO, see Config.RequirePass and Config.AuthFunc.
+func (c *Client) Authenticated() bool {
+	c.mu.Lock()
+	ok := c.authed
+	c.mu.Unlock()
+	return ok
+}
+
+// User returns the username the client has authenticated with, or an
+// empty string if the client is not authenticated.
+func (c *Client) User() string {
+	c.mu.Lock()
+	user := c.user
+	c.mu.Unlock()
+	return user
+}
+
+// login marks the client as authenticated.
+func (c *Client) login(user string) {
+	c.mu.Lock()
+	c.authed, c.user = true, user
+	c.mu.Unlock()
+}
+
+// DB returns the database index selected by the client.
+func (c *Client) DB() int {
+	c.mu.Lock()
+	db := c.db
+	c.mu.Unlock()
+	return db
+}
+
+// SetDB sets the database index of the client.
+func (c *Client) SetDB(db int) {
+	c.mu.Lock()
+	c.db = db
+	c.mu.Unlock()
+}
+
+// Context return the client context. Command contexts are derived from
+// the client context, so values attached to it become visible to
+// all subsequent commands of the client. It is cancelled once the
+// client is closed or disconnected.
 func (c *Client) Context() context.Context {
-	if c.ctx != nil {
-		return c.ctx
+	c.mu.Lock()
+	ctx := c.ctx
+	c.mu.Unlock()
+
+	if ctx != nil {
+		return ctx
 	}
 	return context.Background()
 }
 
-// SetContext sets the client's context
+// SetContext sets the client's context. To retain cancellation, ctx
+// should be derived from Context.
 func (c *Client) SetContext(ctx context.Context) {
+	c.mu.Lock()
 	c.ctx = ctx
+	c.cmdCtx = nil
+	c.mu.Unlock()
+}
+
+// Get returns a per-connection value stored under key or nil
+// if no such value exists. It is safe to call Get from other goroutines.
+func (c *Client) Get(key interface{}) interface{} {
+	c.mu.Lock()
+	val := c.vals[key]
+	c.mu.Unlock()
+	return val
+}
+
+// Lookup returns a per-connection value stored under key, reporting
+// whether it exists. It is safe to call Lookup from other goroutines.
+func (c *Client) Lookup(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	val, ok := c.vals[key]
+	c.mu.Unlock()
+	return val, ok
+}
+
+// Set stores a per-connection value under key. It is safe to call Set
+// from other goroutines.
+func (c *Client) Set(key, val interface{}) {
+	c.mu.Lock()
+	if c.vals == nil {
+		c.vals = make(map[interface{}]interface{})
+	}
+	c.vals[key] = val
+	c.mu.Unlock()
+}
+
+// Del removes a per-connection value.
+func (c *Client) Del(key interface{}) {
+	c.mu.Lock()
+	delete(c.vals, key)
+	c.mu.Unlock()
 }
 
 // RemoteAddr return the remote client address
@@ -73,20 +257,286 @@ func (c *Client) RemoteAddr() net.Addr {
 	return c.cn.RemoteAddr()
 }
 
+// TLSConnectionState returns the state of TLS connections, including
+// verified client certificates. Returns false for other connections.
+func (c *Client) TLSConnectionState() (tls.ConnectionState, bool) {
+	if tc, ok := c.cn.(*tls.Conn); ok {
+		return tc.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
+// BytesRead returns the number of bytes received from the client.
+func (c *Client) BytesRead() int64 { return atomic.LoadInt64(&c.mc.in) }
+
+// BytesWritten returns the number of bytes sent to the client.
+func (c *Client) BytesWritten() int64 { return atomic.LoadInt64(&c.mc.out) }
+
 func (c *Client) Responses() chan interface{} {
 	return c.responses
 }
 
 // Close will disconnect as soon as all pending replies have been written
-// to the client
-func (c *Client) Close() {
+// to the client. Idle clients, waiting for the next command, are
+// disconnected immediately. Outstanding asynchronous commands are
+// cancelled.
+func (c *Client) Close() { c.close(true) }
+
+// drain is like Close, but lets outstanding asynchronous commands
+// complete, see Server.Shutdown.
+func (c *Client) drain() { c.close(false) }
+
+func (c *Client) close(cancel bool) {
+	c.mu.Lock()
 	c.closed = true
+	if !c.busy {
+		// interrupt the pending read
+		_ = c.cn.SetReadDeadline(time.Now())
+	}
+	c.cancelBlock()
+	if cancel {
+		c.cancelPromises()
+		c.cancelContext()
+	}
+	c.mu.Unlock()
+}
+
+// cancelContext cancels the client context. Must be called with the
+// lock held.
+func (c *Client) cancelContext() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// kill disconnects the client immediately, pending replies are discarded.
+// Returns false if the client was already killed.
+func (c *Client) kill() bool {
+	c.mu.Lock()
+	if c.killed {
+		c.mu.Unlock()
+		return false
+	}
+	c.closed = true
+	c.killed = true
+	c.cancelBlock()
+	c.cancelPromises()
+	c.cancelContext()
+	c.mu.Unlock()
+	_ = c.cn.Close()
+	return true
+}
+
+// abort cancels the client context after a failed write.
+func (c *Client) abort() {
+	c.mu.Lock()
+	c.cancelContext()
+	c.mu.Unlock()
+}
+
+func (c *Client) isKilled() bool {
+	c.mu.Lock()
+	killed := c.killed
+	c.mu.Unlock()
+	return killed
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	return closed
+}
+
+// idle marks the client as waiting for the next pipeline.
+// Returns false if the client was closed.
+func (c *Client) idle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	if len(c.promises) != 0 && c.promises[0].resolved() {
+		c.drainPromises()
+		c.setWriteDeadline()
+		_ = c.wr.Flush()
+	}
+	if len(c.pending) != 0 {
+		for _, fn := range c.pending {
+			fn(c.wr)
+		}
+		c.pending = c.pending[:0]
+		c.setWriteDeadline()
+		_ = c.wr.Flush()
+	}
+	c.busy = false
+	c.mc.active = false
+	return true
+}
+
+// Push writes a server-initiated message to the client, e.g. a RESP3 push
+// message via AppendPushLen. It is safe to call from any goroutine.
+// Messages are deferred while the client is processing a pipeline, so
+// they never interleave with replies. Returns an error if the client
+// is closed.
+func (c *Client) Push(fn func(w resp.ResponseWriter)) error {
+	return c.push(fn)
+}
+
+// push writes asynchronous messages to the client. Pushes are
+// deferred while the client is processing a pipeline to prevent
+// them from interleaving with replies.
+func (c *Client) push(fn func(resp.ResponseWriter)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errClientClosed
+	}
+	if c.busy {
+		c.pending = append(c.pending, fn)
+		return nil
+	}
+
+	fn(c.wr)
+	c.setWriteDeadline()
+	return c.wr.Flush()
+}
+
+// setWriteDeadline applies the write timeout, if configured.
+func (c *Client) setWriteDeadline() {
+	if d := c.writeTimeout; d > 0 {
+		_ = c.cn.SetWriteDeadline(time.Now().Add(d))
+	}
+}
+
+// flush writes buffered replies, recording write errors. Must only
+// be called while serving the client.
+func (c *Client) flush() error {
+	if err := c.flushReplies(); err != nil {
+		c.werr = err
+		return err
+	}
+	c.unflushed = 0
+	return nil
+}
+
+// flushReplies writes buffered replies and records the flush with the
+// current reply, see replyWriter.flushed. It is shared by the serving
+// goroutine and the flusher, see startFlusher.
+func (c *Client) flushReplies() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setWriteDeadline()
+	n := c.BytesWritten()
+	err := c.wr.Flush()
+	c.rw.flushed(int(c.BytesWritten() - n))
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&c.omem, atomic.LoadInt64(&c.held))
+	return nil
+}
+
+// startFlusher flushes buffered replies every d, until the returned
+// function is called. Write errors are left to the serving goroutine.
+func (c *Client) startFlusher(d time.Duration) func() {
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+
+		t := time.NewTicker(d)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if c.wr.Buffered() == 0 {
+					continue
+				}
+				if err := c.flushReplies(); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// onRelease registers a callback to run when the client disconnects.
+func (c *Client) onRelease(fn func()) {
+	c.mu.Lock()
+	c.hooks = append(c.hooks, fn)
+	c.mu.Unlock()
+}
+
+// activate marks the client as busy processing a pipeline.
+func (c *Client) activate() {
+	c.mu.Lock()
+	c.busy = true
+	c.mu.Unlock()
+	c.mc.active = true
+}
+
+// terminate forcibly closes the underlying connection.
+func (c *Client) terminate() {
+	c.mu.Lock()
+	c.cancelBlock()
+	c.cancelPromises()
+	c.cancelContext()
+	c.mu.Unlock()
+	_ = c.cn.Close()
+}
+
+// cmdContext returns a new command context, derived from the client context.
+func (c *Client) cmdContext() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// cached until the client context changes, as contexts are immutable
+	if c.cmdCtx == nil {
+		parent := c.ctx
+		if parent == nil {
+			parent = context.Background()
+		}
+		c.cmdCtx = context.WithValue(parent, ctxKeyClient{}, c)
+	}
+	return c.cmdCtx
+}
+
+// withDeadline bounds the context of cmd by the connection deadline, if
+// any. The returned function releases the context.
+func (c *Client) withDeadline(cmd tracedCommand) context.CancelFunc {
+	if c.deadline.IsZero() {
+		return nil
+	}
+	ctx, cancel := context.WithDeadline(cmd.Context(), c.deadline)
+	cmd.SetContext(ctx)
+	return cancel
+}
+
+// gone returns true once the connection has failed or was killed.
+func (c *Client) gone() bool {
+	return c.werr != nil || c.isKilled()
 }
 
 func (c *Client) readCmd(cmd *resp.Command) (*resp.Command, error) {
+	if c.readAhead {
+		c.readAhead = false
+		return cmd, nil
+	}
+
 	var err error
 	if cmd, err = c.rd.ReadCmd(cmd); err == nil {
-		cmd.SetContext(context.WithValue(cmd.Context(), ctxKeyClient{}, c))
+		cmd.SetContext(c.cmdContext())
+		c.extractTraceParent(cmd)
 	}
 	return cmd, err
 }
@@ -94,14 +544,18 @@ func (c *Client) readCmd(cmd *resp.Command) (*resp.Command, error) {
 func (c *Client) streamCmd(cmd *resp.CommandStream) (*resp.CommandStream, error) {
 	var err error
 	if cmd, err = c.rd.StreamCmd(cmd); err == nil {
-		cmd.SetContext(context.WithValue(cmd.Context(), ctxKeyClient{}, c))
+		cmd.SetContext(c.cmdContext())
 	}
 	return cmd, err
 }
 
 func (c *Client) pipeline(fn func(string) error) error {
-	for more := true; more; more = c.rd.Buffered() != 0 {
+	// stop once the client is closed, e.g. after QUIT
+	for more := true; more && !c.isClosed(); more = c.rd.Buffered() != 0 {
 		name, err := c.rd.PeekCmd()
+		if !c.busy {
+			c.activate()
+		}
 		if err != nil {
 			if err == io.EOF {
 				return err
@@ -109,6 +563,10 @@ func (c *Client) pipeline(fn func(string) error) error {
 			_ = c.rd.SkipCmd()
 			return err
 		}
+		if name == "" && c.rd.Buffered() == 0 {
+			// only empty inline lines were received
+			continue
+		}
 		if err := fn(name); err != nil {
 			return err
 		}
@@ -125,33 +583,278 @@ func (c *Client) release() {
 	}
 
 	close(c.done)
+
+	c.mu.Lock()
+	c.closed = true
+	c.pending = nil
+	c.cancelPromises()
+	c.cancelContext()
+	hooks := c.hooks
+	c.hooks = nil
+	c.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+
 	_ = c.cn.Close()
-	readerPool.Put(c.rd)
-	writerPool.Put(c.wr)
+
+	// the response goroutine of async clients may still
+	// hold on to the writer, see Server.handleResponses
+	if !c.async {
+		c.buffers.put(c.rd, c.wr)
+	}
+	c.buffers.putCommand(c.cmd)
+	c.cmd = nil
 }
 
-func (c *Client) reset(cn net.Conn) {
+func (c *Client) reset(cn net.Conn, buffers *bufferPool) {
 	*c = Client{
-		id: atomic.AddUint64(&clientInc, 1),
-		cn: cn,
+		id:      atomic.AddUint64(&clientInc, 1),
+		cn:      cn,
+		mc:      meteredConn{Conn: cn},
+		buffers: buffers,
+	}
+	c.ic.Reader = &c.mc
+	c.rd = buffers.reader(&c.mc)
+	c.wr = buffers.writer(&c.mc)
+	c.cmd = buffers.command()
+	c.rw = replyWriter{ResponseWriter: c.wr, client: c}
+
+	c.done = make(chan struct{})
+	c.responses = make(chan interface{}, 1)
+}
+
+// --------------------------------------------------------------------
+
+// bufferPool recycles request readers and response writers of
+// a given buffer size, as well as commands and their arguments.
+type bufferPool struct {
+	readSize, writeSize        int
+	readers, writers, commands sync.Pool
+}
+
+func newBufferPool(readSize, writeSize int) *bufferPool {
+	if readSize <= 0 {
+		readSize = resp.MaxBufferSize
+	}
+	if writeSize <= 0 {
+		writeSize = resp.MaxBufferSize
 	}
+	return &bufferPool{readSize: readSize, writeSize: writeSize}
+}
 
-	if v := readerPool.Get(); v != nil {
-		rd := v.(*resp.RequestReader)
-		rd.Reset(cn)
-		c.rd = rd
-	} else {
-		c.rd = resp.NewRequestReader(cn)
+func (p *bufferPool) reader(rd io.Reader) *resp.RequestReader {
+	if v := p.readers.Get(); v != nil {
+		r := v.(*resp.RequestReader)
+		r.Reset(rd)
+		return r
 	}
+	return resp.NewRequestReaderSize(rd, p.readSize)
+}
 
-	if v := writerPool.Get(); v != nil {
-		wr := v.(resp.ResponseWriter)
-		wr.Reset(cn)
-		c.wr = wr
-	} else {
-		c.wr = resp.NewResponseWriter(cn)
+func (p *bufferPool) writer(wr io.Writer) resp.ResponseWriter {
+	if v := p.writers.Get(); v != nil {
+		w := v.(resp.ResponseWriter)
+		w.Reset(wr)
+		return w
 	}
+	return resp.NewResponseWriterSize(wr, p.writeSize)
+}
 
-	c.done = make(chan struct{})
-	c.responses = make(chan interface{}, 1)
+// put returns a reader and a writer to the pool. Both are detached from
+// the connection first, so they do not retain it while pooled.
+func (p *bufferPool) put(rd *resp.RequestReader, wr resp.ResponseWriter) {
+	p.putReader(rd)
+	wr.Reset(nil)
+	p.writers.Put(wr)
+}
+
+// command returns a pooled command, or nil.
+func (p *bufferPool) command() *resp.Command {
+	if v := p.commands.Get(); v != nil {
+		return v.(*resp.Command)
+	}
+	return nil
+}
+
+// putCommand returns a command to the pool, unless its arguments retain
+// more memory than the read buffer.
+func (p *bufferPool) putCommand(cmd *resp.Command) {
+	if cmd == nil {
+		return
+	}
+
+	cmd.Reset()
+	size := 0
+	for _, arg := range cmd.Args[:cap(cmd.Args)] {
+		size += cap(arg)
+	}
+	if size <= p.readSize {
+		p.commands.Put(cmd)
+	}
+}
+
+// putReader returns a reader to the pool.
+func (p *bufferPool) putReader(rd *resp.RequestReader) {
+	rd.Reset(nil)
+	p.readers.Put(rd)
+}
+
+// --------------------------------------------------------------------
+
+// meteredConn counts the bytes read from and written to a connection.
+// Reads and writes happen when buffers are filled or flushed.
+type meteredConn struct {
+	net.Conn
+	in, out int64
+
+	readTimeout time.Duration // see Config.ReadTimeout
+	active      bool          // true while a pipeline is read
+}
+
+func (m *meteredConn) Read(p []byte) (int, error) {
+	if m.active && m.readTimeout > 0 {
+		_ = m.Conn.SetReadDeadline(time.Now().Add(m.readTimeout))
+	}
+	n, err := m.Conn.Read(p)
+	atomic.AddInt64(&m.in, int64(n))
+	return n, err
+}
+
+func (m *meteredConn) Write(p []byte) (int, error) {
+	n, err := m.Conn.Write(p)
+	atomic.AddInt64(&m.out, int64(n))
+	return n, err
+}
+
+// ReadFrom retains the ability of the connection to copy
+// efficiently, e.g. via sendfile.
+func (m *meteredConn) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(m.Conn, r)
+	atomic.AddInt64(&m.out, n)
+	return n, err
+}
+
+// --------------------------------------------------------------------
+
+// replyWriter wraps the client's writer to detect error replies
+// and to apply write timeouts to flushes from within handlers.
+// The client is nil for replies buffered by shard workers.
+type replyWriter struct {
+	resp.ResponseWriter
+	client *Client
+	failed bool
+	errMsg string
+
+	mark    int    // buffered bytes as of begin or the last flush
+	wrote   bool   // true once the current reply was partially flushed
+	discard func() // discards the current reply, if supported
+}
+
+func (w *replyWriter) Flush() error {
+	if w.client == nil {
+		w.flushed(w.ResponseWriter.Buffered())
+		return w.ResponseWriter.Flush()
+	}
+	if w.client.gone() {
+		return ErrConnClosed
+	}
+	if err := w.client.flush(); err != nil {
+		w.client.abort()
+		return ErrConnClosed
+	}
+	return nil
+}
+
+func (w *replyWriter) CopyBulk(src io.Reader, n int64) error {
+	if w.client == nil {
+		w.wrote = true
+		return w.ResponseWriter.CopyBulk(src, n)
+	}
+	w.client.mu.Lock()
+	w.wrote = true
+	w.client.mu.Unlock()
+	if w.client.gone() {
+		return ErrConnClosed
+	}
+	w.client.setWriteDeadline()
+	if err := w.ResponseWriter.CopyBulk(src, n); err != nil {
+		if _, ok := err.(net.Error); ok {
+			w.client.werr = err
+			w.client.abort()
+			return ErrConnClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// flushed records whether the current reply was written by a flush of
+// n bytes. The reply state of clients is guarded by the client lock, as
+// replies may be flushed by the flusher, see Client.startFlusher.
+func (w *replyWriter) flushed(n int) {
+	if n > w.mark {
+		w.wrote = true
+	}
+	w.mark = 0
+}
+
+// bufferTo allows to discard partial replies of writers which write
+// to buf.
+func (w *replyWriter) bufferTo(buf *bytes.Buffer) {
+	w.discard = func() {
+		proto := w.ResponseWriter.Protocol()
+		buf.Reset()
+		w.ResponseWriter.Reset(buf)
+		w.ResponseWriter.SetProtocol(proto)
+		w.mark, w.wrote = 0, false
+	}
+}
+
+// written returns true if anything was written since begin.
+func (w *replyWriter) written() bool {
+	if w.client != nil {
+		w.client.mu.Lock()
+		defer w.client.mu.Unlock()
+	}
+	return w.wrote || w.ResponseWriter.Buffered() != w.mark
+}
+
+func (w *replyWriter) AppendError(msg string) {
+	w.failed, w.errMsg = true, msg
+	w.ResponseWriter.AppendError(msg)
+}
+
+func (w *replyWriter) AppendErrorf(pattern string, args ...interface{}) {
+	w.AppendError(fmt.Sprintf(pattern, args...))
+}
+
+func (w *replyWriter) Append(v interface{}) error {
+	if err, ok := v.(error); ok {
+		w.failed, w.errMsg = true, err.Error()
+	}
+	return w.ResponseWriter.Append(v)
+}
+
+func (w *replyWriter) AppendAny(v interface{}) error {
+	return resp.Marshal(w, v)
+}
+
+// begin resets the reply state before a command is executed.
+func (w *replyWriter) begin() {
+	w.failed, w.errMsg = false, ""
+	if w.client != nil {
+		w.client.mu.Lock()
+		defer w.client.mu.Unlock()
+	}
+	w.mark, w.wrote = w.ResponseWriter.Buffered(), false
+}
+
+// result returns err or, if nil, the error reply of the last command.
+func (w *replyWriter) result(err error) error {
+	if err == nil && w.failed {
+		err = errors.New(w.errMsg)
+	}
+	return err
 }
@@ -1,8 +1,10 @@
 package client
 
 import (
+	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/wangaoone/redeo/resp"
@@ -38,6 +40,19 @@ type Conn interface {
 	// Flush flushes the output buffer. Call this after you have completed your pipeline
 	Flush() error
 
+	// Cmd sends a single command and reads the reply. Error replies are
+	// returned as replies, see resp.Reply.Err.
+	Cmd(name string, args ...interface{}) (*resp.Reply, error)
+	// Append appends a command to the pipeline. Arguments may be strings,
+	// byte slices, numbers or booleans, other values are formatted
+	// with fmt.Print.
+	Append(name string, args ...interface{})
+	// Receive reads the next reply of a flushed pipeline.
+	Receive() (*resp.Reply, error)
+	// ReceiveStream reads the next reply like Receive, but streams bulk
+	// values. The reply must be closed before the next one is received.
+	ReceiveStream() (*resp.Reply, error)
+
 	// SetDeadline sets the read and write deadlines associated
 	// with the connection. It is equivalent to calling both
 	// SetReadDeadline and SetWriteDeadline.
@@ -53,6 +68,11 @@ type Conn interface {
 	// A zero value for t means Write will not time out.
 	SetWriteDeadline(time.Time) error
 
+	// SetLimits limits the length of bulk replies and the number of
+	// elements of aggregate replies, e.g. to protect against broken or
+	// malicious servers. Zero values disable the respective limit.
+	SetLimits(maxBulk int64, maxAggregate int)
+
 	// UnreadBytes returns the number of unread bytes.
 	UnreadBytes() int
 	// UnflushedBytes returns the number of pending/unflushed bytes.
@@ -64,6 +84,15 @@ type Conn interface {
 	madeByRedeo()
 }
 
+// Dial connects to the given TCP address.
+func Dial(addr string) (Conn, error) {
+	cn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(cn), nil
+}
+
 // Wrap wraps a single network connection.
 func Wrap(cn net.Conn) Conn {
 	return &conn{
@@ -80,7 +109,8 @@ type conn struct {
 	*resp.RequestWriter
 	resp.ResponseReader
 
-	failed bool
+	failed  bool
+	scratch []byte
 }
 
 // MarkFailed implements Conn interface.
@@ -95,4 +125,74 @@ func (c *conn) UnflushedBytes() int { return c.RequestWriter.Buffered() }
 // Close implements Conn interface.
 func (c *conn) Close() error { c.failed = true; return c.Conn.Close() }
 
+// Cmd implements Conn interface.
+func (c *conn) Cmd(name string, args ...interface{}) (*resp.Reply, error) {
+	c.Append(name, args...)
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	return c.Receive()
+}
+
+// Append implements Conn interface.
+func (c *conn) Append(name string, args ...interface{}) {
+	_ = c.WriteMultiBulkSize(len(args) + 1)
+	c.WriteBulkString(name)
+	for _, arg := range args {
+		c.appendArg(arg)
+	}
+}
+
+// Receive implements Conn interface.
+func (c *conn) Receive() (*resp.Reply, error) {
+	return resp.ReadReply(c.ResponseReader)
+}
+
+// ReceiveStream implements Conn interface.
+func (c *conn) ReceiveStream() (*resp.Reply, error) {
+	return resp.StreamReply(c.ResponseReader)
+}
+
+func (c *conn) appendArg(arg interface{}) {
+	buf := c.scratch[:0]
+	switch v := arg.(type) {
+	case string:
+		c.WriteBulkString(v)
+		return
+	case []byte:
+		c.WriteBulk(v)
+		return
+	case resp.CommandArgument:
+		c.WriteBulk(v)
+		return
+	case nil:
+	case bool:
+		if v {
+			buf = append(buf, '1')
+		} else {
+			buf = append(buf, '0')
+		}
+	case int:
+		buf = strconv.AppendInt(buf, int64(v), 10)
+	case int32:
+		buf = strconv.AppendInt(buf, int64(v), 10)
+	case int64:
+		buf = strconv.AppendInt(buf, v, 10)
+	case uint:
+		buf = strconv.AppendUint(buf, uint64(v), 10)
+	case uint32:
+		buf = strconv.AppendUint(buf, uint64(v), 10)
+	case uint64:
+		buf = strconv.AppendUint(buf, v, 10)
+	case float32:
+		buf = strconv.AppendFloat(buf, float64(v), 'f', -1, 32)
+	case float64:
+		buf = strconv.AppendFloat(buf, v, 'f', -1, 64)
+	default:
+		buf = append(buf, fmt.Sprint(v)...)
+	}
+	c.scratch = buf
+	c.WriteBulk(buf)
+}
+
 func (c *conn) madeByRedeo() {}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conn", func() {
+	var subject Conn
+	var server net.Conn
+	var requests *resp.RequestReader
+
+	// serve replies to the next len(replies) commands and returns their
+	// names and arguments.
+	var serve = func(replies ...string) <-chan []string {
+		cmds := make(chan []string, len(replies))
+		go func() {
+			defer GinkgoRecover()
+
+			for _, reply := range replies {
+				cmd, err := requests.ReadCmd(nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				args := []string{cmd.Name}
+				for _, arg := range cmd.Args {
+					args = append(args, arg.String())
+				}
+				cmds <- args
+
+				_, err = io.WriteString(server, reply)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}()
+		return cmds
+	}
+
+	BeforeEach(func() {
+		var cn net.Conn
+		cn, server = net.Pipe()
+		subject = Wrap(cn)
+		requests = resp.NewRequestReader(server)
+	})
+
+	AfterEach(func() {
+		_ = subject.Close()
+		_ = server.Close()
+	})
+
+	It("should send commands", func() {
+		cmds := serve("$1\r\nx\r\n")
+
+		r, err := subject.Cmd("ECHO", "a", []byte("b"), resp.CommandArgument("c"), nil, true, false,
+			1, int32(-2), int64(3), uint(4), uint32(5), uint64(6), float32(0.25), 1.5, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("x"))
+		Expect(<-cmds).To(Equal([]string{"ECHO", "a", "b", "c", "", "1", "0",
+			"1", "-2", "3", "4", "5", "6", "0.25", "1.5", "1s"}))
+	})
+
+	It("should return error replies", func() {
+		serve("-ERR wrong number of arguments for 'echo' command\r\n")
+
+		r, err := subject.Cmd("ECHO")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("ERR wrong number of arguments for 'echo' command"))
+	})
+
+	It("should pipeline commands", func() {
+		cmds := serve("+PONG\r\n", ":2\r\n", "*2\r\n$1\r\na\r\n$-1\r\n")
+
+		subject.Append("PING")
+		subject.Append("INCR", "key")
+		subject.Append("MGET", "a", "b")
+		Expect(subject.UnflushedBytes()).To(BeNumerically(">", 0))
+		Expect(subject.Flush()).To(Succeed())
+
+		r, err := subject.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("PONG"))
+
+		r, err = subject.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Int()).To(Equal(int64(2)))
+
+		r, err = subject.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		elems, err := r.Slice()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(elems).To(HaveLen(2))
+		Expect(elems[0].Str()).To(Equal("a"))
+		Expect(elems[1].IsNil()).To(BeTrue())
+
+		Expect(<-cmds).To(Equal([]string{"PING"}))
+		Expect(<-cmds).To(Equal([]string{"INCR", "key"}))
+		Expect(<-cmds).To(Equal([]string{"MGET", "a", "b"}))
+	})
+
+	It("should stream replies", func() {
+		blob := strings.Repeat("x", 100000)
+		serve("$100000\r\n"+blob+"\r\n", "+OK\r\n")
+
+		subject.Append("GET", "blob")
+		subject.Append("SET", "key", "value")
+		Expect(subject.Flush()).To(Succeed())
+
+		r, err := subject.ReceiveStream()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Len()).To(Equal(int64(100000)))
+		rd, err := r.Reader()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.ReadAll(rd)).To(Equal([]byte(blob)))
+		Expect(r.Close()).To(Succeed())
+
+		r, err = subject.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("OK"))
+	})
+
+	It("should limit replies", func() {
+		serve("$0099999999999999\r\n")
+
+		subject.SetLimits(1<<20, 1024)
+		_, err := subject.Cmd("GET", "key")
+		Expect(err).To(MatchError("Protocol error: too big bulk length"))
+	})
+
+	It("should fail on broken connections", func() {
+		Expect(server.Close()).To(Succeed())
+
+		_, err := subject.Cmd("PING")
+		Expect(err).To(HaveOccurred())
+	})
+
+})
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "redeo/client")
+}
@@ -62,3 +62,19 @@ func ExamplePool() {
 	// OK
 	// 1
 }
+
+func ExamplePool_Do() {
+	pool, _ := client.New(&pool.Options{
+		InitialSize: 1,
+	}, nil)
+	defer pool.Close()
+
+	// Verify pooled connections before use
+	pool.SetHealthCheck(client.Ping)
+
+	rep, err := pool.Do("SET", "key", 42)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(rep.Str())
+}
@@ -15,6 +15,10 @@ type Pool struct {
 	conns   *pool.Pool
 	readers sync.Pool
 	writers sync.Pool
+	check   func(Conn) error
+
+	maxBulk      int64
+	maxAggregate int
 }
 
 // New initializes a new pool with a custom dialer
@@ -35,30 +39,73 @@ func New(opt *pool.Options, dialer func() (net.Conn, error)) (*Pool, error) {
 	}, nil
 }
 
+// SetHealthCheck installs a check which connections must pass before they
+// are returned by Get, e.g. Ping. Connections failing the check are closed
+// and replaced. It must be called before the pool is used.
+func (p *Pool) SetHealthCheck(fn func(Conn) error) { p.check = fn }
+
+// SetLimits limits the replies read from connections, see Conn.SetLimits.
+// It must be called before the pool is used.
+func (p *Pool) SetLimits(maxBulk int64, maxAggregate int) {
+	p.maxBulk, p.maxAggregate = maxBulk, maxAggregate
+}
+
 // Get returns a connection
 func (p *Pool) Get() (Conn, error) {
-	cn, err := p.conns.Get()
+	for attempts := p.conns.Len() + 1; ; attempts-- {
+		cn, err := p.conns.Get()
+		if err != nil {
+			return nil, err
+		}
+
+		c := &conn{
+			Conn: cn,
+
+			RequestWriter:  p.newRequestWriter(cn),
+			ResponseReader: p.newResponseReader(cn),
+		}
+		c.SetLimits(p.maxBulk, p.maxAggregate)
+		if p.check == nil {
+			return c, nil
+		}
+		if err := p.check(c); err != nil {
+			c.MarkFailed()
+			p.Put(c)
+			if attempts > 1 {
+				continue
+			}
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// Do sends a single command on a pooled connection and reads the reply.
+// Error replies are returned as replies, see resp.Reply.Err.
+func (p *Pool) Do(name string, args ...interface{}) (*resp.Reply, error) {
+	cn, err := p.Get()
 	if err != nil {
 		return nil, err
 	}
+	defer p.Put(cn)
 
-	return &conn{
-		Conn: cn,
-
-		RequestWriter:  p.newRequestWriter(cn),
-		ResponseReader: p.newResponseReader(cn),
-	}, nil
+	rep, err := cn.Cmd(name, args...)
+	if err != nil {
+		cn.MarkFailed()
+	}
+	return rep, err
 }
 
 // Put allows to return a connection back to the pool.
 // Call this method after every call/pipeline.
 // Do not use the connection again after this method
-// is triggered.
+// is triggered. Connections with unread replies or
+// unflushed commands are closed.
 func (p *Pool) Put(cn Conn) {
 	cs, ok := cn.(*conn)
 	if !ok {
 		return
-	} else if cs.failed {
+	} else if cs.failed || cs.UnreadBytes() != 0 || cs.UnflushedBytes() != 0 {
 		_ = cs.Close()
 		return
 	}
@@ -90,3 +137,12 @@ func (p *Pool) newResponseReader(cn net.Conn) resp.ResponseReader {
 	}
 	return resp.NewResponseReader(cn)
 }
+
+// Ping is a health check, which verifies connections via PING.
+func Ping(cn Conn) error {
+	rep, err := cn.Cmd("PING")
+	if err != nil {
+		return err
+	}
+	return rep.Err()
+}
@@ -1,6 +1,11 @@
 package redeo
 
 import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -12,4 +17,96 @@ var _ = Describe("Client", func() {
 		Expect(b.ID() - 1).To(Equal(a.ID()))
 	})
 
+	It("should store per-connection values", func() {
+		c := newClient(&mockConn{})
+		Expect(c.Get("db")).To(BeNil())
+
+		c.Set("db", 3)
+		Expect(c.Get("db")).To(Equal(3))
+
+		c.Del("db")
+		Expect(c.Get("db")).To(BeNil())
+
+		c.Set("user", nil)
+		_, ok := c.Lookup("user")
+		Expect(ok).To(BeTrue())
+		_, ok = c.Lookup("db")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should store values concurrently", func() {
+		c := newClient(&mockConn{})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				c.Set(n, n)
+				c.Get(n)
+				c.SetContext(context.WithValue(c.Context(), ctxKeyClient{}, n))
+			}(i)
+		}
+		wg.Wait()
+
+		Expect(c.Get(7)).To(Equal(7))
+	})
+
+	It("should derive command contexts", func() {
+		type key struct{}
+
+		c := newClient(&mockConn{})
+		c.SetContext(context.WithValue(context.Background(), key{}, "tenant"))
+		c.rd.Reset(strings.NewReader("*1\r\n$4\r\nPING\r\n"))
+
+		cmd, err := c.readCmd(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd.Context().Value(key{})).To(Equal("tenant"))
+		Expect(GetClient(cmd.Context())).To(Equal(c))
+	})
+
+	It("should recycle commands", func() {
+		p := newBufferPool(16, 16)
+		c := new(Client)
+		c.reset(&mockConn{}, p)
+		c.rd.Reset(strings.NewReader("*2\r\n$4\r\nECHO\r\n$5\r\nHELLO\r\n"))
+
+		cmd, err := c.readCmd(c.cmd)
+		Expect(err).NotTo(HaveOccurred())
+		p.putCommand(cmd)
+		Expect(cmd.Name).To(BeEmpty())
+		Expect(cmd.Args).To(BeEmpty())
+		Expect(cmd.Context()).To(Equal(context.Background()))
+
+		// large arguments are not retained
+		c.rd.Reset(strings.NewReader("*2\r\n$4\r\nECHO\r\n$20\r\n" + strings.Repeat("x", 20) + "\r\n"))
+		cmd, err = c.readCmd(nil)
+		Expect(err).NotTo(HaveOccurred())
+		p = newBufferPool(16, 16)
+		p.putCommand(cmd)
+		Expect(p.command()).To(BeNil())
+	})
+
 })
+
+// BenchmarkMeteredConn measures the cost of traffic accounting per write,
+// compare with BenchmarkServer_ping for the cost of a round trip.
+func BenchmarkMeteredConn(b *testing.B) {
+	pong := []byte("+PONG\r\n")
+
+	b.Run("plain", func(b *testing.B) {
+		cn := &mockConn{}
+		for i := 0; i < b.N; i++ {
+			cn.Write(pong)
+			cn.Reset()
+		}
+	})
+
+	b.Run("metered", func(b *testing.B) {
+		cn := &meteredConn{Conn: &mockConn{}}
+		for i := 0; i < b.N; i++ {
+			cn.Write(pong)
+			cn.Conn.(*mockConn).Reset()
+		}
+	})
+}
@@ -0,0 +1,282 @@
+package redeo
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/wangaoone/redeo/info"
+	"github.com/wangaoone/redeo/resp"
+)
+
+// ClusterSlots is the number of hash slots of a redis cluster.
+const ClusterSlots = 16384
+
+// ClusterNode is a node of a cluster.
+type ClusterNode struct {
+	// ID is the unique, 40 character node ID
+	ID string
+	// Host is the address clients connect to
+	Host string
+	// Port is the port clients connect to
+	Port int
+}
+
+// addr returns the node address, as used in redirects.
+func (n ClusterNode) addr() string {
+	return n.Host + ":" + strconv.Itoa(n.Port)
+}
+
+// SlotRange assigns the hash slots Start to End, inclusive, to a master
+// node and its replicas.
+type SlotRange struct {
+	Start, End int
+	Master     ClusterNode
+	Replicas   []ClusterNode
+}
+
+// Cluster describes the ownership of hash slots, allowing a fleet of
+// servers to act as a redis cluster, see Server.HandleCluster. It must
+// not be modified once handled.
+type Cluster struct {
+	// Self is the node of the server
+	Self ClusterNode
+	// Ranges are the assigned slot ranges
+	Ranges []SlotRange
+}
+
+// Owner returns the master node serving the slot.
+func (cl *Cluster) Owner(slot int) (ClusterNode, bool) {
+	for _, r := range cl.Ranges {
+		if slot >= r.Start && slot <= r.End {
+			return r.Master, true
+		}
+	}
+	return ClusterNode{}, false
+}
+
+// Redirect returns a MOVED error reply if key is served by another node,
+// or a CLUSTERDOWN error reply if its slot is not assigned. Returns false
+// if key is served by Self.
+func (cl *Cluster) Redirect(key string) (string, bool) {
+	slot := KeySlot(key)
+	node, ok := cl.Owner(slot)
+	if !ok {
+		return errClusterDown.Error(), true
+	}
+	if node.ID == cl.Self.ID {
+		return "", false
+	}
+	return MovedError{Slot: slot, Addr: node.addr()}.Error(), true
+}
+
+// RouteKeys rejects commands with keys served by other nodes with a MOVED
+// error, keys of unassigned slots with a CLUSTERDOWN error and keys of
+// different slots with a CROSSSLOT error. It can be used as
+// Config.KeyRouter.
+func (cl *Cluster) RouteKeys(_ *Client, _ string, keys []resp.CommandArgument) error {
+	slot := KeySlot(string(keys[0]))
+	for _, key := range keys[1:] {
+		if KeySlot(string(key)) != slot {
+			return errCrossSlot
+		}
+	}
+
+	node, ok := cl.Owner(slot)
+	if !ok {
+		return errClusterDown
+	}
+	if node.ID != cl.Self.ID {
+		return MovedError{Slot: slot, Addr: node.addr()}
+	}
+	return nil
+}
+
+var (
+	errCrossSlot   = NewError("CROSSSLOT", "Keys in request don't hash to the same slot")
+	errClusterDown = NewError("CLUSTERDOWN", "Hash slot not served")
+)
+
+// KeySlot returns the hash slot of key. Only the hash tag is hashed, if
+// the key contains one, e.g. "{user1}.name".
+// https://redis.io/topics/cluster-spec#keys-hash-tags
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start > -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % ClusterSlots)
+}
+
+// crc16 implements CRC16-XMODEM, as used by redis cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// assigned returns the number of assigned slots.
+func (cl *Cluster) assigned() int {
+	n := 0
+	for _, r := range cl.Ranges {
+		n += r.End - r.Start + 1
+	}
+	return n
+}
+
+// nodes returns the known nodes, masters first, in order of appearance.
+func (cl *Cluster) nodes() (masters, all []ClusterNode) {
+	seen := map[string]bool{cl.Self.ID: true}
+	all = append(all, cl.Self)
+	for _, r := range cl.Ranges {
+		if !seen[r.Master.ID] {
+			seen[r.Master.ID] = true
+			all = append(all, r.Master)
+		}
+	}
+	for _, r := range cl.Ranges {
+		for _, n := range r.Replicas {
+			if !seen[n.ID] {
+				seen[n.ID] = true
+				all = append(all, n)
+			}
+		}
+	}
+
+	seen = make(map[string]bool)
+	for _, r := range cl.Ranges {
+		if !seen[r.Master.ID] {
+			seen[r.Master.ID] = true
+			masters = append(masters, r.Master)
+		}
+	}
+	return
+}
+
+func (cl *Cluster) infoString() string {
+	masters, all := cl.nodes()
+	state := "ok"
+	if cl.assigned() != ClusterSlots {
+		state = "fail"
+	}
+
+	return "cluster_enabled:1\r\n" +
+		"cluster_state:" + state + "\r\n" +
+		"cluster_slots_assigned:" + strconv.Itoa(cl.assigned()) + "\r\n" +
+		"cluster_slots_ok:" + strconv.Itoa(cl.assigned()) + "\r\n" +
+		"cluster_slots_pfail:0\r\n" +
+		"cluster_slots_fail:0\r\n" +
+		"cluster_known_nodes:" + strconv.Itoa(len(all)) + "\r\n" +
+		"cluster_size:" + strconv.Itoa(len(masters)) + "\r\n"
+}
+
+func appendClusterNode(w resp.ResponseWriter, n ClusterNode) {
+	w.AppendArrayLen(3)
+	w.AppendBulkString(n.Host)
+	w.AppendInt(int64(n.Port))
+	w.AppendBulkString(n.ID)
+}
+
+func appendShardNode(w resp.ResponseWriter, n ClusterNode, role string) {
+	w.AppendMapLen(6)
+	w.AppendBulkString("id")
+	w.AppendBulkString(n.ID)
+	w.AppendBulkString("port")
+	w.AppendInt(int64(n.Port))
+	w.AppendBulkString("ip")
+	w.AppendBulkString(n.Host)
+	w.AppendBulkString("endpoint")
+	w.AppendBulkString(n.Host)
+	w.AppendBulkString("role")
+	w.AppendBulkString(role)
+	w.AppendBulkString("health")
+	w.AppendBulkString("online")
+}
+
+// --------------------------------------------------------------------
+
+// HandleCluster registers a CLUSTER handler, supporting the INFO, SLOTS,
+// SHARDS, KEYSLOT and MYID sub-commands, and adds a Cluster section to
+// INFO. Handlers may use Cluster.Redirect to reject keys served by other
+// nodes, or Config.KeyRouter can be set to Cluster.RouteKeys.
+// https://redis.io/commands/cluster-slots
+func (srv *Server) HandleCluster(cl *Cluster) {
+	srv.info.Fetch("Cluster").Register("cluster_enabled", info.StaticString("1"))
+
+	// noArgs wraps sub-commands which take no arguments
+	noArgs := func(fn HandlerFunc) HandlerFunc {
+		return func(w resp.ResponseWriter, c *resp.Command) {
+			if c.ArgN() != 0 {
+				w.AppendError(WrongNumberOfArgs(c.Name))
+				return
+			}
+			fn(w, c)
+		}
+	}
+
+	sc := NewSubCommands()
+	sc.HandleFunc("info", noArgs(func(w resp.ResponseWriter, c *resp.Command) {
+		w.AppendBulkString(cl.infoString())
+	}))
+	sc.HandleFunc("myid", noArgs(func(w resp.ResponseWriter, c *resp.Command) {
+		w.AppendBulkString(cl.Self.ID)
+	}))
+	sc.HandleFunc("keyslot", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		w.AppendInt(int64(KeySlot(c.Arg(0).String())))
+	})
+	sc.HandleFunc("slots", noArgs(func(w resp.ResponseWriter, c *resp.Command) {
+		w.AppendArrayLen(len(cl.Ranges))
+		for _, r := range cl.Ranges {
+			w.AppendArrayLen(3 + len(r.Replicas))
+			w.AppendInt(int64(r.Start))
+			w.AppendInt(int64(r.End))
+			appendClusterNode(w, r.Master)
+			for _, n := range r.Replicas {
+				appendClusterNode(w, n)
+			}
+		}
+	}))
+	sc.HandleFunc("shards", noArgs(func(w resp.ResponseWriter, c *resp.Command) {
+		masters, _ := cl.nodes()
+		w.AppendArrayLen(len(masters))
+		for _, m := range masters {
+			var ranges []SlotRange
+			for _, r := range cl.Ranges {
+				if r.Master.ID == m.ID {
+					ranges = append(ranges, r)
+				}
+			}
+
+			w.AppendMapLen(2)
+			w.AppendBulkString("slots")
+			w.AppendArrayLen(2 * len(ranges))
+			for _, r := range ranges {
+				w.AppendInt(int64(r.Start))
+				w.AppendInt(int64(r.End))
+			}
+
+			// replicas are listed with the first range of the master
+			replicas := ranges[0].Replicas
+			w.AppendBulkString("nodes")
+			w.AppendArrayLen(1 + len(replicas))
+			appendShardNode(w, m, "master")
+			for _, n := range replicas {
+				appendShardNode(w, n, "replica")
+			}
+		}
+	}))
+	srv.Handle("cluster", sc)
+}
@@ -0,0 +1,141 @@
+package redeo
+
+import (
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cluster", func() {
+	var subject *Server
+	var cluster *Cluster
+
+	nodeA := ClusterNode{ID: "aaaa", Host: "10.0.0.1", Port: 6379}
+	nodeB := ClusterNode{ID: "bbbb", Host: "10.0.0.2", Port: 6380}
+	nodeC := ClusterNode{ID: "cccc", Host: "10.0.0.3", Port: 6381}
+
+	var serve = func(args ...string) *redeotest.ResponseRecorder {
+		cargs := make([]resp.CommandArgument, len(args))
+		for i, arg := range args {
+			cargs[i] = resp.CommandArgument(arg)
+		}
+		w := redeotest.NewRecorder()
+		subject.cmds["cluster"].served.(Handler).ServeRedeo(w, resp.NewCommand("CLUSTER", cargs...))
+		return w
+	}
+
+	BeforeEach(func() {
+		cluster = &Cluster{
+			Self: nodeA,
+			Ranges: []SlotRange{
+				{Start: 0, End: 8191, Master: nodeA, Replicas: []ClusterNode{nodeC}},
+				{Start: 8192, End: 16383, Master: nodeB},
+			},
+		}
+		subject = NewServer(nil)
+		subject.HandleCluster(cluster)
+	})
+
+	It("should redirect keys", func() {
+		_, ok := cluster.Redirect("bar") // slot 5061
+		Expect(ok).To(BeFalse())
+
+		msg, ok := cluster.Redirect("foo")
+		Expect(ok).To(BeTrue())
+		Expect(msg).To(Equal("MOVED 12182 10.0.0.2:6380"))
+		Expect(AskError{Slot: 12182, Addr: nodeC.addr()}.Error()).To(Equal("ASK 12182 10.0.0.3:6381"))
+
+		cluster.Ranges = cluster.Ranges[:1]
+		msg, ok = cluster.Redirect("foo")
+		Expect(ok).To(BeTrue())
+		Expect(msg).To(Equal("CLUSTERDOWN Hash slot not served"))
+	})
+
+	It("should route keys", func() {
+		Expect(cluster.RouteKeys(nil, "get", []resp.CommandArgument{resp.CommandArgument("bar")})).To(Succeed())
+		Expect(cluster.RouteKeys(nil, "get", []resp.CommandArgument{resp.CommandArgument("foo")})).To(MatchError("MOVED 12182 10.0.0.2:6380"))
+		Expect(cluster.RouteKeys(nil, "mget", []resp.CommandArgument{resp.CommandArgument("{bar}x"), resp.CommandArgument("{bar}y")})).To(Succeed())
+		Expect(cluster.RouteKeys(nil, "mget", []resp.CommandArgument{resp.CommandArgument("bar"), resp.CommandArgument("foo")})).To(MatchError("CROSSSLOT Keys in request don't hash to the same slot"))
+
+		lis := redeotest.NewBufferedListener()
+		defer lis.Close()
+
+		srv := NewServer(&Config{KeyRouter: cluster.RouteKeys})
+		srv.HandleFunc("mget", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendAny(c.Keys())
+		}, Keys(1, -1, 1))
+		go srv.Serve(lis)
+
+		cn, _, err := lis.Dial()
+		Expect(err).NotTo(HaveOccurred())
+		c := redeotest.NewClient(cn)
+		defer c.Close()
+
+		Expect(c.Do("MGET", "bar", "{bar}.x")).To(Equal([]interface{}{"bar", "{bar}.x"}))
+		Expect(c.Do("MGET", "foo")).To(Equal(redeotest.ErrorResponse("MOVED 12182 10.0.0.2:6380")))
+	})
+
+	It("should serve CLUSTER INFO", func() {
+		Expect(serve("info").Response()).To(Equal("cluster_enabled:1\r\n" +
+			"cluster_state:ok\r\n" +
+			"cluster_slots_assigned:16384\r\n" +
+			"cluster_slots_ok:16384\r\n" +
+			"cluster_slots_pfail:0\r\n" +
+			"cluster_slots_fail:0\r\n" +
+			"cluster_known_nodes:3\r\n" +
+			"cluster_size:2\r\n"))
+		Expect(serve("myid").Response()).To(Equal("aaaa"))
+		Expect(serve("keyslot", "foo").Response()).To(Equal(int64(12182)))
+		Expect(serve("keyslot").Response()).To(MatchError("ERR wrong number of arguments for 'CLUSTER keyslot' command"))
+		Expect(subject.Info().String()).To(ContainSubstring("# Cluster\ncluster_enabled:1\n"))
+	})
+
+	It("should serve CLUSTER SLOTS", func() {
+		Expect(serve("slots").Response()).To(Equal([]interface{}{
+			[]interface{}{int64(0), int64(8191),
+				[]interface{}{"10.0.0.1", int64(6379), "aaaa"},
+				[]interface{}{"10.0.0.3", int64(6381), "cccc"},
+			},
+			[]interface{}{int64(8192), int64(16383),
+				[]interface{}{"10.0.0.2", int64(6380), "bbbb"},
+			},
+		}))
+	})
+
+	It("should serve CLUSTER SHARDS", func() {
+		node := func(n ClusterNode, role string) []interface{} {
+			return []interface{}{
+				"id", n.ID, "port", int64(n.Port), "ip", n.Host,
+				"endpoint", n.Host, "role", role, "health", "online",
+			}
+		}
+		Expect(serve("shards").Response()).To(Equal([]interface{}{
+			[]interface{}{
+				"slots", []interface{}{int64(0), int64(8191)},
+				"nodes", []interface{}{node(nodeA, "master"), node(nodeC, "replica")},
+			},
+			[]interface{}{
+				"slots", []interface{}{int64(8192), int64(16383)},
+				"nodes", []interface{}{node(nodeB, "master")},
+			},
+		}))
+	})
+
+})
+
+var _ = DescribeTable("KeySlot",
+	func(key string, slot int) {
+		Expect(KeySlot(key)).To(Equal(slot))
+	},
+
+	Entry("empty", "", 0),
+	Entry("plain", "foo", 12182),
+	Entry("plain", "somekey", 11058),
+	Entry("hash tag", "{foo}.bar", 12182),
+	Entry("first hash tag", "{foo}{bar}", 12182),
+	Entry("empty hash tag", "{}foo", int(crc16("{}foo")%ClusterSlots)),
+	Entry("unclosed hash tag", "{foo", int(crc16("{foo")%ClusterSlots)),
+	Entry("same tag", "{user1000}.following", KeySlot("{user1000}.followers")),
+)
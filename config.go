@@ -1,17 +1,64 @@
 package redeo
 
-import "time"
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
 
 // Config holds the server configuration
 type Config struct {
 	// Timeout represents the per-request socket read/write timeout.
+	// Unless IdleTimeout is set, it also applies while waiting for
+	// the next request. Command contexts expire with the connection
+	// deadline, so long-running handlers can stop early.
 	// Default: 0 (disabled)
 	Timeout time.Duration
 
 	// IdleTimeout forces servers to close idle connection once timeout is reached.
+	// It only applies while waiting for the next request, handlers may run
+	// for longer.
 	// Default: 0 (disabled)
 	IdleTimeout time.Duration
 
+	// ReadTimeout is the maximum time without progress while a pipeline
+	// is read, e.g. the arguments of streamed commands. Unlike Timeout,
+	// it does not limit the execution of handlers. If set, it takes
+	// precedence over Timeout for reads.
+	// Default: 0 (disabled)
+	ReadTimeout time.Duration
+
+	// WriteTimeout is applied as a write deadline before replies are
+	// flushed. If set, it takes precedence over Timeout for writes.
+	// Default: 0 (disabled)
+	WriteTimeout time.Duration
+
+	// OnClientError is called when a connection is dropped due to a read,
+	// write or protocol error, or killed via Server.KillClient with a
+	// *KilledError. It is called at most once per connection.
+	// Default: nil (disabled)
+	OnClientError func(c *ClientInfo, err error)
+
+	// OnConnect is called with each new client before its first command
+	// is read, e.g. to allow-list addresses or to attach state via
+	// Client.Set. Clients are rejected if an error is returned, the error
+	// message is sent as the reply, e.g. "ERR not allowed".
+	// Default: nil (disabled)
+	OnConnect func(c *Client) error
+
+	// OnDisconnect is called once a client accepted by OnConnect has
+	// disconnected.
+	// Default: nil (disabled)
+	OnDisconnect func(c *Client)
+
 	// If non-zero, use SO_KEEPALIVE to send TCP ACKs to clients in absence
 	// of communication. This is useful for two reasons:
 	// 1) Detect dead peers.
@@ -22,4 +69,597 @@ type Config struct {
 	// On other kernels the period depends on the kernel configuration.
 	// Default: 0 (disabled)
 	TCPKeepAlive time.Duration
+
+	// TLSConfig enables TLS on connections accepted via Serve. Client
+	// certificates are verified according to TLSConfig.ClientAuth, see
+	// Client.TLSConnectionState.
+	// Default: nil (disabled)
+	TLSConfig *tls.Config
+
+	// ConnWrapper wraps connections accepted via Serve or a Router, e.g.
+	// to layer compression, PROXY protocol unwrapping or custom
+	// encryption. It is applied after TCPKeepAlive is set on the
+	// underlying connection and before TLS. It is called by the accept
+	// loop and must not block, handshakes should happen on first use.
+	// Default: nil (disabled)
+	ConnWrapper func(net.Conn) net.Conn
+
+	// AcceptProxyProtocol expects connections accepted via Serve or a
+	// Router to start with a PROXY protocol v1 or v2 header, as sent by
+	// HAProxy and other L4 load balancers. The client address of the
+	// header is reported by Client.RemoteAddr, CLIENT LIST and applies
+	// to rate limits. Connections without a valid header are closed.
+	// The header is read within Timeout, before TLS.
+	// Default: false (disabled)
+	AcceptProxyProtocol bool
+
+	// Transactions enables built-in handling of MULTI, EXEC, DISCARD, WATCH
+	// and UNWATCH. Commands sent after MULTI are queued and replayed on EXEC.
+	// Keys are watched for modifications reported via Server.Touch.
+	// EXEC checks watched keys and replays the queued commands atomically:
+	// commands of other clients wait until it has completed. Asynchronous
+	// handlers are only excluded while ServeRedeoAsync runs, not until
+	// their promise is resolved.
+	// Default: false (disabled)
+	Transactions bool
+
+	// Databases is the number of logical databases clients may SELECT.
+	// Default: 16
+	Databases int
+
+	// PanicHandler is called with the command name and the recovered value
+	// when a handler panics, e.g. to log stack traces via debug.Stack().
+	// Default: nil (disabled)
+	PanicHandler func(cmdName string, recovered interface{})
+
+	// PanicReply returns the error reply sent when a handler panics. Clients
+	// are disconnected instead, if the handler has already written parts
+	// of its reply.
+	// Default: "ERR internal error: <recovered>"
+	PanicReply func(cmdName string, recovered interface{}) string
+
+	// AcceptErrorHandler is called with temporary errors returned by the
+	// listener. The server backs off and keeps accepting connections.
+	// Default: nil (disabled)
+	AcceptErrorHandler func(err error)
+
+	// AdminAddr is the address of an HTTP server for health checks, stats
+	// and profiling, started with the first call to Serve, see
+	// Server.AdminHandler.
+	// Default: "" (disabled)
+	AdminAddr string
+
+	// CheckOrigin returns true if a WebSocket upgrade with an Origin
+	// header is accepted, see Server.WebSocketHandler. Requests without
+	// an Origin header, i.e. not sent by browsers, are always accepted.
+	// Default: nil (same origin only, the Origin host must match Host)
+	CheckOrigin func(r *http.Request) bool
+
+	// ListenConfig is used by ListenAndServe and ListenAndServeTLS to
+	// create TCP listeners, e.g. to set socket options via its Control
+	// hook.
+	// Default: nil (net.Listen defaults)
+	ListenConfig *net.ListenConfig
+
+	// AcceptLoops makes ListenAndServe and ListenAndServeTLS open the given
+	// number of listeners on the same address via SO_REUSEPORT, each with
+	// its own accept loop, to spread the accept load across cores. Not
+	// supported on all platforms.
+	// Default: 1
+	AcceptLoops int
+
+	// MaxClients limits the number of concurrently connected clients.
+	// New connections over the limit receive an error and are closed.
+	// Default: 0 (unlimited)
+	MaxClients int
+
+	// MaxClientsWait makes the server wait for a free slot instead of
+	// rejecting connections once MaxClients is reached.
+	// Default: false
+	MaxClientsWait bool
+
+	// SlowLogThreshold is the execution time above which commands are
+	// recorded in the slow log.
+	// Default: 0 (disabled)
+	SlowLogThreshold time.Duration
+
+	// SlowLogMaxLen is the maximum number of slow log entries.
+	// Default: 128
+	SlowLogMaxLen int
+
+	// LatencyMonitorThreshold is the latency from which command executions,
+	// flushes and connection handshakes are recorded by the latency
+	// monitor, see Server.LatencyMonitor.
+	// Default: 0 (disabled)
+	LatencyMonitorThreshold time.Duration
+
+	// StreamCheckpointTTL is the time the progress of interrupted resumable
+	// streams is retained, see Resumable.
+	// Default: 10m
+	StreamCheckpointTTL time.Duration
+
+	// AccessLog receives an entry for each executed command, see
+	// NewAccessLogger.
+	// Default: nil (disabled)
+	AccessLog AccessLogger
+
+	// AccessLogSampling logs only one in every N commands, to limit the
+	// volume of high-QPS servers.
+	// Default: 1 (all commands)
+	AccessLogSampling int
+
+	// RequirePass requires clients to authenticate via AUTH or HELLO
+	// before any other command is accepted.
+	// Default: "" (disabled)
+	RequirePass string
+
+	// AuthFunc verifies the credentials of AUTH and HELLO commands and
+	// takes precedence over RequirePass. The username of the single
+	// argument AUTH form is "default".
+	// Default: nil (disabled)
+	AuthFunc func(username, password string) bool
+
+	// Authorizer is consulted before each command is executed and may
+	// deny commands per client, e.g. via CommandRules or UserRules.
+	// AUTH, HELLO authentication and QUIT are not subject to it.
+	// Default: nil (disabled)
+	Authorizer Authorizer
+
+	// KeyRouter is consulted before commands with key arguments are
+	// executed, see Keys, and may reject commands whose keys are not
+	// served by this node, e.g. with a MovedError, see Cluster.RouteKeys.
+	// The returned error is sent as reply instead.
+	// Default: nil (disabled)
+	KeyRouter func(c *Client, name string, keys []resp.CommandArgument) error
+
+	// ReadOnly rejects commands registered with the "write" flag with
+	// ErrReadOnly, e.g. when running as a read replica. It can be toggled
+	// at runtime, see Server.SetReadOnly.
+	// Default: false
+	ReadOnly bool
+
+	// ShardedDispatch enables the execution of commands on a pool of
+	// worker goroutines, selected by the command key. Independent
+	// commands of a pipeline, registered with the "parallel" flag, are
+	// executed concurrently. Replies are written in request order.
+	// Default: nil (disabled)
+	ShardedDispatch *ShardConfig
+
+	// AsyncTimeout is the time after which pending promises of
+	// asynchronous commands are replied to with an error.
+	// Default: 0 (disabled)
+	AsyncTimeout time.Duration
+
+	// AsyncHighWaterMark is the number of bytes of out-of-order replies
+	// of asynchronous commands a client may buffer. Once exceeded, no
+	// further commands are read until earlier commands have completed.
+	// Default: 1MiB
+	AsyncHighWaterMark int
+
+	// RateLimit limits the number of commands per second of each client.
+	// Commands over the limit are delayed.
+	// Default: 0 (unlimited)
+	RateLimit float64
+
+	// RateLimitBurst is the number of commands a client may send at once
+	// before RateLimit applies.
+	// Default: 1
+	RateLimitBurst int
+
+	// RateLimitMaxDelay is the maximum delay of commands over RateLimit.
+	// Commands which would be delayed for longer are rejected with an error.
+	// Default: 0 (no limit)
+	RateLimitMaxDelay time.Duration
+
+	// MaxOutputBuffer is the maximum number of bytes of unflushed and
+	// pending replies of a client. Clients over the limit are disconnected.
+	// Default: 0 (unlimited)
+	MaxOutputBuffer int
+
+	// OutputBufferSoftLimit is the number of bytes of unflushed and pending
+	// replies a client may exceed for at most OutputBufferSoftPeriod.
+	// Clients over the limit for longer are disconnected.
+	// Default: 0 (unlimited)
+	OutputBufferSoftLimit int
+
+	// OutputBufferSoftPeriod is the time a client may stay over the
+	// OutputBufferSoftLimit.
+	// Default: 0 (disconnect immediately)
+	OutputBufferSoftPeriod time.Duration
+
+	// Workers limits the number of clients executing commands at the same
+	// time, further clients wait for a free worker once they have sent a
+	// request. Idle clients return their read buffers to a shared pool,
+	// which reduces the memory used by many mostly idle connections.
+	// Default: 0 (unlimited)
+	Workers int
+
+	// ReadBufferSize is the initial size of the per-connection read buffer.
+	// It grows to fit large commands.
+	// Default: 64KiB
+	ReadBufferSize int
+
+	// MaxBulkLength is the maximum length of a request argument in bytes
+	// and MaxMultiBulkLength the maximum number of elements of a request.
+	// Clients sending larger requests receive a protocol error and are
+	// disconnected, before their requests are buffered. Negative values
+	// disable the respective limit.
+	// Default: 512MiB and 1048576, as in Redis
+	MaxBulkLength      int64
+	MaxMultiBulkLength int
+
+	// WriteBufferSize is the size of the per-connection write buffer.
+	// Replies are flushed once half of it is filled.
+	// Default: 64KiB
+	WriteBufferSize int
+
+	// Flush determines when replies are flushed while a pipeline is
+	// executed. Replies are always flushed once a pipeline is complete.
+	// Default: once half of the write buffer is filled
+	Flush FlushPolicy
+
+	// OnChange is called with the parameter name and the new value after
+	// a setting was changed at runtime, see Server.SetConfig.
+	// Default: nil (disabled)
+	OnChange func(name, value string)
+
+	// Hooks allow to trace connections and commands.
+	// Default: none
+	Hooks Hooks
+
+	// TraceArg enables the propagation of trace contexts by clients. If
+	// set, e.g. to "TRACEPARENT", a trailing pair of the given name and
+	// a value, e.g. a W3C traceparent, is removed from the arguments of
+	// commands and made available via TraceParent. Streamed commands are
+	// not supported.
+	// Default: "" (disabled)
+	TraceArg string
+}
+
+// FlushPolicy determines when buffered replies are written to clients,
+// trading throughput for lower latencies of individual replies.
+type FlushPolicy struct {
+	// Replies flushes after the given number of commands.
+	// Default: 0 (disabled)
+	Replies int
+
+	// Interval flushes buffered replies periodically, from a goroutine
+	// per client, e.g. while slow commands of a pipeline are running.
+	// Default: 0 (disabled)
+	Interval time.Duration
+
+	// Manual disables all of the above, including size-based flushes.
+	// Handlers flush explicitly via ResponseWriter.Flush, set a
+	// MaxOutputBuffer to limit the memory used by long pipelines.
+	Manual bool
+}
+
+// Hooks are optional callbacks, e.g. to integrate with tracing libraries.
+type Hooks struct {
+	// OnConnect is called with the remote address when a client connects.
+	// The returned context becomes the client context, see Client.Context.
+	OnConnect func(remoteAddr string) context.Context
+
+	// OnCommandStart is called before a command is executed with the
+	// command context, the normalised name and the number of arguments.
+	// GetClient can be used to retrieve the client from ctx. The returned
+	// context is passed to the handler, the returned function is called
+	// once the command has completed, with the handler's error reply, the
+	// protocol error or nil.
+	OnCommandStart func(ctx context.Context, name string, args int) (context.Context, func(err error))
+}
+
+// --------------------------------------------------------------------
+
+// configParam is a runtime-mutable setting, as exposed via CONFIG.
+type configParam struct {
+	get func(*Config) string
+	set func(*Config, string) bool
+}
+
+// configParams are the settings supported by CONFIG GET and SET,
+// formatted like redis.
+var configParams = map[string]configParam{
+	// redis' timeout closes idle clients, equivalent to IdleTimeout
+	"timeout": {
+		get: func(c *Config) string { return formatSeconds(c.IdleTimeout) },
+		set: func(c *Config, v string) bool { return parseSeconds(v, &c.IdleTimeout) },
+	},
+	"tcp-keepalive": {
+		get: func(c *Config) string { return formatSeconds(c.TCPKeepAlive) },
+		set: func(c *Config, v string) bool { return parseSeconds(v, &c.TCPKeepAlive) },
+	},
+	"maxclients": {
+		get: func(c *Config) string { return strconv.Itoa(c.MaxClients) },
+		set: func(c *Config, v string) bool {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return false
+			}
+			c.MaxClients = n
+			return true
+		},
+	},
+	"requirepass": {
+		get: func(c *Config) string { return c.RequirePass },
+		set: func(c *Config, v string) bool { c.RequirePass = v; return true },
+	},
+	// "normal <hard> <soft> <seconds>", see MaxOutputBuffer and
+	// OutputBufferSoftLimit; other client classes are not supported
+	"client-output-buffer-limit": {
+		get: func(c *Config) string {
+			return "normal " + strconv.Itoa(c.MaxOutputBuffer) + " " + strconv.Itoa(c.OutputBufferSoftLimit) + " " + formatSeconds(c.OutputBufferSoftPeriod)
+		},
+		set: func(c *Config, v string) bool {
+			f := strings.Fields(v)
+			if len(f) != 4 || strings.ToLower(f[0]) != "normal" {
+				return false
+			}
+			hard, err1 := strconv.Atoi(f[1])
+			soft, err2 := strconv.Atoi(f[2])
+			if err1 != nil || err2 != nil || hard < 0 || soft < 0 || !parseSeconds(f[3], &c.OutputBufferSoftPeriod) {
+				return false
+			}
+			c.MaxOutputBuffer, c.OutputBufferSoftLimit = hard, soft
+			return true
+		},
+	},
+	"replica-read-only": {
+		get: func(c *Config) string { return formatBool(c.ReadOnly) },
+		set: func(c *Config, v string) bool { return parseBool(v, &c.ReadOnly) },
+	},
+	// in milliseconds, 0 disables the latency monitor
+	"latency-monitor-threshold": {
+		get: func(c *Config) string {
+			return strconv.FormatInt(int64(c.LatencyMonitorThreshold/time.Millisecond), 10)
+		},
+		set: func(c *Config, v string) bool {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return false
+			}
+			c.LatencyMonitorThreshold = time.Duration(n) * time.Millisecond
+			return true
+		},
+	},
+	// in microseconds, 0 disables the slow log
+	"slowlog-log-slower-than": {
+		get: func(c *Config) string { return strconv.FormatInt(int64(c.SlowLogThreshold/time.Microsecond), 10) },
+		set: func(c *Config, v string) bool {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return false
+			}
+			c.SlowLogThreshold = time.Duration(n) * time.Microsecond
+			return true
+		},
+	},
+}
+
+// Tunable is an application-defined setting, exposed via CONFIG GET and
+// SET, see Server.RegisterConfig.
+type Tunable struct {
+	// Get returns the current value.
+	Get func() string
+	// Validate is called before any setting is changed and may reject
+	// the value. Optional.
+	Validate func(value string) error
+	// Set applies a validated value.
+	Set func(value string)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
+
+func parseSeconds(v string, d *time.Duration) bool {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return false
+	}
+	*d = time.Duration(n) * time.Second
+	return true
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func parseBool(v string, b *bool) bool {
+	switch strings.ToLower(v) {
+	case "yes":
+		*b = true
+	case "no":
+		*b = false
+	default:
+		return false
+	}
+	return true
+}
+
+// conf returns the current configuration snapshot.
+func (srv *Server) conf() *Config { return srv.snapshot.Load().(*Config) }
+
+// databases returns the number of logical databases.
+func (srv *Server) databases() int {
+	if n := srv.conf().Databases; n > 0 {
+		return n
+	}
+	return defaultDatabases
+}
+
+// RegisterConfig adds a custom setting, which can be inspected and
+// changed via CONFIG GET and SET alongside the built-in ones. Names are
+// case-insensitive and must not clash with built-in settings.
+func (srv *Server) RegisterConfig(name string, t Tunable) {
+	name = strings.ToLower(name)
+	if _, ok := configParams[name]; ok {
+		panic("redeo: cannot override built-in config " + name)
+	}
+
+	srv.configMu.Lock()
+	if srv.tunables == nil {
+		srv.tunables = make(map[string]Tunable)
+	}
+	srv.tunables[name] = t
+	srv.configMu.Unlock()
+}
+
+// GetConfig returns the values of the runtime-mutable settings matching
+// the glob-style pattern, as reported by CONFIG GET.
+func (srv *Server) GetConfig(pattern string) map[string]string {
+	match := CompilePattern(strings.ToLower(pattern)).Match
+	conf := srv.conf()
+
+	res := make(map[string]string)
+	for name, p := range configParams {
+		if match(name) {
+			res[name] = p.get(conf)
+		}
+	}
+
+	srv.configMu.Lock()
+	for name, t := range srv.tunables {
+		if match(name) {
+			res[name] = t.Get()
+		}
+	}
+	srv.configMu.Unlock()
+	return res
+}
+
+// SetConfig changes a setting at runtime, like CONFIG SET. Changes apply
+// to subsequent connections and commands. Supported are timeout,
+// tcp-keepalive, maxclients, requirepass, client-output-buffer-limit,
+// replica-read-only, latency-monitor-threshold, slowlog-log-slower-than
+// and settings added via RegisterConfig.
+func (srv *Server) SetConfig(name, value string) error {
+	return srv.setConfig(name, value)
+}
+
+// SetReadOnly switches the server to read-only mode and back at runtime,
+// e.g. during failovers, see Config.ReadOnly.
+func (srv *Server) SetReadOnly(on bool) {
+	_ = srv.setConfig("replica-read-only", formatBool(on))
+}
+
+// setConfig applies name/value pairs atomically, either all
+// settings are changed or none.
+func (srv *Server) setConfig(pairs ...string) error {
+	srv.configMu.Lock()
+	conf := *srv.conf()
+	var custom []int
+	for i := 0; i+1 < len(pairs); i += 2 {
+		name, value := strings.ToLower(pairs[i]), pairs[i+1]
+		if t, ok := srv.tunables[name]; ok {
+			if t.Validate != nil {
+				if err := t.Validate(value); err != nil {
+					srv.configMu.Unlock()
+					return errors.New("ERR Invalid argument '" + value + "' for CONFIG SET '" + pairs[i] + "' - " + err.Error())
+				}
+			}
+			custom = append(custom, i)
+			continue
+		}
+
+		p, ok := configParams[name]
+		if !ok {
+			srv.configMu.Unlock()
+			return errors.New("ERR Unknown option or number of arguments for CONFIG SET - '" + pairs[i] + "'")
+		}
+		if !p.set(&conf, value) {
+			srv.configMu.Unlock()
+			return errors.New("ERR Invalid argument '" + value + "' for CONFIG SET '" + pairs[i] + "'")
+		}
+	}
+	srv.snapshot.Store(&conf)
+	for _, i := range custom {
+		srv.tunables[strings.ToLower(pairs[i])].Set(pairs[i+1])
+	}
+	srv.configMu.Unlock()
+
+	// wake up accepts waiting for a free slot
+	srv.slotsMu.Lock()
+	srv.slotsCond.Broadcast()
+	srv.slotsMu.Unlock()
+
+	if fn := conf.OnChange; fn != nil {
+		for i := 0; i+1 < len(pairs); i += 2 {
+			fn(strings.ToLower(pairs[i]), pairs[i+1])
+		}
+	}
+	return nil
+}
+
+// configCommand returns a CONFIG handler.
+// https://redis.io/commands/config-get
+func configCommand(srv *Server) Handler {
+	sc := NewSubCommands()
+	sc.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() == 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		res := make(map[string]string)
+		for _, arg := range c.Args {
+			for name, value := range srv.GetConfig(arg.String()) {
+				res[name] = value
+			}
+		}
+
+		names := make([]string, 0, len(res))
+		for name := range res {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.AppendMapLen(len(names))
+		for _, name := range names {
+			w.AppendBulkString(name)
+			w.AppendBulkString(res[name])
+		}
+	})
+	sc.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() == 0 || c.ArgN()%2 != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		pairs := make([]string, 0, c.ArgN())
+		for _, arg := range c.Args {
+			pairs = append(pairs, arg.String())
+		}
+		if err := srv.setConfig(pairs...); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		w.AppendOK()
+	})
+	sc.HandleFunc("rewrite", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		if err := srv.RewriteConfig(); err == errNoConfigFile {
+			w.AppendError("ERR The server is running without a config file")
+			return
+		} else if err != nil {
+			w.AppendError("ERR Rewriting config file: " + err.Error())
+			return
+		}
+		w.AppendOK()
+	})
+	sc.HandleFunc("resetstat", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		srv.info.ResetStats()
+		w.AppendOK()
+	})
+	return sc
 }
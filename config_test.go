@@ -0,0 +1,168 @@
+package redeo
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var subject *Server
+	var lis net.Listener
+	var changes []string
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		changes = nil
+		subject = NewServer(&Config{
+			IdleTimeout: 30 * time.Second,
+			OnChange: func(name, value string) {
+				changes = append(changes, name+"="+value)
+			},
+		})
+		subject.Handle("ping", Ping())
+		subject.HandleConfig()
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should get settings", func() {
+		cn, w, r := dialRaw(lis)
+		defer cn.Close()
+
+		w.WriteCmdString("CONFIG", "GET", "*")
+		w.WriteCmdString("CONFIG", "get", "TIME*", "max*")
+		Expect(w.Flush()).To(Succeed())
+
+		Expect(r.ReadArrayLen()).To(Equal(16))
+		for _, s := range []string{"client-output-buffer-limit", "normal 0 0 0", "latency-monitor-threshold", "0", "maxclients", "0", "replica-read-only", "no", "requirepass", "", "slowlog-log-slower-than", "0", "tcp-keepalive", "0", "timeout", "30"} {
+			Expect(r.ReadBulkString()).To(Equal(s))
+		}
+
+		Expect(r.ReadArrayLen()).To(Equal(4))
+		for _, s := range []string{"maxclients", "0", "timeout", "30"} {
+			Expect(r.ReadBulkString()).To(Equal(s))
+		}
+	})
+
+	It("should set settings", func() {
+		cn, w, r := dialRaw(lis)
+		defer cn.Close()
+
+		w.WriteCmdString("CONFIG", "SET", "timeout", "0", "TCP-KEEPALIVE", "60")
+		w.WriteCmdString("CONFIG", "SET", "bogus", "1")
+		w.WriteCmdString("CONFIG", "SET", "maxclients", "2", "timeout", "x")
+		w.WriteCmdString("CONFIG", "SET", "timeout")
+		Expect(w.Flush()).To(Succeed())
+
+		Expect(r.ReadInlineString()).To(Equal("OK"))
+		Expect(r.ReadError()).To(Equal("ERR Unknown option or number of arguments for CONFIG SET - 'bogus'"))
+		Expect(r.ReadError()).To(Equal("ERR Invalid argument 'x' for CONFIG SET 'timeout'"))
+		Expect(r.ReadError()).To(Equal("ERR wrong number of arguments for 'CONFIG SET' command"))
+
+		Expect(subject.conf().IdleTimeout).To(Equal(time.Duration(0)))
+		Expect(subject.conf().TCPKeepAlive).To(Equal(time.Minute))
+		Expect(subject.conf().MaxClients).To(Equal(0))
+		Expect(subject.config.IdleTimeout).To(Equal(30 * time.Second))
+		Expect(changes).To(Equal([]string{"timeout=0", "tcp-keepalive=60"}))
+	})
+
+	It("should set custom settings", func() {
+		level := "info"
+		subject.RegisterConfig("LogLevel", Tunable{
+			Get: func() string { return level },
+			Validate: func(v string) error {
+				if v != "info" && v != "debug" {
+					return errors.New("unsupported level")
+				}
+				return nil
+			},
+			Set: func(v string) { level = v },
+		})
+		Expect(func() { subject.RegisterConfig("timeout", Tunable{}) }).To(Panic())
+
+		Expect(subject.SetConfig("slowlog-log-slower-than", "2500")).To(Succeed())
+		Expect(subject.conf().SlowLogThreshold).To(Equal(2500 * time.Microsecond))
+
+		cn, w, r := dialRaw(lis)
+		defer cn.Close()
+
+		w.WriteCmdString("CONFIG", "SET", "loglevel", "debug", "timeout", "x")
+		w.WriteCmdString("CONFIG", "SET", "timeout", "5", "loglevel", "trace")
+		w.WriteCmdString("CONFIG", "SET", "loglevel", "debug", "timeout", "5")
+		w.WriteCmdString("CONFIG", "GET", "log*")
+		Expect(w.Flush()).To(Succeed())
+
+		Expect(r.ReadError()).To(Equal("ERR Invalid argument 'x' for CONFIG SET 'timeout'"))
+		Expect(r.ReadError()).To(Equal("ERR Invalid argument 'trace' for CONFIG SET 'loglevel' - unsupported level"))
+		Expect(r.ReadInlineString()).To(Equal("OK"))
+		Expect(r.ReadArrayLen()).To(Equal(2))
+		Expect(r.ReadBulkString()).To(Equal("loglevel"))
+		Expect(r.ReadBulkString()).To(Equal("debug"))
+
+		Expect(subject.conf().IdleTimeout).To(Equal(5 * time.Second))
+		Expect(changes).To(ContainElement("loglevel=debug"))
+	})
+
+	It("should apply settings to new connections", func() {
+		Expect(subject.SetConfig("maxclients", "1")).To(Succeed())
+
+		cn1, w1, r1 := dialRaw(lis)
+		defer cn1.Close()
+		w1.WriteCmd("PING")
+		Expect(w1.Flush()).To(Succeed())
+		Expect(r1.ReadInlineString()).To(Equal("PONG"))
+
+		cn2, _, r2 := dialRaw(lis)
+		defer cn2.Close()
+		Expect(r2.ReadError()).To(Equal("ERR max number of clients reached"))
+
+		Expect(subject.SetConfig("maxclients", "0")).To(Succeed())
+		cn3, w3, r3 := dialRaw(lis)
+		defer cn3.Close()
+		w3.WriteCmd("PING")
+		Expect(w3.Flush()).To(Succeed())
+		Expect(r3.ReadInlineString()).To(Equal("PONG"))
+	})
+
+	It("should change settings while serving", func() {
+		srv := subject
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_ = srv.SetConfig("timeout", strconv.Itoa(10+i%10))
+				time.Sleep(time.Microsecond)
+			}
+		}()
+		defer wg.Wait()
+		defer close(done)
+
+		cn, w, r := dialRaw(lis)
+		defer cn.Close()
+		for i := 0; i < 100; i++ {
+			w.WriteCmd("PING")
+			Expect(w.Flush()).To(Succeed())
+			Expect(r.ReadInlineString()).To(Equal("PONG"))
+		}
+	})
+
+})
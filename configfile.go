@@ -0,0 +1,358 @@
+package redeo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+var errNoConfigFile = errors.New("redeo: no config file loaded")
+
+// staticDirectives are applied by the application at startup, e.g. via
+// ConfigFile.Addr, and are skipped when settings are applied.
+var staticDirectives = map[string]bool{
+	"bind":       true,
+	"port":       true,
+	"unixsocket": true,
+}
+
+// ConfigFile holds the directives of a redis.conf-style file, see
+// ReadConfigFile.
+type ConfigFile struct {
+	// Path is the path of the file.
+	Path string
+
+	// Directives are the values by lower-cased name. The arguments of
+	// directives with several are joined by spaces, repeated directives
+	// keep the last value.
+	Directives map[string]string
+}
+
+// ReadConfigFile reads a redis.conf-style file with one directive per
+// line, a name followed by its arguments, which may be quoted. Empty
+// lines and comments starting with # are skipped.
+func ReadConfigFile(path string) (*ConfigFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &ConfigFile{Path: path, Directives: make(map[string]string)}
+	err = scanConfig(data, func(_ string, name, value string) {
+		if name != "" {
+			f.Directives[name] = value
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("redeo: %s: %v", path, err)
+	}
+	return f, nil
+}
+
+// Get returns the value of a directive.
+func (f *ConfigFile) Get(name string) (string, bool) {
+	v, ok := f.Directives[strings.ToLower(name)]
+	return v, ok
+}
+
+// Addr returns the address to listen on, as configured via bind and
+// port. Only the first bind address is used.
+// Default: ":6379"
+func (f *ConfigFile) Addr() string {
+	var host string
+	if v := strings.Fields(f.Directives["bind"]); len(v) != 0 {
+		host = v[0]
+	}
+	port := f.Directives["port"]
+	if port == "" {
+		port = "6379"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// pairs returns the name/value pairs of the runtime-mutable settings.
+func (f *ConfigFile) pairs() []string {
+	names := make([]string, 0, len(f.Directives))
+	for name := range f.Directives {
+		if !staticDirectives[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		pairs = append(pairs, name, f.Directives[name])
+	}
+	return pairs
+}
+
+// scanConfig calls fn with each line and the directive it contains, if any.
+func scanConfig(data []byte, fn func(line, name, value string)) error {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			fn(line, "", "")
+			continue
+		}
+
+		cmd, err := resp.NewRequestReader(strings.NewReader(trimmed + "\r\n")).ReadCmd(nil)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+
+		args := make([]string, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			args[i] = arg.String()
+		}
+		fn(line, strings.ToLower(cmd.Name), strings.Join(args, " "))
+	}
+	return s.Err()
+}
+
+// formatDirective formats a directive, quoting the value if necessary.
+func formatDirective(name, value string) string {
+	if value == "" {
+		return name + ` ""`
+	}
+	for _, arg := range strings.Split(value, " ") {
+		if arg == "" || strings.ContainsAny(arg, "\"'\\#") || strconv.Quote(arg) != `"`+arg+`"` {
+			return name + " " + strconv.Quote(value)
+		}
+	}
+	return name + " " + value
+}
+
+// --------------------------------------------------------------------
+
+// LoadConfig reads a redis.conf-style file, see ReadConfigFile, and
+// applies its settings, like CONFIG SET. Settings added via
+// RegisterConfig must be registered beforehand, bind, port and unixsocket
+// are left to the application, see ConfigFile.Addr. Unknown directives
+// are rejected. The file is retained for ReloadConfig and CONFIG REWRITE.
+func (srv *Server) LoadConfig(path string) (*ConfigFile, error) {
+	f, err := ReadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.applyConfig(f); err != nil {
+		return nil, err
+	}
+
+	srv.configMu.Lock()
+	srv.configPath = path
+	srv.configMu.Unlock()
+	return f, nil
+}
+
+// ReloadConfig re-reads the file passed to LoadConfig and applies its
+// settings, either all or none, without interrupting clients.
+func (srv *Server) ReloadConfig() error {
+	path := srv.configFile()
+	if path == "" {
+		return errNoConfigFile
+	}
+
+	f, err := ReadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	return srv.applyConfig(f)
+}
+
+// WatchConfig reloads the file passed to LoadConfig whenever the process
+// receives SIGHUP and, if interval is positive, once the file was modified,
+// polling at the given interval. Errors are passed to onError, if set.
+// Call the returned function to stop watching.
+func (srv *Server) WatchConfig(interval time.Duration, onError func(error)) (stop func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+		tick = ticker.C
+	}
+
+	mtime := srv.configModTime()
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sig:
+			case <-tick:
+				m := srv.configModTime()
+				if m.Equal(mtime) {
+					continue
+				}
+				mtime = m
+			}
+
+			if err := srv.ReloadConfig(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sig)
+			if ticker != nil {
+				ticker.Stop()
+			}
+			close(done)
+		})
+	}
+}
+
+// RewriteConfig writes the current runtime-mutable settings to the file
+// passed to LoadConfig, like CONFIG REWRITE. Comments and other
+// directives are retained, settings missing from the file are appended.
+func (srv *Server) RewriteConfig() error {
+	path := srv.configFile()
+	if path == "" {
+		return errNoConfigFile
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	current := srv.GetConfig("*")
+	written := make(map[string]bool, len(current))
+
+	var buf bytes.Buffer
+	err = scanConfig(data, func(line, name, _ string) {
+		value, ok := current[name]
+		switch {
+		case !ok:
+			buf.WriteString(line)
+		case written[name]:
+			return // drop repeated directives
+		default:
+			buf.WriteString(formatDirective(name, value))
+			written[name] = true
+		}
+		buf.WriteByte('\n')
+	})
+	if err != nil {
+		return fmt.Errorf("redeo: %s: %v", path, err)
+	}
+
+	defaults := defaultConfigParams()
+	names := make([]string, 0, len(current))
+	for name := range current {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if def, ok := defaults[name]; written[name] || (ok && def == current[name]) {
+			continue
+		}
+		buf.WriteString(formatDirective(name, current[name]))
+		buf.WriteByte('\n')
+	}
+
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// applyConfig applies the runtime-mutable settings of f.
+func (srv *Server) applyConfig(f *ConfigFile) error {
+	pairs := f.pairs()
+
+	srv.configMu.Lock()
+	for i := 0; i < len(pairs); i += 2 {
+		name := pairs[i]
+		if _, ok := configParams[name]; ok {
+			continue
+		}
+		if _, ok := srv.tunables[name]; ok {
+			continue
+		}
+		srv.configMu.Unlock()
+		return fmt.Errorf("redeo: %s: unknown directive '%s'", f.Path, name)
+	}
+	srv.configMu.Unlock()
+
+	if err := srv.setConfig(pairs...); err != nil {
+		return fmt.Errorf("redeo: %s: %v", f.Path, err)
+	}
+	return nil
+}
+
+// configFile returns the path passed to LoadConfig.
+func (srv *Server) configFile() string {
+	srv.configMu.Lock()
+	path := srv.configPath
+	srv.configMu.Unlock()
+	return path
+}
+
+// configModTime returns the modification time of the config file.
+func (srv *Server) configModTime() time.Time {
+	if path := srv.configFile(); path != "" {
+		if fi, err := os.Stat(path); err == nil {
+			return fi.ModTime()
+		}
+	}
+	return time.Time{}
+}
+
+// defaultConfigParams returns the built-in settings of an empty Config.
+func defaultConfigParams() map[string]string {
+	conf := new(Config)
+	res := make(map[string]string, len(configParams))
+	for name, p := range configParams {
+		res[name] = p.get(conf)
+	}
+	return res
+}
+
+// writeFileAtomic replaces the file at path via a temporary file.
+func writeFileAtomic(path string, data []byte) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, bytes.NewReader(data)); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(fi.Mode()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
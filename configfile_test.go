@@ -0,0 +1,146 @@
+package redeo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config files", func() {
+	var subject *Server
+	var dir, path string
+	var loglevel string
+
+	var write = func(data string) {
+		Expect(ioutil.WriteFile(path, []byte(data), 0600)).To(Succeed())
+	}
+
+	var read = func() string {
+		data, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "redeo-config")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "redis.conf")
+
+		loglevel = "notice"
+		subject = NewServer(nil)
+		subject.RegisterConfig("loglevel", Tunable{
+			Get: func() string { return loglevel },
+			Set: func(v string) { loglevel = v },
+		})
+		subject.HandleConfig()
+
+		write("# example\n" +
+			"bind 127.0.0.1 ::1\n" +
+			"port 7000\n" +
+			"\n" +
+			"timeout 30\n" +
+			"maxclients 10\n" +
+			"requirepass \"s3cret pass\"\n" +
+			"client-output-buffer-limit normal 0 100 60\n" +
+			"LOGLEVEL debug\n")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should load settings", func() {
+		f, err := subject.LoadConfig(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Addr()).To(Equal("127.0.0.1:7000"))
+		port, ok := f.Get("Port")
+		Expect(ok).To(BeTrue())
+		Expect(port).To(Equal("7000"))
+
+		conf := subject.conf()
+		Expect(conf.IdleTimeout).To(Equal(30 * time.Second))
+		Expect(conf.MaxClients).To(Equal(10))
+		Expect(conf.RequirePass).To(Equal("s3cret pass"))
+		Expect(conf.OutputBufferSoftLimit).To(Equal(100))
+		Expect(conf.OutputBufferSoftPeriod).To(Equal(time.Minute))
+		Expect(loglevel).To(Equal("debug"))
+
+		Expect((&ConfigFile{}).Addr()).To(Equal(":6379"))
+	})
+
+	It("should reject invalid files", func() {
+		write("timeout 30\nunknown yes\n")
+		_, err := subject.LoadConfig(path)
+		Expect(err).To(MatchError("redeo: " + path + ": unknown directive 'unknown'"))
+
+		write("timeout x\n")
+		_, err = subject.LoadConfig(path)
+		Expect(err).To(MatchError("redeo: " + path + ": ERR Invalid argument 'x' for CONFIG SET 'timeout'"))
+
+		write("timeout \"30\n")
+		_, err = subject.LoadConfig(path)
+		Expect(err).To(MatchError("redeo: " + path + ": line 1: Protocol error: unbalanced quotes in request"))
+
+		Expect(subject.conf().IdleTimeout).To(BeZero())
+		Expect(subject.ReloadConfig()).To(Equal(errNoConfigFile))
+	})
+
+	It("should reload settings", func() {
+		_, err := subject.LoadConfig(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		errs := make(chan error, 1)
+		stop := subject.WatchConfig(10*time.Millisecond, func(err error) { errs <- err })
+		defer stop()
+
+		write("timeout 60\nloglevel warning\n")
+		future := time.Now().Add(time.Hour)
+		Expect(os.Chtimes(path, future, future)).To(Succeed())
+		Eventually(func() time.Duration { return subject.conf().IdleTimeout }).Should(Equal(time.Minute))
+		Expect(subject.GetConfig("loglevel")).To(HaveKeyWithValue("loglevel", "warning"))
+
+		write("timeout x\n")
+		Expect(os.Chtimes(path, future.Add(time.Hour), future.Add(time.Hour))).To(Succeed())
+		Eventually(errs).Should(Receive(HaveOccurred()))
+		Expect(subject.conf().IdleTimeout).To(Equal(time.Minute))
+	})
+
+	It("should rewrite settings", func() {
+		w := redeotest.NewRecorder()
+		subject.cmds["config"].served.(Handler).ServeRedeo(w, resp.NewCommand("CONFIG", resp.CommandArgument("rewrite")))
+		Expect(w.Response()).To(MatchError("ERR The server is running without a config file"))
+
+		_, err := subject.LoadConfig(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.SetConfig("timeout", "45")).To(Succeed())
+		Expect(subject.SetConfig("tcp-keepalive", "10")).To(Succeed())
+		Expect(subject.SetConfig("requirepass", "")).To(Succeed())
+
+		w = redeotest.NewRecorder()
+		subject.cmds["config"].served.(Handler).ServeRedeo(w, resp.NewCommand("CONFIG", resp.CommandArgument("rewrite")))
+		Expect(w.Response()).To(Equal("OK"))
+		Expect(read()).To(Equal("# example\n" +
+			"bind 127.0.0.1 ::1\n" +
+			"port 7000\n" +
+			"\n" +
+			"timeout 45\n" +
+			"maxclients 10\n" +
+			"requirepass \"\"\n" +
+			"client-output-buffer-limit normal 0 100 60\n" +
+			"loglevel debug\n" +
+			"tcp-keepalive 10\n"))
+
+		Expect(subject.SetConfig("requirepass", "with \"quotes\"")).To(Succeed())
+		Expect(subject.RewriteConfig()).To(Succeed())
+		Expect(subject.ReloadConfig()).To(Succeed())
+		Expect(subject.conf().RequirePass).To(Equal("with \"quotes\""))
+	})
+
+})
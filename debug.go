@@ -0,0 +1,105 @@
+package redeo
+
+import (
+	"bytes"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// debugCommand returns a DEBUG handler.
+// https://redis.io/commands/debug
+func debugCommand() Handler {
+	sc := NewSubCommands()
+	sc.HandleFunc("sleep", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		secs, err := strconv.ParseFloat(c.Arg(0).String(), 64)
+		if err != nil || secs < 0 {
+			w.AppendError("ERR value is not a valid float")
+			return
+		}
+
+		timer := time.NewTimer(time.Duration(secs * float64(time.Second)))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-c.Context().Done():
+		}
+		w.AppendOK()
+	})
+	sc.HandleFunc("quit", func(w resp.ResponseWriter, c *resp.Command) {
+		if client := GetClient(c.Context()); client != nil {
+			client.kill()
+		}
+	})
+	sc.HandleFunc("error", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		w.AppendError(c.Arg(0).String())
+	})
+	sc.HandleFunc("set-active-expire", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		w.AppendOK()
+	})
+	for _, name := range []string{"jmap", "reload"} {
+		sc.HandleFunc(name, func(w resp.ResponseWriter, _ *resp.Command) { w.AppendOK() })
+	}
+
+	// Go specific
+	sc.HandleFunc("goroutines", func(w resp.ResponseWriter, _ *resp.Command) {
+		buf := make([]byte, 64*1024)
+		for {
+			n := runtime.Stack(buf, true)
+			if n < len(buf) {
+				w.AppendBulk(buf[:n])
+				return
+			}
+			buf = make([]byte, 2*len(buf))
+		}
+	})
+	sc.HandleFunc("gc", func(w resp.ResponseWriter, _ *resp.Command) {
+		debug.FreeOSMemory()
+		w.AppendOK()
+	})
+	sc.HandleFunc("pprof", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 && c.ArgN() != 2 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		profile := pprof.Lookup(c.Arg(0).String())
+		if profile == nil {
+			w.AppendError("ERR unknown profile '" + c.Arg(0).String() + "'")
+			return
+		}
+		level := 0
+		if c.ArgN() == 2 {
+			n, err := c.Arg(1).Int()
+			if err != nil {
+				w.AppendError("ERR value is not an integer or out of range")
+				return
+			}
+			level = int(n)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := profile.WriteTo(buf, level); err != nil {
+			w.AppendError(resp.ErrorReply(err))
+			return
+		}
+		w.AppendBulk(buf.Bytes())
+	})
+	return sc
+}
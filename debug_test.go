@@ -0,0 +1,166 @@
+package redeo
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/wangaoone/redeo/client"
+	"github.com/wangaoone/redeo/redeotest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DEBUG", func() {
+	var subject *Server
+	var lis *redeotest.Listener
+	var dropped chan error
+
+	var dial = func() (net.Conn, client.Conn, *redeotest.Conn) {
+		cn, sn, err := lis.Dial()
+		Expect(err).NotTo(HaveOccurred())
+		return cn, client.Wrap(cn), sn
+	}
+
+	BeforeEach(func() {
+		dropped = make(chan error, 1)
+		subject = NewServer(&Config{
+			OnClientError: func(_ *ClientInfo, err error) { dropped <- err },
+		})
+		subject.Handle("ping", Ping())
+		subject.HandleDebug()
+
+		lis = redeotest.NewListener()
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should sleep without blocking other clients", func() {
+		_, c1, _ := dial()
+		defer c1.Close()
+		_, c2, _ := dial()
+		defer c2.Close()
+
+		start := time.Now()
+		c1.Append("DEBUG", "SLEEP", "0.1")
+		Expect(c1.Flush()).To(Succeed())
+
+		r, err := c2.Cmd("PING")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("PONG"))
+		Expect(time.Since(start)).To(BeNumerically("<", 100*time.Millisecond))
+
+		r, err = c1.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("OK"))
+		Expect(time.Since(start)).To(BeNumerically(">=", 100*time.Millisecond))
+
+		r, err = c1.Cmd("DEBUG", "SLEEP", "soon")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("ERR value is not a valid float"))
+	})
+
+	It("should reply with errors and no-ops", func() {
+		_, c, _ := dial()
+		defer c.Close()
+
+		r, err := c.Cmd("DEBUG", "ERROR", "WRONGTYPE injected")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("WRONGTYPE injected"))
+
+		r, err = c.Cmd("DEBUG", "JMAP")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("OK"))
+
+		r, err = c.Cmd("DEBUG", "ERROR")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("ERR wrong number of arguments for 'DEBUG ERROR' command"))
+	})
+
+	It("should support Go specific helpers", func() {
+		_, c, _ := dial()
+		defer c.Close()
+
+		r, err := c.Cmd("DEBUG", "GOROUTINES")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(ContainSubstring("goroutine "))
+
+		r, err = c.Cmd("DEBUG", "GC")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("OK"))
+
+		r, err = c.Cmd("DEBUG", "PPROF", "goroutine", "1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(HavePrefix("goroutine profile: total"))
+
+		r, err = c.Cmd("DEBUG", "PPROF", "bogus")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("ERR unknown profile 'bogus'"))
+
+		r, err = c.Cmd("DEBUG", "SET-ACTIVE-EXPIRE", "0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("OK"))
+	})
+
+	It("should quit without a reply", func() {
+		cn, c, _ := dial()
+		defer c.Close()
+
+		c.Append("PING")
+		c.Append("DEBUG", "QUIT")
+		c.Append("PING")
+		Expect(c.Flush()).To(Succeed())
+		Expect(ioutil.ReadAll(cn)).To(BeEmpty())
+
+		Eventually(subject.Info().NumClients).Should(Equal(0))
+		Expect(subject.Info().TotalCommands()).To(Equal(int64(2)))
+		Expect(dropped).NotTo(Receive())
+	})
+
+	It("should drop clients on injected read errors", func() {
+		_, c, sn := dial()
+		defer c.Close()
+
+		r, err := c.Cmd("PING")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("PONG"))
+
+		failure := errors.New("read failed")
+		sn.FailReads(failure)
+
+		Eventually(dropped).Should(Receive(Equal(failure)))
+		Expect(subject.Info().String()).To(ContainSubstring("dropped_connections_read_error:1\n"))
+	})
+
+	It("should drop clients on injected write errors", func() {
+		_, c, sn := dial()
+		defer c.Close()
+
+		failure := errors.New("write failed")
+		sn.FailWrites(failure)
+		c.Append("PING")
+		Expect(c.Flush()).To(Succeed())
+
+		Eventually(dropped).Should(Receive(Equal(failure)))
+		Expect(subject.Info().String()).To(ContainSubstring("dropped_connections_write_error:1\n"))
+	})
+
+	It("should drop clients on partial writes", func() {
+		cn, c, sn := dial()
+		defer c.Close()
+
+		sn.LimitWrites(3)
+		c.Append("PING")
+		Expect(c.Flush()).To(Succeed())
+
+		Expect(ioutil.ReadAll(cn)).To(Equal([]byte("+PO")))
+		Eventually(dropped).Should(Receive(Equal(io.ErrShortWrite)))
+		Expect(subject.Info().String()).To(ContainSubstring("dropped_connections_write_error:1\n"))
+	})
+
+})
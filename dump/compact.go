@@ -0,0 +1,194 @@
+package dump
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// ziplist decodes the entries of a ziplist, integers are formatted as
+// strings.
+func ziplist(p []byte) ([][]byte, error) {
+	// zlbytes, zltail and zllen
+	if len(p) < 11 {
+		return nil, errCorrupt
+	}
+
+	var elems [][]byte
+	for pos := 10; ; {
+		if pos >= len(p) {
+			return nil, errCorrupt
+		}
+		if p[pos] == 0xff {
+			return elems, nil
+		}
+
+		// skip the length of the previous entry
+		if p[pos] == 0xfe {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(p) {
+			return nil, errCorrupt
+		}
+
+		var hlen, slen, ilen int
+		var ival int64
+		isInt := true
+		switch enc := p[pos]; {
+		case enc>>6 == 0:
+			hlen, slen, isInt = 1, int(enc&0x3f), false
+		case enc>>6 == 1:
+			if pos+1 >= len(p) {
+				return nil, errCorrupt
+			}
+			hlen, slen, isInt = 2, int(enc&0x3f)<<8|int(p[pos+1]), false
+		case enc == 0x80:
+			if pos+4 >= len(p) {
+				return nil, errCorrupt
+			}
+			hlen, slen, isInt = 5, int(binary.BigEndian.Uint32(p[pos+1:])), false
+		case enc == 0xc0:
+			hlen, ilen = 1, 2
+		case enc == 0xd0:
+			hlen, ilen = 1, 4
+		case enc == 0xe0:
+			hlen, ilen = 1, 8
+		case enc == 0xf0:
+			hlen, ilen = 1, 3
+		case enc == 0xfe:
+			hlen, ilen = 1, 1
+		case enc >= 0xf1 && enc <= 0xfd:
+			hlen, ival = 1, int64(enc&0x0f)-1
+		default:
+			return nil, errCorrupt
+		}
+
+		size := hlen + slen + ilen
+		if slen < 0 || pos+size > len(p) {
+			return nil, errCorrupt
+		}
+		data := p[pos+hlen : pos+size]
+		switch {
+		case ilen != 0:
+			elems = append(elems, strconv.AppendInt(nil, leInt(data), 10))
+		case isInt:
+			elems = append(elems, strconv.AppendInt(nil, ival, 10))
+		default:
+			elems = append(elems, append([]byte(nil), data...))
+		}
+		pos += size
+	}
+}
+
+// listpack decodes the entries of a listpack, integers are formatted as
+// strings.
+func listpack(p []byte) ([][]byte, error) {
+	// total bytes and number of elements
+	if len(p) < 7 {
+		return nil, errCorrupt
+	}
+
+	var elems [][]byte
+	for pos := 6; ; {
+		if pos >= len(p) {
+			return nil, errCorrupt
+		}
+
+		b := p[pos]
+		if b == 0xff {
+			return elems, nil
+		}
+
+		// the size of the encoding and the string or integer length
+		var hlen, slen, ilen int
+		var ival int64
+		var isInt bool
+		switch {
+		case b&0x80 == 0:
+			hlen, ival, isInt = 1, int64(b), true
+		case b&0xc0 == 0x80:
+			hlen, slen = 1, int(b&0x3f)
+		case b&0xe0 == 0xc0:
+			if pos+1 >= len(p) {
+				return nil, errCorrupt
+			}
+			u := int64(b&0x1f)<<8 | int64(p[pos+1])
+			if u >= 1<<12 {
+				u -= 1 << 13
+			}
+			hlen, ival, isInt = 2, u, true
+		case b&0xf0 == 0xe0:
+			if pos+1 >= len(p) {
+				return nil, errCorrupt
+			}
+			hlen, slen = 2, int(b&0x0f)<<8|int(p[pos+1])
+		case b == 0xf0:
+			if pos+4 >= len(p) {
+				return nil, errCorrupt
+			}
+			hlen, slen = 5, int(binary.LittleEndian.Uint32(p[pos+1:]))
+		case b == 0xf1:
+			hlen, ilen = 1, 2
+		case b == 0xf2:
+			hlen, ilen = 1, 3
+		case b == 0xf3:
+			hlen, ilen = 1, 4
+		case b == 0xf4:
+			hlen, ilen = 1, 8
+		default:
+			return nil, errCorrupt
+		}
+
+		size := hlen + slen + ilen
+		if slen < 0 || pos+size > len(p) {
+			return nil, errCorrupt
+		}
+		data := p[pos+hlen : pos+size]
+		switch {
+		case ilen != 0:
+			elems = append(elems, strconv.AppendInt(nil, leInt(data), 10))
+		case isInt:
+			elems = append(elems, strconv.AppendInt(nil, ival, 10))
+		default:
+			elems = append(elems, append([]byte(nil), data...))
+		}
+		pos += size + backlenSize(size)
+	}
+}
+
+// backlenSize returns the number of bytes used to store the size of an
+// entry at its end.
+func backlenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// intset decodes the members of an intset.
+func intset(p []byte) ([][]byte, error) {
+	if len(p) < 8 {
+		return nil, errCorrupt
+	}
+
+	enc := int(binary.LittleEndian.Uint32(p))
+	n := int(binary.LittleEndian.Uint32(p[4:]))
+	if enc != 2 && enc != 4 && enc != 8 || len(p)-8 != enc*n {
+		return nil, errCorrupt
+	}
+
+	elems := make([][]byte, n)
+	for i := range elems {
+		off := 8 + i*enc
+		elems[i] = strconv.AppendInt(nil, leInt(p[off:off+enc]), 10)
+	}
+	return elems, nil
+}
@@ -0,0 +1,134 @@
+// Package dump encodes and decodes values in the serialization format of
+// the Redis DUMP and RESTORE commands, as well as snapshots in the RDB
+// file format, see Writer and Reader.
+//
+// A payload consists of the type and value in RDB encoding, followed by
+// a two byte RDB version and a CRC64 checksum. Payloads created by Encode
+// use plain encodings, which Redis accepts since version 4. Decode also
+// understands the compact encodings Redis uses for small values, e.g.
+// listpacks, ziplists and intsets. Streams and module types are not
+// supported.
+package dump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version is the RDB version written by Encode.
+const Version = 9
+
+// Errors returned by Decode.
+var (
+	ErrChecksum    = errors.New("dump: checksum mismatch")
+	ErrTooShort    = errors.New("dump: payload too short")
+	ErrUnsupported = errors.New("dump: unsupported value type")
+)
+
+// Type is the type of a value.
+type Type uint8
+
+const (
+	TypeString Type = iota
+	TypeList
+	TypeSet
+	TypeSortedSet
+	TypeHash
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeSortedSet:
+		return "zset"
+	case TypeHash:
+		return "hash"
+	}
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
+// Value is a Redis value.
+type Value struct {
+	Type Type
+
+	// Str holds the value of a string.
+	Str []byte
+
+	// Elems holds the elements of a list or a set, the members of a
+	// sorted set or the fields of a hash.
+	Elems [][]byte
+
+	// Scores holds the scores of the sorted set members in Elems.
+	Scores []float64
+
+	// Vals holds the values of the hash fields in Elems.
+	Vals [][]byte
+}
+
+// Encode returns the DUMP payload of v, which can be passed to RESTORE.
+func Encode(v *Value) ([]byte, error) {
+	t, err := rdbType(v)
+	if err != nil {
+		return nil, err
+	}
+
+	w := new(writer)
+	w.byte(t)
+	w.value(v)
+	w.buf = append(w.buf, Version, 0)
+	w.uint64(checksum(w.buf))
+	return w.buf, nil
+}
+
+// rdbType validates v and returns the RDB type it is encoded as.
+func rdbType(v *Value) (byte, error) {
+	switch v.Type {
+	case TypeString:
+		return rdbTypeString, nil
+	case TypeList:
+		return rdbTypeList, nil
+	case TypeSet:
+		return rdbTypeSet, nil
+	case TypeSortedSet:
+		if len(v.Scores) != len(v.Elems) {
+			return 0, fmt.Errorf("dump: %d scores for %d members", len(v.Scores), len(v.Elems))
+		}
+		return rdbTypeZSet2, nil
+	case TypeHash:
+		if len(v.Vals) != len(v.Elems) {
+			return 0, fmt.Errorf("dump: %d values for %d fields", len(v.Vals), len(v.Elems))
+		}
+		return rdbTypeHash, nil
+	}
+	return 0, fmt.Errorf("dump: invalid type %v", v.Type)
+}
+
+// Decode decodes a DUMP payload, e.g. as returned by the DUMP command of
+// Redis.
+func Decode(payload []byte) (*Value, error) {
+	if len(payload) < 11 {
+		return nil, ErrTooShort
+	}
+
+	n := len(payload) - 8
+	if binary.LittleEndian.Uint64(payload[n:]) != checksum(payload[:n]) {
+		return nil, ErrChecksum
+	}
+
+	src := bytes.NewReader(payload[:n-2])
+	v, err := (&reader{src: src}).value()
+	if err == io.ErrUnexpectedEOF || err == nil && src.Len() != 0 {
+		return nil, errCorrupt
+	} else if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,121 @@
+package dump
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encode", func() {
+
+	It("should round-trip values", func() {
+		for _, v := range []*Value{
+			{Type: TypeString, Str: []byte("hello")},
+			{Type: TypeString, Str: make([]byte, 20000)},
+			{Type: TypeList, Elems: [][]byte{[]byte("a"), []byte("b")}},
+			{Type: TypeSet, Elems: [][]byte{[]byte("x")}},
+			{Type: TypeSortedSet, Elems: [][]byte{[]byte("m1"), []byte("m2")}, Scores: []float64{1.5, math.Inf(-1)}},
+			{Type: TypeHash, Elems: [][]byte{[]byte("f")}, Vals: [][]byte{[]byte("v")}},
+		} {
+			payload, err := Encode(v)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(Decode(payload)).To(Equal(v))
+		}
+	})
+
+	It("should validate values", func() {
+		_, err := Encode(&Value{Type: TypeHash, Elems: [][]byte{[]byte("f")}})
+		Expect(err).To(MatchError("dump: 0 values for 1 fields"))
+		_, err = Encode(&Value{Type: Type(9)})
+		Expect(err).To(MatchError("dump: invalid type Type(9)"))
+	})
+
+})
+
+var _ = Describe("Decode", func() {
+
+	// payload appends an RDB version and checksum to body
+	var payload = func(body ...byte) []byte {
+		w := &writer{buf: body}
+		w.buf = append(w.buf, 11, 0)
+		w.uint64(checksum(w.buf))
+		return w.buf
+	}
+
+	It("should calculate checksums", func() {
+		Expect(checksum([]byte("123456789"))).To(Equal(uint64(0xe9c6d914c4b8d9ca)))
+	})
+
+	It("should decode payloads of Redis", func() {
+		// DUMP of SET mykey 10, from the Redis documentation
+		Expect(Decode([]byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"))).To(Equal(&Value{Type: TypeString, Str: []byte("10")}))
+	})
+
+	It("should decode compressed strings", func() {
+		Expect(Decode(payload(0x00, 0xc3, 0x05, 0x0a, 0x00, 'a', 0xe0, 0x00, 0x00))).To(Equal(&Value{
+			Type: TypeString,
+			Str:  []byte("aaaaaaaaaa"),
+		}))
+	})
+
+	It("should decode intsets", func() {
+		Expect(Decode(payload(0x0b, 0x0c, 2, 0, 0, 0, 2, 0, 0, 0, 0x01, 0x00, 0xfe, 0xff))).To(Equal(&Value{
+			Type:  TypeSet,
+			Elems: [][]byte{[]byte("1"), []byte("-2")},
+		}))
+	})
+
+	It("should decode listpacks", func() {
+		lp := []byte{
+			21, 0, 0, 0, 4, 0,
+			0x82, 'f', '1', 0x03,
+			0x05, 0x01,
+			0x82, 'f', '2', 0x03,
+			0xdf, 0x9c, 0x02,
+			0xff,
+		}
+		Expect(Decode(payload(append([]byte{0x10, byte(len(lp))}, lp...)...))).To(Equal(&Value{
+			Type:  TypeHash,
+			Elems: [][]byte{[]byte("f1"), []byte("f2")},
+			Vals:  [][]byte{[]byte("5"), []byte("-100")},
+		}))
+	})
+
+	It("should decode quicklists of ziplists", func() {
+		zl := []byte{
+			20, 0, 0, 0, 15, 0, 0, 0, 3, 0,
+			0x00, 0x01, 'a',
+			0x03, 0xf8,
+			0x02, 0xc0, 0x2c, 0x01,
+			0xff,
+		}
+		Expect(Decode(payload(append([]byte{0x0e, 0x01, byte(len(zl))}, zl...)...))).To(Equal(&Value{
+			Type:  TypeList,
+			Elems: [][]byte{[]byte("a"), []byte("7"), []byte("300")},
+		}))
+	})
+
+	It("should reject invalid payloads", func() {
+		_, err := Decode([]byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\x0b"))
+		Expect(err).To(Equal(ErrChecksum))
+
+		_, err = Decode([]byte("\x00\n\t"))
+		Expect(err).To(Equal(ErrTooShort))
+
+		_, err = Decode(payload(0x15, 0x00))
+		Expect(err).To(Equal(ErrUnsupported))
+
+		_, err = Decode(payload(0x00, 0x05, 'a'))
+		Expect(err).To(MatchError("dump: corrupt payload"))
+	})
+
+})
+
+// --------------------------------------------------------------------
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "redeo/dump")
+}
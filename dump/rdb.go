@@ -0,0 +1,572 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+)
+
+var errCorrupt = errors.New("dump: corrupt payload")
+
+// RDB object types.
+const (
+	rdbTypeString          = 0
+	rdbTypeList            = 1
+	rdbTypeSet             = 2
+	rdbTypeZSet            = 3
+	rdbTypeHash            = 4
+	rdbTypeZSet2           = 5
+	rdbTypeListZiplist     = 10
+	rdbTypeSetIntset       = 11
+	rdbTypeZSetZiplist     = 12
+	rdbTypeHashZiplist     = 13
+	rdbTypeListQuicklist   = 14
+	rdbTypeHashListpack    = 16
+	rdbTypeZSetListpack    = 17
+	rdbTypeListQuicklist2  = 18
+	rdbTypeSetListpack     = 20
+	rdbQuicklistNodePlain  = 1
+	rdbQuicklistNodePacked = 2
+)
+
+// crcTable is the table of the CRC64 variant used by Redis (Jones
+// polynomial, reflected).
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// checksum returns the CRC64 of p, as used by Redis.
+func checksum(p []byte) uint64 {
+	return crcUpdate(0, p)
+}
+
+// crcUpdate adds p to crc. Unlike the standard library, Redis does not
+// invert the checksum.
+func crcUpdate(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
+
+// --------------------------------------------------------------------
+
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *writer) length(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.buf = append(w.buf, byte(n))
+	case n < 1<<14:
+		w.buf = append(w.buf, byte(n>>8)|0x40, byte(n))
+	case n <= math.MaxUint32:
+		var b [5]byte
+		b[0] = 0x80
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		w.buf = append(w.buf, b[:]...)
+	default:
+		var b [9]byte
+		b[0] = 0x81
+		binary.BigEndian.PutUint64(b[1:], n)
+		w.buf = append(w.buf, b[:]...)
+	}
+}
+
+func (w *writer) string(p []byte) {
+	w.length(uint64(len(p)))
+	w.buf = append(w.buf, p...)
+}
+
+func (w *writer) float64(f float64) {
+	w.uint64(math.Float64bits(f))
+}
+
+func (w *writer) uint64(n uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	w.buf = append(w.buf, b[:]...)
+}
+
+// value writes v, which must be valid, without its type, see rdbType.
+func (w *writer) value(v *Value) {
+	switch v.Type {
+	case TypeString:
+		w.string(v.Str)
+	case TypeList, TypeSet:
+		w.length(uint64(len(v.Elems)))
+		for _, elem := range v.Elems {
+			w.string(elem)
+		}
+	case TypeSortedSet:
+		w.length(uint64(len(v.Elems)))
+		for i, elem := range v.Elems {
+			w.string(elem)
+			w.float64(v.Scores[i])
+		}
+	case TypeHash:
+		w.length(uint64(len(v.Elems)))
+		for i, field := range v.Elems {
+			w.string(field)
+			w.string(v.Vals[i])
+		}
+	}
+}
+
+// --------------------------------------------------------------------
+
+// source is the input of a reader, e.g. a bytes.Reader or bufio.Reader.
+type source interface {
+	io.Reader
+	io.ByteReader
+}
+
+type reader struct {
+	src source
+	crc uint64 // the checksum of the bytes read so far
+	tmp [8]byte
+}
+
+// update adds p to the checksum.
+func (r *reader) update(p []byte) {
+	r.crc = crcUpdate(r.crc, p)
+}
+
+// next reads the next n bytes into a new slice.
+func (r *reader) next(n uint64) ([]byte, error) {
+	if n > math.MaxInt32 {
+		return nil, errCorrupt
+	}
+
+	var p []byte
+	if n <= 1<<16 {
+		p = make([]byte, n)
+		if _, err := io.ReadFull(r.src, p); err != nil {
+			return nil, unexpected(err)
+		}
+	} else {
+		// grow with the data read, rather than trusting n
+		buf := bytes.NewBuffer(make([]byte, 0, 1<<16))
+		if _, err := io.CopyN(buf, r.src, int64(n)); err != nil {
+			return nil, unexpected(err)
+		}
+		p = buf.Bytes()
+	}
+	r.update(p)
+	return p, nil
+}
+
+// fixed reads the next n bytes, at most 8, into a temporary buffer.
+func (r *reader) fixed(n int) ([]byte, error) {
+	p := r.tmp[:n]
+	if _, err := io.ReadFull(r.src, p); err != nil {
+		return nil, unexpected(err)
+	}
+	r.update(p)
+	return p, nil
+}
+
+func (r *reader) byte() (byte, error) {
+	b, err := r.src.ReadByte()
+	if err != nil {
+		return 0, unexpected(err)
+	}
+	r.tmp[0] = b
+	r.update(r.tmp[:1])
+	return b, nil
+}
+
+// length reads a length. Encoded is true for special string encodings,
+// in which case n is the encoding.
+func (r *reader) length() (n uint64, encoded bool, err error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		c, err := r.byte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(c), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			p, err := r.fixed(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(p)), false, nil
+		case 0x81:
+			p, err := r.fixed(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(p), false, nil
+		}
+		return 0, false, errCorrupt
+	}
+	return uint64(b & 0x3f), true, nil
+}
+
+// count reads a number of elements.
+func (r *reader) count() (int, error) {
+	n, encoded, err := r.length()
+	if err != nil {
+		return 0, err
+	}
+	if encoded || n > math.MaxInt32 {
+		return 0, errCorrupt
+	}
+	return int(n), nil
+}
+
+func (r *reader) string() ([]byte, error) {
+	n, encoded, err := r.length()
+	if err != nil {
+		return nil, err
+	}
+
+	if !encoded {
+		return r.next(n)
+	}
+
+	switch n {
+	case 0, 1, 2:
+		p, err := r.fixed(1 << n)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, leInt(p), 10), nil
+	case 3:
+		clen, _, err := r.length()
+		if err != nil {
+			return nil, err
+		}
+		ulen, _, err := r.length()
+		if err != nil {
+			return nil, err
+		}
+		p, err := r.next(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(p, ulen)
+	}
+	return nil, errCorrupt
+}
+
+func (r *reader) float64() (float64, error) {
+	p, err := r.fixed(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(p)), nil
+}
+
+// oldFloat reads a float in the string representation of RDB_TYPE_ZSET.
+func (r *reader) oldFloat() (float64, error) {
+	n, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	p, err := r.next(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	return parseFloat(p)
+}
+
+// strings reads n strings.
+func (r *reader) strings(n int) ([][]byte, error) {
+	elems := make([][]byte, 0, capped(n))
+	for i := 0; i < n; i++ {
+		elem, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+func (r *reader) value() (*Value, error) {
+	t, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	return r.object(t)
+}
+
+// object reads a value of RDB type t.
+func (r *reader) object(t byte) (*Value, error) {
+	switch t {
+	case rdbTypeString:
+		s, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Type: TypeString, Str: s}, nil
+
+	case rdbTypeList, rdbTypeSet:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		elems, err := r.strings(n)
+		if err != nil {
+			return nil, err
+		}
+		if t == rdbTypeList {
+			return &Value{Type: TypeList, Elems: elems}, nil
+		}
+		return &Value{Type: TypeSet, Elems: elems}, nil
+
+	case rdbTypeZSet, rdbTypeZSet2:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		v := &Value{Type: TypeSortedSet, Elems: make([][]byte, 0, capped(n)), Scores: make([]float64, 0, capped(n))}
+		for i := 0; i < n; i++ {
+			member, err := r.string()
+			if err != nil {
+				return nil, err
+			}
+
+			var score float64
+			if t == rdbTypeZSet {
+				score, err = r.oldFloat()
+			} else {
+				score, err = r.float64()
+			}
+			if err != nil {
+				return nil, err
+			}
+			v.Elems, v.Scores = append(v.Elems, member), append(v.Scores, score)
+		}
+		return v, nil
+
+	case rdbTypeHash:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		elems, err := r.strings(2 * n)
+		if err != nil {
+			return nil, err
+		}
+		return hashPairs(elems)
+
+	case rdbTypeSetIntset:
+		s, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		elems, err := intset(s)
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Type: TypeSet, Elems: elems}, nil
+
+	case rdbTypeListZiplist, rdbTypeZSetZiplist, rdbTypeHashZiplist,
+		rdbTypeHashListpack, rdbTypeZSetListpack, rdbTypeSetListpack:
+		s, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+
+		var elems [][]byte
+		switch t {
+		case rdbTypeListZiplist, rdbTypeZSetZiplist, rdbTypeHashZiplist:
+			elems, err = ziplist(s)
+		default:
+			elems, err = listpack(s)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case rdbTypeListZiplist:
+			return &Value{Type: TypeList, Elems: elems}, nil
+		case rdbTypeSetListpack:
+			return &Value{Type: TypeSet, Elems: elems}, nil
+		case rdbTypeZSetZiplist, rdbTypeZSetListpack:
+			return sortedSetPairs(elems)
+		}
+		return hashPairs(elems)
+
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+
+		v := &Value{Type: TypeList}
+		for i := 0; i < n; i++ {
+			container := uint64(rdbQuicklistNodePacked)
+			if t == rdbTypeListQuicklist2 {
+				if container, _, err = r.length(); err != nil {
+					return nil, err
+				}
+			}
+
+			s, err := r.string()
+			if err != nil {
+				return nil, err
+			}
+
+			var elems [][]byte
+			switch {
+			case container == rdbQuicklistNodePlain:
+				elems = [][]byte{s}
+			case container != rdbQuicklistNodePacked:
+				return nil, errCorrupt
+			case t == rdbTypeListQuicklist:
+				elems, err = ziplist(s)
+			default:
+				elems, err = listpack(s)
+			}
+			if err != nil {
+				return nil, err
+			}
+			v.Elems = append(v.Elems, elems...)
+		}
+		return v, nil
+	}
+	return nil, ErrUnsupported
+}
+
+func sortedSetPairs(elems [][]byte) (*Value, error) {
+	if len(elems)%2 != 0 {
+		return nil, errCorrupt
+	}
+
+	v := &Value{Type: TypeSortedSet, Elems: make([][]byte, 0, len(elems)/2), Scores: make([]float64, 0, len(elems)/2)}
+	for i := 0; i < len(elems); i += 2 {
+		score, err := parseFloat(elems[i+1])
+		if err != nil {
+			return nil, err
+		}
+		v.Elems = append(v.Elems, elems[i])
+		v.Scores = append(v.Scores, score)
+	}
+	return v, nil
+}
+
+func hashPairs(elems [][]byte) (*Value, error) {
+	if len(elems)%2 != 0 {
+		return nil, errCorrupt
+	}
+
+	v := &Value{Type: TypeHash, Elems: make([][]byte, 0, len(elems)/2), Vals: make([][]byte, 0, len(elems)/2)}
+	for i := 0; i < len(elems); i += 2 {
+		v.Elems = append(v.Elems, elems[i])
+		v.Vals = append(v.Vals, elems[i+1])
+	}
+	return v, nil
+}
+
+// capped limits preallocations for n elements, as n is not trusted.
+func capped(n int) int {
+	if n > 1024 {
+		return 1024
+	}
+	return n
+}
+
+// unexpected converts EOF errors within values.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func parseFloat(p []byte) (float64, error) {
+	f, err := strconv.ParseFloat(string(p), 64)
+	if err != nil {
+		return 0, errCorrupt
+	}
+	return f, nil
+}
+
+// leInt decodes a signed little-endian integer of 1 to 8 bytes.
+func leInt(p []byte) int64 {
+	var u uint64
+	for i := len(p) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(p[i])
+	}
+	shift := uint(64 - 8*len(p))
+	return int64(u<<shift) >> shift
+}
+
+// lzfDecompress decompresses p into n bytes.
+func lzfDecompress(p []byte, n uint64) ([]byte, error) {
+	// a back reference of at most 3 bytes expands to at most 264 bytes
+	if n > 88*uint64(len(p)) {
+		return nil, errCorrupt
+	}
+
+	out := make([]byte, 0, n)
+	for i := 0; i < len(p); {
+		ctrl := int(p[i])
+		i++
+
+		// literal run
+		if ctrl < 1<<5 {
+			end := i + ctrl + 1
+			if end > len(p) {
+				return nil, errCorrupt
+			}
+			out = append(out, p[i:end]...)
+			i = end
+			continue
+		}
+
+		// back reference
+		size := ctrl >> 5
+		if size == 7 {
+			if i >= len(p) {
+				return nil, errCorrupt
+			}
+			size += int(p[i])
+			i++
+		}
+		if i >= len(p) {
+			return nil, errCorrupt
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(p[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errCorrupt
+		}
+		for j := 0; j < size+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if uint64(len(out)) != n {
+		return nil, errCorrupt
+	}
+	return out, nil
+}
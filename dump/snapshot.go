@@ -0,0 +1,263 @@
+package dump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// RDB opcodes.
+const (
+	rdbOpIdle         = 0xf8
+	rdbOpFreq         = 0xf9
+	rdbOpAux          = 0xfa
+	rdbOpResizeDB     = 0xfb
+	rdbOpExpireTimeMs = 0xfc
+	rdbOpExpireTime   = 0xfd
+	rdbOpSelectDB     = 0xfe
+	rdbOpEOF          = 0xff
+)
+
+var errWriterClosed = errors.New("dump: writer is closed")
+
+// Entry is a key of a snapshot.
+type Entry struct {
+	DB    int
+	Key   []byte
+	Value *Value
+
+	// Expires is the expiry time of the key, zero if it is persistent.
+	Expires time.Time
+}
+
+// --------------------------------------------------------------------
+
+// Writer writes snapshots in the RDB file format, e.g. as the payload
+// of a full synchronisation of replicas, see redeo.ReplicationConfig.
+type Writer struct {
+	w   io.Writer
+	enc writer
+	crc uint64
+	db  int // the selected database, -1 if none
+	err error
+}
+
+// NewWriter creates a snapshot writer.
+func NewWriter(w io.Writer) *Writer {
+	sw := &Writer{w: w, db: -1}
+	sw.enc.buf = append(sw.enc.buf, fmt.Sprintf("REDIS%04d", Version)...)
+	return sw
+}
+
+// WriteAux writes an auxiliary field, e.g. "redis-ver".
+func (w *Writer) WriteAux(key, value string) error {
+	w.enc.byte(rdbOpAux)
+	w.enc.string([]byte(key))
+	w.enc.string([]byte(value))
+	return w.flush()
+}
+
+// Write writes an entry. Entries should be written in database order.
+func (w *Writer) Write(e *Entry) error {
+	t, err := rdbType(e.Value)
+	if err != nil {
+		return err
+	}
+
+	if e.DB != w.db {
+		w.enc.byte(rdbOpSelectDB)
+		w.enc.length(uint64(e.DB))
+		w.db = e.DB
+	}
+	if !e.Expires.IsZero() {
+		w.enc.byte(rdbOpExpireTimeMs)
+		w.enc.uint64(uint64(e.Expires.UnixNano() / int64(time.Millisecond)))
+	}
+	w.enc.byte(t)
+	w.enc.string(e.Key)
+	w.enc.value(e.Value)
+	return w.flush()
+}
+
+// Close completes the snapshot. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.enc.byte(rdbOpEOF)
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	w.enc.uint64(w.crc)
+	err := w.flush()
+	w.err = errWriterClosed
+	return err
+}
+
+func (w *Writer) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	w.crc = crcUpdate(w.crc, w.enc.buf)
+	if _, err := w.w.Write(w.enc.buf); err != nil {
+		w.err = err
+		return err
+	}
+	w.enc.buf = w.enc.buf[:0]
+	return nil
+}
+
+// --------------------------------------------------------------------
+
+// Reader reads snapshots in the RDB file format, as written by Writer
+// or Redis.
+type Reader struct {
+	r       reader
+	aux     map[string]string
+	db      int
+	version int
+	err     error
+}
+
+// NewReader creates a snapshot reader.
+func NewReader(rd io.Reader) *Reader {
+	src, ok := rd.(source)
+	if !ok {
+		src = bufio.NewReader(rd)
+	}
+	return &Reader{r: reader{src: src}, version: -1}
+}
+
+// Aux returns the auxiliary fields read so far.
+func (r *Reader) Aux() map[string]string {
+	return r.aux
+}
+
+// Next returns the next entry. It returns io.EOF once the snapshot is
+// complete and its checksum is verified.
+func (r *Reader) Next() (*Entry, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	e, err := r.next()
+	if err != nil {
+		r.err = err
+	}
+	return e, err
+}
+
+func (r *Reader) next() (*Entry, error) {
+	if r.version < 0 {
+		if err := r.header(); err != nil {
+			return nil, err
+		}
+	}
+
+	var expires time.Time
+	for {
+		op, err := r.r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case rdbOpAux:
+			key, err := r.r.string()
+			if err != nil {
+				return nil, err
+			}
+			val, err := r.r.string()
+			if err != nil {
+				return nil, err
+			}
+			if r.aux == nil {
+				r.aux = make(map[string]string)
+			}
+			r.aux[string(key)] = string(val)
+		case rdbOpSelectDB:
+			n, err := r.r.count()
+			if err != nil {
+				return nil, err
+			}
+			r.db = n
+		case rdbOpResizeDB:
+			for i := 0; i < 2; i++ {
+				if _, _, err := r.r.length(); err != nil {
+					return nil, err
+				}
+			}
+		case rdbOpExpireTimeMs:
+			p, err := r.r.fixed(8)
+			if err != nil {
+				return nil, err
+			}
+			ms := int64(binary.LittleEndian.Uint64(p))
+			expires = time.Unix(ms/1000, ms%1000*int64(time.Millisecond))
+		case rdbOpExpireTime:
+			p, err := r.r.fixed(4)
+			if err != nil {
+				return nil, err
+			}
+			expires = time.Unix(int64(int32(binary.LittleEndian.Uint32(p))), 0)
+		case rdbOpIdle:
+			if _, _, err := r.r.length(); err != nil {
+				return nil, err
+			}
+		case rdbOpFreq:
+			if _, err := r.r.byte(); err != nil {
+				return nil, err
+			}
+		case rdbOpEOF:
+			return nil, r.trailer()
+		default:
+			key, err := r.r.string()
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.r.object(op)
+			if err != nil {
+				return nil, err
+			}
+			return &Entry{DB: r.db, Key: key, Value: v, Expires: expires}, nil
+		}
+	}
+}
+
+func (r *Reader) header() error {
+	p, err := r.r.next(9)
+	if err != nil {
+		return err
+	}
+	if string(p[:5]) != "REDIS" {
+		return errCorrupt
+	}
+
+	version, err := strconv.Atoi(string(p[5:]))
+	if err != nil {
+		return errCorrupt
+	}
+	r.version = version
+	return nil
+}
+
+// trailer verifies the checksum, written since RDB version 5. Redis
+// writes a zero checksum when checksums are disabled.
+func (r *Reader) trailer() error {
+	if r.version < 5 {
+		return io.EOF
+	}
+
+	crc := r.r.crc
+	p, err := r.r.fixed(8)
+	if err != nil {
+		return err
+	}
+	if sum := binary.LittleEndian.Uint64(p); sum != 0 && sum != crc {
+		return ErrChecksum
+	}
+	return io.EOF
+}
@@ -0,0 +1,81 @@
+package dump
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshot", func() {
+	expires := time.Unix(1700000000, 123*int64(time.Millisecond))
+	entries := []*Entry{
+		{Key: []byte("str"), Value: &Value{Type: TypeString, Str: []byte("v")}, Expires: expires},
+		{Key: []byte("list"), Value: &Value{Type: TypeList, Elems: [][]byte{[]byte("a")}}},
+		{DB: 2, Key: []byte("hash"), Value: &Value{Type: TypeHash, Elems: [][]byte{[]byte("f")}, Vals: [][]byte{[]byte("v")}}},
+	}
+
+	var write = func() []byte {
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf)
+		Expect(w.WriteAux("redis-ver", "5.0.0")).To(Succeed())
+		for _, e := range entries {
+			Expect(w.Write(e)).To(Succeed())
+		}
+		Expect(w.Close()).To(Succeed())
+		Expect(w.Write(entries[0])).To(MatchError("dump: writer is closed"))
+		return buf.Bytes()
+	}
+
+	It("should write and read snapshots", func() {
+		data := write()
+		Expect(data).To(HavePrefix("REDIS0009\xfa\x09redis-ver\x055.0.0\xfe\x00\xfc"))
+
+		r := NewReader(bytes.NewReader(data))
+		for _, exp := range entries {
+			e, err := r.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e.DB).To(Equal(exp.DB))
+			Expect(e.Key).To(Equal(exp.Key))
+			Expect(e.Value).To(Equal(exp.Value))
+			Expect(e.Expires.Equal(exp.Expires)).To(BeTrue())
+		}
+		_, err := r.Next()
+		Expect(err).To(Equal(io.EOF))
+		Expect(r.Aux()).To(Equal(map[string]string{"redis-ver": "5.0.0"}))
+	})
+
+	It("should verify checksums", func() {
+		data := write()
+		data[len(data)-1]++
+
+		r := NewReader(bytes.NewReader(data))
+		for range entries {
+			_, err := r.Next()
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := r.Next()
+		Expect(err).To(Equal(ErrChecksum))
+
+		// disabled checksums
+		copy(data[len(data)-8:], make([]byte, 8))
+		r = NewReader(bytes.NewReader(data))
+		for range entries {
+			_, err := r.Next()
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err = r.Next()
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("should reject invalid snapshots", func() {
+		_, err := NewReader(bytes.NewBufferString("NOTREDIS0")).Next()
+		Expect(err).To(MatchError("dump: corrupt payload"))
+
+		_, err = NewReader(bytes.NewBufferString("REDIS0009\x00\x03k")).Next()
+		Expect(err).To(Equal(io.ErrUnexpectedEOF))
+	})
+
+})
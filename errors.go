@@ -0,0 +1,95 @@
+package redeo
+
+import "strconv"
+
+// ErrWrongType is returned by operations against keys holding the wrong
+// kind of value.
+var ErrWrongType error = WrongTypeError{}
+
+// ErrReadOnly is returned for commands registered with the "write" flag,
+// while the server is read-only, see Config.ReadOnly.
+var ErrReadOnly error = NewError("READONLY", "You can't write against a read only replica.")
+
+// Error is an error with a custom error class prefix, e.g. "NOSCRIPT".
+// Errors are rendered as "<Prefix> <Message>", see resp.ErrorReply.
+type Error struct {
+	Prefix  string
+	Message string
+}
+
+// NewError inits a new error with a prefix.
+func NewError(prefix, msg string) *Error {
+	return &Error{Prefix: prefix, Message: msg}
+}
+
+// Error implements error.
+func (e *Error) Error() string { return e.Prefix + " " + e.Message }
+
+// ErrorPrefix implements resp.PrefixedError.
+func (e *Error) ErrorPrefix() string { return e.Prefix }
+
+// WrongTypeError is returned by operations against keys holding the
+// wrong kind of value, see ErrWrongType.
+type WrongTypeError struct{}
+
+// Error implements error.
+func (WrongTypeError) Error() string {
+	return "WRONGTYPE Operation against a key holding the wrong kind of value"
+}
+
+// ErrorPrefix implements resp.PrefixedError.
+func (WrongTypeError) ErrorPrefix() string { return "WRONGTYPE" }
+
+// BusyError is returned while the server is busy, e.g. running a
+// long script.
+type BusyError struct {
+	// Msg is the message following the prefix.
+	// Default: "Server is busy"
+	Msg string
+}
+
+// Error implements error.
+func (e BusyError) Error() string {
+	if e.Msg == "" {
+		return "BUSY Server is busy"
+	}
+	return "BUSY " + e.Msg
+}
+
+// ErrorPrefix implements resp.PrefixedError.
+func (BusyError) ErrorPrefix() string { return "BUSY" }
+
+// MovedError redirects clients to the node which permanently serves the
+// slot.
+type MovedError struct {
+	Slot int
+	Addr string // host:port
+}
+
+// Error implements error.
+func (e MovedError) Error() string { return "MOVED " + strconv.Itoa(e.Slot) + " " + e.Addr }
+
+// ErrorPrefix implements resp.PrefixedError.
+func (MovedError) ErrorPrefix() string { return "MOVED" }
+
+// AskError redirects clients to the node serving the slot for the next
+// command only, e.g. during migrations.
+type AskError struct {
+	Slot int
+	Addr string // host:port
+}
+
+// Error implements error.
+func (e AskError) Error() string { return "ASK " + strconv.Itoa(e.Slot) + " " + e.Addr }
+
+// ErrorPrefix implements resp.PrefixedError.
+func (AskError) ErrorPrefix() string { return "ASK" }
+
+// KilledError is passed to Config.OnClientError when a client is
+// disconnected via Server.KillClient.
+type KilledError struct {
+	Reason string
+}
+
+// Error implements error.
+func (e *KilledError) Error() string { return "redeo: client killed: " + e.Reason }
@@ -0,0 +1,69 @@
+package redeo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Error", func() {
+
+	DescribeTable("should render replies",
+		func(err error, exp string) {
+			Expect(resp.ErrorReply(err)).To(Equal(exp))
+		},
+		Entry("plain", errors.New("bad"), "ERR bad"),
+		Entry("custom", NewError("NOSCRIPT", "No matching script"), "NOSCRIPT No matching script"),
+		Entry("wrong type", ErrWrongType, "WRONGTYPE Operation against a key holding the wrong kind of value"),
+		Entry("busy", BusyError{}, "BUSY Server is busy"),
+		Entry("busy with message", BusyError{Msg: "Loading"}, "BUSY Loading"),
+		Entry("moved", MovedError{Slot: 3999, Addr: "127.0.0.1:6381"}, "MOVED 3999 127.0.0.1:6381"),
+		Entry("ask", AskError{Slot: 3999, Addr: "127.0.0.1:6381"}, "ASK 3999 127.0.0.1:6381"),
+		Entry("wrapped", fmt.Errorf("lookup: %w", ErrWrongType), "WRONGTYPE Operation against a key holding the wrong kind of value"),
+	)
+
+	It("should carry metadata", func() {
+		var moved MovedError
+		Expect(errors.As(fmt.Errorf("lookup: %w", MovedError{Slot: 1, Addr: "host:6379"}), &moved)).To(BeTrue())
+		Expect(moved.Slot).To(Equal(1))
+		Expect(moved.Addr).To(Equal("host:6379"))
+		Expect(NewError("NOPERM", "denied").ErrorPrefix()).To(Equal("NOPERM"))
+	})
+
+	It("should be rendered by handlers", func() {
+		h := ErrorHandlerFunc(func(w resp.ResponseWriter, c *resp.Command) error {
+			switch c.Arg(0).String() {
+			case "wrongtype":
+				return ErrWrongType
+			case "plain":
+				return errors.New("bad")
+			}
+			w.AppendOK()
+			return nil
+		})
+
+		w := redeotest.NewRecorder()
+		h.ServeRedeo(w, resp.NewCommand("test", resp.CommandArgument("wrongtype")))
+		Expect(w.Response()).To(Equal(redeotest.ErrorResponse("WRONGTYPE Operation against a key holding the wrong kind of value")))
+
+		w = redeotest.NewRecorder()
+		h.ServeRedeo(w, resp.NewCommand("test", resp.CommandArgument("plain")))
+		Expect(w.Response()).To(Equal(redeotest.ErrorResponse("ERR bad")))
+
+		w = redeotest.NewRecorder()
+		h.ServeRedeo(w, resp.NewCommand("test", resp.CommandArgument("ok")))
+		Expect(w.Response()).To(Equal("OK"))
+
+		w = redeotest.NewRecorder()
+		WrapperFunc(func(c *resp.Command) interface{} {
+			return MovedError{Slot: 1, Addr: "host:6379"}
+		}).ServeRedeo(w, resp.NewCommand("test"))
+		Expect(w.Response()).To(Equal(redeotest.ErrorResponse("MOVED 1 host:6379")))
+	})
+
+})
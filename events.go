@@ -0,0 +1,151 @@
+package redeo
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// eventFeedSize is the number of events a subscriber may lag behind,
+// before further events are dropped.
+const eventFeedSize = 1024
+
+// CommandEvent describes an executed command, see Server.Subscribe.
+type CommandEvent struct {
+	// ClientID is the ID of the client which sent the command
+	ClientID uint64
+	// Name is the normalised (lower-case) command name
+	Name string
+	// Args is a copy of the arguments, nil for streaming commands
+	Args [][]byte
+	// Time is the time at which the execution started
+	Time time.Time
+	// Failed is true if the handler replied with an error
+	Failed bool
+}
+
+// WithEvents enables CommandEvents for the command, see Server.Subscribe.
+func WithEvents() HandlerOption {
+	return func(s *commandSpec) { s.events = true }
+}
+
+// eventBus delivers command events to subscribers.
+type eventBus struct {
+	subs   map[int]chan CommandEvent
+	filter func(name string) bool
+	mu     sync.RWMutex
+	nextID int
+	n      int32
+}
+
+// active returns true if there are any subscribers.
+func (b *eventBus) active() bool {
+	return atomic.LoadInt32(&b.n) != 0
+}
+
+func (b *eventBus) subscribe(fn func(CommandEvent)) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs == nil {
+		b.subs = make(map[int]chan CommandEvent)
+	}
+	b.nextID++
+	feed := make(chan CommandEvent, eventFeedSize)
+	b.subs[b.nextID] = feed
+	atomic.AddInt32(&b.n, 1)
+
+	go func() {
+		for ev := range feed {
+			fn(ev)
+		}
+	}()
+	return b.nextID
+}
+
+func (b *eventBus) unsubscribe(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if feed, ok := b.subs[id]; ok {
+		delete(b.subs, id)
+		atomic.AddInt32(&b.n, -1)
+		close(feed)
+	}
+}
+
+// wants returns true if events should be emitted for the command.
+func (b *eventBus) wants(entry *handlerEntry, name string) bool {
+	if entry.spec.events {
+		return true
+	}
+
+	b.mu.RLock()
+	filter := b.filter
+	b.mu.RUnlock()
+	return filter != nil && filter(name)
+}
+
+// publish sends an event to all subscribers without blocking. Returns
+// the number of subscribers which could not keep up.
+func (b *eventBus) publish(ev CommandEvent) (dropped int) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, feed := range b.subs {
+		select {
+		case feed <- ev:
+		default:
+			dropped++
+		}
+	}
+	return
+}
+
+// --------------------------------------------------------------------
+
+// Subscribe registers fn to receive events for commands registered
+// WithEvents or selected via EmitEvents. Events are delivered
+// asynchronously and in order; events are dropped, rather than stalling
+// clients, when fn cannot keep up. Call the returned function to
+// unsubscribe.
+func (srv *Server) Subscribe(fn func(ev CommandEvent)) (cancel func()) {
+	id := srv.events.subscribe(fn)
+	return func() { srv.events.unsubscribe(id) }
+}
+
+// EmitEvents selects additional commands for which events are emitted,
+// by normalised name. Pass nil to remove the filter.
+func (srv *Server) EmitEvents(filter func(name string) bool) {
+	srv.events.mu.Lock()
+	srv.events.filter = filter
+	srv.events.mu.Unlock()
+}
+
+// emit sends a command event, if any subscriber is interested.
+func (srv *Server) emit(clientID uint64, entry *handlerEntry, name string, args []resp.CommandArgument, start time.Time, failed bool) {
+	if !srv.events.active() || !srv.events.wants(entry, name) {
+		return
+	}
+
+	ev := CommandEvent{ClientID: clientID, Name: name, Time: start, Failed: failed}
+	if args != nil {
+		n := 0
+		for _, arg := range args {
+			n += len(arg)
+		}
+
+		buf := make([]byte, 0, n)
+		ev.Args = make([][]byte, len(args))
+		for i, arg := range args {
+			buf = append(buf, arg...)
+			ev.Args[i] = buf[len(buf)-len(arg) : len(buf) : len(buf)]
+		}
+	}
+
+	if n := srv.events.publish(ev); n != 0 {
+		srv.info.droppedEvents.Inc(int64(n))
+	}
+}
@@ -0,0 +1,106 @@
+package redeo
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Events", func() {
+	var subject *Server
+	var lis net.Listener
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		subject = NewServer(nil)
+		subject.Handle("ping", Ping())
+		subject.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendNil()
+		}, Arity(2))
+		subject.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			if c.Arg(1).String() == "" {
+				w.AppendError("ERR empty value")
+				return
+			}
+			w.AppendOK()
+		}, Arity(3), WithEvents())
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should emit events for selected commands", func() {
+		events := make(chan CommandEvent, 10)
+		cancel := subject.Subscribe(func(ev CommandEvent) { events <- ev })
+		defer cancel()
+
+		cn, w, r := dialRaw(lis)
+		defer cn.Close()
+
+		start := time.Now()
+		w.WriteCmdString("SET", "k1", "v1")
+		w.WriteCmdString("GET", "k1")
+		w.WriteCmdString("SET", "k2", "")
+		w.WriteCmdString("SET", "k3")
+		Expect(w.Flush()).To(Succeed())
+		Expect(r.ReadInlineString()).To(Equal("OK"))
+		Expect(r.ReadNil()).To(Succeed())
+		Expect(r.ReadError()).To(Equal("ERR empty value"))
+		Expect(r.ReadError()).To(HavePrefix("ERR wrong number"))
+
+		var ev CommandEvent
+		Eventually(events).Should(Receive(&ev))
+		Expect(ev.ClientID).To(Equal(subject.Info().ClientInfo()[0].ID))
+		Expect(ev.Name).To(Equal("set"))
+		Expect(ev.Args).To(Equal([][]byte{[]byte("k1"), []byte("v1")}))
+		Expect(ev.Time).To(BeTemporally("~", start, time.Second))
+		Expect(ev.Failed).To(BeFalse())
+
+		Eventually(events).Should(Receive(&ev))
+		Expect(ev.Args).To(Equal([][]byte{[]byte("k2"), []byte("")}))
+		Expect(ev.Failed).To(BeTrue())
+		Consistently(events, "50ms").ShouldNot(Receive())
+
+		subject.EmitEvents(func(name string) bool { return strings.HasPrefix(name, "g") })
+		w.WriteCmdString("GET", "k1")
+		w.WriteCmdString("PING")
+		Expect(w.Flush()).To(Succeed())
+		Expect(r.ReadNil()).To(Succeed())
+		Expect(r.ReadInlineString()).To(Equal("PONG"))
+
+		Eventually(events).Should(Receive(&ev))
+		Expect(ev.Name).To(Equal("get"))
+		Consistently(events, "50ms").ShouldNot(Receive())
+	})
+
+	It("should drop events for slow subscribers", func() {
+		unblock := make(chan struct{})
+		cancel := subject.Subscribe(func(ev CommandEvent) { <-unblock })
+		defer cancel()
+		defer close(unblock)
+
+		cn, w, r := dialRaw(lis)
+		defer cn.Close()
+
+		n := eventFeedSize + 10
+		for i := 0; i < n; i++ {
+			w.WriteCmdString("SET", "k", "v")
+		}
+		Expect(w.Flush()).To(Succeed())
+		for i := 0; i < n; i++ {
+			Expect(r.ReadInlineString()).To(Equal("OK"))
+		}
+		Expect(subject.Info().DroppedEvents()).To(BeNumerically(">=", 9))
+		Expect(subject.Info().String()).To(MatchRegexp(`dropped_command_events:\d+`))
+	})
+
+})
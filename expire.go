@@ -0,0 +1,537 @@
+package redeo
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// ExpirerConfig configures an Expirer.
+type ExpirerConfig struct {
+	// Exists reports whether a key exists. Required.
+	Exists func(db int, key string) bool
+
+	// Delete deletes an expired key. It is called on the goroutine of the
+	// expirer, or of the command detecting an overdue key. Required.
+	Delete func(db int, key string)
+
+	// Resolution is the tick of the timer wheel, keys expire up to one
+	// tick late.
+	// Default: 10ms
+	Resolution time.Duration
+}
+
+// Expirer tracks the expiry of keys, using a hierarchical timer wheel,
+// and serves the EXPIRE, PEXPIRE, TTL, PTTL and PERSIST commands. The
+// deletion of keys is left to ExpirerConfig.Delete. Handlers of commands
+// which delete or overwrite keys should call ClearExpiry, handlers
+// reading keys may call Expired to expire them lazily, like redis.
+type Expirer struct {
+	conf    ExpirerConfig
+	srv     *Server // see InstallTo
+	entries map[expiryKey]*expiryEntry
+	wheel   timerWheel
+	mu      sync.Mutex
+
+	stop, done chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewExpirer inits an expirer and starts its timer wheel.
+func NewExpirer(conf *ExpirerConfig) *Expirer {
+	e := &Expirer{
+		conf:    *conf,
+		entries: make(map[expiryKey]*expiryEntry),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if e.conf.Resolution <= 0 {
+		e.conf.Resolution = 10 * time.Millisecond
+	}
+	e.wheel.now = e.tick(time.Now()) - 1
+
+	go e.loop()
+	return e
+}
+
+// InstallTo registers the EXPIRE, PEXPIRE, TTL, PTTL and PERSIST handlers
+// on the server. Expired keys are then propagated as DEL and reported as
+// "expired" keyspace events, see Server.NotifyKeyspaceEvent.
+func (e *Expirer) InstallTo(srv *Server) {
+	e.mu.Lock()
+	e.srv = srv
+	e.mu.Unlock()
+
+	srv.Handle("expire", e.Expire(), MinArgs(2), Flags("write", "fast"), Keys(1, 1, 1))
+	srv.Handle("pexpire", e.PExpire(), MinArgs(2), Flags("write", "fast"), Keys(1, 1, 1))
+	srv.Handle("ttl", e.TTL(), Arity(2), Flags("readonly", "fast"), Keys(1, 1, 1))
+	srv.Handle("pttl", e.PTTL(), Arity(2), Flags("readonly", "fast"), Keys(1, 1, 1))
+	srv.Handle("persist", e.Persist(), Arity(2), Flags("write", "fast"), Keys(1, 1, 1))
+}
+
+// Close stops the timer wheel.
+func (e *Expirer) Close() {
+	e.closeOnce.Do(func() {
+		close(e.stop)
+		<-e.done
+	})
+}
+
+// Len returns the number of keys with an expiry.
+func (e *Expirer) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.entries)
+}
+
+// SetExpiry sets the expiry time of a key, replacing a previous one.
+// Keys with an expiry in the past are deleted on the next tick.
+func (e *Expirer) SetExpiry(db int, key string, at time.Time) {
+	e.mu.Lock()
+	e.schedule(expiryKey{db: db, key: key}, at)
+	e.mu.Unlock()
+}
+
+// Expiry returns the expiry time of a key, if any.
+func (e *Expirer) Expiry(db int, key string) (time.Time, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if ent, ok := e.entries[expiryKey{db: db, key: key}]; ok {
+		return ent.at, true
+	}
+	return time.Time{}, false
+}
+
+// ClearExpiry removes the expiry of a key. It returns true if the key had
+// an expiry.
+func (e *Expirer) ClearExpiry(db int, key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ent, ok := e.entries[expiryKey{db: db, key: key}]
+	if ok {
+		e.remove(ent)
+	}
+	return ok
+}
+
+// Expired returns true if the key is expired, in which case it is deleted
+// unless the timer wheel has done so already.
+func (e *Expirer) Expired(db int, key string) bool {
+	e.mu.Lock()
+	ent, ok := e.entries[expiryKey{db: db, key: key}]
+	if !ok || time.Now().Before(ent.at) {
+		e.mu.Unlock()
+		return false
+	}
+	e.remove(ent)
+	e.mu.Unlock()
+
+	e.expire(db, key)
+	return true
+}
+
+// Expire returns an EXPIRE handler.
+// https://redis.io/commands/expire
+func (e *Expirer) Expire() Handler {
+	return e.expireCommand(time.Second)
+}
+
+// PExpire returns a PEXPIRE handler.
+// https://redis.io/commands/pexpire
+func (e *Expirer) PExpire() Handler {
+	return e.expireCommand(time.Millisecond)
+}
+
+// TTL returns a TTL handler.
+// https://redis.io/commands/ttl
+func (e *Expirer) TTL() Handler {
+	return e.ttlCommand(time.Second)
+}
+
+// PTTL returns a PTTL handler.
+// https://redis.io/commands/pttl
+func (e *Expirer) PTTL() Handler {
+	return e.ttlCommand(time.Millisecond)
+}
+
+// Persist returns a PERSIST handler.
+// https://redis.io/commands/persist
+func (e *Expirer) Persist() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		db, key := ClientDB(c.Context()), c.Arg(0).String()
+		if !e.exists(db, key) || !e.ClearExpiry(db, key) {
+			w.AppendInt(0)
+			return
+		}
+		e.notify(db, "persist", key)
+		w.AppendInt(1)
+	})
+}
+
+func (e *Expirer) expireCommand(unit time.Duration) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() < 2 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		n, err := c.Arg(1).Int()
+		if err != nil {
+			w.AppendError("ERR value is not an integer or out of range")
+			return
+		}
+
+		var cond expiryCondition
+		for _, arg := range c.Args[2:] {
+			if !cond.parse(arg.String()) {
+				w.AppendError("ERR Unsupported option " + arg.String())
+				return
+			}
+		}
+		if msg := cond.validate(); msg != "" {
+			w.AppendError(msg)
+			return
+		}
+
+		now := time.Now()
+		if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) ||
+			time.Duration(n)*unit > time.Duration(math.MaxInt64-now.UnixNano()) {
+			w.AppendError("ERR invalid expire time in '" + strings.ToLower(c.Name) + "' command")
+			return
+		}
+
+		db, key := ClientDB(c.Context()), c.Arg(0).String()
+		if !e.exists(db, key) {
+			w.AppendInt(0)
+			return
+		}
+
+		at := now.Add(time.Duration(n) * unit)
+		switch e.update(expiryKey{db: db, key: key}, at, now, cond) {
+		case expiryUnchanged:
+			w.AppendInt(0)
+			return
+		case expiryDeleted:
+			e.conf.Delete(db, key)
+			e.notify(db, "del", key)
+		case expiryScheduled:
+			e.notify(db, "expire", key)
+		}
+		w.AppendInt(1)
+	})
+}
+
+func (e *Expirer) ttlCommand(unit time.Duration) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		db, key := ClientDB(c.Context()), c.Arg(0).String()
+		if !e.exists(db, key) {
+			w.AppendInt(-2)
+			return
+		}
+
+		at, ok := e.Expiry(db, key)
+		if !ok {
+			w.AppendInt(-1)
+			return
+		}
+
+		d := time.Until(at)
+		if d < 0 {
+			d = 0
+		}
+		w.AppendInt(int64((d + unit/2) / unit))
+	})
+}
+
+// exists returns true if a key exists and is not expired.
+func (e *Expirer) exists(db int, key string) bool {
+	return !e.Expired(db, key) && e.conf.Exists(db, key)
+}
+
+// update applies an expiry subject to cond.
+func (e *Expirer) update(k expiryKey, at, now time.Time, cond expiryCondition) expiryResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ent, ok := e.entries[k]
+	if !cond.allows(ent, at) {
+		return expiryUnchanged
+	}
+	if !at.After(now) {
+		if ok {
+			e.remove(ent)
+		}
+		return expiryDeleted
+	}
+	e.schedule(k, at)
+	return expiryScheduled
+}
+
+// schedule adds or moves an entry, must be called with the lock held.
+func (e *Expirer) schedule(k expiryKey, at time.Time) {
+	ent, ok := e.entries[k]
+	if ok {
+		ent.list.remove(ent)
+	} else {
+		ent = &expiryEntry{expiryKey: k}
+		e.entries[k] = ent
+	}
+	ent.at, ent.tick = at, e.tick(at)
+	e.wheel.add(ent)
+}
+
+// remove drops an entry, must be called with the lock held.
+func (e *Expirer) remove(ent *expiryEntry) {
+	ent.list.remove(ent)
+	delete(e.entries, ent.expiryKey)
+}
+
+// tick returns the tick of t, rounded up so keys are never expired early.
+func (e *Expirer) tick(t time.Time) int64 {
+	res := int64(e.conf.Resolution)
+	return (t.UnixNano() + res - 1) / res
+}
+
+// expire deletes an expired key.
+func (e *Expirer) expire(db int, key string) {
+	e.conf.Delete(db, key)
+
+	if srv := e.server(); srv != nil {
+		srv.Propagate(db, "del", key)
+		srv.NotifyKeyspaceEvent(db, "expired", key)
+	}
+}
+
+// notify reports a keyspace event, if installed to a server.
+func (e *Expirer) notify(db int, event, key string) {
+	if srv := e.server(); srv != nil {
+		srv.NotifyKeyspaceEvent(db, event, key)
+	}
+}
+
+func (e *Expirer) server() *Server {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.srv
+}
+
+func (e *Expirer) loop() {
+	defer close(e.done)
+
+	t := time.NewTicker(e.conf.Resolution)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case now := <-t.C:
+			e.advance(now)
+		}
+	}
+}
+
+// advance turns the wheel to now and expires due keys.
+func (e *Expirer) advance(now time.Time) {
+	target := now.UnixNano() / int64(e.conf.Resolution)
+
+	var due []*expiryEntry
+	e.mu.Lock()
+	if len(e.entries) == 0 && target > e.wheel.now {
+		e.wheel.now = target
+	}
+	for e.wheel.now < target {
+		due = e.wheel.advance(due)
+	}
+	for _, ent := range due {
+		delete(e.entries, ent.expiryKey)
+	}
+	e.mu.Unlock()
+
+	// delete outside the lock, so callbacks may use the expirer
+	for _, ent := range due {
+		e.expire(ent.db, ent.key)
+	}
+}
+
+// --------------------------------------------------------------------
+
+type expiryResult int
+
+const (
+	expiryUnchanged expiryResult = iota
+	expiryScheduled
+	expiryDeleted
+)
+
+// expiryCondition holds the NX, XX, GT and LT options of EXPIRE.
+type expiryCondition struct{ nx, xx, gt, lt bool }
+
+func (c *expiryCondition) parse(opt string) bool {
+	switch strings.ToLower(opt) {
+	case "nx":
+		c.nx = true
+	case "xx":
+		c.xx = true
+	case "gt":
+		c.gt = true
+	case "lt":
+		c.lt = true
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *expiryCondition) validate() string {
+	if c.nx && (c.xx || c.gt || c.lt) {
+		return "ERR NX and XX, GT or LT options at the same time are not compatible"
+	}
+	if c.gt && c.lt {
+		return "ERR GT and LT options at the same time are not compatible"
+	}
+	return ""
+}
+
+// allows returns true if at may replace the expiry of cur, which is nil
+// for keys without an expiry. Those count as an infinite expiry.
+func (c *expiryCondition) allows(cur *expiryEntry, at time.Time) bool {
+	switch {
+	case c.nx:
+		return cur == nil
+	case c.xx && cur == nil:
+		return false
+	case c.gt:
+		return cur != nil && at.After(cur.at)
+	case c.lt:
+		return cur == nil || at.Before(cur.at)
+	}
+	return true
+}
+
+type expiryKey struct {
+	db  int
+	key string
+}
+
+type expiryEntry struct {
+	expiryKey
+	at   time.Time
+	tick int64
+
+	prev, next *expiryEntry
+	list       *expiryList
+}
+
+// expiryList is an intrusive, doubly linked list of entries.
+type expiryList struct{ head *expiryEntry }
+
+func (l *expiryList) push(ent *expiryEntry) {
+	ent.list, ent.prev, ent.next = l, nil, l.head
+	if l.head != nil {
+		l.head.prev = ent
+	}
+	l.head = ent
+}
+
+func (l *expiryList) remove(ent *expiryEntry) {
+	if ent.prev != nil {
+		ent.prev.next = ent.next
+	} else {
+		l.head = ent.next
+	}
+	if ent.next != nil {
+		ent.next.prev = ent.prev
+	}
+	ent.list, ent.prev, ent.next = nil, nil, nil
+}
+
+// take empties the list, returning its entries.
+func (l *expiryList) take(dst []*expiryEntry) []*expiryEntry {
+	for ent := l.head; ent != nil; {
+		next := ent.next
+		ent.list, ent.prev, ent.next = nil, nil, nil
+		dst = append(dst, ent)
+		ent = next
+	}
+	l.head = nil
+	return dst
+}
+
+// --------------------------------------------------------------------
+
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelLevels = 6
+)
+
+// timerWheel is a hierarchical timer wheel. Each level spans wheelSize
+// slots of the level below, entries beyond the top level are kept in an
+// overflow list. Entries cascade to lower levels as the wheel turns.
+type timerWheel struct {
+	now      int64 // the current tick
+	levels   [wheelLevels][wheelSize]expiryList
+	overflow expiryList
+	buf      []*expiryEntry
+}
+
+// add places an entry in the slot of its tick, entries which are already
+// due are placed in the next slot.
+func (w *timerWheel) add(ent *expiryEntry) {
+	t := ent.tick
+	if t <= w.now {
+		t = w.now + 1
+	}
+
+	for l := 0; l < wheelLevels; l++ {
+		shift := uint(wheelBits * (l + 1))
+		if t>>shift == w.now>>shift {
+			w.levels[l][(t>>(shift-wheelBits))&(wheelSize-1)].push(ent)
+			return
+		}
+	}
+	w.overflow.push(ent)
+}
+
+// advance moves the wheel by one tick, appending the due entries to dst.
+func (w *timerWheel) advance(dst []*expiryEntry) []*expiryEntry {
+	w.now++
+
+	if w.now&(1<<(wheelBits*wheelLevels)-1) == 0 {
+		w.cascade(&w.overflow)
+	}
+	for l := wheelLevels - 1; l > 0; l-- {
+		shift := uint(wheelBits * l)
+		if w.now&(1<<shift-1) == 0 {
+			w.cascade(&w.levels[l][(w.now>>shift)&(wheelSize-1)])
+		}
+	}
+	return w.levels[0][w.now&(wheelSize-1)].take(dst)
+}
+
+// cascade re-places the entries of a slot.
+func (w *timerWheel) cascade(l *expiryList) {
+	w.buf = l.take(w.buf[:0])
+	for _, ent := range w.buf {
+		w.add(ent)
+	}
+	for i := range w.buf {
+		w.buf[i] = nil
+	}
+}
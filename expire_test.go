@@ -0,0 +1,200 @@
+package redeo
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Expirer", func() {
+	var subject *Expirer
+	var keys map[string]bool
+	var mu sync.Mutex
+
+	var has = func(key string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return keys[key]
+	}
+
+	var serve = func(h Handler, args ...string) interface{} {
+		cargs := make([]resp.CommandArgument, len(args))
+		for i, arg := range args {
+			cargs[i] = resp.CommandArgument(arg)
+		}
+
+		w := redeotest.NewRecorder()
+		h.ServeRedeo(w, resp.NewCommand("EXPIRE", cargs...))
+
+		res, err := w.Response()
+		Expect(err).NotTo(HaveOccurred())
+		return res
+	}
+
+	BeforeEach(func() {
+		keys = map[string]bool{"foo": true, "bar": true}
+		subject = NewExpirer(&ExpirerConfig{
+			Exists: func(_ int, key string) bool { return has(key) },
+			Delete: func(_ int, key string) {
+				mu.Lock()
+				delete(keys, key)
+				mu.Unlock()
+			},
+			Resolution: time.Millisecond,
+		})
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should set and report expiries", func() {
+		Expect(serve(subject.Expire(), "foo", "100")).To(Equal(int64(1)))
+		Expect(serve(subject.Expire(), "baz", "100")).To(Equal(int64(0)))
+		Expect(serve(subject.TTL(), "foo")).To(Equal(int64(100)))
+		Expect(serve(subject.PTTL(), "foo")).To(BeNumerically("~", 100000, 100))
+		Expect(serve(subject.TTL(), "bar")).To(Equal(int64(-1)))
+		Expect(serve(subject.TTL(), "baz")).To(Equal(int64(-2)))
+		Expect(subject.Len()).To(Equal(1))
+
+		Expect(serve(subject.PExpire(), "bar", "50000")).To(Equal(int64(1)))
+		Expect(serve(subject.TTL(), "bar")).To(Equal(int64(50)))
+
+		Expect(serve(subject.Persist(), "foo")).To(Equal(int64(1)))
+		Expect(serve(subject.Persist(), "foo")).To(Equal(int64(0)))
+		Expect(serve(subject.TTL(), "foo")).To(Equal(int64(-1)))
+		Expect(subject.Len()).To(Equal(1))
+	})
+
+	It("should support conditions", func() {
+		Expect(serve(subject.Expire(), "foo", "100", "XX")).To(Equal(int64(0)))
+		Expect(serve(subject.Expire(), "foo", "100", "GT")).To(Equal(int64(0)))
+		Expect(serve(subject.Expire(), "foo", "100", "NX")).To(Equal(int64(1)))
+		Expect(serve(subject.Expire(), "foo", "200", "NX")).To(Equal(int64(0)))
+		Expect(serve(subject.Expire(), "foo", "50", "GT")).To(Equal(int64(0)))
+		Expect(serve(subject.Expire(), "foo", "200", "gt")).To(Equal(int64(1)))
+		Expect(serve(subject.Expire(), "foo", "300", "LT")).To(Equal(int64(0)))
+		Expect(serve(subject.Expire(), "foo", "150", "XX", "LT")).To(Equal(int64(1)))
+		Expect(serve(subject.TTL(), "foo")).To(Equal(int64(150)))
+		Expect(serve(subject.Expire(), "bar", "100", "LT")).To(Equal(int64(1)))
+	})
+
+	It("should validate arguments", func() {
+		Expect(serve(subject.Expire(), "foo")).To(MatchError("ERR wrong number of arguments for 'EXPIRE' command"))
+		Expect(serve(subject.Expire(), "foo", "x")).To(MatchError("ERR value is not an integer or out of range"))
+		Expect(serve(subject.Expire(), "foo", "10", "YY")).To(MatchError("ERR Unsupported option YY"))
+		Expect(serve(subject.Expire(), "foo", "10", "NX", "GT")).To(MatchError("ERR NX and XX, GT or LT options at the same time are not compatible"))
+		Expect(serve(subject.Expire(), "foo", "10", "GT", "LT")).To(MatchError("ERR GT and LT options at the same time are not compatible"))
+		Expect(serve(subject.Expire(), "foo", "9223372036854775807")).To(MatchError("ERR invalid expire time in 'expire' command"))
+		Expect(serve(subject.TTL())).To(MatchError("ERR wrong number of arguments for 'EXPIRE' command"))
+	})
+
+	It("should delete keys", func() {
+		Expect(serve(subject.Expire(), "foo", "-1")).To(Equal(int64(1)))
+		Expect(has("foo")).To(BeFalse())
+
+		Expect(serve(subject.PExpire(), "bar", "20")).To(Equal(int64(1)))
+		Expect(has("bar")).To(BeTrue())
+		Eventually(func() bool { return has("bar") }).Should(BeFalse())
+		Expect(subject.Len()).To(Equal(0))
+	})
+
+	It("should expire keys lazily", func() {
+		subject.Close()
+		subject.SetExpiry(0, "foo", time.Now().Add(-time.Second))
+		Expect(has("foo")).To(BeTrue())
+		Expect(serve(subject.TTL(), "foo")).To(Equal(int64(-2)))
+		Expect(has("foo")).To(BeFalse())
+
+		subject.SetExpiry(0, "bar", time.Now().Add(time.Hour))
+		Expect(subject.Expired(0, "bar")).To(BeFalse())
+		Expect(subject.ClearExpiry(0, "bar")).To(BeTrue())
+		Expect(subject.Expiry(0, "bar")).To(BeZero())
+	})
+
+	It("should notify servers", func() {
+		var events []KeyspaceEvent
+		srv := NewServer(nil)
+		srv.OnKeyspaceEvent(func(ev KeyspaceEvent) {
+			mu.Lock()
+			events = append(events, ev)
+			mu.Unlock()
+		})
+		subject.InstallTo(srv)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go srv.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmdString("PEXPIRE", "foo", "10")
+		cw.WriteCmdString("PTTL", "foo")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadInt()).To(Equal(int64(1)))
+		Expect(cr.ReadInt()).To(BeNumerically("<=", 10))
+
+		Eventually(func() []KeyspaceEvent {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]KeyspaceEvent(nil), events...)
+		}).Should(Equal([]KeyspaceEvent{
+			{Event: "expire", Key: "foo"},
+			{Event: "expired", Key: "foo"},
+		}))
+	})
+
+})
+
+var _ = Describe("timerWheel", func() {
+
+	It("should fire entries on their tick", func() {
+		w := &timerWheel{now: 1000}
+		ticks := []int64{900, 1001, 1063, 1064, 5000, 1000 + 64*64*64 + 7}
+
+		fired := make(map[int64]int64)
+		for _, t := range ticks {
+			w.add(&expiryEntry{tick: t})
+		}
+		for w.now < ticks[len(ticks)-1] {
+			for _, ent := range w.advance(nil) {
+				Expect(ent.list).To(BeNil())
+				fired[ent.tick] = w.now
+			}
+		}
+		Expect(fired).To(Equal(map[int64]int64{
+			900:                 1001,
+			1001:                1001,
+			1063:                1063,
+			1064:                1064,
+			5000:                5000,
+			1000 + 64*64*64 + 7: 1000 + 64*64*64 + 7,
+		}))
+	})
+
+	It("should cascade overflowing entries", func() {
+		top := int64(1) << (wheelBits * wheelLevels)
+		w := &timerWheel{now: top - 10}
+		ent := &expiryEntry{tick: top + 5}
+		w.add(ent)
+		Expect(ent.list).To(Equal(&w.overflow))
+
+		var fired int64
+		for w.now < top+5 {
+			if due := w.advance(nil); len(due) != 0 {
+				fired = w.now
+			}
+		}
+		Expect(fired).To(Equal(top + 5))
+	})
+
+})
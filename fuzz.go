@@ -0,0 +1,60 @@
+package redeo
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+// ServeBytes serves data as the input of a single connection and returns
+// the output, once the input is consumed. It feeds the data through the
+// full client pipeline, i.e. reading, dispatch and replies, on the calling
+// goroutine, e.g. for fuzzing the protocol path. The connection is closed
+// at the end of the input, replies of blocked or asynchronous commands may
+// therefore be missing.
+func (srv *Server) ServeBytes(data []byte) []byte {
+	cn := &bytesConn{rd: bytes.NewReader(data)}
+	if !srv.acquireSlot() {
+		srv.reject(cn)
+		return cn.output()
+	}
+
+	_ = srv.serveClient(srv.newClient(cn), true)
+	return cn.output()
+}
+
+// bytesConn is a connection which reads from a fixed input and records
+// its output, see ServeBytes.
+type bytesConn struct {
+	rd *bytes.Reader
+
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (c *bytesConn) Read(p []byte) (int, error) { return c.rd.Read(p) }
+
+func (c *bytesConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+func (c *bytesConn) output() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.buf.Bytes()...)
+}
+
+func (c *bytesConn) Close() error                       { return nil }
+func (c *bytesConn) LocalAddr() net.Addr                { return bytesAddr{} }
+func (c *bytesConn) RemoteAddr() net.Addr               { return bytesAddr{} }
+func (c *bytesConn) SetDeadline(_ time.Time) error      { return nil }
+func (c *bytesConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (c *bytesConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+type bytesAddr struct{}
+
+func (bytesAddr) Network() string { return "bytes" }
+func (bytesAddr) String() string  { return "bytes" }
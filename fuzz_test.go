@@ -0,0 +1,88 @@
+package redeo
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+func FuzzServeBytes(f *testing.F) {
+	for _, seed := range []string{
+		"PING\r\n",
+		"*1\r\n$4\r\nPING\r\n",
+		"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n",
+		"ECHO \"quoted \\x41rg\"\r\n",
+		"MULTI\r\nSET a b\r\nEXEC\r\n",
+		"*2\r\n$4\r\nECHO\r\n$5\r\nhel",
+		"*2\r\n$4\r\nECHO\r\n$99999999999\r\n",
+		"*99999999999\r\n",
+		"*-1\r\n$-5\r\n",
+		"*2\r\n$3\r\nLEN\r\n$3\r\nabc\r\n",
+		"ECHO \"unbalanced\r\n",
+		"\x00\xff\r\n",
+		"*1\n$2\n0\n00",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out := newFuzzServer().ServeBytes(data)
+
+		r := resp.NewResponseReader(bytes.NewReader(out))
+		for {
+			if _, err := resp.ReadReply(r); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("invalid output %q for %q: %v", out, data, err)
+			}
+		}
+	})
+}
+
+// newFuzzServer returns a server with a set of mock handlers.
+func newFuzzServer() *Server {
+	srv := NewServer(&Config{
+		Transactions:       true,
+		MaxBulkLength:      1 << 16,
+		MaxMultiBulkLength: 1024,
+	})
+	srv.Handle("ping", Ping())
+	srv.Handle("echo", Echo())
+
+	var mu sync.Mutex
+	vals := make(map[string]string)
+	srv.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+		mu.Lock()
+		vals[c.Arg(0).String()] = c.Arg(1).String()
+		mu.Unlock()
+		w.AppendOK()
+	}, Arity(3), Flags("write"), Keys(1, 1, 1))
+	srv.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+		mu.Lock()
+		v, ok := vals[c.Arg(0).String()]
+		mu.Unlock()
+		if !ok {
+			w.AppendNil()
+			return
+		}
+		w.AppendBulkString(v)
+	}, Arity(2), Flags("readonly"), Keys(1, 1, 1))
+	srv.HandleStreamFunc("len", func(w resp.ResponseWriter, c *resp.CommandStream) {
+		var n int64
+		for c.More() {
+			arg, err := c.Next()
+			if err != nil {
+				w.AppendError("ERR " + err.Error())
+				return
+			}
+			m, _ := io.Copy(ioutil.Discard, arg)
+			n += m
+		}
+		w.AppendInt(n)
+	})
+	return srv
+}
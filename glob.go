@@ -0,0 +1,138 @@
+package redeo
+
+// MatchPattern reports whether key matches the glob-style pattern, like
+// redis' KEYS, SCAN MATCH and PSUBSCRIBE. Supported are '*', '?',
+// character classes such as [abc], [^abc] and [a-z], as well as escaping
+// via '\'. Use CompilePattern to match a pattern repeatedly.
+func MatchPattern(pattern, key string) bool {
+	return CompilePattern(pattern).Match(key)
+}
+
+// Pattern is a compiled glob-style pattern, see MatchPattern.
+type Pattern struct {
+	src   string
+	elems []patternElem
+}
+
+// CompilePattern compiles a glob-style pattern. All patterns are valid,
+// malformed ones are interpreted like redis does, e.g. an unterminated
+// class ends the pattern.
+func CompilePattern(pattern string) *Pattern {
+	p := &Pattern{src: pattern}
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			if n := len(p.elems); n == 0 || p.elems[n-1].kind != patternStar {
+				p.elems = append(p.elems, patternElem{kind: patternStar})
+			}
+		case '?':
+			p.elems = append(p.elems, patternElem{kind: patternAny})
+		case '[':
+			var e patternElem
+			i = e.parseClass(pattern, i+1)
+			p.elems = append(p.elems, e)
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			fallthrough
+		default:
+			p.elems = append(p.elems, patternElem{kind: patternChar, char: pattern[i]})
+		}
+	}
+	return p
+}
+
+// String returns the source of the pattern.
+func (p *Pattern) String() string { return p.src }
+
+// Match reports whether key matches the pattern.
+func (p *Pattern) Match(key string) bool {
+	// each element other than a star matches a single byte, so it is
+	// sufficient to backtrack to the last star
+	star, next := -1, 0
+	i, j := 0, 0
+	for j < len(key) {
+		if i < len(p.elems) {
+			if e := &p.elems[i]; e.kind == patternStar {
+				star, next = i, j
+				i++
+				continue
+			} else if e.match(key[j]) {
+				i++
+				j++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		next++
+		i, j = star+1, next
+	}
+
+	for i < len(p.elems) && p.elems[i].kind == patternStar {
+		i++
+	}
+	return i == len(p.elems)
+}
+
+// --------------------------------------------------------------------
+
+const (
+	patternChar = iota
+	patternAny
+	patternClass
+	patternStar
+)
+
+type patternElem struct {
+	kind  uint8
+	char  byte
+	not   bool
+	class [4]uint64 // bitset of a character class
+}
+
+// parseClass parses the character class starting at i, after the
+// opening bracket. It returns the position of the closing bracket.
+func (e *patternElem) parseClass(pattern string, i int) int {
+	e.kind = patternClass
+	if i < len(pattern) && pattern[i] == '^' {
+		e.not = true
+		i++
+	}
+
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			e.set(pattern[i], pattern[i])
+		case i+2 < len(pattern) && pattern[i+1] == '-':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			e.set(lo, hi)
+			i += 2
+		default:
+			e.set(pattern[i], pattern[i])
+		}
+	}
+	return i
+}
+
+func (e *patternElem) set(lo, hi byte) {
+	for c := int(lo); c <= int(hi); c++ {
+		e.class[c>>6] |= 1 << (uint(c) & 63)
+	}
+}
+
+func (e *patternElem) match(c byte) bool {
+	switch e.kind {
+	case patternChar:
+		return e.char == c
+	case patternClass:
+		return (e.class[c>>6]&(1<<(c&63)) != 0) != e.not
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package redeo
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("MatchPattern",
+	func(pattern, s string, exp bool) {
+		Expect(MatchPattern(pattern, s)).To(Equal(exp))
+		Expect(CompilePattern(pattern).Match(s)).To(Equal(exp))
+	},
+
+	Entry("exact", "foo", "foo", true),
+	Entry("mismatch", "foo", "bar", false),
+	Entry("star", "f*", "foo", true),
+	Entry("star (empty)", "foo*", "foo", true),
+	Entry("star (infix)", "f*o", "fxxo", true),
+	Entry("star (infix mismatch)", "f*o", "fxxy", false),
+	Entry("question mark", "f?o", "foo", true),
+	Entry("question mark (too short)", "fo?", "fo", false),
+	Entry("class", "h[ae]llo", "hallo", true),
+	Entry("class (mismatch)", "h[ae]llo", "hillo", false),
+	Entry("negated class", "h[^e]llo", "hallo", true),
+	Entry("negated class (mismatch)", "h[^e]llo", "hello", false),
+	Entry("range", "h[a-f]llo", "hello", true),
+	Entry("range (mismatch)", "h[a-f]llo", "hzllo", false),
+	Entry("escape", `h\*llo`, "h*llo", true),
+	Entry("escape (mismatch)", `h\*llo`, "hello", false),
+	Entry("empty", "", "", true),
+	Entry("empty (mismatch)", "", "a", false),
+	Entry("stars", "**", "", true),
+	Entry("star (backtrack)", "*a*b", "xaxxab", true),
+	Entry("star (pathological)", "a*a*a*a*a*a*a*a*b", strings.Repeat("a", 64), false),
+	Entry("escape in class", `[\]]`, "]", true),
+	Entry("reversed range", "[z-a]", "m", true),
+	Entry("empty class", "[]", "a", false),
+	Entry("unterminated class", "a[bc", "ab", true),
+	Entry("unterminated class (too long)", "a[bc", "abc", false),
+	Entry("trailing backslash", `a\`, `a\`, true),
+)
@@ -6,6 +6,6 @@ require (
 	github.com/onsi/gomega v1.4.3
 	golang.org/x/net v0.0.0-20181129055619-fae4c4e3ad76 // indirect
 	golang.org/x/sync v0.0.0-20181108010431-42b317875d0f // indirect
-	golang.org/x/sys v0.0.0-20181128092732-4ed8d59d0b35 // indirect
+	golang.org/x/sys v0.0.0-20181128092732-4ed8d59d0b35
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 )
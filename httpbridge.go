@@ -0,0 +1,161 @@
+package redeo
+
+import (
+	"encoding/json"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// maxHTTPCommandSize limits the request body of the HTTP bridge.
+const maxHTTPCommandSize = 1 << 20
+
+// HTTPHandler returns an http.Handler which executes one-shot commands
+// sent via POST, e.g. for environments which cannot open raw TCP
+// connections. The body is a JSON array of strings, the command name
+// followed by its arguments:
+//
+//	["SET", "key", "value"]
+//
+// The reply is returned as a JSON object, either {"result": ...} or
+// {"error": "..."} for error replies. Credentials of HTTP basic
+// authentication are sent via AUTH first. Each request is served like a
+// separate connection. Requests must have the Content-Type
+// application/json, which browsers cannot send cross-origin without
+// a CORS preflight.
+func (srv *Server) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+			http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var args []string
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHTTPCommandSize)).Decode(&args); err != nil || len(args) == 0 {
+			http.Error(w, "body must be a JSON array of strings", http.StatusBadRequest)
+			return
+		}
+
+		cn, sn := net.Pipe()
+		defer cn.Close()
+		go func() { _ = srv.serveHTTPConn(&httpConn{Conn: sn, remote: httpAddr(r.RemoteAddr)}) }()
+
+		ctx := r.Context()
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = cn.Close()
+			case <-stop:
+			}
+		}()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		user, pass, hasAuth := r.BasicAuth()
+		if hasAuth {
+			if user != "" {
+				cw.WriteCmdString("AUTH", user, pass)
+			} else {
+				cw.WriteCmdString("AUTH", pass)
+			}
+		}
+		cw.WriteCmdString(args[0], args[1:]...)
+		if err := cw.Flush(); err != nil {
+			http.Error(w, "server unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		if hasAuth {
+			rp, err := resp.ReadReply(cr)
+			if err != nil {
+				http.Error(w, "server unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			if err := rp.Err(); err != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="redeo"`)
+				writeHTTPReply(w, http.StatusUnauthorized, map[string]interface{}{"error": err.Error()})
+				return
+			}
+		}
+
+		rp, err := resp.ReadReply(cr)
+		if err != nil {
+			http.Error(w, "server unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if err := rp.Err(); err != nil {
+			writeHTTPReply(w, http.StatusOK, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		writeHTTPReply(w, http.StatusOK, map[string]interface{}{"result": replyValue(rp)})
+	})
+}
+
+func writeHTTPReply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// replyValue converts a reply into a value which can be encoded as JSON.
+func replyValue(rp *resp.Reply) interface{} {
+	switch rp.Type {
+	case resp.TypeNil:
+		return nil
+	case resp.TypeError:
+		return map[string]interface{}{"error": rp.Err().Error()}
+	case resp.TypeInt:
+		n, _ := rp.Int()
+		return n
+	case resp.TypeBool:
+		n, _ := rp.Int()
+		return n != 0
+	case resp.TypeDouble:
+		s, _ := rp.Str()
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+		return s
+	case resp.TypeMap:
+		elems, _ := rp.Slice()
+		m := make(map[string]interface{}, len(elems)/2)
+		for i := 0; i+1 < len(elems); i += 2 {
+			key, _ := elems[i].Str()
+			m[key] = replyValue(elems[i+1])
+		}
+		return m
+	case resp.TypeArray, resp.TypeSet, resp.TypePush:
+		elems, _ := rp.Slice()
+		vals := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			vals[i] = replyValue(elem)
+		}
+		return vals
+	}
+
+	s, _ := rp.Str()
+	return s
+}
+
+// httpConn reports the address of the HTTP client.
+type httpConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *httpConn) RemoteAddr() net.Addr { return c.remote }
+
+// httpAddr is the remote address of an HTTP request.
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
@@ -0,0 +1,96 @@
+package redeo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server.HTTPHandler", func() {
+	var subject *Server
+	var hs *httptest.Server
+
+	var post = func(body, user, pass string) (int, string) {
+		req, err := http.NewRequest("POST", hs.URL, strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Content-Type", "application/json")
+		if pass != "" {
+			req.SetBasicAuth(user, pass)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+
+		data, err := ioutil.ReadAll(res.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return res.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	BeforeEach(func() {
+		subject = NewServer(nil)
+		subject.Handle("ping", Ping())
+		subject.Handle("echo", Echo())
+		subject.HandleFunc("list", func(w resp.ResponseWriter, _ *resp.Command) {
+			w.AppendArrayLen(4)
+			w.AppendBulkString("a")
+			w.AppendInt(1)
+			w.AppendNil()
+			w.AppendArrayLen(1)
+			w.AppendInlineString("b")
+		})
+		hs = httptest.NewServer(subject.HTTPHandler())
+	})
+
+	AfterEach(func() {
+		hs.Close()
+	})
+
+	It("should execute commands", func() {
+		status, body := post(`["PING"]`, "", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal(`{"result":"PONG"}`))
+
+		_, body = post(`["echo", "hello"]`, "", "")
+		Expect(body).To(Equal(`{"result":"hello"}`))
+		_, body = post(`["ECHO"]`, "", "")
+		Expect(body).To(Equal(`{"error":"ERR wrong number of arguments for 'ECHO' command"}`))
+		_, body = post(`["LIST"]`, "", "")
+		Expect(body).To(Equal(`{"result":["a",1,null,["b"]]}`))
+	})
+
+	It("should reject bad requests", func() {
+		res, err := http.Get(hs.URL)
+		Expect(err).NotTo(HaveOccurred())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+
+		// simple cross-origin requests
+		res, err = http.Post(hs.URL, "text/plain", strings.NewReader(`["PING"]`))
+		Expect(err).NotTo(HaveOccurred())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusUnsupportedMediaType))
+
+		status, _ := post(`"PING"`, "", "")
+		Expect(status).To(Equal(http.StatusBadRequest))
+		status, _ = post(`[]`, "", "")
+		Expect(status).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should authenticate", func() {
+		Expect(subject.SetConfig("requirepass", "secret")).To(Succeed())
+
+		_, body := post(`["PING"]`, "", "")
+		Expect(body).To(Equal(`{"error":"NOAUTH Authentication required."}`))
+		status, _ := post(`["PING"]`, "", "wrong")
+		Expect(status).To(Equal(http.StatusUnauthorized))
+		_, body = post(`["PING"]`, "", "secret")
+		Expect(body).To(Equal(`{"result":"PONG"}`))
+	})
+
+})
@@ -3,16 +3,21 @@ package redeo
 import (
 	cryptorand "crypto/rand"
 	"encoding/hex"
+	"expvar"
 	"fmt"
 	"github.com/cornelk/hashmap"
+	"math"
 	mathrand "math/rand"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/wangaoone/redeo/info"
+	"github.com/wangaoone/redeo/resp"
 )
 
 // CommandDescription describes supported commands
@@ -44,6 +49,34 @@ type CommandDescription struct {
 	KeyStepCount int64
 }
 
+func (cmd *CommandDescription) appendTo(w resp.ResponseWriter) {
+	w.AppendArrayLen(6)
+	w.AppendBulkString(strings.ToLower(cmd.Name))
+	w.AppendInt(cmd.Arity)
+	w.AppendArrayLen(len(cmd.Flags))
+	for _, flag := range cmd.Flags {
+		w.AppendBulkString(flag)
+	}
+	w.AppendInt(cmd.FirstKey)
+	w.AppendInt(cmd.LastKey)
+	w.AppendInt(cmd.KeyStepCount)
+}
+
+// validArgs returns true if the number of arguments, excluding the
+// command name, satisfies the arity.
+func (cmd *CommandDescription) validArgs(n int) bool {
+	argc := int64(n + 1)
+	if cmd.Arity < 0 {
+		return argc >= -cmd.Arity
+	}
+	return cmd.Arity == 0 || argc == cmd.Arity
+}
+
+// keys returns the key arguments, as located by the key positions.
+func (cmd *CommandDescription) keys(args []resp.CommandArgument) []resp.CommandArgument {
+	return resp.KeySpec{First: int(cmd.FirstKey), Last: int(cmd.LastKey), Step: int(cmd.KeyStepCount)}.Locate(args)
+}
+
 // --------------------------------------------------------------------
 
 // ClientInfo contains client stats
@@ -56,6 +89,12 @@ type ClientInfo struct {
 	// RemoteAddr is the remote address string
 	RemoteAddr string
 
+	// Name is the name of the client, as set by CLIENT SETNAME
+	Name string
+
+	// DB is the database index, as set by SELECT
+	DB int
+
 	// LastCmd is the last command called by this client
 	LastCmd string
 
@@ -65,6 +104,19 @@ type ClientInfo struct {
 
 	// AccessTime returns the time of the last access
 	AccessTime time.Time
+
+	// NetInput is the number of bytes received from the client
+	NetInput int64
+
+	// NetOutput is the number of bytes sent to the client
+	NetOutput int64
+
+	// OutputBuffer is the number of bytes of unflushed and pending replies
+	OutputBuffer int64
+
+	// RateLimited is the number of commands which were delayed or
+	// rejected, see Config.RateLimit
+	RateLimited int64
 }
 
 func newClientInfo(c *Client, now time.Time) *ClientInfo {
@@ -80,12 +132,18 @@ func newClientInfo(c *Client, now time.Time) *ClientInfo {
 // String generates an info string
 func (i *ClientInfo) String() string {
 	now := time.Now()
-	return fmt.Sprintf("id=%d addr=%s age=%d idle=%d cmd=%s",
+	return fmt.Sprintf("id=%d addr=%s name=%s age=%d idle=%d db=%d cmd=%s tot-net-in=%d tot-net-out=%d omem=%d rate-limited=%d",
 		i.ID,
 		i.RemoteAddr,
+		i.Name,
 		now.Sub(i.CreateTime)/time.Second,
 		now.Sub(i.AccessTime)/time.Second,
+		i.DB,
 		i.LastCmd,
+		i.NetInput,
+		i.NetOutput,
+		i.OutputBuffer,
+		i.RateLimited,
 	)
 }
 
@@ -96,13 +154,40 @@ type ServerInfo struct {
 	registry *info.Registry
 
 	startTime time.Time
-	port      string
-	socket    string
+	port      *info.IntValue
+	socket    *info.StringValue
 	pid       int
 
 	clients     *clientReadStats
+	peakClients int64 // atomic, see PeakClients
 	connections *info.IntValue
 	commands    *info.IntValue
+	panics      *info.IntValue
+	rejected    *info.IntValue
+	unknown     *info.IntValue
+	idle        *info.IntValue
+	monitors    *info.IntValue
+	blocked     *info.IntValue
+
+	droppedEvents *info.IntValue
+
+	rateLimited        *info.IntValue
+	killedOutputBuffer *info.IntValue
+
+	killedSoftOutputBuffer *info.IntValue
+	killed                 *info.IntValue
+
+	droppedTimeout  *info.IntValue
+	droppedProtocol *info.IntValue
+	droppedRead     *info.IntValue
+	droppedWrite    *info.IntValue
+
+	// traffic of disconnected clients, see TotalNetInputBytes
+	netInput  *info.IntValue
+	netOutput *info.IntValue
+	netMeter  netMeter
+
+	cmdstats *hashmap.HashMap
 }
 
 // newServerInfo creates a new server info container
@@ -110,8 +195,34 @@ func newServerInfo() *ServerInfo {
 	info := &ServerInfo{
 		registry:    info.New(),
 		startTime:   time.Now(),
+		port:        info.NewIntValue(0),
+		socket:      info.NewStringValue(""),
 		connections: info.NewIntValue(0),
 		commands:    info.NewIntValue(0),
+		panics:      info.NewIntValue(0),
+		rejected:    info.NewIntValue(0),
+		unknown:     info.NewIntValue(0),
+		idle:        info.NewIntValue(0),
+		monitors:    info.NewIntValue(0),
+		blocked:     info.NewIntValue(0),
+
+		droppedEvents: info.NewIntValue(0),
+
+		rateLimited:        info.NewIntValue(0),
+		killedOutputBuffer: info.NewIntValue(0),
+
+		killedSoftOutputBuffer: info.NewIntValue(0),
+		killed:                 info.NewIntValue(0),
+
+		droppedTimeout:  info.NewIntValue(0),
+		droppedProtocol: info.NewIntValue(0),
+		droppedRead:     info.NewIntValue(0),
+		droppedWrite:    info.NewIntValue(0),
+
+		netInput:  info.NewIntValue(0),
+		netOutput: info.NewIntValue(0),
+
+		cmdstats:    &hashmap.HashMap{},
 		// clients:     clientStats{stats: make(map[uint64]*ClientInfo)},
 		clients:     &clientReadStats{ stats: &hashmap.HashMap{} },
 	}
@@ -133,9 +244,19 @@ func (i *ServerInfo) String() string { return i.registry.String() }
 // NumClients returns the number of connected clients
 func (i *ServerInfo) NumClients() int { return i.clients.Len() }
 
+// PeakClients returns the highest number of concurrently connected clients
+// since the server was started or the stats were reset.
+func (i *ServerInfo) PeakClients() int64 { return atomic.LoadInt64(&i.peakClients) }
+
 // ClientInfo returns details about connected clients
 func (i *ServerInfo) ClientInfo() []*ClientInfo { return i.clients.Stats() }
 
+// NumBlocked returns the number of clients blocked by a command
+func (i *ServerInfo) NumBlocked() int { return int(i.blocked.Value()) }
+
+// NumMonitors returns the number of clients in MONITOR mode
+func (i *ServerInfo) NumMonitors() int { return int(i.monitors.Value()) }
+
 // Clients returns all connected clients
 func (i *ServerInfo) Clients() []*Client { return i.clients.All() }
 
@@ -150,6 +271,168 @@ func (i *ServerInfo) TotalConnections() int64 { return i.connections.Value() }
 // of the server.
 func (i *ServerInfo) TotalCommands() int64 { return i.commands.Value() }
 
+// TotalPanics returns the total number of handler panics recovered since
+// the start of the server.
+func (i *ServerInfo) TotalPanics() int64 { return i.panics.Value() }
+
+// RejectedConnections returns the number of connections rejected because
+// of the MaxClients limit or by Config.OnConnect.
+func (i *ServerInfo) RejectedConnections() int64 { return i.rejected.Value() }
+
+// UnknownCommands returns the number of commands rejected because no
+// handler, nor a fallback handler, was registered.
+func (i *ServerInfo) UnknownCommands() int64 { return i.unknown.Value() }
+
+// DroppedEvents returns the number of command events which were dropped
+// because subscribers could not keep up.
+func (i *ServerInfo) DroppedEvents() int64 { return i.droppedEvents.Value() }
+
+// RateLimitedCommands returns the number of commands which were delayed
+// or rejected, see Config.RateLimit.
+func (i *ServerInfo) RateLimitedCommands() int64 { return i.rateLimited.Value() }
+
+// OutputBufferKills returns the number of clients which were disconnected
+// because they exceeded Config.MaxOutputBuffer.
+func (i *ServerInfo) OutputBufferKills() int64 { return i.killedOutputBuffer.Value() }
+
+// SoftOutputBufferKills returns the number of clients which were
+// disconnected because they exceeded Config.OutputBufferSoftLimit for
+// longer than Config.OutputBufferSoftPeriod.
+func (i *ServerInfo) SoftOutputBufferKills() int64 { return i.killedSoftOutputBuffer.Value() }
+
+// KilledClients returns the number of clients disconnected via
+// Server.KillClient.
+func (i *ServerInfo) KilledClients() int64 { return i.killed.Value() }
+
+// EvictedClients returns the total number of clients which were closed
+// by the server, i.e. idle timeouts, output buffer kills and clients
+// disconnected via Server.KillClient.
+func (i *ServerInfo) EvictedClients() int64 {
+	return i.IdleTimeouts() + i.OutputBufferKills() + i.SoftOutputBufferKills() + i.KilledClients()
+}
+
+// IdleTimeouts returns the number of connections closed because of
+// the IdleTimeout.
+func (i *ServerInfo) IdleTimeouts() int64 { return i.idle.Value() }
+
+// TotalNetInputBytes returns the number of bytes received from clients
+// since the start of the server.
+func (i *ServerInfo) TotalNetInputBytes() int64 {
+	n := i.netInput.Value()
+	for _, c := range i.clients.All() {
+		n += c.BytesRead()
+	}
+	return n
+}
+
+// TotalNetOutputBytes returns the number of bytes sent to clients
+// since the start of the server.
+func (i *ServerInfo) TotalNetOutputBytes() int64 {
+	n := i.netOutput.Value()
+	for _, c := range i.clients.All() {
+		n += c.BytesWritten()
+	}
+	return n
+}
+
+// InstantaneousKbps returns the estimated input and output throughput
+// in KB/s over the last few seconds.
+func (i *ServerInfo) InstantaneousKbps() (input, output float64) {
+	return i.netMeter.rate(time.Now(), i.TotalNetInputBytes(), i.TotalNetOutputBytes())
+}
+
+// DroppedConnections returns the number of connections dropped due
+// to errors, by reason.
+func (i *ServerInfo) DroppedConnections() DroppedConnections {
+	return DroppedConnections{
+		Timeout:       i.droppedTimeout.Value(),
+		ProtocolError: i.droppedProtocol.Value(),
+		ReadError:     i.droppedRead.Value(),
+		WriteError:    i.droppedWrite.Value(),
+	}
+}
+
+// CommandStats returns a snapshot of the per-command execution stats,
+// sorted by command name.
+func (i *ServerInfo) CommandStats() []CommandStats {
+	res := make([]CommandStats, 0, i.cmdstats.Len())
+	for kv := range i.cmdstats.Iter() {
+		if st := kv.Value.(*cmdStats).snapshot(); st.Calls != 0 {
+			res = append(res, st)
+		}
+	}
+	sort.Slice(res, func(n, m int) bool { return res[n].Name < res[m].Name })
+	return res
+}
+
+// Expvar returns the stats as an expvar.Var, e.g. to expose them via
+// expvar.Publish("redeo", srv.Info().Expvar()). Per-command stats are
+// listed under "commands", including their latency histograms.
+func (i *ServerInfo) Expvar() expvar.Var {
+	return expvar.Func(func() interface{} {
+		commands := make(map[string]interface{})
+		for _, st := range i.CommandStats() {
+			latency := make(map[string]int64, len(st.Histogram))
+			for n, calls := range st.Histogram {
+				if n < len(LatencyBuckets) {
+					latency[LatencyBuckets[n].String()] = calls
+				} else {
+					latency["+Inf"] = calls
+				}
+			}
+			commands[st.Name] = map[string]interface{}{
+				"calls":        st.Calls,
+				"failed_calls": st.Errors,
+				"usec":         int64(st.Total / time.Microsecond),
+				"max_usec":     int64(st.Max / time.Microsecond),
+				"latency":      latency,
+			}
+		}
+
+		return map[string]interface{}{
+			"connected_clients":          i.NumClients(),
+			"connected_clients_peak":     i.PeakClients(),
+			"total_connections_received": i.TotalConnections(),
+			"total_commands_processed":   i.TotalCommands(),
+			"total_handler_panics":       i.TotalPanics(),
+			"rejected_connections":       i.RejectedConnections(),
+			"unknown_commands":           i.UnknownCommands(),
+			"total_net_input_bytes":      i.TotalNetInputBytes(),
+			"total_net_output_bytes":     i.TotalNetOutputBytes(),
+			"commands":                   commands,
+		}
+	})
+}
+
+// ResetStats resets the statistics, equivalent to CONFIG RESETSTAT.
+func (i *ServerInfo) ResetStats() {
+	atomic.StoreInt64(&i.peakClients, int64(i.NumClients()))
+	i.connections.Set(0)
+	i.commands.Set(0)
+	i.panics.Set(0)
+	i.rejected.Set(0)
+	i.unknown.Set(0)
+	i.idle.Set(0)
+	i.droppedTimeout.Set(0)
+	i.droppedProtocol.Set(0)
+	i.droppedRead.Set(0)
+	i.droppedWrite.Set(0)
+	i.droppedEvents.Set(0)
+	i.rateLimited.Set(0)
+	i.killedOutputBuffer.Set(0)
+	i.killedSoftOutputBuffer.Set(0)
+	i.killed.Set(0)
+	i.netInput.Set(i.netInput.Value() - i.TotalNetInputBytes())
+	i.netOutput.Set(i.netOutput.Value() - i.TotalNetOutputBytes())
+	i.netMeter.reset()
+
+	i.Fetch("Commandstats").Clear()
+	i.Fetch("Latencystats").Clear()
+	for kv := range i.cmdstats.Iter() {
+		kv.Value.(*cmdStats).reset()
+	}
+}
+
 // Apply default info
 func (i *ServerInfo) initDefaults() {
 	runID := make([]byte, 20)
@@ -168,24 +451,76 @@ func (i *ServerInfo) initDefaults() {
 		d := time.Since(i.startTime) / time.Hour / 24
 		return strconv.FormatInt(int64(d), 10)
 	}))
+	server.Register("tcp_port", i.port)
+	server.Register("unix_socket", i.socket)
 
 	clients := i.Fetch("Clients")
 	clients.Register("connected_clients", info.Callback(func() string {
 		return strconv.Itoa(i.NumClients())
 	}))
+	clients.Register("connected_clients_peak", info.Callback(func() string {
+		return strconv.FormatInt(i.PeakClients(), 10)
+	}))
+	clients.Register("connected_monitors", i.monitors)
+	clients.Register("blocked_clients", i.blocked)
 
 	stats := i.Fetch("Stats")
 	stats.Register("total_connections_received", i.connections)
 	stats.Register("total_commands_processed", i.commands)
+	stats.Register("total_handler_panics", i.panics)
+	stats.Register("rejected_connections", i.rejected)
+	stats.Register("unknown_commands", i.unknown)
+	stats.Register("idle_timeouts", i.idle)
+	stats.Register("dropped_connections_timeout", i.droppedTimeout)
+	stats.Register("dropped_connections_protocol_error", i.droppedProtocol)
+	stats.Register("dropped_connections_read_error", i.droppedRead)
+	stats.Register("dropped_connections_write_error", i.droppedWrite)
+	stats.Register("dropped_command_events", i.droppedEvents)
+	stats.Register("rate_limited_commands", i.rateLimited)
+	stats.Register("clients_killed_for_output_buffer", i.killedOutputBuffer)
+	stats.Register("clients_killed_for_soft_output_buffer", i.killedSoftOutputBuffer)
+	stats.Register("killed_clients", i.killed)
+	stats.Register("evicted_clients", info.Callback(func() string {
+		return strconv.FormatInt(i.EvictedClients(), 10)
+	}))
+	stats.Register("total_net_input_bytes", info.Callback(func() string {
+		return strconv.FormatInt(i.TotalNetInputBytes(), 10)
+	}))
+	stats.Register("total_net_output_bytes", info.Callback(func() string {
+		return strconv.FormatInt(i.TotalNetOutputBytes(), 10)
+	}))
+	stats.Register("instantaneous_input_kbps", info.Callback(func() string {
+		input, _ := i.InstantaneousKbps()
+		return strconv.FormatFloat(input, 'f', 2, 64)
+	}))
+	stats.Register("instantaneous_output_kbps", info.Callback(func() string {
+		_, output := i.InstantaneousKbps()
+		return strconv.FormatFloat(output, 'f', 2, 64)
+	}))
+
+	i.Fetch("Commandstats")
+	i.Fetch("Latencystats")
 }
 
 func (i *ServerInfo) register(c *Client) {
 	i.clients.Add(c)
 	i.connections.Inc(1)
+
+	n := int64(i.NumClients())
+	for peak := i.PeakClients(); n > peak; peak = i.PeakClients() {
+		if atomic.CompareAndSwapInt64(&i.peakClients, peak, n) {
+			break
+		}
+	}
 }
 
 func (i *ServerInfo) deregister(clientID uint64) {
+	c, ok := i.clients.Client(clientID)
 	i.clients.Del(clientID)
+	if ok {
+		i.netInput.Inc(c.BytesRead())
+		i.netOutput.Inc(c.BytesWritten())
+	}
 }
 
 func (i *ServerInfo) command(clientID uint64, cmd string) {
@@ -193,6 +528,230 @@ func (i *ServerInfo) command(clientID uint64, cmd string) {
 	i.commands.Inc(1)
 }
 
+// observe records the execution of a command.
+func (i *ServerInfo) observe(cmd string, d time.Duration, failed bool) {
+	v, ok := i.cmdstats.GetStringKey(cmd)
+	if !ok {
+		v, _ = i.cmdstats.GetOrInsert(cmd, &cmdStats{name: cmd})
+	}
+
+	st := v.(*cmdStats)
+	st.observe(d, failed)
+
+	if atomic.LoadInt32(&st.listed) == 0 && atomic.CompareAndSwapInt32(&st.listed, 0, 1) {
+		i.Fetch("Commandstats").Register("cmdstat_"+cmd, st)
+		i.Fetch("Latencystats").Register("latency_percentiles_usec_"+cmd, cmdLatency{st})
+	}
+}
+
+// --------------------------------------------------------------------
+
+const (
+	netMeterInterval = 100 * time.Millisecond
+	netMeterWindow   = 2 * time.Second
+)
+
+// netMeter estimates the throughput from samples of the traffic totals
+// taken when the rate is queried.
+type netMeter struct {
+	samples []netSample
+	mu      sync.Mutex
+}
+
+type netSample struct {
+	t       time.Time
+	in, out int64
+}
+
+// rate returns the input and output rates in KB/s since the oldest
+// sample within the window.
+func (m *netMeter) rate(now time.Time, in, out int64) (float64, float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// expire old samples, but keep one as reference
+	for len(m.samples) > 1 && now.Sub(m.samples[0].t) > netMeterWindow {
+		m.samples = m.samples[1:]
+	}
+
+	var kin, kout float64
+	if len(m.samples) != 0 {
+		ref := m.samples[0]
+		if secs := now.Sub(ref.t).Seconds(); secs > 0 {
+			kin = math.Max(0, float64(in-ref.in)/1024/secs)
+			kout = math.Max(0, float64(out-ref.out)/1024/secs)
+		}
+	}
+
+	if n := len(m.samples); n == 0 || now.Sub(m.samples[n-1].t) >= netMeterInterval {
+		m.samples = append(m.samples, netSample{t: now, in: in, out: out})
+	}
+	return kin, kout
+}
+
+func (m *netMeter) reset() {
+	m.mu.Lock()
+	m.samples = nil
+	m.mu.Unlock()
+}
+
+// --------------------------------------------------------------------
+
+// DroppedConnections contains the number of connections dropped
+// due to errors, by reason
+type DroppedConnections struct {
+	Timeout       int64
+	ProtocolError int64
+	ReadError     int64
+	WriteError    int64
+}
+
+// --------------------------------------------------------------------
+
+// CommandStats contains execution stats of a command
+type CommandStats struct {
+	// Name is the normalised command name
+	Name string
+	// Calls is the number of calls
+	Calls int64
+	// Errors is the number of calls which replied with an error
+	Errors int64
+	// Total is the cumulative execution time
+	Total time.Duration
+	// Max is the maximum execution time
+	Max time.Duration
+	// Histogram holds the number of calls by execution time, bucketed by
+	// LatencyBuckets. The last element counts slower calls.
+	Histogram []int64
+}
+
+// LatencyBuckets are the upper bounds of the latency histogram buckets of
+// CommandStats, e.g. to feed a prometheus.Collector.
+var LatencyBuckets = [...]time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// UsecPerCall returns the average execution time in microseconds
+func (s CommandStats) UsecPerCall() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Total) / float64(time.Microsecond) / float64(s.Calls)
+}
+
+// Percentile estimates the execution time below which p percent of the
+// calls completed, e.g. 99.9, as the upper bound of the matching
+// Histogram bucket, capped by Max.
+func (s CommandStats) Percentile(p float64) time.Duration {
+	var total int64
+	for _, n := range s.Histogram {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for n, count := range s.Histogram {
+		if seen += count; seen < rank {
+			continue
+		}
+		if n < len(LatencyBuckets) && LatencyBuckets[n] < s.Max {
+			return LatencyBuckets[n]
+		}
+		break
+	}
+	return s.Max
+}
+
+type cmdStats struct {
+	name   string
+	calls  int64
+	errors int64
+	total  int64
+	max    int64
+	hist   [len(LatencyBuckets) + 1]int64
+	listed int32 // 1 if registered in the commandstats section
+}
+
+func (s *cmdStats) observe(d time.Duration, failed bool) {
+	atomic.AddInt64(&s.calls, 1)
+	atomic.AddInt64(&s.total, int64(d))
+	if failed {
+		atomic.AddInt64(&s.errors, 1)
+	}
+
+	n := sort.Search(len(LatencyBuckets), func(n int) bool { return d <= LatencyBuckets[n] })
+	atomic.AddInt64(&s.hist[n], 1)
+
+	for {
+		max := atomic.LoadInt64(&s.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&s.max, max, int64(d)) {
+			break
+		}
+	}
+}
+
+func (s *cmdStats) snapshot() CommandStats {
+	hist := make([]int64, len(s.hist))
+	for n := range s.hist {
+		hist[n] = atomic.LoadInt64(&s.hist[n])
+	}
+
+	return CommandStats{
+		Name:      s.name,
+		Calls:     atomic.LoadInt64(&s.calls),
+		Errors:    atomic.LoadInt64(&s.errors),
+		Total:     time.Duration(atomic.LoadInt64(&s.total)),
+		Max:       time.Duration(atomic.LoadInt64(&s.max)),
+		Histogram: hist,
+	}
+}
+
+func (s *cmdStats) reset() {
+	atomic.StoreInt64(&s.calls, 0)
+	atomic.StoreInt64(&s.errors, 0)
+	atomic.StoreInt64(&s.total, 0)
+	atomic.StoreInt64(&s.max, 0)
+	for n := range s.hist {
+		atomic.StoreInt64(&s.hist[n], 0)
+	}
+	atomic.StoreInt32(&s.listed, 0)
+}
+
+// String implements info.Value
+func (s *cmdStats) String() string {
+	st := s.snapshot()
+	return fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,failed_calls=%d",
+		st.Calls, int64(st.Total/time.Microsecond), st.UsecPerCall(), st.Errors)
+}
+
+// cmdLatency reports the latency percentiles of a command, like redis'
+// latencystats.
+type cmdLatency struct{ *cmdStats }
+
+// String implements info.Value
+func (s cmdLatency) String() string {
+	st := s.snapshot()
+	usec := func(p float64) float64 { return float64(st.Percentile(p)) / float64(time.Microsecond) }
+	return fmt.Sprintf("p50=%.3f,p99=%.3f,p99.9=%.3f", usec(50), usec(99), usec(99.9))
+}
+
 // --------------------------------------------------------------------
 
 type clientStats struct {
@@ -300,6 +859,23 @@ func (s *clientReadStats) Client(clientID uint64) (*Client, bool) {
 	}
 }
 
+func (s *clientReadStats) Info(clientID uint64) (*ClientInfo, bool) {
+	v, ok := s.stats.Get(clientID)
+	if !ok {
+		return nil, false
+	}
+
+	info := *v.(*ClientInfo)
+	info.Name = info.client.Name()
+	info.DB = info.client.DB()
+	info.NetInput = info.client.BytesRead()
+	info.NetOutput = info.client.BytesWritten()
+	info.OutputBuffer = atomic.LoadInt64(&info.client.omem)
+	info.RateLimited = atomic.LoadInt64(&info.client.rl.limited)
+	info.client = nil
+	return &info, true
+}
+
 func (s *clientReadStats) Len() int {
 	return s.stats.Len()
 }
@@ -309,6 +885,12 @@ func (s *clientReadStats) Stats() []*ClientInfo {
 	res := make(clientInfoSlice, 0, len(iter))
 	for keyVal := range iter {
 		info := *keyVal.Value.(*ClientInfo)
+		info.Name = info.client.Name()
+		info.DB = info.client.DB()
+		info.NetInput = info.client.BytesRead()
+		info.NetOutput = info.client.BytesWritten()
+		info.OutputBuffer = atomic.LoadInt64(&info.client.omem)
+		info.RateLimited = atomic.LoadInt64(&info.client.rl.limited)
 		info.client = nil
 		res = append(res, &info)
 	}
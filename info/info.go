@@ -59,15 +59,15 @@ func (r *Registry) String() string {
 	defer r.mu.RUnlock()
 
 	buf := new(bytes.Buffer)
-	for i, s := range r.sections {
-		if len(s.kvs) == 0 {
-			continue
-		}
-
-		if i != 0 {
-			buf.WriteByte('\n')
+	for _, s := range r.sections {
+		s.mu.RLock()
+		if len(s.kvs) != 0 {
+			if buf.Len() != 0 {
+				buf.WriteByte('\n')
+			}
+			s.writeTo(buf)
 		}
-		s.writeTo(buf)
+		s.mu.RUnlock()
 	}
 	return buf.String()
 }
@@ -91,7 +91,15 @@ type Section struct {
 // Register registers a value under a name
 func (s *Section) Register(name string, value Value) {
 	s.mu.Lock()
-	s.kvs = append(s.kvs, kv{name, value})
+	s.kvs = append(s.kvs, kv{name: name, value: value})
+	s.mu.Unlock()
+}
+
+// RegisterFunc registers a callback which adds a variable number of
+// values each time the section is generated, e.g. a value per database.
+func (s *Section) RegisterFunc(fn func(add func(name, value string))) {
+	s.mu.Lock()
+	s.kvs = append(s.kvs, kv{fn: fn})
 	s.mu.Unlock()
 }
 
@@ -115,6 +123,12 @@ func (s *Section) Replace(fn func(*Section)) {
 func (s *Section) writeTo(buf *bytes.Buffer) {
 	buf.WriteString("# " + s.name + "\n")
 	for _, kv := range s.kvs {
+		if kv.fn != nil {
+			kv.fn(func(name, value string) {
+				buf.WriteString(name + ":" + value + "\n")
+			})
+			continue
+		}
 		buf.WriteString(kv.name + ":" + kv.value.String() + "\n")
 	}
 }
@@ -140,4 +154,5 @@ func (s *Section) String() string {
 type kv struct {
 	name  string
 	value Value
+	fn    func(add func(name, value string)) // see RegisterFunc
 }
@@ -1,6 +1,7 @@
 package info
 
 import (
+	"strconv"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -39,6 +40,26 @@ var _ = Describe("Registry", func() {
 		Expect(s).To(Equal("# Server\ntest:string\n"))
 	})
 
+	It("should skip empty sections", func() {
+		subject = New()
+		subject.FetchSection("Empty")
+		subject.FetchSection("Server").Register("version", StaticString("1.0.1"))
+		Expect(subject.String()).To(Equal("# Server\nversion:1.0.1\n"))
+	})
+
+	It("should register dynamic values", func() {
+		dbs := []int{0, 3}
+		subject.FetchSection("Keyspace").RegisterFunc(func(add func(string, string)) {
+			for _, db := range dbs {
+				add("db"+strconv.Itoa(db), "keys=1")
+			}
+		})
+		Expect(subject.FindSection("keyspace").String()).To(Equal("# Keyspace\ndb0:keys=1\ndb3:keys=1\n"))
+
+		dbs = dbs[1:]
+		Expect(subject.String()).To(HaveSuffix("\n\n# Keyspace\ndb3:keys=1\n"))
+	})
+
 	It("should generate section strings", func() {
 		s := subject.FindSection("clients").String()
 		Expect(s).To(Equal("# Clients\ncount:17\ntotal:123456\n"))
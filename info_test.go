@@ -1,6 +1,7 @@
 package redeo
 
 import (
+	"encoding/json"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -33,10 +34,120 @@ var _ = Describe("ServerInfo", func() {
 		Expect(str).To(ContainSubstring("# Stats\ntotal_connections_received:5\ntotal_commands_processed:12\n"))
 	})
 
+	It("should track command stats", func() {
+		subject.observe("get", 2*time.Millisecond, false)
+		subject.observe("get", 4*time.Millisecond, true)
+		subject.observe("set", time.Microsecond, false)
+
+		stats := subject.CommandStats()
+		Expect(stats).To(HaveLen(2))
+		Expect(stats[0].Name).To(Equal("get"))
+		Expect(stats[0].Calls).To(Equal(int64(2)))
+		Expect(stats[0].Errors).To(Equal(int64(1)))
+		Expect(stats[0].Total).To(Equal(6 * time.Millisecond))
+		Expect(stats[0].Max).To(Equal(4 * time.Millisecond))
+		Expect(stats[0].UsecPerCall()).To(Equal(3000.0))
+		Expect(stats[1].Name).To(Equal("set"))
+		Expect(stats[1].Calls).To(Equal(int64(1)))
+		Expect(stats[0].Histogram).To(Equal([]int64{0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0}))
+		Expect(stats[1].Histogram[0]).To(Equal(int64(1)))
+
+		str := subject.String()
+		Expect(str).To(ContainSubstring("# Commandstats\ncmdstat_get:calls=2,usec=6000,usec_per_call=3000.00,failed_calls=1\ncmdstat_set:calls=1,usec=1,"))
+		Expect(str).To(ContainSubstring("# Latencystats\nlatency_percentiles_usec_get:p50=4000.000,p99=4000.000,p99.9=4000.000\nlatency_percentiles_usec_set:p50=1.000,"))
+	})
+
+	It("should estimate latency percentiles", func() {
+		for i := 0; i < 90; i++ {
+			subject.observe("get", 30*time.Microsecond, false)
+		}
+		for i := 0; i < 9; i++ {
+			subject.observe("get", 3*time.Millisecond, false)
+		}
+		subject.observe("get", 2*time.Second, false)
+
+		st := subject.CommandStats()[0]
+		Expect(st.Percentile(50)).To(Equal(50 * time.Microsecond))
+		Expect(st.Percentile(90)).To(Equal(50 * time.Microsecond))
+		Expect(st.Percentile(99)).To(Equal(5 * time.Millisecond))
+		Expect(st.Percentile(99.9)).To(Equal(2 * time.Second))
+		Expect(CommandStats{}.Percentile(50)).To(Equal(time.Duration(0)))
+	})
+
+	It("should reset stats", func() {
+		subject.observe("get", time.Millisecond, false)
+		subject.ResetStats()
+
+		Expect(subject.TotalCommands()).To(Equal(int64(0)))
+		Expect(subject.TotalConnections()).To(Equal(int64(0)))
+		Expect(subject.CommandStats()).To(BeEmpty())
+		Expect(subject.String()).NotTo(ContainSubstring("cmdstat_"))
+		Expect(subject.String()).NotTo(ContainSubstring("latency_percentiles_usec_"))
+
+		subject.observe("set", time.Millisecond, false)
+		Expect(subject.CommandStats()).To(HaveLen(1))
+		Expect(subject.String()).To(ContainSubstring("# Commandstats\ncmdstat_set:calls=1,"))
+	})
+
+	It("should track peak clients", func() {
+		Expect(subject.PeakClients()).To(Equal(int64(0)))
+
+		c := newClient(&mockConn{Port: 10005})
+		subject.register(c)
+		subject.register(newClient(&mockConn{Port: 10006}))
+		Expect(subject.PeakClients()).To(Equal(int64(5)))
+
+		subject.deregister(c.ID())
+		Expect(subject.NumClients()).To(Equal(4))
+		Expect(subject.PeakClients()).To(Equal(int64(5)))
+		Expect(subject.String()).To(ContainSubstring("connected_clients:4\nconnected_clients_peak:5\n"))
+
+		subject.ResetStats()
+		Expect(subject.PeakClients()).To(Equal(int64(4)))
+	})
+
+	It("should export expvars", func() {
+		subject.observe("get", 2*time.Millisecond, false)
+		subject.observe("get", 2*time.Second, true)
+
+		var vars map[string]interface{}
+		Expect(json.Unmarshal([]byte(subject.Expvar().String()), &vars)).To(Succeed())
+		Expect(vars).To(HaveKeyWithValue("connected_clients", 3.0))
+		Expect(vars).To(HaveKeyWithValue("total_connections_received", 5.0))
+		Expect(vars).To(HaveKeyWithValue("commands", map[string]interface{}{
+			"get": map[string]interface{}{
+				"calls":        2.0,
+				"failed_calls": 1.0,
+				"usec":         2002000.0,
+				"max_usec":     2000000.0,
+				"latency": map[string]interface{}{
+					"10µs": 0.0, "50µs": 0.0, "100µs": 0.0, "500µs": 0.0,
+					"1ms": 0.0, "5ms": 1.0, "10ms": 0.0, "50ms": 0.0,
+					"100ms": 0.0, "500ms": 0.0, "1s": 0.0, "+Inf": 1.0,
+				},
+			},
+		}))
+	})
+
+	It("should estimate throughput", func() {
+		t := time.Now()
+		Expect(subject.netMeter.rate(t, 0, 0)).To(BeZero())
+
+		in, out := subject.netMeter.rate(t.Add(time.Second), 2048, 1024)
+		Expect(in).To(Equal(2.0))
+		Expect(out).To(Equal(1.0))
+
+		// samples outside of the window expire
+		in, _ = subject.netMeter.rate(t.Add(4*time.Second), 2048, 1024)
+		Expect(in).To(Equal(0.0))
+		in, _ = subject.netMeter.rate(t.Add(5*time.Second), 3072, 1024)
+		Expect(in).To(Equal(1.0))
+	})
+
 	It("should retrieve a list of clients", func() {
 		stats := subject.ClientInfo()
 		Expect(stats).To(HaveLen(3))
-		Expect(stats[0].String()).To(MatchRegexp(`id=\d+ addr=1\.2\.3\.4\:10001 age=\d+ idle=\d+ cmd=get`))
+		Expect(stats[0].String()).To(MatchRegexp(`id=\d+ addr=1\.2\.3\.4\:10001 name= age=\d+ idle=\d+ db=0 cmd=get`))
 	})
 
 })
@@ -48,7 +159,7 @@ var _ = Describe("ClientInfo", func() {
 		c.id = 12
 
 		info := newClientInfo(c, time.Now().Add(-3*time.Second))
-		Expect(info.String()).To(Equal(`id=12 addr=1.2.3.4:10001 age=3 idle=3 cmd=`))
+		Expect(info.String()).To(Equal(`id=12 addr=1.2.3.4:10001 name= age=3 idle=3 db=0 cmd= tot-net-in=0 tot-net-out=0 omem=0 rate-limited=0`))
 	})
 
 })
@@ -0,0 +1,78 @@
+package redeo
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// ReplyFunc is called with the RESP-encoded reply of a command and
+// returns the reply to send instead, e.g. the original, a rewritten
+// or a cached one. Replies can be parsed with resp.ReadReply. The reply
+// is only valid until the function returns, it must be copied to be
+// retained.
+type ReplyFunc func(c *resp.Command, reply []byte) []byte
+
+// InterceptReplies returns a middleware, see Server.Use, which buffers the
+// reply of each command and passes it to fn before it is sent, e.g. for
+// caching layers, reply rewriting or size accounting. Replies of handlers
+// which flush or stream via CopyBulk are buffered in full, so fn should
+// pass commands which deliver replies incrementally through unchanged,
+// see CommandName.
+func InterceptReplies(fn ReplyFunc) func(Handler) Handler {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+			cw := getCaptureWriter(w.Protocol())
+			defer putCaptureWriter(cw)
+
+			next.ServeRedeo(cw.ResponseWriter, c)
+			_ = cw.Flush()
+
+			appendReply(w, fn(c, cw.buf.Bytes()))
+		})
+	}
+}
+
+// appendReply appends an encoded reply. Error replies are appended via
+// AppendError, so they are reported like other errors.
+func appendReply(w resp.ResponseWriter, p []byte) {
+	if len(p) != 0 && p[0] == '-' {
+		if n := bytes.Index(p, []byte("\r\n")); n > 0 {
+			w.AppendError(string(p[1:n]))
+			p = p[n+2:]
+		}
+	}
+	if len(p) != 0 {
+		w.AppendRaw(p)
+	}
+}
+
+// --------------------------------------------------------------------
+
+var captureWriterPool sync.Pool
+
+// captureWriter buffers replies, see InterceptReplies.
+type captureWriter struct {
+	resp.ResponseWriter
+	buf bytes.Buffer
+}
+
+func getCaptureWriter(proto int) *captureWriter {
+	if v := captureWriterPool.Get(); v != nil {
+		cw := v.(*captureWriter)
+		cw.ResponseWriter.SetProtocol(proto)
+		return cw
+	}
+
+	cw := new(captureWriter)
+	cw.ResponseWriter = resp.NewResponseWriter(&cw.buf)
+	cw.ResponseWriter.SetProtocol(proto)
+	return cw
+}
+
+func putCaptureWriter(cw *captureWriter) {
+	cw.buf.Reset()
+	cw.ResponseWriter.Reset(&cw.buf)
+	captureWriterPool.Put(cw)
+}
@@ -0,0 +1,109 @@
+package redeo
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InterceptReplies", func() {
+	var subject *Server
+	var lis *redeotest.Listener
+	var errs []string
+	var mu sync.Mutex
+
+	var dial = func() *redeotest.Client {
+		cn, _, err := lis.Dial()
+		Expect(err).NotTo(HaveOccurred())
+		return redeotest.NewClient(cn)
+	}
+
+	BeforeEach(func() {
+		errs = nil
+		subject = NewServer(&Config{
+			AccessLog: AccessLoggerFunc(func(e *AccessLogEntry) {
+				mu.Lock()
+				errs = append(errs, e.Error)
+				mu.Unlock()
+			}),
+		})
+		subject.Handle("echo", Echo())
+		subject.HandleFunc("fail", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendError("ERR failed")
+		})
+
+		lis = redeotest.NewBufferedListener()
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should rewrite replies", func() {
+		subject.Use(InterceptReplies(func(c *resp.Command, reply []byte) []byte {
+			if CommandName(c.Context()) != "echo" {
+				return reply
+			}
+			return bytes.ToUpper(reply)
+		}))
+
+		c := dial()
+		defer c.Close()
+
+		Expect(c.Do("ECHO", "hello")).To(Equal("HELLO"))
+		Expect(c.Do("FAIL")).To(Equal(redeotest.ErrorResponse("ERR failed")))
+	})
+
+	It("should account reply sizes", func() {
+		var size int
+		subject.Use(InterceptReplies(func(c *resp.Command, reply []byte) []byte {
+			size += len(reply)
+			return reply
+		}))
+
+		c := dial()
+		defer c.Close()
+
+		Expect(c.Do("ECHO", "hello")).To(Equal("hello"))
+		Expect(size).To(Equal(11))
+	})
+
+	It("should serve cached replies", func() {
+		subject.Use(InterceptReplies(func(c *resp.Command, reply []byte) []byte {
+			if CommandName(c.Context()) == "fail" {
+				return []byte("$6\r\ncached\r\n")
+			}
+			return reply
+		}))
+
+		c := dial()
+		defer c.Close()
+
+		Expect(c.Do("FAIL")).To(Equal("cached"))
+	})
+
+	It("should report error replies", func() {
+		subject.Use(InterceptReplies(func(c *resp.Command, reply []byte) []byte {
+			if CommandName(c.Context()) == "echo" {
+				return []byte("-WRONGTYPE rewritten\r\n")
+			}
+			return reply
+		}))
+
+		c := dial()
+		defer c.Close()
+
+		Expect(c.Do("FAIL")).To(Equal(redeotest.ErrorResponse("ERR failed")))
+		Expect(c.Do("ECHO", "hello")).To(Equal(redeotest.ErrorResponse("WRONGTYPE rewritten")))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(errs).To(Equal([]string{"ERR failed", "WRONGTYPE rewritten"}))
+	})
+
+})
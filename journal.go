@@ -0,0 +1,278 @@
+package redeo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// errJournalClosed is returned by Journal.Err once the journal is closed.
+var errJournalClosed = errors.New("redeo: journal closed")
+
+// FsyncPolicy determines when a journal is synced to stable storage.
+type FsyncPolicy int
+
+const (
+	// FsyncNo writes commands once per second, leaving syncs to the OS.
+	FsyncNo FsyncPolicy = iota
+	// FsyncAlways writes and syncs each command before it is replied to.
+	FsyncAlways
+	// FsyncEverySec writes and syncs commands once per second.
+	FsyncEverySec
+)
+
+// JournalConfig configures a command journal, see Server.StartJournal.
+type JournalConfig struct {
+	// Writer receives the journaled commands, encoded as RESP arrays like
+	// the append only file of Redis. Writers implementing Sync, e.g.
+	// *os.File, are synced as per Fsync.
+	Writer io.Writer
+
+	// Fsync determines when the journal is written and synced.
+	// Default: FsyncNo
+	Fsync FsyncPolicy
+
+	// Journal decides whether a successfully executed command is
+	// journaled, by normalised name.
+	// Default: commands registered with the "write" flag
+	Journal func(name string, args []resp.CommandArgument) bool
+}
+
+// Journal appends executed commands to a writer, so they can be replayed
+// on startup, see Replay.
+type Journal struct {
+	srv  *Server
+	conf JournalConfig
+	w    *bufio.Writer
+	db   int // the database selected in the journal, replays start in 0
+	err  error
+	mu   sync.Mutex
+
+	stop, done chan struct{}
+}
+
+// StartJournal starts journaling executed commands, which are selected
+// like commands forwarded to replicas. Streamed commands are not
+// journaled. A previously started journal is replaced.
+func (srv *Server) StartJournal(conf *JournalConfig) *Journal {
+	j := &Journal{
+		srv:  srv,
+		conf: *conf,
+		w:    bufio.NewWriter(conf.Writer),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if conf.Fsync == FsyncAlways {
+		close(j.done)
+	} else {
+		go j.loop()
+	}
+
+	srv.journal.Store(j)
+	return j
+}
+
+// currentJournal returns the started journal, if any.
+func (srv *Server) currentJournal() *Journal {
+	j, _ := srv.journal.Load().(*Journal)
+	return j
+}
+
+// Err returns the first write error. Commands are no longer journaled
+// thereafter.
+func (j *Journal) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Close stops journaling, writes and syncs pending commands. It does not
+// close the underlying writer.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	if j.err == errJournalClosed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.mu.Unlock()
+
+	if j.srv.currentJournal() == j {
+		j.srv.journal.Store((*Journal)(nil))
+	}
+	if j.conf.Fsync != FsyncAlways {
+		close(j.stop)
+		<-j.done
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	err := j.flush()
+	if err == nil {
+		err = j.sync()
+	}
+	j.err = errJournalClosed
+	return err
+}
+
+// wants returns true if an executed command should be journaled.
+func (j *Journal) wants(entry *handlerEntry, name string, args []resp.CommandArgument) bool {
+	if fn := j.conf.Journal; fn != nil {
+		return fn(name, args)
+	}
+	return entry.spec.hasFlag("write")
+}
+
+// append journals a command executed in db.
+func (j *Journal) append(db int, name string, args []resp.CommandArgument) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.err != nil {
+		return
+	}
+
+	var buf []byte
+	if db != j.db {
+		buf = appendCommand(buf, "select", resp.CommandArgument(strconv.Itoa(db)))
+		j.db = db
+	}
+	buf = appendCommand(buf, name, args...)
+	if _, err := j.w.Write(buf); err != nil {
+		j.err = err
+		return
+	}
+
+	if j.conf.Fsync == FsyncAlways {
+		if err := j.flush(); err == nil {
+			_ = j.sync()
+		}
+	}
+}
+
+// loop writes pending commands once per second.
+func (j *Journal) loop() {
+	defer close(j.done)
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-t.C:
+		}
+
+		j.mu.Lock()
+		err := j.flush()
+		j.mu.Unlock()
+
+		// sync outside the lock, so commands are not delayed
+		if err == nil && j.conf.Fsync == FsyncEverySec {
+			if err := j.syncer(); err != nil {
+				j.mu.Lock()
+				j.fail(err)
+				j.mu.Unlock()
+			}
+		}
+	}
+}
+
+// flush writes buffered commands, must be called with the lock held.
+func (j *Journal) flush() error {
+	if j.err != nil {
+		return j.err
+	}
+	if err := j.w.Flush(); err != nil {
+		j.fail(err)
+		return err
+	}
+	return nil
+}
+
+// sync syncs the writer, must be called with the lock held.
+func (j *Journal) sync() error {
+	if err := j.syncer(); err != nil {
+		j.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (j *Journal) syncer() error {
+	if s, ok := j.conf.Writer.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// fail records the first error, must be called with the lock held.
+func (j *Journal) fail(err error) {
+	if j.err == nil {
+		j.err = err
+	}
+}
+
+// --------------------------------------------------------------------
+
+// Replay executes the commands of a journal, e.g. on startup before
+// the journal is started and clients are served. Commands are served by
+// an internal client, which is authenticated. Replay stops at the first
+// error reply.
+func Replay(r io.Reader, srv *Server) error {
+	if !srv.acquireSlot() {
+		return errMaxClients
+	}
+
+	cn, peer := net.Pipe()
+	c := srv.newClient(cn)
+	c.authed = true
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = srv.serveClient(c, true)
+	}()
+	defer func() {
+		_ = peer.Close()
+		<-done
+	}()
+
+	rd := resp.NewRequestReader(r)
+	cw, cr := resp.NewRequestWriter(peer), resp.NewResponseReader(peer)
+
+	var cmd *resp.Command
+	for {
+		var err error
+		if cmd, err = rd.ReadCmd(cmd); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		args := make([][]byte, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			args[i] = arg
+		}
+		cw.WriteCmd(cmd.Name, args...)
+		if err := cw.Flush(); err != nil {
+			return err
+		}
+
+		reply, err := resp.ReadReply(cr)
+		if err != nil {
+			return err
+		}
+		if err := reply.Err(); err != nil {
+			return fmt.Errorf("redeo: replay of %s failed: %v", cmd.Name, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package redeo
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Journal", func() {
+	var subject *Server
+	var lis net.Listener
+	var data map[string]string
+	var mu sync.Mutex
+
+	var newServer = func() *Server {
+		srv := NewServer(nil)
+		srv.Handle("select", Select())
+		srv.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			mu.Lock()
+			data[strconv.Itoa(GetClient(c.Context()).DB())+":"+c.Arg(0).String()] = c.Arg(1).String()
+			mu.Unlock()
+			w.AppendOK()
+		}, Arity(3), Flags("write"))
+		srv.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendNil()
+		}, Arity(2), Flags("readonly"))
+		srv.HandleFunc("fail", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendError("ERR failed")
+		}, Flags("write"))
+		return srv
+	}
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		data = make(map[string]string)
+		subject = newServer()
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should journal write commands", func() {
+		buf := new(bytes.Buffer)
+		j := subject.StartJournal(&JournalConfig{Writer: buf, Fsync: FsyncAlways})
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmdString("SET", "foo", "bar")
+		cw.WriteCmdString("GET", "foo")
+		cw.WriteCmdString("FAIL")
+		cw.WriteCmdString("SELECT", "2")
+		cw.WriteCmdString("SET", "baz", "qux")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadNil()).To(Succeed())
+		Expect(cr.ReadError()).To(Equal("ERR failed"))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+		subject.Propagate(2, "del", "baz")
+		Expect(buf.String()).To(Equal(strings.Join([]string{
+			"*3\r\n$3\r\nset\r\n$3\r\nfoo\r\n$3\r\nbar\r\n",
+			"*2\r\n$6\r\nselect\r\n$1\r\n2\r\n",
+			"*3\r\n$3\r\nset\r\n$3\r\nbaz\r\n$3\r\nqux\r\n",
+			"*2\r\n$3\r\ndel\r\n$3\r\nbaz\r\n",
+		}, "")))
+
+		n := buf.Len()
+		Expect(j.Close()).To(Succeed())
+		Expect(j.Err()).To(MatchError("redeo: journal closed"))
+
+		subject.Propagate(0, "del", "foo")
+		Expect(buf.Len()).To(Equal(n))
+	})
+
+	It("should journal writes of scripts", func() {
+		subject.HandleScripting(mockScriptEngine{
+			"set": func(keys, args []string, call ScriptCall) (interface{}, error) {
+				_, err := call("SET", keys[0], args[0])
+				return nil, err
+			},
+		})
+		buf := new(bytes.Buffer)
+		j := subject.StartJournal(&JournalConfig{Writer: buf, Fsync: FsyncAlways})
+		defer j.Close()
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmdString("EVAL", "set", "1", "foo", "bar")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadNil()).To(Succeed())
+		Expect(buf.String()).To(Equal("*3\r\n$3\r\nset\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"))
+	})
+
+	It("should buffer commands until closed", func() {
+		buf := new(bytes.Buffer)
+		j := subject.StartJournal(&JournalConfig{
+			Writer:  buf,
+			Fsync:   FsyncEverySec,
+			Journal: func(name string, _ []resp.CommandArgument) bool { return name == "del" },
+		})
+
+		subject.Propagate(0, "set", "foo", "bar")
+		Expect(buf.Len()).To(Equal(0))
+		Expect(j.Close()).To(Succeed())
+		Expect(buf.String()).To(Equal("*3\r\n$3\r\nset\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"))
+	})
+
+	It("should replay journals", func() {
+		journal := "*3\r\n$3\r\nset\r\n$3\r\nfoo\r\n$3\r\nbar\r\n" +
+			"*2\r\n$6\r\nselect\r\n$1\r\n2\r\n" +
+			"*3\r\n$3\r\nset\r\n$3\r\nbaz\r\n$3\r\nqux\r\n"
+
+		srv := newServer()
+		srv.config.RequirePass = "secret"
+		Expect(Replay(strings.NewReader(journal), srv)).To(Succeed())
+		Expect(data).To(Equal(map[string]string{"0:foo": "bar", "2:baz": "qux"}))
+		Expect(srv.Info().NumClients()).To(Equal(0))
+	})
+
+	It("should stop replays on errors", func() {
+		journal := "*3\r\n$3\r\nset\r\n$3\r\nfoo\r\n$3\r\nbar\r\n" +
+			"*1\r\n$4\r\nfail\r\n" +
+			"*3\r\n$3\r\nset\r\n$3\r\nbaz\r\n$3\r\nqux\r\n"
+
+		Expect(Replay(strings.NewReader(journal), newServer())).To(MatchError("redeo: replay of fail failed: ERR failed"))
+		Expect(data).To(Equal(map[string]string{"0:foo": "bar"}))
+	})
+
+})
@@ -0,0 +1,68 @@
+package redeo
+
+import (
+	"strconv"
+	"sync"
+)
+
+// KeyspaceEvent describes the modification of a key, see
+// Server.NotifyKeyspaceEvent.
+type KeyspaceEvent struct {
+	// DB is the database index of the key
+	DB int
+	// Event is the event name, e.g. "set", "del" or "expired"
+	Event string
+	// Key is the modified key
+	Key string
+}
+
+// keyspaceBus delivers keyspace events to listeners.
+type keyspaceBus struct {
+	listeners []func(KeyspaceEvent)
+	mu        sync.RWMutex
+}
+
+func (b *keyspaceBus) listen(fn func(KeyspaceEvent)) {
+	b.mu.Lock()
+	b.listeners = append(b.listeners, fn)
+	b.mu.Unlock()
+}
+
+func (b *keyspaceBus) notify(ev KeyspaceEvent) {
+	b.mu.RLock()
+	listeners := b.listeners
+	b.mu.RUnlock()
+
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
+
+// OnKeyspaceEvent registers fn to be called with the events reported via
+// NotifyKeyspaceEvent. Listeners are called synchronously, on the
+// goroutine reporting the event. Use PubSubBroker.NotifyKeyspaceEvent to
+// publish events as redis' keyspace notifications.
+func (srv *Server) OnKeyspaceEvent(fn func(KeyspaceEvent)) {
+	srv.keyspace.listen(fn)
+}
+
+// NotifyKeyspaceEvent reports that keys of the database db were modified
+// by event. The keys are also touched, see Touch.
+func (srv *Server) NotifyKeyspaceEvent(db int, event string, keys ...string) {
+	srv.Touch(db, keys...)
+	for _, key := range keys {
+		srv.keyspace.notify(KeyspaceEvent{DB: db, Event: event, Key: key})
+	}
+}
+
+// --------------------------------------------------------------------
+
+// NotifyKeyspaceEvent publishes ev on the __keyspace@<db>__:<key> channel,
+// with the event name as message, and on the __keyevent@<db>__:<event>
+// channel, with the key as message, like redis' keyspace notifications.
+// It can be registered via Server.OnKeyspaceEvent.
+func (b *PubSubBroker) NotifyKeyspaceEvent(ev KeyspaceEvent) {
+	db := strconv.Itoa(ev.DB)
+	b.PublishMessage("__keyspace@"+db+"__:"+ev.Key, ev.Event)
+	b.PublishMessage("__keyevent@"+db+"__:"+ev.Event, ev.Key)
+}
@@ -0,0 +1,223 @@
+package redeo
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// latencyHistoryLen is the number of samples retained per event.
+const latencyHistoryLen = 160
+
+// Latency event classes, see LatencyMonitor.
+const (
+	// LatencyCommand is the execution of a command.
+	LatencyCommand = "command"
+	// LatencyFlush is the flush of the replies of a pipeline.
+	LatencyFlush = "flush"
+	// LatencyAccept is the handshake of an accepted connection, i.e.
+	// reading the PROXY protocol header and TLS.
+	LatencyAccept = "accept"
+)
+
+// LatencySample is a latency spike.
+type LatencySample struct {
+	// Time is the time of the spike
+	Time time.Time
+	// Duration is the latency
+	Duration time.Duration
+}
+
+// LatencyEvent summarises the spikes of an event class.
+type LatencyEvent struct {
+	// Name is the event class, e.g. LatencyCommand
+	Name string
+	// Latest is the most recent spike
+	Latest LatencySample
+	// Max is the maximum latency since the last reset
+	Max time.Duration
+}
+
+// LatencyMonitor records latency spikes above
+// Config.LatencyMonitorThreshold per event class. Spikes within the same
+// second are merged, keeping the maximum.
+type LatencyMonitor struct {
+	events map[string]*latencySeries
+	mu     sync.Mutex
+}
+
+// latencySeries is the ring buffer of the samples of an event.
+type latencySeries struct {
+	samples [latencyHistoryLen]LatencySample
+	pos     int // position of the next sample
+	size    int // number of samples
+	max     time.Duration
+}
+
+func newLatencyMonitor() *LatencyMonitor {
+	return &LatencyMonitor{events: make(map[string]*latencySeries)}
+}
+
+// Latest returns the latest spike of each event, ordered by name.
+func (m *LatencyMonitor) Latest() []LatencyEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make([]LatencyEvent, 0, len(m.events))
+	for name, s := range m.events {
+		res = append(res, LatencyEvent{Name: name, Latest: s.latest(), Max: s.max})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// History returns the spikes of an event, oldest first.
+func (m *LatencyMonitor) History(event string) []LatencySample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.events[event]
+	if !ok {
+		return nil
+	}
+
+	res := make([]LatencySample, 0, s.size)
+	for i := s.size; i > 0; i-- {
+		res = append(res, s.samples[(s.pos-i+latencyHistoryLen)%latencyHistoryLen])
+	}
+	return res
+}
+
+// Reset removes the spikes of the given events or, if none are given,
+// of all events. Returns the number of events reset.
+func (m *LatencyMonitor) Reset(events ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(events) == 0 {
+		n := len(m.events)
+		m.events = make(map[string]*latencySeries)
+		return n
+	}
+
+	n := 0
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+func (m *LatencyMonitor) add(event string, t time.Time, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.events[event]
+	if !ok {
+		s = new(latencySeries)
+		m.events[event] = s
+	}
+	s.add(t, d)
+}
+
+func (s *latencySeries) latest() LatencySample {
+	return s.samples[(s.pos-1+latencyHistoryLen)%latencyHistoryLen]
+}
+
+func (s *latencySeries) add(t time.Time, d time.Duration) {
+	if d > s.max {
+		s.max = d
+	}
+
+	if s.size != 0 {
+		if last := &s.samples[(s.pos-1+latencyHistoryLen)%latencyHistoryLen]; last.Time.Unix() == t.Unix() {
+			if d > last.Duration {
+				last.Duration = d
+			}
+			return
+		}
+	}
+
+	s.samples[s.pos] = LatencySample{Time: t, Duration: d}
+	s.pos = (s.pos + 1) % latencyHistoryLen
+	if s.size < latencyHistoryLen {
+		s.size++
+	}
+}
+
+// --------------------------------------------------------------------
+
+// latencyStart returns the current time if the latency monitor is
+// enabled, the zero time otherwise.
+func (srv *Server) latencyStart() time.Time {
+	if srv.conf().LatencyMonitorThreshold > 0 {
+		return time.Now()
+	}
+	return time.Time{}
+}
+
+// noteLatency records a spike of an event which started at start, if it
+// exceeds Config.LatencyMonitorThreshold.
+func (srv *Server) noteLatency(event string, start time.Time) {
+	if !start.IsZero() {
+		srv.observeLatency(event, start, time.Since(start))
+	}
+}
+
+// observeLatency records a spike of duration d, if it exceeds
+// Config.LatencyMonitorThreshold.
+func (srv *Server) observeLatency(event string, start time.Time, d time.Duration) {
+	if t := srv.conf().LatencyMonitorThreshold; t > 0 && d >= t {
+		srv.latency.add(event, start, d)
+	}
+}
+
+// latencyCommand implements LATENCY LATEST, HISTORY and RESET.
+// https://redis.io/commands/latency-latest
+func latencyCommand(m *LatencyMonitor) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() == 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		switch sub := c.Arg(0).String(); strings.ToLower(sub) {
+		case "latest":
+			events := m.Latest()
+			w.AppendArrayLen(len(events))
+			for _, e := range events {
+				w.AppendArrayLen(4)
+				w.AppendBulkString(e.Name)
+				w.AppendInt(e.Latest.Time.Unix())
+				w.AppendInt(int64(e.Latest.Duration / time.Millisecond))
+				w.AppendInt(int64(e.Max / time.Millisecond))
+			}
+		case "history":
+			if c.ArgN() != 2 {
+				w.AppendError(WrongNumberOfArgs(c.Name + " " + sub))
+				return
+			}
+
+			samples := m.History(c.Arg(1).String())
+			w.AppendArrayLen(len(samples))
+			for _, s := range samples {
+				w.AppendArrayLen(2)
+				w.AppendInt(s.Time.Unix())
+				w.AppendInt(int64(s.Duration / time.Millisecond))
+			}
+		case "reset":
+			events := make([]string, 0, c.ArgN()-1)
+			for _, arg := range c.Args[1:] {
+				events = append(events, arg.String())
+			}
+			w.AppendInt(int64(m.Reset(events...)))
+		default:
+			w.AppendError("ERR Unknown " + strings.ToLower(c.Name) + " subcommand '" + sub + "'")
+		}
+	})
+}
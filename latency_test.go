@@ -0,0 +1,152 @@
+package redeo
+
+import (
+	"net"
+	"time"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LatencyMonitor", func() {
+	var subject *LatencyMonitor
+	var t0 = time.Unix(1500000000, 0)
+
+	BeforeEach(func() {
+		subject = newLatencyMonitor()
+	})
+
+	It("should record spikes", func() {
+		subject.add("command", t0, 5*time.Millisecond)
+		subject.add("command", t0.Add(100*time.Millisecond), 8*time.Millisecond)
+		subject.add("command", t0.Add(time.Second), 2*time.Millisecond)
+		subject.add("flush", t0, time.Millisecond)
+
+		Expect(subject.History("command")).To(Equal([]LatencySample{
+			{Time: t0, Duration: 8 * time.Millisecond},
+			{Time: t0.Add(time.Second), Duration: 2 * time.Millisecond},
+		}))
+		Expect(subject.History("accept")).To(BeEmpty())
+		Expect(subject.Latest()).To(Equal([]LatencyEvent{
+			{Name: "command", Latest: LatencySample{Time: t0.Add(time.Second), Duration: 2 * time.Millisecond}, Max: 8 * time.Millisecond},
+			{Name: "flush", Latest: LatencySample{Time: t0, Duration: time.Millisecond}, Max: time.Millisecond},
+		}))
+
+		Expect(subject.Reset("flush", "accept")).To(Equal(1))
+		Expect(subject.Latest()).To(HaveLen(1))
+		Expect(subject.Reset()).To(Equal(1))
+		Expect(subject.Latest()).To(BeEmpty())
+	})
+
+	It("should retain a limited history", func() {
+		for i := 0; i < latencyHistoryLen+10; i++ {
+			subject.add("command", t0.Add(time.Duration(i)*time.Second), time.Duration(i+1)*time.Millisecond)
+		}
+
+		history := subject.History("command")
+		Expect(history).To(HaveLen(latencyHistoryLen))
+		Expect(history[0].Time).To(Equal(t0.Add(10 * time.Second)))
+		Expect(history[latencyHistoryLen-1].Duration).To(Equal(time.Duration(latencyHistoryLen+10) * time.Millisecond))
+	})
+
+	It("should serve LATENCY", func() {
+		subject.add("command", t0, 5*time.Millisecond)
+		cmd := latencyCommand(subject)
+
+		w := redeotest.NewRecorder()
+		cmd.ServeRedeo(w, resp.NewCommand("LATENCY", resp.CommandArgument("latest")))
+		Expect(w.Response()).To(Equal([]interface{}{
+			[]interface{}{"command", int64(1500000000), int64(5), int64(5)},
+		}))
+
+		w = redeotest.NewRecorder()
+		cmd.ServeRedeo(w, resp.NewCommand("LATENCY", resp.CommandArgument("history"), resp.CommandArgument("command")))
+		Expect(w.Response()).To(Equal([]interface{}{
+			[]interface{}{int64(1500000000), int64(5)},
+		}))
+
+		w = redeotest.NewRecorder()
+		cmd.ServeRedeo(w, resp.NewCommand("LATENCY", resp.CommandArgument("history")))
+		Expect(w.Response()).To(MatchError("ERR wrong number of arguments for 'LATENCY history' command"))
+
+		w = redeotest.NewRecorder()
+		cmd.ServeRedeo(w, resp.NewCommand("LATENCY", resp.CommandArgument("reset"), resp.CommandArgument("command")))
+		Expect(w.Response()).To(Equal(int64(1)))
+
+		w = redeotest.NewRecorder()
+		cmd.ServeRedeo(w, resp.NewCommand("LATENCY", resp.CommandArgument("doctor")))
+		Expect(w.Response()).To(MatchError("ERR Unknown latency subcommand 'doctor'"))
+	})
+
+	It("should monitor commands", func() {
+		srv := NewServer(&Config{LatencyMonitorThreshold: 5 * time.Millisecond})
+		srv.HandleLatency()
+		srv.Handle("ping", Ping())
+		srv.HandleFunc("sleep", func(w resp.ResponseWriter, c *resp.Command) {
+			time.Sleep(10 * time.Millisecond)
+			w.AppendOK()
+		})
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go srv.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmd("PING")
+		cw.WriteCmd("SLEEP")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+		history := srv.LatencyMonitor().History(LatencyCommand)
+		Expect(history).To(HaveLen(1))
+		Expect(history[0].Duration).To(BeNumerically(">=", 10*time.Millisecond))
+	})
+
+	It("should monitor sharded and asynchronous commands", func() {
+		srv := NewServer(&Config{
+			LatencyMonitorThreshold: 5 * time.Millisecond,
+			ShardedDispatch: &ShardConfig{
+				Shards:       2,
+				KeyExtractor: func(cmd *resp.Command) []byte { return cmd.Arg(0) },
+			},
+		})
+		srv.HandleFunc("sleep", func(w resp.ResponseWriter, c *resp.Command) {
+			time.Sleep(10 * time.Millisecond)
+			w.AppendOK()
+		}, Arity(2))
+		srv.HandleAsyncFunc("asleep", func(p *Promise, c *resp.Command) {
+			time.Sleep(20 * time.Millisecond)
+			p.Resolve(func(w resp.ResponseWriter) { w.AppendOK() })
+		})
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go srv.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmdString("SLEEP", "key")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(srv.LatencyMonitor().History(LatencyCommand)).To(HaveLen(1))
+		Expect(srv.LatencyMonitor().Reset()).To(Equal(1))
+
+		cw.WriteCmd("ASLEEP")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Eventually(func() []LatencySample { return srv.LatencyMonitor().History(LatencyCommand) }).Should(HaveLen(1))
+	})
+
+})
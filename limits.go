@@ -0,0 +1,284 @@
+package redeo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangaoone/redeo/info"
+	"github.com/wangaoone/redeo/resp"
+)
+
+var errOutputBufferLimit = errors.New("redeo: output buffer limit reached")
+var errOutputBufferSoftLimit = errors.New("redeo: output buffer soft limit reached")
+
+// rateLimiter is a per-client token bucket, see Config.RateLimit. It is
+// only accessed by the goroutine serving the client, apart from limited.
+type rateLimiter struct {
+	tokens  float64
+	last    time.Time
+	limited int64 // number of delayed or rejected commands
+}
+
+// refill adds the tokens accrued since the last refill, up to burst.
+func (rl *rateLimiter) refill(now time.Time, rate, burst float64) {
+	if rl.last.IsZero() {
+		rl.tokens = burst
+	} else if rl.tokens += now.Sub(rl.last).Seconds() * rate; rl.tokens > burst {
+		rl.tokens = burst
+	}
+	rl.last = now
+}
+
+// throttle takes a token from the client's bucket, delaying the command
+// until one is available. Returns false if the command must be rejected
+// because the delay would exceed Config.RateLimitMaxDelay.
+func (srv *Server) throttle(c *Client) bool {
+	conf := srv.conf()
+	rate := conf.RateLimit
+	if rate <= 0 {
+		return true
+	}
+	burst := float64(conf.RateLimitBurst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	rl, now := &c.rl, time.Now()
+	rl.refill(now, rate, burst)
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return true
+	}
+
+	srv.info.rateLimited.Inc(1)
+	atomic.AddInt64(&rl.limited, 1)
+
+	wait := time.Duration((1 - rl.tokens) / rate * float64(time.Second))
+	if max := conf.RateLimitMaxDelay; max > 0 && wait > max {
+		return false
+	}
+	time.Sleep(wait)
+
+	rl.tokens, rl.last = 0, now.Add(wait)
+	return true
+}
+
+// outputBuffer returns the number of bytes of unflushed and pending replies.
+func (c *Client) outputBuffer() int {
+	return c.wr.Buffered() + int(atomic.LoadInt64(&c.held))
+}
+
+// checkOutput returns an error once the output of the client exceeds
+// Config.MaxOutputBuffer, or Config.OutputBufferSoftLimit for longer than
+// Config.OutputBufferSoftPeriod.
+func (srv *Server) checkOutput(c *Client) error {
+	n := c.outputBuffer()
+	atomic.StoreInt64(&c.omem, int64(n))
+
+	conf := srv.conf()
+	if max := conf.MaxOutputBuffer; max > 0 && n > max {
+		return errOutputBufferLimit
+	}
+
+	if soft := conf.OutputBufferSoftLimit; soft <= 0 || n <= soft {
+		c.softSince = time.Time{}
+	} else if now := time.Now(); c.softSince.IsZero() {
+		c.softSince = now
+		if conf.OutputBufferSoftPeriod <= 0 {
+			return errOutputBufferSoftLimit
+		}
+	} else if now.Sub(c.softSince) >= conf.OutputBufferSoftPeriod {
+		return errOutputBufferSoftLimit
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------
+
+// maxIdleBuckets is the number of per-address buckets above which full,
+// i.e. idle, buckets are discarded.
+const maxIdleBuckets = 1024
+
+// Rate is the limit of a token bucket.
+type Rate struct {
+	// Limit is the number of commands per second
+	Limit float64
+	// Burst is the number of commands which may be sent at once,
+	// at least 1
+	Burst int
+}
+
+func (r Rate) burst() float64 {
+	if r.Burst < 1 {
+		return 1
+	}
+	return float64(r.Burst)
+}
+
+// RateLimits configures a middleware which rejects commands over the
+// limits, see Server.UseRateLimits. Unlike Config.RateLimit, limits are
+// shared by all clients from the same address or calling the same
+// command, and commands are rejected rather than delayed.
+type RateLimits struct {
+	// PerAddr limits the commands of all clients of an IP address.
+	// Default: unlimited
+	PerAddr Rate
+
+	// PerCommand limits the calls of commands, by normalised name.
+	// Default: none
+	PerCommand map[string]Rate
+
+	// Error is the error reply of rejected commands.
+	// Default: "ERR rate limit exceeded"
+	Error string
+}
+
+// rateLimits holds the token buckets of RateLimits.
+type rateLimits struct {
+	conf     *RateLimits
+	addrs    map[string]*rateLimiter
+	cmds     map[string]*rateLimiter
+	mu       sync.Mutex
+	rejected *info.IntValue
+}
+
+// allow takes a token from the buckets of addr and cmd.
+func (l *rateLimits) allow(addr, cmd string) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var byAddr, byCmd *rateLimiter
+	if r := l.conf.PerAddr; r.Limit > 0 && addr != "" {
+		byAddr = l.bucket(l.addrs, addr, now, r)
+	}
+	if r, ok := l.conf.PerCommand[cmd]; ok && r.Limit > 0 {
+		byCmd = l.bucket(l.cmds, cmd, now, r)
+	}
+
+	// only take tokens if both buckets allow the command
+	if (byAddr != nil && byAddr.tokens < 1) || (byCmd != nil && byCmd.tokens < 1) {
+		if byAddr != nil {
+			byAddr.limited++
+		}
+		if byCmd != nil {
+			byCmd.limited++
+		}
+		l.rejected.Inc(1)
+		return false
+	}
+	if byAddr != nil {
+		byAddr.tokens--
+	}
+	if byCmd != nil {
+		byCmd.tokens--
+	}
+	return true
+}
+
+// bucket returns the refilled bucket of key, must be called with the
+// lock held.
+func (l *rateLimits) bucket(buckets map[string]*rateLimiter, key string, now time.Time, r Rate) *rateLimiter {
+	rl, ok := buckets[key]
+	if !ok {
+		if len(buckets) >= maxIdleBuckets {
+			for k, b := range buckets {
+				if b.refill(now, r.Limit, r.burst()); b.tokens >= r.burst() {
+					delete(buckets, k)
+				}
+			}
+		}
+		rl = new(rateLimiter)
+		buckets[key] = rl
+	}
+	rl.refill(now, r.Limit, r.burst())
+	return rl
+}
+
+// state returns the remaining tokens and the number of rejections of the
+// bucket of cmd.
+func (l *rateLimits) state(cmd string, r Rate) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tokens, rejected := r.burst(), int64(0)
+	if rl, ok := l.cmds[cmd]; ok {
+		rl.refill(time.Now(), r.Limit, r.burst())
+		tokens, rejected = rl.tokens, rl.limited
+	}
+	return "tokens=" + strconv.Itoa(int(tokens)) + ",rejected=" + strconv.FormatInt(rejected, 10)
+}
+
+// UseRateLimits registers a middleware which rejects commands over the
+// given limits with an error reply. A RateLimits section is added to
+// INFO. Limits must not be modified once used.
+func (srv *Server) UseRateLimits(limits *RateLimits) {
+	l := &rateLimits{
+		conf:     limits,
+		addrs:    make(map[string]*rateLimiter),
+		cmds:     make(map[string]*rateLimiter),
+		rejected: info.NewIntValue(0),
+	}
+	msg := limits.Error
+	if msg == "" {
+		msg = "ERR rate limit exceeded"
+	}
+
+	allow := func(ctx context.Context, name string) bool {
+		var addr string
+		if c := GetClient(ctx); c != nil {
+			addr = c.RemoteAddr().String()
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				addr = host
+			}
+		}
+		return l.allow(addr, strings.ToLower(name))
+	}
+
+	section := srv.info.Fetch("RateLimits")
+	section.Register("rate_limit_rejected", l.rejected)
+	section.Register("rate_limit_addrs", info.Callback(func() string {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return strconv.Itoa(len(l.addrs))
+	}))
+
+	names := make([]string, 0, len(limits.PerCommand))
+	for name := range limits.PerCommand {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		name, r := name, limits.PerCommand[name]
+		section.Register("rate_limit_cmd_"+name, info.Callback(func() string {
+			return l.state(name, r)
+		}))
+	}
+
+	srv.Use(func(next Handler) Handler {
+		return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+			if !allow(c.Context(), c.Name) {
+				w.AppendError(msg)
+				return
+			}
+			next.ServeRedeo(w, c)
+		})
+	})
+	srv.UseStream(func(next StreamHandler) StreamHandler {
+		return StreamHandlerFunc(func(w resp.ResponseWriter, c *resp.CommandStream) {
+			if !allow(c.Context(), c.Name) {
+				w.AppendError(msg)
+				return
+			}
+			next.ServeRedeoStream(w, c)
+		})
+	})
+}
@@ -0,0 +1,189 @@
+package redeo
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/wangaoone/redeo/client"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limits", func() {
+	var subject *Server
+	var lis net.Listener
+
+	var dial = func() client.Conn {
+		c, err := client.Dial(lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		return c
+	}
+
+	var serve = func(config *Config) {
+		subject = NewServer(config)
+		subject.Handle("ping", Ping())
+		subject.HandleFunc("big", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendBulkString(strings.Repeat("x", 200))
+		})
+		go subject.Serve(lis)
+	}
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should delay commands over the rate limit", func() {
+		serve(&Config{RateLimit: 50, RateLimitBurst: 2})
+
+		c := dial()
+		defer c.Close()
+
+		start := time.Now()
+		for i := 0; i < 4; i++ {
+			c.Append("PING")
+		}
+		Expect(c.Flush()).To(Succeed())
+		for i := 0; i < 4; i++ {
+			r, err := c.Receive()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Str()).To(Equal("PONG"))
+		}
+		Expect(time.Since(start)).To(BeNumerically(">=", 35*time.Millisecond))
+		Expect(subject.Info().RateLimitedCommands()).To(Equal(int64(2)))
+	})
+
+	It("should reject commands delayed for too long", func() {
+		serve(&Config{RateLimit: 1, RateLimitMaxDelay: 10 * time.Millisecond})
+
+		c := dial()
+		defer c.Close()
+
+		c.Append("PING")
+		c.Append("PING")
+		c.Append("PING")
+		Expect(c.Flush()).To(Succeed())
+
+		r, err := c.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("PONG"))
+		for i := 0; i < 2; i++ {
+			r, err := c.Receive()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Err()).To(MatchError("ERR rate limit exceeded"))
+		}
+
+		info := subject.Info().ClientInfo()
+		Expect(info).To(HaveLen(1))
+		Expect(info[0].RateLimited).To(Equal(int64(2)))
+		Expect(subject.Info().TotalCommands()).To(Equal(int64(1)))
+	})
+
+	It("should reject commands over address and command limits", func() {
+		serve(nil)
+		subject.UseRateLimits(&RateLimits{
+			PerAddr:    Rate{Limit: 0.001, Burst: 3},
+			PerCommand: map[string]Rate{"big": {Limit: 0.001}},
+			Error:      "BUSY slow down",
+		})
+
+		c1, c2 := dial(), dial()
+		defer c1.Close()
+		defer c2.Close()
+
+		for _, c := range []client.Conn{c1, c2} {
+			r, err := c.Cmd("BIG")
+			Expect(err).NotTo(HaveOccurred())
+			if c == c1 {
+				Expect(r.Str()).To(HaveLen(200))
+			} else {
+				Expect(r.Err()).To(MatchError("BUSY slow down"))
+			}
+		}
+
+		// clients of the same address share a bucket
+		for _, c := range []client.Conn{c2, c1} {
+			r, err := c.Cmd("PING")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Str()).To(Equal("PONG"))
+		}
+		r, err := c2.Cmd("PING")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("BUSY slow down"))
+
+		Expect(subject.Info().String()).To(ContainSubstring("# RateLimits\n" +
+			"rate_limit_rejected:2\n" +
+			"rate_limit_addrs:1\n" +
+			"rate_limit_cmd_big:tokens=0,rejected=1\n"))
+	})
+
+	It("should disconnect clients over the output buffer limit", func() {
+		serve(&Config{MaxOutputBuffer: 100})
+
+		c := dial()
+		defer c.Close()
+
+		r, err := c.Cmd("PING")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("PONG"))
+
+		_, err = c.Cmd("BIG")
+		Expect(err).To(HaveOccurred())
+		Eventually(subject.Info().NumClients).Should(Equal(0))
+		Expect(subject.Info().OutputBufferKills()).To(Equal(int64(1)))
+		Expect(subject.Info().String()).To(ContainSubstring("clients_killed_for_output_buffer:1\n"))
+	})
+
+	It("should disconnect clients over the soft output buffer limit", func() {
+		serve(&Config{OutputBufferSoftLimit: 100, OutputBufferSoftPeriod: time.Hour})
+
+		c := dial()
+		defer c.Close()
+
+		r, err := c.Cmd("BIG")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(HaveLen(200))
+		Expect(subject.Info().SoftOutputBufferKills()).To(Equal(int64(0)))
+
+		Expect(subject.GetConfig("client-output-buffer-limit")).To(HaveKeyWithValue("client-output-buffer-limit", "normal 0 100 3600"))
+		Expect(subject.SetConfig("client-output-buffer-limit", "normal 0 100 0")).To(Succeed())
+		_, err = c.Cmd("BIG")
+		Expect(err).To(HaveOccurred())
+		Eventually(subject.Info().NumClients).Should(Equal(0))
+		Expect(subject.Info().SoftOutputBufferKills()).To(Equal(int64(1)))
+		Expect(subject.Info().String()).To(ContainSubstring("clients_killed_for_soft_output_buffer:1\n"))
+	})
+
+	It("should kill clients", func() {
+		var reason error
+		serve(&Config{OnClientError: func(_ *ClientInfo, err error) { reason = err }})
+
+		c := dial()
+		defer c.Close()
+
+		r, err := c.Cmd("PING")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("PONG"))
+
+		id := subject.Info().ClientInfo()[0].ID
+		Expect(subject.KillClient(id, "maintenance")).To(BeTrue())
+		Expect(subject.KillClient(id, "maintenance")).To(BeFalse())
+		Expect(reason).To(MatchError("redeo: client killed: maintenance"))
+
+		_, err = c.Cmd("PING")
+		Expect(err).To(HaveOccurred())
+		Eventually(subject.Info().NumClients).Should(Equal(0))
+		Expect(subject.Info().KilledClients()).To(Equal(int64(1)))
+		Expect(subject.Info().EvictedClients()).To(Equal(int64(1)))
+		Expect(subject.Info().String()).To(ContainSubstring("killed_clients:1\n"))
+		Expect(subject.Info().String()).To(ContainSubstring("evicted_clients:1\n"))
+	})
+
+})
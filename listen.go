@@ -0,0 +1,236 @@
+package redeo
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// defaultAddr is the address used by the ListenAndServe methods if addr
+// is blank.
+const defaultAddr = ":6379"
+
+// ListenAndServe listens on the TCP network address addr and then
+// calls Serve to handle incoming connections. If addr is blank, ":6379"
+// is used. Config.TCPKeepAlive and Config.MaxClients apply to accepted
+// connections, the accept backlog is determined by the OS.
+func (srv *Server) ListenAndServe(addr string) error {
+	lis, err := srv.listenTCP(listenAddr(addr))
+	if err != nil {
+		return err
+	}
+	if len(lis) > 1 {
+		return srv.serveAll(lis, nil)
+	}
+	return srv.serveListener(lis[0])
+}
+
+// ListenAndServeUnix listens on the unix socket at path and then calls
+// Serve to handle incoming connections. A stale socket file is removed
+// before listening, the socket file is created with the given permissions
+// and removed again when the listener is closed. The path is reported as
+// unix_socket by INFO.
+func (srv *Server) ListenAndServeUnix(path string, perm os.FileMode) error {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		_ = lis.Close()
+		return err
+	}
+	return srv.serveListener(lis)
+}
+
+// ListenAndServeTLS listens on the TCP network address addr and then
+// calls Serve to handle incoming TLS connections. If tlsConf is nil,
+// Config.TLSConfig is used. See ListenAndServe for the handling of addr.
+func (srv *Server) ListenAndServeTLS(addr string, tlsConf *tls.Config) error {
+	if tlsConf == nil {
+		tlsConf = srv.conf().TLSConfig
+	}
+	if tlsConf == nil {
+		return errors.New("redeo: TLS config required")
+	}
+
+	lis, err := srv.listenTCP(listenAddr(addr))
+	if err != nil {
+		return err
+	}
+	return srv.serveAll(lis, tlsConf)
+}
+
+// ServeAll serves all listeners concurrently, sharing handlers, clients
+// and INFO stats, e.g. a TCP port, a unix socket and a TLS port wrapped
+// via tls.NewListener. It returns once all listeners have stopped. If
+// any listener fails, the others are closed and the first error is
+// returned, otherwise ErrServerClosed like Serve.
+func (srv *Server) ServeAll(listeners ...net.Listener) error {
+	return srv.serveAll(listeners, nil)
+}
+
+func (srv *Server) serveAll(listeners []net.Listener, tlsConf *tls.Config) error {
+	for _, lis := range listeners {
+		srv.trackListener(lis, true)
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, lis := range listeners {
+		go func(lis net.Listener) {
+			defer srv.trackListener(lis, false)
+			errs <- srv.serveImpl(lis, true, tlsConf)
+		}(lis)
+	}
+
+	err := ErrServerClosed
+	for range listeners {
+		if e := <-errs; e != ErrServerClosed && err == ErrServerClosed {
+			err = e
+			_ = srv.Close(listeners...)
+		}
+	}
+	return err
+}
+
+// serveListener tracks lis before serving it, so it can be closed via Close
+// as soon as the ListenAndServe methods have created it.
+func (srv *Server) serveListener(lis net.Listener) error {
+	srv.trackListener(lis, true)
+	defer srv.trackListener(lis, false)
+
+	return srv.Serve(lis)
+}
+
+// --------------------------------------------------------------------
+
+// listenTCP creates the TCP listeners for addr, one per accept loop, see
+// Config.ListenConfig and Config.AcceptLoops.
+func (srv *Server) listenTCP(addr string) ([]net.Listener, error) {
+	conf := srv.conf()
+
+	var lc net.ListenConfig
+	if conf.ListenConfig != nil {
+		lc = *conf.ListenConfig
+	}
+
+	n := conf.AcceptLoops
+	if n < 2 {
+		lis, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{lis}, nil
+	}
+
+	control := lc.Control
+	lc.Control = func(network, address string, c syscall.RawConn) error {
+		if err := reusePort(c); err != nil {
+			return err
+		}
+		if control != nil {
+			return control(network, address, c)
+		}
+		return nil
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		lis, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		// bind the remaining listeners to the same port, if addr
+		// requested an ephemeral one
+		addr = lis.Addr().String()
+		listeners = append(listeners, lis)
+	}
+	return listeners, nil
+}
+
+func listenAddr(addr string) string {
+	if addr == "" {
+		return defaultAddr
+	}
+	return addr
+}
+
+// acceptConn applies Config.TCPKeepAlive, Config.AcceptProxyProtocol,
+// Config.ConnWrapper and TLS to an accepted connection and creates a
+// client for it. Config.TLSConfig is used if tlsConf is nil.
+func (srv *Server) acceptConn(cn net.Conn, tlsConf *tls.Config) *Client {
+	conf := srv.conf()
+	if ka := conf.TCPKeepAlive; ka > 0 {
+		setKeepAlive(cn, ka)
+	}
+
+	var pc *proxyProtoConn
+	if conf.AcceptProxyProtocol {
+		pc = &proxyProtoConn{Conn: cn}
+		cn = pc
+	}
+	if fn := conf.ConnWrapper; fn != nil {
+		cn = fn(cn)
+	}
+	if tlsConf == nil {
+		tlsConf = conf.TLSConfig
+	}
+	if tlsConf != nil {
+		cn = wrapTLS(cn, tlsConf)
+	}
+
+	c := srv.newClient(cn)
+	c.proxy = pc
+	return c
+}
+
+// wrapTLS wraps cn in a TLS server connection, unless the listener has
+// wrapped it already.
+func wrapTLS(cn net.Conn, conf *tls.Config) net.Conn {
+	if _, ok := cn.(*tls.Conn); ok {
+		return cn
+	}
+	return tls.Server(cn, conf)
+}
+
+// handshake reads the PROXY protocol header and performs the TLS
+// handshake of c, each within Config.Timeout. Handshakes of other
+// connections are no-ops.
+func (srv *Server) handshake(c *Client) error {
+	d := srv.conf().Timeout
+	if c.proxy != nil {
+		if err := c.proxy.handshake(d); err != nil {
+			return err
+		}
+	}
+
+	tc, ok := c.cn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	if d > 0 {
+		_ = tc.SetDeadline(time.Now().Add(d))
+		defer tc.SetDeadline(time.Time{})
+	}
+	return tc.Handshake()
+}
+
+func setKeepAlive(cn net.Conn, period time.Duration) {
+	if tc, ok := cn.(*net.TCPConn); ok {
+		_ = tc.SetKeepAlive(true)
+		_ = tc.SetKeepAlivePeriod(period)
+	}
+}
@@ -0,0 +1,392 @@
+package redeo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server.ListenAndServe", func() {
+	var subject *Server
+	var dir string
+
+	var ping = func(cn net.Conn) {
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmd("PING")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+	}
+
+	var serve = func(fn func() error) chan error {
+		ch := make(chan error, 1)
+		go func() { ch <- fn() }()
+		return ch
+	}
+
+	var freeAddr = func() string {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		return lis.Addr().String()
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "redeo-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		subject = NewServer(&Config{TCPKeepAlive: time.Minute})
+		subject.Handle("ping", Ping())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should serve TCP", func() {
+		addr := freeAddr()
+		srv := subject
+		ch := serve(func() error { return srv.ListenAndServe(addr) })
+
+		var cn net.Conn
+		Eventually(func() (err error) {
+			cn, err = net.Dial("tcp", addr)
+			return
+		}).Should(Succeed())
+		defer cn.Close()
+		ping(cn)
+
+		Expect(subject.Close()).To(Succeed())
+		Eventually(ch).Should(Receive(Equal(ErrServerClosed)))
+	})
+
+	It("should return on shutdown", func() {
+		addr := freeAddr()
+		srv := subject
+		ch := serve(func() error { return srv.ListenAndServe(addr) })
+
+		var cn net.Conn
+		Eventually(func() (err error) {
+			cn, err = net.Dial("tcp", addr)
+			return
+		}).Should(Succeed())
+		ping(cn)
+		Expect(cn.Close()).To(Succeed())
+
+		Expect(subject.Shutdown(context.Background())).To(Succeed())
+		Eventually(ch).Should(Receive(Equal(ErrServerClosed)))
+		Expect(listenAddr("")).To(Equal(":6379"))
+	})
+
+	It("should serve unix sockets", func() {
+		path := filepath.Join(dir, "redeo.sock")
+
+		// stale socket file
+		stale, err := net.Listen("unix", path+".stale")
+		Expect(err).NotTo(HaveOccurred())
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		Expect(stale.Close()).To(Succeed())
+		Expect(os.Rename(path+".stale", path)).To(Succeed())
+
+		srv := subject
+		ch := serve(func() error { return srv.ListenAndServeUnix(path, 0660) })
+
+		var cn net.Conn
+		Eventually(func() (err error) {
+			cn, err = net.Dial("unix", path)
+			return
+		}).Should(Succeed())
+		defer cn.Close()
+		ping(cn)
+
+		fi, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0660)))
+		Expect(subject.Info().String()).To(ContainSubstring("unix_socket:" + path + "\n"))
+
+		Expect(subject.Close()).To(Succeed())
+		Eventually(ch).Should(Receive(Equal(ErrServerClosed)))
+		Expect(path).NotTo(BeAnExistingFile())
+	})
+
+	It("should not remove regular files", func() {
+		path := filepath.Join(dir, "redeo.sock")
+		Expect(ioutil.WriteFile(path, nil, 0600)).To(Succeed())
+		Expect(subject.ListenAndServeUnix(path, 0660)).To(HaveOccurred())
+		Expect(path).To(BeAnExistingFile())
+	})
+
+	It("should serve TLS", func() {
+		cert := generateCert()
+		addr := freeAddr()
+		srv := subject
+		ch := serve(func() error {
+			return srv.ListenAndServeTLS(addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+		})
+
+		var cn net.Conn
+		Eventually(func() (err error) {
+			cn, err = tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+			return
+		}).Should(Succeed())
+		defer cn.Close()
+		ping(cn)
+
+		Expect(subject.Close()).To(Succeed())
+		Eventually(ch).Should(Receive(Equal(ErrServerClosed)))
+	})
+
+	It("should serve TLS with certificate files", func() {
+		cert := generateCert()
+		certFile, keyFile := writeCert(dir, cert)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		srv := subject
+		ch := serve(func() error { return srv.ServeTLS(lis, certFile, keyFile) })
+
+		cn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+		ping(cn)
+
+		Expect(subject.Close()).To(Succeed())
+		Eventually(ch).Should(Receive(Equal(ErrServerClosed)))
+	})
+
+	It("should fail to serve TLS without certificates", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+
+		Expect(subject.ServeTLS(lis, "", "")).To(HaveOccurred())
+	})
+
+	It("should verify client certificates", func() {
+		cert, peer := generateCert(), generateCert()
+		leaf, err := x509.ParseCertificate(peer.Certificate[0])
+		Expect(err).NotTo(HaveOccurred())
+		pool := x509.NewCertPool()
+		pool.AddCert(leaf)
+
+		subject = NewServer(&Config{TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+		}})
+		subject.HandleFunc("whoami", func(w resp.ResponseWriter, c *resp.Command) {
+			state, _ := GetClient(c.Context()).TLSConnectionState()
+			w.AppendBulkString(state.PeerCertificates[0].Subject.CommonName)
+		})
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		srv := subject
+		ch := serve(func() error { return srv.Serve(lis) })
+
+		cn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       []tls.Certificate{peer},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmd("WHOAMI")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadBulkString()).To(Equal("localhost"))
+
+		// without certificate
+		anon, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			defer anon.Close()
+			_, err = anon.Read(make([]byte, 1))
+		}
+		Expect(err).To(HaveOccurred())
+		Expect(subject.Info().TotalConnections()).To(Equal(int64(1)))
+
+		Expect(subject.Close()).To(Succeed())
+		Eventually(ch).Should(Receive(Equal(ErrServerClosed)))
+	})
+
+	It("should wrap connections", func() {
+		var keepAlive int32
+		subject = NewServer(&Config{
+			TCPKeepAlive: time.Minute,
+			ConnWrapper: func(cn net.Conn) net.Conn {
+				if _, ok := cn.(*net.TCPConn); ok {
+					atomic.AddInt32(&keepAlive, 1)
+				}
+				return xorConn{cn}
+			},
+		})
+		subject.Handle("ping", Ping())
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		srv := subject
+		ch := serve(func() error { return srv.Serve(lis) })
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+		ping(xorConn{cn})
+		Expect(atomic.LoadInt32(&keepAlive)).To(Equal(int32(1)))
+
+		Expect(subject.Close()).To(Succeed())
+		Eventually(ch).Should(Receive(Equal(ErrServerClosed)))
+	})
+
+	It("should shard accept loops", func() {
+		var sockets int32
+		subject = NewServer(&Config{
+			AcceptLoops: 3,
+			ListenConfig: &net.ListenConfig{
+				Control: func(_, _ string, _ syscall.RawConn) error {
+					atomic.AddInt32(&sockets, 1)
+					return nil
+				},
+			},
+		})
+		subject.Handle("ping", Ping())
+
+		addr, srv := freeAddr(), subject
+		ch := serve(func() error { return srv.ListenAndServe(addr) })
+
+		for i := 0; i < 6; i++ {
+			var cn net.Conn
+			Eventually(func() (err error) {
+				cn, err = net.Dial("tcp", addr)
+				return
+			}).Should(Succeed())
+			ping(cn)
+			Expect(cn.Close()).To(Succeed())
+		}
+		Expect(atomic.LoadInt32(&sockets)).To(Equal(int32(3)))
+
+		Expect(subject.Close()).To(Succeed())
+		Eventually(ch).Should(Receive(Equal(ErrServerClosed)))
+	})
+
+	It("should require a TLS config", func() {
+		Expect(subject.ListenAndServeTLS("127.0.0.1:0", nil)).To(MatchError("redeo: TLS config required"))
+	})
+
+	It("should serve multiple listeners", func() {
+		addr, path := freeAddr(), filepath.Join(dir, "redeo.sock")
+		srv := subject
+		ch1 := serve(func() error { return srv.ListenAndServe(addr) })
+		ch2 := serve(func() error { return srv.ListenAndServeUnix(path, 0600) })
+
+		var cn1, cn2 net.Conn
+		Eventually(func() (err error) {
+			cn1, err = net.Dial("tcp", addr)
+			return
+		}).Should(Succeed())
+		defer cn1.Close()
+		Eventually(func() (err error) {
+			cn2, err = net.Dial("unix", path)
+			return
+		}).Should(Succeed())
+		defer cn2.Close()
+
+		ping(cn1)
+		ping(cn2)
+
+		Expect(subject.Close()).To(Succeed())
+		Eventually(ch1).Should(Receive(Equal(ErrServerClosed)))
+		Eventually(ch2).Should(Receive(Equal(ErrServerClosed)))
+	})
+
+	It("should serve all listeners", func() {
+		lis1, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		lis2, err := net.Listen("unix", filepath.Join(dir, "redeo.sock"))
+		Expect(err).NotTo(HaveOccurred())
+
+		srv := subject
+		ch := serve(func() error { return srv.ServeAll(lis1, lis2) })
+
+		cn1, err := net.Dial("tcp", lis1.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn1.Close()
+		cn2, err := net.Dial("unix", lis2.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn2.Close()
+
+		ping(cn1)
+		ping(cn2)
+		Expect(subject.Info().TotalConnections()).To(Equal(int64(2)))
+
+		Expect(subject.Close(lis1)).To(Succeed())
+		Consistently(ch).ShouldNot(Receive())
+		ping(cn2)
+
+		Expect(subject.Shutdown(context.Background())).To(Succeed())
+		Eventually(ch).Should(Receive(Equal(ErrServerClosed)))
+	})
+
+})
+
+// xorConn is a toy codec, which flips the bits of all bytes.
+type xorConn struct{ net.Conn }
+
+func (c xorConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0xff
+	}
+	return n, err
+}
+
+func (c xorConn) Write(p []byte) (int, error) {
+	q := make([]byte, len(p))
+	for i, b := range p {
+		q[i] = b ^ 0xff
+	}
+	return c.Conn.Write(q)
+}
+
+func generateCert() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writeCert(dir string, cert tls.Certificate) (certFile, keyFile string) {
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	Expect(err).NotTo(HaveOccurred())
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)).To(Succeed())
+	Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)).To(Succeed())
+	return
+}
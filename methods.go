@@ -0,0 +1,75 @@
+package redeo
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	commandType = reflect.TypeOf((*resp.Command)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// HandleStruct registers the exported methods of v as commands, named
+// by prefix and the lower-cased method name, e.g. "user.get" for method
+// Get and prefix "user.". Methods must have one of the signatures:
+//
+//	func(ctx context.Context, c *resp.Command) (T, error)
+//	func(ctx context.Context, c *resp.Command) error
+//
+// Other methods are skipped. Values of T are appended via
+// resp.ResponseWriter.AppendAny, e.g. strings, integers, slices, maps or
+// structs. Methods returning only an error reply with OK. Errors are
+// rendered via resp.ErrorReply. Returns the names of the registered
+// commands.
+func (srv *Server) HandleStruct(prefix string, v interface{}, opts ...HandlerOption) []string {
+	rv := reflect.ValueOf(v)
+	rt := rv.Type()
+
+	var names []string
+	for i := 0; i < rt.NumMethod(); i++ {
+		h := methodHandler(rv.Method(i))
+		if h == nil {
+			continue
+		}
+
+		name := strings.ToLower(prefix + rt.Method(i).Name)
+		srv.Handle(name, h, opts...)
+		names = append(names, name)
+	}
+	return names
+}
+
+// methodHandler returns a handler for a method, nil if its signature is
+// not supported.
+func methodHandler(m reflect.Value) Handler {
+	// avoid reflection for common signatures
+	switch fn := m.Interface().(type) {
+	case func(context.Context, *resp.Command) (interface{}, error):
+		return ValueHandlerFunc(fn)
+	case func(context.Context, *resp.Command) error:
+		return ErrorHandlerFunc(func(w resp.ResponseWriter, c *resp.Command) error {
+			if err := fn(c.Context(), c); err != nil {
+				return err
+			}
+			w.AppendOK()
+			return nil
+		})
+	}
+
+	t := m.Type()
+	if t.NumIn() != 2 || t.In(0) != contextType || t.In(1) != commandType ||
+		t.NumOut() != 2 || t.Out(1) != errorType {
+		return nil
+	}
+
+	return ValueHandlerFunc(func(ctx context.Context, c *resp.Command) (interface{}, error) {
+		out := m.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(c)})
+		err, _ := out[1].Interface().(error)
+		return out[0].Interface(), err
+	})
+}
@@ -0,0 +1,127 @@
+package redeo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server.HandleStruct", func() {
+	var subject *Server
+	var names []string
+
+	var serve = func(name string, args ...string) interface{} {
+		cargs := make([]resp.CommandArgument, len(args))
+		for i, arg := range args {
+			cargs[i] = resp.CommandArgument(arg)
+		}
+		w := redeotest.NewRecorder()
+		subject.cmds[name].served.(Handler).ServeRedeo(w, resp.NewCommand(name, cargs...))
+		v, err := w.Response()
+		Expect(err).NotTo(HaveOccurred())
+		return v
+	}
+
+	BeforeEach(func() {
+		subject = NewServer(nil)
+		names = subject.HandleStruct("svc.", &testService{vals: map[string]string{"k": "v"}})
+	})
+
+	It("should register methods", func() {
+		Expect(names).To(Equal([]string{
+			"svc.any",
+			"svc.bad",
+			"svc.fail",
+			"svc.get",
+			"svc.hash",
+			"svc.incr",
+			"svc.keys",
+			"svc.record",
+			"svc.set",
+		}))
+		Expect(subject.cmds).NotTo(HaveKey("svc.helper"))
+		Expect(subject.cmds).NotTo(HaveKey("svc.private"))
+	})
+
+	It("should marshal return values", func() {
+		Expect(serve("svc.get", "k")).To(Equal("v"))
+		Expect(serve("svc.get", "x")).To(BeNil())
+		Expect(serve("svc.incr")).To(Equal(int64(1)))
+		Expect(serve("svc.incr")).To(Equal(int64(2)))
+		Expect(serve("svc.keys")).To(Equal([]interface{}{"k"}))
+		Expect(serve("svc.hash")).To(Equal([]interface{}{"k", "v"}))
+		Expect(serve("svc.any")).To(Equal("any"))
+		Expect(serve("svc.record")).To(Equal([]interface{}{"name", "k", "size", int64(1)}))
+		Expect(serve("svc.set", "a", "b")).To(Equal("OK"))
+		Expect(serve("svc.get", "a")).To(Equal("b"))
+	})
+
+	It("should render errors", func() {
+		Expect(serve("svc.fail")).To(Equal(redeotest.ErrorResponse("WRONGTYPE Operation against a key holding the wrong kind of value")))
+		Expect(serve("svc.set")).To(Equal(redeotest.ErrorResponse("ERR wrong number of arguments")))
+		Expect(serve("svc.bad")).To(Equal(redeotest.ErrorResponse("ERR resp: unsupported type chan int")))
+	})
+
+})
+
+type testService struct {
+	vals map[string]string
+	n    int64
+}
+
+func (s *testService) Get(_ context.Context, c *resp.Command) (interface{}, error) {
+	if v, ok := s.vals[c.Arg(0).String()]; ok {
+		return v, nil
+	}
+	return nil, nil
+}
+
+func (s *testService) Set(_ context.Context, c *resp.Command) error {
+	if c.ArgN() != 2 {
+		return errors.New("wrong number of arguments")
+	}
+	s.vals[c.Arg(0).String()] = c.Arg(1).String()
+	return nil
+}
+
+func (s *testService) Incr(_ context.Context, _ *resp.Command) (int64, error) {
+	s.n++
+	return s.n, nil
+}
+
+func (s *testService) Keys(_ context.Context, _ *resp.Command) ([]string, error) {
+	return []string{"k"}, nil
+}
+
+func (s *testService) Hash(_ context.Context, _ *resp.Command) (map[string]string, error) {
+	return map[string]string{"k": s.vals["k"]}, nil
+}
+
+func (s *testService) Any(_ context.Context, _ *resp.Command) (string, error) {
+	return "any", nil
+}
+
+func (s *testService) Record(_ context.Context, _ *resp.Command) (*testRecord, error) {
+	return &testRecord{Name: "k", Size: 1}, nil
+}
+
+func (s *testService) Fail(_ context.Context, _ *resp.Command) (string, error) {
+	return "", ErrWrongType
+}
+
+func (s *testService) Bad(_ context.Context, _ *resp.Command) (chan int, error) {
+	return make(chan int), nil
+}
+
+type testRecord struct {
+	Name string `redis:"name"`
+	Size int    `redis:"size"`
+}
+
+func (s *testService) Helper() string { return "" }
+
+func (s *testService) private(_ context.Context, _ *resp.Command) error { return nil }
@@ -0,0 +1,248 @@
+package redeo
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// monitorFeedSize is the number of lines a monitor may lag behind,
+// before it is considered too slow and disconnected.
+const monitorFeedSize = 1024
+
+// monitorSet holds the clients in MONITOR mode.
+type monitorSet struct {
+	monitors map[uint64]*monitor
+	mu       sync.RWMutex
+	n        int32
+}
+
+type monitor struct {
+	client *Client
+	feed   chan string
+}
+
+// active returns true if there are any monitors.
+func (s *monitorSet) active() bool {
+	return atomic.LoadInt32(&s.n) != 0
+}
+
+// add starts monitoring for c, returns false if c is already a monitor.
+func (s *monitorSet) add(c *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.monitors[c.ID()]; ok {
+		return false
+	}
+	if s.monitors == nil {
+		s.monitors = make(map[uint64]*monitor)
+	}
+
+	m := &monitor{client: c, feed: make(chan string, monitorFeedSize)}
+	s.monitors[c.ID()] = m
+	atomic.AddInt32(&s.n, 1)
+
+	go m.loop()
+	return true
+}
+
+// remove stops monitoring for the client, returns false if the client
+// was not a monitor.
+func (s *monitorSet) remove(clientID uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.monitors[clientID]
+	if !ok {
+		return false
+	}
+	delete(s.monitors, clientID)
+	atomic.AddInt32(&s.n, -1)
+	close(m.feed)
+	return true
+}
+
+// broadcast sends a line to all monitors without blocking. Returns
+// the IDs of monitors which could not keep up.
+func (s *monitorSet) broadcast(line string) (slow []uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for id, m := range s.monitors {
+		select {
+		case m.feed <- line:
+		default:
+			slow = append(slow, id)
+		}
+	}
+	return
+}
+
+func (m *monitor) loop() {
+	for line := range m.feed {
+		line := line
+		if err := m.client.push(func(w resp.ResponseWriter) { w.AppendInlineString(line) }); err != nil {
+			return
+		}
+	}
+}
+
+// --------------------------------------------------------------------
+
+// startMonitor puts the client into MONITOR mode.
+func (srv *Server) startMonitor(c *Client) {
+	if !srv.monitors.add(c) {
+		return
+	}
+	srv.info.monitors.Inc(1)
+	c.onRelease(func() { srv.stopMonitor(c) })
+}
+
+// stopMonitor ends MONITOR mode for the client.
+func (srv *Server) stopMonitor(c *Client) {
+	if srv.monitors.remove(c.ID()) {
+		srv.info.monitors.Inc(-1)
+	}
+}
+
+// feedMonitors sends the command to all monitors, slow
+// monitors are disconnected.
+func (srv *Server) feedMonitors(c *Client, name string, args []resp.CommandArgument) {
+	if !srv.monitors.active() {
+		return
+	}
+
+	for _, id := range srv.monitors.broadcast(monitorLine(time.Now(), c, name, args)) {
+		if mc, ok := srv.info.Client(id); ok {
+			srv.stopMonitor(mc)
+			mc.terminate()
+		}
+	}
+}
+
+// monitorLine formats a command like redis, e.g.:
+//   1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+func monitorLine(t time.Time, c *Client, name string, args []resp.CommandArgument) string {
+	buf := strconv.AppendInt(nil, t.Unix(), 10)
+	buf = append(buf, '.')
+	usec := strconv.AppendInt(nil, int64(t.Nanosecond()/1000), 10)
+	for i := len(usec); i < 6; i++ {
+		buf = append(buf, '0')
+	}
+	buf = append(buf, usec...)
+
+	buf = append(buf, " ["...)
+	buf = strconv.AppendInt(buf, int64(c.DB()), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, c.RemoteAddr().String()...)
+	buf = append(buf, ']')
+
+	buf = append(buf, ' ')
+	buf = appendRepr(buf, []byte(name))
+	secret := redactedArgs(name, args)
+	for i, arg := range args {
+		buf = append(buf, ' ')
+		if i >= secret.start && i < secret.end {
+			buf = append(buf, "(redacted)"...)
+		} else {
+			buf = appendRepr(buf, arg)
+		}
+	}
+	return string(buf)
+}
+
+// argRange is a half-open range of argument indexes.
+type argRange struct{ start, end int }
+
+// redactedArgs returns the arguments of AUTH and HELLO which contain
+// credentials and must not be exposed to monitors.
+func redactedArgs(name string, args []resp.CommandArgument) argRange {
+	switch strings.ToLower(name) {
+	case "auth":
+		return argRange{0, len(args)}
+	case "hello":
+		for i := 1; i < len(args); i++ {
+			if strings.EqualFold(args[i].String(), "auth") {
+				return argRange{i + 1, i + 3}
+			}
+		}
+	}
+	return argRange{}
+}
+
+// appendRepr appends a quoted, escaped representation of s,
+// equivalent to redis' sdscatrepr.
+func appendRepr(dst, s []byte) []byte {
+	const hex = "0123456789abcdef"
+
+	dst = append(dst, '"')
+	for _, c := range s {
+		switch c {
+		case '\\', '"':
+			dst = append(dst, '\\', c)
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		case '\t':
+			dst = append(dst, '\\', 't')
+		case '\a':
+			dst = append(dst, '\\', 'a')
+		case '\b':
+			dst = append(dst, '\\', 'b')
+		default:
+			if c < ' ' || c > '~' {
+				dst = append(dst, '\\', 'x', hex[c>>4], hex[c&0xf])
+			} else {
+				dst = append(dst, c)
+			}
+		}
+	}
+	return append(dst, '"')
+}
+
+// --------------------------------------------------------------------
+
+// monitorCommand returns a MONITOR handler.
+// https://redis.io/commands/monitor
+func monitorCommand(srv *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		cl := GetClient(c.Context())
+		if cl == nil {
+			w.AppendError("ERR MONITOR requires a client connection")
+			return
+		}
+
+		srv.startMonitor(cl)
+		w.AppendOK()
+	})
+}
+
+// resetCommand returns a RESET handler, which ends MONITOR mode,
+// discards open transactions and selects the default database.
+// https://redis.io/commands/reset
+func resetCommand(srv *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		if cl := GetClient(c.Context()); cl != nil {
+			srv.stopMonitor(cl)
+			cl.tx = nil
+			cl.SetDB(0)
+		}
+		w.AppendInlineString("RESET")
+	})
+}
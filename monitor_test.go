@@ -0,0 +1,103 @@
+package redeo
+
+import (
+	"net"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Monitor", func() {
+	var subject *Server
+	var lis net.Listener
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		subject = NewServer(nil)
+		subject.Handle("ping", Ping())
+		subject.HandleMonitor()
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should feed commands to monitors", func() {
+		mcn, mw, mr := dialRaw(lis)
+		defer mcn.Close()
+
+		mw.WriteCmd("MONITOR")
+		Expect(mw.Flush()).To(Succeed())
+		Expect(mr.ReadInlineString()).To(Equal("OK"))
+		Expect(subject.Info().NumMonitors()).To(Equal(1))
+		Expect(subject.Info().String()).To(ContainSubstring("connected_monitors:1\n"))
+
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmdString("PING", "hello \"world\"\n")
+		cw.WriteCmd("UNKNOWN")
+		cw.WriteCmdString("PING")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadBulkString()).To(Equal("hello \"world\"\n"))
+		Expect(cr.ReadError()).To(HavePrefix("ERR unknown command"))
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+
+		addr := cn.LocalAddr().String()
+		Expect(mr.ReadInlineString()).To(MatchRegexp(`^\d+\.\d{6} \[0 \Q` + addr + `\E\] "PING" "hello \\"world\\"\\n"$`))
+		Expect(mr.ReadInlineString()).To(MatchRegexp(`^\d+\.\d{6} \[0 \Q` + addr + `\E\] "PING"$`))
+
+		mw.WriteCmd("RESET")
+		Expect(mw.Flush()).To(Succeed())
+		Expect(mr.ReadInlineString()).To(Equal("RESET"))
+		Expect(subject.Info().NumMonitors()).To(Equal(0))
+	})
+
+	It("should stop monitoring on disconnect", func() {
+		mcn, mw, mr := dialRaw(lis)
+		mw.WriteCmd("MONITOR")
+		Expect(mw.Flush()).To(Succeed())
+		Expect(mr.ReadInlineString()).To(Equal("OK"))
+		Expect(subject.Info().NumMonitors()).To(Equal(1))
+
+		Expect(mcn.Close()).To(Succeed())
+		Eventually(subject.Info().NumMonitors).Should(Equal(0))
+	})
+
+})
+
+var _ = DescribeTable("appendRepr",
+	func(s, exp string) {
+		Expect(string(appendRepr(nil, []byte(s)))).To(Equal(exp))
+	},
+
+	Entry("plain", "key", `"key"`),
+	Entry("quotes", `a "b" \c`, `"a \"b\" \\c"`),
+	Entry("control chars", "\r\n\t\a\b", `"\r\n\t\a\b"`),
+	Entry("binary", "\x00\xff", `"\x00\xff"`),
+)
+
+var _ = DescribeTable("monitorLine",
+	func(name string, args []string, exp string) {
+		cargs := make([]resp.CommandArgument, len(args))
+		for i, arg := range args {
+			cargs[i] = resp.CommandArgument(arg)
+		}
+
+		c := newClient(&mockConn{Port: 10001})
+		line := monitorLine(time.Unix(1515151515, 2000), c, name, cargs)
+		Expect(line).To(Equal(`1515151515.000002 [0 1.2.3.4:10001] ` + exp))
+	},
+
+	Entry("plain", "set", []string{"key", "val"}, `"set" "key" "val"`),
+	Entry("auth", "AUTH", []string{"user", "secret"}, `"AUTH" (redacted) (redacted)`),
+	Entry("hello", "hello", []string{"3", "AUTH", "user", "secret", "SETNAME", "app"}, `"hello" "3" "AUTH" (redacted) (redacted) "SETNAME" "app"`),
+	Entry("hello without auth", "hello", []string{"3"}, `"hello" "3"`),
+)
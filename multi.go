@@ -0,0 +1,320 @@
+package redeo
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// transaction holds the commands queued after MULTI
+type transaction struct {
+	cmds   []*resp.Command
+	failed bool
+}
+
+// queue appends a copy of cmd, as the command buffers are reused
+// between reads.
+func (tx *transaction) queue(cmd *resp.Command) {
+	cp := copyCommand(cmd)
+	cp.SetContext(withTraceParent(context.Background(), cmd))
+	tx.cmds = append(tx.cmds, cp)
+}
+
+// copyCommand returns a copy of cmd which does not share buffers.
+func copyCommand(cmd *resp.Command) *resp.Command {
+	args := make([]resp.CommandArgument, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		args[i] = append(resp.CommandArgument(nil), arg...)
+	}
+	cp := resp.NewCommand(cmd.Name, args...)
+	cp.SetKeySpec(cmd.KeySpec())
+	return cp
+}
+
+// performTx handles MULTI, EXEC, DISCARD, WATCH and UNWATCH and queues all
+// other commands while a transaction is open. Returns false if the command
+// is not subject to transaction handling.
+func (srv *Server) performTx(c *Client, name, norm string) (bool, error) {
+	switch norm {
+	case "multi", "exec", "discard", "watch", "unwatch":
+	default:
+		if c.tx == nil {
+			return false, nil
+		}
+		return true, srv.queueTx(c, name, norm)
+	}
+
+	var err error
+	if c.cmd, err = c.readCmd(c.cmd); err != nil {
+		return true, err
+	}
+	if norm == "watch" {
+		switch {
+		case c.tx != nil:
+			c.wr.AppendError("ERR WATCH inside MULTI is not allowed")
+		case c.cmd.ArgN() == 0:
+			c.wr.AppendError(WrongNumberOfArgs(norm))
+		default:
+			srv.watches.watch(c, c.DB(), c.cmd.Args)
+			c.wr.AppendOK()
+		}
+		return true, nil
+	}
+	if c.cmd.ArgN() != 0 {
+		if c.tx != nil {
+			c.tx.failed = true
+		}
+		c.wr.AppendError(WrongNumberOfArgs(norm))
+		return true, nil
+	}
+
+	switch norm {
+	case "multi":
+		if c.tx != nil {
+			c.wr.AppendError("ERR MULTI calls can not be nested")
+			return true, nil
+		}
+		c.tx = new(transaction)
+		c.wr.AppendOK()
+	case "discard":
+		if c.tx == nil {
+			c.wr.AppendError("ERR DISCARD without MULTI")
+			return true, nil
+		}
+		c.tx = nil
+		srv.watches.unwatch(c)
+		c.wr.AppendOK()
+	case "exec":
+		if c.tx == nil {
+			c.wr.AppendError("ERR EXEC without MULTI")
+			return true, nil
+		}
+		tx := c.tx
+		c.tx = nil
+		srv.exec(c, tx)
+	case "unwatch":
+		if c.tx != nil {
+			// replied to on EXEC, which unwatches anyway
+			c.tx.queue(c.cmd)
+			c.wr.AppendInlineString("QUEUED")
+			return true, nil
+		}
+		srv.watches.unwatch(c)
+		c.wr.AppendOK()
+	}
+	return true, nil
+}
+
+func (srv *Server) queueTx(c *Client, name, norm string) error {
+	entry, ok := srv.lookup(norm)
+
+	if !ok {
+		srv.info.unknown.Inc(1)
+		c.tx.failed = true
+		c.wr.AppendError(UnknownCommand(name))
+		return c.rd.SkipCmd()
+	}
+
+	var err error
+	if c.cmd, err = c.readCmd(c.cmd); err != nil {
+		return err
+	}
+	if msg := entry.spec.check(c.cmd); msg != "" {
+		c.tx.failed = true
+		c.wr.AppendError(msg)
+		return nil
+	}
+	c.tx.queue(c.cmd)
+	c.wr.AppendInlineString("QUEUED")
+	return nil
+}
+
+// exec executes the queued commands, unless the transaction has failed or
+// watched keys were modified. Watched keys are checked and commands are
+// replayed under the transaction lock, which single commands share, see
+// serveCmd.
+func (srv *Server) exec(c *Client, tx *transaction) {
+	srv.txMu.Lock()
+	defer srv.txMu.Unlock()
+
+	modified := srv.watches.unwatch(c)
+	if tx.failed {
+		c.wr.AppendError("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	if modified {
+		c.wr.AppendNil()
+		return
+	}
+
+	c.wr.AppendArrayLen(len(tx.cmds))
+	for _, cmd := range tx.cmds {
+		norm := strings.ToLower(cmd.Name)
+		if norm == "unwatch" {
+			c.wr.AppendOK()
+			continue
+		}
+
+		entry, ok := srv.lookup(norm)
+
+		if !ok {
+			srv.info.unknown.Inc(1)
+			c.wr.AppendError(UnknownCommand(cmd.Name))
+			continue
+		}
+
+		srv.execCmd(c, norm, entry, cmd)
+	}
+}
+
+// serveCmd serves a single command. Unless it is replayed by EXEC, which
+// holds the transaction lock exclusively, it shares the lock while the
+// handler runs, so it cannot interleave with transactions.
+func (srv *Server) serveCmd(h Handler, w resp.ResponseWriter, cmd *resp.Command) {
+	if srv.conf().Transactions {
+		srv.txMu.RLock()
+		defer srv.txMu.RUnlock()
+	}
+	h.ServeRedeo(w, cmd)
+}
+
+// serveStream is like serveCmd, for streaming commands.
+func (srv *Server) serveStream(h StreamHandler, w resp.ResponseWriter, cmd *resp.CommandStream) {
+	if srv.conf().Transactions {
+		srv.txMu.RLock()
+		defer srv.txMu.RUnlock()
+	}
+	h.ServeRedeoStream(w, cmd)
+}
+
+func (srv *Server) execCmd(c *Client, norm string, entry *handlerEntry, cmd *resp.Command) {
+	// register call
+	srv.info.command(c.id, norm)
+	c.cmdName = norm
+
+	// track execution stats, recover from handler panics
+	c.rw.begin()
+	c.args, c.ran = cmd.Args, true
+	defer srv.observe(c, entry, norm, time.Now())
+
+	var end func(error)
+	if srv.conf().Hooks.OnCommandStart != nil {
+		defer func() {
+			if end != nil {
+				end(c.rw.result(nil))
+			}
+		}()
+	}
+	defer srv.recoverPanic(&c.rw, norm)
+
+	if err := srv.authorize(c, norm, cmd.Args); err != nil {
+		c.rw.AppendError(err.Error())
+		return
+	}
+	srv.feedMonitors(c, cmd.Name, cmd.Args)
+	switch handler := entry.served.(type) {
+	case Handler:
+		cmd.SetContext(withTraceParent(c.cmdContext(), cmd))
+		if srv.conf().Hooks.OnCommandStart != nil {
+			end = srv.startCommand(c, cmd, norm, nil)
+		}
+		handler.ServeRedeo(&c.rw, cmd)
+	case StreamHandler:
+		scmd := resp.NewCommandStream(cmd.Name, cmd.Args...)
+		scmd.SetContext(withTraceParent(c.cmdContext(), cmd))
+		if srv.conf().Hooks.OnCommandStart != nil {
+			end = srv.startCommand(c, scmd, norm, nil)
+		}
+		handler.ServeRedeoStream(&c.rw, scmd)
+	case AsyncHandler:
+		cmd.SetContext(withTraceParent(c.cmdContext(), cmd))
+		if srv.conf().Hooks.OnCommandStart != nil {
+			end = srv.startCommand(c, cmd, norm, nil)
+		}
+		srv.execAsync(c, norm, entry, handler, cmd)
+	}
+
+	// blocked commands time out immediately
+	if b := c.blocker; b != nil {
+		c.blocker = nil
+		b.finish(nil, ErrBlockTimeout)
+		c.rw.AppendNil()
+	}
+}
+
+// --------------------------------------------------------------------
+
+// watchKey is a key of a database, see WATCH.
+type watchKey struct {
+	db  int
+	key string
+}
+
+// watchSet holds the keys watched by clients.
+type watchSet struct {
+	keys map[watchKey]map[*Client]struct{}
+	mu   sync.Mutex
+}
+
+// watch adds keys of db to the keys watched by c.
+func (s *watchSet) watch(c *Client, db int, keys []resp.CommandArgument) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keys == nil {
+		s.keys = make(map[watchKey]map[*Client]struct{})
+	}
+	for _, key := range keys {
+		k := watchKey{db: db, key: key.String()}
+		clients, ok := s.keys[k]
+		if !ok {
+			clients = make(map[*Client]struct{})
+			s.keys[k] = clients
+		}
+		if _, ok := clients[c]; !ok {
+			clients[c] = struct{}{}
+			c.watched = append(c.watched, k)
+		}
+	}
+}
+
+// unwatch removes all keys watched by c. Returns true if any of them
+// were modified since they were watched.
+func (s *watchSet) unwatch(c *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range c.watched {
+		clients := s.keys[k]
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(s.keys, k)
+		}
+	}
+
+	modified := c.modified
+	c.watched, c.modified = nil, false
+	return modified
+}
+
+// touch flags clients watching any of the keys of db.
+func (s *watchSet) touch(db int, keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		for c := range s.keys[watchKey{db: db, key: key}] {
+			c.modified = true
+		}
+	}
+}
+
+// Touch marks keys of the database db as modified, so transactions of
+// clients watching them via WATCH are aborted on EXEC. Handlers of
+// commands that modify keys should call it, passing ClientDB as db.
+func (srv *Server) Touch(db int, keys ...string) {
+	srv.watches.touch(db, keys)
+}
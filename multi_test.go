@@ -0,0 +1,278 @@
+package redeo
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transactions", func() {
+	var subject *Server
+	var addr string
+
+	var serve = func(fn func(*resp.RequestWriter, resp.ResponseReader)) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go subject.Serve(lis)
+		addr = lis.Addr().String()
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		fn(resp.NewRequestWriter(cn), resp.NewResponseReader(cn))
+	}
+
+	BeforeEach(func() {
+		data := make(map[string]string)
+
+		subject = NewServer(&Config{Transactions: true})
+		subject.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			if c.ArgN() != 2 {
+				w.AppendError(WrongNumberOfArgs(c.Name))
+				return
+			}
+			data[c.Arg(0).String()] = c.Arg(1).String()
+			subject.Touch(ClientDB(c.Context()), c.Arg(0).String())
+			w.AppendOK()
+		})
+		subject.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			if v, ok := data[c.Arg(0).String()]; ok {
+				w.AppendBulkString(v)
+				return
+			}
+			w.AppendNil()
+		})
+		subject.HandleStreamFunc("len", func(w resp.ResponseWriter, c *resp.CommandStream) {
+			w.AppendInt(int64(c.ArgN()))
+		})
+	})
+
+	It("should queue and execute commands", func() {
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("MULTI")
+			cw.WriteCmdString("SET", "key", "v1")
+			cw.WriteCmdString("GET", "key")
+			cw.WriteCmdString("LEN", "a", "b", "c")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+
+			cw.WriteCmd("EXEC")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadArrayLen()).To(Equal(3))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadBulkString()).To(Equal("v1"))
+			Expect(cr.ReadInt()).To(Equal(int64(3)))
+		})
+	})
+
+	It("should copy queued arguments", func() {
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("MULTI")
+			cw.WriteCmdString("SET", "k1", "aaaa")
+			cw.WriteCmdString("SET", "k2", "bbbb")
+			cw.WriteCmdString("GET", "k1")
+			cw.WriteCmd("EXEC")
+			Expect(cw.Flush()).To(Succeed())
+
+			for i := 0; i < 4; i++ {
+				_, err := cr.ReadInlineString()
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(cr.ReadArrayLen()).To(Equal(3))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadBulkString()).To(Equal("aaaa"))
+		})
+	})
+
+	It("should discard", func() {
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("MULTI")
+			cw.WriteCmdString("SET", "key", "v1")
+			cw.WriteCmd("DISCARD")
+			cw.WriteCmdString("GET", "key")
+			cw.WriteCmd("EXEC")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadNil()).To(Succeed())
+			Expect(cr.ReadError()).To(Equal("ERR EXEC without MULTI"))
+		})
+	})
+
+	It("should abort on unknown commands", func() {
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("MULTI")
+			cw.WriteCmdString("SET", "key", "v1")
+			cw.WriteCmdString("BAD", "cmd")
+			cw.WriteCmd("EXEC")
+			cw.WriteCmdString("GET", "key")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadError()).To(Equal("ERR unknown command 'BAD'"))
+			Expect(cr.ReadError()).To(Equal("EXECABORT Transaction discarded because of previous errors."))
+			Expect(cr.ReadNil()).To(Succeed())
+		})
+	})
+
+	It("should reject nested MULTI calls", func() {
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("MULTI")
+			cw.WriteCmd("MULTI")
+			cw.WriteCmd("EXEC")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadError()).To(Equal("ERR MULTI calls can not be nested"))
+			Expect(cr.ReadArrayLen()).To(Equal(0))
+		})
+	})
+
+	It("should abort when watched keys are modified", func() {
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmdString("WATCH", "k1", "k2")
+			cw.WriteCmdString("SET", "k2", "v1")
+			cw.WriteCmd("MULTI")
+			cw.WriteCmdString("SET", "k1", "v2")
+			cw.WriteCmd("EXEC")
+			cw.WriteCmdString("GET", "k1")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadNil()).To(Succeed())
+			Expect(cr.ReadNil()).To(Succeed())
+
+			// keys are unwatched after EXEC
+			cw.WriteCmd("MULTI")
+			cw.WriteCmdString("SET", "k1", "v2")
+			cw.WriteCmd("EXEC")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadArrayLen()).To(Equal(1))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+		})
+	})
+
+	It("should execute when watched keys are unmodified", func() {
+		subject.Handle("select", Select())
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmdString("WATCH", "k1")
+			cw.WriteCmdString("SET", "k2", "v1")
+			cw.WriteCmdString("SELECT", "1")
+			cw.WriteCmdString("SET", "k1", "v1")
+			cw.WriteCmdString("SELECT", "0")
+			cw.WriteCmd("MULTI")
+			cw.WriteCmdString("GET", "k2")
+			cw.WriteCmd("EXEC")
+			Expect(cw.Flush()).To(Succeed())
+
+			for i := 0; i < 6; i++ {
+				Expect(cr.ReadInlineString()).To(Equal("OK"))
+			}
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadArrayLen()).To(Equal(1))
+			Expect(cr.ReadBulkString()).To(Equal("v1"))
+		})
+	})
+
+	It("should unwatch", func() {
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmdString("WATCH", "k1")
+			cw.WriteCmd("UNWATCH")
+			cw.WriteCmdString("SET", "k1", "v1")
+			cw.WriteCmd("MULTI")
+			cw.WriteCmd("UNWATCH")
+			cw.WriteCmdString("WATCH", "k1")
+			cw.WriteCmdString("GET", "k1")
+			cw.WriteCmd("EXEC")
+			cw.WriteCmd("WATCH")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadError()).To(Equal("ERR WATCH inside MULTI is not allowed"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadArrayLen()).To(Equal(2))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadBulkString()).To(Equal("v1"))
+			Expect(cr.ReadError()).To(Equal("ERR wrong number of arguments for 'watch' command"))
+		})
+		Expect(subject.watches.keys).To(BeEmpty())
+	})
+
+	It("should not interleave with commands of other clients", func() {
+		var noted []string
+		var mu sync.Mutex
+		started := make(chan struct{}, 1)
+		subject.HandleFunc("note", func(w resp.ResponseWriter, c *resp.Command) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			noted = append(noted, c.Arg(0).String())
+			mu.Unlock()
+			w.AppendOK()
+		})
+
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("MULTI")
+			cw.WriteCmdString("NOTE", "a1")
+			cw.WriteCmdString("NOTE", "a2")
+			cw.WriteCmd("EXEC")
+			Expect(cw.Flush()).To(Succeed())
+			Eventually(started).Should(Receive())
+
+			cn, err := net.Dial("tcp", addr)
+			Expect(err).NotTo(HaveOccurred())
+			defer cn.Close()
+			ow, or := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+			ow.WriteCmdString("NOTE", "b")
+			Expect(ow.Flush()).To(Succeed())
+			Expect(or.ReadInlineString()).To(Equal("OK"))
+
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+			Expect(cr.ReadArrayLen()).To(Equal(2))
+		})
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(noted).To(Equal([]string{"a1", "a2", "b"}))
+	})
+
+	It("should be disabled by default", func() {
+		subject.config.Transactions = false
+		serve(func(cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("MULTI")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadError()).To(Equal("ERR unknown command 'MULTI'"))
+		})
+	})
+
+})
@@ -0,0 +1,46 @@
+package redeo
+
+// Namespace registers commands under a common prefix, separated by a
+// dot, e.g. "myapp.stats". Like all command names, namespaced names are
+// matched case-insensitively.
+type Namespace struct {
+	srv    *Server
+	prefix string
+}
+
+// Namespace returns a namespace for registering commands under prefix.
+func (srv *Server) Namespace(prefix string) *Namespace {
+	return &Namespace{srv: srv, prefix: prefix}
+}
+
+// Name returns the full command name for name.
+func (ns *Namespace) Name(name string) string {
+	return ns.prefix + "." + name
+}
+
+// Handle registers a handler for a namespaced command.
+func (ns *Namespace) Handle(name string, h Handler, opts ...HandlerOption) {
+	ns.srv.Handle(ns.Name(name), h, opts...)
+}
+
+// HandleFunc registers a handler func for a namespaced command.
+func (ns *Namespace) HandleFunc(name string, fn HandlerFunc, opts ...HandlerOption) {
+	ns.srv.Handle(ns.Name(name), fn, opts...)
+}
+
+// HandleStream registers a handler for a namespaced streaming command.
+func (ns *Namespace) HandleStream(name string, h StreamHandler, opts ...HandlerOption) {
+	ns.srv.HandleStream(ns.Name(name), h, opts...)
+}
+
+// HandleStreamFunc registers a handler func for a namespaced streaming
+// command.
+func (ns *Namespace) HandleStreamFunc(name string, fn StreamHandlerFunc, opts ...HandlerOption) {
+	ns.srv.HandleStream(ns.Name(name), fn, opts...)
+}
+
+// Unhandle removes a namespaced command. Returns false if no such command
+// was registered.
+func (ns *Namespace) Unhandle(name string) bool {
+	return ns.srv.Unhandle(ns.Name(name))
+}
@@ -0,0 +1,119 @@
+package redeo
+
+import "github.com/wangaoone/redeo/resp"
+
+// HandlerOption configures a command at registration time.
+type HandlerOption func(*commandSpec)
+
+// MinArgs requires at least n arguments, excluding the command name.
+func MinArgs(n int) HandlerOption {
+	return func(s *commandSpec) { s.minArgs = n }
+}
+
+// MaxArgs allows at most n arguments, excluding the command name.
+func MaxArgs(n int) HandlerOption {
+	return func(s *commandSpec) { s.maxArgs = n }
+}
+
+// Arity sets the number of arguments using redis' arity notation, which
+// includes the command name. A positive arity requires exactly that
+// many, a negative arity at least that many arguments.
+// https://redis.io/commands/command#command-arity
+func Arity(n int) HandlerOption {
+	return func(s *commandSpec) {
+		if n < 0 {
+			s.minArgs, s.maxArgs = -n-1, -1
+		} else if n > 0 {
+			s.minArgs, s.maxArgs = n-1, n-1
+		}
+	}
+}
+
+// ValidateArgs registers a check, which is applied to the arguments of
+// commands before the handler is called. An error returned by fn is sent
+// as reply instead. Streamed commands are not validated.
+func ValidateArgs(fn func(c *resp.Command) error) HandlerOption {
+	return func(s *commandSpec) { s.validate = fn }
+}
+
+// Flags sets the command flags, as reported by COMMAND. Commands flagged
+// "write" are rejected while the server is read-only, see Config.ReadOnly,
+// and are propagated to replicas and journals by default.
+// https://redis.io/commands/command#flags
+func Flags(flags ...string) HandlerOption {
+	return func(s *commandSpec) { s.flags = flags }
+}
+
+// Keys sets the key positions, as reported by COMMAND. Handlers can
+// retrieve the keys via resp.Command.Keys, see also Config.KeyRouter.
+// https://redis.io/commands/command#first-key-in-argument-list
+func Keys(first, last, step int) HandlerOption {
+	return func(s *commandSpec) {
+		s.keys = resp.KeySpec{First: first, Last: last, Step: step}
+	}
+}
+
+// --------------------------------------------------------------------
+
+// commandSpec holds the constraints and the description of a command.
+type commandSpec struct {
+	minArgs int
+	maxArgs int // -1 if unlimited
+	flags   []string
+	events  bool // see WithEvents
+
+	validate func(*resp.Command) error // see ValidateArgs
+
+	timeoutError string // see TimeoutError
+	timeoutWait  bool   // see TimeoutWait
+
+	keys resp.KeySpec // see Keys
+
+	resumable bool // see Resumable
+}
+
+func newCommandSpec(opts []HandlerOption) commandSpec {
+	s := commandSpec{maxArgs: -1}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+// validArgs returns true if n arguments satisfy the constraints.
+func (s *commandSpec) validArgs(n int) bool {
+	return n >= s.minArgs && (s.maxArgs < 0 || n <= s.maxArgs)
+}
+
+// check returns the error reply if cmd does not satisfy the constraints,
+// otherwise an empty string. It also applies the key positions to cmd.
+func (s *commandSpec) check(cmd *resp.Command) string {
+	cmd.SetKeySpec(s.keys)
+	if !s.validArgs(cmd.ArgN()) {
+		return WrongNumberOfArgs(cmd.Name)
+	}
+	if s.validate != nil {
+		if err := s.validate(cmd); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// hasFlag returns true if the command was registered with the flag.
+func (s *commandSpec) hasFlag(flag string) bool {
+	for _, f := range s.flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// arity returns the arity in redis' notation.
+func (s *commandSpec) arity() int64 {
+	if s.maxArgs == s.minArgs {
+		return int64(s.minArgs + 1)
+	}
+	return -int64(s.minArgs + 1)
+}
@@ -0,0 +1,268 @@
+package redeo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// proxyBufferLimit is the size above which bulk replies are streamed
+// to the client instead of being buffered.
+const proxyBufferLimit = 32 * 1024
+
+var errProxyProtocol = errors.New("redeo: unexpected upstream reply")
+
+// NewProxyHandler returns a handler which forwards commands to an
+// upstream server and copies the replies back to the client. Upstream
+// connections are created via dial and up to poolSize idle ones are
+// kept for reuse. Broken connections are discarded.
+//
+// Commands are forwarded as they are, connection state, such as the
+// selected database or the authentication status, is not passed on.
+// The handler is safe for concurrent use and may be registered as the
+// fallback for unknown commands via HandleDefault.
+func NewProxyHandler(dial func() (net.Conn, error), poolSize int) Handler {
+	return newProxy(dial, poolSize)
+}
+
+// NewProxyStreamHandler is like NewProxyHandler, but returns a
+// streaming handler, which pipes command arguments to the upstream
+// server without buffering them.
+func NewProxyStreamHandler(dial func() (net.Conn, error), poolSize int) StreamHandler {
+	return newProxy(dial, poolSize)
+}
+
+type proxy struct {
+	dial func() (net.Conn, error)
+	idle chan *proxyConn
+}
+
+type proxyConn struct {
+	net.Conn
+	w   *resp.RequestWriter
+	r   resp.ResponseReader
+	buf []byte // scratch buffer for relayed replies
+}
+
+func newProxy(dial func() (net.Conn, error), poolSize int) *proxy {
+	if poolSize < 0 {
+		poolSize = 0
+	}
+	return &proxy{dial: dial, idle: make(chan *proxyConn, poolSize)}
+}
+
+// ServeRedeo implements Handler.
+func (p *proxy) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	cn, err := p.get()
+	if err != nil {
+		w.AppendError(proxyError(err))
+		return
+	}
+
+	cn.w.WriteMultiBulkSize(c.ArgN() + 1)
+	cn.w.WriteBulkString(c.Name)
+	for _, arg := range c.Args {
+		cn.w.WriteBulk(arg)
+	}
+	p.roundTrip(c.Context(), w, cn)
+}
+
+// ServeRedeoStream implements StreamHandler.
+func (p *proxy) ServeRedeoStream(w resp.ResponseWriter, c *resp.CommandStream) {
+	cn, err := p.get()
+	if err != nil {
+		w.AppendError(proxyError(err))
+		return
+	}
+
+	cn.w.WriteMultiBulkSize(c.ArgN() + 1)
+	cn.w.WriteBulkString(c.Name)
+	for c.More() {
+		rd, err := c.Next()
+		if err != nil {
+			// the upstream has seen an incomplete command
+			_ = cn.Close()
+			w.AppendError(proxyError(err))
+			return
+		}
+		err = cn.w.CopyBulk(rd, rd.Len())
+		_ = rd.Close()
+		if err != nil {
+			_ = cn.Close()
+			w.AppendError(proxyError(err))
+			return
+		}
+	}
+	p.roundTrip(c.Context(), w, cn)
+}
+
+// roundTrip flushes the command written to cn and copies the reply.
+func (p *proxy) roundTrip(ctx context.Context, w resp.ResponseWriter, cn *proxyConn) {
+	if err := cn.w.Flush(); err != nil {
+		_ = cn.Close()
+		w.AppendError(proxyError(err))
+		return
+	}
+
+	var started bool
+	if err := cn.copyReply(w, &started); err != nil {
+		_ = cn.Close()
+		if !started {
+			w.AppendError(proxyError(err))
+			return
+		}
+
+		// the client has seen a partial reply, there is
+		// no way to recover other than disconnecting it
+		if cl := GetClient(ctx); cl != nil {
+			cl.Close()
+		}
+		return
+	}
+	p.put(cn)
+}
+
+// get returns an idle connection or dials a new one.
+func (p *proxy) get() (*proxyConn, error) {
+	select {
+	case cn := <-p.idle:
+		return cn, nil
+	default:
+	}
+
+	cn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{
+		Conn: cn,
+		w:    resp.NewRequestWriter(cn),
+		r:    resp.NewResponseReader(cn),
+	}, nil
+}
+
+// put returns a healthy connection to the pool or closes it if the
+// pool is full.
+func (p *proxy) put(cn *proxyConn) {
+	select {
+	case p.idle <- cn:
+	default:
+		_ = cn.Close()
+	}
+}
+
+// copyReply copies a single, possibly nested, reply from the upstream to
+// w. Status and integer replies are relayed byte-for-byte, bulks are
+// copied via a scratch buffer or streamed. The started flag is set as soon
+// as anything was written to w.
+func (cn *proxyConn) copyReply(w resp.ResponseWriter, started *bool) error {
+	r := cn.r
+	t, err := r.PeekType()
+	if err != nil {
+		return err
+	}
+
+	switch t {
+	case resp.TypeArray, resp.TypeMap, resp.TypeSet, resp.TypePush:
+		var n int
+		if t != resp.TypeMap {
+			n, err = r.ReadArrayLen()
+		} else {
+			n, err = r.ReadMapLen()
+		}
+		if err != nil {
+			return err
+		}
+
+		*started = true
+		switch t {
+		case resp.TypeArray:
+			w.AppendArrayLen(n)
+		case resp.TypeSet:
+			w.AppendSetLen(n)
+		case resp.TypePush:
+			w.AppendPushLen(n)
+		default:
+			w.AppendMapLen(n)
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			if err := cn.copyReply(w, started); err != nil {
+				return err
+			}
+		}
+	case resp.TypeBulk:
+		rd, err := r.StreamBulk()
+		if err != nil {
+			return err
+		}
+		defer rd.Close()
+
+		if rd.Len() > proxyBufferLimit {
+			*started = true
+			return w.CopyBulk(rd, rd.Len())
+		}
+
+		if n := int(rd.Len()); cap(cn.buf) < n {
+			cn.buf = make([]byte, n)
+		}
+		b := cn.buf[:rd.Len()]
+		if _, err := io.ReadFull(rd, b); err != nil {
+			return err
+		}
+		*started = true
+		w.AppendBulk(b)
+	case resp.TypeInline, resp.TypeInt:
+		b, err := r.ReadRaw(cn.buf[:0])
+		if err != nil {
+			return err
+		}
+		cn.buf = b
+		*started = true
+		w.AppendRaw(b)
+	case resp.TypeError:
+		s, err := r.ReadError()
+		if err != nil {
+			return err
+		}
+		*started = true
+		w.AppendError(s)
+	case resp.TypeNil:
+		if err := r.ReadNil(); err != nil {
+			return err
+		}
+		*started = true
+		w.AppendNil()
+	case resp.TypeDouble:
+		f, err := r.ReadDouble()
+		if err != nil {
+			return err
+		}
+		*started = true
+		w.AppendDouble(f)
+	case resp.TypeBool:
+		b, err := r.ReadBool()
+		if err != nil {
+			return err
+		}
+		*started = true
+		w.AppendBool(b)
+	case resp.TypeBigInt:
+		n, err := r.ReadBigInt()
+		if err != nil {
+			return err
+		}
+		*started = true
+		w.AppendBigInt(n)
+	default:
+		return errProxyProtocol
+	}
+	return nil
+}
+
+func proxyError(err error) string {
+	return "ERR upstream: " + err.Error()
+}
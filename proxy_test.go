@@ -0,0 +1,147 @@
+package redeo
+
+import (
+	"net"
+	"strings"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Proxy", func() {
+	var subject, upstream *Server
+	var lis, ulis net.Listener
+
+	var dialUpstream = func() (net.Conn, error) {
+		return net.Dial("tcp", ulis.Addr().String())
+	}
+
+	BeforeEach(func() {
+		var err error
+		ulis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		store := make(map[string]string)
+		upstream = NewServer(nil)
+		upstream.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			store[c.Arg(0).String()] = c.Arg(1).String()
+			w.AppendOK()
+		}, Arity(3))
+		upstream.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			if v, ok := store[c.Arg(0).String()]; ok {
+				w.AppendBulkString(v)
+			} else {
+				w.AppendNil()
+			}
+		}, Arity(2))
+		upstream.HandleFunc("nested", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendArrayLen(4)
+			w.AppendBulkString("a")
+			w.AppendInt(2)
+			w.AppendArrayLen(2)
+			w.AppendError("ERR inner")
+			w.AppendNil()
+			w.AppendInlineString("OK")
+		})
+		go upstream.Serve(ulis)
+
+		subject = NewServer(nil)
+		subject.Handle("ping", Ping())
+		subject.HandleDefault(NewProxyHandler(dialUpstream, 2))
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+		Expect(ulis.Close()).To(Succeed())
+	})
+
+	It("should forward unknown commands", func() {
+		cn, w, r := dialRaw(lis)
+		defer cn.Close()
+
+		big := strings.Repeat("x", 3*proxyBufferLimit)
+		w.WriteCmdString("SET", "key", "value")
+		w.WriteCmdString("GET", "key")
+		w.WriteCmdString("PING")
+		w.WriteCmdString("SET", "big", big)
+		w.WriteCmdString("GET", "big")
+		w.WriteCmdString("GET", "missing")
+		w.WriteCmdString("NESTED")
+		w.WriteCmdString("UNKNOWN")
+		Expect(w.Flush()).To(Succeed())
+
+		Expect(r.ReadInlineString()).To(Equal("OK"))
+		Expect(r.ReadBulkString()).To(Equal("value"))
+		Expect(r.ReadInlineString()).To(Equal("PONG"))
+		Expect(r.ReadInlineString()).To(Equal("OK"))
+		Expect(r.ReadBulkString()).To(Equal(big))
+		Expect(r.ReadNil()).To(Succeed())
+
+		Expect(r.ReadArrayLen()).To(Equal(4))
+		Expect(r.ReadBulkString()).To(Equal("a"))
+		Expect(r.ReadInt()).To(Equal(int64(2)))
+		Expect(r.ReadArrayLen()).To(Equal(2))
+		Expect(r.ReadError()).To(Equal("ERR inner"))
+		Expect(r.ReadNil()).To(Succeed())
+		Expect(r.ReadInlineString()).To(Equal("OK"))
+
+		Expect(r.ReadError()).To(HavePrefix("ERR unknown command 'UNKNOWN'"))
+		Expect(subject.Info().TotalCommands()).To(Equal(int64(8)))
+	})
+
+	It("should forward streaming commands", func() {
+		subject.HandleStream("set", NewProxyStreamHandler(dialUpstream, 1))
+
+		cn, w, r := dialRaw(lis)
+		defer cn.Close()
+
+		big := strings.Repeat("y", 3*proxyBufferLimit)
+		w.WriteCmdString("SET", "key", big)
+		w.WriteCmdString("GET", "key")
+		Expect(w.Flush()).To(Succeed())
+		Expect(r.ReadInlineString()).To(Equal("OK"))
+		Expect(r.ReadBulkString()).To(Equal(big))
+	})
+
+	It("should reply with errors on upstream failures", func() {
+		Expect(ulis.Close()).To(Succeed())
+
+		dead, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		ulis = dead
+		go func() {
+			for {
+				cn, err := dead.Accept()
+				if err != nil {
+					return
+				}
+				cn.Close()
+			}
+		}()
+
+		cn, w, r := dialRaw(lis)
+		defer cn.Close()
+
+		w.WriteCmdString("GET", "key")
+		w.WriteCmdString("PING")
+		Expect(w.Flush()).To(Succeed())
+		Expect(r.ReadError()).To(HavePrefix("ERR upstream: "))
+		Expect(r.ReadInlineString()).To(Equal("PONG"))
+	})
+
+	It("should remove the fallback", func() {
+		subject.HandleDefault(nil)
+
+		cn, w, r := dialRaw(lis)
+		defer cn.Close()
+
+		w.WriteCmdString("GET", "key")
+		Expect(w.Flush()).To(Succeed())
+		Expect(r.ReadError()).To(HavePrefix("ERR unknown command 'GET'"))
+	})
+
+})
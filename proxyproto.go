@@ -0,0 +1,185 @@
+package redeo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errProxyHeader = errors.New("redeo: invalid PROXY protocol header")
+
+// proxyV2Signature starts PROXY protocol v2 headers.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtoConn is a connection accepted from a load balancer, which is
+// prefixed with a PROXY protocol header, see Config.AcceptProxyProtocol.
+// The header is read by handshake, before the connection is used.
+type proxyProtoConn struct {
+	net.Conn
+
+	rd            *bufio.Reader // data buffered while reading the header
+	remote, local net.Addr      // the addresses of the header, if any
+}
+
+// handshake reads the header within timeout.
+func (c *proxyProtoConn) handshake(timeout time.Duration) error {
+	if timeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	c.rd = bufio.NewReader(c.Conn)
+	remote, local, err := readProxyHeader(c.rd)
+	if err != nil {
+		return err
+	}
+	c.remote, c.local = remote, local
+	return nil
+}
+
+// Read implements net.Conn.
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	if c.rd != nil {
+		if c.rd.Buffered() != 0 {
+			return c.rd.Read(p)
+		}
+		c.rd = nil
+	}
+	return c.Conn.Read(p)
+}
+
+// RemoteAddr returns the client address of the header.
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the server address of the header.
+func (c *proxyProtoConn) LocalAddr() net.Addr {
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader reads a v1 or v2 header. The addresses are nil for
+// headers without address information, e.g. health checks.
+func readProxyHeader(rd *bufio.Reader) (remote, local net.Addr, err error) {
+	b, err := rd.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch b[0] {
+	case 'P':
+		return readProxyV1(rd)
+	case '\r':
+		return readProxyV2(rd)
+	}
+	return nil, nil, errProxyHeader
+}
+
+// readProxyV1 reads a header like "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+func readProxyV1(rd *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < 107 { // max header length
+		b, err := rd.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		if line = append(line, b); b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errProxyHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, nil, errProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, nil, errProxyHeader
+	}
+
+	remote, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	local, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return remote, local, nil
+}
+
+func parseProxyAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errProxyHeader
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(n)}, nil
+}
+
+// readProxyV2 reads a binary header.
+func readProxyV2(rd *bufio.Reader) (net.Addr, net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(rd, hdr); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Signature) || hdr[12]>>4 != 2 {
+		return nil, nil, errProxyHeader
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(rd, body); err != nil {
+		return nil, nil, err
+	}
+
+	switch hdr[12] & 0xf {
+	case 0x0: // LOCAL
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, errProxyHeader
+	}
+
+	var size int
+	switch hdr[13] >> 4 {
+	case 0x1: // AF_INET
+		size = net.IPv4len
+	case 0x2: // AF_INET6
+		size = net.IPv6len
+	default: // AF_UNSPEC, AF_UNIX
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, errProxyHeader
+	}
+
+	remote := &net.TCPAddr{
+		IP:   net.IP(body[:size]),
+		Port: int(binary.BigEndian.Uint16(body[2*size:])),
+	}
+	local := &net.TCPAddr{
+		IP:   net.IP(body[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(body[2*size+2:])),
+	}
+	return remote, local, nil
+}
@@ -0,0 +1,91 @@
+package redeo
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PROXY protocol", func() {
+
+	DescribeTable("should read headers",
+		func(hdr, remote, local string) {
+			rd := bufio.NewReader(strings.NewReader(hdr + "rest"))
+			r, l, err := readProxyHeader(rd)
+			Expect(err).NotTo(HaveOccurred())
+			if remote == "" {
+				Expect(r).To(BeNil())
+				Expect(l).To(BeNil())
+			} else {
+				Expect(r.String()).To(Equal(remote))
+				Expect(l.String()).To(Equal(local))
+			}
+			Expect(ioutil.ReadAll(rd)).To(Equal([]byte("rest")))
+		},
+		Entry("v1 TCP4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 6379\r\n", "192.0.2.1:56324", "198.51.100.1:6379"),
+		Entry("v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 6379\r\n", "[2001:db8::1]:56324", "[2001:db8::2]:6379"),
+		Entry("v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", ""),
+		Entry("v2 TCP4", proxyV2(0x21, 0x11, "\xc0\x00\x02\x01\xc6\x33\x64\x01\xdc\x04\x18\xeb"), "192.0.2.1:56324", "198.51.100.1:6379"),
+		Entry("v2 TCP4 with TLVs", proxyV2(0x21, 0x11, "\xc0\x00\x02\x01\xc6\x33\x64\x01\xdc\x04\x18\xeb\x04\x00\x01x"), "192.0.2.1:56324", "198.51.100.1:6379"),
+		Entry("v2 LOCAL", proxyV2(0x20, 0x00, ""), "", ""),
+		Entry("v2 UNSPEC", proxyV2(0x21, 0x00, ""), "", ""),
+	)
+
+	DescribeTable("should reject invalid headers",
+		func(hdr string) {
+			_, _, err := readProxyHeader(bufio.NewReader(strings.NewReader(hdr)))
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("no header", "PING\r\n"),
+		Entry("v1 protocol", "PROXY UDP4 192.0.2.1 198.51.100.1 56324 6379\r\n"),
+		Entry("v1 address", "PROXY TCP4 192.0.2 198.51.100.1 56324 6379\r\n"),
+		Entry("v1 port", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 65536\r\n"),
+		Entry("v1 line ending", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 6379\n"),
+		Entry("v1 too long", "PROXY TCP4 "+strings.Repeat("1", 200)+"\r\n"),
+		Entry("v2 version", proxyV2(0x11, 0x11, "\xc0\x00\x02\x01\xc6\x33\x64\x01\xdc\x04\x18\xeb")),
+		Entry("v2 short", proxyV2(0x21, 0x11, "\xc0\x00\x02\x01")),
+		Entry("v2 truncated", proxyV2(0x21, 0x11, "\xc0\x00\x02\x01\xc6\x33\x64\x01\xdc\x04\x18\xeb")[:20]),
+	)
+
+	It("should report client addresses", func() {
+		subject := NewServer(&Config{AcceptProxyProtocol: true})
+		subject.HandleFunc("whoami", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendBulkString(GetClient(c.Context()).RemoteAddr().String())
+		})
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go subject.Serve(lis)
+		defer subject.Close()
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		_, err = cn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 6379\r\nWHOAMI\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.NewResponseReader(cn).ReadBulkString()).To(Equal("192.0.2.1:56324"))
+		Expect(subject.Info().ClientInfo()[0].RemoteAddr).To(Equal("192.0.2.1:56324"))
+
+		// without header
+		anon, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer anon.Close()
+
+		_, err = anon.Write([]byte("WHOAMI\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = anon.Read(make([]byte, 1))
+		Expect(err).To(HaveOccurred())
+	})
+
+})
+
+func proxyV2(verCmd, fam byte, body string) string {
+	return string(proxyV2Signature) + string([]byte{verCmd, fam, byte(len(body) >> 8), byte(len(body))}) + body
+}
@@ -1,6 +1,8 @@
 package redeo
 
 import (
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -9,27 +11,92 @@ import (
 )
 
 // PubSubBroker can be used to emulate redis'
-// native pub/sub functionality
+// native pub/sub functionality. RESP3 clients receive messages and
+// subscription replies as push messages.
 type PubSubBroker struct {
 	channels map[string]*pubSubChannel
+	patterns map[string]*pubSubChannel
+	clients  map[uint64]*pubSubSubscriber
 	mu       sync.RWMutex
+	nextID   int64
 }
 
 // NewPubSubBroker inits a new pub-sub broker
 func NewPubSubBroker() *PubSubBroker {
 	return &PubSubBroker{
 		channels: make(map[string]*pubSubChannel),
+		patterns: make(map[string]*pubSubChannel),
+		clients:  make(map[uint64]*pubSubSubscriber),
 	}
 }
 
+// InstallTo registers the SUBSCRIBE, PSUBSCRIBE, UNSUBSCRIBE, PUNSUBSCRIBE
+// and PUBLISH handlers on the server. It also installs a middleware which
+// restricts RESP2 clients with active subscriptions to pub/sub commands.
+func (b *PubSubBroker) InstallTo(srv *Server) {
+	srv.Handle("subscribe", b.Subscribe())
+	srv.Handle("psubscribe", b.PSubscribe())
+	srv.Handle("unsubscribe", b.Unsubscribe())
+	srv.Handle("punsubscribe", b.PUnsubscribe())
+	srv.Handle("publish", b.Publish())
+	srv.Use(b.restrict)
+	srv.UseStream(b.restrictStream)
+}
+
 // Subscribe returns a subscribe handler
 func (b *PubSubBroker) Subscribe() Handler {
 	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
-		if c.ArgN() != 1 {
+		if c.ArgN() == 0 {
 			w.AppendError(WrongNumberOfArgs(c.Name))
 			return
 		}
-		b.subscribe(c.Arg(0).String(), w)
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		s := b.subscriber(w, c)
+		for _, arg := range c.Args {
+			name := arg.String()
+			b.add(b.channels, s.channels, name, s)
+			appendSubReply(w, "subscribe", name, s.count())
+		}
+	})
+}
+
+// PSubscribe returns a pattern subscribe handler. Patterns
+// support redis' glob-style syntax.
+func (b *PubSubBroker) PSubscribe() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() == 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		s := b.subscriber(w, c)
+		for _, arg := range c.Args {
+			pattern := arg.String()
+			if ch := b.add(b.patterns, s.patterns, pattern, s); ch.pattern == nil {
+				ch.pattern = CompilePattern(pattern)
+			}
+			appendSubReply(w, "psubscribe", pattern, s.count())
+		}
+	})
+}
+
+// Unsubscribe returns an unsubscribe handler
+func (b *PubSubBroker) Unsubscribe() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		b.unsubscribe(w, c, "unsubscribe", false)
+	})
+}
+
+// PUnsubscribe returns a pattern unsubscribe handler
+func (b *PubSubBroker) PUnsubscribe() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		b.unsubscribe(w, c, "punsubscribe", true)
 	})
 }
 
@@ -47,85 +114,267 @@ func (b *PubSubBroker) Publish() Handler {
 }
 
 // PublishMessage allows to publish a message to the broker
-// outside the command-cycle. Returns the number of subscribers
+// outside the command-cycle. Returns the number of receivers
 func (b *PubSubBroker) PublishMessage(name, msg string) int64 {
-	b.mu.RLock()
-	ch, ok := b.channels[name]
-	b.mu.RUnlock()
-
-	if ok {
-		return ch.Publish(name, msg)
+	type delivery struct {
+		s       *pubSubSubscriber
+		pattern string
 	}
-	return 0
-}
 
-func (b *PubSubBroker) subscribe(name string, w resp.ResponseWriter) {
+	var targets []delivery
 	b.mu.RLock()
-	ch, ok := b.channels[name]
+	if ch, ok := b.channels[name]; ok {
+		for _, s := range ch.subscribers {
+			targets = append(targets, delivery{s: s})
+		}
+	}
+	for pattern, ch := range b.patterns {
+		if ch.pattern.Match(name) {
+			for _, s := range ch.subscribers {
+				targets = append(targets, delivery{s: s, pattern: pattern})
+			}
+		}
+	}
 	b.mu.RUnlock()
 
-	if !ok {
-		b.mu.Lock()
-		if ch, ok = b.channels[name]; !ok {
-			ch = &pubSubChannel{
-				subscribers: make(map[int64]resp.ResponseWriter),
+	var n int64
+	var failed []*pubSubSubscriber
+	for _, t := range targets {
+		pattern := t.pattern
+		err := t.s.push(func(w resp.ResponseWriter) {
+			if pattern != "" {
+				w.AppendPushLen(4)
+				w.AppendBulkString("pmessage")
+				w.AppendBulkString(pattern)
+			} else {
+				w.AppendPushLen(3)
+				w.AppendBulkString("message")
 			}
-			b.channels[name] = ch
+			w.AppendBulkString(name)
+			w.AppendBulkString(msg)
+		})
+
+		if err != nil {
+			failed = append(failed, t.s)
+		} else {
+			n++
+		}
+	}
+
+	if len(failed) != 0 {
+		b.mu.Lock()
+		for _, s := range failed {
+			b.drop(s)
 		}
 		b.mu.Unlock()
 	}
+	return n
+}
 
-	ch.Subscribe(w)
-	w.AppendArrayLen(3)
-	w.AppendBulkString("subscribe")
-	w.AppendBulkString(name)
-	w.AppendInt(1)
+func (b *PubSubBroker) unsubscribe(w resp.ResponseWriter, c *resp.Command, kind string, patterns bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var s *pubSubSubscriber
+	if cl := GetClient(c.Context()); cl != nil {
+		s = b.clients[cl.ID()]
+	}
+
+	index := b.channels
+	var subs map[string]struct{}
+	if patterns {
+		index = b.patterns
+	}
+	if s != nil {
+		subs = s.channels
+		if patterns {
+			subs = s.patterns
+		}
+	}
+
+	names := make([]string, 0, c.ArgN())
+	for _, arg := range c.Args {
+		names = append(names, arg.String())
+	}
+	if len(names) == 0 {
+		for name := range subs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	if len(names) == 0 {
+		w.AppendPushLen(3)
+		w.AppendBulkString(kind)
+		w.AppendNil()
+		w.AppendInt(int64(s.count()))
+		return
+	}
+
+	for _, name := range names {
+		if s != nil {
+			b.remove(index, subs, name, s)
+		}
+		appendSubReply(w, kind, name, s.count())
+	}
 }
 
-// --------------------------------------------------------------------
+// subscriber returns the subscriber for the command's client,
+// must be called with the lock held.
+func (b *PubSubBroker) subscriber(w resp.ResponseWriter, c *resp.Command) *pubSubSubscriber {
+	cl := GetClient(c.Context())
+	if cl != nil {
+		if s, ok := b.clients[cl.ID()]; ok {
+			return s
+		}
+	}
 
-type pubSubChannel struct {
-	subscribers map[int64]resp.ResponseWriter
-	mu          sync.RWMutex
-	nextID      int64
+	s := &pubSubSubscriber{
+		id:       atomic.AddInt64(&b.nextID, 1),
+		client:   cl,
+		w:        w,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	if cl != nil {
+		b.clients[cl.ID()] = s
+		cl.onRelease(func() {
+			b.mu.Lock()
+			b.drop(s)
+			b.mu.Unlock()
+		})
+	}
+	return s
 }
 
-func (c *pubSubChannel) Subscribe(w resp.ResponseWriter) {
-	sid := atomic.AddInt64(&c.nextID, 1)
+// add subscribes s to name, must be called with the lock held.
+func (b *PubSubBroker) add(index map[string]*pubSubChannel, subs map[string]struct{}, name string, s *pubSubSubscriber) *pubSubChannel {
+	ch, ok := index[name]
+	if !ok {
+		ch = &pubSubChannel{
+			subscribers: make(map[int64]*pubSubSubscriber),
+		}
+		index[name] = ch
+	}
+	ch.subscribers[s.id] = s
+	subs[name] = struct{}{}
+	return ch
+}
 
-	c.mu.Lock()
-	c.subscribers[sid] = w
-	c.mu.Unlock()
+// remove unsubscribes s from name, must be called with the lock held.
+func (b *PubSubBroker) remove(index map[string]*pubSubChannel, subs map[string]struct{}, name string, s *pubSubSubscriber) {
+	if ch, ok := index[name]; ok {
+		delete(ch.subscribers, s.id)
+		if len(ch.subscribers) == 0 {
+			delete(index, name)
+		}
+	}
+	delete(subs, name)
 }
 
-func (c *pubSubChannel) Publish(name, msg string) (n int64) {
-	var failed []int64
+// drop removes all subscriptions of s, must be called with the lock held.
+func (b *PubSubBroker) drop(s *pubSubSubscriber) {
+	for name := range s.channels {
+		b.remove(b.channels, s.channels, name, s)
+	}
+	for pattern := range s.patterns {
+		b.remove(b.patterns, s.patterns, pattern, s)
+	}
+	if s.client != nil {
+		delete(b.clients, s.client.ID())
+	}
+}
 
-	c.mu.RLock()
-	for sid, w := range c.subscribers {
-		w.AppendArrayLen(3)
-		w.AppendBulkString("message")
-		w.AppendBulkString(name)
-		w.AppendBulkString(msg)
+// subscribed returns true if the client is in subscribed mode.
+func (b *PubSubBroker) subscribed(cl *Client) bool {
+	if cl == nil || cl.Protocol() == resp.RESP3 {
+		return false
+	}
 
-		if err := w.Flush(); err != nil {
-			failed = append(failed, sid)
-		} else {
-			n++
+	b.mu.RLock()
+	s, ok := b.clients[cl.ID()]
+	n := 0
+	if ok {
+		n = s.count()
+	}
+	b.mu.RUnlock()
+	return n != 0
+}
+
+func (b *PubSubBroker) restrict(next Handler) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if name := CommandName(c.Context()); !pubSubAllowed(name) && b.subscribed(GetClient(c.Context())) {
+			w.AppendError(pubSubRestricted(c.Name))
+			return
+		}
+		next.ServeRedeo(w, c)
+	})
+}
+
+func (b *PubSubBroker) restrictStream(next StreamHandler) StreamHandler {
+	return StreamHandlerFunc(func(w resp.ResponseWriter, c *resp.CommandStream) {
+		if name := CommandName(c.Context()); !pubSubAllowed(name) && b.subscribed(GetClient(c.Context())) {
+			w.AppendError(pubSubRestricted(c.Name))
+			return
 		}
+		next.ServeRedeoStream(w, c)
+	})
+}
+
+func pubSubAllowed(name string) bool {
+	switch name {
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "ping", "quit":
+		return true
 	}
-	c.mu.RUnlock()
+	return false
+}
 
-	if len(failed) != 0 {
-		c.evict(failed)
+func pubSubRestricted(name string) string {
+	return "ERR Can't execute '" + strings.ToLower(name) + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"
+}
+
+func appendSubReply(w resp.ResponseWriter, kind, name string, n int) {
+	w.AppendPushLen(3)
+	w.AppendBulkString(kind)
+	w.AppendBulkString(name)
+	w.AppendInt(int64(n))
+}
+
+// --------------------------------------------------------------------
+
+type pubSubChannel struct {
+	subscribers map[int64]*pubSubSubscriber
+	pattern     *Pattern // compiled, for pattern subscriptions
+}
+
+type pubSubSubscriber struct {
+	id     int64
+	client *Client // nil, unless subscribed via a server connection
+	w      resp.ResponseWriter
+	mu     sync.Mutex
+
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// count returns the number of subscriptions, must be called with
+// the broker lock held.
+func (s *pubSubSubscriber) count() int {
+	if s == nil {
+		return 0
 	}
-	return
+	return len(s.channels) + len(s.patterns)
 }
 
-func (c *pubSubChannel) evict(failed []int64) {
-	c.mu.Lock()
-	for _, sid := range failed {
-		delete(c.subscribers, sid)
+// push writes a message to the subscriber.
+func (s *pubSubSubscriber) push(fn func(resp.ResponseWriter)) error {
+	if s.client != nil {
+		return s.client.push(fn)
 	}
-	c.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(s.w)
+	return s.w.Flush()
 }
@@ -1,6 +1,9 @@
 package redeo
 
 import (
+	"net"
+	"strconv"
+
 	"github.com/wangaoone/redeo/redeotest"
 	"github.com/wangaoone/redeo/resp"
 	. "github.com/onsi/ginkgo"
@@ -63,4 +66,210 @@ var _ = Describe("PubSubBroker", func() {
 		}))
 	})
 
+	Describe("server", func() {
+		var srv *Server
+		var lis net.Listener
+
+		type conn struct {
+			net.Conn
+			w *resp.RequestWriter
+			r resp.ResponseReader
+		}
+
+		var dial = func() *conn {
+			cn, err := net.Dial("tcp", lis.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			return &conn{Conn: cn, w: resp.NewRequestWriter(cn), r: resp.NewResponseReader(cn)}
+		}
+
+		var readStrings = func(r resp.ResponseReader) []string {
+			n, err := r.ReadArrayLen()
+			Expect(err).NotTo(HaveOccurred())
+
+			vv := make([]string, 0, n)
+			for i := 0; i < n; i++ {
+				t, err := r.PeekType()
+				Expect(err).NotTo(HaveOccurred())
+				switch t {
+				case resp.TypeInt:
+					v, err := r.ReadInt()
+					Expect(err).NotTo(HaveOccurred())
+					vv = append(vv, strconv.FormatInt(v, 10))
+				case resp.TypeNil:
+					Expect(r.ReadNil()).To(Succeed())
+					vv = append(vv, "")
+				default:
+					v, err := r.ReadBulkString()
+					Expect(err).NotTo(HaveOccurred())
+					vv = append(vv, v)
+				}
+			}
+			return vv
+		}
+
+		var numClients = func() int {
+			subject.mu.RLock()
+			defer subject.mu.RUnlock()
+			return len(subject.clients)
+		}
+
+		BeforeEach(func() {
+			var err error
+			lis, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			srv = NewServer(nil)
+			srv.Handle("ping", Ping())
+			srv.Handle("echo", Echo())
+			subject.InstallTo(srv)
+			go srv.Serve(lis)
+		})
+
+		AfterEach(func() {
+			Expect(lis.Close()).To(Succeed())
+		})
+
+		It("should publish keyspace notifications", func() {
+			srv.OnKeyspaceEvent(subject.NotifyKeyspaceEvent)
+			srv.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+				srv.NotifyKeyspaceEvent(ClientDB(c.Context()), "set", c.Arg(0).String())
+				w.AppendOK()
+			})
+
+			sub, pub := dial(), dial()
+			defer sub.Close()
+			defer pub.Close()
+
+			sub.w.WriteCmdString("SUBSCRIBE", "__keyspace@0__:foo")
+			sub.w.WriteCmdString("PSUBSCRIBE", "__keyevent@*__:set")
+			Expect(sub.w.Flush()).To(Succeed())
+			Expect(readStrings(sub.r)).To(Equal([]string{"subscribe", "__keyspace@0__:foo", "1"}))
+			Expect(readStrings(sub.r)).To(Equal([]string{"psubscribe", "__keyevent@*__:set", "2"}))
+
+			pub.w.WriteCmdString("SET", "foo", "bar")
+			Expect(pub.w.Flush()).To(Succeed())
+			Expect(pub.r.ReadInlineString()).To(Equal("OK"))
+
+			Expect(readStrings(sub.r)).To(Equal([]string{"message", "__keyspace@0__:foo", "set"}))
+			Expect(readStrings(sub.r)).To(Equal([]string{"pmessage", "__keyevent@*__:set", "__keyevent@0__:set", "foo"}))
+		})
+
+		It("should push messages to subscribers", func() {
+			sub, pub := dial(), dial()
+			defer sub.Close()
+			defer pub.Close()
+
+			sub.w.WriteCmdString("SUBSCRIBE", "foo", "bar")
+			sub.w.WriteCmdString("PSUBSCRIBE", "f[aeiou]?")
+			Expect(sub.w.Flush()).To(Succeed())
+			Expect(readStrings(sub.r)).To(Equal([]string{"subscribe", "foo", "1"}))
+			Expect(readStrings(sub.r)).To(Equal([]string{"subscribe", "bar", "2"}))
+			Expect(readStrings(sub.r)).To(Equal([]string{"psubscribe", "f[aeiou]?", "3"}))
+
+			pub.w.WriteCmdString("PUBLISH", "foo", "msg1")
+			pub.w.WriteCmdString("PUBLISH", "baz", "msg2")
+			pub.w.WriteCmdString("PUBLISH", "bar", "msg3")
+			Expect(pub.w.Flush()).To(Succeed())
+			Expect(pub.r.ReadInt()).To(Equal(int64(2)))
+			Expect(pub.r.ReadInt()).To(Equal(int64(0)))
+			Expect(pub.r.ReadInt()).To(Equal(int64(1)))
+
+			msgs := [][]string{readStrings(sub.r), readStrings(sub.r), readStrings(sub.r)}
+			Expect(msgs).To(ConsistOf(
+				[]string{"message", "foo", "msg1"},
+				[]string{"pmessage", "f[aeiou]?", "foo", "msg1"},
+				[]string{"message", "bar", "msg3"},
+			))
+		})
+
+		It("should push messages to RESP3 clients", func() {
+			srv.Handle("hello", Hello())
+			sub, pub := dial(), dial()
+			defer sub.Close()
+			defer pub.Close()
+
+			sub.w.WriteCmdString("HELLO", "3")
+			sub.w.WriteCmdString("SUBSCRIBE", "foo")
+			Expect(sub.w.Flush()).To(Succeed())
+			_, err := resp.ReadReply(sub.r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sub.r.PeekType()).To(Equal(resp.TypePush))
+			Expect(readStrings(sub.r)).To(Equal([]string{"subscribe", "foo", "1"}))
+
+			pub.w.WriteCmdString("PUBLISH", "foo", "msg")
+			Expect(pub.w.Flush()).To(Succeed())
+			Expect(pub.r.ReadInt()).To(Equal(int64(1)))
+			Expect(sub.r.PeekType()).To(Equal(resp.TypePush))
+			Expect(readStrings(sub.r)).To(Equal([]string{"message", "foo", "msg"}))
+		})
+
+		It("should not restrict subscribed RESP3 clients", func() {
+			srv.Handle("hello", Hello())
+			sub, pub := dial(), dial()
+			defer sub.Close()
+			defer pub.Close()
+
+			sub.w.WriteCmdString("HELLO", "3")
+			sub.w.WriteCmdString("SUBSCRIBE", "foo")
+			sub.w.WriteCmdString("ECHO", "x")
+			Expect(sub.w.Flush()).To(Succeed())
+			_, err := resp.ReadReply(sub.r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readStrings(sub.r)).To(Equal([]string{"subscribe", "foo", "1"}))
+			Expect(sub.r.ReadBulkString()).To(Equal("x"))
+
+			// messages are interleaved with regular replies
+			pub.w.WriteCmdString("PUBLISH", "foo", "msg")
+			Expect(pub.w.Flush()).To(Succeed())
+			Expect(pub.r.ReadInt()).To(Equal(int64(1)))
+			Expect(readStrings(sub.r)).To(Equal([]string{"message", "foo", "msg"}))
+
+			sub.w.WriteCmdString("ECHO", "y")
+			Expect(sub.w.Flush()).To(Succeed())
+			Expect(sub.r.ReadBulkString()).To(Equal("y"))
+		})
+
+		It("should restrict subscribed clients", func() {
+			sub := dial()
+			defer sub.Close()
+
+			sub.w.WriteCmdString("SUBSCRIBE", "foo")
+			sub.w.WriteCmdString("ECHO", "x")
+			sub.w.WriteCmdString("UNSUBSCRIBE")
+			sub.w.WriteCmdString("ECHO", "y")
+			sub.w.WriteCmdString("UNSUBSCRIBE")
+			Expect(sub.w.Flush()).To(Succeed())
+
+			Expect(readStrings(sub.r)).To(Equal([]string{"subscribe", "foo", "1"}))
+			Expect(sub.r.ReadError()).To(Equal("ERR Can't execute 'echo': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"))
+			Expect(readStrings(sub.r)).To(Equal([]string{"unsubscribe", "foo", "0"}))
+			Expect(sub.r.ReadBulkString()).To(Equal("y"))
+			Expect(readStrings(sub.r)).To(Equal([]string{"unsubscribe", "", "0"}))
+		})
+
+		It("should remove subscriptions on disconnect", func() {
+			sub, pub := dial(), dial()
+			defer pub.Close()
+
+			sub.w.WriteCmdString("SUBSCRIBE", "foo")
+			sub.w.WriteCmdString("PSUBSCRIBE", "*")
+			Expect(sub.w.Flush()).To(Succeed())
+			Expect(readStrings(sub.r)).To(HaveLen(3))
+			Expect(readStrings(sub.r)).To(HaveLen(3))
+			Expect(numClients()).To(Equal(1))
+
+			Expect(sub.Close()).To(Succeed())
+			Eventually(numClients).Should(Equal(0))
+
+			pub.w.WriteCmdString("PUBLISH", "foo", "msg")
+			Expect(pub.w.Flush()).To(Succeed())
+			Expect(pub.r.ReadInt()).To(Equal(int64(0)))
+
+			subject.mu.RLock()
+			defer subject.mu.RUnlock()
+			Expect(subject.channels).To(BeEmpty())
+			Expect(subject.patterns).To(BeEmpty())
+		})
+	})
+
 })
@@ -1,8 +1,13 @@
 package redeo
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	//"github.com/bsm/redeo/resp"
 	"github.com/wangaoone/redeo/resp"
@@ -34,7 +39,7 @@ func Ping() Handler {
 	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
 		switch c.ArgN() {
 		case 0:
-			w.AppendInlineString("PONG")
+			resp.StaticPONG.AppendTo(w)
 		case 1:
 			w.AppendBulk(c.Arg(0))
 		default:
@@ -56,18 +61,179 @@ func Echo() Handler {
 	})
 }
 
-// Info returns an info handler.
+// Quit returns a quit handler. The connection is closed once
+// the reply has been written.
+// https://redis.io/commands/quit
+func Quit() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if client := GetClient(c.Context()); client != nil {
+			client.Close()
+		}
+		w.AppendOK()
+	})
+}
+
+// Select returns a select handler, which accepts database indexes 0-15.
+// The selected index is recorded on the client, see ClientDB. Servers
+// register a handler which respects Config.Databases via HandleDefaults.
+// https://redis.io/commands/select
+func Select() Handler {
+	return selectCommand(func() int { return defaultDatabases })
+}
+
+func selectCommand(databases func() int) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		db, err := c.Arg(0).Int()
+		if err != nil {
+			w.AppendError("ERR value is not an integer or out of range")
+			return
+		} else if db < 0 || db >= int64(databases()) {
+			w.AppendError("ERR DB index is out of range")
+			return
+		}
+
+		if client := GetClient(c.Context()); client != nil {
+			client.SetDB(int(db))
+		}
+		w.AppendOK()
+	})
+}
+
+// swapDBCommand returns a SWAPDB handler.
+// https://redis.io/commands/swapdb
+func swapDBCommand(srv *Server, fn func(a, b int) error) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 2 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		a, err := c.Arg(0).Int()
+		if err != nil {
+			w.AppendError("ERR invalid first DB index")
+			return
+		}
+		b, err := c.Arg(1).Int()
+		if err != nil {
+			w.AppendError("ERR invalid second DB index")
+			return
+		}
+		if n := int64(srv.databases()); a < 0 || a >= n || b < 0 || b >= n {
+			w.AppendError("ERR DB index is out of range")
+			return
+		}
+
+		if err := fn(int(a), int(b)); err != nil {
+			_ = w.Append(err)
+			return
+		}
+		w.AppendOK()
+	})
+}
+
+// shutdownCommand returns a SHUTDOWN handler.
+// https://redis.io/commands/shutdown
+func shutdownCommand(srv *Server, fn func(c *resp.Command) error, timeout time.Duration) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if fn != nil {
+			if err := fn(c); err != nil {
+				_ = w.Append(err)
+				return
+			}
+		}
+
+		// like redis, the calling client is disconnected without a reply
+		if client := GetClient(c.Context()); client != nil {
+			client.kill()
+		}
+		go func() {
+			ctx := context.Background()
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			_ = srv.Shutdown(ctx)
+		}()
+	})
+}
+
+// Info returns an info handler. It accepts optional section names, the
+// special "all", "default" and "everything" sections include all sections.
+// Unknown sections are ignored.
 // https://redis.io/commands/info
 func Info(s *Server) Handler {
 	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
 		info := s.Info()
-		resp := ""
-		if c.ArgN() == 1 {
-			resp = info.Find(c.Args[0].String()).String()
-		} else {
-			resp = info.String()
+		if c.ArgN() == 0 {
+			w.AppendBulkString(infoString(info.String()))
+			return
+		}
+
+		var sections []string
+		for _, arg := range c.Args {
+			name := arg.String()
+			switch strings.ToLower(name) {
+			case "all", "default", "everything":
+				w.AppendBulkString(infoString(info.String()))
+				return
+			}
+			if str := info.Find(name).String(); str != "" {
+				sections = append(sections, str)
+			}
+		}
+		w.AppendBulkString(infoString(strings.Join(sections, "\n")))
+	})
+}
+
+// infoString converts line endings to CRLF, as expected by redis clients.
+func infoString(s string) string {
+	return strings.Replace(s, "\n", "\r\n", -1)
+}
+
+// Hello returns a handler which negotiates the protocol version
+// of a connection. Clients which never send HELLO stay on RESP2.
+// https://redis.io/commands/hello
+func Hello() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		proto := w.Protocol()
+		if c.ArgN() != 0 {
+			v, err := strconv.Atoi(c.Arg(0).String())
+			if err != nil {
+				w.AppendError("ERR Protocol version is not an integer or out of range")
+				return
+			}
+			if v != resp.RESP2 && v != resp.RESP3 {
+				w.AppendError("NOPROTO unsupported protocol version")
+				return
+			}
+			proto = v
+		}
+		w.SetProtocol(proto)
+
+		var id int64
+		if cl := GetClient(c.Context()); cl != nil {
+			id = int64(cl.ID())
 		}
-		w.AppendBulkString(resp)
+
+		w.AppendMapLen(6)
+		w.AppendBulkString("server")
+		w.AppendBulkString("redeo")
+		w.AppendBulkString("proto")
+		w.AppendInt(int64(proto))
+		w.AppendBulkString("id")
+		w.AppendInt(id)
+		w.AppendBulkString("mode")
+		w.AppendBulkString("standalone")
+		w.AppendBulkString("role")
+		w.AppendBulkString("master")
+		w.AppendBulkString("modules")
+		w.AppendArrayLen(0)
 	})
 }
 
@@ -79,22 +245,273 @@ func (s CommandDescriptions) ServeRedeo(w resp.ResponseWriter, c *resp.Command)
 	w.AppendArrayLen(len(s))
 
 	for _, cmd := range s {
-		w.AppendArrayLen(6)
-		w.AppendBulkString(strings.ToLower(cmd.Name))
-		w.AppendInt(cmd.Arity)
-		w.AppendArrayLen(len(cmd.Flags))
-		for _, flag := range cmd.Flags {
-			w.AppendBulkString(flag)
+		cmd.appendTo(w)
+	}
+}
+
+// commandIntrospection returns a command handler which describes
+// the commands registered on the server.
+// https://redis.io/commands/command
+func commandIntrospection(s *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() == 0 {
+			CommandDescriptions(s.commandDescriptions()).ServeRedeo(w, c)
+			return
+		}
+
+		switch sub := c.Arg(0).String(); strings.ToLower(sub) {
+		case "count":
+			if c.ArgN() != 1 {
+				w.AppendError(WrongNumberOfArgs(c.Name + " " + sub))
+				return
+			}
+			w.AppendInt(int64(len(s.commandDescriptions())))
+		case "info":
+			w.AppendArrayLen(c.ArgN() - 1)
+			for _, arg := range c.Args[1:] {
+				if cmd, ok := s.commandDescription(arg.String()); ok {
+					cmd.appendTo(w)
+				} else {
+					w.AppendNil()
+				}
+			}
+		case "list":
+			if c.ArgN() != 1 {
+				w.AppendError(WrongNumberOfArgs(c.Name + " " + sub))
+				return
+			}
+			descs := s.commandDescriptions()
+			w.AppendArrayLen(len(descs))
+			for _, cmd := range descs {
+				w.AppendBulkString(cmd.Name)
+			}
+		case "docs":
+			names := make([]string, 0, c.ArgN()-1)
+			for _, arg := range c.Args[1:] {
+				if cmd, ok := s.commandDescription(arg.String()); ok {
+					names = append(names, cmd.Name)
+				}
+			}
+			if c.ArgN() == 1 {
+				for _, cmd := range s.commandDescriptions() {
+					names = append(names, cmd.Name)
+				}
+			}
+			w.AppendMapLen(len(names))
+			for _, name := range names {
+				w.AppendBulkString(name)
+				w.AppendMapLen(0)
+			}
+		case "getkeys":
+			if c.ArgN() < 2 {
+				w.AppendError(WrongNumberOfArgs(c.Name + " " + sub))
+				return
+			}
+			cmd, ok := s.commandDescription(c.Arg(1).String())
+			if !ok {
+				w.AppendError("ERR Invalid command specified")
+				return
+			}
+			if args := c.Args[2:]; !cmd.validArgs(len(args)) {
+				w.AppendError("ERR Invalid number of arguments specified for command")
+			} else if keys := cmd.keys(args); len(keys) == 0 {
+				w.AppendError("ERR The command has no key arguments")
+			} else {
+				w.AppendArrayLen(len(keys))
+				for _, key := range keys {
+					w.AppendBulk(key)
+				}
+			}
+		default:
+			w.AppendError("ERR Unknown " + strings.ToLower(c.Name) + " subcommand '" + sub + "'")
+		}
+	})
+}
+
+// clientCommand returns a handler which manages client connections.
+// https://redis.io/commands/client-list
+func clientCommand(s *Server) Handler {
+	sc := NewSubCommands()
+	sc.HandleFunc("list", func(w resp.ResponseWriter, c *resp.Command) {
+		clientList(s, w, c)
+	})
+	sc.HandleFunc("info", func(w resp.ResponseWriter, c *resp.Command) {
+		client := GetClient(c.Context())
+		for _, info := range s.Info().ClientInfo() {
+			if client != nil && info.ID == client.ID() {
+				w.AppendBulkString(info.String() + "\n")
+				return
+			}
+		}
+		w.AppendNil()
+	}, MaxArgs(0))
+	sc.HandleFunc("id", func(w resp.ResponseWriter, c *resp.Command) {
+		client := GetClient(c.Context())
+		if client == nil {
+			w.AppendNil()
+			return
+		}
+		w.AppendInt(int64(client.ID()))
+	})
+	sc.HandleFunc("getname", func(w resp.ResponseWriter, c *resp.Command) {
+		client := GetClient(c.Context())
+		if client == nil || client.Name() == "" {
+			w.AppendNil()
+			return
+		}
+		w.AppendBulkString(client.Name())
+	})
+	sc.HandleFunc("setname", func(w resp.ResponseWriter, c *resp.Command) {
+		name := c.Arg(0).String()
+		if strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r > '~' }) != -1 {
+			w.AppendError("ERR Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		if client := GetClient(c.Context()); client != nil {
+			client.SetName(name)
+		}
+		w.AppendOK()
+	}, Arity(2))
+	sc.HandleFunc("kill", func(w resp.ResponseWriter, c *resp.Command) {
+		clientKill(s, GetClient(c.Context()), w, c)
+	})
+	sc.HandleFunc("tracking", func(w resp.ResponseWriter, c *resp.Command) {
+		clientTracking(s, GetClient(c.Context()), w, c)
+	}, MinArgs(1))
+	return sc
+}
+
+// clientList implements CLIENT LIST [ID id [id ...]].
+func clientList(s *Server, w resp.ResponseWriter, c *resp.Command) {
+	var ids map[uint64]bool
+	if c.ArgN() > 0 {
+		if c.ArgN() == 1 || !strings.EqualFold(c.Arg(0).String(), "id") {
+			w.AppendError("ERR syntax error")
+			return
+		}
+
+		ids = make(map[uint64]bool, c.ArgN()-1)
+		for _, arg := range c.Args[1:] {
+			n, err := strconv.ParseUint(arg.String(), 10, 64)
+			if err != nil || n == 0 {
+				w.AppendError("ERR Invalid client ID")
+				return
+			}
+			ids[n] = true
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	for _, info := range s.Info().ClientInfo() {
+		if ids != nil && !ids[info.ID] {
+			continue
+		}
+		buf.WriteString(info.String())
+		buf.WriteByte('\n')
+	}
+	w.AppendBulk(buf.Bytes())
+}
+
+// clientKill implements CLIENT KILL addr and
+// CLIENT KILL [ID id] [ADDR addr] [SKIPME yes/no].
+func clientKill(s *Server, self *Client, w resp.ResponseWriter, c *resp.Command) {
+	// old style: CLIENT KILL addr
+	if c.ArgN() == 1 {
+		addr := c.Arg(0).String()
+		for _, info := range s.Info().ClientInfo() {
+			if info.RemoteAddr == addr && s.CloseClient(info.ID) {
+				w.AppendOK()
+				return
+			}
+		}
+		w.AppendError("ERR No such client")
+		return
+	}
+
+	if c.ArgN() < 2 || c.ArgN()%2 != 0 {
+		w.AppendError("ERR syntax error")
+		return
+	}
+
+	var id uint64
+	var addr string
+	skipMe := true
+	for i := 0; i < c.ArgN(); i += 2 {
+		switch strings.ToLower(c.Arg(i).String()) {
+		case "id":
+			n, err := strconv.ParseUint(c.Arg(i+1).String(), 10, 64)
+			if err != nil || n == 0 {
+				w.AppendError("ERR client-id should be greater than 0")
+				return
+			}
+			id = n
+		case "addr":
+			addr = c.Arg(i + 1).String()
+		case "skipme":
+			switch strings.ToLower(c.Arg(i + 1).String()) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				w.AppendError("ERR syntax error")
+				return
+			}
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+	}
+
+	var n int64
+	for _, info := range s.Info().ClientInfo() {
+		if id != 0 && info.ID != id {
+			continue
+		}
+		if addr != "" && info.RemoteAddr != addr {
+			continue
+		}
+		if skipMe && self != nil && info.ID == self.ID() {
+			continue
+		}
+		if s.CloseClient(info.ID) {
+			n++
 		}
-		w.AppendInt(cmd.FirstKey)
-		w.AppendInt(cmd.LastKey)
-		w.AppendInt(cmd.KeyStepCount)
 	}
+	w.AppendInt(n)
 }
 
-// SubCommands returns a handler that is parsing sub-commands
+// SubCommands returns a handler that is parsing sub-commands. Sub-commands
+// are matched case-insensitively against the first argument, the handlers
+// receive the remaining arguments. Unless registered explicitly, a HELP
+// sub-command lists the available ones.
 type SubCommands map[string]Handler
 
+// NewSubCommands inits an empty set of sub-commands.
+func NewSubCommands() SubCommands { return make(SubCommands) }
+
+// Handle registers a handler for a sub-command. Options may declare
+// constraints on the remaining arguments, such as MinArgs, which are
+// checked before the handler is called.
+func (s SubCommands) Handle(name string, h Handler, opts ...HandlerOption) {
+	if len(opts) != 0 {
+		spec, next := newCommandSpec(opts), h
+		h = HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+			if msg := spec.check(c); msg != "" {
+				w.AppendError(msg)
+				return
+			}
+			next.ServeRedeo(w, c)
+		})
+	}
+	s[strings.ToLower(name)] = h
+}
+
+// HandleFunc registers a handler func for a sub-command.
+func (s SubCommands) HandleFunc(name string, fn HandlerFunc, opts ...HandlerOption) {
+	s.Handle(name, fn, opts...)
+}
+
 func (s SubCommands) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
 
 	// First, check if we have a subcommand
@@ -104,15 +521,54 @@ func (s SubCommands) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
 	}
 
 	firstArg := c.Arg(0).String()
-	if h, ok := s[strings.ToLower(firstArg)]; ok {
+	sub := strings.ToLower(firstArg)
+	if h, ok := s[sub]; ok {
 		cmd := resp.NewCommand(c.Name+" "+firstArg, c.Args[1:]...)
 		cmd.SetContext(c.Context())
 		h.ServeRedeo(w, cmd)
 		return
 	}
+	if sub == "help" && c.ArgN() == 1 {
+		s.help(w, c.Name)
+		return
+	}
+
+	w.AppendError("ERR Unknown " + strings.ToUpper(c.Name) + " subcommand or wrong number of arguments for '" + firstArg + "'")
+}
 
-	w.AppendError("ERR Unknown " + strings.ToLower(c.Name) + " subcommand '" + firstArg + "'")
+func (s SubCommands) help(w resp.ResponseWriter, name string) {
+	name = strings.ToUpper(name)
 
+	subs := make([]string, 0, len(s)+1)
+	for sub := range s {
+		subs = append(subs, strings.ToUpper(sub))
+	}
+	subs = append(subs, "HELP")
+	sort.Strings(subs)
+
+	w.AppendArrayLen(len(subs) + 1)
+	w.AppendInlineString(name + " <subcommand> [<arg> ...]. Subcommands are:")
+	for _, sub := range subs {
+		w.AppendInlineString(sub)
+	}
+}
+
+// --------------------------------------------------------------------
+
+// DBs is a handler that routes commands to the handler registered
+// for the database selected by the client, see ClientDB. This allows
+// each database to be served by a separate backend, e.g. by using it as
+// the Fallback handler. Commands are rejected if no handler is
+// registered for the selected index.
+type DBs []Handler
+
+func (s DBs) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	db := ClientDB(c.Context())
+	if db < 0 || db >= len(s) || s[db] == nil {
+		w.AppendError("ERR DB index is out of range")
+		return
+	}
+	s[db].ServeRedeo(w, c)
 }
 
 // --------------------------------------------------------------------
@@ -129,6 +585,16 @@ type HandlerFunc func(w resp.ResponseWriter, c *resp.Command)
 // ServeRedeo calls f(w, c).
 func (f HandlerFunc) ServeRedeo(w resp.ResponseWriter, c *resp.Command) { f(w, c) }
 
+// ContextHandlerFunc is a context-aware callback function, implementing
+// Handler. The context includes values attached by Config.Hooks and is
+// cancelled once the client is closed or disconnected.
+type ContextHandlerFunc func(ctx context.Context, w resp.ResponseWriter, c *resp.Command)
+
+// ServeRedeo calls f(c.Context(), w, c).
+func (f ContextHandlerFunc) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	f(c.Context(), w, c)
+}
+
 // WrapperFunc implements Handler, accepts a command and must return one of
 // the following types:
 //   nil
@@ -142,12 +608,44 @@ func (f HandlerFunc) ServeRedeo(w resp.ResponseWriter, c *resp.Command) { f(w, c
 //   resp.CustomResponse instances
 //   slices of any of the above typs
 //   maps containing keys and values of any of the above types
+//   structs, pointers and named types, see resp.Marshal
+// Values of other types result in an error reply.
 type WrapperFunc func(c *resp.Command) interface{}
 
 // ServeRedeo implements Handler
 func (f WrapperFunc) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
-	if err := w.Append(f(c)); err != nil {
-		w.AppendError("ERR " + err.Error())
+	if err := w.AppendAny(f(c)); err != nil {
+		w.AppendError(resp.ErrorReply(err))
+	}
+}
+
+// ValueHandlerFunc is a context-aware callback function, implementing
+// Handler, which returns a value or an error. Values are appended via
+// resp.ResponseWriter.AppendAny, errors as in ErrorHandlerFunc.
+type ValueHandlerFunc func(ctx context.Context, c *resp.Command) (interface{}, error)
+
+// ServeRedeo implements Handler
+func (f ValueHandlerFunc) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	v, err := f(c.Context(), c)
+	if err == nil {
+		err = w.AppendAny(v)
+	}
+	if err != nil {
+		w.AppendError(resp.ErrorReply(err))
+	}
+}
+
+// ErrorHandlerFunc is a callback function, implementing Handler, which
+// may return an error instead of writing a reply. Errors are rendered
+// via resp.ErrorReply, i.e. typed errors keep their prefix, others are
+// prefixed with "ERR". Handlers must not write a reply when returning
+// an error.
+type ErrorHandlerFunc func(w resp.ResponseWriter, c *resp.Command) error
+
+// ServeRedeo calls f(w, c).
+func (f ErrorHandlerFunc) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	if err := f(w, c); err != nil {
+		w.AppendError(resp.ErrorReply(err))
 	}
 }
 
@@ -2,13 +2,19 @@ package redeo
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/wangaoone/redeo/info"
 	"github.com/wangaoone/redeo/redeotest"
 	"github.com/wangaoone/redeo/resp"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -31,6 +37,165 @@ var _ = Describe("Ping", func() {
 
 })
 
+var _ = Describe("Select", func() {
+	subject := Select()
+
+	It("should validate the index", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("SELECT", resp.CommandArgument("15")))
+		Expect(w.Response()).To(Equal("OK"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("SELECT", resp.CommandArgument("16")))
+		Expect(w.Response()).To(MatchError("ERR DB index is out of range"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("SELECT", resp.CommandArgument("x")))
+		Expect(w.Response()).To(MatchError("ERR value is not an integer or out of range"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("SELECT"))
+		Expect(w.Response()).To(MatchError("ERR wrong number of arguments for 'SELECT' command"))
+	})
+
+})
+
+var _ = DescribeTable("WrapperFunc",
+	func(v interface{}, exp string) {
+		w := redeotest.NewRecorder()
+		subject := WrapperFunc(func(_ *resp.Command) interface{} { return v })
+		subject.ServeRedeo(w, resp.NewCommand("TEST"))
+		Expect(w.Quoted()).To(Equal(strconv.Quote(exp)))
+	},
+
+	Entry("nil", nil, "$-1\r\n"),
+	Entry("error", ErrWrongNumberOfArgs("test"), "-ERR wrong number of arguments for 'test' command\r\n"),
+	Entry("string", "str", "$3\r\nstr\r\n"),
+	Entry("[]byte", []byte("bin"), "$3\r\nbin\r\n"),
+	Entry("int", 7, ":7\r\n"),
+	Entry("int64", int64(-7), ":-7\r\n"),
+	Entry("bool", true, ":1\r\n"),
+	Entry("[]string", []string{"a", "b"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"),
+	Entry("[]interface{}", []interface{}{"a", 1, []interface{}{nil, false}}, "*3\r\n$1\r\na\r\n:1\r\n*2\r\n$-1\r\n:0\r\n"),
+	Entry("map[string]string", map[string]string{"k": "v"}, "*2\r\n$1\r\nk\r\n$1\r\nv\r\n"),
+	Entry("struct", struct {
+		N int `redis:"n"`
+	}{N: 1}, "*2\r\n$1\r\nn\r\n:1\r\n"),
+	Entry("named type", time.Second, ":1000000000\r\n"),
+	Entry("unsupported", make(chan int), "-ERR resp: unsupported type chan int\r\n"),
+	Entry("nested unsupported", []interface{}{"a", complex(1, 2)}, "-ERR resp: unsupported type complex128\r\n"),
+)
+
+var _ = Describe("ValueHandlerFunc", func() {
+
+	It("should append values and errors", func() {
+		subject := ValueHandlerFunc(func(_ context.Context, c *resp.Command) (interface{}, error) {
+			switch c.Arg(0).String() {
+			case "struct":
+				return &struct{ ID int64 }{ID: 7}, nil
+			case "unsupported":
+				return make(chan int), nil
+			}
+			return nil, ErrWrongType
+		})
+
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("TEST", resp.CommandArgument("struct")))
+		Expect(w.Response()).To(Equal([]interface{}{"ID", int64(7)}))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("TEST", resp.CommandArgument("unsupported")))
+		Expect(w.Response()).To(MatchError("ERR resp: unsupported type chan int"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("TEST"))
+		Expect(w.Response()).To(MatchError("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	})
+
+})
+
+var _ = Describe("Hello", func() {
+	subject := Hello()
+
+	It("should keep the current protocol without args", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("HELLO"))
+		Expect(w.Protocol()).To(Equal(resp.RESP2))
+		Expect(w.Response()).To(Equal([]interface{}{
+			"server", "redeo",
+			"proto", int64(2),
+			"id", int64(0),
+			"mode", "standalone",
+			"role", "master",
+			"modules", []interface{}{},
+		}))
+	})
+
+	It("should negotiate RESP3", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("HELLO", resp.CommandArgument("3")))
+		Expect(w.Protocol()).To(Equal(resp.RESP3))
+		Expect(w.String()).To(HavePrefix("%6\r\n"))
+	})
+
+	It("should reject unsupported versions", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("HELLO", resp.CommandArgument("4")))
+		Expect(w.Protocol()).To(Equal(resp.RESP2))
+		Expect(w.Response()).To(MatchError("NOPROTO unsupported protocol version"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("HELLO", resp.CommandArgument("x")))
+		Expect(w.Response()).To(MatchError("ERR Protocol version is not an integer or out of range"))
+	})
+
+})
+
+var _ = Describe("Info", func() {
+	var subject Handler
+	var srv *Server
+
+	BeforeEach(func() {
+		srv = NewServer(nil)
+		srv.Info().Fetch("Custom").Register("key", info.StaticString("value"))
+		subject = Info(srv)
+	})
+
+	It("should report all sections", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("INFO"))
+		v, err := w.Response()
+		Expect(err).NotTo(HaveOccurred())
+		str, _ := v.(string)
+		Expect(str).To(HavePrefix("# Server\r\nprocess_id:"))
+		Expect(str).To(ContainSubstring("\r\ntcp_port:0\r\n"))
+		Expect(str).To(ContainSubstring("\r\n\r\n# Clients\r\nconnected_clients:0\r\n"))
+		Expect(str).To(ContainSubstring("\r\nblocked_clients:0\r\n"))
+		Expect(str).To(ContainSubstring("\r\n\r\n# Stats\r\ntotal_connections_received:0\r\n"))
+		Expect(str).To(HaveSuffix("# Custom\r\nkey:value\r\n"))
+		Expect(str).NotTo(MatchRegexp("[^\r]\n"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("INFO", resp.CommandArgument("EVERYTHING")))
+		Expect(w.Response()).To(Equal(str))
+	})
+
+	It("should filter sections", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("INFO", resp.CommandArgument("cUsTom")))
+		Expect(w.Response()).To(Equal("# Custom\r\nkey:value\r\n"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("INFO", resp.CommandArgument("clients"), resp.CommandArgument("custom")))
+		Expect(w.Response()).To(MatchRegexp("(?s)^# Clients\r\n.*\r\n\r\n# Custom\r\nkey:value\r\n$"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("INFO", resp.CommandArgument("unknown")))
+		Expect(w.Response()).To(Equal(""))
+	})
+
+})
+
 var _ = Describe("CommandDescriptions", func() {
 	subject := CommandDescriptions{
 		{Name: "GeT", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStepCount: 1},
@@ -52,6 +217,250 @@ var _ = Describe("CommandDescriptions", func() {
 
 })
 
+var _ = Describe("commandIntrospection", func() {
+	var subject Handler
+
+	BeforeEach(func() {
+		srv := NewServer(nil)
+		srv.Handle("ping", Ping())
+		srv.Handle("ECHO", Echo())
+		srv.HandleCallbackFunc(func(_ resp.ResponseWriter, _ interface{}) {})
+		srv.HandleCommandIntrospection()
+		subject = srv.cmds["command"].served.(Handler)
+	})
+
+	It("should enumerate registered commands", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("COMMAND"))
+		Expect(w.Response()).To(Equal([]interface{}{
+			[]interface{}{"command", int64(-1), []interface{}{}, int64(0), int64(0), int64(0)},
+			[]interface{}{"echo", int64(-1), []interface{}{}, int64(0), int64(0), int64(0)},
+			[]interface{}{"ping", int64(-1), []interface{}{}, int64(0), int64(0), int64(0)},
+		}))
+	})
+
+	It("should count", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("COMMAND", resp.CommandArgument("count")))
+		Expect(w.Response()).To(Equal(int64(3)))
+	})
+
+	It("should describe individual commands", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("COMMAND", resp.CommandArgument("INFO"), resp.CommandArgument("PING"), resp.CommandArgument("missing")))
+		Expect(w.Response()).To(Equal([]interface{}{
+			[]interface{}{"ping", int64(-1), []interface{}{}, int64(0), int64(0), int64(0)},
+			nil,
+		}))
+	})
+
+	It("should describe registration options", func() {
+		srv := NewServer(nil)
+		srv.Handle("get", Echo(), Arity(2), Flags("readonly", "fast"), Keys(1, 1, 1))
+		srv.HandleFunc("mset", nil, MinArgs(2), Flags("write"), Keys(1, -1, 2))
+		srv.HandleCommandIntrospection()
+
+		w := redeotest.NewRecorder()
+		srv.cmds["command"].served.(Handler).ServeRedeo(w, resp.NewCommand("COMMAND", resp.CommandArgument("INFO"), resp.CommandArgument("get"), resp.CommandArgument("mset")))
+		Expect(w.Response()).To(Equal([]interface{}{
+			[]interface{}{"get", int64(2), []interface{}{"readonly", "fast"}, int64(1), int64(1), int64(1)},
+			[]interface{}{"mset", int64(-3), []interface{}{"write"}, int64(1), int64(-1), int64(2)},
+		}))
+	})
+
+	It("should list commands", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("COMMAND", resp.CommandArgument("list")))
+		Expect(w.Response()).To(Equal([]interface{}{"command", "echo", "ping"}))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("COMMAND", resp.CommandArgument("docs"), resp.CommandArgument("PING"), resp.CommandArgument("missing")))
+		Expect(w.Response()).To(Equal([]interface{}{"ping", []interface{}{}}))
+	})
+
+	It("should extract keys", func() {
+		srv := NewServer(nil)
+		srv.Handle("get", Echo(), Arity(2), Keys(1, 1, 1))
+		srv.HandleFunc("mset", nil, MinArgs(2), Keys(1, -1, 2))
+		srv.Handle("ping", Ping())
+		srv.HandleCommandIntrospection()
+		subject = srv.cmds["command"].served.(Handler)
+
+		getkeys := func(args ...string) *redeotest.ResponseRecorder {
+			cargs := []resp.CommandArgument{resp.CommandArgument("getkeys")}
+			for _, arg := range args {
+				cargs = append(cargs, resp.CommandArgument(arg))
+			}
+			w := redeotest.NewRecorder()
+			subject.ServeRedeo(w, resp.NewCommand("COMMAND", cargs...))
+			return w
+		}
+
+		Expect(getkeys("GET", "key").Response()).To(Equal([]interface{}{"key"}))
+		Expect(getkeys("mset", "k1", "v1", "k2", "v2").Response()).To(Equal([]interface{}{"k1", "k2"}))
+		Expect(getkeys("get").Response()).To(MatchError("ERR Invalid number of arguments specified for command"))
+		Expect(getkeys("ping").Response()).To(MatchError("ERR The command has no key arguments"))
+		Expect(getkeys("missing").Response()).To(MatchError("ERR Invalid command specified"))
+	})
+
+	It("should fail on unknown sub-commands", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("COMMAND", resp.CommandArgument("bad")))
+		Expect(w.Response()).To(MatchError("ERR Unknown command subcommand 'bad'"))
+	})
+
+})
+
+var _ = Describe("clientCommand", func() {
+	var srv *Server
+	var lis net.Listener
+
+	type conn struct {
+		net.Conn
+		w *resp.RequestWriter
+		r resp.ResponseReader
+	}
+
+	var dial = func() *conn {
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		return &conn{Conn: cn, w: resp.NewRequestWriter(cn), r: resp.NewResponseReader(cn)}
+	}
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		srv = NewServer(nil)
+		srv.Handle("ping", Ping())
+		srv.HandleClient()
+		go srv.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should get/set names", func() {
+		cn := dial()
+		defer cn.Close()
+
+		cn.w.WriteCmdString("CLIENT", "GETNAME")
+		cn.w.WriteCmdString("CLIENT", "SETNAME", "bad name")
+		cn.w.WriteCmdString("CLIENT", "SETNAME", "worker-1")
+		cn.w.WriteCmdString("CLIENT", "GETNAME")
+		cn.w.WriteCmdString("CLIENT", "ID")
+		Expect(cn.w.Flush()).To(Succeed())
+
+		Expect(cn.r.ReadNil()).To(Succeed())
+		Expect(cn.r.ReadError()).To(Equal("ERR Client names cannot contain spaces, newlines or special characters."))
+		Expect(cn.r.ReadInlineString()).To(Equal("OK"))
+		Expect(cn.r.ReadBulkString()).To(Equal("worker-1"))
+
+		id, err := cn.r.ReadInt()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(srv.Info().ClientInfo()[0].ID).To(Equal(uint64(id)))
+		Expect(srv.Info().ClientInfo()[0].Name).To(Equal("worker-1"))
+	})
+
+	It("should list clients", func() {
+		cn1, cn2 := dial(), dial()
+		defer cn1.Close()
+		defer cn2.Close()
+
+		cn1.w.WriteCmdString("CLIENT", "SETNAME", "first")
+		Expect(cn1.w.Flush()).To(Succeed())
+		Expect(cn1.r.ReadInlineString()).To(Equal("OK"))
+
+		cn2.w.WriteCmdString("CLIENT", "LIST")
+		Expect(cn2.w.Flush()).To(Succeed())
+
+		s, err := cn2.r.ReadBulkString()
+		Expect(err).NotTo(HaveOccurred())
+
+		lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(MatchRegexp(`^id=\d+ addr=` + cn1.LocalAddr().String() + ` name=first age=\d+ idle=\d+ db=0 cmd=client tot-net-in=\d+ tot-net-out=\d+ omem=\d+ rate-limited=0$`))
+		Expect(lines[1]).To(MatchRegexp(`^id=\d+ addr=` + cn2.LocalAddr().String() + ` name= age=\d+ idle=\d+ db=0 cmd=client tot-net-in=\d+ tot-net-out=\d+ omem=\d+ rate-limited=0$`))
+	})
+
+	It("should kill clients", func() {
+		cn1, cn2, cn3 := dial(), dial(), dial()
+		defer cn1.Close()
+		defer cn2.Close()
+		defer cn3.Close()
+
+		cn1.w.WriteCmdString("CLIENT", "ID")
+		Expect(cn1.w.Flush()).To(Succeed())
+		id, err := cn1.r.ReadInt()
+		Expect(err).NotTo(HaveOccurred())
+
+		cn2.w.WriteCmd("PING")
+		Expect(cn2.w.Flush()).To(Succeed())
+		Expect(cn2.r.ReadInlineString()).To(Equal("PONG"))
+
+		cn3.w.WriteCmdString("CLIENT", "KILL", "1.2.3.4:5")
+		cn3.w.WriteCmdString("CLIENT", "KILL", "ID", strconv.FormatInt(id, 10))
+		cn3.w.WriteCmdString("CLIENT", "KILL", cn2.LocalAddr().String())
+		Expect(cn3.w.Flush()).To(Succeed())
+		Expect(cn3.r.ReadError()).To(Equal("ERR No such client"))
+		Expect(cn3.r.ReadInt()).To(Equal(int64(1)))
+		Expect(cn3.r.ReadInlineString()).To(Equal("OK"))
+
+		_, err = cn1.r.PeekType()
+		Expect(err).To(Equal(io.EOF))
+		_, err = cn2.r.PeekType()
+		Expect(err).To(Equal(io.EOF))
+		Eventually(srv.Info().NumClients).Should(Equal(1))
+	})
+
+	It("should filter client lists", func() {
+		cn1, cn2 := dial(), dial()
+		defer cn1.Close()
+		defer cn2.Close()
+
+		cn1.w.WriteCmdString("CLIENT", "ID")
+		Expect(cn1.w.Flush()).To(Succeed())
+		id, err := cn1.r.ReadInt()
+		Expect(err).NotTo(HaveOccurred())
+
+		cn2.w.WriteCmdString("CLIENT", "LIST", "ID", strconv.FormatInt(id, 10))
+		cn2.w.WriteCmdString("CLIENT", "LIST", "ID", "x")
+		cn2.w.WriteCmdString("CLIENT", "LIST", "TYPE", "normal")
+		cn2.w.WriteCmdString("CLIENT", "INFO")
+		Expect(cn2.w.Flush()).To(Succeed())
+
+		s, err := cn2.r.ReadBulkString()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s).To(MatchRegexp(`^id=` + strconv.FormatInt(id, 10) + ` addr=` + cn1.LocalAddr().String() + ` .*\n$`))
+		Expect(cn2.r.ReadError()).To(Equal("ERR Invalid client ID"))
+		Expect(cn2.r.ReadError()).To(Equal("ERR syntax error"))
+
+		s, err = cn2.r.ReadBulkString()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s).To(MatchRegexp(`^id=\d+ addr=` + cn2.LocalAddr().String() + ` .* cmd=client .*\n$`))
+	})
+
+	It("should skip the calling client on kill", func() {
+		cn := dial()
+		defer cn.Close()
+
+		addr := cn.LocalAddr().String()
+		cn.w.WriteCmdString("CLIENT", "KILL", "ADDR", addr)
+		cn.w.WriteCmdString("CLIENT", "KILL", "ADDR", addr, "SKIPME", "maybe")
+		cn.w.WriteCmdString("CLIENT", "KILL", "ADDR", addr, "SKIPME", "no")
+		Expect(cn.w.Flush()).To(Succeed())
+		Expect(cn.r.ReadInt()).To(Equal(int64(0)))
+		Expect(cn.r.ReadError()).To(Equal("ERR syntax error"))
+		Expect(cn.r.ReadInt()).To(Equal(int64(1)))
+
+		_, err := cn.r.PeekType()
+		Expect(err).To(Equal(io.EOF))
+	})
+
+})
+
 var _ = Describe("SubCommands", func() {
 	subject := SubCommands{
 		"echo": Echo(),
@@ -67,7 +476,7 @@ var _ = Describe("SubCommands", func() {
 	It("should fail on calls with an unknown sub", func() {
 		w := redeotest.NewRecorder()
 		subject.ServeRedeo(w, resp.NewCommand("CUSTOM", resp.CommandArgument("missing")))
-		Expect(w.Response()).To(MatchError("ERR Unknown custom subcommand 'missing'"))
+		Expect(w.Response()).To(MatchError("ERR Unknown CUSTOM subcommand or wrong number of arguments for 'missing'"))
 	})
 
 	It("should fail on calls with invalid args", func() {
@@ -86,6 +495,73 @@ var _ = Describe("SubCommands", func() {
 		Expect(w.Response()).To(Equal("PONG"))
 	})
 
+	It("should register and nest sub-commands", func() {
+		inner := NewSubCommands()
+		inner.HandleFunc("Get", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendBulkString(c.Name + ":" + c.Arg(0).String())
+		})
+		outer := NewSubCommands()
+		outer.Handle("config", inner)
+
+		w := redeotest.NewRecorder()
+		outer.ServeRedeo(w, resp.NewCommand("X", resp.CommandArgument("CONFIG"), resp.CommandArgument("get"), resp.CommandArgument("k")))
+		Expect(w.Response()).To(Equal("X CONFIG get:k"))
+
+		w = redeotest.NewRecorder()
+		outer.ServeRedeo(w, resp.NewCommand("X", resp.CommandArgument("config"), resp.CommandArgument("set")))
+		Expect(w.Response()).To(MatchError("ERR Unknown X CONFIG subcommand or wrong number of arguments for 'set'"))
+	})
+
+	It("should check sub-command arguments", func() {
+		sc := NewSubCommands()
+		sc.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendBulkString(c.Arg(0).String() + "=" + c.Arg(1).String())
+		}, Arity(3))
+
+		w := redeotest.NewRecorder()
+		sc.ServeRedeo(w, resp.NewCommand("X", resp.CommandArgument("SET"), resp.CommandArgument("k")))
+		Expect(w.Response()).To(MatchError("ERR wrong number of arguments for 'X SET' command"))
+
+		w = redeotest.NewRecorder()
+		sc.ServeRedeo(w, resp.NewCommand("X", resp.CommandArgument("set"), resp.CommandArgument("k"), resp.CommandArgument("v")))
+		Expect(w.Response()).To(Equal("k=v"))
+	})
+
+	It("should generate help", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("custom", resp.CommandArgument("HELP")))
+		Expect(w.Response()).To(Equal([]interface{}{
+			"CUSTOM <subcommand> [<arg> ...]. Subcommands are:",
+			"ECHO",
+			"HELP",
+			"PING",
+		}))
+	})
+
+})
+
+var _ = Describe("DBs", func() {
+	subject := DBs{Echo(), nil, Ping()}
+
+	var serve = func(db int, args ...resp.CommandArgument) *redeotest.ResponseRecorder {
+		c := newClient(&mockConn{})
+		c.SetDB(db)
+
+		cmd := resp.NewCommand("CUSTOM", args...)
+		cmd.SetContext(context.WithValue(context.Background(), ctxKeyClient{}, c))
+
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, cmd)
+		return w
+	}
+
+	It("should route by the selected database", func() {
+		Expect(serve(0, resp.CommandArgument("hi")).Response()).To(Equal("hi"))
+		Expect(serve(2).Response()).To(Equal("PONG"))
+		Expect(serve(1).Response()).To(MatchError("ERR DB index is out of range"))
+		Expect(serve(3).Response()).To(MatchError("ERR DB index is out of range"))
+	})
+
 })
 
 // ------------------------------------------------------------------------
@@ -95,6 +571,12 @@ func TestSuite(t *testing.T) {
 	RunSpecs(t, "redeo")
 }
 
+func dialRaw(lis net.Listener) (net.Conn, *resp.RequestWriter, resp.ResponseReader) {
+	cn, err := net.Dial("tcp", lis.Addr().String())
+	Expect(err).NotTo(HaveOccurred())
+	return cn, resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+}
+
 // --------------------------------------------------------------------
 
 type mockConn struct {
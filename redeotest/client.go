@@ -0,0 +1,176 @@
+package redeotest
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+
+	"github.com/bsm/redeo/client"
+	"github.com/bsm/redeo/resp"
+)
+
+// TestingT is the subset of testing.TB used by assertions, which is also
+// implemented by GinkgoT().
+type TestingT interface {
+	Fatalf(format string, args ...interface{})
+}
+
+// Client is a RESP client for tests, built on client.Conn. Replies are
+// returned like the ones of ResponseRecorder, error replies as
+// ErrorResponse values.
+type Client struct {
+	net.Conn
+
+	cc client.Conn
+}
+
+// NewClient wraps a connection, e.g. one side of Pipe or the client side
+// of Listener.Dial.
+func NewClient(cn net.Conn) *Client {
+	return &Client{Conn: cn, cc: client.Wrap(cn)}
+}
+
+// Send buffers a command, see Flush. Pipelines are sent by multiple calls
+// to Send followed by a single Flush.
+func (c *Client) Send(name string, args ...string) {
+	c.cc.WriteCmdString(name, args...)
+}
+
+// SendRaw flushes buffered commands and writes raw bytes, e.g. inline
+// or malformed commands.
+func (c *Client) SendRaw(p []byte) error {
+	if err := c.cc.Flush(); err != nil {
+		return err
+	}
+	_, err := c.Conn.Write(p)
+	return err
+}
+
+// Flush sends the buffered commands.
+func (c *Client) Flush() error {
+	return c.cc.Flush()
+}
+
+// Receive reads the next reply.
+func (c *Client) Receive() (interface{}, error) {
+	r, err := c.cc.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return replyValue(r)
+}
+
+// Do sends a single command and reads the reply.
+func (c *Client) Do(name string, args ...string) (interface{}, error) {
+	c.Send(name, args...)
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	return c.Receive()
+}
+
+// ExpectReply reads the next reply and fails t unless it equals exp.
+// Integers are compared as int64, string slices as []interface{}.
+func (c *Client) ExpectReply(t TestingT, exp interface{}) {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	v, err := c.Receive()
+	if err != nil {
+		t.Fatalf("redeotest: expected reply %#v, but failed with %v", exp, err)
+		return
+	}
+	if exp = normalizeReply(exp); !reflect.DeepEqual(v, exp) {
+		t.Fatalf("redeotest: expected reply %#v, got %#v", exp, v)
+	}
+}
+
+// ExpectError reads the next reply and fails t unless it is an error
+// reply with message msg.
+func (c *Client) ExpectError(t TestingT, msg string) {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	c.ExpectReply(t, ErrorResponse(msg))
+}
+
+// ExpectClosed fails t unless the connection is closed by the peer once
+// pending replies are read.
+func (c *Client) ExpectClosed(t TestingT) {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	if v, err := c.Receive(); err == nil {
+		t.Fatalf("redeotest: expected connection to be closed, got %#v", v)
+	}
+}
+
+// replyValue converts r to the values returned by ResponseRecorder.
+func replyValue(r *resp.Reply) (interface{}, error) {
+	switch r.Type {
+	case resp.TypeBulk, resp.TypeInline:
+		return r.Str()
+	case resp.TypeInt:
+		return r.Int()
+	case resp.TypeError:
+		return ErrorResponse(r.Err().Error()), nil
+	case resp.TypeNil:
+		return nil, nil
+	case resp.TypeDouble:
+		s, err := r.Str()
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(s, 64)
+	case resp.TypeBool:
+		n, err := r.Int()
+		return n == 1, err
+	case resp.TypeBigInt:
+		s, err := r.Str()
+		if err != nil {
+			return nil, err
+		}
+		n, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid big number %q", s)
+		}
+		return n, nil
+	case resp.TypeArray, resp.TypeMap, resp.TypeSet, resp.TypePush:
+		elems, err := r.Slice()
+		if err != nil {
+			return nil, err
+		}
+		vv := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			if vv[i], err = replyValue(elem); err != nil {
+				return nil, err
+			}
+		}
+		return vv, nil
+	}
+	return nil, fmt.Errorf("unexpected response %v", r.Type)
+}
+
+func normalizeReply(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return int64(x)
+	case []string:
+		vv := make([]interface{}, len(x))
+		for i, s := range x {
+			vv[i] = s
+		}
+		return vv
+	case []interface{}:
+		vv := make([]interface{}, len(x))
+		for i, e := range x {
+			vv[i] = normalizeReply(e)
+		}
+		return vv
+	}
+	return v
+}
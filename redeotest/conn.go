@@ -0,0 +1,187 @@
+package redeotest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrListenerClosed is returned by Accept and Dial once the listener
+// is closed.
+var ErrListenerClosed = errors.New("redeotest: listener closed")
+
+// Listener is an in-memory net.Listener, which allows to serve a server
+// without binding TCP ports. Connections are synchronous, like net.Pipe,
+// unless created via NewBufferedListener.
+type Listener struct {
+	conns chan *Conn
+	done  chan struct{}
+	once  sync.Once
+	pipe  func() (net.Conn, net.Conn)
+}
+
+// NewListener inits a new listener.
+func NewListener() *Listener {
+	return &Listener{
+		conns: make(chan *Conn),
+		done:  make(chan struct{}),
+		pipe:  net.Pipe,
+	}
+}
+
+// NewBufferedListener inits a new listener, which connects via Pipe.
+func NewBufferedListener() *Listener {
+	l := NewListener()
+	l.pipe = func() (net.Conn, net.Conn) {
+		cn, sn := Pipe()
+		return cn, sn
+	}
+	return l
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case cn := <-l.conns:
+		return cn, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close implements net.Listener.
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr implements net.Listener.
+func (l *Listener) Addr() net.Addr { return pipeAddr{} }
+
+// Dial connects to the listener. It returns the client side of the
+// connection and the server side, which can be used to inject
+// faults. Dial blocks until the connection is accepted.
+func (l *Listener) Dial() (net.Conn, *Conn, error) {
+	cn, sn := l.pipe()
+	conn := &Conn{Conn: sn}
+
+	select {
+	case l.conns <- conn:
+		return cn, conn, nil
+	case <-l.done:
+		_ = cn.Close()
+		_ = sn.Close()
+		return nil, nil, ErrListenerClosed
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// --------------------------------------------------------------------
+
+// Conn is the server side of an in-memory connection, see Listener.
+type Conn struct {
+	net.Conn
+
+	rerr, werr error
+	wlimit     int  // remaining bytes before writes are cut short
+	limited    bool // true if wlimit applies
+	mu         sync.Mutex
+}
+
+// FailReads makes pending and subsequent reads return err. Pass nil to
+// reset.
+func (c *Conn) FailReads(err error) {
+	c.mu.Lock()
+	c.rerr = err
+	c.mu.Unlock()
+
+	if err != nil {
+		_ = c.Conn.SetReadDeadline(time.Now())
+	} else {
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// FailWrites makes pending and subsequent writes return err. Pass nil to
+// reset.
+func (c *Conn) FailWrites(err error) {
+	c.mu.Lock()
+	c.werr = err
+	c.mu.Unlock()
+
+	if err != nil {
+		_ = c.Conn.SetWriteDeadline(time.Now())
+	} else {
+		_ = c.Conn.SetWriteDeadline(time.Time{})
+	}
+}
+
+// LimitWrites cuts writes short once n more bytes have been written,
+// further writes fail with io.ErrShortWrite. Pass a negative n to reset.
+func (c *Conn) LimitWrites(n int) {
+	c.mu.Lock()
+	c.wlimit, c.limited = n, n >= 0
+	c.mu.Unlock()
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	err := c.rerr
+	c.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		err = c.failure(&c.rerr, err)
+	}
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	err, short := c.werr, false
+	if err == nil && c.limited {
+		if len(p) > c.wlimit {
+			p, short = p[:c.wlimit], true
+		}
+		c.wlimit -= len(p)
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	if short && len(p) == 0 {
+		return 0, io.ErrShortWrite
+	}
+
+	n, err := c.Conn.Write(p)
+	if err != nil {
+		err = c.failure(&c.werr, err)
+	} else if short {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+// failure returns the injected error, if set, otherwise err.
+func (c *Conn) failure(injected *error, err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if *injected != nil {
+		return *injected
+	}
+	return err
+}
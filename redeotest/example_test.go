@@ -1,6 +1,7 @@
 package redeotest_test
 
 import (
+	"bufio"
 	"fmt"
 
 	"github.com/bsm/redeo"
@@ -30,3 +31,65 @@ func ExampleResponseRecorder() {
 	// "*3\r\n$3\r\nbob\r\n$11\r\nresponds to\r\n$4\r\ncall\r\n"
 	// [bob responds to call] <nil>
 }
+
+func ExampleListener() {
+	srv := redeo.NewServer(nil)
+	srv.Handle("ping", redeo.Ping())
+
+	lis := redeotest.NewListener()
+	defer lis.Close()
+	go srv.Serve(lis)
+
+	cn, _, err := lis.Dial()
+	if err != nil {
+		panic(err)
+	}
+	defer cn.Close()
+
+	if _, err := cn.Write([]byte("PING\r\n")); err != nil {
+		panic(err)
+	}
+	line, err := bufio.NewReader(cn).ReadString('\n')
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%q\n", line)
+
+	// Output:
+	// "+PONG\r\n"
+}
+
+func ExampleClient() {
+	srv := redeo.NewServer(nil)
+	srv.Handle("ping", redeo.Ping())
+	srv.Handle("echo", redeo.Echo())
+
+	lis := redeotest.NewBufferedListener()
+	defer lis.Close()
+	go srv.Serve(lis)
+
+	cn, _, err := lis.Dial()
+	if err != nil {
+		panic(err)
+	}
+
+	client := redeotest.NewClient(cn)
+	defer client.Close()
+
+	// pipeline commands
+	client.Send("PING")
+	client.Send("ECHO", "hello")
+	client.Send("ECHO")
+	if err := client.Flush(); err != nil {
+		panic(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		fmt.Println(client.Receive())
+	}
+
+	// Output:
+	// PONG <nil>
+	// hello <nil>
+	// ERR wrong number of arguments for 'ECHO' command <nil>
+}
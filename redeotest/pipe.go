@@ -0,0 +1,198 @@
+package redeotest
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Pipe creates a full-duplex, in-memory connection pair. Unlike net.Pipe,
+// writes are buffered, so either side may write while the other is not
+// reading, e.g. to pipeline commands. Buffers are unlimited, unless
+// restricted via SetWriteBuffer.
+func Pipe() (*PipeConn, *PipeConn) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return &PipeConn{rd: a, wr: b}, &PipeConn{rd: b, wr: a}
+}
+
+// PipeConn is an end of an in-memory connection, see Pipe.
+type PipeConn struct {
+	rd, wr *pipeBuffer
+}
+
+// Read implements net.Conn.
+func (c *PipeConn) Read(p []byte) (int, error) { return c.rd.read(p) }
+
+// Write implements net.Conn.
+func (c *PipeConn) Write(p []byte) (int, error) { return c.wr.write(p) }
+
+// Close implements net.Conn. The peer reads the buffered data, then EOF.
+func (c *PipeConn) Close() error {
+	c.rd.close(true)
+	c.wr.close(false)
+	return nil
+}
+
+// LocalAddr implements net.Conn.
+func (c *PipeConn) LocalAddr() net.Addr { return pipeAddr{} }
+
+// RemoteAddr implements net.Conn.
+func (c *PipeConn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+// SetDeadline implements net.Conn.
+func (c *PipeConn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *PipeConn) SetReadDeadline(t time.Time) error {
+	c.rd.update(func(b *pipeBuffer) { b.rdeadline = t })
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *PipeConn) SetWriteDeadline(t time.Time) error {
+	c.wr.update(func(b *pipeBuffer) { b.wdeadline = t })
+	return nil
+}
+
+// SetWriteBuffer limits the bytes written, but not yet read by the peer.
+// Writes block while the buffer is full, which simulates a slow reader.
+// Pass 0 to remove the limit.
+func (c *PipeConn) SetWriteBuffer(n int) error {
+	c.wr.update(func(b *pipeBuffer) { b.size = n })
+	return nil
+}
+
+// SetReadLimit limits the bytes returned by each read, which simulates
+// fragmented input. Pass 0 to remove the limit.
+func (c *PipeConn) SetReadLimit(n int) {
+	c.rd.update(func(b *pipeBuffer) { b.limit = n })
+}
+
+// --------------------------------------------------------------------
+
+// pipeBuffer holds the data written in one direction.
+type pipeBuffer struct {
+	buf   []byte
+	size  int // capacity, 0 if unlimited
+	limit int // bytes per read, 0 if unlimited
+
+	rdeadline, wdeadline time.Time
+	rclosed, wclosed     bool // closed by the reading or writing end
+
+	wake chan struct{} // closed on change
+	mu   sync.Mutex
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{wake: make(chan struct{})}
+}
+
+func (b *pipeBuffer) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		if b.rclosed {
+			return 0, io.ErrClosedPipe
+		}
+		if len(b.buf) != 0 {
+			if b.limit > 0 && len(p) > b.limit {
+				p = p[:b.limit]
+			}
+			n := copy(p, b.buf)
+			b.buf = b.buf[:copy(b.buf, b.buf[n:])]
+			b.notify()
+			return n, nil
+		}
+		if b.wclosed {
+			return 0, io.EOF
+		}
+		if err := b.wait(b.rdeadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (b *pipeBuffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var n int
+	for len(p) != 0 {
+		if b.wclosed || b.rclosed {
+			return n, io.ErrClosedPipe
+		}
+
+		m := len(p)
+		if b.size > 0 {
+			if space := b.size - len(b.buf); space <= 0 {
+				if err := b.wait(b.wdeadline); err != nil {
+					return n, err
+				}
+				continue
+			} else if m > space {
+				m = space
+			}
+		} else if !b.wdeadline.IsZero() && !time.Now().Before(b.wdeadline) {
+			return n, os.ErrDeadlineExceeded
+		}
+
+		b.buf = append(b.buf, p[:m]...)
+		p, n = p[m:], n+m
+		b.notify()
+	}
+	return n, nil
+}
+
+func (b *pipeBuffer) close(reader bool) {
+	b.update(func(b *pipeBuffer) {
+		if reader {
+			b.rclosed = true
+		} else {
+			b.wclosed = true
+		}
+	})
+}
+
+func (b *pipeBuffer) update(fn func(*pipeBuffer)) {
+	b.mu.Lock()
+	fn(b)
+	b.notify()
+	b.mu.Unlock()
+}
+
+// notify wakes waiting readers and writers, must be called with the
+// lock held.
+func (b *pipeBuffer) notify() {
+	close(b.wake)
+	b.wake = make(chan struct{})
+}
+
+// wait waits for a change or the deadline, must be called with the lock
+// held.
+func (b *pipeBuffer) wait(deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	wake := b.wake
+	b.mu.Unlock()
+	select {
+	case <-wake:
+	case <-timeout:
+	}
+	b.mu.Lock()
+	return nil
+}
@@ -0,0 +1,157 @@
+package redeotest
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pipe", func() {
+	var a, b *PipeConn
+
+	BeforeEach(func() {
+		a, b = Pipe()
+	})
+
+	It("should buffer writes", func() {
+		Expect(a.Write([]byte("hello"))).To(Equal(5))
+		Expect(a.Write([]byte(" world"))).To(Equal(6))
+		Expect(b.Write([]byte("back"))).To(Equal(4))
+
+		buf := make([]byte, 32)
+		Expect(b.Read(buf)).To(Equal(11))
+		Expect(string(buf[:11])).To(Equal("hello world"))
+		Expect(a.Read(buf)).To(Equal(4))
+		Expect(string(buf[:4])).To(Equal("back"))
+	})
+
+	It("should limit reads", func() {
+		b.SetReadLimit(3)
+		Expect(a.Write([]byte("hello"))).To(Equal(5))
+
+		buf := make([]byte, 32)
+		Expect(b.Read(buf)).To(Equal(3))
+		Expect(b.Read(buf)).To(Equal(2))
+		Expect(string(buf[:2])).To(Equal("lo"))
+	})
+
+	It("should block writes while the buffer is full", func() {
+		Expect(a.SetWriteBuffer(4)).To(Succeed())
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			Expect(a.Write([]byte("hello world"))).To(Equal(11))
+		}()
+		Consistently(done).ShouldNot(BeClosed())
+
+		data, err := io.ReadAll(io.LimitReader(b, 11))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("hello world"))
+		Eventually(done).Should(BeClosed())
+
+		Expect(a.Write([]byte("full"))).To(Equal(4))
+		Expect(a.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))).To(Succeed())
+		_, err = a.Write([]byte("x"))
+		Expect(err).To(Equal(os.ErrDeadlineExceeded))
+	})
+
+	It("should support read deadlines", func() {
+		Expect(b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))).To(Succeed())
+		_, err := b.Read(make([]byte, 1))
+		Expect(err).To(Equal(os.ErrDeadlineExceeded))
+		Expect(err.(net.Error).Timeout()).To(BeTrue())
+
+		Expect(b.SetReadDeadline(time.Time{})).To(Succeed())
+		Expect(a.Write([]byte("x"))).To(Equal(1))
+		Expect(b.Read(make([]byte, 1))).To(Equal(1))
+	})
+
+	It("should close", func() {
+		Expect(a.Write([]byte("bye"))).To(Equal(3))
+		Expect(a.Close()).To(Succeed())
+
+		data, err := io.ReadAll(b)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("bye"))
+
+		_, err = b.Write([]byte("x"))
+		Expect(err).To(Equal(io.ErrClosedPipe))
+		_, err = a.Read(make([]byte, 1))
+		Expect(err).To(Equal(io.ErrClosedPipe))
+	})
+
+})
+
+var _ = Describe("Client", func() {
+	var lis *Listener
+	var subject *Client
+
+	BeforeEach(func() {
+		srv := redeo.NewServer(nil)
+		srv.Handle("ping", redeo.Ping())
+		srv.Handle("quit", redeo.Quit())
+		srv.HandleFunc("keys", func(w resp.ResponseWriter, _ *resp.Command) {
+			w.AppendArrayLen(2)
+			w.AppendBulkString("a")
+			w.AppendInt(1)
+		})
+
+		lis = NewBufferedListener()
+		go srv.Serve(lis)
+
+		cn, _, err := lis.Dial()
+		Expect(err).NotTo(HaveOccurred())
+		subject = NewClient(cn)
+	})
+
+	AfterEach(func() {
+		Expect(subject.Close()).To(Succeed())
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should assert replies", func() {
+		subject.Send("PING")
+		subject.Send("KEYS")
+		subject.Send("NOPE")
+		Expect(subject.SendRaw([]byte("PING inline\r\n"))).To(Succeed())
+		subject.Send("QUIT")
+		Expect(subject.Flush()).To(Succeed())
+
+		subject.ExpectReply(GinkgoT(), "PONG")
+		subject.ExpectReply(GinkgoT(), []interface{}{"a", 1})
+		subject.ExpectError(GinkgoT(), "ERR unknown command 'NOPE'")
+		subject.ExpectReply(GinkgoT(), "inline")
+		subject.ExpectReply(GinkgoT(), "OK")
+		subject.ExpectClosed(GinkgoT())
+	})
+
+	It("should report mismatches", func() {
+		t := new(mockT)
+		subject.Send("PING")
+		Expect(subject.Flush()).To(Succeed())
+		subject.ExpectReply(t, "PING")
+		Expect(t.msg).To(Equal(`redeotest: expected reply "PING", got "PONG"`))
+
+		Expect(subject.Do("PING")).To(Equal("PONG"))
+	})
+
+})
+
+type mockT struct{ msg string }
+
+func (t *mockT) Fatalf(format string, args ...interface{}) { t.msg = fmt.Sprintf(format, args...) }
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "redeo/redeotest")
+}
@@ -95,7 +95,27 @@ func parseResult(rr resp.ResponseReader) (interface{}, error) {
 		return ErrorResponse(s), nil
 	case resp.TypeNil:
 		return nil, rr.ReadNil()
-	case resp.TypeArray:
+	case resp.TypeDouble:
+		return rr.ReadDouble()
+	case resp.TypeBool:
+		return rr.ReadBool()
+	case resp.TypeBigInt:
+		return rr.ReadBigInt()
+	case resp.TypeMap:
+		sz, err := rr.ReadMapLen()
+		if err != nil {
+			return nil, err
+		}
+
+		// maps are returned as flat key/value slices, like their RESP2 equivalent
+		vv := make([]interface{}, 2*sz)
+		for i := 0; i < len(vv); i++ {
+			if vv[i], err = parseResult(rr); err != nil {
+				return nil, err
+			}
+		}
+		return vv, nil
+	case resp.TypeArray, resp.TypeSet, resp.TypePush:
 		sz, err := rr.ReadArrayLen()
 		if err != nil {
 			return nil, err
@@ -0,0 +1,459 @@
+package redeo
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangaoone/redeo/info"
+	"github.com/wangaoone/redeo/resp"
+)
+
+// replicaFeedSize is the number of commands a replica may lag behind,
+// before it is considered too slow and disconnected.
+const replicaFeedSize = 4096
+
+// ReplicationConfig configures the primary side of redis' replication
+// protocol, see Server.HandleReplication.
+type ReplicationConfig struct {
+	// Snapshot returns the dataset sent to replicas on synchronisation,
+	// e.g. an RDB payload, and its size in bytes. Readers implementing
+	// io.Closer are closed once sent. Commands replicated while the
+	// snapshot is taken are forwarded to the replica thereafter.
+	Snapshot func() (r io.Reader, size int64, err error)
+
+	// Replicate decides whether a successfully executed command is
+	// forwarded to replicas, by normalised name.
+	// Default: commands registered with the "write" flag
+	Replicate func(name string, args []resp.CommandArgument) bool
+}
+
+// replicaSet holds the connected replicas and the state of the
+// replication stream.
+type replicaSet struct {
+	conf     *ReplicationConfig
+	id       string // the replication ID
+	offset   int64  // the replication offset, atomic
+	db       int    // the database selected in the stream, -1 if unset
+	replicas map[uint64]*replica
+	acks     chan struct{} // closed on acknowledgements, see acked
+	mu       sync.Mutex
+	n        int32
+}
+
+type replica struct {
+	client *Client
+	feed   chan []byte
+	ack    int64 // the acknowledged offset
+}
+
+// active returns true if there are any replicas.
+func (s *replicaSet) active() bool {
+	return atomic.LoadInt32(&s.n) != 0
+}
+
+// add starts replication to c, returns false if c is a replica already.
+// It returns the offset at which the replica starts.
+func (s *replicaSet) add(c *Client) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.replicas[c.ID()]; ok {
+		return 0, false
+	}
+	if s.replicas == nil {
+		s.replicas = make(map[uint64]*replica)
+	}
+
+	r := &replica{client: c, feed: make(chan []byte, replicaFeedSize)}
+	s.replicas[c.ID()] = r
+	s.db = -1 // the replica starts without a selected database
+	atomic.AddInt32(&s.n, 1)
+
+	go r.loop()
+	return atomic.LoadInt64(&s.offset), true
+}
+
+// has returns true if the client is a replica.
+func (s *replicaSet) has(clientID uint64) bool {
+	if !s.active() {
+		return false
+	}
+
+	s.mu.Lock()
+	_, ok := s.replicas[clientID]
+	s.mu.Unlock()
+	return ok
+}
+
+// remove stops replication to the client.
+func (s *replicaSet) remove(clientID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.replicas[clientID]; ok {
+		delete(s.replicas, clientID)
+		atomic.AddInt32(&s.n, -1)
+		close(r.feed)
+	}
+}
+
+// feed appends a command to the replication stream, without blocking.
+// Returns the offset of the stream thereafter and the replicas which could
+// not keep up.
+func (s *replicaSet) feed(db int, name string, args []resp.CommandArgument) (int64, []*Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf []byte
+	if db != s.db {
+		buf = appendCommand(buf, "select", resp.CommandArgument(strconv.Itoa(db)))
+		s.db = db
+	}
+	buf = appendCommand(buf, name, args...)
+	return s.send(buf)
+}
+
+// getAck asks replicas to acknowledge the offset they have processed.
+func (s *replicaSet) getAck() []*Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, slow := s.send(appendCommand(nil, "replconf", resp.CommandArgument("GETACK"), resp.CommandArgument("*")))
+	return slow
+}
+
+// send appends buf to the stream, must be called with the lock held.
+func (s *replicaSet) send(buf []byte) (offset int64, slow []*Client) {
+	offset = atomic.AddInt64(&s.offset, int64(len(buf)))
+	for _, r := range s.replicas {
+		select {
+		case r.feed <- buf:
+		default:
+			slow = append(slow, r.client)
+		}
+	}
+	return
+}
+
+// ack records the offset acknowledged by a replica.
+func (s *replicaSet) ack(clientID uint64, offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.replicas[clientID]; ok && offset > r.ack {
+		r.ack = offset
+		if s.acks != nil {
+			close(s.acks)
+			s.acks = nil
+		}
+	}
+}
+
+// acked returns the number of replicas which have acknowledged offset
+// and a channel, which is closed on the next acknowledgement.
+func (s *replicaSet) acked(offset int64) (int, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, r := range s.replicas {
+		if r.ack >= offset {
+			n++
+		}
+	}
+	if s.acks == nil {
+		s.acks = make(chan struct{})
+	}
+	return n, s.acks
+}
+
+func (r *replica) loop() {
+	for buf := range r.feed {
+		buf := buf
+		if err := r.client.push(func(w resp.ResponseWriter) { w.AppendRaw(buf) }); err != nil {
+			return
+		}
+	}
+}
+
+// appendCommand appends the command, encoded as a RESP array.
+func appendCommand(dst []byte, name string, args ...resp.CommandArgument) []byte {
+	dst = append(dst, '*')
+	dst = strconv.AppendInt(dst, int64(len(args)+1), 10)
+	dst = append(dst, "\r\n$"...)
+	dst = strconv.AppendInt(dst, int64(len(name)), 10)
+	dst = append(dst, "\r\n"...)
+	dst = append(dst, name...)
+	dst = append(dst, "\r\n"...)
+	for _, arg := range args {
+		dst = append(dst, '$')
+		dst = strconv.AppendInt(dst, int64(len(arg)), 10)
+		dst = append(dst, "\r\n"...)
+		dst = append(dst, arg...)
+		dst = append(dst, "\r\n"...)
+	}
+	return dst
+}
+
+// --------------------------------------------------------------------
+
+// HandleReplication enables the primary side of redis' replication
+// protocol. It registers REPLCONF, PSYNC and SYNC handlers, which send
+// the snapshot provided by conf to replicas and then forward the
+// replicated commands. A Replication section is added to INFO.
+// https://redis.io/topics/replication
+func (srv *Server) HandleReplication(conf *ReplicationConfig) {
+	id := make([]byte, 20)
+	_, _ = cryptorand.Read(id)
+
+	srv.replicas.mu.Lock()
+	srv.replicas.conf = conf
+	srv.replicas.id = hex.EncodeToString(id)
+	srv.replicas.mu.Unlock()
+
+	section := srv.info.Fetch("Replication")
+	section.Register("role", info.StaticString("master"))
+	section.Register("connected_slaves", info.Callback(func() string {
+		return strconv.Itoa(int(atomic.LoadInt32(&srv.replicas.n)))
+	}))
+	section.Register("master_replid", info.StaticString(srv.replicas.id))
+	section.Register("master_repl_offset", info.Callback(func() string {
+		return strconv.FormatInt(atomic.LoadInt64(&srv.replicas.offset), 10)
+	}))
+
+	srv.Handle("replconf", replconfCommand(srv))
+	srv.Handle("psync", syncCommand(srv), Arity(3))
+	srv.Handle("sync", syncCommand(srv), Arity(1))
+	srv.Handle("wait", waitCommand(srv), Arity(3))
+}
+
+// ReplicationOffset returns the current offset of the replication stream.
+func (srv *Server) ReplicationOffset() int64 {
+	return atomic.LoadInt64(&srv.replicas.offset)
+}
+
+// WaitReplicas blocks until n replicas have acknowledged the replication
+// offset, or ctx is done. It returns the number of replicas which have
+// acknowledged the offset, e.g. to decide whether a write is durable.
+func (srv *Server) WaitReplicas(ctx context.Context, n int, offset int64) (int, error) {
+	s := &srv.replicas
+	acked, ch := s.acked(offset)
+	if acked >= n {
+		return acked, nil
+	}
+
+	srv.terminateReplicas(s.getAck())
+	for {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			acked, _ = s.acked(offset)
+			return acked, ctx.Err()
+		}
+
+		if acked, ch = s.acked(offset); acked >= n {
+			return acked, nil
+		}
+	}
+}
+
+// Propagate appends a command to the replication stream and the journal,
+// e.g. to replicate the expiry of keys. Commands served by handlers are
+// replicated automatically, see ReplicationConfig.Replicate.
+func (srv *Server) Propagate(db int, name string, args ...string) {
+	j := srv.currentJournal()
+	if !srv.replicas.active() && j == nil {
+		return
+	}
+
+	cargs := make([]resp.CommandArgument, len(args))
+	for i, arg := range args {
+		cargs[i] = resp.CommandArgument(arg)
+	}
+	if j != nil {
+		j.append(db, name, cargs)
+	}
+	if srv.replicas.active() {
+		srv.feedReplicas(db, name, cargs)
+	}
+}
+
+// propagate replicates and journals an executed command, if selected by
+// ReplicationConfig.Replicate and JournalConfig.Journal. Streamed commands
+// are neither replicated nor journaled.
+func (srv *Server) propagate(c *Client, entry *handlerEntry, name string, args []resp.CommandArgument, failed bool) {
+	j := srv.currentJournal()
+	if failed || !srv.replicas.active() && j == nil {
+		return
+	}
+	if _, ok := entry.served.(StreamHandler); ok {
+		return
+	}
+
+	if j != nil && j.wants(entry, name, args) {
+		j.append(c.DB(), name, args)
+	}
+	if !srv.replicas.active() {
+		return
+	}
+
+	if fn := srv.replicas.conf.Replicate; fn != nil {
+		if !fn(name, args) {
+			return
+		}
+	} else if !entry.spec.hasFlag("write") {
+		return
+	}
+	atomic.StoreInt64(&c.replOffset, srv.feedReplicas(c.DB(), name, args))
+}
+
+// feedReplicas sends the command to all replicas and returns the offset of
+// the stream thereafter.
+func (srv *Server) feedReplicas(db int, name string, args []resp.CommandArgument) int64 {
+	offset, slow := srv.replicas.feed(db, name, args)
+	srv.terminateReplicas(slow)
+	return offset
+}
+
+// terminateReplicas disconnects replicas which could not keep up.
+func (srv *Server) terminateReplicas(slow []*Client) {
+	for _, c := range slow {
+		srv.replicas.remove(c.ID())
+		c.terminate()
+	}
+}
+
+// replconfCommand returns a REPLCONF handler.
+func replconfCommand(srv *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN()%2 != 0 {
+			w.AppendError("ERR syntax error")
+			return
+		}
+
+		for i := 0; i < c.ArgN(); i += 2 {
+			switch opt := c.Arg(i).String(); strings.ToLower(opt) {
+			case "ack":
+				offset, err := c.Arg(i + 1).Int()
+				if err != nil {
+					return
+				}
+				if cl := GetClient(c.Context()); cl != nil {
+					srv.replicas.ack(cl.ID(), offset)
+				}
+				return // acknowledgements are not replied to
+			case "listening-port":
+				if _, err := c.Arg(i + 1).Int(); err != nil {
+					w.AppendError("ERR value is not an integer or out of range")
+					return
+				}
+			case "ip-address", "capa", "rdb-only", "rdb-filter-only":
+			default:
+				w.AppendError("ERR Unrecognized REPLCONF option: " + opt)
+				return
+			}
+		}
+		w.AppendOK()
+	})
+}
+
+// syncCommand returns a PSYNC and SYNC handler, which performs a full
+// synchronisation.
+func syncCommand(srv *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		cl := GetClient(c.Context())
+		if cl == nil {
+			w.AppendError("ERR " + strings.ToUpper(c.Name) + " requires a client connection")
+			return
+		}
+
+		s := &srv.replicas
+		offset, ok := s.add(cl)
+		if !ok {
+			return // already a replica, ignored like redis
+		}
+		cl.onRelease(func() { s.remove(cl.ID()) })
+
+		r, size, err := s.conf.Snapshot()
+		if err != nil {
+			s.remove(cl.ID())
+			w.AppendError(resp.ErrorReply(err))
+			return
+		}
+		if rc, ok := r.(io.Closer); ok {
+			defer rc.Close()
+		}
+
+		if strings.EqualFold(c.Name, "psync") {
+			w.AppendInlineString("FULLRESYNC " + s.id + " " + strconv.FormatInt(offset, 10))
+		}
+		if err := w.CopyBulk(r, size); err != nil {
+			// the payload is incomplete, the replica cannot recover
+			s.remove(cl.ID())
+			cl.terminate()
+		}
+	})
+}
+
+// waitCommand returns a WAIT handler, which blocks the client until the
+// given number of replicas have acknowledged its last replicated write.
+// https://redis.io/commands/wait
+func waitCommand(srv *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		n, err := c.Arg(0).Int()
+		if err != nil {
+			w.AppendError("ERR value is not an integer or out of range")
+			return
+		}
+		ms, err := c.Arg(1).Int()
+		if err != nil {
+			w.AppendError("ERR timeout is not an integer or out of range")
+			return
+		} else if ms < 0 {
+			w.AppendError("ERR timeout is negative")
+			return
+		}
+
+		cl := GetClient(c.Context())
+		if cl == nil {
+			w.AppendError("ERR WAIT requires a client connection")
+			return
+		}
+
+		offset := atomic.LoadInt64(&cl.replOffset)
+		if acked, _ := srv.replicas.acked(offset); acked >= int(n) {
+			w.AppendInt(int64(acked))
+			return
+		}
+
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if ms > 0 {
+			ctx, cancel = context.WithTimeout(cl.Context(), time.Duration(ms)*time.Millisecond)
+		} else {
+			ctx, cancel = context.WithCancel(cl.Context())
+		}
+
+		b := cl.Block(0)
+		go func() {
+			defer cancel()
+			go func() {
+				select {
+				case <-b.Done():
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			acked, _ := srv.WaitReplicas(ctx, int(n), offset)
+			b.Resolve(func(w resp.ResponseWriter) { w.AppendInt(int64(acked)) })
+		}()
+	})
+}
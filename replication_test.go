@@ -0,0 +1,195 @@
+package redeo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replication", func() {
+	var subject *Server
+	var lis net.Listener
+	var snapshot string
+
+	var readCmd = func(r resp.ResponseReader) []string {
+		n, err := r.ReadArrayLen()
+		Expect(err).NotTo(HaveOccurred())
+
+		args := make([]string, n)
+		for i := range args {
+			args[i], err = r.ReadBulkString()
+			Expect(err).NotTo(HaveOccurred())
+		}
+		return args
+	}
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		snapshot = "SNAPSHOT"
+		subject = NewServer(nil)
+		subject.Handle("select", Select())
+		subject.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendOK()
+		}, Arity(3), Flags("write"))
+		subject.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendNil()
+		}, Arity(2), Flags("readonly"))
+		subject.HandleFunc("fail", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendError("ERR failed")
+		}, Flags("write"))
+		subject.HandleReplication(&ReplicationConfig{
+			Snapshot: func() (io.Reader, int64, error) {
+				if snapshot == "" {
+					return nil, 0, errors.New("no snapshot")
+				}
+				return strings.NewReader(snapshot), int64(len(snapshot)), nil
+			},
+		})
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should forward write commands to replicas", func() {
+		rcn, rw, rr := dialRaw(lis)
+		defer rcn.Close()
+
+		rw.WriteCmdString("REPLCONF", "listening-port", "6380")
+		rw.WriteCmdString("REPLCONF", "capa", "eof", "capa", "psync2")
+		rw.WriteCmdString("PSYNC", "?", "-1")
+		Expect(rw.Flush()).To(Succeed())
+		Expect(rr.ReadInlineString()).To(Equal("OK"))
+		Expect(rr.ReadInlineString()).To(Equal("OK"))
+		Expect(rr.ReadInlineString()).To(MatchRegexp(`^FULLRESYNC [0-9a-f]{40} 0$`))
+		Expect(rr.ReadBulkString()).To(Equal("SNAPSHOT"))
+		Expect(subject.Info().String()).To(ContainSubstring("# Replication\nrole:master\nconnected_slaves:1\n"))
+
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmdString("SET", "foo", "bar")
+		cw.WriteCmdString("GET", "foo")
+		cw.WriteCmdString("FAIL")
+		cw.WriteCmdString("SELECT", "2")
+		cw.WriteCmdString("SET", "baz", "qux")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadNil()).To(Succeed())
+		Expect(cr.ReadError()).To(Equal("ERR failed"))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+		Expect(readCmd(rr)).To(Equal([]string{"select", "0"}))
+		Expect(readCmd(rr)).To(Equal([]string{"set", "foo", "bar"}))
+		Expect(readCmd(rr)).To(Equal([]string{"select", "2"}))
+		Expect(readCmd(rr)).To(Equal([]string{"set", "baz", "qux"}))
+
+		// acknowledgements are not replied to
+		rw.WriteCmdString("REPLCONF", "ACK", "0")
+		Expect(rw.Flush()).To(Succeed())
+
+		subject.Propagate(2, "del", "baz")
+		Expect(readCmd(rr)).To(Equal([]string{"del", "baz"}))
+		Expect(subject.Info().String()).To(MatchRegexp(`master_repl_offset:[1-9]\d*\n`))
+
+		Expect(rcn.Close()).To(Succeed())
+		Eventually(subject.Info().String).Should(ContainSubstring("connected_slaves:0\n"))
+	})
+
+	It("should forward writes of scripts", func() {
+		subject.HandleScripting(mockScriptEngine{
+			"set": func(keys, args []string, call ScriptCall) (interface{}, error) {
+				_, err := call("SET", keys[0], args[0])
+				return nil, err
+			},
+		})
+
+		rcn, rw, rr := dialRaw(lis)
+		defer rcn.Close()
+
+		rw.WriteCmdString("SYNC")
+		Expect(rw.Flush()).To(Succeed())
+		Expect(rr.ReadBulkString()).To(Equal("SNAPSHOT"))
+
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmdString("EVAL", "set", "1", "foo", "bar")
+		cw.WriteCmdString("SET", "baz", "qux")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadNil()).To(Succeed())
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+		Expect(readCmd(rr)).To(Equal([]string{"select", "0"}))
+		Expect(readCmd(rr)).To(Equal([]string{"set", "foo", "bar"}))
+		Expect(readCmd(rr)).To(Equal([]string{"set", "baz", "qux"}))
+	})
+
+	It("should wait for acknowledgements", func() {
+		rcn, rw, rr := dialRaw(lis)
+		defer rcn.Close()
+
+		rw.WriteCmdString("SYNC")
+		Expect(rw.Flush()).To(Succeed())
+		Expect(rr.ReadBulkString()).To(Equal("SNAPSHOT"))
+
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmdString("WAIT", "0", "0")
+		cw.WriteCmdString("SET", "foo", "bar")
+		cw.WriteCmdString("WAIT", "1", "0")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadInt()).To(Equal(int64(1)))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+		Expect(readCmd(rr)).To(Equal([]string{"select", "0"}))
+		Expect(readCmd(rr)).To(Equal([]string{"set", "foo", "bar"}))
+		Expect(readCmd(rr)).To(Equal([]string{"replconf", "GETACK", "*"}))
+
+		rw.WriteCmdString("REPLCONF", "ACK", strconv.FormatInt(subject.ReplicationOffset(), 10))
+		Expect(rw.Flush()).To(Succeed())
+		Expect(cr.ReadInt()).To(Equal(int64(1)))
+
+		// times out with the number of acknowledgements
+		cw.WriteCmdString("WAIT", "2", "20")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadInt()).To(Equal(int64(1)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		n, err := subject.WaitReplicas(ctx, 1, subject.ReplicationOffset()+1)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(n).To(Equal(0))
+
+		cw.WriteCmdString("WAIT", "1", "-1")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadError()).To(Equal("ERR timeout is negative"))
+	})
+
+	It("should fail without snapshots", func() {
+		snapshot = ""
+
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmdString("SYNC")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadError()).To(Equal("ERR no snapshot"))
+		Expect(subject.Info().String()).To(ContainSubstring("connected_slaves:0\n"))
+	})
+
+})
@@ -0,0 +1,234 @@
+package resp
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const errMsgNotStruct = "destination not a pointer to a struct"
+
+var (
+	errSyntax     = errors.New("ERR syntax error")
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	errNotFloat   = errors.New("ERR value is not a valid float")
+	errNotTime    = errors.New("ERR value is not a valid time")
+)
+
+// ScanCommand populates the struct pointed to by dst from the arguments
+// of cmd. Fields tagged with the "pos" option, e.g. `redis:"key,pos"`, are
+// assigned positionally in declaration order, a trailing slice consumes
+// all remaining arguments. The remaining arguments are options, matched by
+// field name, case-insensitively, e.g. "EX 10 NX": bool fields are set if
+// an option is present, other fields take the value from the subsequent
+// argument, slices may be repeated. Pointer fields are only allocated if
+// an option is present.
+//
+// time.Duration fields are parsed as seconds, or milliseconds with the
+// "ms" tag option. time.Time fields are parsed as unix milliseconds, or
+// RFC3339 with the "rfc3339" tag option.
+//
+// Errors caused by invalid arguments can be used as error replies.
+func ScanCommand(cmd *Command, dst interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Struct {
+		return scanErrf(dst, errMsgNotStruct)
+	}
+	dv = dv.Elem()
+
+	var pos, opts []structField
+	for _, f := range cachedFields(dv.Type()) {
+		if f.hasOpt("pos") {
+			pos = append(pos, f)
+		} else {
+			opts = append(opts, f)
+		}
+	}
+	for i, f := range pos {
+		variadic := i == len(pos)-1 && isArgSlice(f.typ)
+		if !variadic && !canScanArg(f.typ) {
+			return scanErrf(dst, "unsupported field %s of type %s", f.name, f.typ)
+		}
+	}
+	for _, f := range opts {
+		if !canScanArg(f.typ) && !isArgSlice(f.typ) {
+			return scanErrf(dst, "unsupported field %s of type %s", f.name, f.typ)
+		}
+	}
+
+	args := cmd.Args
+	for i, f := range pos {
+		fv, _ := fieldByIndex(dv, f.index, true)
+		if i == len(pos)-1 && isArgSlice(f.typ) {
+			for len(args) != 0 {
+				if err := scanArg(fv, args[0], &f); err != nil {
+					return err
+				}
+				args = args[1:]
+			}
+			break
+		}
+		if len(args) == 0 {
+			return errors.New("ERR wrong number of arguments for '" + cmd.Name + "' command")
+		}
+		if err := scanArg(fv, args[0], &f); err != nil {
+			return err
+		}
+		args = args[1:]
+	}
+
+	for len(args) != 0 {
+		f := findField(opts, args[0].String())
+		if f == nil {
+			return errSyntax
+		}
+		fv, _ := fieldByIndex(dv, f.index, true)
+		args = args[1:]
+
+		if isFlag(f.typ) {
+			if fv.Kind() == reflect.Ptr {
+				fv.Set(reflect.New(fv.Type().Elem()))
+				fv = fv.Elem()
+			}
+			fv.SetBool(true)
+			continue
+		}
+		if len(args) == 0 {
+			return errSyntax
+		}
+		if err := scanArg(fv, args[0], f); err != nil {
+			return err
+		}
+		args = args[1:]
+	}
+	return nil
+}
+
+func findField(fields []structField, name string) *structField {
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// isFlag returns true if t is a bool or a pointer to a bool.
+func isFlag(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Bool
+}
+
+// isArgSlice returns true if t is a slice of scannable elements,
+// excluding byte slices.
+func isArgSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 && canScanArg(t.Elem())
+}
+
+// canScanArg returns true if a single argument can be scanned into t.
+func canScanArg(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+// scanArg assigns arg to v. Slices of arguments are appended to.
+func scanArg(v reflect.Value, arg CommandArgument, f *structField) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		nv := reflect.New(v.Type().Elem())
+		if err := scanArg(nv.Elem(), arg, f); err != nil {
+			return err
+		}
+		v.Set(nv)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if err := scanArg(ev, arg, f); err != nil {
+				return err
+			}
+			v.Set(reflect.Append(v, ev))
+			return nil
+		}
+
+		// copy, arguments are only valid until the next command is read
+		v.SetBytes(append([]byte(nil), arg...))
+		return nil
+	}
+
+	if v.Type() == timeType {
+		if f.hasOpt("rfc3339") {
+			t, err := time.Parse(time.RFC3339Nano, arg.String())
+			if err != nil {
+				return errNotTime
+			}
+			v.Set(reflect.ValueOf(t))
+			return nil
+		}
+
+		ms, err := arg.Int()
+		if err != nil {
+			return errNotInteger
+		}
+		v.Set(reflect.ValueOf(time.Unix(ms/1000, ms%1000*int64(time.Millisecond))))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(arg.String())
+	case reflect.Bool:
+		switch arg.String() {
+		case "1":
+			v.SetBool(true)
+		case "0":
+			v.SetBool(false)
+		default:
+			return errSyntax
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(arg.String(), 10, v.Type().Bits())
+		if err != nil {
+			return errNotInteger
+		}
+		if v.Type() == durationType {
+			if f.hasOpt("ms") {
+				n *= int64(time.Millisecond)
+			} else {
+				n *= int64(time.Second)
+			}
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(arg.String(), 10, v.Type().Bits())
+		if err != nil {
+			return errNotInteger
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(arg.String(), v.Type().Bits())
+		if err != nil {
+			return errNotFloat
+		}
+		v.SetFloat(n)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package resp_test
+
+import (
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ScanCommand", func() {
+
+	type expiry struct {
+		EX *time.Duration `redis:"ex"`
+		PX time.Duration  `redis:"px,ms"`
+	}
+
+	type setArgs struct {
+		expiry
+
+		Key     string `redis:"key,pos"`
+		Value   []byte `redis:"value,pos"`
+		NX      bool   `redis:"nx"`
+		XX      bool   `redis:"xx"`
+		KeepTTL bool
+		hidden  string
+	}
+
+	var cmd = func(args ...string) *resp.Command {
+		c := resp.NewCommand("set")
+		for _, arg := range args {
+			c.Args = append(c.Args, resp.CommandArgument(arg))
+		}
+		return c
+	}
+
+	It("should scan positional arguments and options", func() {
+		var dst setArgs
+		Expect(resp.ScanCommand(cmd("k", "v", "ex", "10", "NX", "keepttl"), &dst)).To(Succeed())
+		Expect(dst.Key).To(Equal("k"))
+		Expect(dst.Value).To(Equal([]byte("v")))
+		Expect(dst.EX).NotTo(BeNil())
+		Expect(*dst.EX).To(Equal(10 * time.Second))
+		Expect(dst.PX).To(Equal(time.Duration(0)))
+		Expect(dst.NX).To(BeTrue())
+		Expect(dst.XX).To(BeFalse())
+		Expect(dst.KeepTTL).To(BeTrue())
+
+		dst = setArgs{}
+		Expect(resp.ScanCommand(cmd("k", "v", "PX", "250"), &dst)).To(Succeed())
+		Expect(dst.EX).To(BeNil())
+		Expect(dst.PX).To(Equal(250 * time.Millisecond))
+	})
+
+	It("should copy byte arguments", func() {
+		c := cmd("k", "v")
+		var dst setArgs
+		Expect(resp.ScanCommand(c, &dst)).To(Succeed())
+		c.Args[1][0] = 'x'
+		Expect(dst.Value).To(Equal([]byte("v")))
+	})
+
+	It("should scan variadic arguments", func() {
+		var dst struct {
+			Key    string   `redis:"key,pos"`
+			Fields []string `redis:"fields,pos"`
+		}
+		Expect(resp.ScanCommand(cmd("k", "a", "b"), &dst)).To(Succeed())
+		Expect(dst.Key).To(Equal("k"))
+		Expect(dst.Fields).To(Equal([]string{"a", "b"}))
+	})
+
+	It("should scan repeated and typed options", func() {
+		type limit struct {
+			Count uint8 `redis:"count"`
+		}
+		var dst struct {
+			*limit
+			Match []string  `redis:"match"`
+			Score float64   `redis:"score"`
+			Since time.Time `redis:"since"`
+			Until time.Time `redis:"until,rfc3339"`
+		}
+		Expect(resp.ScanCommand(cmd("MATCH", "a*", "match", "b*", "score", "1.5", "since", "1556964672013", "until", "2019-05-04T10:11:12Z"), &dst)).To(Succeed())
+		Expect(dst.limit).To(BeNil())
+		Expect(dst.Match).To(Equal([]string{"a*", "b*"}))
+		Expect(dst.Score).To(Equal(1.5))
+		Expect(dst.Since.UTC()).To(Equal(time.Date(2019, 5, 4, 10, 11, 12, 13000000, time.UTC)))
+		Expect(dst.Until).To(Equal(time.Date(2019, 5, 4, 10, 11, 12, 0, time.UTC)))
+	})
+
+	It("should allocate exported embedded pointers", func() {
+		type Limit struct {
+			Count int `redis:"count"`
+		}
+		var dst struct{ *Limit }
+		Expect(resp.ScanCommand(cmd("count", "5"), &dst)).To(Succeed())
+		Expect(dst.Limit).NotTo(BeNil())
+		Expect(dst.Count).To(Equal(5))
+	})
+
+	It("should reject invalid arguments", func() {
+		var dst setArgs
+		Expect(resp.ScanCommand(cmd("k"), &dst)).To(MatchError("ERR wrong number of arguments for 'set' command"))
+		Expect(resp.ScanCommand(cmd("k", "v", "bogus"), &dst)).To(MatchError("ERR syntax error"))
+		Expect(resp.ScanCommand(cmd("k", "v", "hidden", "x"), &dst)).To(MatchError("ERR syntax error"))
+		Expect(resp.ScanCommand(cmd("k", "v", "ex"), &dst)).To(MatchError("ERR syntax error"))
+		Expect(resp.ScanCommand(cmd("k", "v", "ex", "ten"), &dst)).To(MatchError("ERR value is not an integer or out of range"))
+
+		var typed struct {
+			N uint8     `redis:"n"`
+			F float32   `redis:"f"`
+			T time.Time `redis:"t,rfc3339"`
+		}
+		Expect(resp.ScanCommand(cmd("n", "256"), &typed)).To(MatchError("ERR value is not an integer or out of range"))
+		Expect(resp.ScanCommand(cmd("f", "x"), &typed)).To(MatchError("ERR value is not a valid float"))
+		Expect(resp.ScanCommand(cmd("t", "yesterday"), &typed)).To(MatchError("ERR value is not a valid time"))
+	})
+
+	It("should reject unsupported destinations", func() {
+		var dst setArgs
+		Expect(resp.ScanCommand(cmd(), dst)).To(MatchError(`resp: error on Scan into resp_test.setArgs: destination not a pointer to a struct`))
+		Expect(resp.ScanCommand(cmd(), new(string))).To(MatchError(`resp: error on Scan into *string: destination not a pointer to a struct`))
+
+		var bad struct {
+			Ch chan int `redis:"ch"`
+		}
+		Expect(resp.ScanCommand(cmd(), &bad)).To(MatchError(`resp: error on Scan into *struct { Ch chan int "redis:\"ch\"" }: unsupported field ch of type chan int`))
+
+		var early struct {
+			Keys []string `redis:"keys,pos"`
+			Last string   `redis:"last,pos"`
+		}
+		Expect(resp.ScanCommand(cmd("a", "b"), &early)).To(MatchError(ContainSubstring("unsupported field keys of type []string")))
+	})
+
+})
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -13,6 +16,12 @@ type bufioR struct {
 	buf []byte
 
 	r, w int
+
+	maxBulk  int64 // maximum bulk length, unlimited if zero
+	maxArray int   // maximum multibulk length, unlimited if zero
+
+	names   map[string]string // interned command names
+	scratch []byte            // scratch buffer for inline command names
 }
 
 // Buffered returns the number of buffered bytes
@@ -50,6 +59,22 @@ func (b *bufioR) PeekType() (t ResponseType, err error) {
 		t = TypeError
 	case ':':
 		t = TypeInt
+	case '_':
+		t = TypeNil
+	case '%':
+		t = TypeMap
+	case ',':
+		t = TypeDouble
+	case '#':
+		t = TypeBool
+	case '(':
+		t = TypeBigInt
+	case '~':
+		t = TypeSet
+	case '>':
+		t = TypePush
+	case '|':
+		t = TypeAttribute
 	}
 	return
 }
@@ -59,12 +84,87 @@ func (b *bufioR) ReadNil() error {
 	if err != nil {
 		return err
 	}
+	if len(line) >= 3 && bytes.Equal(line[:3], binNULL) {
+		return nil
+	}
 	if len(line) < 3 || !bytes.Equal(line[:3], binNIL[:3]) {
 		return errNotANilMessage
 	}
 	return nil
 }
 
+func (b *bufioR) ReadMapLen() (int, error) {
+	line, err := b.ReadLine()
+	if err != nil {
+		return 0, err
+	}
+	sz, err := line.ParseSize(line.aggregate('%', '|'), errInvalidMultiBulkLength)
+	if err != nil {
+		return 0, err
+	}
+	return int(sz), b.checkArrayLen(sz)
+}
+
+func (b *bufioR) ReadDouble() (float64, error) {
+	line, err := b.ReadLine()
+	if err != nil {
+		return 0, err
+	}
+	s, err := line.ParseMessage(',')
+	if err != nil {
+		return 0, err
+	}
+
+	switch s {
+	case "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errNotADouble
+	}
+	return f, nil
+}
+
+func (b *bufioR) ReadBool() (bool, error) {
+	line, err := b.ReadLine()
+	if err != nil {
+		return false, err
+	}
+	s, err := line.ParseMessage('#')
+	if err != nil {
+		return false, err
+	}
+
+	switch s {
+	case "t":
+		return true, nil
+	case "f":
+		return false, nil
+	}
+	return false, errNotABool
+}
+
+func (b *bufioR) ReadBigInt() (*big.Int, error) {
+	line, err := b.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	s, err := line.ParseMessage('(')
+	if err != nil {
+		return nil, err
+	}
+
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, errNotANumber
+	}
+	return n, nil
+}
+
 func (b *bufioR) ReadInt() (int64, error) {
 	line, err := b.ReadLine()
 	if err != nil {
@@ -94,11 +194,11 @@ func (b *bufioR) ReadArrayLen() (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	sz, err := line.ParseSize('*', errInvalidMultiBulkLength)
+	sz, err := line.ParseSize(line.aggregate('*', '~', '>'), errInvalidMultiBulkLength)
 	if err != nil {
 		return 0, err
 	}
-	return int(sz), nil
+	return int(sz), b.checkArrayLen(sz)
 }
 
 func (b *bufioR) ReadBulkLen() (int64, error) {
@@ -106,37 +206,74 @@ func (b *bufioR) ReadBulkLen() (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return line.ParseSize('$', errInvalidBulkLength)
+	sz, err := line.ParseSize('$', errInvalidBulkLength)
+	if err != nil {
+		return 0, err
+	}
+	return sz, b.checkBulkLen(sz)
+}
+
+// SetLimits limits the length of bulks and the number of elements of
+// aggregates. Zero values disable the respective limit.
+func (b *bufioR) SetLimits(maxBulk int64, maxArray int) {
+	b.maxBulk, b.maxArray = maxBulk, maxArray
+}
+
+// checkArrayLen returns an error if sz exceeds the multibulk length limit.
+func (b *bufioR) checkArrayLen(sz int64) error {
+	if b.maxArray > 0 && sz > int64(b.maxArray) {
+		return errMultiBulkTooLong
+	}
+	return nil
+}
+
+// checkBulkLen returns an error if sz exceeds the bulk length limit.
+func (b *bufioR) checkBulkLen(sz int64) error {
+	if b.maxBulk > 0 && sz > b.maxBulk {
+		return errBulkTooLong
+	}
+	return nil
 }
 
 func (b *bufioR) ReadBulk(p []byte) ([]byte, error) {
-	//temp0 := b.Buffered()
-	//fmt.Println("before require buff len is", temp0)
-	//t0 := time.Now()
 	sz, err := b.ReadBulkLen()
 	if err != nil {
 		return p, err
 	}
-	//time0 := time.Since(t0)
-	//t1 := time.Now()
-	if err := b.require(int(sz + 2)); err != nil {
-		return p, err
-	}
-	//temp1 := b.Buffered()
-	//fmt.Println("after require buff len is", temp1)
-	//time1 := time.Since(t1)
-	//t2 := time.Now()
-	p = append(p, b.buf[b.r:b.r+int(sz)]...)
-	//time2 := time.Since(t2)
-	//MyPrint("ReadBulk ReadLen time is ", time0,
-	//	"ReadBulk Require time is", time1,
-	//	"ReadBulk Append time is", time2)
-	//if err := nanolog.Log(LogServerBufio, time0.String(), time1.String(), time2.String()); err != nil {
-	//	fmt.Println("LogServerBufio err", err)
-	//}
+	return b.appendBulk(p, int(sz))
+}
 
-	b.r += int(sz + 2)
+// appendBulk reads the sz bytes of a bulk and its trailing CRLF and
+// appends the data to p. Bulks larger than the buffer are read in chunks,
+// so memory grows with the data received rather than the announced length.
+func (b *bufioR) appendBulk(p []byte, sz int) ([]byte, error) {
+	if sz <= len(b.buf)-2 {
+		if err := b.require(sz + 2); err != nil {
+			return p, err
+		}
+		p = append(p, b.buf[b.r:b.r+sz]...)
+		b.r += sz + 2
+		return p, nil
+	}
 
+	for sz > 0 {
+		if b.Buffered() == 0 {
+			if err := b.fill(); err != nil && b.Buffered() == 0 {
+				return p, err
+			}
+		}
+		n := b.Buffered()
+		if n > sz {
+			n = sz
+		}
+		p = append(p, b.buf[b.r:b.r+n]...)
+		b.r += n
+		sz -= n
+	}
+	if err := b.require(2); err != nil {
+		return p, err
+	}
+	b.r += 2
 	return p, nil
 }
 
@@ -149,12 +286,82 @@ func (b *bufioR) StreamBulk() (AllReadCloser, error) {
 	return newBulkReader(b, sz), nil
 }
 
+// ReadRaw reads the next response, including nested elements, and
+// appends it to p as it was received, without decoding values.
+func (b *bufioR) ReadRaw(p []byte) ([]byte, error) {
+	line, err := b.PeekLine(0)
+	if err != nil {
+		return p, err
+	}
+	data := line.Trim()
+	if len(data) == 0 {
+		return p, errBadResponseType
+	}
+
+	// nil bulks and arrays
+	if len(data) == 3 && data[1] == '-' && data[2] == '1' && (data[0] == '$' || data[0] == '*') {
+		p = append(p, line...)
+		b.r += len(line)
+		return p, nil
+	}
+
+	nested := 0
+	switch data[0] {
+	case '$', '=', '!':
+		sz, err := line.ParseSize(data[0], errInvalidBulkLength)
+		if err != nil {
+			return p, err
+		}
+		if err := b.checkBulkLen(sz); err != nil {
+			return p, err
+		}
+		p = append(p, line...)
+		b.r += len(line)
+		if p, err = b.appendBulk(p, int(sz)); err != nil {
+			return p, err
+		}
+		return append(p, '\r', '\n'), nil
+	case '*', '~', '>', '%', '|':
+		sz, err := line.ParseSize(data[0], errInvalidMultiBulkLength)
+		if err != nil {
+			return p, err
+		}
+		if err := b.checkArrayLen(sz); err != nil {
+			return p, err
+		}
+		nested = int(sz)
+		switch data[0] {
+		case '%':
+			nested *= 2
+		case '|':
+			// attributes precede the actual response
+			nested = nested*2 + 1
+		}
+	case '+', '-', ':', '_', ',', '#', '(':
+	default:
+		return p, errBadResponseType
+	}
+
+	p = append(p, line...)
+	b.r += len(line)
+	for i := 0; i < nested; i++ {
+		if p, err = b.ReadRaw(p); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
 func (b *bufioR) ReadBulkString() (string, error) {
 	sz, err := b.ReadBulkLen()
 	if err != nil {
 		return "", err
 	}
 
+	if int(sz) > len(b.buf)-2 {
+		p, err := b.appendBulk(nil, int(sz))
+		return string(p), err
+	}
 	if err := b.require(int(sz + 2)); err != nil {
 		return "", err
 	}
@@ -165,6 +372,23 @@ func (b *bufioR) ReadBulkString() (string, error) {
 	return s, nil
 }
 
+// ReadBulkName reads a bulk string, interning it, see intern.
+func (b *bufioR) ReadBulkName() (string, error) {
+	sz, err := b.ReadBulkLen()
+	if err != nil {
+		return "", err
+	}
+
+	if err := b.require(int(sz + 2)); err != nil {
+		return "", err
+	}
+
+	s := b.intern(b.buf[b.r : b.r+int(sz)])
+	b.r += int(sz + 2)
+
+	return s, nil
+}
+
 func (b *bufioR) SkipBulk() error {
 	sz, err := b.ReadBulkLen()
 	if err != nil {
@@ -211,14 +435,15 @@ func (b *bufioR) PeekN(offset, n int) ([]byte, error) {
 	return b.buf[b.r+offset : b.r+offset+n], nil
 }
 
-// PeekLine returns the next line until CRLF without reading it
+// PeekLine returns the next line until CRLF without reading it. Lines
+// terminated by a bare LF are accepted too, as sent by netcat and alike.
 func (b *bufioR) PeekLine(offset int) (bufioLn, error) {
 	index := -1
 
 	// try to find the end of the line
 	start := b.r + offset
 	if start < b.w {
-		index = bytes.IndexByte(b.buf[start:b.w], '\r')
+		index = bytes.IndexByte(b.buf[start:b.w], '\n')
 	}
 
 	// try to read more data into the buffer if not in the buffer
@@ -228,27 +453,24 @@ func (b *bufioR) PeekLine(offset int) (bufioLn, error) {
 		}
 		start = b.r + offset
 		if start < b.w {
-			index = bytes.IndexByte(b.buf[start:b.w], '\r')
+			index = bytes.IndexByte(b.buf[start:b.w], '\n')
+		}
+	}
+
+	// CR was read without LF, wait for it
+	if index < 0 && start < b.w && b.buf[b.w-1] == '\r' {
+		if err := b.require(offset + b.w - start + 1); err != nil {
+			return nil, err
 		}
+		start = b.r + offset
+		index = bytes.IndexByte(b.buf[start:b.w], '\n')
 	}
 
 	// fail if still nothing found
 	if index < 0 {
 		return nil, errInlineRequestTooLong
 	}
-
-	// FIXED: Ensure there is (offset + index + 2) to read.
-	if (start + index + 1) >= b.w {
-		// fmt.Println("CR read without LF")
-		b.require(offset + index + 2)
-		start = b.r + offset
-	}
-	// Proved to be not a problem
-	// if b.buf[start + index + 1] != '\n' {
-	// 	fmt.Printf("Detected non LF after CR: %v", b.buf[start : start + index + 2])
-	// 	return bufioLn(b.buf[start : start + index + 1]), nil
-	// }
-	return bufioLn(b.buf[start : start + index + 2]), nil
+	return bufioLn(b.buf[start : start+index+1]), nil
 }
 
 // ReadLine returns the next line until CRLF
@@ -314,7 +536,26 @@ func (b *bufioR) compact() {
 }
 
 func (b *bufioR) reset(buf []byte, rd io.Reader) {
-	*b = bufioR{buf: buf, rd: rd}
+	*b = bufioR{buf: buf, rd: rd, names: b.names, scratch: b.scratch[:0]}
+}
+
+// intern returns p as a string. Short strings are interned, so repeated
+// command names do not allocate. Once full, the table is replaced,
+// to bound the memory retained by clients sending arbitrary names.
+func (b *bufioR) intern(p []byte) string {
+	if len(p) > maxInternedLen {
+		return string(p)
+	}
+	if s, ok := b.names[string(p)]; ok {
+		return s
+	}
+
+	if b.names == nil || len(b.names) >= maxInterned {
+		b.names = make(map[string]string)
+	}
+	s := string(p)
+	b.names[s] = s
+	return s
 }
 
 // --------------------------------------------------------------------
@@ -366,6 +607,14 @@ func (b *bulkReader) Read(p []byte) (n int, err error) {
 
 func (b *bulkReader) Len() int64 { return b.len }
 
+// Offset returns the number of bytes read.
+func (b *bulkReader) Offset() int64 {
+	if b.n < 2 {
+		return b.len
+	}
+	return b.len - (b.n - 2)
+}
+
 func (b *bulkReader) ReadAll() ([]byte, error) {
 	p := make([]byte, b.len)
 	n, err := io.ReadFull(b, p)
@@ -423,6 +672,10 @@ func (ln bufioLn) Trim() bufioLn {
 
 // FirstWord return the first word
 func (ln bufioLn) FirstWord() string {
+	return string(ln.firstWord())
+}
+
+func (ln bufioLn) firstWord() []byte {
 	offset := 0
 	inWord := false
 	data := ln.Trim()
@@ -430,7 +683,7 @@ func (ln bufioLn) FirstWord() string {
 	for i, c := range data {
 		if asciiSpace[c] {
 			if inWord {
-				return string(data[offset:i])
+				return data[offset:i]
 			}
 			inWord = false
 		} else {
@@ -440,7 +693,7 @@ func (ln bufioLn) FirstWord() string {
 			inWord = true
 		}
 	}
-	return string(data[offset:])
+	return data[offset:]
 }
 
 // ParseInt parses an int
@@ -478,6 +731,19 @@ func (ln bufioLn) ParseMessage(prefix byte) (string, error) {
 }
 
 // ParseSize parses a size with prefix
+// aggregate returns the prefix of the line if it is one of the given
+// aggregate prefixes, otherwise the first one.
+func (ln bufioLn) aggregate(prefixes ...byte) byte {
+	if data := ln.Trim(); len(data) != 0 {
+		for _, c := range prefixes {
+			if data[0] == c {
+				return c
+			}
+		}
+	}
+	return prefixes[0]
+}
+
 func (ln bufioLn) ParseSize(prefix byte, fallback error) (int64, error) {
 	data := ln.Trim()
 
@@ -507,8 +773,10 @@ func (ln bufioLn) ParseSize(prefix byte, fallback error) (int64, error) {
 
 type bufioW struct {
 	io.Writer
-	buf []byte
-	mu  sync.Mutex
+	buf   []byte
+	mu    sync.Mutex
+	proto int
+	err   error // sticky, set when a bulk copy was cut short
 }
 
 // Buffered returns the number of buffered bytes
@@ -570,7 +838,7 @@ func (b *bufioW) AppendBulkString(s string) {
 func (b *bufioW) AppendInline(p []byte) {
 	b.mu.Lock()
 	b.buf = append(b.buf, '+')
-	b.buf = append(b.buf, p...)
+	b.buf = appendLine(b.buf, p)
 	b.buf = append(b.buf, binCRLF...)
 	b.mu.Unlock()
 }
@@ -579,7 +847,7 @@ func (b *bufioW) AppendInline(p []byte) {
 func (b *bufioW) AppendInlineString(s string) {
 	b.mu.Lock()
 	b.buf = append(b.buf, '+')
-	b.buf = append(b.buf, s...)
+	b.buf = appendLineString(b.buf, s)
 	b.buf = append(b.buf, binCRLF...)
 	b.mu.Unlock()
 }
@@ -588,7 +856,7 @@ func (b *bufioW) AppendInlineString(s string) {
 func (b *bufioW) AppendError(msg string) {
 	b.mu.Lock()
 	b.buf = append(b.buf, '-')
-	b.buf = append(b.buf, msg...)
+	b.buf = appendLineString(b.buf, msg)
 	b.buf = append(b.buf, binCRLF...)
 	b.mu.Unlock()
 }
@@ -617,7 +885,135 @@ func (b *bufioW) AppendInt(n int64) {
 // AppendNil appends a nil-value to the output buffer
 func (b *bufioW) AppendNil() {
 	b.mu.Lock()
-	b.buf = append(b.buf, binNIL...)
+	if b.proto == RESP3 {
+		b.buf = append(b.buf, binNULL...)
+	} else {
+		b.buf = append(b.buf, binNIL...)
+	}
+	b.mu.Unlock()
+}
+
+// AppendMapLen appends a map header to the output buffer
+func (b *bufioW) AppendMapLen(n int) {
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.appendSize('%', int64(n))
+	} else {
+		b.appendSize('*', int64(n)*2)
+	}
+	b.mu.Unlock()
+}
+
+// AppendSetLen appends a set header to the output buffer
+func (b *bufioW) AppendSetLen(n int) {
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.appendSize('~', int64(n))
+	} else {
+		b.appendSize('*', int64(n))
+	}
+	b.mu.Unlock()
+}
+
+// AppendPushLen appends a push message header to the output buffer
+func (b *bufioW) AppendPushLen(n int) {
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.appendSize('>', int64(n))
+	} else {
+		b.appendSize('*', int64(n))
+	}
+	b.mu.Unlock()
+}
+
+// AppendAttributeLen appends an attribute header to the output buffer
+func (b *bufioW) AppendAttributeLen(n int) {
+	b.mu.Lock()
+	b.appendSize('|', int64(n))
+	b.mu.Unlock()
+}
+
+// AppendDouble appends a floating point number to the output buffer
+func (b *bufioW) AppendDouble(f float64) {
+	var s string
+	switch {
+	case math.IsInf(f, 1):
+		s = "inf"
+	case math.IsInf(f, -1):
+		s = "-inf"
+	default:
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.buf = append(b.buf, ',')
+		b.buf = append(b.buf, s...)
+		b.buf = append(b.buf, binCRLF...)
+	} else {
+		b.appendSize('$', int64(len(s)))
+		b.buf = append(b.buf, s...)
+		b.buf = append(b.buf, binCRLF...)
+	}
+	b.mu.Unlock()
+}
+
+// AppendBool appends a boolean to the output buffer
+func (b *bufioW) AppendBool(v bool) {
+	b.mu.Lock()
+	switch {
+	case b.proto == RESP3 && v:
+		b.buf = append(b.buf, binTRUE...)
+	case b.proto == RESP3:
+		b.buf = append(b.buf, binFALSE...)
+	case v:
+		b.buf = append(b.buf, binONE...)
+	default:
+		b.buf = append(b.buf, binZERO...)
+	}
+	b.mu.Unlock()
+}
+
+// AppendBigInt appends a big number to the output buffer
+func (b *bufioW) AppendBigInt(n *big.Int) {
+	s := n.String()
+
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.buf = append(b.buf, '(')
+		b.buf = append(b.buf, s...)
+		b.buf = append(b.buf, binCRLF...)
+	} else {
+		b.appendSize('$', int64(len(s)))
+		b.buf = append(b.buf, s...)
+		b.buf = append(b.buf, binCRLF...)
+	}
+	b.mu.Unlock()
+}
+
+// Protocol returns the protocol version
+func (b *bufioW) Protocol() int {
+	b.mu.Lock()
+	v := b.proto
+	b.mu.Unlock()
+
+	if v == RESP3 {
+		return RESP3
+	}
+	return RESP2
+}
+
+// SetProtocol sets the protocol version
+func (b *bufioW) SetProtocol(v int) {
+	b.mu.Lock()
+	b.proto = v
+	b.mu.Unlock()
+}
+
+// AppendRaw appends pre-encoded data to the output buffer
+func (b *bufioW) AppendRaw(p []byte) {
+	b.mu.Lock()
+	b.buf = append(b.buf, p...)
 	b.mu.Unlock()
 }
 
@@ -629,15 +1025,25 @@ func (b *bufioW) AppendOK() {
 }
 
 // CopyBulk flushes the existing buffer and read n bytes from the reader directly to
-// the client connection.
+// the client connection. If the underlying writer implements io.ReaderFrom, it
+// is used for the copy, allowing e.g. sendfile for *os.File sources. A short read
+// leaves the stream in a broken state and makes all subsequent writes fail.
 func (b *bufioW) CopyBulk(src io.Reader, n int64) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if b.err != nil {
+		return b.err
+	}
+
 	b.appendSize('$', n)
 	if start := len(b.buf); int64(cap(b.buf)-start) >= n+2 {
 		b.buf = b.buf[:start+int(n)]
 		if _, err := io.ReadFull(src, b.buf[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			b.err = err
 			return err
 		}
 
@@ -650,9 +1056,13 @@ func (b *bufioW) CopyBulk(src io.Reader, n int64) error {
 	}
 
 	b.buf = b.buf[:cap(b.buf)]
-	_, err := io.CopyBuffer(b, io.LimitReader(src, int64(n)), b.buf)
+	m, err := io.CopyBuffer(b.Writer, io.LimitReader(src, n), b.buf)
 	b.buf = b.buf[:0]
+	if err == nil && m < n {
+		err = io.ErrUnexpectedEOF
+	}
 	if err != nil {
+		b.err = err
 		return err
 	}
 
@@ -680,6 +1090,9 @@ func (b *bufioW) Reset(w io.Writer) {
 }
 
 func (b *bufioW) flush() error {
+	if b.err != nil {
+		return b.err
+	}
 	if len(b.buf) == 0 {
 		return nil
 	}
@@ -701,3 +1114,32 @@ func (b *bufioW) appendSize(c byte, n int64) {
 func (b *bufioW) reset(buf []byte, wr io.Writer) {
 	*b = bufioW{buf: buf[:0], Writer: wr}
 }
+
+// appendLine appends the message of a simple string or error, replacing
+// line breaks by spaces, so replies cannot break the protocol.
+func appendLine(buf, p []byte) []byte {
+	if bytes.IndexAny(p, "\r\n") < 0 {
+		return append(buf, p...)
+	}
+	for _, c := range p {
+		if c == '\r' || c == '\n' {
+			c = ' '
+		}
+		buf = append(buf, c)
+	}
+	return buf
+}
+
+func appendLineString(buf []byte, s string) []byte {
+	if strings.IndexAny(s, "\r\n") < 0 {
+		return append(buf, s...)
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\r' || c == '\n' {
+			c = ' '
+		}
+		buf = append(buf, c)
+	}
+	return buf
+}
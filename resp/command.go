@@ -29,6 +29,38 @@ func (c CommandArgument) Int() (int64, error) {
 
 // --------------------------------------------------------------------
 
+// KeySpec describes the positions of key arguments, using redis' notation.
+// Positions start at 1, a negative Last counts from the end of the
+// arguments. A First of 0 denotes commands without keys.
+// https://redis.io/commands/command#first-key-in-argument-list
+type KeySpec struct {
+	First, Last, Step int
+}
+
+// Locate returns the key arguments.
+func (s KeySpec) Locate(args []CommandArgument) []CommandArgument {
+	if s.First < 1 {
+		return nil
+	}
+
+	last := s.Last
+	if last < 0 {
+		last += len(args) + 1
+	}
+	step := s.Step
+	if step < 1 {
+		step = 1
+	}
+
+	var keys []CommandArgument
+	for pos := s.First; pos <= last && pos <= len(args); pos += step {
+		keys = append(keys, args[pos-1])
+	}
+	return keys
+}
+
+// --------------------------------------------------------------------
+
 // Command instances are parsed by a RequestReader
 type Command struct {
 	// Name refers to the command name
@@ -37,7 +69,8 @@ type Command struct {
 	// Args returns arguments
 	Args []CommandArgument
 
-	ctx context.Context
+	ctx  context.Context
+	keys KeySpec
 }
 
 // NewCommand returns a new command instance;
@@ -83,6 +116,17 @@ func (c *Command) SetContext(ctx context.Context) {
 	}
 }
 
+// KeySpec returns the key positions, see Keys.
+func (c *Command) KeySpec() KeySpec { return c.keys }
+
+// SetKeySpec sets the key positions. Servers set them before commands
+// are handled, as registered with the command.
+func (c *Command) SetKeySpec(s KeySpec) { c.keys = s }
+
+// Keys returns the key arguments, as located by the key positions.
+// Returns nil if the command has no key positions.
+func (c *Command) Keys() []CommandArgument { return c.keys.Locate(c.Args) }
+
 func (c *Command) grow(n int) {
 	if d := n - cap(c.Args); d > 0 {
 		c.Args = c.Args[:cap(c.Args)]
@@ -114,10 +158,11 @@ func (c *Command) readInline(r *bufioR) (bool, error) {
 
 	data := line.Trim()
 
-	var name []byte
-	var n int
-
-	name, n = appendArgument(name, data)
+	name, n, err := appendArgument(r.scratch[:0], data)
+	if err != nil {
+		return false, err
+	}
+	r.scratch = name
 	data = data[n:]
 	if len(name) == 0 {
 		return false, nil
@@ -125,11 +170,13 @@ func (c *Command) readInline(r *bufioR) (bool, error) {
 
 	for pos := 0; len(data) != 0; pos++ {
 		c.grow(pos + 1)
-		c.Args[pos], n = appendArgument(c.Args[pos], data)
+		if c.Args[pos], n, err = appendArgument(c.Args[pos], data); err != nil {
+			return false, err
+		}
 		data = data[n:]
 	}
 
-	c.Name = string(name)
+	c.Name = r.intern(name)
 	return true, nil
 }
 
@@ -152,7 +199,7 @@ func readCommand(c interface {
 			return readCommand(c, r)
 		}
 
-		name, err := r.ReadBulkString()
+		name, err := r.ReadBulkName()
 		if err != nil {
 			return err
 		}
@@ -201,7 +248,11 @@ func (c* CommandStreamArgument) Int() (int64, error) {
 
 var errNoMoreArgs = errors.New("resp: no more arguments")
 
-// CommandStream instances are created by a RequestReader
+// CommandStream instances are created by a RequestReader. Arguments are
+// read from the connection in order: Next and NextArg discard any unread
+// bytes of the previous argument, waiting until held readers are
+// released. Large arguments can thus be piped straight to disk without
+// being buffered in memory.
 type CommandStream struct {
 	// Name refers to the command name
 	Name string
@@ -214,10 +265,21 @@ type CommandStream struct {
 	nargs int
 	pos   int
 	arg   AllReadCloser
+	off   int64 // bytes read of the current argument, unless streamed
 
 	rd *bufioR
 }
 
+// NewCommandStream returns a new command stream over
+// the given arguments; useful for tests
+func NewCommandStream(name string, args ...CommandArgument) *CommandStream {
+	return &CommandStream{
+		Name:     name,
+		inline:   Command{Name: name, Args: args},
+		isInline: true,
+	}
+}
+
 // Reset discards all data and resets all state
 func (c *CommandStream) Reset() {
 	c.inline.Reset()
@@ -233,14 +295,7 @@ func (c *CommandStream) Discard() error {
 		}
 	}
 
-	var err error
-	if c.arg != nil {
-		if e := c.arg.Close(); e != nil {
-			err = e
-		}
-		c.arg = nil
-	}
-
+	err := c.closeArg()
 	if c.rd != nil {
 		for ; c.pos < c.nargs; c.pos++ {
 			if e := c.rd.SkipBulk(); e != nil {
@@ -264,7 +319,8 @@ func (c *CommandStream) More() bool {
 	return c.pos < c.ArgN()
 }
 
-// Next returns the next argument as an io.Reader
+// Next returns the next argument as an io.Reader, its declared length is
+// available via Len.
 func (c *CommandStream) Next() (AllReadCloser, error) {
 	if c.ctx != nil {
 		if err := c.ctx.Err(); err != nil {
@@ -274,19 +330,25 @@ func (c *CommandStream) Next() (AllReadCloser, error) {
 	if !c.More() {
 		return nil, errNoMoreArgs
 	}
+	if err := c.closeArg(); err != nil {
+		return nil, err
+	}
 
 	if c.isInline {
 		arg := NewInlineReader(c.inline.Args[c.pos])
+		c.off = arg.Len()
 		c.pos++
 		return arg, nil
 	}
 
 	var err error
+	c.off = 0
 	c.arg, err = c.rd.StreamBulk()
 	c.pos++
 	return c.arg, err
 }
 
+// NextArg reads the next argument into memory.
 func (c *CommandStream) NextArg() *CommandStreamArgument {
 	if c.ctx != nil {
 		if err := c.ctx.Err(); err != nil {
@@ -296,19 +358,44 @@ func (c *CommandStream) NextArg() *CommandStreamArgument {
 	if !c.More() {
 		return &CommandStreamArgument{nil, errNoMoreArgs}
 	}
+	if err := c.closeArg(); err != nil {
+		return &CommandStreamArgument{nil, err}
+	}
 
 	if c.isInline {
 		arg := c.inline.Args[c.pos]
+		c.off = int64(len(arg))
 		c.pos++
 		return &CommandStreamArgument{ arg, nil }
 	}
 
 	var arg CommandArgument
 	arg, err := c.rd.ReadBulk(arg)
+	c.off = int64(len(arg))
 	c.pos++
 	return &CommandStreamArgument{ arg, err }
 }
 
+// Progress returns the index of the argument read last and the number of
+// its bytes read, e.g. to resume interrupted streams. The index is -1
+// before the first argument is read.
+func (c *CommandStream) Progress() (int, int64) {
+	if r, ok := c.arg.(*bulkReader); ok {
+		return c.pos - 1, r.Offset()
+	}
+	return c.pos - 1, c.off
+}
+
+// closeArg discards the unread bytes of the current argument.
+func (c *CommandStream) closeArg() error {
+	if c.arg == nil {
+		return nil
+	}
+	err := c.arg.Close()
+	c.arg = nil
+	return err
+}
+
 // Context returns the context
 func (c *CommandStream) Context() context.Context {
 	if c.ctx != nil {
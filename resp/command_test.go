@@ -1,6 +1,10 @@
 package resp
 
 import (
+	"io"
+	"io/ioutil"
+	"strings"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -16,4 +20,54 @@ var _ = Describe("Command", func() {
 		Expect(ok).To(Equal(true))
 		Expect(extracted.ClientID).To(Equal(ctx.ClientID))
 	})
+
+	It("should stream from arguments", func() {
+		cmd := NewCommandStream("test", CommandArgument("a"), CommandArgument("bc"))
+		Expect(cmd.Name).To(Equal("test"))
+		Expect(cmd.ArgN()).To(Equal(2))
+
+		arg, err := cmd.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(arg.ReadAll()).To(Equal([]byte("a")))
+		Expect(cmd.NextArg().String()).To(Equal("bc"))
+		Expect(cmd.More()).To(BeFalse())
+	})
+
+	It("should report the progress of streams", func() {
+		r := NewRequestReader(strings.NewReader("*3\r\n$6\r\nUPLOAD\r\n$3\r\nkey\r\n$10\r\nhello"))
+		cmd, err := r.StreamCmd(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd.Progress()).To(Equal(-1))
+
+		Expect(cmd.NextArg().String()).To(Equal("key"))
+		arg, off := cmd.Progress()
+		Expect(arg).To(Equal(0))
+		Expect(off).To(Equal(int64(3)))
+
+		src, err := cmd.Next()
+		Expect(err).NotTo(HaveOccurred())
+		buf := make([]byte, 3)
+		Expect(io.ReadFull(src, buf)).To(Equal(3))
+		arg, off = cmd.Progress()
+		Expect(arg).To(Equal(1))
+		Expect(off).To(Equal(int64(3)))
+
+		Expect(ioutil.ReadAll(src)).To(Equal([]byte("lo")))
+		_, off = cmd.Progress()
+		Expect(off).To(Equal(int64(5)))
+		Expect(cmd.Discard()).To(HaveOccurred())
+	})
+
+	It("should locate keys", func() {
+		cmd := NewCommand("mset", CommandArgument("k1"), CommandArgument("v1"), CommandArgument("k2"), CommandArgument("v2"))
+		Expect(cmd.Keys()).To(BeNil())
+
+		cmd.SetKeySpec(KeySpec{First: 1, Last: -1, Step: 2})
+		Expect(cmd.Keys()).To(Equal([]CommandArgument{CommandArgument("k1"), CommandArgument("k2")}))
+		Expect(KeySpec{First: 2, Last: 3}.Locate(cmd.Args)).To(Equal([]CommandArgument{CommandArgument("v1"), CommandArgument("k2")}))
+		Expect(KeySpec{First: 5, Last: 5}.Locate(cmd.Args)).To(BeEmpty())
+
+		cmd.Reset()
+		Expect(cmd.KeySpec()).To(Equal(KeySpec{}))
+	})
 })
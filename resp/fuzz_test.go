@@ -0,0 +1,100 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+var fuzzRequestSeeds = []string{
+	"PING\r\n",
+	"*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n",
+	"*2\r\n$4\r\nECHO\r\n$5\r\nhel",
+	"*2\r\n$4\r\nECHO\r\n$99999999999\r\n",
+	"*99999999999\r\n",
+	"*-1\r\n$-5\r\n",
+	"ECHO \"quoted \\x41rg\" 'single'\r\n",
+	"ECHO \"unbalanced\r\n",
+	"\x00\xff\r\n",
+}
+
+func FuzzRequestReader_ReadCmd(f *testing.F) {
+	for _, seed := range fuzzRequestSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := NewRequestReader(bytes.NewReader(data))
+		r.SetLimits(1<<16, 1024)
+
+		var cmd *Command
+		for n := 0; n <= len(data); n++ {
+			var err error
+			if cmd, err = r.ReadCmd(cmd); err != nil {
+				return
+			}
+		}
+		t.Fatalf("read more commands than bytes from %q", data)
+	})
+}
+
+func FuzzRequestReader_StreamCmd(f *testing.F) {
+	for _, seed := range fuzzRequestSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := NewRequestReader(bytes.NewReader(data))
+		r.SetLimits(1<<16, 1024)
+
+		var cmd *CommandStream
+		for n := 0; n <= len(data); n++ {
+			var err error
+			if cmd, err = r.StreamCmd(cmd); err != nil {
+				return
+			}
+			for cmd.More() {
+				arg, err := cmd.Next()
+				if err != nil {
+					return
+				}
+				_, err = io.Copy(ioutil.Discard, arg)
+				_ = arg.Close()
+				if err != nil {
+					return
+				}
+			}
+		}
+		t.Fatalf("read more commands than bytes from %q", data)
+	})
+}
+
+func FuzzReadReply(f *testing.F) {
+	for _, seed := range []string{
+		"+OK\r\n",
+		"-ERR wrong\r\n",
+		":-12\r\n",
+		"$5\r\nhello\r\n",
+		"$5\r\nhel",
+		"$-1\r\n",
+		"*2\r\n$1\r\na\r\n*1\r\n:1\r\n",
+		"*3\r\n$1\r\na\r\n",
+		"%1\r\n+key\r\n#t\r\n",
+		"~1\r\n,3.14\r\n",
+		">2\r\n+message\r\n_\r\n",
+		"*99999999999\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := NewResponseReader(bytes.NewReader(data))
+		for n := 0; n <= len(data); n++ {
+			if _, err := ReadReply(r); err != nil {
+				return
+			}
+		}
+		t.Fatalf("read more replies than bytes from %q", data)
+	})
+}
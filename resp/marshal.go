@@ -0,0 +1,326 @@
+package resp
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Marshaler values implement custom serialization and can be passed
+// to Marshal.
+type Marshaler interface {
+	// MarshalRESP appends the value to w.
+	MarshalRESP(w ResponseWriter) error
+}
+
+var (
+	marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	timeType      = reflect.TypeOf(time.Time{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+)
+
+// Marshal serializes v and appends it to w. In addition to the values
+// supported by ResponseWriter.Append, it supports pointers, arrays, named
+// types, Marshaler instances and time.Time values, as unix milliseconds.
+// Structs are serialized as maps of field names to values, like HGETALL.
+//
+// Field names can be set via `redis:"name"` tags, fields tagged with
+// `redis:"-"` and unexported fields are skipped. Fields of embedded structs
+// are promoted. With the "rfc3339" tag option, times are serialized as
+// RFC3339 bulk strings instead.
+//
+// Values are validated first, to avoid writing partial replies. Errors
+// returned by Marshaler instances may still leave partial replies.
+func Marshal(w ResponseWriter, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if err := checkMarshal(rv); err != nil {
+		return err
+	}
+	return marshalValue(w, rv, false)
+}
+
+func marshalValue(w ResponseWriter, v reflect.Value, rfc3339 bool) error {
+	if isNil(v) {
+		w.AppendNil()
+		return nil
+	}
+	if m, ok := asMarshaler(v); ok {
+		return m.MarshalRESP(w)
+	}
+	if isAppendable(v) {
+		return w.Append(v.Interface())
+	}
+
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if rfc3339 {
+			w.AppendBulkString(t.Format(time.RFC3339Nano))
+		} else {
+			w.AppendInt(t.Unix()*1000 + int64(t.Nanosecond()/int(time.Millisecond)))
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return marshalValue(w, v.Elem(), rfc3339)
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+		values := make([]reflect.Value, len(fields))
+		n := 0
+		for i, f := range fields {
+			if fv, ok := fieldByIndex(v, f.index, false); ok {
+				values[i] = fv
+				n++
+			}
+		}
+
+		w.AppendMapLen(n)
+		for i, f := range fields {
+			if !values[i].IsValid() {
+				continue
+			}
+			w.AppendBulkString(f.name)
+			if err := marshalValue(w, values[i], f.hasOpt("rfc3339")); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice {
+				w.AppendBulk(v.Bytes())
+			} else {
+				w.AppendBulk(copyArray(v).Elem().Slice(0, v.Len()).Bytes())
+			}
+			return nil
+		}
+
+		w.AppendArrayLen(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := marshalValue(w, v.Index(i), rfc3339); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		w.AppendMapLen(v.Len())
+		for _, key := range v.MapKeys() {
+			if err := marshalValue(w, key, false); err != nil {
+				return err
+			}
+			if err := marshalValue(w, v.MapIndex(key), rfc3339); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		w.AppendBulkString(v.String())
+	case reflect.Bool:
+		return w.Append(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.AppendInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		w.AppendInt(int64(v.Uint()))
+	case reflect.Float32:
+		return w.Append(float32(v.Float()))
+	case reflect.Float64:
+		return w.Append(v.Float())
+	}
+	return nil
+}
+
+// checkMarshal returns an error if v, or any of the values nested in it,
+// cannot be marshaled.
+func checkMarshal(v reflect.Value) error {
+	if isNil(v) {
+		return nil
+	}
+	if _, ok := asMarshaler(v); ok {
+		return nil
+	}
+	if isAppendable(v) || v.Type() == timeType {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return checkMarshal(v.Elem())
+	case reflect.Struct:
+		for _, f := range cachedFields(v.Type()) {
+			if fv, ok := fieldByIndex(v, f.index, false); ok {
+				if err := checkMarshal(fv); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := checkMarshal(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			if err := checkMarshal(key); err != nil {
+				return err
+			}
+			if err := checkMarshal(v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		return fmt.Errorf("resp: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func isNil(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isAppendable returns true for types with built-in serialization,
+// see ResponseWriter.Append.
+func isAppendable(v reflect.Value) bool {
+	if !v.CanInterface() {
+		return false
+	}
+	switch v.Interface().(type) {
+	case CustomResponse, error, *big.Int, []byte, CommandArgument:
+		return true
+	}
+	return false
+}
+
+func asMarshaler(v reflect.Value) (Marshaler, bool) {
+	if v.Type().Implements(marshalerType) && v.CanInterface() {
+		return v.Interface().(Marshaler), true
+	}
+	if v.CanAddr() && reflect.PtrTo(v.Type()).Implements(marshalerType) && v.Addr().CanInterface() {
+		return v.Addr().Interface().(Marshaler), true
+	}
+	return nil, false
+}
+
+// copyArray returns a pointer to an addressable copy of the array v.
+func copyArray(v reflect.Value) reflect.Value {
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr
+}
+
+// --------------------------------------------------------------------
+
+// structField is a field of a struct, as configured by its `redis` tag.
+type structField struct {
+	name  string
+	index []int
+	typ   reflect.Type
+	opts  []string
+}
+
+func (f *structField) hasOpt(opt string) bool {
+	for _, o := range f.opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+var fieldCache sync.Map // map[reflect.Type][]structField
+
+// cachedFields returns the fields of the struct type t.
+func cachedFields(t reflect.Type) []structField {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]structField)
+	}
+	fields, _ := fieldCache.LoadOrStore(t, typeFields(t, nil))
+	return fields.([]structField)
+}
+
+// typeFields returns the fields of t, including the promoted fields of
+// embedded structs. Fields shadow promoted fields of the same name.
+func typeFields(t reflect.Type, index []int) []structField {
+	var fields, promoted []structField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("redis")
+		if tag == "-" {
+			continue
+		}
+
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		path := append(append([]int(nil), index...), i)
+
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+				if sf.PkgPath != "" {
+					continue // unexported, cannot be allocated
+				}
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				promoted = append(promoted, typeFields(ft, path)...)
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, structField{name: name, index: path, typ: sf.Type, opts: opts[1:]})
+	}
+
+	for _, f := range promoted {
+		if !hasField(fields, f.name) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func hasField(fields []structField, name string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldByIndex returns the nested field of v. Nil embedded pointers are
+// allocated if alloc is true, otherwise false is returned.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,133 @@
+package resp_test
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Marshal", func() {
+	var subject resp.ResponseWriter
+	var buf = new(bytes.Buffer)
+
+	type base struct {
+		ID int64 `redis:"id"`
+	}
+
+	type Meta struct {
+		Tags []string `redis:"tags"`
+	}
+
+	type record struct {
+		base
+		*Meta
+
+		Name    string    `redis:"name"`
+		Created time.Time `redis:"created"`
+		Updated time.Time `redis:"updated,rfc3339"`
+		Owner   *string   `redis:"owner"`
+		Secret  string    `redis:"-"`
+		Plain   bool
+		hidden  string
+	}
+
+	ts := time.Date(2019, 5, 4, 10, 11, 12, 13000000, time.UTC)
+
+	BeforeEach(func() {
+		buf.Reset()
+		subject = resp.NewResponseWriter(buf)
+	})
+
+	DescribeTable("success",
+		func(v interface{}, exp string) {
+			Expect(resp.Marshal(subject, v)).To(Succeed())
+			Expect(subject.Flush()).To(Succeed())
+			Expect(strconv.Quote(buf.String())).To(Equal(strconv.Quote(exp)))
+		},
+
+		Entry("nil", nil, "$-1\r\n"),
+		Entry("nil pointer", (*record)(nil), "$-1\r\n"),
+		Entry("string", "many words", "$10\r\nmany words\r\n"),
+		Entry("named string", status("ok"), "$2\r\nok\r\n"),
+		Entry("pointer", &[]int{1}, "*1\r\n:1\r\n"),
+		Entry("error", errors.New("failed"), "-ERR failed\r\n"),
+		Entry("time", ts, ":1556964672013\r\n"),
+		Entry("byte array", [2]byte{'a', 'b'}, "$2\r\nab\r\n"),
+		Entry("[]interface{}", []interface{}{"a", nil, 1.5}, "*3\r\n$1\r\na\r\n$-1\r\n+1.5\r\n"),
+		Entry("struct",
+			record{base: base{ID: 7}, Name: "x", Created: ts, Updated: ts, Secret: "s", hidden: "h"},
+			"*12\r\n$4\r\nname\r\n$1\r\nx\r\n$7\r\ncreated\r\n:1556964672013\r\n$7\r\nupdated\r\n$24\r\n2019-05-04T10:11:12.013Z\r\n"+
+				"$5\r\nowner\r\n$-1\r\n$5\r\nPlain\r\n:0\r\n$2\r\nid\r\n:7\r\n"),
+		Entry("struct with embedded pointer",
+			&record{Meta: &Meta{Tags: []string{"a"}}},
+			"*14\r\n$4\r\nname\r\n$0\r\n\r\n$7\r\ncreated\r\n:-62135596800000\r\n$7\r\nupdated\r\n$20\r\n0001-01-01T00:00:00Z\r\n"+
+				"$5\r\nowner\r\n$-1\r\n$5\r\nPlain\r\n:0\r\n$2\r\nid\r\n:0\r\n$4\r\ntags\r\n*1\r\n$1\r\na\r\n"),
+		Entry("slice of structs", []base{{ID: 1}, {ID: 2}}, "*2\r\n*2\r\n$2\r\nid\r\n:1\r\n*2\r\n$2\r\nid\r\n:2\r\n"),
+		Entry("marshaler", point{X: 1, Y: 2}, "*2\r\n:1\r\n:2\r\n"),
+		Entry("pointer marshaler", []ref{{Key: "k"}}, "*1\r\n+@k\r\n"),
+		Entry("custom response", &customResponse{Host: "foo", Port: 8888}, "$17\r\ncustom 'foo:8888'\r\n"),
+	)
+
+	It("should encode structs as maps in RESP3", func() {
+		subject.SetProtocol(resp.RESP3)
+		Expect(resp.Marshal(subject, base{ID: 3})).To(Succeed())
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("%1\r\n$2\r\nid\r\n:3\r\n"))
+	})
+
+	It("should reject unsupported types without writing", func() {
+		Expect(resp.Marshal(subject, make(chan int))).To(MatchError(`resp: unsupported type chan int`))
+		Expect(resp.Marshal(subject, []interface{}{"a", complex(1, 2)})).To(MatchError(`resp: unsupported type complex128`))
+		Expect(resp.Marshal(subject, struct{ Fn func() }{})).To(MatchError(`resp: unsupported type func()`))
+		Expect(subject.Buffered()).To(Equal(0))
+	})
+
+	It("should ignore unsupported unexported fields", func() {
+		Expect(resp.Marshal(subject, struct {
+			N  int
+			ch chan int
+		}{N: 1})).To(Succeed())
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("*2\r\n$1\r\nN\r\n:1\r\n"))
+	})
+
+	It("should append any values", func() {
+		Expect(subject.AppendAny(&base{ID: 3})).To(Succeed())
+		Expect(subject.AppendAny([]Meta{{Tags: []string{"a"}}})).To(Succeed())
+		Expect(subject.AppendAny(make(chan int))).To(MatchError(`resp: unsupported type chan int`))
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("*2\r\n$2\r\nid\r\n:3\r\n*1\r\n*2\r\n$4\r\ntags\r\n*1\r\n$1\r\na\r\n"))
+	})
+
+	It("should return marshaler errors", func() {
+		Expect(resp.Marshal(subject, point{X: -1})).To(MatchError("negative"))
+	})
+
+})
+
+type status string
+
+type point struct{ X, Y int }
+
+func (p point) MarshalRESP(w resp.ResponseWriter) error {
+	if p.X < 0 {
+		return errors.New("negative")
+	}
+	w.AppendArrayLen(2)
+	w.AppendInt(int64(p.X))
+	w.AppendInt(int64(p.Y))
+	return nil
+}
+
+type ref struct{ Key string }
+
+func (r *ref) MarshalRESP(w resp.ResponseWriter) error {
+	w.AppendInlineString("@" + r.Key)
+	return nil
+}
@@ -0,0 +1,293 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxReplyPrealloc limits the elements preallocated by ReadReply.
+const maxReplyPrealloc = 1024
+
+// ErrNil is returned by Reply accessors for nil replies
+var ErrNil = errors.New("resp: nil reply")
+
+// ServerError is an error reply returned by the server
+type ServerError string
+
+// Error implements error
+func (e ServerError) Error() string { return string(e) }
+
+// ErrorPrefix implements PrefixedError, so server errors are relayed
+// verbatim.
+func (e ServerError) ErrorPrefix() string {
+	if pos := strings.IndexByte(string(e), ' '); pos > -1 {
+		return string(e[:pos])
+	}
+	return string(e)
+}
+
+// PrefixedError is implemented by errors with an error class prefix, e.g.
+// "WRONGTYPE" or "MOVED". Error must return the full message, including
+// the prefix.
+type PrefixedError interface {
+	error
+	ErrorPrefix() string
+}
+
+// ErrorReply returns the error reply message for err. Messages of
+// PrefixedErrors are used verbatim, other messages are prefixed with
+// "ERR" unless they already are.
+func ErrorReply(err error) string {
+	var pe PrefixedError
+	if errors.As(err, &pe) {
+		return pe.Error()
+	}
+
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "ERR ") {
+		msg = "ERR " + msg
+	}
+	return msg
+}
+
+// Reply is a parsed response, see ReadReply.
+type Reply struct {
+	// Type is the response type
+	Type ResponseType
+
+	data   []byte
+	num    int64
+	elems  []*Reply
+	stream AllReadCloser
+}
+
+// ReadReply reads and buffers the next response, including all nested
+// array and map elements. RESP3 attributes are skipped.
+func ReadReply(r ResponseParser) (*Reply, error) {
+	t, err := r.PeekType()
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &Reply{Type: t}
+	switch t {
+	case TypeAttribute:
+		n, err := r.ReadMapLen()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < 2*n; i++ {
+			if _, err := ReadReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return ReadReply(r)
+	case TypeArray, TypeMap, TypeSet, TypePush:
+		var n int
+		if t != TypeMap {
+			n, err = r.ReadArrayLen()
+		} else if n, err = r.ReadMapLen(); err == nil {
+			n *= 2
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// the announced length is not trusted with the allocation
+		size := n
+		if size > maxReplyPrealloc {
+			size = maxReplyPrealloc
+		}
+		rp.elems = make([]*Reply, 0, size)
+		for i := 0; i < n; i++ {
+			elem, err := ReadReply(r)
+			if err != nil {
+				return nil, err
+			}
+			rp.elems = append(rp.elems, elem)
+		}
+	case TypeBulk:
+		if rp.data, err = r.ReadBulk(nil); err != nil {
+			return nil, err
+		}
+	case TypeInline:
+		s, err := r.ReadInlineString()
+		if err != nil {
+			return nil, err
+		}
+		rp.data = []byte(s)
+	case TypeError:
+		s, err := r.ReadError()
+		if err != nil {
+			return nil, err
+		}
+		rp.data = []byte(s)
+	case TypeInt:
+		if rp.num, err = r.ReadInt(); err != nil {
+			return nil, err
+		}
+	case TypeNil:
+		if err := r.ReadNil(); err != nil {
+			return nil, err
+		}
+	case TypeDouble:
+		f, err := r.ReadDouble()
+		if err != nil {
+			return nil, err
+		}
+		rp.data = strconv.AppendFloat(nil, f, 'f', -1, 64)
+	case TypeBool:
+		b, err := r.ReadBool()
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			rp.num = 1
+		}
+	case TypeBigInt:
+		n, err := r.ReadBigInt()
+		if err != nil {
+			return nil, err
+		}
+		rp.data = []byte(n.String())
+	default:
+		return nil, errBadResponseType
+	}
+	return rp, nil
+}
+
+// StreamReply reads the next response like ReadReply, but does not buffer
+// bulk replies. The bulk must be consumed via Reader and closed before the
+// next response can be read.
+func StreamReply(r ResponseParser) (*Reply, error) {
+	if t, err := r.PeekType(); err != nil {
+		return nil, err
+	} else if t != TypeBulk {
+		return ReadReply(r)
+	}
+
+	stream, err := r.StreamBulk()
+	if err != nil {
+		return nil, err
+	}
+	return &Reply{Type: TypeBulk, stream: stream}, nil
+}
+
+// IsNil returns true for nil replies.
+func (r *Reply) IsNil() bool { return r.Type == TypeNil }
+
+// Err returns a ServerError for error replies, nil otherwise.
+func (r *Reply) Err() error {
+	if r.Type == TypeError {
+		return ServerError(r.data)
+	}
+	return nil
+}
+
+// Bytes returns the value of bulk, inline, double and big number replies.
+func (r *Reply) Bytes() ([]byte, error) {
+	switch r.Type {
+	case TypeBulk:
+		if r.stream != nil {
+			data, err := r.stream.ReadAll()
+			if err != nil {
+				return nil, err
+			}
+			r.data, r.stream = data, nil
+		}
+		return r.data, nil
+	case TypeInline, TypeDouble, TypeBigInt:
+		return r.data, nil
+	case TypeInt:
+		return strconv.AppendInt(nil, r.num, 10), nil
+	}
+	return nil, r.typeErr()
+}
+
+// Str returns the value as a string, see Bytes.
+func (r *Reply) Str() (string, error) {
+	b, err := r.Bytes()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Int returns the value of int and bool replies, or parses string values.
+func (r *Reply) Int() (int64, error) {
+	switch r.Type {
+	case TypeInt, TypeBool:
+		return r.num, nil
+	case TypeBulk, TypeInline, TypeBigInt:
+		s, err := r.Str()
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, errNotANumber
+		}
+		return n, nil
+	}
+	return 0, r.typeErr()
+}
+
+// Slice returns the elements of array, set and push replies. Maps are
+// returned as a flat list of alternating keys and values.
+func (r *Reply) Slice() ([]*Reply, error) {
+	switch r.Type {
+	case TypeArray, TypeMap, TypeSet, TypePush:
+		return r.elems, nil
+	}
+	return nil, r.typeErr()
+}
+
+// Reader returns a reader over the value of a bulk reply.
+func (r *Reply) Reader() (io.Reader, error) {
+	if r.Type == TypeBulk && r.stream != nil {
+		return r.stream, nil
+	}
+
+	b, err := r.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// Len returns the length of bulk values or the number of elements.
+func (r *Reply) Len() int64 {
+	switch r.Type {
+	case TypeArray, TypeMap, TypeSet, TypePush:
+		return int64(len(r.elems))
+	case TypeBulk:
+		if r.stream != nil {
+			return r.stream.Len()
+		}
+	}
+	return int64(len(r.data))
+}
+
+// Close discards the unread remainder of a streamed bulk.
+func (r *Reply) Close() error {
+	if r.stream == nil {
+		return nil
+	}
+
+	stream := r.stream
+	r.stream = nil
+	return stream.Close()
+}
+
+func (r *Reply) typeErr() error {
+	switch r.Type {
+	case TypeError:
+		return r.Err()
+	case TypeNil:
+		return ErrNil
+	}
+	return errBadResponseType
+}
@@ -0,0 +1,186 @@
+package resp_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reply", func() {
+	var subject resp.ResponseReader
+	var buf = new(bytes.Buffer)
+
+	BeforeEach(func() {
+		buf.Reset()
+		subject = resp.NewResponseReader(buf)
+	})
+
+	It("should read scalars", func() {
+		buf.WriteString("+OK\r\n$4\r\nPING\r\n:12\r\n$2\r\n33\r\n-ERR bad\r\n$-1\r\n")
+
+		r, err := resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("OK"))
+
+		r, err = resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Type).To(Equal(resp.TypeBulk))
+		Expect(r.Str()).To(Equal("PING"))
+		_, err = r.Int()
+		Expect(err).To(HaveOccurred())
+
+		r, err = resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Int()).To(Equal(int64(12)))
+		Expect(r.Str()).To(Equal("12"))
+
+		r, err = resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Int()).To(Equal(int64(33)))
+
+		r, err = resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("ERR bad"))
+		_, err = r.Str()
+		Expect(err).To(Equal(resp.ServerError("ERR bad")))
+
+		r, err = resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.IsNil()).To(BeTrue())
+		Expect(r.Err()).NotTo(HaveOccurred())
+		_, err = r.Str()
+		Expect(err).To(Equal(resp.ErrNil))
+	})
+
+	It("should read nested arrays and maps", func() {
+		buf.WriteString("*3\r\n$1\r\na\r\n*2\r\n:1\r\n$-1\r\n*0\r\n%1\r\n+k\r\n#t\r\n")
+
+		r, err := resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		elems, err := r.Slice()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(elems).To(HaveLen(3))
+		Expect(elems[0].Str()).To(Equal("a"))
+
+		inner, err := elems[1].Slice()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inner).To(HaveLen(2))
+		Expect(inner[0].Int()).To(Equal(int64(1)))
+		Expect(inner[1].IsNil()).To(BeTrue())
+		Expect(elems[2].Slice()).To(BeEmpty())
+
+		r, err = resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Type).To(Equal(resp.TypeMap))
+		pairs, err := r.Slice()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pairs).To(HaveLen(2))
+		Expect(pairs[0].Str()).To(Equal("k"))
+		Expect(pairs[1].Int()).To(Equal(int64(1)))
+	})
+
+	It("should read sets and skip attributes", func() {
+		buf.WriteString("|1\r\n+ttl\r\n:3\r\n~2\r\n+a\r\n+b\r\n>1\r\n+msg\r\n")
+
+		r, err := resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Type).To(Equal(resp.TypeSet))
+		Expect(r.Len()).To(Equal(int64(2)))
+		elems, err := r.Slice()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(elems[1].Str()).To(Equal("b"))
+
+		r, err = resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Type).To(Equal(resp.TypePush))
+		elems, err = r.Slice()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(elems[0].Str()).To(Equal("msg"))
+	})
+
+	It("should stream bulks", func() {
+		buf.WriteString("$10\r\n0123456789\r\n$4\r\nnext\r\n+OK\r\n")
+
+		r, err := resp.StreamReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Len()).To(Equal(int64(10)))
+		rd, err := r.Reader()
+		Expect(err).NotTo(HaveOccurred())
+		p := make([]byte, 4)
+		Expect(rd.Read(p)).To(Equal(4))
+		Expect(string(p)).To(Equal("0123"))
+		Expect(r.Close()).To(Succeed())
+
+		r, err = resp.StreamReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		rd, err = r.Reader()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.ReadAll(rd)).To(Equal([]byte("next")))
+		Expect(r.Close()).To(Succeed())
+
+		r, err = resp.StreamReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("OK"))
+	})
+
+	It("should read bulks larger than the buffer", func() {
+		blob := strings.Repeat("x", 100000)
+		buf.WriteString("$100000\r\n" + blob + "\r\n+OK\r\n")
+
+		r, err := resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal(blob))
+
+		r, err = resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("OK"))
+	})
+
+	It("should not trust announced bulk lengths", func() {
+		buf.WriteString("$0099999999999999\r\nshort")
+
+		_, err := resp.ReadReply(subject)
+		Expect(err).To(MatchError("EOF"))
+	})
+
+	It("should apply limits", func() {
+		subject.SetLimits(4, 2)
+		buf.WriteString("$4\r\nPING\r\n$5\r\nHELLO\r\n")
+
+		r, err := resp.ReadReply(subject)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Str()).To(Equal("PING"))
+		_, err = resp.ReadReply(subject)
+		Expect(err).To(MatchError("Protocol error: too big bulk length"))
+
+		buf.Reset()
+		subject.Reset(buf)
+		subject.SetLimits(4, 2)
+		buf.WriteString("*3\r\n:1\r\n:2\r\n:3\r\n")
+		_, err = resp.ReadReply(subject)
+		Expect(err).To(MatchError("Protocol error: too big multibulk length"))
+
+		buf.Reset()
+		subject.Reset(buf)
+		subject.SetLimits(4, 2)
+		buf.WriteString("%3\r\n")
+		_, err = resp.ReadReply(subject)
+		Expect(err).To(MatchError("Protocol error: too big multibulk length"))
+	})
+
+	It("should render error replies", func() {
+		Expect(resp.ErrorReply(errors.New("bad"))).To(Equal("ERR bad"))
+		Expect(resp.ErrorReply(errors.New("ERR bad"))).To(Equal("ERR bad"))
+		Expect(resp.ErrorReply(resp.ServerError("MOVED 1 host:6379"))).To(Equal("MOVED 1 host:6379"))
+		Expect(resp.ErrorReply(fmt.Errorf("proxy: %w", resp.ServerError("WRONGTYPE bad")))).To(Equal("WRONGTYPE bad"))
+		Expect(resp.ServerError("MOVED 1 host:6379").ErrorPrefix()).To(Equal("MOVED"))
+		Expect(resp.ServerError("BUSY").ErrorPrefix()).To(Equal("BUSY"))
+	})
+
+})
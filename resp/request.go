@@ -17,18 +17,51 @@ func NewRequestReader(rd io.Reader) *RequestReader {
 	return &RequestReader{r: r}
 }
 
+// NewRequestReaderSize is like NewRequestReader, but uses a buffer of the
+// given initial size. The buffer grows to fit large commands.
+func NewRequestReaderSize(rd io.Reader, size int) *RequestReader {
+	r := new(bufioR)
+	r.reset(mkBuffer(size), rd)
+	return &RequestReader{r: r}
+}
+
 // Buffered returns the number of unread bytes.
 func (r *RequestReader) Buffered() int {
 	return r.r.Buffered()
 }
 
 // Reset resets the reader to a new reader and recycles internal buffers.
+// Limits are cleared.
 func (r *RequestReader) Reset(rd io.Reader) {
 	r.r.Reset(rd)
 }
 
-// PeekCmd peeks the next command name.
+// SetLimits limits the length of bulk arguments and the number of
+// elements of multibulk requests. Requests over the limits fail with
+// a fatal protocol error, see IsFatalProtocolError. Zero values disable
+// the respective limit.
+func (r *RequestReader) SetLimits(maxBulk int64, maxMultiBulk int) {
+	r.r.maxBulk, r.r.maxArray = maxBulk, maxMultiBulk
+}
+
+// PeekCmd peeks the next command name. Empty inline lines are
+// discarded; if nothing else is buffered, an empty name is returned.
 func (r *RequestReader) PeekCmd() (string, error) {
+	for {
+		line, err := r.r.PeekLine(0)
+		if err != nil {
+			return "", err
+		}
+		if len(line.Trim()) != 0 {
+			break
+		}
+		if _, err := r.r.ReadLine(); err != nil {
+			return "", err
+		}
+		if r.r.Buffered() == 0 {
+			return "", nil
+		}
+	}
 	return r.peekCmd(0)
 }
 
@@ -92,12 +125,14 @@ func (r *RequestReader) peekCmd(offset int) (string, error) {
 	if len(line) == 0 {
 		return "", nil
 	} else if line[0] != '*' {
-		return line.FirstWord(), nil
+		return r.r.intern(line.firstWord()), nil
 	}
 
 	n, err := line.ParseSize('*', errInvalidMultiBulkLength)
 	if err != nil {
 		return "", err
+	} else if err := r.r.checkArrayLen(n); err != nil {
+		return "", err
 	}
 
 	if n < 1 {
@@ -113,10 +148,15 @@ func (r *RequestReader) peekCmd(offset int) (string, error) {
 	n, err = line.ParseSize('$', errInvalidBulkLength)
 	if err != nil {
 		return "", err
+	} else if err := r.r.checkBulkLen(n); err != nil {
+		return "", err
 	}
 
 	data, err := r.r.PeekN(offset, int(n))
-	return string(data), err
+	if err != nil {
+		return "", err
+	}
+	return r.r.intern(data), nil
 }
 
 // --------------------------------------------------------------------
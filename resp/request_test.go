@@ -5,6 +5,7 @@ import (
 	"io"
 	"strings"
 	"fmt"
+	"testing"
 
 	"github.com/wangaoone/redeo/resp"
 	. "github.com/onsi/ginkgo"
@@ -89,6 +90,25 @@ var _ = Describe("RequestReader", func() {
 		Expect(cmd).To(MatchCommand(""))
 	})
 
+	It("should enforce limits", func() {
+		r := setup("*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n*2\r\n$4\r\nECHO\r\n$6\r\nhello!\r\n*3\r\n")
+		r.SetLimits(5, 2)
+
+		cmd, err := r.ReadCmd(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd).To(MatchCommand("ECHO", "hello"))
+
+		_, err = r.ReadCmd(cmd)
+		Expect(err).To(MatchError("Protocol error: too big bulk length"))
+		Expect(resp.IsFatalProtocolError(err)).To(BeTrue())
+
+		r.Reset(bytes.NewBufferString("*3\r\n"))
+		r.SetLimits(0, 2)
+		_, err = r.ReadCmd(cmd)
+		Expect(err).To(MatchError("Protocol error: too big multibulk length"))
+		Expect(resp.IsFatalProtocolError(err)).To(BeTrue())
+	})
+
 	It("should read inline streams", func() {
 		r := setup("PING\r\nEcHO   HeLLO   \r\n")
 
@@ -160,6 +180,28 @@ var _ = Describe("RequestReader", func() {
 		Expect(buf.Len()).To(Equal(100000))
 	})
 
+	It("should discard unread stream arguments", func() {
+		r := setup("*3\r\n$3\r\nSET\r\n$5\r\nhello\r\n$5\r\nworld\r\n*1\r\n$4\r\nPING\r\n")
+
+		cmd, err := r.StreamCmd(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd.Name).To(Equal("SET"))
+
+		arg, err := cmd.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(arg.Len()).To(Equal(int64(5)))
+
+		p := make([]byte, 2)
+		_, err = io.ReadFull(arg, p)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(p)).To(Equal("he"))
+		Expect(cmd.NextArg().String()).To(Equal("world"))
+
+		cmd, err = r.StreamCmd(cmd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd).To(MatchStream("PING"))
+	})
+
 	It("should allow to discard stream commands", func() {
 		r := setup("*2\r\n$4\r\nECHO\r\n$100\r\n" + strings.Repeat("x", 100) + "\r\n*1\r\n$4\r\nPING\r\n")
 
@@ -234,6 +276,13 @@ var _ = Describe("RequestReader", func() {
 			[][]string{
 				{"PING"},
 			}),
+		Entry("inline requests terminated by LF",
+			"PING\nECHO HELLO\n\nECHO WORLD\r\n",
+			[][]string{
+				{"PING"},
+				{"ECHO", "HELLO"},
+				{"ECHO", "WORLD"},
+			}),
 		Entry("multiple inline requests with args",
 			"  ECHO HELLO  \r\nECHO   WORLD   \r\n",
 			[][]string{
@@ -284,6 +333,7 @@ var _ = Describe("RequestReader", func() {
 		Entry("bad multi-bulk len", "*x\r\n", "Protocol error: invalid multibulk length"),
 		Entry("inline inside multi-bulk", "*1\r\nPING\r\n", "Protocol error: expected '$', got 'P'"),
 		Entry("bad bulk length", "*1\r\n$x\r\n", "Protocol error: invalid bulk length"),
+		Entry("unbalanced inline quotes", "ECHO \"hello\r\n", "Protocol error: unbalanced quotes in request"),
 		Entry("negative bulk length", "*1\r\n$-1\r\n", "Protocol error: invalid bulk length"),
 	)
 
@@ -301,12 +351,33 @@ var _ = Describe("RequestReader", func() {
 			"  ECHO HELLO  \r\n", "ECHO"),
 		Entry("blank multi-bulks",
 			"*0\r\nPING\r\n", "PING"),
+		Entry("extra line breaks",
+			"\r\n  \r\nPING\r\n", "PING"),
+		Entry("only line breaks",
+			"\r\n\r\n", ""),
 		Entry("multi-bulks",
 			"*2\r\n$4\r\nECHO\r\n$4\r\nmore\r\n", "ECHO"),
 		Entry("large multi-bulks",
 			"*2\r\n$4\r\nECHO\r\n$100000\r\n"+strings.Repeat("x", 100000)+"\r\n", "ECHO"),
 	)
 
+	It("should read recycled commands without allocations", func() {
+		r := setup(strings.Repeat("PING\r\n*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n", 110))
+
+		var cmd *resp.Command
+		read := func() {
+			for i := 0; i < 2; i++ {
+				_, err := r.PeekCmd()
+				Expect(err).NotTo(HaveOccurred())
+				cmd, err = r.ReadCmd(cmd)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}
+		read()
+		Expect(testing.AllocsPerRun(100, read)).To(BeZero())
+		Expect(cmd).To(MatchCommand("ECHO", "hello"))
+	})
+
 	DescribeTable("should skip commands",
 		func(s string) {
 			r := setup(s + "QUIT\r\n")
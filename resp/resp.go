@@ -26,6 +26,20 @@ func (t ResponseType) String() string {
 		return "Int"
 	case TypeNil:
 		return "Nil"
+	case TypeMap:
+		return "Map"
+	case TypeDouble:
+		return "Double"
+	case TypeBool:
+		return "Bool"
+	case TypeBigInt:
+		return "BigInt"
+	case TypeSet:
+		return "Set"
+	case TypePush:
+		return "Push"
+	case TypeAttribute:
+		return "Attribute"
 	}
 	return "Unknown"
 }
@@ -39,6 +53,19 @@ const (
 	TypeError
 	TypeInt
 	TypeNil
+	TypeMap
+	TypeDouble
+	TypeBool
+	TypeBigInt
+	TypeSet
+	TypePush
+	TypeAttribute
+)
+
+// Supported protocol versions
+const (
+	RESP2 = 2
+	RESP3 = 3
 )
 
 // --------------------------------------------------------------------
@@ -97,14 +124,25 @@ func IsProtocolError(err error) bool {
 	return ok
 }
 
+// IsFatalProtocolError returns true if the error is a protocol error
+// after which the request stream cannot be recovered.
+func IsFatalProtocolError(err error) bool {
+	return err == errInlineRequestTooLong || err == errBulkTooLong || err == errMultiBulkTooLong
+}
+
 const (
 	errInvalidMultiBulkLength = protoError("Protocol error: invalid multibulk length")
 	errInvalidBulkLength      = protoError("Protocol error: invalid bulk length")
 	errBlankBulkLength        = protoError("Protocol error: expected '$', got ' '")
 	errInlineRequestTooLong   = protoError("Protocol error: too big inline request")
+	errBulkTooLong            = protoError("Protocol error: too big bulk length")
+	errMultiBulkTooLong       = protoError("Protocol error: too big multibulk length")
+	errUnbalancedQuotes       = protoError("Protocol error: unbalanced quotes in request")
 	errNotANumber             = protoError("Protocol error: expected a number")
 	errNotANilMessage         = protoError("Protocol error: expected a nil")
 	errBadResponseType        = protoError("Protocol error: bad response type")
+	errNotABool               = protoError("Protocol error: expected a bool")
+	errNotADouble             = protoError("Protocol error: expected a double")
 )
 
 var (
@@ -113,9 +151,29 @@ var (
 	binZERO = []byte(":0\r\n")
 	binONE  = []byte(":1\r\n")
 	binNIL  = []byte("$-1\r\n")
+
+	binNULL  = []byte("_\r\n")
+	binTRUE  = []byte("#t\r\n")
+	binFALSE = []byte("#f\r\n")
+
+	binStreamedArray = []byte("*?\r\n")
+	binStreamEnd     = []byte(".\r\n")
 )
 
 // MaxBufferSize is the max request/response buffer size
 const MaxBufferSize = 64 * 1024
 
+// limits of the command names interned per reader
+const (
+	maxInterned    = 64
+	maxInternedLen = 32
+)
+
 func mkStdBuffer() []byte { return make([]byte, MaxBufferSize) }
+
+func mkBuffer(size int) []byte {
+	if size <= 0 {
+		return mkStdBuffer()
+	}
+	return make([]byte, size)
+}
@@ -2,6 +2,7 @@ package resp
 
 import (
 	"io"
+	"math/big"
 )
 
 // CustomResponse values implement custom serialization and can be passed
@@ -33,9 +34,33 @@ type ResponseWriter interface {
 	// AppendInt appends a numeric response to the output buffer.
 	AppendInt(n int64)
 	// AppendNil appends a nil-value to the output buffer.
+	// RESP3 clients receive a null, RESP2 clients a nil bulk.
 	AppendNil()
 	// AppendOK appends "OK" to the output buffer.
 	AppendOK()
+	// AppendMapLen appends a map header for n key/value pairs to the output
+	// buffer. RESP2 clients receive an array of 2*n elements instead.
+	AppendMapLen(n int)
+	// AppendSetLen appends a set header for n elements to the output buffer.
+	// RESP2 clients receive an array instead.
+	AppendSetLen(n int)
+	// AppendPushLen appends a header for a push message of n elements, e.g.
+	// a pub/sub message, to the output buffer. RESP2 clients receive an
+	// array instead.
+	AppendPushLen(n int)
+	// AppendAttributeLen appends an attribute header for n key/value pairs
+	// to the output buffer, the attributed reply must follow. Attributes
+	// are not supported by RESP2, see Protocol.
+	AppendAttributeLen(n int)
+	// AppendDouble appends a floating point number to the output buffer.
+	// RESP2 clients receive a bulk string instead.
+	AppendDouble(f float64)
+	// AppendBool appends a boolean to the output buffer.
+	// RESP2 clients receive :1 or :0 instead.
+	AppendBool(b bool)
+	// AppendBigInt appends a big number to the output buffer.
+	// RESP2 clients receive a bulk string instead.
+	AppendBigInt(n *big.Int)
 	// Append automatically serialized given values and appends them to the output buffer.
 	// Supported values include:
 	//   * nil
@@ -49,15 +74,29 @@ type ResponseWriter interface {
 	//   * CustomResponse instances
 	//   * slices and maps of any of the above
 	Append(v interface{}) error
+	// AppendAny marshals v and appends it to the output buffer, see Marshal.
+	// Unlike Append, it also supports structs, pointers and named types.
+	AppendAny(v interface{}) error
+	// AppendRaw appends pre-encoded data to the output buffer as is.
+	AppendRaw(p []byte)
 	// CopyBulk copies n bytes from a reader.
-	// This call may flush pending buffer to prevent overflows.
+	// This call may flush pending buffer to prevent overflows. Large
+	// bulks are copied directly to the connection, using sendfile for
+	// *os.File sources where supported.
+	// If src returns less than n bytes, the error is fatal and
+	// all subsequent flushes fail.
 	CopyBulk(src io.Reader, n int64) error
 	// Buffered returns the number of pending bytes.
 	Buffered() int
 	// Flush flushes pending buffer.
 	Flush() error
 	// Reset resets the writer to a new writer and recycles internal buffers.
+	// It also resets the protocol version to RESP2.
 	Reset(w io.Writer)
+	// Protocol returns the negotiated protocol version, RESP2 (default) or RESP3.
+	Protocol() int
+	// SetProtocol sets the protocol version used for encoding replies.
+	SetProtocol(v int)
 }
 
 // NewResponseWriter wraps any writer interface, but
@@ -68,6 +107,14 @@ func NewResponseWriter(wr io.Writer) ResponseWriter {
 	return w
 }
 
+// NewResponseWriterSize is like NewResponseWriter, but uses a buffer of
+// the given initial capacity.
+func NewResponseWriterSize(wr io.Writer, size int) ResponseWriter {
+	w := new(bufioW)
+	w.reset(mkBuffer(size), wr)
+	return w
+}
+
 // --------------------------------------------------------------------
 
 // ResponseParser is a basic response parser
@@ -87,8 +134,17 @@ type ResponseParser interface {
 	SkipBulk() error
 	// ReadInt reads an int value
 	ReadInt() (int64, error)
-	// ReadArrayLen reads the array length
+	// ReadArrayLen reads the length of an array, a RESP3 set or push message
 	ReadArrayLen() (int, error)
+	// ReadMapLen reads the number of key/value pairs of a RESP3 map or
+	// attribute
+	ReadMapLen() (int, error)
+	// ReadDouble reads a RESP3 double
+	ReadDouble() (float64, error)
+	// ReadBool reads a RESP3 boolean
+	ReadBool() (bool, error)
+	// ReadBigInt reads a RESP3 big number
+	ReadBigInt() (*big.Int, error)
 	// ReadError reads an error string
 	ReadError() (string, error)
 	// ReadInlineString reads a status string
@@ -104,8 +160,16 @@ type ResponseReader interface {
 
 	// Buffered returns the number of buffered (unread) bytes.
 	Buffered() int
+	// ReadRaw reads the next response, including nested elements, and
+	// appends it to p without decoding it, e.g. to relay it as it is.
+	ReadRaw(p []byte) ([]byte, error)
 	// Reset resets the reader to a new reader and recycles internal buffers.
+	// Limits are cleared.
 	Reset(r io.Reader)
+	// SetLimits limits the length of bulk replies and the number of
+	// elements of aggregate replies. Replies over the limits fail with
+	// a protocol error. Zero values disable the respective limit.
+	SetLimits(maxBulk int64, maxAggregate int)
 }
 
 // NewResponseReader returns ResponseReader, which wraps any reader interface, but
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
@@ -63,6 +66,14 @@ var _ = Describe("ResponseWriter", func() {
 		Expect(buf.String()).To(Equal("-WRONGTYPE not a number\r\n"))
 	})
 
+	It("should strip line breaks from inline strings and errors", func() {
+		subject.AppendError("ERR unknown command 'a\r\nb'")
+		subject.AppendInline([]byte("x\ny"))
+		subject.AppendInlineString("x\ry")
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("-ERR unknown command 'a  b'\r\n+x y\r\n+x y\r\n"))
+	})
+
 	It("should append ints", func() {
 		subject.AppendInt(27)
 		Expect(buf.String()).To(BeEmpty())
@@ -97,6 +108,28 @@ var _ = Describe("ResponseWriter", func() {
 		Expect(buf.String()).To(Equal("*1\r\n$16\r\nthis is a stream\r\n"))
 	})
 
+	It("should copy large bulks directly from readers", func() {
+		subject.AppendArrayLen(1)
+		src := strings.NewReader(strings.Repeat("x", 100000))
+		Expect(subject.CopyBulk(src, 80000)).To(Succeed())
+		Expect(subject.Buffered()).To(Equal(0))
+		Expect(buf.Len()).To(Equal(80014))
+		Expect(buf.String()).To(HavePrefix("*1\r\n$80000\r\nxxx"))
+		Expect(buf.String()).To(HaveSuffix("xxx\r\n"))
+	})
+
+	It("should fail on short reads", func() {
+		Expect(subject.CopyBulk(strings.NewReader("short"), 16)).To(MatchError(io.ErrUnexpectedEOF))
+		Expect(subject.Flush()).To(MatchError(io.ErrUnexpectedEOF))
+		Expect(buf.String()).To(BeEmpty())
+
+		subject = resp.NewResponseWriter(buf)
+		Expect(subject.CopyBulk(strings.NewReader("short"), 80000)).To(MatchError(io.ErrUnexpectedEOF))
+		subject.AppendOK()
+		Expect(subject.Flush()).To(MatchError(io.ErrUnexpectedEOF))
+		Expect(buf.String()).To(Equal("$80000\r\nshort"))
+	})
+
 	DescribeTable("Append",
 		func(v interface{}, exp string) {
 			subject.Append(v)
@@ -132,6 +165,107 @@ var _ = Describe("ResponseWriter", func() {
 		Expect(subject.Append(time.Time{})).To(MatchError(`resp: unsupported type time.Time`))
 	})
 
+	It("should reject nested bad types without writing", func() {
+		Expect(subject.Append([]interface{}{"a", time.Time{}})).To(MatchError(`resp: unsupported type time.Time`))
+		Expect(subject.Append(map[string]interface{}{"a": struct{}{}})).To(MatchError(`resp: unsupported type struct {}`))
+		Expect(subject.Buffered()).To(Equal(0))
+	})
+
+	It("should default to RESP2", func() {
+		Expect(subject.Protocol()).To(Equal(resp.RESP2))
+
+		subject.AppendMapLen(2)
+		subject.AppendDouble(1.5)
+		subject.AppendBool(true)
+		subject.AppendBool(false)
+		subject.AppendBigInt(big.NewInt(1234))
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("*4\r\n$3\r\n1.5\r\n:1\r\n:0\r\n$4\r\n1234\r\n"))
+	})
+
+	It("should append RESP3 types", func() {
+		subject.SetProtocol(resp.RESP3)
+		Expect(subject.Protocol()).To(Equal(resp.RESP3))
+
+		subject.AppendMapLen(2)
+		subject.AppendDouble(1.5)
+		subject.AppendDouble(math.Inf(-1))
+		subject.AppendBool(true)
+		subject.AppendBool(false)
+		subject.AppendBigInt(big.NewInt(1234))
+		subject.AppendNil()
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("%2\r\n,1.5\r\n,-inf\r\n#t\r\n#f\r\n(1234\r\n_\r\n"))
+	})
+
+	It("should append RESP3 aggregates", func() {
+		subject.AppendSetLen(1)
+		subject.AppendPushLen(1)
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("*1\r\n*1\r\n"))
+
+		buf.Reset()
+		subject.SetProtocol(resp.RESP3)
+		subject.AppendSetLen(2)
+		subject.AppendPushLen(3)
+		subject.AppendAttributeLen(1)
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("~2\r\n>3\r\n|1\r\n"))
+	})
+
+	It("should stream arrays", func() {
+		s := resp.NewArrayStream(subject)
+		s.Next().AppendBulkString("a")
+		Expect(s.Next().CopyBulk(strings.NewReader("bc"), 2)).To(Succeed())
+		Expect(s.Len()).To(Equal(2))
+		Expect(s.Close()).To(Succeed())
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("*2\r\n$1\r\na\r\n$2\r\nbc\r\n"))
+
+		buf.Reset()
+		subject.SetProtocol(resp.RESP3)
+		s = resp.NewArrayStream(subject)
+		for i := 0; i < 3; i++ {
+			s.Next().AppendInt(int64(i))
+		}
+		Expect(s.Close()).To(Succeed())
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("*?\r\n:0\r\n:1\r\n:2\r\n.\r\n"))
+	})
+
+	It("should flush streamed arrays as they grow", func() {
+		subject.SetProtocol(resp.RESP3)
+		s := resp.NewArrayStream(subject)
+		for i := 0; i < 10; i++ {
+			s.Next().AppendBulkString(strings.Repeat("x", 10000))
+		}
+		Expect(buf.Len()).To(BeNumerically(">", 60000))
+		Expect(subject.Buffered()).To(BeNumerically("<", resp.MaxBufferSize))
+		Expect(s.Close()).To(Succeed())
+	})
+
+	It("should revert to RESP2 on reset", func() {
+		subject.SetProtocol(resp.RESP3)
+		subject.Reset(buf)
+		Expect(subject.Protocol()).To(Equal(resp.RESP2))
+	})
+
+	DescribeTable("Append (RESP3)",
+		func(v interface{}, exp string) {
+			subject.SetProtocol(resp.RESP3)
+			subject.Append(v)
+			Expect(subject.Flush()).To(Succeed())
+			Expect(strconv.Quote(buf.String())).To(Equal(strconv.Quote(exp)))
+		},
+
+		Entry("nil", nil, "_\r\n"),
+		Entry("bool (true)", true, "#t\r\n"),
+		Entry("bool (false)", false, "#f\r\n"),
+		Entry("float64", 0.7357, ",0.7357\r\n"),
+		Entry("big.Int", big.NewInt(-77), "(-77\r\n"),
+		Entry("map[string]string", map[string]string{"a": "b"}, "%1\r\n$1\r\na\r\n$1\r\nb\r\n"),
+	)
+
 })
 
 var _ = Describe("ResponseReader", func() {
@@ -253,6 +387,74 @@ var _ = Describe("ResponseReader", func() {
 		Expect(t).To(Equal(resp.TypeInline))
 	})
 
+	It("should read RESP3 nulls", func() {
+		buf.WriteString("_\r\n+OK\r\n")
+
+		t, err := subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeNil))
+		Expect(subject.ReadNil()).To(Succeed())
+
+		t, err = subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeInline))
+	})
+
+	It("should read RESP3 types", func() {
+		buf.WriteString("%2\r\n,3.25\r\n,inf\r\n#t\r\n(12345678901234567890\r\n+OK\r\n")
+
+		t, err := subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeMap))
+		Expect(subject.ReadMapLen()).To(Equal(2))
+
+		t, err = subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeDouble))
+		Expect(subject.ReadDouble()).To(Equal(3.25))
+		Expect(subject.ReadDouble()).To(Equal(math.Inf(1)))
+
+		t, err = subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeBool))
+		Expect(subject.ReadBool()).To(BeTrue())
+
+		t, err = subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeBigInt))
+		n, err := subject.ReadBigInt()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n.String()).To(Equal("12345678901234567890"))
+
+		// ensure we have consumed everything
+		t, err = subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeInline))
+	})
+
+	It("should read RESP3 aggregates", func() {
+		buf.WriteString("~2\r\n>3\r\n|1\r\n+OK\r\n")
+
+		t, err := subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeSet))
+		Expect(subject.ReadArrayLen()).To(Equal(2))
+
+		t, err = subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypePush))
+		Expect(subject.ReadArrayLen()).To(Equal(3))
+
+		t, err = subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeAttribute))
+		Expect(subject.ReadMapLen()).To(Equal(1))
+
+		t, err = subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeInline))
+	})
+
 	It("should read errors", func() {
 		buf.WriteString("-WRONGTYPE expected hash\r\n+OK\r\n")
 
@@ -312,6 +514,32 @@ var _ = Describe("ResponseReader", func() {
 		Expect(err).To(MatchError("EOF"))
 	})
 
+	It("should read raw responses", func() {
+		raws := []string{
+			"+OK\r\n",
+			"$-1\r\n",
+			"$5\r\nhe\r\no\r\n",
+			"*3\r\n:1\r\n*-1\r\n*2\r\n-ERR x\r\n$0\r\n\r\n",
+			"%1\r\n+a\r\n~1\r\n#t\r\n",
+			"|1\r\n+ttl\r\n:3\r\n,1.5\r\n",
+		}
+		for _, raw := range raws {
+			buf.WriteString(raw)
+		}
+
+		var p []byte
+		for _, raw := range raws {
+			var err error
+			p, err = subject.ReadRaw(p[:0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(p)).To(Equal(raw))
+		}
+
+		buf.WriteString("?\r\n")
+		_, err := subject.ReadRaw(nil)
+		Expect(err).To(MatchError("Protocol error: bad response type"))
+	})
+
 	Describe("Scan", func() {
 
 		DescribeTable("success",
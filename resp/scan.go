@@ -41,7 +41,7 @@ func (b *bufioR) scan(dst interface{}) error {
 	}
 
 	switch pt {
-	case TypeArray:
+	case TypeArray, TypeSet:
 		sz, err := b.ReadArrayLen()
 		if err != nil {
 			return err
@@ -0,0 +1,59 @@
+package resp
+
+import "strconv"
+
+// StaticReply is a pre-encoded reply, e.g. for constant replies of hot
+// commands. Appending it copies the encoded bytes to the output buffer,
+// without encoding or allocations:
+//
+//	var pong = resp.NewStaticInline("PONG")
+//
+//	func (w resp.ResponseWriter, c *resp.Command) {
+//		pong.AppendTo(w)
+//	}
+//
+// Static replies are independent of the protocol version.
+type StaticReply []byte
+
+// Static replies of common values.
+var (
+	StaticOK   = NewStaticInline("OK")
+	StaticPONG = NewStaticInline("PONG")
+	StaticZero = NewStaticInt(0)
+	StaticOne  = NewStaticInt(1)
+)
+
+// NewStaticBulk pre-encodes a bulk reply.
+func NewStaticBulk(p []byte) StaticReply {
+	b := make([]byte, 0, len(p)+16)
+	b = append(b, '$')
+	b = strconv.AppendInt(b, int64(len(p)), 10)
+	b = append(b, binCRLF...)
+	b = append(b, p...)
+	return StaticReply(append(b, binCRLF...))
+}
+
+// NewStaticBulkString pre-encodes a bulk reply.
+func NewStaticBulkString(s string) StaticReply {
+	return NewStaticBulk([]byte(s))
+}
+
+// NewStaticInline pre-encodes an inline reply, line breaks are replaced
+// by spaces.
+func NewStaticInline(s string) StaticReply {
+	b := appendLineString([]byte{'+'}, s)
+	return StaticReply(append(b, binCRLF...))
+}
+
+// NewStaticInt pre-encodes a numeric reply.
+func NewStaticInt(n int64) StaticReply {
+	b := strconv.AppendInt([]byte{':'}, n, 10)
+	return StaticReply(append(b, binCRLF...))
+}
+
+// AppendTo implements CustomResponse. Prefer calling AppendTo directly over
+// passing the reply to ResponseWriter.Append, which allocates.
+func (r StaticReply) AppendTo(w ResponseWriter) { w.AppendRaw(r) }
+
+// String returns the encoded reply.
+func (r StaticReply) String() string { return string(r) }
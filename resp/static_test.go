@@ -0,0 +1,47 @@
+package resp_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StaticReply", func() {
+	var subject resp.ResponseWriter
+	var buf *bytes.Buffer
+
+	BeforeEach(func() {
+		buf = new(bytes.Buffer)
+		subject = resp.NewResponseWriter(buf)
+	})
+
+	It("should pre-encode replies", func() {
+		Expect(resp.NewStaticBulk([]byte("hello")).String()).To(Equal("$5\r\nhello\r\n"))
+		Expect(resp.NewStaticBulkString("").String()).To(Equal("$0\r\n\r\n"))
+		Expect(resp.NewStaticInline("a\r\nb").String()).To(Equal("+a  b\r\n"))
+		Expect(resp.NewStaticInt(-7).String()).To(Equal(":-7\r\n"))
+		Expect(resp.StaticOK.String()).To(Equal("+OK\r\n"))
+		Expect(resp.StaticPONG.String()).To(Equal("+PONG\r\n"))
+		Expect(resp.StaticZero.String()).To(Equal(":0\r\n"))
+		Expect(resp.StaticOne.String()).To(Equal(":1\r\n"))
+	})
+
+	It("should append without allocations", func() {
+		hello := resp.NewStaticBulkString("hello")
+		hello.AppendTo(subject)
+		Expect(subject.Append(resp.StaticOne)).To(Succeed())
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("$5\r\nhello\r\n:1\r\n"))
+
+		Expect(testing.AllocsPerRun(100, func() {
+			hello.AppendTo(subject)
+			resp.StaticOK.AppendTo(subject)
+			if subject.Buffered() > 4096 {
+				_ = subject.Flush()
+			}
+		})).To(BeZero())
+	})
+})
@@ -0,0 +1,59 @@
+package resp
+
+import "bytes"
+
+// ArrayStream appends an array of unknown length, e.g. a lazily generated
+// result set. RESP3 clients receive a streamed aggregate, which is flushed
+// as it grows. RESP2 clients require the length up front, so elements are
+// buffered until Close.
+type ArrayStream struct {
+	w   ResponseWriter
+	buf *bufioW // RESP2 only
+	out bytes.Buffer
+	n   int
+	err error
+}
+
+// NewArrayStream starts an array of unknown length.
+func NewArrayStream(w ResponseWriter) *ArrayStream {
+	s := &ArrayStream{w: w}
+	if w.Protocol() == RESP3 {
+		w.AppendRaw(binStreamedArray)
+	} else {
+		s.buf = new(bufioW)
+		s.buf.reset(mkBuffer(4096), &s.out)
+	}
+	return s
+}
+
+// Next returns the writer to append the next element to. Each element must
+// be appended completely before Next or Close is called again.
+func (s *ArrayStream) Next() ResponseWriter {
+	s.n++
+	if s.buf != nil {
+		return s.buf
+	}
+
+	if s.err == nil && s.w.Buffered() >= MaxBufferSize {
+		s.err = s.w.Flush()
+	}
+	return s.w
+}
+
+// Len returns the number of elements started.
+func (s *ArrayStream) Len() int { return s.n }
+
+// Close terminates the array and returns the first flush error, if any.
+func (s *ArrayStream) Close() error {
+	if s.buf != nil {
+		if err := s.buf.Flush(); err != nil {
+			return err
+		}
+		s.w.AppendArrayLen(s.n)
+		s.w.AppendRaw(s.out.Bytes())
+		s.buf = nil
+	} else {
+		s.w.AppendRaw(binStreamEnd)
+	}
+	return s.err
+}
@@ -3,7 +3,7 @@ package resp
 var asciiSpace = [256]bool{'\t': true, '\n': true, '\v': true, '\f': true, '\r': true, ' ': true}
 
 // 'Inspired' by sdssplitargs from https://github.com/antirez/sds
-func appendArgument(dst, src []byte) ([]byte, int) {
+func appendArgument(dst, src []byte) ([]byte, int, error) {
 	// skip initial blanks
 	pos := 0
 	for ; pos < len(src) && asciiSpace[src[pos]]; pos++ {
@@ -17,7 +17,10 @@ MainLoop:
 		if inQ {
 			if p == '"' {
 				pos++
-				break MainLoop
+				if pos < len(src) && !asciiSpace[src[pos]] {
+					return dst, pos, errUnbalancedQuotes
+				}
+				return dst, pos, nil
 			} else if p == '\\' && pos+3 < len(src) && src[pos+1] == 'x' && isHexChar(src[pos+2]) && isHexChar(src[pos+3]) {
 				p = fromHexChar(src[pos+2])<<4 | fromHexChar(src[pos+3])
 				pos += 3
@@ -40,9 +43,15 @@ MainLoop:
 			dst = append(dst, p)
 
 		} else if inSQ {
-			if p == '\'' {
+			if p == '\\' && pos+1 < len(src) && src[pos+1] == '\'' {
 				pos++
-				break MainLoop
+				p = '\''
+			} else if p == '\'' {
+				pos++
+				if pos < len(src) && !asciiSpace[src[pos]] {
+					return dst, pos, errUnbalancedQuotes
+				}
+				return dst, pos, nil
 			}
 			dst = append(dst, p)
 
@@ -66,7 +75,11 @@ MainLoop:
 		}
 		pos++
 	}
-	return dst, pos
+
+	if inQ || inSQ {
+		return dst, pos, errUnbalancedQuotes
+	}
+	return dst, pos, nil
 }
 
 // --------------------------------------------------------------------
@@ -7,7 +7,8 @@ import (
 
 var _ = DescribeTable("appendArgument",
 	func(src string, exp string, expN int) {
-		dst, n := appendArgument(nil, []byte(src))
+		dst, n, err := appendArgument(nil, []byte(src))
+		Expect(err).NotTo(HaveOccurred())
 		Expect(string(dst)).To(Equal(exp))
 		Expect(n).To(Equal(expN))
 	},
@@ -34,4 +35,20 @@ var _ = DescribeTable("appendArgument",
 		`"hello \x6dy" world`, `hello my`, 13),
 	Entry("single quoted",
 		` 'hello my' world`, "hello my", 11),
+	Entry("single quoted with escaped quotes",
+		`'it\'s' world`, "it's", 7),
+	Entry("quoted with escaped chars",
+		`"a\tb\nc"`, "a\tb\nc", 9),
+)
+
+var _ = DescribeTable("appendArgument errors",
+	func(src string) {
+		_, _, err := appendArgument(nil, []byte(src))
+		Expect(err).To(MatchError("Protocol error: unbalanced quotes in request"))
+	},
+
+	Entry("unterminated quotes", `"hello world`),
+	Entry("unterminated single quotes", `'hello world`),
+	Entry("quotes followed by text", `"hello"world`),
+	Entry("single quotes followed by text", `'hello'world`),
 )
@@ -2,26 +2,38 @@ package resp
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
 	"strconv"
-	"strings"
 )
 
 // Append implements ResponseWriter
 func (w *bufioW) Append(v interface{}) error {
+	// validate first, to avoid writing partial replies
+	if err := checkAppendable(v); err != nil {
+		return err
+	}
+	w.appendValue(v)
+	return nil
+}
+
+// AppendAny implements ResponseWriter
+func (w *bufioW) AppendAny(v interface{}) error {
+	return Marshal(w, v)
+}
+
+func (w *bufioW) appendValue(v interface{}) {
 	switch v := v.(type) {
 	case nil:
 		w.AppendNil()
 	case CustomResponse:
 		v.AppendTo(w)
 	case error:
-		msg := v.Error()
-		if !strings.HasPrefix(msg, "ERR ") {
-			msg = "ERR " + msg
-		}
-		w.AppendError(msg)
+		w.AppendError(ErrorReply(v))
 	case bool:
-		if v {
+		if w.Protocol() == RESP3 {
+			w.AppendBool(v)
+		} else if v {
 			w.AppendInt(1)
 		} else {
 			w.AppendInt(0)
@@ -52,10 +64,20 @@ func (w *bufioW) Append(v interface{}) error {
 		w.AppendBulk(v)
 	case CommandArgument:
 		w.AppendBulk(v)
+	case *big.Int:
+		w.AppendBigInt(v)
 	case float32:
-		w.AppendInlineString(strconv.FormatFloat(float64(v), 'f', -1, 32))
+		if w.Protocol() == RESP3 {
+			w.AppendDouble(float64(v))
+		} else {
+			w.AppendInlineString(strconv.FormatFloat(float64(v), 'f', -1, 32))
+		}
 	case float64:
-		w.AppendInlineString(strconv.FormatFloat(v, 'f', -1, 64))
+		if w.Protocol() == RESP3 {
+			w.AppendDouble(v)
+		} else {
+			w.AppendInlineString(strconv.FormatFloat(v, 'f', -1, 64))
+		}
 	default:
 		switch reflect.TypeOf(v).Kind() {
 		case reflect.Slice:
@@ -63,19 +85,48 @@ func (w *bufioW) Append(v interface{}) error {
 
 			w.AppendArrayLen(s.Len())
 			for i := 0; i < s.Len(); i++ {
-				w.Append(s.Index(i).Interface())
+				w.appendValue(s.Index(i).Interface())
 			}
 		case reflect.Map:
 			s := reflect.ValueOf(v)
 
-			w.AppendArrayLen(s.Len() * 2)
+			w.AppendMapLen(s.Len())
 			for _, key := range s.MapKeys() {
-				w.Append(key.Interface())
-				w.Append(s.MapIndex(key).Interface())
+				w.appendValue(key.Interface())
+				w.appendValue(s.MapIndex(key).Interface())
 			}
-		default:
-			return fmt.Errorf("resp: unsupported type %T", v)
 		}
 	}
+}
+
+// checkAppendable returns an error if v, or any of the values nested
+// in it, cannot be appended.
+func checkAppendable(v interface{}) error {
+	switch v.(type) {
+	case nil, CustomResponse, error, bool, string, []byte, CommandArgument, *big.Int,
+		int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return nil
+	}
+
+	s := reflect.ValueOf(v)
+	switch s.Kind() {
+	case reflect.Slice:
+		for i := 0; i < s.Len(); i++ {
+			if err := checkAppendable(s.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range s.MapKeys() {
+			if err := checkAppendable(key.Interface()); err != nil {
+				return err
+			}
+			if err := checkAppendable(s.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("resp: unsupported type %T", v)
+	}
 	return nil
 }
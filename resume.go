@@ -0,0 +1,253 @@
+package redeo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// defaultStreamCheckpointTTL is the default of Config.StreamCheckpointTTL.
+const defaultStreamCheckpointTTL = 10 * time.Minute
+
+type ctxKeyStreamResume struct{}
+
+// StreamCheckpoint is the progress of a resumable stream, see Resumable.
+type StreamCheckpoint struct {
+	// Token identifies the stream, as chosen by the client
+	Token string
+	// Command is the normalized command name
+	Command string
+	// Arg is the index of the argument which was being read
+	Arg int
+	// Offset is the number of bytes of Arg consumed by the handler or,
+	// if passed to RESUME CONTINUE, the number of bytes of the reply
+	// already received by the client
+	Offset int64
+	// Time is the time of the interruption
+	Time time.Time
+}
+
+// Resumable marks a streaming command as resumable, e.g. to continue
+// large uploads and downloads after a dropped connection.
+//
+// Clients announce resumable streams via RESUME CONTINUE <token>, before
+// sending the command, see HandleResume. If the connection breaks while
+// the arguments are read, the progress is recorded under the token and
+// reported by RESUME STATUS <token>. Once reconnected, clients announce
+// the token again and resend the command, omitting the first Offset bytes
+// of argument Arg. Downloads pass the number of bytes received instead,
+// via RESUME CONTINUE <token> <offset>. Handlers retrieve the checkpoint
+// to continue from via StreamResume.
+func Resumable() HandlerOption {
+	return func(s *commandSpec) { s.resumable = true }
+}
+
+// StreamResume returns the checkpoint the current stream continues from,
+// nil unless the stream was announced via RESUME CONTINUE. Arg and Offset
+// are zero for new streams.
+func StreamResume(ctx context.Context) *StreamCheckpoint {
+	if ctx != nil {
+		if cp, ok := ctx.Value(ctxKeyStreamResume{}).(*StreamCheckpoint); ok {
+			return cp
+		}
+	}
+	return nil
+}
+
+// HandleResume registers a RESUME handler for resumable streams, see
+// Resumable, supporting the sub-commands:
+//
+//	RESUME CONTINUE <token> [offset]  announces the next command
+//	RESUME STATUS <token>             replies [command, arg, offset] or nil
+//	RESUME DISCARD <token>            removes the checkpoint
+func (srv *Server) HandleResume() {
+	srv.Handle("resume", resumeCommand(srv))
+}
+
+// StreamCheckpoint returns the checkpoint of an interrupted resumable
+// stream.
+func (srv *Server) StreamCheckpoint(token string) (StreamCheckpoint, bool) {
+	return srv.checkpoints.get(token, srv.checkpointTTL())
+}
+
+func (srv *Server) checkpointTTL() time.Duration {
+	if d := srv.conf().StreamCheckpointTTL; d > 0 {
+		return d
+	}
+	return defaultStreamCheckpointTTL
+}
+
+// resumeStream attaches the announced checkpoint to the stream, it returns
+// the error reply if cmd is not resumable.
+func (srv *Server) resumeStream(c *Client, entry *handlerEntry, norm string, cmd *resp.CommandStream) (*StreamCheckpoint, string) {
+	cp := c.takeResume()
+	if cp == nil {
+		return nil, ""
+	}
+	if !entry.spec.resumable {
+		return nil, notResumable(cmd.Name)
+	}
+	if cp.Command != "" && cp.Command != norm {
+		return nil, "ERR stream '" + cp.Token + "' was interrupted in '" + cp.Command + "'"
+	}
+
+	cp.Command = norm
+	cmd.SetContext(context.WithValue(cmd.Context(), ctxKeyStreamResume{}, cp))
+	return cp, ""
+}
+
+// checkpoint records the progress of an interrupted resumable stream, which
+// continued from cp, or removes the checkpoint once completed. It must be
+// called once the handler has returned.
+func (srv *Server) checkpoint(cmd *resp.CommandStream, from StreamCheckpoint) {
+	arg, off := cmd.Progress()
+	if cmd.Discard() == nil {
+		srv.checkpoints.remove(from.Token)
+		return
+	}
+
+	cp := from
+	switch {
+	case arg == cp.Arg:
+		cp.Offset += off
+	case arg > cp.Arg:
+		cp.Arg, cp.Offset = arg, off
+	}
+	cp.Time = time.Now()
+	srv.checkpoints.put(cp, srv.checkpointTTL())
+}
+
+func notResumable(name string) string {
+	return "ERR '" + name + "' is not resumable"
+}
+
+// takeResume returns and clears the checkpoint announced via RESUME
+// CONTINUE.
+func (c *Client) takeResume() *StreamCheckpoint {
+	c.mu.Lock()
+	cp := c.resume
+	c.resume = nil
+	c.mu.Unlock()
+	return cp
+}
+
+// --------------------------------------------------------------------
+
+// checkpointStore retains the checkpoints of interrupted streams.
+type checkpointStore struct {
+	entries map[string]StreamCheckpoint
+	mu      sync.Mutex
+}
+
+func (s *checkpointStore) get(token string, ttl time.Duration) (StreamCheckpoint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp, ok := s.entries[token]
+	if ok && time.Since(cp.Time) > ttl {
+		delete(s.entries, token)
+		return StreamCheckpoint{}, false
+	}
+	return cp, ok
+}
+
+// put stores cp, expired checkpoints are removed.
+func (s *checkpointStore) put(cp StreamCheckpoint, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.entries == nil {
+		s.entries = make(map[string]StreamCheckpoint)
+	}
+	for token, e := range s.entries {
+		if time.Since(e.Time) > ttl {
+			delete(s.entries, token)
+		}
+	}
+	s.entries[cp.Token] = cp
+}
+
+func (s *checkpointStore) remove(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.entries[token]
+	delete(s.entries, token)
+	return ok
+}
+
+// --------------------------------------------------------------------
+
+// resumeCommand implements RESUME CONTINUE, STATUS and DISCARD.
+func resumeCommand(srv *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() < 2 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		sub, token := c.Arg(0).String(), c.Arg(1).String()
+		switch strings.ToLower(sub) {
+		case "continue":
+			if c.ArgN() > 3 {
+				w.AppendError(WrongNumberOfArgs(c.Name + " " + sub))
+				return
+			}
+			client := GetClient(c.Context())
+			if client == nil {
+				w.AppendError("ERR no client connection")
+				return
+			}
+
+			cp, ok := srv.StreamCheckpoint(token)
+			if !ok {
+				cp = StreamCheckpoint{Token: token}
+			}
+			if c.ArgN() == 3 {
+				n, err := strconv.ParseInt(c.Arg(2).String(), 10, 64)
+				if err != nil || n < 0 {
+					w.AppendError("ERR offset is not an integer or out of range")
+					return
+				}
+				cp.Offset = n
+			}
+
+			client.mu.Lock()
+			client.resume = &cp
+			client.mu.Unlock()
+			w.AppendOK()
+		case "status":
+			if c.ArgN() != 2 {
+				w.AppendError(WrongNumberOfArgs(c.Name + " " + sub))
+				return
+			}
+
+			cp, ok := srv.StreamCheckpoint(token)
+			if !ok {
+				w.AppendNil()
+				return
+			}
+			w.AppendArrayLen(3)
+			w.AppendBulkString(cp.Command)
+			w.AppendInt(int64(cp.Arg))
+			w.AppendInt(cp.Offset)
+		case "discard":
+			if c.ArgN() != 2 {
+				w.AppendError(WrongNumberOfArgs(c.Name + " " + sub))
+				return
+			}
+
+			if srv.checkpoints.remove(token) {
+				w.AppendInt(1)
+			} else {
+				w.AppendInt(0)
+			}
+		default:
+			w.AppendError("ERR Unknown " + strings.ToLower(c.Name) + " subcommand '" + sub + "'")
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package redeo
+
+import (
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resumable streams", func() {
+	var subject *Server
+	var lis net.Listener
+	var uploads map[string]string
+	var mu sync.Mutex
+
+	BeforeEach(func() {
+		uploads = make(map[string]string)
+		subject = NewServer(nil)
+		subject.HandleResume()
+		subject.HandleStreamFunc("upload", func(w resp.ResponseWriter, c *resp.CommandStream) {
+			key, err := c.NextArg().String()
+			if err != nil {
+				return
+			}
+			src, err := c.Next()
+			if err != nil {
+				return
+			}
+			data, err := ioutil.ReadAll(src)
+
+			mu.Lock()
+			if cp := StreamResume(c.Context()); cp == nil || cp.Arg != 1 || cp.Offset == 0 {
+				uploads[key] = ""
+			}
+			uploads[key] += string(data)
+			mu.Unlock()
+
+			if err == nil {
+				w.AppendInt(src.Len())
+			}
+		}, Resumable())
+		subject.HandleStreamFunc("plain", func(w resp.ResponseWriter, c *resp.CommandStream) {
+			w.AppendOK()
+		})
+
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	dial := func() (net.Conn, *redeotest.Client) {
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		return cn, redeotest.NewClient(cn)
+	}
+
+	expect := func(c *redeotest.Client, exp interface{}, name string, args ...string) {
+		c.Send(name, args...)
+		Expect(c.Flush()).To(Succeed())
+		c.ExpectReply(GinkgoT(), exp)
+	}
+
+	uploaded := func(key string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		return uploads[key]
+	}
+
+	checkpoint := func(token string) StreamCheckpoint {
+		cp, _ := subject.StreamCheckpoint(token)
+		return cp
+	}
+
+	It("should resume interrupted uploads", func() {
+		cn, c := dial()
+		expect(c, "OK", "RESUME", "CONTINUE", "t1")
+		Expect(c.SendRaw([]byte("*3\r\n$6\r\nUPLOAD\r\n$3\r\nkey\r\n$10\r\nhello"))).To(Succeed())
+		Expect(c.Flush()).To(Succeed())
+		Expect(cn.Close()).To(Succeed())
+		Eventually(func() string { return uploaded("key") }).Should(Equal("hello"))
+
+		Eventually(func() int64 { return checkpoint("t1").Offset }).Should(Equal(int64(5)))
+		Expect(checkpoint("t1").Arg).To(Equal(1))
+		Expect(checkpoint("t1").Command).To(Equal("upload"))
+
+		cn, c = dial()
+		defer cn.Close()
+
+		expect(c, []interface{}{"upload", int64(1), int64(5)}, "RESUME", "STATUS", "t1")
+		expect(c, "OK", "RESUME", "CONTINUE", "t1")
+		expect(c, int64(5), "UPLOAD", "key", "world")
+		Expect(uploaded("key")).To(Equal("helloworld"))
+
+		_, ok := subject.StreamCheckpoint("t1")
+		Expect(ok).To(BeFalse())
+		expect(c, nil, "RESUME", "STATUS", "t1")
+	})
+
+	It("should accumulate offsets of repeated interruptions", func() {
+		for _, part := range []string{"hel", "lo"} {
+			cn, c := dial()
+			expect(c, "OK", "RESUME", "CONTINUE", "t2")
+			Expect(c.SendRaw([]byte("*3\r\n$6\r\nUPLOAD\r\n$3\r\nkey\r\n$10\r\n" + part))).To(Succeed())
+			Expect(c.Flush()).To(Succeed())
+			Expect(cn.Close()).To(Succeed())
+			Eventually(func() string { return uploaded("key") }).Should(HaveSuffix(part))
+		}
+		Eventually(func() int64 { return checkpoint("t2").Offset }).Should(Equal(int64(5)))
+		Expect(uploaded("key")).To(Equal("hello"))
+	})
+
+	It("should accept download offsets", func() {
+		cn, c := dial()
+		defer cn.Close()
+
+		subject.HandleStreamFunc("download", func(w resp.ResponseWriter, c *resp.CommandStream) {
+			w.AppendInt(StreamResume(c.Context()).Offset)
+		}, Resumable())
+
+		expect(c, "OK", "RESUME", "CONTINUE", "t3", "1024")
+		expect(c, int64(1024), "DOWNLOAD", "key")
+		expect(c, redeotest.ErrorResponse("ERR offset is not an integer or out of range"), "RESUME", "CONTINUE", "t3", "-1")
+		expect(c, int64(0), "RESUME", "DISCARD", "t3")
+	})
+
+	It("should reject commands which are not resumable", func() {
+		cn, c := dial()
+		defer cn.Close()
+
+		expect(c, "OK", "RESUME", "CONTINUE", "t4")
+		expect(c, redeotest.ErrorResponse("ERR 'PLAIN' is not resumable"), "PLAIN", "x")
+		expect(c, "OK", "PLAIN", "x")
+
+		expect(c, "OK", "RESUME", "CONTINUE", "t4")
+		expect(c, redeotest.ErrorResponse("ERR 'RESUME' is not resumable"), "RESUME", "STATUS", "t4")
+		expect(c, redeotest.ErrorResponse("ERR Unknown resume subcommand 'PAUSE'"), "RESUME", "PAUSE", "t4")
+	})
+})
@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package redeo
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort is not supported on this platform.
+func reusePort(c syscall.RawConn) error {
+	return errors.New("redeo: SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,85 @@
+package redeo
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// routeHandshakeTimeout bounds TLS handshakes performed to route
+// connections, see ServerNameRoute.
+const routeHandshakeTimeout = 10 * time.Second
+
+// Router serves several virtual servers on the same listeners. Each
+// server keeps its own handlers, settings and INFO stats, the server of
+// a connection is chosen once it is accepted.
+type Router struct {
+	route func(cn net.Conn) *Server
+}
+
+// NewRouter creates a router, which serves connections via the server
+// returned by route. Connections without a server are closed.
+func NewRouter(route func(cn net.Conn) *Server) *Router {
+	return &Router{route: route}
+}
+
+// Serve accepts connections on lis and serves them until lis is closed.
+// The TLSConfig of a server applies to the plain connections routed to
+// it. To route by SNI, wrap lis via tls.NewListener instead.
+func (r *Router) Serve(lis net.Listener) error {
+	var tempDelay time.Duration // how long to sleep on accept failure
+	for {
+		cn, err := lis.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := maxAcceptDelay; tempDelay > max {
+					tempDelay = max
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+
+		go r.serveConn(cn)
+	}
+}
+
+func (r *Router) serveConn(cn net.Conn) {
+	srv := r.route(cn)
+	if srv == nil {
+		_ = cn.Close()
+		return
+	}
+	_ = srv.serveConn(cn)
+}
+
+// ServerNameRoute returns a route for TLS connections, which selects
+// servers by the name clients request via SNI. Other connections and
+// unknown names are routed to fallback, which may be nil.
+func ServerNameRoute(servers map[string]*Server, fallback *Server) func(net.Conn) *Server {
+	return func(cn net.Conn) *Server {
+		tc, ok := cn.(*tls.Conn)
+		if !ok {
+			return fallback
+		}
+
+		_ = tc.SetDeadline(time.Now().Add(routeHandshakeTimeout))
+		err := tc.Handshake()
+		_ = tc.SetDeadline(time.Time{})
+		if err != nil {
+			return nil
+		}
+
+		if srv, ok := servers[tc.ConnectionState().ServerName]; ok {
+			return srv
+		}
+		return fallback
+	}
+}
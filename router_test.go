@@ -0,0 +1,83 @@
+package redeo
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/wangaoone/redeo/client"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Router", func() {
+	var a, b *Server
+
+	BeforeEach(func() {
+		a, b = NewServer(nil), NewServer(nil)
+		a.HandleFunc("whoami", func(w resp.ResponseWriter, _ *resp.Command) { w.AppendInlineString("a") })
+		b.HandleFunc("whoami", func(w resp.ResponseWriter, _ *resp.Command) { w.AppendInlineString("b") })
+		b.Handle("ping", Ping())
+	})
+
+	var cmd = func(c client.Conn, name string) *resp.Reply {
+		r, err := c.Cmd(name)
+		Expect(err).NotTo(HaveOccurred())
+		return r
+	}
+
+	It("should route connections to virtual servers", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+
+		routes := make(chan *Server, 3)
+		routes <- a
+		routes <- b
+		routes <- nil
+		go NewRouter(func(net.Conn) *Server { return <-routes }).Serve(lis)
+
+		dial := func() client.Conn {
+			cn, err := net.Dial("tcp", lis.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			return client.Wrap(cn)
+		}
+
+		ca := dial()
+		defer ca.Close()
+		Expect(cmd(ca, "WHOAMI").Str()).To(Equal("a"))
+		Expect(cmd(ca, "PING").Err()).To(MatchError("ERR unknown command 'PING'"))
+
+		cb := dial()
+		defer cb.Close()
+		Expect(cmd(cb, "WHOAMI").Str()).To(Equal("b"))
+		Expect(cmd(cb, "PING").Str()).To(Equal("PONG"))
+
+		Expect(a.Info().TotalCommands()).To(Equal(int64(1)))
+		Expect(b.Info().TotalCommands()).To(Equal(int64(2)))
+
+		cn := dial()
+		defer cn.Close()
+		_, err = cn.Cmd("WHOAMI")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should route by server name", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		lis = tls.NewListener(lis, &tls.Config{Certificates: []tls.Certificate{generateCert()}})
+		defer lis.Close()
+
+		go NewRouter(ServerNameRoute(map[string]*Server{"b.example.com": b}, a)).Serve(lis)
+
+		for name, exp := range map[string]string{"b.example.com": "b", "other.example.com": "a"} {
+			cn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{ServerName: name, InsecureSkipVerify: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			c := client.Wrap(cn)
+			Expect(cmd(c, "WHOAMI").Str()).To(Equal(exp))
+			Expect(c.Close()).To(Succeed())
+		}
+	})
+
+})
@@ -0,0 +1,145 @@
+package redeo
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+var (
+	errInvalidCursor = errors.New("ERR invalid cursor")
+	errNotInteger    = errors.New("ERR value is not an integer or out of range")
+	errSyntax        = errors.New("ERR syntax error")
+)
+
+// defaultScanCount is the default COUNT of SCAN style commands.
+const defaultScanCount = 10
+
+// ScanOptions holds the arguments of SCAN style commands.
+type ScanOptions struct {
+	// Cursor is the position to continue the iteration from, 0 to start.
+	Cursor uint64
+	// Count is the number of entries to visit, a hint.
+	// Default: 10
+	Count int
+	// Match filters the entries by key, nil unless MATCH is given.
+	Match *Pattern
+	// Type filters the entries by type, empty unless TYPE is given.
+	Type string
+}
+
+// ParseScanOptions parses the cursor and the options of SCAN style
+// commands: cursor [MATCH pattern] [COUNT count] [TYPE type].
+// https://redis.io/commands/scan
+func ParseScanOptions(args []resp.CommandArgument) (*ScanOptions, error) {
+	if len(args) == 0 {
+		return nil, errSyntax
+	}
+
+	cursor, err := strconv.ParseUint(args[0].String(), 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	opts := &ScanOptions{Cursor: cursor, Count: defaultScanCount}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return nil, errSyntax
+		}
+
+		val := args[i+1].String()
+		switch strings.ToLower(args[i].String()) {
+		case "match":
+			opts.Match = CompilePattern(val)
+		case "count":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, errNotInteger
+			} else if n < 1 {
+				return nil, errSyntax
+			}
+			opts.Count = n
+		case "type":
+			opts.Type = val
+		default:
+			return nil, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+// ScanIterator is a collection which is iterated incrementally.
+type ScanIterator interface {
+	// Scan visits about opts.Count entries from opts.Cursor, calling fn with
+	// the key and the values of each, e.g. the value of a hash field. It
+	// returns the cursor to continue from, 0 once the iteration is complete.
+	// ScanHandler filters the keys by opts.Match, iterators may filter by
+	// opts.Type.
+	Scan(opts *ScanOptions, fn func(key string, vals ...string)) uint64
+}
+
+// ScanIteratorFunc is a func implementing ScanIterator.
+type ScanIteratorFunc func(opts *ScanOptions, fn func(key string, vals ...string)) uint64
+
+// Scan implements ScanIterator.
+func (f ScanIteratorFunc) Scan(opts *ScanOptions, fn func(key string, vals ...string)) uint64 {
+	return f(opts, fn)
+}
+
+// ScanHandler returns a handler for SCAN style commands, with the cursor
+// at the argument position cursorArg, e.g. 0 for SCAN and 1 for HSCAN.
+// The lookup func returns the iterator for the command, e.g. of the
+// client's database or of the hash stored at the first argument, or nil
+// if there is nothing to iterate.
+func ScanHandler(cursorArg int, lookup func(c *resp.Command) (ScanIterator, error)) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() <= cursorArg {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		opts, err := ParseScanOptions(c.Args[cursorArg:])
+		if err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+
+		it, err := lookup(c)
+		if err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+
+		var next uint64
+		var elems []string
+		if it != nil {
+			next = it.Scan(opts, func(key string, vals ...string) {
+				if opts.Match == nil || opts.Match.Match(key) {
+					elems = append(append(elems, key), vals...)
+				}
+			})
+		}
+
+		w.AppendArrayLen(2)
+		w.AppendBulkString(strconv.FormatUint(next, 10))
+		w.AppendArrayLen(len(elems))
+		for _, elem := range elems {
+			w.AppendBulkString(elem)
+		}
+	})
+}
+
+// NextCursor advances a cursor over a hash table of mask+1 buckets, where
+// the bucket of a cursor is cursor&mask. Like redis, it increments the
+// reversed bits of the cursor, so entries which are present for the whole
+// iteration are visited at least once, also if the table is resized in
+// between. It returns 0 once all buckets are visited.
+func NextCursor(cursor, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
@@ -0,0 +1,159 @@
+package redeo
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scan", func() {
+
+	var args = func(ss ...string) []resp.CommandArgument {
+		cargs := make([]resp.CommandArgument, len(ss))
+		for i, s := range ss {
+			cargs[i] = resp.CommandArgument(s)
+		}
+		return cargs
+	}
+
+	It("should parse options", func() {
+		opts, err := ParseScanOptions(args("17"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(Equal(&ScanOptions{Cursor: 17, Count: 10}))
+
+		opts, err = ParseScanOptions(args("0", "match", "k*", "COUNT", "100", "TYPE", "hash"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts.Count).To(Equal(100))
+		Expect(opts.Match.String()).To(Equal("k*"))
+		Expect(opts.Type).To(Equal("hash"))
+
+		_, err = ParseScanOptions(args("x"))
+		Expect(err).To(MatchError("ERR invalid cursor"))
+		_, err = ParseScanOptions(args("0", "count", "x"))
+		Expect(err).To(MatchError("ERR value is not an integer or out of range"))
+		_, err = ParseScanOptions(args("0", "count", "0"))
+		Expect(err).To(MatchError("ERR syntax error"))
+		_, err = ParseScanOptions(args("0", "match"))
+		Expect(err).To(MatchError("ERR syntax error"))
+		_, err = ParseScanOptions(args("0", "bad", "x"))
+		Expect(err).To(MatchError("ERR syntax error"))
+	})
+
+	It("should serve scans", func() {
+		fields := []string{"k1", "v1", "x", "v2", "k2", "v3"}
+		subject := ScanHandler(1, func(c *resp.Command) (ScanIterator, error) {
+			switch c.Arg(0).String() {
+			case "missing":
+				return nil, nil
+			case "bad":
+				return nil, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+			}
+			return ScanIteratorFunc(func(opts *ScanOptions, fn func(string, ...string)) uint64 {
+				i := int(opts.Cursor)
+				for ; i < len(fields)/2 && i < int(opts.Cursor)+opts.Count; i++ {
+					fn(fields[2*i], fields[2*i+1])
+				}
+				if i == len(fields)/2 {
+					return 0
+				}
+				return uint64(i)
+			}), nil
+		})
+
+		var serve = func(ss ...string) interface{} {
+			w := redeotest.NewRecorder()
+			subject.ServeRedeo(w, resp.NewCommand("HSCAN", args(ss...)...))
+			res, err := w.Response()
+			Expect(err).NotTo(HaveOccurred())
+			return res
+		}
+
+		Expect(serve("h", "0")).To(Equal([]interface{}{"0", []interface{}{"k1", "v1", "x", "v2", "k2", "v3"}}))
+		Expect(serve("h", "0", "COUNT", "2", "MATCH", "k*")).To(Equal([]interface{}{"2", []interface{}{"k1", "v1"}}))
+		Expect(serve("h", "2", "COUNT", "2", "MATCH", "k*")).To(Equal([]interface{}{"0", []interface{}{"k2", "v3"}}))
+		Expect(serve("missing", "0")).To(Equal([]interface{}{"0", []interface{}{}}))
+		Expect(serve("bad", "0")).To(MatchError("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		Expect(serve("h", "-1")).To(MatchError("ERR invalid cursor"))
+		Expect(serve("h")).To(MatchError("ERR wrong number of arguments for 'HSCAN' command"))
+	})
+
+	It("should visit all entries of resized tables", func() {
+		t := new(testScanTable)
+		t.resize(16)
+		for i := 0; i < 100; i++ {
+			t.add("key" + strconv.Itoa(i))
+		}
+
+		seen := make(map[string]bool)
+		opts := &ScanOptions{Count: 5}
+		for calls := 0; ; calls++ {
+			Expect(calls).To(BeNumerically("<", 200))
+			switch calls {
+			case 2:
+				t.resize(64)
+			case 8:
+				t.resize(4)
+			}
+
+			opts.Cursor = t.Scan(opts, func(key string, _ ...string) { seen[key] = true })
+			if opts.Cursor == 0 {
+				break
+			}
+		}
+		Expect(seen).To(HaveLen(100))
+	})
+
+	It("should advance cursors in reverse binary order", func() {
+		var cursors []uint64
+		for c := NextCursor(0, 7); c != 0; c = NextCursor(c, 7) {
+			cursors = append(cursors, c)
+		}
+		Expect(cursors).To(Equal([]uint64{4, 2, 6, 1, 5, 3, 7}))
+	})
+
+})
+
+// testScanTable is a hash table of keys, scanned via NextCursor.
+type testScanTable struct{ buckets [][]string }
+
+func (t *testScanTable) bucket(key string, mask uint64) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64() & mask
+}
+
+func (t *testScanTable) add(key string) {
+	mask := uint64(len(t.buckets) - 1)
+	b := t.bucket(key, mask)
+	t.buckets[b] = append(t.buckets[b], key)
+}
+
+func (t *testScanTable) resize(n int) {
+	old := t.buckets
+	t.buckets = make([][]string, n)
+	for _, keys := range old {
+		for _, key := range keys {
+			t.add(key)
+		}
+	}
+}
+
+func (t *testScanTable) Scan(opts *ScanOptions, fn func(string, ...string)) uint64 {
+	mask := uint64(len(t.buckets) - 1)
+	cursor := opts.Cursor
+	for n := 0; n < opts.Count; {
+		for _, key := range t.buckets[cursor&mask] {
+			fn(key)
+			n++
+		}
+		if cursor = NextCursor(cursor, mask); cursor == 0 {
+			break
+		}
+	}
+	return cursor
+}
@@ -0,0 +1,226 @@
+package redeo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// ScriptEngine executes scripts, e.g. by embedding a Lua interpreter,
+// see Server.HandleScripting.
+type ScriptEngine interface {
+	// Run executes script with the given keys and arguments. Commands
+	// called by the script, e.g. via redis.call, must be dispatched via
+	// call. The result is appended as the reply, see
+	// resp.ResponseWriter.Append.
+	Run(ctx context.Context, script string, keys, args []string, call ScriptCall) (interface{}, error)
+}
+
+// ScriptCall executes a registered command on behalf of a script and
+// returns its reply. Commands which fail, or are not allowed within
+// scripts, return error replies, see resp.Reply.Err.
+type ScriptCall func(name string, args ...string) (*resp.Reply, error)
+
+// scriptCache holds the scripts known by their SHA1 digest.
+type scriptCache struct {
+	scripts map[string]string
+	mu      sync.RWMutex
+}
+
+func (s *scriptCache) load(script string) string {
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	if s.scripts == nil {
+		s.scripts = make(map[string]string)
+	}
+	s.scripts[sha] = script
+	s.mu.Unlock()
+	return sha
+}
+
+func (s *scriptCache) get(sha string) (string, bool) {
+	s.mu.RLock()
+	script, ok := s.scripts[strings.ToLower(sha)]
+	s.mu.RUnlock()
+	return script, ok
+}
+
+func (s *scriptCache) flush() {
+	s.mu.Lock()
+	s.scripts = nil
+	s.mu.Unlock()
+}
+
+// --------------------------------------------------------------------
+
+// HandleScripting registers EVAL, EVALSHA and SCRIPT handlers, which
+// execute scripts via engine. Scripts may call any registered command,
+// except for asynchronous ones and those flagged "noscript". Blocking
+// commands time out immediately, as within transactions. Commands called
+// by scripts are replicated and journaled individually, rather than the
+// scripts themselves.
+// https://redis.io/commands/eval
+func (srv *Server) HandleScripting(engine ScriptEngine) {
+	cache := new(scriptCache)
+
+	run := func(w resp.ResponseWriter, c *resp.Command, script string) {
+		numKeys, err := c.Arg(1).Int()
+		if err != nil {
+			w.AppendError("ERR value is not an integer or out of range")
+			return
+		} else if numKeys < 0 {
+			w.AppendError("ERR Number of keys can't be negative")
+			return
+		} else if numKeys > int64(c.ArgN()-2) {
+			w.AppendError("ERR Number of keys can't be greater than number of args")
+			return
+		}
+
+		keys := make([]string, numKeys)
+		for i := range keys {
+			keys[i] = c.Arg(2 + i).String()
+		}
+		args := make([]string, c.ArgN()-2-len(keys))
+		for i := range args {
+			args[i] = c.Arg(2 + len(keys) + i).String()
+		}
+
+		ctx := c.Context()
+		res, err := engine.Run(ctx, script, keys, args, func(name string, args ...string) (*resp.Reply, error) {
+			return srv.callFromScript(ctx, name, args)
+		})
+		if err != nil {
+			msg := err.Error()
+			if !strings.HasPrefix(msg, "ERR ") {
+				msg = "ERR Error running script: " + msg
+			}
+			w.AppendError(msg)
+			return
+		}
+		if err := w.Append(res); err != nil {
+			w.AppendError(resp.ErrorReply(err))
+		}
+	}
+
+	srv.HandleFunc("eval", func(w resp.ResponseWriter, c *resp.Command) {
+		script := c.Arg(0).String()
+		cache.load(script)
+		run(w, c, script)
+	}, MinArgs(2), Flags("noscript"))
+
+	srv.HandleFunc("evalsha", func(w resp.ResponseWriter, c *resp.Command) {
+		script, ok := cache.get(c.Arg(0).String())
+		if !ok {
+			w.AppendError("NOSCRIPT No matching script. Please use EVAL.")
+			return
+		}
+		run(w, c, script)
+	}, MinArgs(2), Flags("noscript"))
+
+	sc := NewSubCommands()
+	sc.HandleFunc("load", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		w.AppendBulkString(cache.load(c.Arg(0).String()))
+	})
+	sc.HandleFunc("exists", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() == 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		w.AppendArrayLen(c.ArgN())
+		for _, arg := range c.Args {
+			_, ok := cache.get(arg.String())
+			w.AppendBool(ok)
+		}
+	})
+	sc.HandleFunc("flush", func(w resp.ResponseWriter, c *resp.Command) {
+		cache.flush()
+		w.AppendOK()
+	})
+	srv.Handle("script", sc, Flags("noscript"))
+}
+
+// callFromScript executes a command for a script, run by the client of
+// ctx, and returns the parsed reply.
+func (srv *Server) callFromScript(ctx context.Context, name string, args []string) (*resp.Reply, error) {
+	buf := new(bytes.Buffer)
+	w := &replyWriter{ResponseWriter: resp.NewResponseWriterSize(buf, 512)}
+	w.bufferTo(buf)
+
+	srv.serveScriptCall(ctx, w, name, args)
+	if err := w.ResponseWriter.Flush(); err != nil {
+		return nil, err
+	}
+	return resp.ReadReply(resp.NewResponseReader(buf))
+}
+
+func (srv *Server) serveScriptCall(ctx context.Context, w *replyWriter, name string, args []string) {
+	norm := srv.normalize(name)
+	entry, ok := srv.lookup(norm)
+	if !ok {
+		w.AppendError("ERR Unknown Redis command called from script")
+		return
+	}
+	if _, ok := entry.served.(AsyncHandler); ok || entry.spec.hasFlag("noscript") {
+		w.AppendError("ERR This Redis command is not allowed from scripts")
+		return
+	}
+
+	cargs := make([]resp.CommandArgument, len(args))
+	for i, arg := range args {
+		cargs[i] = resp.CommandArgument(arg)
+	}
+	cmd := resp.NewCommand(name, cargs...)
+	cmd.SetContext(ctx)
+	if msg := entry.spec.check(cmd); msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	c := GetClient(ctx)
+	if c != nil {
+		if err := srv.authorize(c, norm, cmd.Args); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		srv.feedMonitors(c, name, cmd.Args)
+	}
+
+	start := time.Now()
+	defer func() { srv.info.observe(norm, time.Since(start), w.failed) }()
+	defer srv.recoverPanic(w, norm)
+
+	switch handler := entry.served.(type) {
+	case Handler:
+		handler.ServeRedeo(w, cmd)
+	case StreamHandler:
+		scmd := resp.NewCommandStream(name, cargs...)
+		scmd.SetContext(ctx)
+		handler.ServeRedeoStream(w, scmd)
+	}
+
+	// blocked commands time out immediately
+	if c != nil && c.blocker != nil {
+		b := c.blocker
+		c.blocker = nil
+		b.finish(nil, ErrBlockTimeout)
+		w.AppendNil()
+		return
+	}
+
+	// replicate the effects, scripts themselves are not propagated
+	if c != nil {
+		srv.propagate(c, entry, norm, cmd.Args, w.failed)
+	}
+}
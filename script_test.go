@@ -0,0 +1,113 @@
+package redeo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// mockScriptEngine runs scripts by name.
+type mockScriptEngine map[string]func(keys, args []string, call ScriptCall) (interface{}, error)
+
+func (m mockScriptEngine) Run(_ context.Context, script string, keys, args []string, call ScriptCall) (interface{}, error) {
+	fn, ok := m[script]
+	if !ok {
+		return nil, errors.New("unknown script")
+	}
+	return fn(keys, args, call)
+}
+
+var _ = Describe("Scripting", func() {
+	var subject *Server
+	var data map[string]string
+
+	var serve = func(name string, args ...string) *redeotest.ResponseRecorder {
+		cargs := make([]resp.CommandArgument, len(args))
+		for i, arg := range args {
+			cargs[i] = resp.CommandArgument(arg)
+		}
+		w := redeotest.NewRecorder()
+		subject.cmds[name].served.(Handler).ServeRedeo(w, resp.NewCommand(name, cargs...))
+		return w
+	}
+
+	BeforeEach(func() {
+		data = map[string]string{"foo": "bar"}
+
+		subject = NewServer(nil)
+		subject.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			if v, ok := data[c.Arg(0).String()]; ok {
+				w.AppendBulkString(v)
+			} else {
+				w.AppendNil()
+			}
+		}, Arity(2))
+		subject.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			data[c.Arg(0).String()] = c.Arg(1).String()
+			w.AppendOK()
+		}, Arity(3))
+		subject.HandleFunc("boom", func(w resp.ResponseWriter, c *resp.Command) {
+			panic("boom")
+		})
+		subject.HandleScripting(mockScriptEngine{
+			"copy": func(keys, args []string, call ScriptCall) (interface{}, error) {
+				rep, err := call("GET", keys[0])
+				if err != nil {
+					return nil, err
+				}
+				src, err := rep.Str()
+				if err != nil {
+					return nil, err
+				}
+				if rep, err = call("SET", keys[1], src+args[0]); err != nil {
+					return nil, err
+				}
+				return []interface{}{src, rep.Err() == nil}, nil
+			},
+			"call": func(_, args []string, call ScriptCall) (interface{}, error) {
+				rep, err := call(args[0], args[1:]...)
+				if err != nil {
+					return nil, err
+				}
+				return rep.Err(), nil
+			},
+		})
+	})
+
+	It("should evaluate scripts", func() {
+		Expect(serve("eval", "copy", "2", "foo", "baz", "!").Response()).To(Equal([]interface{}{"bar", int64(1)}))
+		Expect(data).To(HaveKeyWithValue("baz", "bar!"))
+
+		Expect(serve("eval", "missing", "0").Response()).To(MatchError("ERR Error running script: unknown script"))
+		Expect(serve("eval", "copy", "x").Response()).To(MatchError("ERR value is not an integer or out of range"))
+		Expect(serve("eval", "copy", "-1").Response()).To(MatchError("ERR Number of keys can't be negative"))
+		Expect(serve("eval", "copy", "2", "foo").Response()).To(MatchError("ERR Number of keys can't be greater than number of args"))
+	})
+
+	It("should restrict calls", func() {
+		Expect(serve("eval", "call", "0", "get").Response()).To(MatchError("ERR wrong number of arguments for 'get' command"))
+		Expect(serve("eval", "call", "0", "missing").Response()).To(MatchError("ERR Unknown Redis command called from script"))
+		Expect(serve("eval", "call", "0", "eval", "call", "0").Response()).To(MatchError("ERR This Redis command is not allowed from scripts"))
+		Expect(serve("eval", "call", "0", "boom").Response()).To(MatchError("ERR internal error: boom"))
+		Expect(subject.Info().TotalPanics()).To(Equal(int64(1)))
+	})
+
+	It("should cache scripts", func() {
+		sha := "f84e2e2dadd87384fb55f25886926b777e8378f1" // sha1("copy")
+		Expect(serve("evalsha", sha, "0").Response()).To(MatchError("NOSCRIPT No matching script. Please use EVAL."))
+		Expect(serve("script", "exists", sha).Response()).To(Equal([]interface{}{int64(0)}))
+
+		Expect(serve("script", "load", "copy").Response()).To(Equal(sha))
+		Expect(serve("script", "exists", sha, "abcd").Response()).To(Equal([]interface{}{int64(1), int64(0)}))
+		Expect(serve("evalsha", sha, "2", "foo", "baz", "?").Response()).To(Equal([]interface{}{"bar", int64(1)}))
+		Expect(data).To(HaveKeyWithValue("baz", "bar?"))
+
+		Expect(serve("script", "flush").Response()).To(Equal("OK"))
+		Expect(serve("script", "exists", sha).Response()).To(Equal([]interface{}{int64(0)}))
+	})
+
+})
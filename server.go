@@ -1,11 +1,18 @@
 package redeo
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"github.com/wangaoone/redeo/resp"
 	"io"
 	"net"
+	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,14 +20,79 @@ const (
 	ASYNC_CB_NAME = "callback"
 )
 
+// ErrServerClosed is returned by Serve after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("redeo: Server closed")
+
+var errMaxClients = errors.New("redeo: max number of clients reached")
+
+// shutdownPollInterval is the interval at which Shutdown checks
+// whether all clients have disconnected.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// maxAcceptDelay caps the back-off on temporary accept errors.
+const maxAcceptDelay = time.Second
+
+// defaultDatabases is the default for Config.Databases.
+const defaultDatabases = 16
+
+// Defaults for Config.MaxBulkLength and Config.MaxMultiBulkLength.
+const (
+	defaultMaxBulkLength      = 512 << 20
+	defaultMaxMultiBulkLength = 1 << 20
+)
+
 // Server configuration
 type Server struct {
-	config *Config
-	info   *ServerInfo
+	config   *Config            // as passed to NewServer
+	snapshot atomic.Value       // *Config, replaced by SetConfig
+	configMu sync.Mutex         // serialises SetConfig
+	tunables map[string]Tunable // see RegisterConfig, guarded by configMu
+
+	configPath string // see LoadConfig, guarded by configMu
+
+	info    *ServerInfo
+	slowlog *SlowLog
+	latency *LatencyMonitor
+
+	checkpoints checkpointStore // of interrupted resumable streams
+
+	accesses uint64 // commands seen by the access log, see Config.AccessLogSampling
+
+	monitors monitorSet
+	watches  watchSet
+	txMu     sync.RWMutex // held by EXEC, shared by single commands
+	tracking trackingTable
+	replicas replicaSet
+	journal  atomic.Value // *Journal, see StartJournal
+	events   eventBus
+	keyspace keyspaceBus
+	shards   shardPool
+	buffers  *bufferPool
+	workers  workerPool
 
-	cmds     map[string]interface{}
+	cmds     map[string]*handlerEntry
+	fallback *handlerEntry // see HandleDefault
+	table    atomic.Value  // *commandTable, published on changes
 	mu       sync.RWMutex
-//	released *sync.WaitGroup
+
+	clients   map[uint64]*Client // live clients
+	clientsMu sync.Mutex
+
+	middleware       []func(Handler) Handler
+	streamMiddleware []func(StreamHandler) StreamHandler
+
+	listeners map[net.Listener]bool // true if closed by the server
+	lisMu     sync.Mutex
+	closing   int32
+	backoff   int32 // number of accept loops backing off after errors
+
+	admin   *http.Server // see Config.AdminAddr
+	adminLn net.Listener
+	adminMu sync.Mutex
+
+	slots     int // number of connected clients, see MaxClients
+	slotsMu   sync.Mutex
+	slotsCond *sync.Cond
 }
 
 // NewServer creates a new server instance
@@ -29,45 +101,79 @@ func NewServer(config *Config) *Server {
 		config = new(Config)
 	}
 
-	return &Server{
-		config: config,
-		info:   newServerInfo(),
-		cmds:   make(map[string]interface{}),
+	srv := &Server{
+		config:  config,
+		info:    newServerInfo(),
+		slowlog: newSlowLog(config.SlowLogMaxLen),
+		latency: newLatencyMonitor(),
+		cmds:    make(map[string]*handlerEntry),
+		clients: make(map[uint64]*Client),
+
+		listeners: make(map[net.Listener]bool),
 	}
+	srv.slotsCond = sync.NewCond(&srv.slotsMu)
+	srv.snapshot.Store(config)
+	srv.buffers = defaultBuffers
+	if config.ReadBufferSize > 0 || config.WriteBufferSize > 0 {
+		srv.buffers = newBufferPool(config.ReadBufferSize, config.WriteBufferSize)
+	}
+	srv.workers = newWorkerPool(config.Workers)
+	srv.publish()
+	return srv
 }
 
 // Info returns the server info registry
 func (srv *Server) Info() *ServerInfo { return srv.info }
 
-// Handle registers a handler for a command.
-func (srv *Server) Handle(name string, h Handler) {
-	srv.mu.Lock()
-	srv.cmds[strings.ToLower(name)] = h
-	srv.mu.Unlock()
+// SlowLog returns the slow log
+func (srv *Server) SlowLog() *SlowLog { return srv.slowlog }
+
+// LatencyMonitor returns the latency monitor
+func (srv *Server) LatencyMonitor() *LatencyMonitor { return srv.latency }
+
+// Handle registers a handler for a command. Options may declare
+// constraints, such as MinArgs, which are checked before the handler
+// is called.
+func (srv *Server) Handle(name string, h Handler, opts ...HandlerOption) {
+	srv.handle(name, h, opts)
 }
 
 // HandleFunc registers a handler func for a command.
-func (srv *Server) HandleFunc(name string, fn HandlerFunc) {
-	srv.Handle(name, fn)
+func (srv *Server) HandleFunc(name string, fn HandlerFunc, opts ...HandlerOption) {
+	srv.Handle(name, fn, opts...)
+}
+
+// HandleFuncCtx registers a context-aware handler func for a command. The
+// context is cancelled once the client is closed or disconnected, or when
+// Shutdown stops waiting for the client.
+func (srv *Server) HandleFuncCtx(name string, fn ContextHandlerFunc, opts ...HandlerOption) {
+	srv.Handle(name, fn, opts...)
+}
+
+// HandleErrorFunc registers a handler func for a command, which may
+// return an error reply, see ErrorHandlerFunc.
+func (srv *Server) HandleErrorFunc(name string, fn ErrorHandlerFunc, opts ...HandlerOption) {
+	srv.Handle(name, fn, opts...)
+}
+
+// HandleWrapperFunc registers a wrapper func for a command, see WrapperFunc.
+func (srv *Server) HandleWrapperFunc(name string, fn WrapperFunc, opts ...HandlerOption) {
+	srv.Handle(name, fn, opts...)
 }
 
 // HandleStream registers a handler for a streaming command.
-func (srv *Server) HandleStream(name string, h StreamHandler) {
-	srv.mu.Lock()
-	srv.cmds[strings.ToLower(name)] = h
-	srv.mu.Unlock()
+func (srv *Server) HandleStream(name string, h StreamHandler, opts ...HandlerOption) {
+	srv.handle(name, h, opts)
 }
 
 // HandleStreamFunc registers a handler func for a command
-func (srv *Server) HandleStreamFunc(name string, fn StreamHandlerFunc) {
-	srv.HandleStream(name, fn)
+func (srv *Server) HandleStreamFunc(name string, fn StreamHandlerFunc, opts ...HandlerOption) {
+	srv.HandleStream(name, fn, opts...)
 }
 
 // Callback registers a handler for a callback command.
 func (srv *Server) HandleCallback(cb Callback) {
-	srv.mu.Lock()
-	srv.cmds[ASYNC_CB_NAME] = cb
-	srv.mu.Unlock()
+	srv.handle(ASYNC_CB_NAME, cb, nil)
 }
 
 // CallbackFunc registers a handler func for a command
@@ -75,152 +181,998 @@ func (srv *Server) HandleCallbackFunc(cbf CallbackFunc) {
 	srv.HandleCallback(cbf)
 }
 
+// Unhandle removes a command. Returns false if no such command was registered.
+func (srv *Server) Unhandle(name string) bool {
+	norm := strings.ToLower(name)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if _, ok := srv.cmds[norm]; !ok {
+		return false
+	}
+	delete(srv.cmds, norm)
+	srv.publish()
+	return true
+}
+
+// RenameCommand renames a command, like redis' rename-command directive.
+// Renaming to an empty string disables the command, an existing command
+// with the new name is replaced. Returns false if no command was
+// registered under the old name.
+func (srv *Server) RenameCommand(old, new string) bool {
+	oldNorm, newNorm := strings.ToLower(old), strings.ToLower(new)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	entry, ok := srv.cmds[oldNorm]
+	if !ok {
+		return false
+	}
+	delete(srv.cmds, oldNorm)
+	if newNorm != "" {
+		srv.cmds[newNorm] = entry
+	}
+	srv.publish()
+	return true
+}
+
+// Alias registers alias as an additional name for the target command,
+// sharing its handler and options. Returns false if no command was
+// registered under the target name.
+func (srv *Server) Alias(alias, target string) bool {
+	aliasNorm, targetNorm := strings.ToLower(alias), strings.ToLower(target)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	entry, ok := srv.cmds[targetNorm]
+	if !ok {
+		return false
+	}
+	srv.cmds[aliasNorm] = entry
+	srv.publish()
+	return true
+}
+
+// Commands returns the sorted names of all registered commands.
+func (srv *Server) Commands() []string {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	names := make([]string, 0, len(srv.cmds))
+	for name, entry := range srv.cmds {
+		if _, ok := entry.handler.(Callback); ok {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HandleDefault registers a fallback handler, which serves all commands
+// that have no handler of their own, instead of replying with an
+// unknown command error. The handler receives the command name as sent
+// by the client. Pass nil to remove it.
+func (srv *Server) HandleDefault(h Handler) {
+	if h == nil {
+		srv.handleFallback(nil)
+		return
+	}
+	srv.handleFallback(h)
+}
+
+// HandleDefaultStream registers a fallback handler for streaming
+// commands, see HandleDefault.
+func (srv *Server) HandleDefaultStream(h StreamHandler) {
+	if h == nil {
+		srv.handleFallback(nil)
+		return
+	}
+	srv.handleFallback(h)
+}
+
+// HandleDefaults registers the built-in PING, ECHO, QUIT and SELECT
+// handlers. If names are given, only the named handlers are registered.
+// Individual handlers can be overridden by registering another handler
+// under the same name afterwards. See HandleShutdown for SHUTDOWN.
+func (srv *Server) HandleDefaults(names ...string) {
+	defaults := map[string]Handler{
+		"ping":   Ping(),
+		"echo":   Echo(),
+		"quit":   Quit(),
+		"select": selectCommand(srv.databases),
+	}
+	if len(names) == 0 {
+		for name, h := range defaults {
+			srv.Handle(name, h)
+		}
+		return
+	}
+	for _, name := range names {
+		h, ok := defaults[strings.ToLower(name)]
+		if !ok {
+			panic("redeo: no default handler for " + name)
+		}
+		srv.Handle(name, h)
+	}
+}
+
+// HandleCommandIntrospection registers a COMMAND handler, which describes
+// all commands registered on the server. It supports the COMMAND COUNT,
+// INFO, LIST, DOCS and GETKEYS sub-commands. DOCS replies with empty
+// documentation, for compatibility with redis-cli.
+// https://redis.io/commands/command
+func (srv *Server) HandleCommandIntrospection() {
+	srv.Handle("command", commandIntrospection(srv))
+}
+
+// HandleClient registers a CLIENT handler, supporting the LIST, INFO, ID,
+// GETNAME, SETNAME, KILL and TRACKING sub-commands. Client-side caching
+// via TRACKING requires RESP3 and commands registered with the "readonly"
+// flag and key positions, see Keys and Server.Invalidate.
+// https://redis.io/commands/client-list
+func (srv *Server) HandleClient() {
+	srv.Handle("client", clientCommand(srv))
+}
+
+// HandleInfo registers an INFO handler, which reports the sections of
+// the server info, including custom ones added via Info().Fetch.
+// https://redis.io/commands/info
+func (srv *Server) HandleInfo() {
+	srv.Handle("info", Info(srv))
+}
+
+// HandleSlowLog registers a SLOWLOG handler, supporting the GET, LEN
+// and RESET sub-commands.
+// https://redis.io/commands/slowlog
+func (srv *Server) HandleSlowLog() {
+	srv.Handle("slowlog", slowLogCommand(srv.slowlog))
+}
+
+// HandleLatency registers a LATENCY handler, supporting the LATEST,
+// HISTORY and RESET sub-commands, see Config.LatencyMonitorThreshold.
+// https://redis.io/topics/latency-monitor
+func (srv *Server) HandleLatency() {
+	srv.Handle("latency", latencyCommand(srv.latency))
+}
+
+// HandleConfig registers a CONFIG handler, supporting the GET, SET,
+// REWRITE and RESETSTAT sub-commands, see SetConfig and LoadConfig.
+// https://redis.io/commands/config-set
+func (srv *Server) HandleConfig() {
+	srv.Handle("config", configCommand(srv))
+}
+
+// HandleSwapDB registers a SWAPDB handler, which validates both database
+// indexes and calls fn to swap the keyspaces. Errors returned by fn are
+// replied to the client.
+// https://redis.io/commands/swapdb
+func (srv *Server) HandleSwapDB(fn func(a, b int) error) {
+	srv.Handle("swapdb", swapDBCommand(srv, fn))
+}
+
+// HandleShutdown registers a SHUTDOWN handler, which calls fn, if not
+// nil, and then shuts down the server gracefully, see Shutdown. Remaining
+// connections are closed forcibly after timeout, unless zero. An error
+// returned by fn aborts the shutdown and is replied to the client,
+// otherwise the client is disconnected without a reply.
+// https://redis.io/commands/shutdown
+func (srv *Server) HandleShutdown(fn func(c *resp.Command) error, timeout time.Duration) {
+	srv.Handle("shutdown", shutdownCommand(srv, fn, timeout), Flags("noscript"))
+}
+
+// HandleDebug registers a DEBUG handler for fault injection, supporting
+// the SLEEP, QUIT and ERROR sub-commands, as well as JMAP, RELOAD and
+// SET-ACTIVE-EXPIRE as no-ops. DEBUG SLEEP only blocks the calling client,
+// DEBUG QUIT disconnects it without a reply. The Go specific GOROUTINES,
+// GC and PPROF <profile> [debug] sub-commands return a stack dump of all
+// goroutines, force a garbage collection and return a runtime/pprof
+// profile, respectively. DEBUG is not registered by default and should
+// not be exposed to untrusted clients.
+// https://redis.io/commands/debug
+func (srv *Server) HandleDebug() {
+	srv.Handle("debug", debugCommand())
+}
+
+// HandleMonitor registers a MONITOR handler, which streams all executed
+// commands to the calling client, and a RESET handler to end it.
+// Credentials passed to AUTH and HELLO are redacted.
+// https://redis.io/commands/monitor
+func (srv *Server) HandleMonitor() {
+	srv.Handle("monitor", monitorCommand(srv))
+	srv.Handle("reset", resetCommand(srv))
+}
+
+// Use appends a middleware to the chain of command handlers. Middleware
+// is applied in the order of registration, i.e. the first registered
+// middleware is the outermost one. Middleware applies to all
+// commands, including the ones registered before Use was called.
+//
+// Middleware may short-circuit a command by writing a reply
+// without invoking the next handler. Use CommandName to retrieve
+// the normalised name of the command that is being served. Panics
+// in middleware are recovered like panics in handlers.
+func (srv *Server) Use(mw func(Handler) Handler) {
+	srv.mu.Lock()
+	srv.middleware = append(srv.middleware, mw)
+	srv.rechain()
+	srv.mu.Unlock()
+}
+
+// UseStream appends a middleware to the chain of streaming command
+// handlers. See Use for details.
+func (srv *Server) UseStream(mw func(StreamHandler) StreamHandler) {
+	srv.mu.Lock()
+	srv.streamMiddleware = append(srv.streamMiddleware, mw)
+	srv.rechain()
+	srv.mu.Unlock()
+}
+
 // Serve accepts incoming connections on a listener, creating a
-// new service goroutine for each.
+// new service goroutine for each. Connections are served via TLS if
+// Config.TLSConfig is set.
 func (srv *Server) Serve(lis net.Listener) error {
-	return srv.serveImpl(lis, true)
+	return srv.serveImpl(lis, true, nil)
+}
+
+// ServeTLS accepts incoming TLS connections on a listener, like Serve.
+// The certificate and matching private key are loaded from certFile and
+// keyFile, unless Config.TLSConfig provides Certificates or
+// GetCertificate already.
+func (srv *Server) ServeTLS(lis net.Listener, certFile, keyFile string) error {
+	conf := new(tls.Config)
+	if tc := srv.conf().TLSConfig; tc != nil {
+		conf = tc.Clone()
+	}
+
+	if len(conf.Certificates) == 0 && conf.GetCertificate == nil || certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return srv.serveImpl(lis, true, conf)
 }
 
 func (srv *Server) ServeAsync(lis net.Listener) error {
-	return srv.serveImpl(lis, false)
+	return srv.serveImpl(lis, false, nil)
 }
 
-func (srv *Server) serveImpl(lis net.Listener, sync bool) error {
+func (srv *Server) serveImpl(lis net.Listener, sync bool, tlsConf *tls.Config) error {
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
+	if err := srv.startAdmin(); err != nil {
+		return err
+	}
+
+	srv.trackListener(lis, true)
+	defer srv.trackListener(lis, false)
+
+	switch addr := lis.Addr().(type) {
+	case *net.TCPAddr:
+		srv.info.port.Set(int64(addr.Port))
+	case *net.UnixAddr:
+		srv.info.socket.Set(addr.Name)
+	}
+
+	var tempDelay time.Duration // how long to sleep on accept failure
+	defer func() {
+		if tempDelay != 0 {
+			atomic.AddInt32(&srv.backoff, -1)
+		}
+	}()
 	for {
 		cn, err := lis.Accept()
 		if err != nil {
+			if srv.shuttingDown() || srv.closedListener(lis) {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+					atomic.AddInt32(&srv.backoff, 1)
+				} else {
+					tempDelay *= 2
+				}
+				if max := maxAcceptDelay; tempDelay > max {
+					tempDelay = max
+				}
+				if fn := srv.conf().AcceptErrorHandler; fn != nil {
+					fn(err)
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
 			return err
 		}
+		if tempDelay != 0 {
+			atomic.AddInt32(&srv.backoff, -1)
+			tempDelay = 0
+		}
 
-		if ka := srv.config.TCPKeepAlive; ka > 0 {
-			if tc, ok := cn.(*net.TCPConn); ok {
-				tc.SetKeepAlive(true)
-				tc.SetKeepAlivePeriod(ka)
+		if !srv.acquireSlot() {
+			if srv.shuttingDown() {
+				_ = cn.Close()
+				return ErrServerClosed
 			}
+			go srv.reject(cn)
+			continue
 		}
 
-		go srv.serveClient(newClient(cn), sync)
+		if srv.shuttingDown() {
+			srv.releaseSlot()
+			_ = cn.Close()
+			return ErrServerClosed
+		}
+
+		go srv.serveClient(srv.acceptConn(cn, tlsConf), sync)
+	}
+}
+
+// serveConn serves a connection accepted by a Router.
+func (srv *Server) serveConn(cn net.Conn) error {
+	if !srv.acquireSlot() {
+		srv.reject(cn)
+		return errMaxClients
+	}
+	if srv.shuttingDown() {
+		srv.releaseSlot()
+		_ = cn.Close()
+		return ErrServerClosed
 	}
+
+	return srv.serveClient(srv.acceptConn(cn, nil), true)
 }
 
 func (srv *Server) GetClient(id uint64) (*Client, bool) {
 	return srv.info.Client(id)
 }
 
-func (srv *Server) Close(lis net.Listener) {
-	lis.Close()
+// Close closes the given listeners or, if none are given, all listeners
+// the server is serving and the admin server, see Config.AdminAddr. Serve
+// returns ErrServerClosed for listeners closed this way. Connected clients
+// are unaffected, see Shutdown.
+func (srv *Server) Close(lis ...net.Listener) error {
+	if len(lis) == 0 {
+		srv.closeAdmin()
+	}
+
+	srv.lisMu.Lock()
+	if len(lis) == 0 {
+		for l := range srv.listeners {
+			lis = append(lis, l)
+		}
+	}
+	for _, l := range lis {
+		if _, ok := srv.listeners[l]; ok {
+			srv.listeners[l] = true
+		}
+	}
+	srv.lisMu.Unlock()
+
+	var err error
+	for _, l := range lis {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the server without interrupting any
+// commands in progress. Shutdown works by first closing all open listeners,
+// then closing all idle connections, and then waiting for the remaining
+// connections to finish their current pipelines, including outstanding
+// asynchronous commands, and disconnect. If the provided context expires before the shutdown is complete,
+// all remaining connections are closed forcibly and Shutdown returns
+// the context's error.
+//
+// Once Shutdown has been called, Serve returns ErrServerClosed. The admin
+// server, see Config.AdminAddr, reports the shutdown until it is complete.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&srv.closing, 1)
+	srv.closeListeners()
+	defer srv.closeAdmin()
+
+	// wake up accepts waiting for a free slot
+	srv.slotsMu.Lock()
+	srv.slotsCond.Broadcast()
+	srv.slotsMu.Unlock()
+
+	for _, client := range srv.liveClients() {
+		client.drain()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for srv.info.NumClients() != 0 {
+		select {
+		case <-ctx.Done():
+			for _, client := range srv.liveClients() {
+				client.terminate()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
 }
 
+// acquireSlot reserves a slot for a new client. It returns false if
+// MaxClients is reached, unless MaxClientsWait is set, in which case it
+// blocks until a slot is freed or the server shuts down.
+func (srv *Server) acquireSlot() bool {
+	srv.slotsMu.Lock()
+	defer srv.slotsMu.Unlock()
+
+	// slots are counted even without a limit, as
+	// MaxClients may be changed via SetConfig
+	for {
+		conf := srv.conf()
+		switch {
+		case conf.MaxClients < 1:
+		case srv.shuttingDown():
+			return false
+		case srv.slots >= conf.MaxClients:
+			if conf.MaxClientsWait {
+				srv.slotsCond.Wait()
+				continue
+			}
+			return false
+		}
+		srv.slots++
+		return true
+	}
+}
+
+// releaseSlot frees a slot reserved via acquireSlot.
+func (srv *Server) releaseSlot() {
+	srv.slotsMu.Lock()
+	srv.slots--
+	srv.slotsCond.Signal()
+	srv.slotsMu.Unlock()
+}
+
+// reject replies with an error and closes connections over the limit.
+func (srv *Server) reject(cn net.Conn) {
+	srv.info.rejected.Inc(1)
+
+	_ = cn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = cn.Write([]byte("-ERR max number of clients reached\r\n"))
+	_ = cn.Close()
+}
+
+func (srv *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&srv.closing) != 0
+}
+
+func (srv *Server) trackListener(lis net.Listener, add bool) {
+	srv.lisMu.Lock()
+	if !add {
+		delete(srv.listeners, lis)
+	} else if _, ok := srv.listeners[lis]; !ok {
+		srv.listeners[lis] = false
+	}
+	srv.lisMu.Unlock()
+}
+
+// closedListener returns true if lis was closed via Close.
+func (srv *Server) closedListener(lis net.Listener) bool {
+	srv.lisMu.Lock()
+	closed := srv.listeners[lis]
+	srv.lisMu.Unlock()
+	return closed
+}
+
+func (srv *Server) closeListeners() {
+	srv.lisMu.Lock()
+	for lis := range srv.listeners {
+		_ = lis.Close()
+	}
+	srv.lisMu.Unlock()
+}
+
+// Release closes all connected clients and waits for them to disconnect.
 func (srv *Server) Release() {
-	// srv.mu.Lock()
-	// srv.released = &sync.WaitGroup{}
-	// srv.mu.Unlock()
+	_ = srv.CloseAll(context.Background())
+}
+
+// CloseClient closes the client with the given ID once its pending
+// replies are written. Returns false if no such client is connected.
+func (srv *Server) CloseClient(id uint64) bool {
+	srv.clientsMu.Lock()
+	c, ok := srv.clients[id]
+	srv.clientsMu.Unlock()
+
+	if ok {
+		c.Close()
+	}
+	return ok
+}
+
+// KillClient disconnects the client with the given ID immediately,
+// pending replies are discarded. The reason is passed to
+// Config.OnClientError. Returns false if no such client is connected.
+func (srv *Server) KillClient(id uint64, reason string) bool {
+	srv.clientsMu.Lock()
+	c, ok := srv.clients[id]
+	srv.clientsMu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	ci, _ := srv.info.clients.Info(id)
+	if !c.kill() {
+		return false
+	}
+
+	srv.info.killed.Inc(1)
+	if fn := srv.conf().OnClientError; fn != nil && ci != nil {
+		fn(ci, &KilledError{Reason: reason})
+	}
+	return true
+}
+
+// CloseAll closes all connected clients and waits for them to disconnect.
+// If the context expires first, the remaining connections are terminated
+// and the context's error is returned.
+func (srv *Server) CloseAll(ctx context.Context) error {
+	clients := srv.liveClients()
+	for _, c := range clients {
+		c.Close()
+	}
+
+	for i, c := range clients {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			for _, c := range clients[i:] {
+				c.terminate()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Broadcast pushes a message to all connected clients accepted by filter,
+// or to all clients if filter is nil, see Client.Push. Replicas are
+// skipped. Returns the number of clients the message was written or
+// queued for.
+func (srv *Server) Broadcast(fn func(w resp.ResponseWriter), filter func(c *Client) bool) int {
+	var n int
+	for _, c := range srv.liveClients() {
+		if srv.replicas.has(c.ID()) || filter != nil && !filter(c) {
+			continue
+		}
+		if c.Push(fn) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// liveClients returns a snapshot of the connected clients.
+func (srv *Server) liveClients() []*Client {
+	srv.clientsMu.Lock()
+	defer srv.clientsMu.Unlock()
+
+	clients := make([]*Client, 0, len(srv.clients))
+	for _, c := range srv.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+func (srv *Server) handleFallback(h interface{}) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.fallback = nil
+	if h != nil {
+		srv.fallback = &handlerEntry{
+			handler: h,
+			served:  srv.chain(h),
+			spec:    newCommandSpec(nil),
+		}
+	}
+	srv.publish()
+}
+
+func (srv *Server) handle(name string, h interface{}, opts []HandlerOption) {
+	srv.mu.Lock()
+	srv.cmds[strings.ToLower(name)] = &handlerEntry{
+		handler: h,
+		served:  srv.chain(h),
+		spec:    newCommandSpec(opts),
+	}
+	srv.publish()
+	srv.mu.Unlock()
+}
 
-	for _, client := range srv.info.Clients() {
-		// srv.released.Add(1)
-		client.Close()
+// chain wraps a handler in the registered middleware, must be called
+// with the lock held.
+func (srv *Server) chain(h interface{}) interface{} {
+	switch handler := h.(type) {
+	case Handler:
+		for i := len(srv.middleware) - 1; i >= 0; i-- {
+			handler = srv.middleware[i](handler)
+		}
+		return handler
+	case StreamHandler:
+		for i := len(srv.streamMiddleware) - 1; i >= 0; i-- {
+			handler = srv.streamMiddleware[i](handler)
+		}
+		return handler
 	}
-	// srv.released.Wait()
-	// srv.released = nil
+	return h
+}
+
+// commandDescriptions returns the descriptions of all registered
+// commands, sorted by name.
+func (srv *Server) commandDescriptions() []CommandDescription {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	descs := make([]CommandDescription, 0, len(srv.cmds))
+	for name, entry := range srv.cmds {
+		if _, ok := entry.handler.(Callback); ok {
+			continue
+		}
+		descs = append(descs, entry.describe(name))
+	}
+	sort.Slice(descs, func(i, j int) bool { return descs[i].Name < descs[j].Name })
+	return descs
+}
+
+// commandDescription returns the description of a single command.
+func (srv *Server) commandDescription(name string) (CommandDescription, bool) {
+	norm := strings.ToLower(name)
+
+	srv.mu.RLock()
+	entry, ok := srv.cmds[norm]
+	srv.mu.RUnlock()
+
+	if !ok {
+		return CommandDescription{}, false
+	}
+	if _, ok := entry.handler.(Callback); ok {
+		return CommandDescription{}, false
+	}
+	return entry.describe(norm), true
+}
+
+// rechain re-applies the middleware to all registered handlers, must be
+// called with the lock held. Entries are replaced rather than modified,
+// as they may be in use by published tables.
+func (srv *Server) rechain() {
+	for name, entry := range srv.cmds {
+		srv.cmds[name] = entry.rechained(srv)
+	}
+	if srv.fallback != nil {
+		srv.fallback = srv.fallback.rechained(srv)
+	}
+	srv.publish()
+}
+
+// newClient creates a client for cn, with buffers from the server's pool.
+func (srv *Server) newClient(cn net.Conn) *Client {
+	c := new(Client)
+	c.reset(cn, srv.buffers)
+	srv.limitReader(c.rd)
+	return c
+}
+
+// limitReader applies the configured request size limits to rd.
+func (srv *Server) limitReader(rd *resp.RequestReader) *resp.RequestReader {
+	conf := srv.conf()
+	maxBulk, maxMultiBulk := conf.MaxBulkLength, conf.MaxMultiBulkLength
+	if maxBulk == 0 {
+		maxBulk = defaultMaxBulkLength
+	} else if maxBulk < 0 {
+		maxBulk = 0
+	}
+	if maxMultiBulk == 0 {
+		maxMultiBulk = defaultMaxMultiBulkLength
+	} else if maxMultiBulk < 0 {
+		maxMultiBulk = 0
+	}
+	rd.SetLimits(maxBulk, maxMultiBulk)
+	return rd
 }
 
 func (srv *Server) register(c *Client) {
+	srv.clientsMu.Lock()
+	srv.clients[c.id] = c
+	srv.clientsMu.Unlock()
+
 	srv.info.register(c)
 }
 
 func (srv *Server) deregister(clientID uint64) {
 	srv.info.deregister(clientID)
-	// go func() {
-	// 	srv.mu.Lock()
-	// 	defer srv.mu.Unlock()
-	//
-	// 	if srv.released != nil {
-	// 		srv.released.Done()
-	// 	}
-	// }()
+
+	srv.clientsMu.Lock()
+	delete(srv.clients, clientID)
+	srv.clientsMu.Unlock()
 }
 
 // Starts a new session, serving client
 func (srv *Server) serveClient(c *Client, sync bool) error {
+	// Free the slot on exit
+	defer srv.releaseSlot()
+
 	// Release client on exit
 	defer c.release()
 
+	start := srv.latencyStart()
+	if err := srv.handshake(c); err != nil {
+		return err
+	}
+	srv.noteLatency(LatencyAccept, start)
+
 	// Register client
 	srv.register(c)
 	defer srv.deregister(c.id)
+	defer srv.watches.unwatch(c)
+
+	// Do not serve clients that sneaked in during shutdown
+	if srv.shuttingDown() {
+		c.Close()
+	}
 
 	if !sync {
+		c.async = true
 		go srv.handleResponses(c)
 	}
-	return srv.handleRequests(c)
+
+	ctx := context.Background()
+	if fn := srv.conf().Hooks.OnConnect; fn != nil {
+		if hctx := fn(c.RemoteAddr().String()); hctx != nil {
+			ctx = hctx
+		}
+	}
+	c.mu.Lock()
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	if c.closed {
+		c.cancel()
+	}
+	c.mu.Unlock()
+
+	c.writeTimeout = srv.conf().WriteTimeout
+	c.traceArg = srv.conf().TraceArg
+	c.mc.readTimeout = srv.conf().ReadTimeout
+	if policy := srv.conf().Flush; policy.Interval > 0 && !policy.Manual {
+		defer c.startFlusher(policy.Interval)()
+	}
+
+	if fn := srv.conf().OnConnect; fn != nil {
+		if err := fn(c); err != nil {
+			srv.info.rejected.Inc(1)
+			c.wr.AppendError(err.Error())
+			return c.flush()
+		}
+	}
+	if fn := srv.conf().OnDisconnect; fn != nil {
+		defer fn(c)
+	}
+
+	err := srv.handleRequests(c)
+	if err != nil && err != io.EOF && !c.isKilled() {
+		srv.dropped(c, err)
+	}
+	return err
+}
+
+// dropped records a connection dropped due to an error.
+func (srv *Server) dropped(c *Client, err error) {
+	switch {
+	case err == errOutputBufferLimit:
+		srv.info.killedOutputBuffer.Inc(1)
+	case err == errOutputBufferSoftLimit:
+		srv.info.killedSoftOutputBuffer.Inc(1)
+	case c.werr != nil:
+		srv.info.droppedWrite.Inc(1)
+	case resp.IsProtocolError(err):
+		srv.info.droppedProtocol.Inc(1)
+	case isTimeout(err):
+		srv.info.droppedTimeout.Inc(1)
+	default:
+		srv.info.droppedRead.Inc(1)
+	}
+
+	if fn := srv.conf().OnClientError; fn != nil {
+		if ci, ok := srv.info.clients.Info(c.id); ok {
+			fn(ci, err)
+		}
+	}
 }
 
 func (srv *Server) handleRequests(c *Client) error {
 	// Create perform callback
+	var started bool
 	perform := func(name string) error {
+		if !started {
+			started = true
+			srv.execDeadline(c)
+		}
 		return srv.perform(c, name)
 	}
+	defer srv.workers.release(c)
+
 	// Init request/response loop
-	for !c.closed {
+	for {
 		// set deadline
-		if d := srv.config.Timeout; d > 0 {
-			c.cn.SetDeadline(time.Now().Add(d))
+		started = false
+		srv.idleDeadline(c)
+
+		// wait for the next pipeline, unless closed
+		if !c.idle() {
+			return srv.drain(c)
 		}
 
-		// perform pipeline
-		if err := c.pipeline(perform); err != nil {
+		// perform pipeline, collect replies from shard workers and
+		// of completed asynchronous commands
+		err := srv.awaitRequest(c)
+		if err == nil {
+			err = c.pipeline(perform)
+		}
+		srv.collect(c)
+		srv.appendResolved(c)
+		if c.isKilled() {
+			return nil
+		}
+		if err != nil {
 			if err == io.EOF {
 				return err
 			}
-			c.wr.AppendError("ERR " + err.Error())
+			// read was interrupted by Close
+			if c.isClosed() {
+				return srv.drain(c)
+			}
+			// client was idle for too long
+			if !started && srv.conf().IdleTimeout > 0 && isTimeout(err) {
+				srv.info.idle.Inc(1)
+				return nil
+			}
+			// read and write errors are fatal
+			if c.werr != nil || !resp.IsProtocolError(err) {
+				return err
+			}
 
-			if !resp.IsProtocolError(err) {
-				err := c.wr.Flush()
-				if err != nil {
-					return err
-				}
+			srv.settle(c)
+			c.wr.AppendError(resp.ErrorReply(err))
+			if resp.IsFatalProtocolError(err) {
+				_ = c.flush()
+				return err
 			}
 		}
 
 		// flush buffer, return on errors
-		if err := c.wr.Flush(); err != nil {
+		if err := srv.checkOutput(c); err != nil {
+			return err
+		}
+		start := srv.latencyStart()
+		if err := c.flush(); err != nil {
 			return err
 		}
+		srv.noteLatency(LatencyFlush, start)
 	}
-	return nil
+}
+
+// drain writes the replies of outstanding asynchronous commands of
+// a closed client.
+func (srv *Server) drain(c *Client) error {
+	if len(c.promises) == 0 {
+		return nil
+	}
+	srv.settle(c)
+	return c.flush()
+}
+
+// idleDeadline sets the deadline while waiting for the next pipeline.
+func (srv *Server) idleDeadline(c *Client) {
+	now := time.Now()
+	c.deadline = time.Time{}
+	if d := srv.conf().IdleTimeout; d > 0 {
+		c.cn.SetReadDeadline(now.Add(d))
+		c.cn.SetWriteDeadline(time.Time{})
+	} else if d := srv.conf().Timeout; d > 0 {
+		c.deadline = now.Add(d)
+		c.cn.SetDeadline(c.deadline)
+	}
+}
+
+// execDeadline replaces the idle deadline once a pipeline has begun.
+func (srv *Server) execDeadline(c *Client) {
+	if srv.conf().IdleTimeout <= 0 {
+		return
+	}
+	if d := srv.conf().Timeout; d > 0 {
+		c.deadline = time.Now().Add(d)
+		c.cn.SetDeadline(c.deadline)
+	} else {
+		c.cn.SetReadDeadline(time.Time{})
+	}
+}
+
+func isTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
 }
 
 func (srv *Server) handleResponses(c *Client) {
 	// All response should be handled.
 	for response := range c.responses {
 		// find handler
-		srv.mu.RLock()
-		cb, ok := srv.cmds[ASYNC_CB_NAME]
-		srv.mu.RUnlock()
-
+		entry, ok := srv.table.Load().(*commandTable).cmds[ASYNC_CB_NAME]
 		if !ok {
 			c.wr.AppendError(UnknownCommand(ASYNC_CB_NAME))
 			// Nothing can be done on error
+			c.setWriteDeadline()
 			c.wr.Flush()
 			continue
 		}
 
-		cb.(Callback).ServeCallback(c.wr, response)
+		entry.served.(Callback).ServeCallback(c.wr, response)
 
 		// Nothing can be done on error
+		c.setWriteDeadline()
 		c.wr.Flush()
 	}
 }
 
 func (srv *Server) perform(c *Client, name string) (err error) {
-	norm := strings.ToLower(name)
+	norm := srv.normalize(name)
+
+	// apply rate limits
+	if !srv.throttle(c) {
+		srv.collect(c)
+		srv.settle(c)
+		c.wr.AppendError("ERR rate limit exceeded")
+		return c.rd.SkipCmd()
+	}
+
+	// wait for asynchronous commands, unless this one is too
+	if len(c.promises) != 0 {
+		if _, ok := srv.asyncable(c, norm); !ok {
+			srv.settle(c)
+		}
+	}
+
+	// dispatch commands with keys to shard workers
+	if srv.conf().ShardedDispatch != nil {
+		if ok, err := srv.performSharded(c, name, norm); ok {
+			return err
+		}
+	}
+
+	// require authentication
+	if srv.authRequired() {
+		if ok, err := srv.performAuth(c, name, norm); ok {
+			return err
+		}
+	}
+
+	// queue commands within transactions
+	if srv.conf().Transactions {
+		if ok, err := srv.performTx(c, name, norm); ok {
+			return err
+		}
+	}
 
 	// find handler
-	srv.mu.RLock()
-	h, ok := srv.cmds[norm]
-	srv.mu.RUnlock()
+	entry, ok := srv.lookup(norm)
 
 	if !ok {
+		srv.info.unknown.Inc(1)
 		c.wr.AppendError(UnknownCommand(name))
 		_ = c.rd.SkipCmd()
 		return
@@ -228,29 +1180,214 @@ func (srv *Server) perform(c *Client, name string) (err error) {
 
 	// register call
 	srv.info.command(c.id, norm)
+	c.cmdName = norm
 
-	switch handler := h.(type) {
+	// asynchronous commands track their own execution
+	if handler, ok := entry.served.(AsyncHandler); ok {
+		return srv.performAsync(c, norm, entry, handler)
+	}
+
+	// track execution stats, recover from handler panics
+	c.rw.begin()
+	c.args, c.ran = nil, false
+	defer srv.observe(c, entry, norm, time.Now())
+
+	var end func(error)
+	if srv.conf().Hooks.OnCommandStart != nil {
+		defer func() {
+			if end != nil {
+				end(c.rw.result(err))
+			}
+		}()
+	}
+	defer srv.recoverPanic(&c.rw, norm)
+
+	switch handler := entry.served.(type) {
 	case Handler:
-		if c.cmd, err = c.readCmd(c.cmd); err != nil {
+		c.cmd, err = c.readCmd(c.cmd)
+		if srv.conf().Hooks.OnCommandStart != nil {
+			end = srv.startCommand(c, c.cmd, norm, err)
+		}
+		if err != nil {
+			return
+		}
+		if cancel := c.withDeadline(c.cmd); cancel != nil {
+			defer cancel()
+		}
+		c.args = c.cmd.Args
+		if c.takeResume() != nil {
+			c.rw.AppendError(notResumable(c.cmd.Name))
+			return
+		}
+		if msg := entry.spec.check(c.cmd); msg != "" {
+			c.rw.AppendError(msg)
 			return
 		}
+		if err := srv.authorize(c, norm, c.args); err != nil {
+			c.rw.AppendError(err.Error())
+			return nil
+		}
+		srv.feedMonitors(c, c.cmd.Name, c.args)
 
-		handler.ServeRedeo(c.wr, c.cmd)
+		c.ran = true
+		srv.serveCmd(handler, &c.rw, c.cmd)
 
 	case StreamHandler:
-		if c.scmd, err = c.streamCmd(c.scmd); err != nil {
+		c.scmd, err = c.streamCmd(c.scmd)
+		if srv.conf().Hooks.OnCommandStart != nil {
+			end = srv.startCommand(c, c.scmd, norm, err)
+		}
+		if err != nil {
 			return
 		}
+		if cancel := c.withDeadline(c.scmd); cancel != nil {
+			defer cancel()
+		}
 		defer c.scmd.Discard()
+		cp, msg := srv.resumeStream(c, entry, norm, c.scmd)
+		if msg != "" {
+			c.rw.AppendError(msg)
+			return
+		}
+		if !entry.spec.validArgs(c.scmd.ArgN()) {
+			c.rw.AppendError(WrongNumberOfArgs(c.scmd.Name))
+			return
+		}
+		if err := srv.authorize(c, norm, nil); err != nil {
+			c.rw.AppendError(err.Error())
+			return nil
+		}
+		srv.feedMonitors(c, c.scmd.Name, nil)
 
-		handler.ServeRedeoStream(c.wr, c.scmd)
+		if cp != nil {
+			defer srv.checkpoint(c.scmd, *cp)
+		}
+		c.ran = true
+		srv.serveStream(handler, &c.rw, c.scmd)
 	}
 
-	// flush when buffer is large enough
-	if n := c.wr.Buffered(); n > resp.MaxBufferSize/2 {
-		err = c.wr.Flush()
+	// suspend the client, if blocked by the handler
+	if c.blocker != nil {
+		if err = srv.await(c); err != nil {
+			return
+		}
+	}
+
+	// flush as configured
+	return srv.autoFlush(c)
+}
+
+// observe records the execution of a command, started at start.
+func (srv *Server) observe(c *Client, entry *handlerEntry, name string, start time.Time) {
+	srv.record(c, entry, name, c.args, start, c.ran, &c.rw)
+}
+
+// record records the execution of a command, started at start, whether
+// performed inline, by a shard worker or asynchronously. Commands which
+// have not run, e.g. rejected ones, are not emitted, propagated or tracked.
+func (srv *Server) record(c *Client, entry *handlerEntry, name string, args []resp.CommandArgument, start time.Time, ran bool, rw *replyWriter) {
+	d := time.Since(start)
+	srv.info.observe(name, d, rw.failed)
+	if ran {
+		srv.emit(c.id, entry, name, args, start, rw.failed)
+		srv.propagate(c, entry, name, args, rw.failed)
+		if !rw.failed {
+			srv.trackKeys(c, entry, name, args)
+		}
+	}
+
+	if t := srv.conf().SlowLogThreshold; t > 0 && d > t {
+		srv.slowlog.add(start, d, name, args, c.RemoteAddr().String(), c.Name())
+	}
+	srv.observeLatency(LatencyCommand, start, d)
+	srv.logAccess(c, name, args, start, d, rw)
+}
+
+// tracedCommand is implemented by resp.Command and resp.CommandStream.
+type tracedCommand interface {
+	ArgN() int
+	Context() context.Context
+	SetContext(context.Context)
+}
+
+// startCommand calls the OnCommandStart hook for a command which was read
+// with the given error. It returns the function to call on completion.
+func (srv *Server) startCommand(c *Client, cmd tracedCommand, name string, err error) func(error) {
+	ctx, nargs := c.cmdContext(), 0
+	if err == nil {
+		ctx, nargs = cmd.Context(), cmd.ArgN()
+	}
+
+	tctx, end := srv.conf().Hooks.OnCommandStart(ctx, name, nargs)
+	if err == nil && tctx != nil {
+		cmd.SetContext(tctx)
+	}
+	return end
+}
+
+// recoverPanic recovers from panics in command handlers and replies
+// with an error, keeping the connection alive. Clients are disconnected
+// if the handler has written a partial reply which cannot be discarded.
+// Must be deferred.
+func (srv *Server) recoverPanic(w *replyWriter, name string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	srv.notePanic(name, r)
+	if w.written() {
+		if w.discard == nil {
+			w.failed, w.errMsg = true, fmt.Sprintf("ERR internal error: %v", r)
+			if w.client != nil {
+				w.client.kill()
+			}
+			return
+		}
+		w.discard()
+	}
+
+	if fn := srv.conf().PanicReply; fn != nil {
+		w.AppendError(fn(name, r))
+	} else {
+		w.AppendError(fmt.Sprintf("ERR internal error: %v", r))
+	}
+}
+
+// notePanic counts and reports a recovered panic.
+func (srv *Server) notePanic(name string, r interface{}) {
+	srv.info.panics.Inc(1)
+	if fn := srv.conf().PanicHandler; fn != nil {
+		fn(name, r)
+	}
+}
+
+// --------------------------------------------------------------------
+
+// handlerEntry is a registered command handler.
+type handlerEntry struct {
+	handler interface{} // the handler, as registered
+	served  interface{} // the handler, wrapped in middleware
+	spec    commandSpec
+}
+
+// rechained returns a copy of the entry, wrapped in the current
+// middleware, must be called with the lock held.
+func (e *handlerEntry) rechained(srv *Server) *handlerEntry {
+	c := *e
+	c.served = srv.chain(c.handler)
+	return &c
+}
+
+func (e *handlerEntry) describe(name string) CommandDescription {
+	return CommandDescription{
+		Name:         name,
+		Arity:        e.spec.arity(),
+		Flags:        e.spec.flags,
+		FirstKey:     int64(e.spec.keys.First),
+		LastKey:      int64(e.spec.keys.Last),
+		KeyStepCount: int64(e.spec.keys.Step),
 	}
-	return
 }
 
 /*
@@ -259,5 +1396,9 @@ func (srv *Server) perform(c *Client, name string) (err error) {
 
 // Lambda facing serve client
 func (srv *Server) ServeForeignClient(cn net.Conn) error {
-	return srv.serveClient(newClient(cn), true)
+	if !srv.acquireSlot() {
+		srv.reject(cn)
+		return errMaxClients
+	}
+	return srv.serveClient(srv.newClient(cn), true)
 }
@@ -1,13 +1,23 @@
 package redeo
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/wangaoone/redeo/client"
 	"github.com/wangaoone/redeo/resp"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -68,7 +78,7 @@ var _ = Describe("Server", func() {
 		}
 	)
 
-	var runServer = func(srv *Server, fn func(net.Conn, *resp.RequestWriter, resp.ResponseReader)) {
+	var runServer = func(srv *Server, fn func(net.Conn, client.Conn)) {
 		lis, err := net.Listen("tcp", "127.0.0.1:0")
 		Expect(err).NotTo(HaveOccurred())
 		defer lis.Close()
@@ -81,7 +91,7 @@ var _ = Describe("Server", func() {
 		Expect(err).NotTo(HaveOccurred())
 		defer cn.Close()
 
-		fn(cn, resp.NewRequestWriter(cn), resp.NewResponseReader(cn))
+		fn(cn, client.Wrap(cn))
 	}
 
 	BeforeEach(func() {
@@ -100,12 +110,135 @@ var _ = Describe("Server", func() {
 		Expect(subject.cmds).To(HaveKey("ping"))
 	})
 
+	It("should unregister and rename handlers", func() {
+		Expect(subject.Commands()).To(Equal([]string{"echo", "flush", "ping", "quit", "stream"}))
+
+		Expect(subject.Unhandle("FLUSH")).To(BeTrue())
+		Expect(subject.Unhandle("flush")).To(BeFalse())
+		Expect(subject.RenameCommand("ECHO", "say")).To(BeTrue())
+		Expect(subject.RenameCommand("stream", "")).To(BeTrue())
+		Expect(subject.RenameCommand("missing", "x")).To(BeFalse())
+		Expect(subject.Commands()).To(Equal([]string{"ping", "quit", "say"}))
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("FLUSH")
+			c.WriteCmdString("ECHO", "a")
+			c.WriteCmdString("STREAM", `{"N":1,"S":"x"}`)
+			c.WriteCmdString("SAY", "b")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadError()).To(Equal("ERR unknown command 'FLUSH'"))
+			Expect(c.ReadError()).To(Equal("ERR unknown command 'ECHO'"))
+			Expect(c.ReadError()).To(Equal("ERR unknown command 'STREAM'"))
+			Expect(c.ReadBulkString()).To(Equal("b"))
+		})
+	})
+
+	It("should alias and namespace handlers", func() {
+		Expect(subject.Alias("say", "ECHO")).To(BeTrue())
+		Expect(subject.Alias("x", "missing")).To(BeFalse())
+
+		ns := subject.Namespace("myapp")
+		ns.HandleFunc("Echo", echo, Arity(2))
+		Expect(ns.Name("stats")).To(Equal("myapp.stats"))
+		Expect(subject.Commands()).To(Equal([]string{"echo", "flush", "myapp.echo", "ping", "quit", "say", "stream"}))
+		Expect(subject.normalize("MyApp.ECHO")).To(Equal("myapp.echo"))
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmdString("SAY", "a")
+			c.WriteCmdString("MYAPP.ECHO", "b")
+			c.WriteCmdString("myapp.echo")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadBulkString()).To(Equal("a"))
+			Expect(c.ReadBulkString()).To(Equal("b"))
+			Expect(c.ReadError()).To(Equal("ERR wrong number of arguments for 'myapp.echo' command"))
+		})
+
+		Expect(ns.Unhandle("echo")).To(BeTrue())
+		Expect(subject.Commands()).NotTo(ContainElement("myapp.echo"))
+	})
+
+	It("should register and unregister handlers while serving", func() {
+		srv := subject
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				srv.Unhandle("echo")
+				srv.HandleFunc("echo", echo)
+				srv.Commands()
+				time.Sleep(time.Microsecond)
+			}
+		}()
+		defer wg.Wait()
+		defer close(done)
+
+		runServer(srv, func(cn net.Conn, c client.Conn) {
+			for i := 0; i < 100; i++ {
+				c.WriteCmdString("ECHO", "x")
+				c.WriteCmdString("PING")
+				Expect(c.Flush()).To(Succeed())
+
+				if t, _ := c.PeekType(); t == resp.TypeError {
+					Expect(c.ReadError()).To(Equal("ERR unknown command 'ECHO'"))
+				} else {
+					Expect(c.ReadBulkString()).To(Equal("x"))
+				}
+				Expect(c.ReadInlineString()).To(Equal("PONG"))
+			}
+		})
+	})
+
+	It("should swap handler sets", func() {
+		version := func(v string) *HandlerSet {
+			set := NewHandlerSet()
+			set.HandleFunc("version", func(w resp.ResponseWriter, _ *resp.Command) { w.AppendBulkString(v) }, Arity(1))
+			set.Unhandle("flush")
+			return set
+		}
+		subject.SetHandlers(version("1"))
+		Expect(subject.Commands()).To(Equal([]string{"echo", "ping", "quit", "stream", "version"}))
+
+		srv := subject
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				srv.SetHandlers(version(strconv.Itoa(1 + i%2)))
+				time.Sleep(time.Microsecond)
+			}
+		}()
+		defer wg.Wait()
+		defer close(done)
+
+		runServer(srv, func(cn net.Conn, c client.Conn) {
+			for i := 0; i < 100; i++ {
+				c.WriteCmd("VERSION")
+				Expect(c.Flush()).To(Succeed())
+				Expect(c.ReadBulkString()).To(Or(Equal("1"), Equal("2")))
+			}
+		})
+	})
+
 	It("should serve", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
-			cw.WriteCmd("PING")
-			Expect(cw.Flush()).To(Succeed())
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
 
-			s, err := cr.ReadInlineString()
+			s, err := c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("PONG"))
 
@@ -115,10 +248,10 @@ var _ = Describe("Server", func() {
 			Expect(info.TotalConnections()).To(Equal(int64(1)))
 			Expect(info.ClientInfo()[0].LastCmd).To(Equal("ping"))
 
-			cw.WriteCmdString("echo", strings.Repeat("x", 10000))
-			Expect(cw.Flush()).To(Succeed())
+			c.WriteCmdString("echo", strings.Repeat("x", 10000))
+			Expect(c.Flush()).To(Succeed())
 
-			s, err = cr.ReadBulkString()
+			s, err = c.ReadBulkString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(len(s)).To(Equal(10000))
 
@@ -131,180 +264,960 @@ var _ = Describe("Server", func() {
 	})
 
 	It("should serve streams", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
-			cw.WriteCmdString("STREAM", `{"n":8,"s":"hello"}`)
-			Expect(cw.Flush()).To(Succeed())
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			r, err := c.Cmd("STREAM", `{"n":8,"s":"hello"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Str()).To(Equal("hello.8"))
+		})
+	})
+
+	It("should serve bytes", func() {
+		out := subject.ServeBytes([]byte("PING\r\n*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n"))
+		Expect(string(out)).To(Equal("+PONG\r\n$5\r\nhello\r\n"))
+		Expect(subject.Info().TotalConnections()).To(Equal(int64(1)))
+		Expect(subject.Info().NumClients()).To(Equal(0))
+	})
+
+	It("should serve PING without allocations", func() {
+		srv := NewServer(nil)
+		srv.Handle("ping", Ping())
+
+		serve := func(n int) float64 {
+			pipe := []byte(strings.Repeat("PING\r\n*1\r\n$4\r\nPING\r\n", n))
+			return testing.AllocsPerRun(10, func() { srv.ServeBytes(pipe) })
+		}
+		base := serve(1)
+		Expect((serve(501) - base) / 1000).To(BeNumerically("<", 0.05))
+	})
+
+	It("should serve client commands", func() {
+		subject.HandleFunc("nested", func(w resp.ResponseWriter, _ *resp.Command) {
+			w.AppendArrayLen(3)
+			w.AppendBulkString("a")
+			w.AppendArrayLen(2)
+			w.AppendInt(1)
+			w.AppendNil()
+			w.AppendArrayLen(0)
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			r, err := c.Cmd("ECHO", 42)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Int()).To(Equal(int64(42)))
+
+			r, err = c.Cmd("ECHO")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Err()).To(MatchError("ERR wrong number of arguments for 'ECHO' command"))
+
+			r, err = c.Cmd("QUIT")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Str()).To(Equal("OK"))
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			r, err := c.Cmd("NESTED")
+			Expect(err).NotTo(HaveOccurred())
+			elems, err := r.Slice()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(elems).To(HaveLen(3))
+			Expect(elems[0].Str()).To(Equal("a"))
+			inner, err := elems[1].Slice()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(inner[0].Int()).To(Equal(int64(1)))
+			Expect(inner[1].IsNil()).To(BeTrue())
+			Expect(elems[2].Slice()).To(BeEmpty())
+
+			c.Append("ECHO", strings.Repeat("x", 100000))
+			c.Append("PING")
+			Expect(c.Flush()).To(Succeed())
+
+			r, err = c.ReceiveStream()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Len()).To(Equal(int64(100000)))
+			rd, err := r.Reader()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(io.Copy(ioutil.Discard, rd)).To(Equal(int64(100000)))
+			Expect(r.Close()).To(Succeed())
 
-			s, err := cr.ReadInlineString()
+			r, err = c.Receive()
 			Expect(err).NotTo(HaveOccurred())
-			Expect(s).To(Equal("hello.8"))
+			Expect(r.Str()).To(Equal("PONG"))
 		})
 	})
 
 	It("should handle pipelines", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
-			cw.WriteCmd("PING")
-			cw.WriteCmd("PING")
-			cw.WriteCmd("PING")
-			Expect(cw.Flush()).To(Succeed())
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.Append("PING")
+			c.Append("PING")
+			c.Append("PING")
+			Expect(c.Flush()).To(Succeed())
 
 			for i := 0; i < 3; i++ {
-				s, err := cr.ReadInlineString()
+				r, err := c.Receive()
 				Expect(err).NotTo(HaveOccurred())
-				Expect(s).To(Equal("PONG"))
+				Expect(r.Str()).To(Equal("PONG"))
 			}
 		})
 	})
 
+	It("should stay on RESP2 unless negotiated", func() {
+		subject.Handle("hello", Hello())
+		subject.HandleFunc("flags", func(w resp.ResponseWriter, _ *resp.Command) {
+			w.Append(map[string]bool{"ok": true})
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("FLAGS")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadArrayLen()).To(Equal(2))
+			Expect(c.ReadBulkString()).To(Equal("ok"))
+			Expect(c.ReadInt()).To(Equal(int64(1)))
+
+			Expect(subject.Info().Clients()[0].Protocol()).To(Equal(resp.RESP2))
+		})
+	})
+
+	It("should upgrade to RESP3 mid-session", func() {
+		subject.Handle("hello", Hello())
+		subject.HandleFunc("flags", func(w resp.ResponseWriter, _ *resp.Command) {
+			w.Append(map[string]bool{"ok": true})
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("FLAGS")
+			c.WriteCmdString("HELLO", "3")
+			c.WriteCmd("FLAGS")
+			Expect(c.Flush()).To(Succeed())
+
+			Expect(c.ReadArrayLen()).To(Equal(2))
+			Expect(c.ReadBulkString()).To(Equal("ok"))
+			Expect(c.ReadInt()).To(Equal(int64(1)))
+
+			Expect(c.ReadMapLen()).To(Equal(6))
+			Expect(c.ReadBulkString()).To(Equal("server"))
+			Expect(c.ReadBulkString()).To(Equal("redeo"))
+			Expect(c.ReadBulkString()).To(Equal("proto"))
+			Expect(c.ReadInt()).To(Equal(int64(3)))
+			Expect(c.ReadBulkString()).To(Equal("id"))
+			Expect(c.ReadInt()).To(BeNumerically(">", 0))
+			Expect(c.ReadBulkString()).To(Equal("mode"))
+			Expect(c.ReadBulkString()).To(Equal("standalone"))
+			Expect(c.ReadBulkString()).To(Equal("role"))
+			Expect(c.ReadBulkString()).To(Equal("master"))
+			Expect(c.ReadBulkString()).To(Equal("modules"))
+			Expect(c.ReadArrayLen()).To(Equal(0))
+
+			Expect(c.ReadMapLen()).To(Equal(1))
+			Expect(c.ReadBulkString()).To(Equal("ok"))
+			Expect(c.ReadBool()).To(BeTrue())
+
+			Expect(subject.Info().Clients()[0].Protocol()).To(Equal(resp.RESP3))
+		})
+	})
+
+	It("should recover from handler panics", func() {
+		var recovered []interface{}
+		subject.config.PanicHandler = func(name string, r interface{}) {
+			recovered = append(recovered, name, r)
+		}
+		subject.HandleFunc("boom", func(_ resp.ResponseWriter, _ *resp.Command) {
+			panic("bad handler")
+		})
+		subject.HandleStreamFunc("sboom", func(_ resp.ResponseWriter, _ *resp.CommandStream) {
+			panic("bad stream handler")
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("BOOM")
+			c.WriteCmd("PING")
+			c.WriteCmdString("SBOOM", "unread", "args")
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+
+			Expect(c.ReadError()).To(Equal("ERR internal error: bad handler"))
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+			Expect(c.ReadError()).To(Equal("ERR internal error: bad stream handler"))
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+
+			Expect(subject.Info().TotalPanics()).To(Equal(int64(2)))
+
+			stats := subject.Info().CommandStats()
+			Expect(stats[0].Name).To(Equal("boom"))
+			Expect(stats[0].Errors).To(Equal(int64(1)))
+			Expect(subject.Info().NumClients()).To(Equal(1))
+		})
+		Expect(recovered).To(Equal([]interface{}{"boom", "bad handler", "sboom", "bad stream handler"}))
+	})
+
+	It("should reply with custom panic errors", func() {
+		subject.config.PanicReply = func(name string, r interface{}) string {
+			return fmt.Sprintf("ERR %s failed", name)
+		}
+		subject.HandleFunc("boom", func(_ resp.ResponseWriter, _ *resp.Command) {
+			panic("bad handler")
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("BOOM")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadError()).To(Equal("ERR boom failed"))
+		})
+	})
+
+	It("should disconnect on panics after partial replies", func() {
+		subject.HandleFunc("boom", func(w resp.ResponseWriter, _ *resp.Command) {
+			w.AppendArrayLen(2)
+			w.AppendInt(1)
+			panic("bad handler")
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+
+			c.WriteCmd("BOOM")
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			_, err := c.PeekType()
+			Expect(err).To(MatchError("EOF"))
+
+			Expect(subject.Info().TotalPanics()).To(Equal(int64(1)))
+			Eventually(subject.Info().NumClients).Should(Equal(0))
+		})
+	})
+
 	It("should handle invalid commands", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
-			cw.WriteCmd("nOOp")
-			Expect(cw.Flush()).To(Succeed())
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("nOOp")
+			Expect(c.Flush()).To(Succeed())
 
-			s, err := cr.ReadError()
+			s, err := c.ReadError()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("ERR unknown command 'nOOp'"))
 
 			// connection should still be open
-			cw.WriteCmd("PING")
-			Expect(cw.Flush()).To(Succeed())
-			s, err = cr.ReadInlineString()
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			s, err = c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("PONG"))
 		})
 	})
 
 	It("should handle invalid commands in pipelines", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
-			cw.WriteCmd("PING")
-			cw.WriteCmd("BAD")
-			cw.WriteCmd("PING")
-			Expect(cw.Flush()).To(Succeed())
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("PING")
+			c.WriteCmd("BAD")
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
 
-			s, err := cr.ReadInlineString()
+			s, err := c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("PONG"))
 
-			s, err = cr.ReadError()
+			s, err = c.ReadError()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("ERR unknown command 'BAD'"))
 
-			s, err = cr.ReadInlineString()
+			s, err = c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("PONG"))
 		})
+		Expect(subject.Info().UnknownCommands()).To(Equal(int64(1)))
+	})
+
+	It("should route unknown commands to the fallback", func() {
+		subject.HandleDefault(HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendArrayLen(c.ArgN() + 1)
+			w.AppendBulkString(c.Name)
+			for _, arg := range c.Args {
+				w.AppendBulk(arg)
+			}
+		}))
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmdString("NoOp", "a", "b")
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+
+			Expect(c.ReadArrayLen()).To(Equal(3))
+			Expect(c.ReadBulkString()).To(Equal("NoOp"))
+			Expect(c.ReadBulkString()).To(Equal("a"))
+			Expect(c.ReadBulkString()).To(Equal("b"))
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+		})
+		Expect(subject.Info().UnknownCommands()).To(Equal(int64(0)))
+	})
+
+	It("should route unknown commands to a streaming fallback", func() {
+		subject.HandleDefaultStream(StreamHandlerFunc(func(w resp.ResponseWriter, c *resp.CommandStream) {
+			w.AppendInt(int64(c.ArgN()))
+		}))
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmdString("NOOP", "a", strings.Repeat("x", 100000))
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+
+			Expect(c.ReadInt()).To(Equal(int64(2)))
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+		})
 	})
 
 	It("should handle client errors", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
-			cw.WriteCmd("ECHO")
-			Expect(cw.Flush()).To(Succeed())
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("ECHO")
+			Expect(c.Flush()).To(Succeed())
 
-			s, err := cr.ReadError()
+			s, err := c.ReadError()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("ERR wrong number of arguments for 'ECHO' command"))
 
 			// connection should still be open
-			cw.WriteCmd("PING")
-			Expect(cw.Flush()).To(Succeed())
-			s, err = cr.ReadInlineString()
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			s, err = c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("PONG"))
 		})
 	})
 
 	It("should handle client errors in pipelines", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
-			cw.WriteCmd("PING")
-			cw.WriteCmd("echo")
-			cw.WriteCmd("PING")
-			Expect(cw.Flush()).To(Succeed())
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("PING")
+			c.WriteCmd("echo")
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
 
-			s, err := cr.ReadInlineString()
+			s, err := c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("PONG"))
 
-			s, err = cr.ReadError()
+			s, err = c.ReadError()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("ERR wrong number of arguments for 'echo' command"))
 
-			s, err = cr.ReadInlineString()
+			s, err = c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("PONG"))
 		})
 	})
 
+	It("should close idle connections", func() {
+		subject.config.Timeout = 0
+		subject.config.IdleTimeout = 50 * time.Millisecond
+		subject.HandleFunc("slow", func(w resp.ResponseWriter, _ *resp.Command) {
+			time.Sleep(150 * time.Millisecond)
+			w.AppendOK()
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("SLOW")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadInlineString()).To(Equal("OK"))
+			Expect(subject.Info().NumClients()).To(Equal(1))
+
+			_, err := c.PeekType()
+			Expect(err).To(Equal(io.EOF))
+			Expect(subject.Info().IdleTimeouts()).To(Equal(int64(1)))
+			Eventually(subject.Info().NumClients).Should(Equal(0))
+		})
+	})
+
+	It("should apply read timeouts", func() {
+		srv := NewServer(&Config{ReadTimeout: 50 * time.Millisecond})
+		srv.HandleStreamFunc("slurp", func(w resp.ResponseWriter, cmd *resp.CommandStream) {
+			rd, err := cmd.Next()
+			if err != nil {
+				w.AppendError("ERR " + err.Error())
+				return
+			}
+			defer rd.Close()
+
+			b, err := rd.ReadAll()
+			if err != nil {
+				w.AppendError("ERR " + err.Error())
+				return
+			}
+			w.AppendInt(int64(len(b)))
+		})
+
+		runServer(srv, func(cn net.Conn, c client.Conn) {
+			// slow, but steady
+			for _, chunk := range []string{"*2\r\n$5\r\nslurp\r\n$6\r\nab", "cd", "ef\r\n"} {
+				_, err := cn.Write([]byte(chunk))
+				Expect(err).NotTo(HaveOccurred())
+				time.Sleep(30 * time.Millisecond)
+			}
+			Expect(c.ReadInt()).To(Equal(int64(6)))
+
+			// idle clients are not affected
+			time.Sleep(80 * time.Millisecond)
+			_, err := cn.Write([]byte("*2\r\n$5\r\nslurp\r\n$6\r\nab"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = c.PeekType()
+			Expect(err).To(HaveOccurred())
+			Eventually(func() int64 {
+				return srv.Info().DroppedConnections().Timeout
+			}).Should(Equal(int64(1)))
+		})
+	})
+
+	It("should call connection hooks", func() {
+		var connected int32
+		disconnected := make(chan interface{}, 1)
+		srv := NewServer(&Config{
+			OnConnect: func(c *Client) error {
+				if atomic.AddInt32(&connected, 1) > 1 {
+					return errors.New("ERR not allowed")
+				}
+				c.Set("tenant", "acme")
+				return nil
+			},
+			OnDisconnect: func(c *Client) {
+				disconnected <- c.Get("tenant")
+			},
+		})
+		srv.HandleFunc("tenant", func(w resp.ResponseWriter, cmd *resp.Command) {
+			w.AppendBulkString(GetClient(cmd.Context()).Get("tenant").(string))
+		})
+
+		runServer(srv, func(cn net.Conn, c client.Conn) {
+			c.WriteCmdString("TENANT")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadBulkString()).To(Equal("acme"))
+
+			cn2, err := net.Dial("tcp", cn.RemoteAddr().String())
+			Expect(err).NotTo(HaveOccurred())
+			defer cn2.Close()
+
+			msg, err := resp.NewResponseReader(cn2).ReadError()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msg).To(Equal("ERR not allowed"))
+			Expect(srv.Info().RejectedConnections()).To(Equal(int64(1)))
+			Consistently(disconnected).ShouldNot(Receive())
+
+			Expect(cn.Close()).To(Succeed())
+			Eventually(disconnected).Should(Receive(Equal("acme")))
+		})
+	})
+
+	It("should validate arguments before calling handlers", func() {
+		var calls int
+		subject.HandleFunc("set", func(w resp.ResponseWriter, _ *resp.Command) {
+			calls++
+			w.AppendOK()
+		}, MinArgs(2), MaxArgs(3))
+		subject.HandleStreamFunc("sset", func(w resp.ResponseWriter, _ *resp.CommandStream) {
+			calls++
+			w.AppendOK()
+		}, Arity(3))
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmdString("SET", "key")
+			c.WriteCmdString("SET", "key", "val", "ex", "10")
+			c.WriteCmdString("SSET", "key", strings.Repeat("x", 100000), "extra")
+			c.WriteCmdString("SET", "key", "val")
+			c.WriteCmdString("SSET", "key", "val")
+			Expect(c.Flush()).To(Succeed())
+
+			Expect(c.ReadError()).To(Equal("ERR wrong number of arguments for 'SET' command"))
+			Expect(c.ReadError()).To(Equal("ERR wrong number of arguments for 'SET' command"))
+			Expect(c.ReadError()).To(Equal("ERR wrong number of arguments for 'SSET' command"))
+			Expect(c.ReadInlineString()).To(Equal("OK"))
+			Expect(c.ReadInlineString()).To(Equal("OK"))
+			Expect(calls).To(Equal(2))
+		})
+	})
+
+	It("should apply argument validators", func() {
+		var calls int
+		subject.HandleFunc("incrby", func(w resp.ResponseWriter, c *resp.Command) {
+			calls++
+			w.AppendBulk(c.Arg(1))
+		}, Arity(3), ValidateArgs(func(c *resp.Command) error {
+			_, err := c.Arg(1).Int()
+			if err != nil {
+				return errors.New("ERR value is not an integer or out of range")
+			}
+			return nil
+		}))
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmdString("INCRBY", "key")
+			c.WriteCmdString("INCRBY", "key", "x")
+			c.WriteCmdString("INCRBY", "key", "2")
+			Expect(c.Flush()).To(Succeed())
+
+			Expect(c.ReadError()).To(Equal("ERR wrong number of arguments for 'INCRBY' command"))
+			Expect(c.ReadError()).To(Equal("ERR value is not an integer or out of range"))
+			Expect(c.ReadBulkString()).To(Equal("2"))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	It("should handle inline commands", func() {
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			_, err := cn.Write([]byte("PING\r\n\r\n  \r\necho \"hello\\tworld\"\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+			Expect(c.ReadBulkString()).To(Equal("hello\tworld"))
+
+			_, err = cn.Write([]byte("\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cn.Write([]byte("echo 'unbalanced\r\nPING\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ReadError()).To(Equal("ERR Protocol error: unbalanced quotes in request"))
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+
+			// like netcat, without CR
+			_, err = cn.Write([]byte("PING\necho hi\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+			Expect(c.ReadBulkString()).To(Equal("hi"))
+		})
+	})
+
 	It("should handle protocol errors", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+		runServer(subject, func(cn net.Conn, c client.Conn) {
 			_, err := cn.Write([]byte("*x\r\n"))
 			Expect(err).NotTo(HaveOccurred())
 
-			x, _ := cr.PeekType()
+			x, _ := c.PeekType()
 			Expect(x).To(Equal(resp.TypeError))
 
-			s, err := cr.ReadError()
+			s, err := c.ReadError()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("ERR Protocol error: invalid multibulk length"))
 
 			// connection should still be open
-			cw.WriteCmd("PING")
-			Expect(cw.Flush()).To(Succeed())
-			s, err = cr.ReadInlineString()
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			s, err = c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("PONG"))
 		})
 	})
 
 	It("should handle protocol errors in pipelines", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+		runServer(subject, func(cn net.Conn, c client.Conn) {
 			_, err := cn.Write([]byte("*1\r\n$4\r\nPING\r\n*1\r\n$x\r\nPING\r\n*1\r\n$4\r\nPING\r\n"))
 			Expect(err).NotTo(HaveOccurred())
 
-			s, err := cr.ReadInlineString()
+			s, err := c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("PONG"))
 
-			s, err = cr.ReadError()
+			s, err = c.ReadError()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("ERR Protocol error: invalid bulk length"))
 
-			s, err = cr.ReadInlineString()
+			s, err = c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("PONG"))
 		})
 	})
 
+	It("should enforce request size limits", func() {
+		subject.config.MaxBulkLength = 8
+		subject.config.MaxMultiBulkLength = 3
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmdString("ECHO", "12345678")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadBulkString()).To(Equal("12345678"))
+
+			_, err := cn.Write([]byte("*2\r\n$4\r\nECHO\r\n$4294967296\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ReadError()).To(Equal("ERR Protocol error: too big bulk length"))
+			_, err = c.PeekType()
+			Expect(err).To(HaveOccurred())
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			_, err := cn.Write([]byte("*1000000\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ReadError()).To(Equal("ERR Protocol error: too big multibulk length"))
+			_, err = c.PeekType()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("should limit request sizes by default", func() {
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			_, err := cn.Write([]byte("*2\r\n$4\r\nECHO\r\n$999999999999\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ReadError()).To(Equal("ERR Protocol error: too big bulk length"))
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			_, err := cn.Write([]byte("*2000000\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ReadError()).To(Equal("ERR Protocol error: too big multibulk length"))
+		})
+	})
+
+	It("should flush replies as configured", func() {
+		for _, policy := range []FlushPolicy{{Replies: 1}, {Interval: 5 * time.Millisecond}} {
+			release := make(chan struct{})
+			subject.config.Flush = policy
+			subject.HandleFunc("wait", func(w resp.ResponseWriter, _ *resp.Command) {
+				<-release
+				w.AppendOK()
+			})
+
+			runServer(subject, func(cn net.Conn, c client.Conn) {
+				c.WriteCmdString("ECHO", "early")
+				c.WriteCmd("WAIT")
+				Expect(c.Flush()).To(Succeed())
+
+				// the first reply arrives while WAIT is still running
+				Expect(c.ReadBulkString()).To(Equal("early"))
+				close(release)
+				Expect(c.ReadInlineString()).To(Equal("OK"))
+			})
+		}
+	})
+
+	It("should detect partial replies flushed by the interval", func() {
+		release := make(chan struct{})
+		subject.config.Flush = FlushPolicy{Interval: 5 * time.Millisecond}
+		subject.HandleFunc("partial", func(w resp.ResponseWriter, _ *resp.Command) {
+			w.AppendArrayLen(2)
+			w.AppendBulkString("a")
+			<-release
+			panic("oops")
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.Append("PARTIAL")
+			Expect(c.Flush()).To(Succeed())
+
+			// the partial reply is flushed while the handler is running
+			Expect(c.ReadArrayLen()).To(Equal(2))
+			Expect(c.ReadBulkString()).To(Equal("a"))
+			close(release)
+
+			// the reply cannot be completed, the client is disconnected
+			_, err := c.Receive()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	It("should close connections on EOF errors", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+		runServer(subject, func(cn net.Conn, c client.Conn) {
 			_, err := cn.Write([]byte("*1\r\n$4\r\nPI"))
 			Expect(err).NotTo(HaveOccurred())
 
 			// connection should be closed
-			_, err = cr.PeekType()
+			_, err = c.PeekType()
 			Expect(err).To(MatchError("EOF"))
 		})
 	})
 
+	It("should report dropped connections", func() {
+		var dropped []string
+		var mu sync.Mutex
+		subject.config.OnClientError = func(ci *ClientInfo, err error) {
+			mu.Lock()
+			dropped = append(dropped, fmt.Sprintf("%s %v", ci.LastCmd, err))
+			mu.Unlock()
+		}
+		getDropped := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return dropped
+		}
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+
+			_, err := cn.Write([]byte("ECHO " + strings.Repeat("x", 100000) + "\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.ReadError()).To(Equal("ERR Protocol error: too big inline request"))
+			_, err = c.PeekType()
+			Expect(err).To(HaveOccurred())
+		})
+		Eventually(getDropped).Should(Equal([]string{"ping Protocol error: too big inline request"}))
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			_, err := cn.Write([]byte("*1\r\n$4\r\nPI"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.PeekType()
+			Expect(err).To(MatchError("EOF"))
+		})
+		Eventually(getDropped).Should(HaveLen(2))
+		Expect(getDropped()[1]).To(HaveSuffix("i/o timeout"))
+
+		Expect(subject.Info().DroppedConnections()).To(Equal(DroppedConnections{
+			Timeout:       1,
+			ProtocolError: 1,
+		}))
+		Expect(subject.Info().String()).To(ContainSubstring("dropped_connections_protocol_error:1\n"))
+	})
+
+	It("should apply write timeouts", func() {
+		subject.config.Timeout = 0
+		subject.config.WriteTimeout = 50 * time.Millisecond
+		subject.HandleFunc("flood", func(w resp.ResponseWriter, _ *resp.Command) {
+			chunk := make([]byte, 1<<20)
+			for i := 0; i < 256; i++ {
+				w.AppendBulk(chunk)
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("FLOOD")
+			Expect(c.Flush()).To(Succeed())
+			Eventually(func() int64 {
+				return subject.Info().DroppedConnections().WriteError
+			}).Should(Equal(int64(1)))
+		})
+	})
+
+	It("should apply buffer sizes", func() {
+		srv := NewServer(&Config{ReadBufferSize: 16, WriteBufferSize: 32})
+		srv.HandleFunc("echo", echo)
+		Expect(srv.buffers.readSize).To(Equal(16))
+		Expect(srv.buffers.writeSize).To(Equal(32))
+		Expect(subject.buffers).To(BeIdenticalTo(defaultBuffers))
+
+		runServer(srv, func(cn net.Conn, c client.Conn) {
+			for _, n := range []int{1, 100, 100000} {
+				c.WriteCmdString("ECHO", strings.Repeat("x", n))
+				Expect(c.Flush()).To(Succeed())
+				Expect(c.ReadBulkString()).To(HaveLen(n))
+			}
+		})
+	})
+
+	It("should copy bulks from files", func() {
+		f, err := ioutil.TempFile("", "redeo-test")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		_, err = f.WriteString(strings.Repeat("x", 200000))
+		Expect(err).NotTo(HaveOccurred())
+
+		subject.HandleFunc("file", func(w resp.ResponseWriter, c *resp.Command) {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				w.AppendError("ERR " + err.Error())
+				return
+			}
+			if err := w.CopyBulk(f, 200000); err != nil {
+				w.AppendError("ERR " + err.Error())
+			}
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("FILE")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadBulkString()).To(HaveLen(200000))
+			Eventually(func() int64 {
+				return subject.Info().ClientInfo()[0].NetOutput
+			}).Should(Equal(int64(200000 + 11)))
+		})
+	})
+
+	It("should count traffic", func() {
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+
+			info := subject.Info().ClientInfo()
+			Expect(info).To(HaveLen(1))
+			Expect(info[0].NetInput).To(Equal(int64(14)))
+			Expect(info[0].NetOutput).To(Equal(int64(7)))
+			Expect(info[0].String()).To(HaveSuffix(" tot-net-in=14 tot-net-out=7 omem=0 rate-limited=0"))
+		})
+
+		Eventually(subject.Info().NumClients).Should(Equal(0))
+		Expect(subject.Info().TotalNetInputBytes()).To(Equal(int64(14)))
+		Expect(subject.Info().TotalNetOutputBytes()).To(Equal(int64(7)))
+		Expect(subject.Info().String()).To(ContainSubstring("total_net_input_bytes:14\ntotal_net_output_bytes:7\n"))
+
+		subject.Info().ResetStats()
+		Expect(subject.Info().TotalNetInputBytes()).To(Equal(int64(0)))
+	})
+
+	It("should call tracing hooks", func() {
+		type ctxKey struct{}
+		var mu sync.Mutex
+		var events []string
+		record := func(s string) {
+			mu.Lock()
+			events = append(events, s)
+			mu.Unlock()
+		}
+
+		subject.config.Hooks = Hooks{
+			OnConnect: func(addr string) context.Context {
+				Expect(addr).NotTo(BeEmpty())
+				return context.WithValue(context.Background(), ctxKey{}, "conn")
+			},
+			OnCommandStart: func(ctx context.Context, name string, args int) (context.Context, func(error)) {
+				Expect(GetClient(ctx)).NotTo(BeNil())
+				record(fmt.Sprintf("start %s/%d %v", name, args, ctx.Value(ctxKey{})))
+				ctx = context.WithValue(ctx, ctxKey{}, "cmd")
+				return ctx, func(err error) { record(fmt.Sprintf("end %s %v", name, err)) }
+			},
+		}
+		subject.Handle("trace", ContextHandlerFunc(func(ctx context.Context, w resp.ResponseWriter, _ *resp.Command) {
+			w.AppendBulkString(ctx.Value(ctxKey{}).(string))
+		}))
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmdString("TRACE")
+			c.WriteCmdString("echo", "a", "b")
+			c.WriteCmdString("stream", `{"N":1,"S":"x"}`)
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadBulkString()).To(Equal("cmd"))
+			Expect(c.ReadError()).To(Equal("ERR wrong number of arguments for 'echo' command"))
+			Expect(c.ReadInlineString()).To(Equal("x.1"))
+			Expect(c.ReadInlineString()).To(Equal("OK"))
+		})
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(events).To(Equal([]string{
+			"start trace/0 conn",
+			"end trace <nil>",
+			"start echo/2 conn",
+			"end echo ERR wrong number of arguments for 'echo' command",
+			"start stream/1 conn",
+			"end stream <nil>",
+		}))
+	})
+
 	It("should allow user to close connections", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
-			cw.WriteCmd("QUIT")
-			Expect(cw.Flush()).To(Succeed())
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("QUIT")
+			Expect(c.Flush()).To(Succeed())
 
-			s, err := cr.ReadInlineString()
+			s, err := c.ReadInlineString()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("OK"))
 
-			cw.WriteCmd("PING")
-			Expect(cw.Flush()).To(Succeed())
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
 
 			// connection should be closed
-			_, err = cr.PeekType()
+			_, err = c.PeekType()
 			Expect(err).To(MatchError("EOF"))
 		})
 	})
 
+	It("should stop pipelines on QUIT", func() {
+		subject = NewServer(nil)
+		subject.HandleDefaults()
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("SELECT", []byte("3"))
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadInlineString()).To(Equal("OK"))
+			Expect(subject.Info().ClientInfo()[0].DB).To(Equal(3))
+
+			c.WriteCmd("PING")
+			c.WriteCmd("QUIT")
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+			Expect(c.ReadInlineString()).To(Equal("OK"))
+
+			_, err := c.PeekType()
+			Expect(err).To(MatchError("EOF"))
+			Expect(subject.Info().TotalCommands()).To(Equal(int64(3)))
+		})
+	})
+
+	It("should limit SELECT to the configured databases", func() {
+		subject = NewServer(&Config{Databases: 4})
+		subject.HandleDefaults()
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			r, err := c.Cmd("SELECT", "3")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Str()).To(Equal("OK"))
+
+			r, err = c.Cmd("SELECT", "4")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Err()).To(MatchError("ERR DB index is out of range"))
+			Expect(subject.Info().ClientInfo()[0].DB).To(Equal(3))
+		})
+	})
+
+	It("should swap databases", func() {
+		var swapped [][2]int
+		subject.HandleSwapDB(func(a, b int) error {
+			if a == b {
+				return errors.New("same database")
+			}
+			swapped = append(swapped, [2]int{a, b})
+			return nil
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			r, err := c.Cmd("SWAPDB", "0", "15")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Str()).To(Equal("OK"))
+
+			r, err = c.Cmd("SWAPDB", "0", "16")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Err()).To(MatchError("ERR DB index is out of range"))
+
+			r, err = c.Cmd("SWAPDB", "x", "1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Err()).To(MatchError("ERR invalid first DB index"))
+
+			r, err = c.Cmd("SWAPDB", "1", "1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Err()).To(MatchError("ERR same database"))
+		})
+		Expect(swapped).To(Equal([][2]int{{0, 15}}))
+	})
+
+	It("should shut down", func() {
+		var calls int32
+		subject.HandleShutdown(func(c *resp.Command) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return errors.New("not yet")
+			}
+			return nil
+		}, time.Second)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		done := make(chan error, 1)
+		go func() { done <- subject.Serve(lis) }()
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		c := client.Wrap(cn)
+		r, err := c.Cmd("SHUTDOWN")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Err()).To(MatchError("ERR not yet"))
+
+		c.WriteCmd("SHUTDOWN")
+		Expect(c.Flush()).To(Succeed())
+		Expect(ioutil.ReadAll(cn)).To(BeEmpty())
+		Eventually(done).Should(Receive(Equal(ErrServerClosed)))
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+	})
+
 	It("should handle connection close", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+		runServer(subject, func(cn net.Conn, c client.Conn) {
 			cn.Close()
 
 			time.Sleep(1 * time.Second)
@@ -316,10 +1229,10 @@ var _ = Describe("Server", func() {
 	})
 
 	It("should shut down gracefully", func() {
-		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+		runServer(subject, func(cn net.Conn, c client.Conn) {
 			// connection is active
-			cw.WriteCmd("PING")
-			Expect(cw.Flush()).To(Succeed())
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
 
 			subject.Release()
 
@@ -327,10 +1240,587 @@ var _ = Describe("Server", func() {
 			Expect(num).To(Equal(0))
 		})
 	})
+
+	It("should close individual clients", func() {
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+
+			id := subject.Info().ClientInfo()[0].ID
+			Expect(subject.CloseClient(id + 1)).To(BeFalse())
+			Expect(subject.CloseClient(id)).To(BeTrue())
+
+			_, err := c.PeekType()
+			Expect(err).To(MatchError("EOF"))
+			Eventually(subject.Info().NumClients).Should(Equal(0))
+			Expect(subject.CloseClient(id)).To(BeFalse())
+		})
+	})
+
+	It("should cancel handler contexts of closed clients", func() {
+		subject.HandleFuncCtx("wait", func(ctx context.Context, w resp.ResponseWriter, _ *resp.Command) {
+			<-ctx.Done()
+			w.AppendError("ERR " + ctx.Err().Error())
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("WAIT")
+			Expect(c.Flush()).To(Succeed())
+			Eventually(subject.Info().TotalCommands).Should(Equal(int64(1)))
+
+			id := subject.Info().ClientInfo()[0].ID
+			Expect(subject.CloseClient(id)).To(BeTrue())
+
+			Expect(c.ReadError()).To(Equal("ERR context canceled"))
+			_, err := c.PeekType()
+			Expect(err).To(MatchError("EOF"))
+		})
+	})
+
+	It("should broadcast messages", func() {
+		release := make(chan struct{})
+		subject.HandleFunc("hold", func(w resp.ResponseWriter, _ *resp.Command) {
+			<-release
+			w.AppendOK()
+		})
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go subject.Serve(lis)
+
+		cn1, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn1.Close()
+		cn2, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn2.Close()
+
+		c1, c2 := client.Wrap(cn1), client.Wrap(cn2)
+		c1.WriteCmd("HOLD")
+		Expect(c1.Flush()).To(Succeed())
+		Eventually(subject.Info().NumClients).Should(Equal(2))
+		Eventually(subject.Info().TotalCommands).Should(Equal(int64(1)))
+
+		invalidate := func(w resp.ResponseWriter) {
+			w.AppendPushLen(2)
+			w.AppendBulkString("invalidate")
+			w.AppendBulkString("key")
+		}
+		Expect(subject.Broadcast(invalidate, nil)).To(Equal(2))
+		Expect(subject.Broadcast(invalidate, func(c *Client) bool { return false })).To(Equal(0))
+
+		Expect(c2.ReadArrayLen()).To(Equal(2))
+		Expect(c2.ReadBulkString()).To(Equal("invalidate"))
+		Expect(c2.ReadBulkString()).To(Equal("key"))
+
+		// pushes are deferred until the pending reply is written
+		close(release)
+		Expect(c1.ReadInlineString()).To(Equal("OK"))
+		Expect(c1.ReadArrayLen()).To(Equal(2))
+		Expect(c1.ReadBulkString()).To(Equal("invalidate"))
+		Expect(c1.ReadBulkString()).To(Equal("key"))
+	})
+
+	It("should cancel handler contexts on timeouts", func() {
+		errs := make(chan error, 3)
+		subject.HandleFuncCtx("wait", func(ctx context.Context, w resp.ResponseWriter, _ *resp.Command) {
+			<-ctx.Done()
+			errs <- ctx.Err()
+
+			w.AppendOK()
+			errs <- w.Flush()
+			w.AppendOK()
+			errs <- w.Flush()
+		})
+
+		runServer(subject, func(cn net.Conn, c client.Conn) {
+			c.WriteCmd("WAIT")
+			Expect(c.Flush()).To(Succeed())
+
+			Eventually(errs).Should(Receive(Equal(context.DeadlineExceeded)))
+			Eventually(errs).Should(Receive(Equal(ErrConnClosed)))
+			Eventually(errs).Should(Receive(Equal(ErrConnClosed)))
+		})
+	})
+
+	It("should release while clients come and go", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go subject.Serve(lis)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				cn, err := net.Dial("tcp", lis.Addr().String())
+				Expect(err).NotTo(HaveOccurred())
+				defer cn.Close()
+
+				c := client.Wrap(cn)
+				c.WriteCmd("PING")
+				_ = c.Flush()
+				_, _ = c.PeekType()
+			}()
+		}
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for running := true; running; {
+			select {
+			case <-done:
+				running = false
+			default:
+				Expect(subject.CloseAll(ctx)).To(Succeed())
+			}
+		}
+
+		subject.Release()
+		Expect(subject.Info().NumClients()).To(Equal(0))
+	})
+})
+
+var _ = Describe("Server.Use", func() {
+	var subject *Server
+	var calls []string
+
+	var record = func(tag string) func(Handler) Handler {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+				calls = append(calls, tag+":"+CommandName(c.Context()))
+				next.ServeRedeo(w, c)
+			})
+		}
+	}
+
+	var recordStream = func(tag string) func(StreamHandler) StreamHandler {
+		return func(next StreamHandler) StreamHandler {
+			return StreamHandlerFunc(func(w resp.ResponseWriter, c *resp.CommandStream) {
+				calls = append(calls, tag+":"+CommandName(c.Context()))
+				next.ServeRedeoStream(w, c)
+			})
+		}
+	}
+
+	var deny = func(next Handler) Handler {
+		return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+			if CommandName(c.Context()) == "secret" {
+				w.AppendError("ERR denied")
+				return
+			}
+			next.ServeRedeo(w, c)
+		})
+	}
+
+	var serve = func(fn func(client.Conn)) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go subject.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		fn(client.Wrap(cn))
+	}
+
+	BeforeEach(func() {
+		calls = nil
+		subject = NewServer(nil)
+		subject.Use(record("a"))
+		subject.HandleFunc("PING", func(w resp.ResponseWriter, _ *resp.Command) {
+			calls = append(calls, "ping")
+			w.AppendInlineString("PONG")
+		})
+		subject.HandleFunc("secret", func(w resp.ResponseWriter, _ *resp.Command) {
+			calls = append(calls, "secret")
+			w.AppendOK()
+		})
+		subject.HandleStreamFunc("stream", func(w resp.ResponseWriter, c *resp.CommandStream) {
+			calls = append(calls, "stream")
+			w.AppendInt(int64(c.ArgN()))
+		})
+		subject.Use(record("b"))
+		subject.UseStream(recordStream("s"))
+	})
+
+	It("should apply middleware in order", func() {
+		serve(func(c client.Conn) {
+			c.WriteCmd("PiNG")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+		})
+		Expect(calls).To(Equal([]string{"a:ping", "b:ping", "ping"}))
+	})
+
+	It("should apply stream middleware", func() {
+		serve(func(c client.Conn) {
+			c.WriteCmdString("STREAM", "x", "y")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadInt()).To(Equal(int64(2)))
+		})
+		Expect(calls).To(Equal([]string{"s:stream", "stream"}))
+	})
+
+	It("should allow to short-circuit", func() {
+		subject.Use(deny)
+		subject.UseStream(func(StreamHandler) StreamHandler {
+			return StreamHandlerFunc(func(w resp.ResponseWriter, _ *resp.CommandStream) {
+				w.AppendError("ERR stream denied")
+			})
+		})
+
+		serve(func(c client.Conn) {
+			c.WriteCmd("SECRET")
+			c.WriteCmdString("STREAM", "x")
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadError()).To(Equal("ERR denied"))
+			Expect(c.ReadError()).To(Equal("ERR stream denied"))
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+		})
+		Expect(calls).To(Equal([]string{"a:secret", "b:secret", "s:stream", "a:ping", "b:ping", "ping"}))
+	})
+
+	It("should recover panics in middleware", func() {
+		subject.Use(func(next Handler) Handler {
+			return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+				if CommandName(c.Context()) == "secret" {
+					panic("oops")
+				}
+				next.ServeRedeo(w, c)
+			})
+		})
+
+		serve(func(c client.Conn) {
+			c.WriteCmd("SECRET")
+			c.WriteCmd("PING")
+			Expect(c.Flush()).To(Succeed())
+			Expect(c.ReadError()).To(Equal("ERR internal error: oops"))
+			Expect(c.ReadInlineString()).To(Equal("PONG"))
+		})
+		Expect(subject.Info().TotalPanics()).To(Equal(int64(1)))
+	})
+})
+
+var _ = Describe("Server.Shutdown", func() {
+	var subject *Server
+	var lis net.Listener
+	var served chan error
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		subject = NewServer(nil)
+		subject.HandleFunc("ping", func(w resp.ResponseWriter, _ *resp.Command) {
+			w.AppendInlineString("PONG")
+		})
+		subject.HandleFunc("sleep", func(w resp.ResponseWriter, c *resp.Command) {
+			d, _ := time.ParseDuration(c.Arg(0).String())
+			time.Sleep(d)
+			w.AppendOK()
+		})
+		subject.HandleAsyncFunc("later", func(p *Promise, c *resp.Command) {
+			d, _ := time.ParseDuration(c.Arg(0).String())
+			time.AfterFunc(d, func() {
+				p.Resolve(func(w resp.ResponseWriter) { w.AppendOK() })
+			})
+		})
+
+		srv, l, ch := subject, lis, make(chan error, 1)
+		go func() { ch <- srv.Serve(l) }()
+		served = ch
+	})
+
+	AfterEach(func() {
+		lis.Close()
+	})
+
+	var connect = func() client.Conn {
+		c, err := client.Dial(lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		return c
+	}
+
+	It("should close idle clients and stop serving", func() {
+		c := connect()
+		defer c.Close()
+
+		c.WriteCmd("PING")
+		Expect(c.Flush()).To(Succeed())
+		Expect(c.ReadInlineString()).To(Equal("PONG"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		Expect(subject.Shutdown(ctx)).To(Succeed())
+		Expect(subject.Info().NumClients()).To(Equal(0))
+		Eventually(served).Should(Receive(Equal(ErrServerClosed)))
+
+		_, err := c.PeekType()
+		Expect(err).To(MatchError("EOF"))
+	})
+
+	It("should wait for in-flight commands", func() {
+		c := connect()
+		defer c.Close()
+
+		c.WriteCmdString("SLEEP", "100ms")
+		Expect(c.Flush()).To(Succeed())
+		Eventually(subject.Info().TotalCommands).Should(Equal(int64(1)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		Expect(subject.Shutdown(ctx)).To(Succeed())
+
+		Expect(c.ReadInlineString()).To(Equal("OK"))
+		_, err := c.PeekType()
+		Expect(err).To(MatchError("EOF"))
+	})
+
+	It("should wait for asynchronous commands", func() {
+		c := connect()
+		defer c.Close()
+
+		c.WriteCmdString("LATER", "100ms")
+		Expect(c.Flush()).To(Succeed())
+		Eventually(subject.Info().TotalCommands).Should(Equal(int64(1)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		Expect(subject.Shutdown(ctx)).To(Succeed())
+
+		Expect(c.ReadInlineString()).To(Equal("OK"))
+		_, err := c.PeekType()
+		Expect(err).To(MatchError("EOF"))
+	})
+
+	It("should force-close clients when context expires", func() {
+		c := connect()
+		defer c.Close()
+
+		c.WriteCmdString("SLEEP", "500ms")
+		Expect(c.Flush()).To(Succeed())
+		Eventually(subject.Info().TotalCommands).Should(Equal(int64(1)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		Expect(subject.Shutdown(ctx)).To(Equal(context.DeadlineExceeded))
+
+		_, err := c.PeekType()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should cancel handler contexts when context expires", func() {
+		done := make(chan error, 1)
+		subject.HandleFuncCtx("wait", func(ctx context.Context, w resp.ResponseWriter, _ *resp.Command) {
+			<-ctx.Done()
+			done <- ctx.Err()
+			w.AppendOK()
+		})
+
+		c := connect()
+		defer c.Close()
+
+		c.WriteCmd("WAIT")
+		Expect(c.Flush()).To(Succeed())
+		Eventually(subject.Info().TotalCommands).Should(Equal(int64(1)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		Expect(subject.Shutdown(ctx)).To(Equal(context.DeadlineExceeded))
+		Eventually(done).Should(Receive(Equal(context.Canceled)))
+	})
+
+	It("should not serve after shutdown", func() {
+		Expect(subject.Shutdown(context.Background())).To(Succeed())
+		Expect(subject.Serve(lis)).To(Equal(ErrServerClosed))
+	})
 })
 
 // --------------------------------------------------------------------
 
+var _ = Describe("Server.Serve", func() {
+	var subject *Server
+	var lis *faultyListener
+	var handled []error
+
+	BeforeEach(func() {
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		handled = nil
+		lis = &faultyListener{Listener: inner}
+		subject = NewServer(&Config{
+			AcceptErrorHandler: func(err error) { handled = append(handled, err) },
+		})
+		subject.Handle("ping", Ping())
+	})
+
+	AfterEach(func() {
+		lis.Listener.Close()
+	})
+
+	It("should retry temporary errors", func() {
+		tmp := &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+		lis.errs = []error{tmp, tmp, tmp}
+
+		srv, l, ch := subject, lis, make(chan error, 1)
+		go func() { ch <- srv.Serve(l) }()
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		c := client.Wrap(cn)
+		c.WriteCmd("PING")
+		Expect(c.Flush()).To(Succeed())
+		Expect(c.ReadInlineString()).To(Equal("PONG"))
+		Expect(handled).To(Equal([]error{tmp, tmp, tmp}))
+		Consistently(ch).ShouldNot(Receive())
+
+		subject.Close(lis)
+		Eventually(ch).Should(Receive(Equal(ErrServerClosed)))
+	})
+
+	It("should return permanent errors", func() {
+		lis.errs = []error{errors.New("permanent failure")}
+		Expect(subject.Serve(lis)).To(MatchError("permanent failure"))
+		Expect(handled).To(BeEmpty())
+	})
+
+})
+
+var _ = Describe("Server.MaxClients", func() {
+	var subject *Server
+	var lis net.Listener
+
+	var dial = func() client.Conn {
+		c, err := client.Dial(lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		return c
+	}
+
+	var ping = func(c client.Conn) {
+		c.WriteCmd("PING")
+		Expect(c.Flush()).To(Succeed())
+		Expect(c.ReadInlineString()).To(Equal("PONG"))
+	}
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		subject = NewServer(&Config{MaxClients: 2})
+		subject.Handle("ping", Ping())
+	})
+
+	AfterEach(func() {
+		Expect(subject.Shutdown(context.Background())).To(Succeed())
+	})
+
+	It("should reject connections over the limit", func() {
+		srv, l := subject, lis
+		go srv.Serve(l)
+
+		c1 := dial()
+		defer c1.Close()
+		ping(c1)
+
+		c2 := dial()
+		defer c2.Close()
+		ping(c2)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				c, err := client.Dial(l.Addr().String())
+				Expect(err).NotTo(HaveOccurred())
+				defer c.Close()
+
+				Expect(c.ReadError()).To(Equal("ERR max number of clients reached"))
+				_, err = c.PeekType()
+				Expect(err).To(Equal(io.EOF))
+			}()
+		}
+		wg.Wait()
+
+		Expect(subject.Info().NumClients()).To(Equal(2))
+		Expect(subject.Info().RejectedConnections()).To(Equal(int64(10)))
+		Expect(subject.Info().String()).To(ContainSubstring("rejected_connections:10\n"))
+
+		// free a slot
+		Expect(c1.Close()).To(Succeed())
+		Eventually(subject.Info().NumClients).Should(Equal(1))
+
+		c3 := dial()
+		defer c3.Close()
+		ping(c3)
+	})
+
+	It("should wait for free slots", func() {
+		subject.config.MaxClients = 1
+		subject.config.MaxClientsWait = true
+
+		srv, l := subject, lis
+		go srv.Serve(l)
+
+		c1 := dial()
+		defer c1.Close()
+		ping(c1)
+
+		c2 := dial()
+		defer c2.Close()
+		c2.WriteCmd("PING")
+		Expect(c2.Flush()).To(Succeed())
+
+		replied := make(chan string, 1)
+		go func() {
+			s, _ := c2.ReadInlineString()
+			replied <- s
+		}()
+		Consistently(replied).ShouldNot(Receive())
+
+		Expect(c1.Close()).To(Succeed())
+		Eventually(replied).Should(Receive(Equal("PONG")))
+		Expect(subject.Info().RejectedConnections()).To(Equal(int64(0)))
+	})
+
+})
+
+// faultyListener returns the given errors before accepting connections
+type faultyListener struct {
+	net.Listener
+	errs []error
+}
+
+func (l *faultyListener) Accept() (net.Conn, error) {
+	if len(l.errs) != 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		return nil, err
+	}
+	return l.Listener.Accept()
+}
+
 func BenchmarkServer_inline(b *testing.B) {
 	benchmarkServer(b, []byte(
 		"ECHO HELLO\r\n"+
@@ -347,6 +1837,53 @@ func BenchmarkServer_bulk(b *testing.B) {
 	), 24)
 }
 
+func BenchmarkServer_ping(b *testing.B) {
+	benchmarkServer(b, []byte("*1\r\n$4\r\nPING\r\n"), 7)
+}
+
+func BenchmarkServer_connect(b *testing.B) {
+	for _, size := range []int{0, 4096} {
+		b.Run(fmt.Sprintf("buffers=%d", size), func(b *testing.B) {
+			benchmarkConnect(b, size, true)
+		})
+		b.Run(fmt.Sprintf("buffers=%d/unpooled", size), func(b *testing.B) {
+			benchmarkConnect(b, size, false)
+		})
+	}
+}
+
+func benchmarkConnect(b *testing.B, size int, pooled bool) {
+	srv := NewServer(&Config{ReadBufferSize: size, WriteBufferSize: size})
+	srv.Handle("ping", Ping())
+
+	ping := []byte("*1\r\n$4\r\nPING\r\n")
+	buf := make([]byte, 7)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !pooled {
+			srv.buffers = newBufferPool(size, size)
+		}
+
+		cn, sn := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			srv.ServeForeignClient(sn)
+		}()
+
+		if _, err := cn.Write(ping); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(cn, buf); err != nil {
+			b.Fatal(err)
+		}
+		cn.Close()
+		<-done
+	}
+}
+
 func benchmarkServer(b *testing.B, pipe []byte, expN int) {
 	lis, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -361,6 +1898,7 @@ func benchmarkServer(b *testing.B, pipe []byte, expN int) {
 		}
 		w.AppendInline(cmd.Arg(0))
 	})
+	srv.Handle("ping", Ping())
 
 	go srv.Serve(lis)
 
@@ -372,6 +1910,7 @@ func benchmarkServer(b *testing.B, pipe []byte, expN int) {
 
 	buf := make([]byte, 1024)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		if _, err := conn.Write(pipe); err != nil {
@@ -384,3 +1923,52 @@ func benchmarkServer(b *testing.B, pipe []byte, expN int) {
 		}
 	}
 }
+
+func BenchmarkDispatch(b *testing.B) {
+	srv := NewServer(nil)
+	srv.HandleDefaults()
+	srv.Handle("get", Ping())
+
+	// locked replicates dispatch via the RWMutex-guarded map
+	locked := func(name string) bool {
+		norm := strings.ToLower(name)
+		srv.mu.RLock()
+		_, ok := srv.cmds[norm]
+		srv.mu.RUnlock()
+		return ok
+	}
+	table := func(name string) bool {
+		_, ok := srv.lookup(srv.normalize(name))
+		return ok
+	}
+
+	for _, impl := range []struct {
+		name string
+		fn   func(string) bool
+	}{
+		{"locked", locked},
+		{"table", table},
+	} {
+		for _, n := range []int{1, 8, 64} {
+			fn := impl.fn
+			b.Run(fmt.Sprintf("%s/goroutines=%d", impl.name, n), func(b *testing.B) {
+				var wg sync.WaitGroup
+				b.ReportAllocs()
+				b.ResetTimer()
+				for g := 0; g < n; g++ {
+					wg.Add(1)
+					go func(g int) {
+						defer wg.Done()
+						for i := g; i < b.N; i += n {
+							if !fn("GET") || !fn("ping") {
+								b.Error("command not found")
+								return
+							}
+						}
+					}(g)
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
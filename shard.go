@@ -0,0 +1,279 @@
+package redeo
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// maxDispatched is the number of commands a client may have in flight
+// on shard workers before it waits for their replies.
+const maxDispatched = 1024
+
+// ShardConfig configures sharded dispatch, see Config.ShardedDispatch.
+// Commands registered with the "parallel" flag are independent of each
+// other and are spread across all workers, regardless of their key.
+type ShardConfig struct {
+	// KeyExtractor returns the key of a command. Commands with the same
+	// key are executed sequentially by the same worker. Commands without
+	// a key (nil) are executed inline.
+	// Default: nil (only "parallel" commands are dispatched)
+	KeyExtractor func(cmd *resp.Command) []byte
+
+	// Shards is the number of worker goroutines.
+	// Default: runtime.NumCPU()
+	Shards int
+}
+
+// shardPool distributes commands to workers by key.
+type shardPool struct {
+	queues []chan *shardTask
+	next   uint32 // the next queue of independent commands, atomic
+	once   sync.Once
+}
+
+// start starts the worker goroutines, once.
+func (p *shardPool) start(srv *Server, n int) {
+	p.once.Do(func() {
+		if n < 1 {
+			n = runtime.NumCPU()
+		}
+		p.queues = make([]chan *shardTask, n)
+		for i := range p.queues {
+			p.queues[i] = make(chan *shardTask, maxDispatched)
+			go srv.shardWorker(p.queues[i])
+		}
+	})
+}
+
+// queue returns the queue for key.
+func (p *shardPool) queue(key []byte) chan<- *shardTask {
+	// FNV-1a
+	h := uint32(2166136261)
+	for _, b := range key {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return p.queues[h%uint32(len(p.queues))]
+}
+
+// any returns the queues in rotation, for commands without a key.
+func (p *shardPool) any() chan<- *shardTask {
+	n := atomic.AddUint32(&p.next, 1)
+	return p.queues[n%uint32(len(p.queues))]
+}
+
+// --------------------------------------------------------------------
+
+// shardTask is a command dispatched to a worker. Its reply is buffered
+// until it is collected by the client.
+type shardTask struct {
+	client  *Client
+	name    string
+	entry   *handlerEntry
+	handler Handler
+	cmd     *resp.Command
+	end     func(error)
+
+	buf  bytes.Buffer
+	rw   replyWriter
+	done chan struct{}
+}
+
+var shardTaskPool sync.Pool
+
+func newShardTask(c *Client, name string, entry *handlerEntry, cmd *resp.Command) *shardTask {
+	var t *shardTask
+	if v := shardTaskPool.Get(); v != nil {
+		t = v.(*shardTask)
+		t.buf.Reset()
+		t.rw.ResponseWriter.Reset(&t.buf)
+		t.rw.begin()
+	} else {
+		t = new(shardTask)
+		t.rw.ResponseWriter = resp.NewResponseWriter(&t.buf)
+		t.rw.bufferTo(&t.buf)
+	}
+	t.rw.SetProtocol(c.wr.Protocol())
+
+	t.client, t.name, t.entry, t.cmd = c, name, entry, cmd
+	t.handler = entry.served.(Handler)
+	t.done = make(chan struct{})
+	return t
+}
+
+func (t *shardTask) release() {
+	t.client, t.entry, t.handler, t.cmd, t.end = nil, nil, nil, nil, nil
+	shardTaskPool.Put(t)
+}
+
+func (srv *Server) shardWorker(queue <-chan *shardTask) {
+	for t := range queue {
+		srv.runTask(t)
+	}
+}
+
+func (srv *Server) runTask(t *shardTask) {
+	defer close(t.done)
+	defer srv.observeTask(t, time.Now())
+	if t.end != nil {
+		defer func() { t.end(t.rw.result(nil)) }()
+	}
+	defer srv.recoverPanic(&t.rw, t.name)
+
+	srv.serveCmd(t.handler, &t.rw, t.cmd)
+}
+
+func (srv *Server) observeTask(t *shardTask, start time.Time) {
+	srv.record(t.client, t.entry, t.name, t.cmd.Args, start, true, &t.rw)
+}
+
+// --------------------------------------------------------------------
+
+// performSharded dispatches commands with keys and independent commands to
+// shard workers. Replies of dispatched commands are collected before
+// anything else is written to the client. Returns false if the command is
+// not subject to sharded dispatch.
+func (srv *Server) performSharded(c *Client, name, norm string) (bool, error) {
+	entry, ok := srv.dispatchable(c, norm)
+	if !ok {
+		srv.collect(c)
+		return false, nil
+	}
+
+	var err error
+	if c.cmd, err = c.readCmd(c.cmd); err != nil {
+		srv.collect(c)
+		return true, err
+	}
+	if msg := entry.spec.check(c.cmd); msg != "" {
+		srv.collect(c)
+		c.wr.AppendError(msg)
+		return true, nil
+	}
+
+	conf := srv.conf().ShardedDispatch
+	srv.shards.start(srv, conf.Shards)
+
+	var queue chan<- *shardTask
+	if entry.spec.hasFlag("parallel") {
+		queue = srv.shards.any()
+	} else if conf.KeyExtractor != nil {
+		if key := conf.KeyExtractor(c.cmd); key != nil {
+			queue = srv.shards.queue(key)
+		}
+	}
+	if queue == nil {
+		// commands without a key are performed inline, as if not sharded
+		srv.collect(c)
+		c.readAhead = true
+		return false, nil
+	}
+
+	if err := srv.authorize(c, norm, c.cmd.Args); err != nil {
+		srv.collect(c)
+		c.wr.AppendError(err.Error())
+		return true, nil
+	}
+
+	srv.info.command(c.id, norm)
+	c.cmdName = norm
+	srv.feedMonitors(c, c.cmd.Name, c.cmd.Args)
+
+	t := newShardTask(c, norm, entry, copyCommand(c.cmd))
+	t.cmd.SetContext(withTraceParent(c.cmdContext(), c.cmd))
+	if srv.conf().Hooks.OnCommandStart != nil {
+		t.end = srv.startCommand(c, t.cmd, norm, nil)
+	}
+
+	c.dispatched = append(c.dispatched, t)
+	queue <- t
+
+	if len(c.dispatched) >= maxDispatched {
+		srv.collect(c)
+		return true, srv.autoFlush(c)
+	}
+	return true, nil
+}
+
+// dispatchable returns the handler entry if the command may be dispatched
+// to a shard worker. Streaming commands are executed inline.
+func (srv *Server) dispatchable(c *Client, norm string) (*handlerEntry, bool) {
+	entry, ok := srv.deferrable(c, norm)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := entry.served.(Handler); !ok {
+		return nil, false
+	}
+	return entry, true
+}
+
+// deferrable returns the handler entry if the command may be executed out
+// of line. SELECT, transactions and commands which are subject to
+// authentication handling are executed inline.
+func (srv *Server) deferrable(c *Client, norm string) (*handlerEntry, bool) {
+	// subsequent commands must see the selected database
+	if norm == "select" {
+		return nil, false
+	}
+	if srv.conf().Transactions {
+		switch norm {
+		case "multi", "exec", "discard", "watch", "unwatch":
+			return nil, false
+		}
+		if c.tx != nil {
+			return nil, false
+		}
+	}
+	if srv.authRequired() {
+		switch norm {
+		case "auth", "hello":
+			return nil, false
+		}
+		if !c.authed {
+			return nil, false
+		}
+	}
+
+	return srv.lookup(norm)
+}
+
+// collect waits for the dispatched commands of the client and appends
+// their replies in request order.
+func (srv *Server) collect(c *Client) {
+	for _, t := range c.dispatched {
+		<-t.done
+		_ = t.rw.Flush()
+		c.wr.AppendRaw(t.buf.Bytes())
+		t.release()
+	}
+	for i := range c.dispatched {
+		c.dispatched[i] = nil
+	}
+	c.dispatched = c.dispatched[:0]
+}
+
+// autoFlush flushes the client buffer after a command, as configured
+// by the flush policy. By default, when it is large enough.
+func (srv *Server) autoFlush(c *Client) error {
+	if err := srv.checkOutput(c); err != nil {
+		return err
+	}
+
+	policy := srv.conf().Flush
+	if policy.Manual {
+		return nil
+	}
+	if c.unflushed++; policy.Replies > 0 && c.unflushed >= policy.Replies {
+		return c.flush()
+	}
+	if n := c.wr.Buffered(); n > c.buffers.writeSize/2 {
+		return c.flush()
+	}
+	return nil
+}
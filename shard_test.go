@@ -0,0 +1,171 @@
+package redeo
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ShardedDispatch", func() {
+	var subject *Server
+	var lis net.Listener
+	var counters map[string]*int
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		counters = map[string]*int{"k1": new(int), "k2": new(int), "k3": new(int)}
+
+		subject = NewServer(&Config{
+			ShardedDispatch: &ShardConfig{
+				Shards: 4,
+				KeyExtractor: func(cmd *resp.Command) []byte {
+					if cmd.ArgN() == 0 {
+						return nil
+					}
+					return cmd.Arg(0)
+				},
+			},
+		})
+		subject.Handle("ping", Ping())
+		subject.HandleDefaults("select")
+		subject.HandleFunc("db", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendInt(int64(ClientDB(c.Context())))
+		}, Arity(2))
+		subject.HandleFunc("sleep", func(w resp.ResponseWriter, c *resp.Command) {
+			ms, _ := strconv.Atoi(c.Arg(1).String())
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			w.AppendBulk(c.Arg(0))
+		}, Arity(3))
+		subject.HandleFunc("incr", func(w resp.ResponseWriter, c *resp.Command) {
+			n := counters[c.Arg(0).String()]
+			*n++
+			w.AppendInt(int64(*n))
+		}, Arity(2))
+		subject.HandleFunc("work", func(w resp.ResponseWriter, c *resp.Command) {
+			time.Sleep(50 * time.Millisecond)
+			w.AppendBulk(c.Arg(1))
+		}, Arity(3), Flags("parallel"))
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should reply in request order", func() {
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmdString("SLEEP", "a", "50")
+		cw.WriteCmdString("SLEEP", "b", "0")
+		cw.WriteCmdString("SLEEP", "c")
+		cw.WriteCmdString("PING")
+		cw.WriteCmdString("SLEEP", "d", "10")
+		cw.WriteCmdString("UNKNOWN", "e")
+		cw.WriteCmdString("SLEEP", "f", "0")
+		Expect(cw.Flush()).To(Succeed())
+
+		Expect(cr.ReadBulkString()).To(Equal("a"))
+		Expect(cr.ReadBulkString()).To(Equal("b"))
+		Expect(cr.ReadError()).To(Equal("ERR wrong number of arguments for 'SLEEP' command"))
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		Expect(cr.ReadBulkString()).To(Equal("d"))
+		Expect(cr.ReadError()).To(HavePrefix("ERR unknown command"))
+		Expect(cr.ReadBulkString()).To(Equal("f"))
+
+		Expect(subject.Info().TotalCommands()).To(Equal(int64(5)))
+	})
+
+	It("should apply SELECT to subsequent commands", func() {
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmdString("DB", "k1")
+		cw.WriteCmdString("SELECT", "2")
+		cw.WriteCmdString("DB", "k2")
+		cw.WriteCmdString("SELECT", "5")
+		cw.WriteCmdString("DB", "k1")
+		Expect(cw.Flush()).To(Succeed())
+
+		Expect(cr.ReadInt()).To(Equal(int64(0)))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadInt()).To(Equal(int64(2)))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadInt()).To(Equal(int64(5)))
+	})
+
+	It("should perform commands without a key inline", func() {
+		blocked := make(chan *Blocker, 1)
+		subject.HandleFunc("block", func(w resp.ResponseWriter, c *resp.Command) {
+			blocked <- GetClient(c.Context()).Block(0)
+		}, Arity(1))
+
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		cw.WriteCmdString("SLEEP", "a", "10")
+		cw.WriteCmdString("BLOCK")
+		cw.WriteCmdString("PING")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadBulkString()).To(Equal("a"))
+
+		var b *Blocker
+		Eventually(blocked).Should(Receive(&b))
+		Expect(b.Resolve(func(w resp.ResponseWriter) { w.AppendBulkString("done") })).To(BeTrue())
+		Expect(cr.ReadBulkString()).To(Equal("done"))
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+	})
+
+	It("should serialize commands by key", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				cn, cw, cr := dialRaw(lis)
+				defer cn.Close()
+
+				for j := 0; j < 100; j++ {
+					cw.WriteCmdString("INCR", "k"+strconv.Itoa(j%3+1))
+				}
+				Expect(cw.Flush()).To(Succeed())
+				for j := 0; j < 100; j++ {
+					_, err := cr.ReadInt()
+					Expect(err).NotTo(HaveOccurred())
+				}
+			}()
+		}
+		wg.Wait()
+
+		Expect(*counters["k1"] + *counters["k2"] + *counters["k3"]).To(Equal(400))
+		Expect(*counters["k1"]).To(Equal(136))
+	})
+
+	It("should execute independent commands in parallel", func() {
+		cn, cw, cr := dialRaw(lis)
+		defer cn.Close()
+
+		start := time.Now()
+		for _, s := range []string{"a", "b", "c", "d"} {
+			cw.WriteCmdString("WORK", "k1", s)
+		}
+		Expect(cw.Flush()).To(Succeed())
+
+		Expect(cr.ReadBulkString()).To(Equal("a"))
+		Expect(cr.ReadBulkString()).To(Equal("b"))
+		Expect(cr.ReadBulkString()).To(Equal("c"))
+		Expect(cr.ReadBulkString()).To(Equal("d"))
+		Expect(time.Since(start)).To(BeNumerically("<", 150*time.Millisecond))
+	})
+
+})
@@ -0,0 +1,183 @@
+package redeo
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+const (
+	defaultSlowLogMaxLen = 128
+
+	slowLogMaxArgs   = 32  // max number of recorded arguments
+	slowLogMaxArgLen = 128 // max length of a recorded argument
+)
+
+// SlowLogEntry is a slow log entry
+type SlowLogEntry struct {
+	// ID is a unique, monotonically increasing identifier
+	ID int64
+	// Time is the time the command was processed
+	Time time.Time
+	// Duration is the execution time of the handler
+	Duration time.Duration
+	// Args contains the command name followed by its (truncated) arguments
+	Args []string
+	// Addr is the remote address of the client
+	Addr string
+	// Name is the name of the client
+	Name string
+}
+
+// SlowLog records commands which exceed Config.SlowLogThreshold
+// in a fixed-size ring buffer.
+type SlowLog struct {
+	entries []SlowLogEntry
+	pos     int // position of the next entry
+	size    int // number of entries
+	nextID  int64
+	mu      sync.Mutex
+}
+
+func newSlowLog(maxLen int) *SlowLog {
+	if maxLen < 1 {
+		maxLen = defaultSlowLogMaxLen
+	}
+	return &SlowLog{entries: make([]SlowLogEntry, maxLen)}
+}
+
+// Entries returns up to n of the most recent entries, newest first.
+// Returns all entries if n is negative.
+func (l *SlowLog) Entries(n int) []SlowLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n < 0 || n > l.size {
+		n = l.size
+	}
+
+	res := make([]SlowLogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		res = append(res, l.entries[(l.pos-i+len(l.entries))%len(l.entries)])
+	}
+	return res
+}
+
+// Len returns the number of entries.
+func (l *SlowLog) Len() int {
+	l.mu.Lock()
+	n := l.size
+	l.mu.Unlock()
+	return n
+}
+
+// Reset removes all entries.
+func (l *SlowLog) Reset() {
+	l.mu.Lock()
+	for i := range l.entries {
+		l.entries[i] = SlowLogEntry{}
+	}
+	l.pos, l.size = 0, 0
+	l.mu.Unlock()
+}
+
+func (l *SlowLog) add(start time.Time, d time.Duration, name string, args []resp.CommandArgument, addr, clientName string) {
+	entry := SlowLogEntry{
+		Time:     start,
+		Duration: d,
+		Args:     slowLogArgs(name, args),
+		Addr:     addr,
+		Name:     clientName,
+	}
+
+	l.mu.Lock()
+	l.nextID++
+	entry.ID = l.nextID
+	l.entries[l.pos] = entry
+	l.pos = (l.pos + 1) % len(l.entries)
+	if l.size < len(l.entries) {
+		l.size++
+	}
+	l.mu.Unlock()
+}
+
+// slowLogArgs truncates the arguments the same way redis does.
+// Credentials are redacted, like in MONITOR.
+func slowLogArgs(name string, args []resp.CommandArgument) []string {
+	argc := len(args) + 1
+	if argc > slowLogMaxArgs {
+		argc = slowLogMaxArgs
+	}
+
+	res := make([]string, 0, argc)
+	res = append(res, name)
+	secret := redactedArgs(name, args)
+	for i, arg := range args {
+		if len(res) == slowLogMaxArgs-1 && len(args) > i+1 {
+			res = append(res, "... ("+strconv.Itoa(len(args)-i)+" more arguments)")
+			break
+		}
+		if i >= secret.start && i < secret.end {
+			res = append(res, "(redacted)")
+		} else if len(arg) > slowLogMaxArgLen {
+			res = append(res, string(arg[:slowLogMaxArgLen])+"... ("+strconv.Itoa(len(arg)-slowLogMaxArgLen)+" more bytes)")
+		} else {
+			res = append(res, string(arg))
+		}
+	}
+	return res
+}
+
+// --------------------------------------------------------------------
+
+// slowLogCommand returns a SLOWLOG handler.
+// https://redis.io/commands/slowlog
+func slowLogCommand(l *SlowLog) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() == 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		switch sub := c.Arg(0).String(); strings.ToLower(sub) {
+		case "get":
+			n := 10
+			if c.ArgN() > 2 {
+				w.AppendError(WrongNumberOfArgs(c.Name + " " + sub))
+				return
+			} else if c.ArgN() == 2 {
+				v, err := c.Arg(1).Int()
+				if err != nil || v < -1 {
+					w.AppendError("ERR count should be greater than or equal to -1")
+					return
+				}
+				n = int(v)
+			}
+
+			entries := l.Entries(n)
+			w.AppendArrayLen(len(entries))
+			for _, e := range entries {
+				w.AppendArrayLen(6)
+				w.AppendInt(e.ID)
+				w.AppendInt(e.Time.Unix())
+				w.AppendInt(int64(e.Duration / time.Microsecond))
+				w.AppendArrayLen(len(e.Args))
+				for _, arg := range e.Args {
+					w.AppendBulkString(arg)
+				}
+				w.AppendBulkString(e.Addr)
+				w.AppendBulkString(e.Name)
+			}
+		case "len":
+			w.AppendInt(int64(l.Len()))
+		case "reset":
+			l.Reset()
+			w.AppendOK()
+		default:
+			w.AppendError("ERR Unknown " + strings.ToLower(c.Name) + " subcommand '" + sub + "'")
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package redeo
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SlowLog", func() {
+	var subject *SlowLog
+
+	var add = func(name string, args ...string) {
+		cargs := make([]resp.CommandArgument, 0, len(args))
+		for _, arg := range args {
+			cargs = append(cargs, resp.CommandArgument(arg))
+		}
+		subject.add(time.Unix(1515151515, 0), 20*time.Millisecond, name, cargs, "1.2.3.4:10001", "")
+	}
+
+	BeforeEach(func() {
+		subject = newSlowLog(3)
+	})
+
+	It("should record entries", func() {
+		add("get", "key")
+		add("set", "key", "val")
+		Expect(subject.Len()).To(Equal(2))
+		Expect(subject.Entries(-1)).To(Equal([]SlowLogEntry{
+			{ID: 2, Time: time.Unix(1515151515, 0), Duration: 20 * time.Millisecond, Args: []string{"set", "key", "val"}, Addr: "1.2.3.4:10001"},
+			{ID: 1, Time: time.Unix(1515151515, 0), Duration: 20 * time.Millisecond, Args: []string{"get", "key"}, Addr: "1.2.3.4:10001"},
+		}))
+		Expect(subject.Entries(1)).To(HaveLen(1))
+	})
+
+	It("should discard old entries", func() {
+		for i := 0; i < 5; i++ {
+			add("ping")
+		}
+		Expect(subject.Len()).To(Equal(3))
+
+		entries := subject.Entries(10)
+		Expect(entries).To(HaveLen(3))
+		Expect(entries[0].ID).To(Equal(int64(5)))
+		Expect(entries[2].ID).To(Equal(int64(3)))
+	})
+
+	It("should reset", func() {
+		add("ping")
+		subject.Reset()
+		Expect(subject.Len()).To(Equal(0))
+		Expect(subject.Entries(-1)).To(BeEmpty())
+
+		add("ping")
+		Expect(subject.Entries(-1)[0].ID).To(Equal(int64(2)))
+	})
+
+	It("should truncate arguments", func() {
+		args := make([]string, 40)
+		for i := range args {
+			args[i] = "x"
+		}
+		args[0] = strings.Repeat("y", 130)
+		add("mset", args...)
+
+		recorded := subject.Entries(1)[0].Args
+		Expect(recorded).To(HaveLen(32))
+		Expect(recorded[0]).To(Equal("mset"))
+		Expect(recorded[1]).To(Equal(strings.Repeat("y", 128) + "... (2 more bytes)"))
+		Expect(recorded[30]).To(Equal("x"))
+		Expect(recorded[31]).To(Equal("... (10 more arguments)"))
+	})
+
+	It("should redact credentials", func() {
+		add("auth", "secret")
+		add("hello", "3", "auth", "user", "secret")
+		Expect(subject.Entries(1)[0].Args).To(Equal([]string{"hello", "3", "auth", "(redacted)", "(redacted)"}))
+		Expect(subject.Entries(2)[1].Args).To(Equal([]string{"auth", "(redacted)"}))
+	})
+
+	It("should serve SLOWLOG", func() {
+		add("get", "key")
+
+		w := redeotest.NewRecorder()
+		cmd := slowLogCommand(subject)
+
+		cmd.ServeRedeo(w, resp.NewCommand("SLOWLOG", resp.CommandArgument("get")))
+		Expect(w.Response()).To(Equal([]interface{}{
+			[]interface{}{int64(1), int64(1515151515), int64(20000), []interface{}{"get", "key"}, "1.2.3.4:10001", ""},
+		}))
+
+		cmd.ServeRedeo(w, resp.NewCommand("SLOWLOG", resp.CommandArgument("len")))
+		Expect(w.Response()).To(Equal(int64(1)))
+
+		cmd.ServeRedeo(w, resp.NewCommand("SLOWLOG", resp.CommandArgument("get"), resp.CommandArgument("-2")))
+		Expect(w.Response()).To(MatchError("ERR count should be greater than or equal to -1"))
+
+		cmd.ServeRedeo(w, resp.NewCommand("SLOWLOG", resp.CommandArgument("reset")))
+		Expect(w.Response()).To(Equal("OK"))
+		Expect(subject.Len()).To(Equal(0))
+
+		cmd.ServeRedeo(w, resp.NewCommand("SLOWLOG", resp.CommandArgument("bad")))
+		Expect(w.Response()).To(MatchError("ERR Unknown slowlog subcommand 'bad'"))
+	})
+
+	It("should record slow commands", func() {
+		srv := NewServer(&Config{SlowLogThreshold: 5 * time.Millisecond})
+		srv.HandleSlowLog()
+		srv.Handle("ping", Ping())
+		srv.HandleFunc("sleep", func(w resp.ResponseWriter, c *resp.Command) {
+			time.Sleep(10 * time.Millisecond)
+			w.AppendOK()
+		})
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go srv.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmd("PING")
+		cw.WriteCmdString("SLEEP", "arg")
+		cw.WriteCmdString("SLOWLOG", "len")
+		Expect(cw.Flush()).To(Succeed())
+
+		Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadInt()).To(Equal(int64(1)))
+
+		entries := srv.SlowLog().Entries(-1)
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Args).To(Equal([]string{"sleep", "arg"}))
+		Expect(entries[0].Duration).To(BeNumerically(">=", 10*time.Millisecond))
+		Expect(entries[0].Addr).To(Equal(cn.LocalAddr().String()))
+	})
+
+})
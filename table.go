@@ -0,0 +1,133 @@
+package redeo
+
+import "strings"
+
+// maxInternLen is the maximum length of command names which are
+// normalised without allocating, long enough for namespaced names.
+const maxInternLen = 64
+
+// commandTable is an immutable snapshot of the registered handlers. It
+// is replaced on every registration, so commands can be dispatched
+// without holding a lock.
+type commandTable struct {
+	cmds     map[string]*handlerEntry
+	names    map[string]string // interned command names
+	fallback *handlerEntry
+}
+
+// publish stores a snapshot of the registered handlers, must be called
+// with the lock held.
+func (srv *Server) publish() {
+	t := &commandTable{
+		cmds:     make(map[string]*handlerEntry, len(srv.cmds)),
+		names:    make(map[string]string, len(srv.cmds)),
+		fallback: srv.fallback,
+	}
+	for name, entry := range srv.cmds {
+		t.cmds[name] = entry
+		t.names[name] = name
+	}
+	srv.table.Store(t)
+}
+
+// lookup returns the handler entry for a normalised command name,
+// falling back on the default handler.
+func (srv *Server) lookup(norm string) (*handlerEntry, bool) {
+	t := srv.table.Load().(*commandTable)
+	if entry, ok := t.cmds[norm]; ok {
+		return entry, true
+	}
+	return t.fallback, t.fallback != nil
+}
+
+// normalize returns the lowercase command name. Names of registered
+// commands are interned to avoid allocations.
+func (srv *Server) normalize(name string) string {
+	if len(name) > maxInternLen {
+		return strings.ToLower(name)
+	}
+
+	var buf [maxInternLen]byte
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 0x80 {
+			return strings.ToLower(name)
+		} else if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		buf[i] = c
+	}
+
+	t := srv.table.Load().(*commandTable)
+	if norm, ok := t.names[string(buf[:len(name)])]; ok {
+		return norm
+	}
+	return strings.ToLower(name)
+}
+
+// --------------------------------------------------------------------
+
+// HandlerSet collects handler changes, which are applied atomically via
+// Server.SetHandlers, e.g. to switch feature-flagged or canary handlers
+// at runtime.
+type HandlerSet struct {
+	changes []handlerChange
+}
+
+type handlerChange struct {
+	name    string
+	handler interface{} // nil to remove
+	opts    []HandlerOption
+}
+
+// NewHandlerSet creates an empty handler set.
+func NewHandlerSet() *HandlerSet {
+	return new(HandlerSet)
+}
+
+// Handle adds a handler for a command.
+func (s *HandlerSet) Handle(name string, h Handler, opts ...HandlerOption) {
+	s.changes = append(s.changes, handlerChange{name: name, handler: h, opts: opts})
+}
+
+// HandleFunc adds a handler func for a command.
+func (s *HandlerSet) HandleFunc(name string, fn HandlerFunc, opts ...HandlerOption) {
+	s.Handle(name, fn, opts...)
+}
+
+// HandleStream adds a handler for a streaming command.
+func (s *HandlerSet) HandleStream(name string, h StreamHandler, opts ...HandlerOption) {
+	s.changes = append(s.changes, handlerChange{name: name, handler: h, opts: opts})
+}
+
+// HandleStreamFunc adds a handler func for a streaming command.
+func (s *HandlerSet) HandleStreamFunc(name string, fn StreamHandlerFunc, opts ...HandlerOption) {
+	s.HandleStream(name, fn, opts...)
+}
+
+// Unhandle removes a command.
+func (s *HandlerSet) Unhandle(name string) {
+	s.changes = append(s.changes, handlerChange{name: name})
+}
+
+// SetHandlers applies all changes of set at once. Commands dispatched
+// concurrently see either none or all of the changes. Commands not
+// mentioned in set are kept.
+func (srv *Server) SetHandlers(set *HandlerSet) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for _, ch := range set.changes {
+		norm := strings.ToLower(ch.name)
+		if ch.handler == nil {
+			delete(srv.cmds, norm)
+			continue
+		}
+		srv.cmds[norm] = &handlerEntry{
+			handler: ch.handler,
+			served:  srv.chain(ch.handler),
+			spec:    newCommandSpec(ch.opts),
+		}
+	}
+	srv.publish()
+}
@@ -0,0 +1,119 @@
+package redeo
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// defaultTimeoutError is the default error reply of timed out commands,
+// see HandleWithTimeout.
+var defaultTimeoutError = BusyError{Msg: "command timed out"}.Error()
+
+// TimeoutError sets the error reply of commands registered via
+// HandleWithTimeout, once the time limit is reached.
+// Default: "BUSY command timed out"
+func TimeoutError(msg string) HandlerOption {
+	return func(s *commandSpec) { s.timeoutError = msg }
+}
+
+// TimeoutWait makes the connection of commands registered via
+// HandleWithTimeout wait for the handler to return once the time limit
+// is reached. The error reply is flushed right away, but the next command
+// is only read afterwards, e.g. for handlers which must not run alongside
+// other commands of the same client.
+func TimeoutWait() HandlerOption {
+	return func(s *commandSpec) { s.timeoutWait = true }
+}
+
+// HandleWithTimeout registers a handler for a command, which may run for
+// at most d. Once d has passed, the command context is cancelled and an
+// error reply is sent instead, see TimeoutError. The reply of the handler
+// is abandoned and, unless TimeoutWait is set, the pipeline proceeds while
+// the handler may still be running.
+//
+// Handlers run on a separate goroutine and write to a buffer, so flushes
+// do not reach the client before the handler returns.
+func (srv *Server) HandleWithTimeout(name string, h Handler, d time.Duration, opts ...HandlerOption) {
+	if d <= 0 {
+		srv.Handle(name, h, opts...)
+		return
+	}
+
+	spec := newCommandSpec(opts)
+	th := &timeoutHandler{srv: srv, h: h, d: d, msg: spec.timeoutError, wait: spec.timeoutWait}
+	if th.msg == "" {
+		th.msg = defaultTimeoutError
+	}
+	srv.Handle(name, th, opts...)
+}
+
+// timeoutHandler limits the execution time of a handler.
+type timeoutHandler struct {
+	srv  *Server
+	h    Handler
+	d    time.Duration
+	msg  string
+	wait bool
+}
+
+// states of timed executions
+const (
+	timedRunning int32 = iota
+	timedCompleted
+	timedOut
+)
+
+func (t *timeoutHandler) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	ctx, cancel := context.WithTimeout(c.Context(), t.d)
+	defer cancel()
+
+	// the handler may outlive c, whose buffers are recycled
+	cmd := copyCommand(c)
+	cmd.SetContext(ctx)
+	name := CommandName(ctx)
+
+	var buf bytes.Buffer
+	bw := &replyWriter{ResponseWriter: resp.NewResponseWriter(&buf)}
+	bw.SetProtocol(w.Protocol())
+	bw.bufferTo(&buf)
+
+	var state int32
+	var recovered interface{}
+	exit := make(chan struct{})
+	go func() {
+		defer close(exit)
+		defer func() {
+			r := recover()
+			if atomic.CompareAndSwapInt32(&state, timedRunning, timedCompleted) {
+				recovered = r
+			} else if r != nil {
+				t.srv.notePanic(name, r)
+			}
+		}()
+		t.h.ServeRedeo(bw, cmd)
+	}()
+
+	select {
+	case <-exit:
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&state, timedRunning, timedOut) {
+			w.AppendError(t.msg)
+			if t.wait {
+				_ = w.Flush()
+				<-exit
+			}
+			return
+		}
+		<-exit
+	}
+
+	if recovered != nil {
+		panic(recovered) // re-panic, to be recovered like panics in handlers
+	}
+	_ = bw.Flush()
+	appendReply(w, buf.Bytes())
+}
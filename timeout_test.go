@@ -0,0 +1,99 @@
+package redeo
+
+import (
+	"context"
+	"time"
+
+	"github.com/wangaoone/redeo/redeotest"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server.HandleWithTimeout", func() {
+	var subject *Server
+	var lis *redeotest.Listener
+	var finished chan error
+
+	var slow = func(w resp.ResponseWriter, c *resp.Command) {
+		ctx := c.Context()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+		time.Sleep(50 * time.Millisecond)
+		w.AppendOK()
+		finished <- ctx.Err()
+	}
+
+	var dial = func() *redeotest.Client {
+		cn, _, err := lis.Dial()
+		Expect(err).NotTo(HaveOccurred())
+		return redeotest.NewClient(cn)
+	}
+
+	BeforeEach(func() {
+		finished = make(chan error, 1)
+		subject = NewServer(nil)
+		subject.Handle("ping", Ping())
+		subject.HandleWithTimeout("echo", Echo(), time.Second)
+		subject.HandleWithTimeout("slow", HandlerFunc(slow), 20*time.Millisecond)
+		subject.HandleWithTimeout("wait", HandlerFunc(slow), 20*time.Millisecond, TimeoutError("ERR too slow"), TimeoutWait())
+		subject.HandleWithTimeout("boom", HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendArrayLen(2)
+			panic("boom")
+		}), time.Second)
+
+		lis = redeotest.NewBufferedListener()
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should reply within the limit", func() {
+		c := dial()
+		defer c.Close()
+
+		Expect(c.Do("ECHO", "hello")).To(Equal("hello"))
+		Expect(c.Do("ECHO")).To(Equal(redeotest.ErrorResponse("ERR wrong number of arguments for 'ECHO' command")))
+	})
+
+	It("should abandon slow handlers", func() {
+		c := dial()
+		defer c.Close()
+
+		c.Send("SLOW")
+		c.Send("PING")
+		Expect(c.Flush()).To(Succeed())
+
+		c.ExpectError(GinkgoT(), "BUSY command timed out")
+		c.ExpectReply(GinkgoT(), "PONG")
+		Expect(finished).NotTo(Receive())
+		Eventually(finished).Should(Receive(Equal(context.DeadlineExceeded)))
+	})
+
+	It("should wait for slow handlers", func() {
+		c := dial()
+		defer c.Close()
+
+		c.Send("WAIT")
+		c.Send("PING")
+		Expect(c.Flush()).To(Succeed())
+
+		c.ExpectError(GinkgoT(), "ERR too slow")
+		c.ExpectReply(GinkgoT(), "PONG")
+		Expect(finished).To(Receive(Equal(context.DeadlineExceeded)))
+	})
+
+	It("should recover panics", func() {
+		c := dial()
+		defer c.Close()
+
+		Expect(c.Do("BOOM")).To(Equal(redeotest.ErrorResponse("ERR internal error: boom")))
+		Expect(c.Do("PING")).To(Equal("PONG"))
+		Expect(subject.info.panics.Value()).To(Equal(int64(1)))
+	})
+
+})
@@ -0,0 +1,102 @@
+package redeo
+
+import (
+	"context"
+	"strings"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+type ctxKeyTraceParent struct{}
+
+// TraceParent returns the trace context propagated by the client with the
+// current command, see Config.TraceArg.
+func TraceParent(ctx context.Context) string {
+	if ctx != nil {
+		if s, ok := ctx.Value(ctxKeyTraceParent{}).(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// extractTraceParent removes the trailing trace argument pair from cmd,
+// if Config.TraceArg is set, and attaches the value to the command context.
+func (c *Client) extractTraceParent(cmd *resp.Command) {
+	name := c.traceArg
+	if name == "" {
+		return
+	}
+
+	n := cmd.ArgN()
+	if n < 2 || !strings.EqualFold(cmd.Arg(n-2).String(), name) {
+		return
+	}
+	cmd.SetContext(context.WithValue(cmd.Context(), ctxKeyTraceParent{}, string(cmd.Arg(n-1))))
+	// empty the stripped arguments, their buffers are reused on Reset
+	cmd.Args[n-2], cmd.Args[n-1] = cmd.Args[n-2][:0], cmd.Args[n-1][:0]
+	cmd.Args = cmd.Args[:n-2]
+}
+
+// withTraceParent returns ctx with the trace context of cmd, if any.
+func withTraceParent(ctx context.Context, cmd *resp.Command) context.Context {
+	if s := TraceParent(cmd.Context()); s != "" {
+		return context.WithValue(ctx, ctxKeyTraceParent{}, s)
+	}
+	return ctx
+}
+
+// --------------------------------------------------------------------
+
+// CommandSpan describes a traced command, see TraceCommands.
+type CommandSpan struct {
+	// Name is the normalised command name
+	Name string
+	// Args is the number of arguments
+	Args int
+	// ClientAddr is the remote address of the client
+	ClientAddr string
+	// TraceParent is the trace context propagated by the client, see
+	// Config.TraceArg
+	TraceParent string
+
+	// Err is the error reply or protocol error, set on completion
+	Err error
+	// BytesWritten is the size of the reply, set on completion
+	BytesWritten int64
+}
+
+// TraceCommands returns a Hooks.OnCommandStart callback, which describes
+// each command as a CommandSpan, e.g. to start an OpenTelemetry span with
+// the command's attributes. The context returned by start is passed to
+// the handler, the returned function is called with the completed span.
+func TraceCommands(start func(ctx context.Context, span *CommandSpan) (context.Context, func(span *CommandSpan))) func(context.Context, string, int) (context.Context, func(error)) {
+	return func(ctx context.Context, name string, args int) (context.Context, func(error)) {
+		span := &CommandSpan{Name: name, Args: args, TraceParent: TraceParent(ctx)}
+
+		c := GetClient(ctx)
+		var offset int64
+		if c != nil {
+			span.ClientAddr = c.RemoteAddr().String()
+			offset = c.replySize()
+		}
+
+		ctx, finish := start(ctx, span)
+		return ctx, func(err error) {
+			if finish == nil {
+				return
+			}
+			span.Err = err
+			if c != nil {
+				span.BytesWritten = c.replySize() - offset
+			}
+			finish(span)
+		}
+	}
+}
+
+// replySize returns the number of bytes written and buffered for the
+// client so far.
+func (c *Client) replySize() int64 {
+	return c.BytesWritten() + int64(c.wr.Buffered())
+}
@@ -0,0 +1,120 @@
+package redeo
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracing", func() {
+
+	It("should trace commands", func() {
+		var spans []CommandSpan
+		var mu sync.Mutex
+
+		srv := NewServer(&Config{
+			TraceArg: "TRACEPARENT",
+			Hooks: Hooks{
+				OnCommandStart: TraceCommands(func(ctx context.Context, span *CommandSpan) (context.Context, func(*CommandSpan)) {
+					return ctx, func(span *CommandSpan) {
+						mu.Lock()
+						spans = append(spans, *span)
+						mu.Unlock()
+					}
+				}),
+			},
+		})
+		srv.Handle("echo", Echo())
+		srv.HandleFunc("parent", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendBulkString(TraceParent(c.Context()))
+		})
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go srv.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmdString("ECHO", "hello", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		cw.WriteCmdString("PARENT", "TRACEPARENT", "00-x-y-01")
+		cw.WriteCmdString("ECHO")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadBulkString()).To(Equal("hello"))
+		Expect(cr.ReadBulkString()).To(Equal("00-x-y-01"))
+		Expect(cr.ReadError()).To(Equal("ERR wrong number of arguments for 'ECHO' command"))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(spans).To(HaveLen(3))
+		Expect(spans[0]).To(Equal(CommandSpan{
+			Name:         "echo",
+			Args:         1,
+			ClientAddr:   cn.LocalAddr().String(),
+			TraceParent:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			BytesWritten: 11,
+		}))
+		Expect(spans[1].Args).To(Equal(0))
+		Expect(spans[2].TraceParent).To(BeEmpty())
+		Expect(spans[2].Err).To(MatchError("ERR wrong number of arguments for 'ECHO' command"))
+	})
+
+	It("should strip trace arguments of asynchronous, sharded and queued commands", func() {
+		srv := NewServer(&Config{
+			TraceArg:     "TRACEPARENT",
+			Transactions: true,
+			ShardedDispatch: &ShardConfig{
+				KeyExtractor: func(cmd *resp.Command) []byte {
+					if cmd.ArgN() == 0 {
+						return nil
+					}
+					return cmd.Arg(0)
+				},
+			},
+		})
+		srv.HandleFunc("parent", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendBulkString(TraceParent(c.Context()))
+		}, Arity(1))
+		srv.HandleFunc("kparent", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendBulkString(TraceParent(c.Context()))
+		}, Arity(2))
+		srv.HandleAsyncFunc("aparent", func(p *Promise, c *resp.Command) {
+			s := TraceParent(c.Context())
+			p.Resolve(func(w resp.ResponseWriter) { w.AppendBulkString(s) })
+		}, Arity(1))
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go srv.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		cw.WriteCmdString("APARENT", "TRACEPARENT", "00-a-01")
+		cw.WriteCmdString("KPARENT", "key", "TRACEPARENT", "00-k-01")
+		cw.WriteCmdString("MULTI")
+		cw.WriteCmdString("PARENT", "TRACEPARENT", "00-m-01")
+		cw.WriteCmdString("APARENT", "TRACEPARENT", "00-n-01")
+		cw.WriteCmdString("EXEC")
+		Expect(cw.Flush()).To(Succeed())
+		Expect(cr.ReadBulkString()).To(Equal("00-a-01"))
+		Expect(cr.ReadBulkString()).To(Equal("00-k-01"))
+		Expect(cr.ReadInlineString()).To(Equal("OK"))
+		Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+		Expect(cr.ReadInlineString()).To(Equal("QUEUED"))
+		Expect(cr.ReadArrayLen()).To(Equal(2))
+		Expect(cr.ReadBulkString()).To(Equal("00-m-01"))
+		Expect(cr.ReadBulkString()).To(Equal("00-n-01"))
+	})
+
+})
@@ -0,0 +1,251 @@
+package redeo
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wangaoone/redeo/resp"
+)
+
+// trackingTable holds the state of client-side caching, see CLIENT
+// TRACKING.
+type trackingTable struct {
+	clients map[*Client]*trackingState
+	keys    map[string]map[*Client]struct{} // keys read by clients in default mode
+	hooked  map[*Client]struct{}            // clients with a release hook, see release
+	mu      sync.Mutex
+	n       int32
+}
+
+type trackingState struct {
+	bcast    bool
+	prefixes []string // BCAST mode only, all keys if empty
+}
+
+// active returns true if any client has tracking enabled.
+func (t *trackingTable) active() bool {
+	return atomic.LoadInt32(&t.n) != 0
+}
+
+// enable turns on tracking for c. Returns true if c has not had tracking
+// enabled before, so release must be registered as a release hook.
+func (t *trackingTable) enable(c *Client, st *trackingState) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.clients == nil {
+		t.clients = make(map[*Client]*trackingState)
+		t.hooked = make(map[*Client]struct{})
+	}
+	if _, ok := t.clients[c]; !ok {
+		atomic.AddInt32(&t.n, 1)
+	}
+	t.clients[c] = st
+
+	if _, ok := t.hooked[c]; ok {
+		return false
+	}
+	t.hooked[c] = struct{}{}
+	return true
+}
+
+// release disables tracking for a disconnected client.
+func (t *trackingTable) release(c *Client) {
+	t.disable(c)
+
+	t.mu.Lock()
+	delete(t.hooked, c)
+	t.mu.Unlock()
+}
+
+// disable turns off tracking for c and forgets the keys it has read.
+func (t *trackingTable) disable(c *Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.clients[c]; !ok {
+		return
+	}
+	delete(t.clients, c)
+	atomic.AddInt32(&t.n, -1)
+
+	for key, clients := range t.keys {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(t.keys, key)
+		}
+	}
+}
+
+// track remembers the keys read by c, unless it is in BCAST mode.
+func (t *trackingTable) track(c *Client, keys []resp.CommandArgument) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.clients[c]
+	if !ok || st.bcast {
+		return
+	}
+	if t.keys == nil {
+		t.keys = make(map[string]map[*Client]struct{})
+	}
+	for _, key := range keys {
+		clients, ok := t.keys[string(key)]
+		if !ok {
+			clients = make(map[*Client]struct{})
+			t.keys[string(key)] = clients
+		}
+		clients[c] = struct{}{}
+	}
+}
+
+// invalidate returns the keys to invalidate by client. Keys tracked in
+// default mode are forgotten, as clients must read them again to receive
+// further invalidations.
+func (t *trackingTable) invalidate(keys []string) map[*Client][]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make(map[*Client][]string)
+	for _, key := range keys {
+		for c := range t.keys[key] {
+			res[c] = append(res[c], key)
+		}
+		delete(t.keys, key)
+	}
+	for c, st := range t.clients {
+		if !st.bcast {
+			continue
+		}
+		for _, key := range keys {
+			if st.matches(key) {
+				res[c] = append(res[c], key)
+			}
+		}
+	}
+	return res
+}
+
+// flush forgets all tracked keys and returns the tracking clients.
+func (t *trackingTable) flush() []*Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.keys = nil
+	clients := make([]*Client, 0, len(t.clients))
+	for c := range t.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+func (st *trackingState) matches(key string) bool {
+	if len(st.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range st.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// --------------------------------------------------------------------
+
+// Invalidate sends invalidation push messages to clients, which track the
+// given keys via CLIENT TRACKING. Handlers of commands that modify keys
+// should call it, so clients can evict them from their caches. Without
+// keys, all tracking clients are told to flush their caches, e.g. after
+// FLUSHALL.
+func (srv *Server) Invalidate(keys ...string) {
+	if !srv.tracking.active() {
+		return
+	}
+
+	if len(keys) == 0 {
+		for _, c := range srv.tracking.flush() {
+			_ = c.Push(func(w resp.ResponseWriter) {
+				w.AppendPushLen(2)
+				w.AppendBulkString("invalidate")
+				w.AppendNil()
+			})
+		}
+		return
+	}
+
+	for c, keys := range srv.tracking.invalidate(keys) {
+		keys := keys
+		_ = c.Push(func(w resp.ResponseWriter) {
+			w.AppendPushLen(2)
+			w.AppendBulkString("invalidate")
+			w.AppendArrayLen(len(keys))
+			for _, key := range keys {
+				w.AppendBulkString(key)
+			}
+		})
+	}
+}
+
+// trackKeys remembers the keys read by a successful command of a
+// tracking client. Keys of commands flagged "readonly" are located via
+// their key positions, see Keys.
+func (srv *Server) trackKeys(c *Client, entry *handlerEntry, name string, args []resp.CommandArgument) {
+	if !srv.tracking.active() || !entry.spec.hasFlag("readonly") {
+		return
+	}
+
+	desc := entry.describe(name)
+	if keys := desc.keys(args); len(keys) != 0 {
+		srv.tracking.track(c, keys)
+	}
+}
+
+// clientTracking implements
+// CLIENT TRACKING ON|OFF [BCAST] [PREFIX prefix [PREFIX prefix ...]].
+func clientTracking(s *Server, self *Client, w resp.ResponseWriter, c *resp.Command) {
+	st := new(trackingState)
+	for i := 1; i < c.ArgN(); i++ {
+		switch strings.ToLower(c.Arg(i).String()) {
+		case "bcast":
+			st.bcast = true
+		case "prefix":
+			if i+1 >= c.ArgN() {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			i++
+			st.prefixes = append(st.prefixes, c.Arg(i).String())
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+	}
+
+	switch strings.ToLower(c.Arg(0).String()) {
+	case "on":
+		if len(st.prefixes) != 0 && !st.bcast {
+			w.AppendError("ERR PREFIX option requires BCAST mode to be enabled")
+			return
+		}
+		if self == nil {
+			break
+		}
+		if self.Protocol() != resp.RESP3 {
+			w.AppendError("ERR client tracking requires RESP3, see HELLO")
+			return
+		}
+		if s.tracking.enable(self, st) {
+			self.onRelease(func() { s.tracking.release(self) })
+		}
+	case "off":
+		if self != nil {
+			s.tracking.disable(self)
+		}
+	default:
+		w.AppendError("ERR syntax error")
+		return
+	}
+	w.AppendOK()
+}
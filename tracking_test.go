@@ -0,0 +1,189 @@
+package redeo
+
+import (
+	"net"
+	"strings"
+
+	"github.com/wangaoone/redeo/client"
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracking", func() {
+	var subject *Server
+	var lis net.Listener
+
+	var dial = func() client.Conn {
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		return client.Wrap(cn)
+	}
+
+	var cmd = func(c client.Conn, name string, args ...interface{}) *resp.Reply {
+		r, err := c.Cmd(name, args...)
+		Expect(err).NotTo(HaveOccurred())
+		return r
+	}
+
+	var receive = func(c client.Conn) []interface{} {
+		r, err := c.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Type).To(Equal(resp.TypePush))
+
+		elems, err := r.Slice()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(elems).To(HaveLen(2))
+		Expect(elems[0].Str()).To(Equal("invalidate"))
+		if elems[1].IsNil() {
+			return nil
+		}
+
+		keys, err := elems[1].Slice()
+		Expect(err).NotTo(HaveOccurred())
+		res := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			s, err := key.Str()
+			Expect(err).NotTo(HaveOccurred())
+			res = append(res, s)
+		}
+		return res
+	}
+
+	BeforeEach(func() {
+		subject = NewServer(nil)
+		subject.Handle("ping", Ping())
+		subject.Handle("hello", Hello())
+		subject.HandleClient()
+		subject.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendNil()
+		}, Arity(2), Flags("readonly"), Keys(1, 1, 1))
+		subject.HandleFunc("del", func(w resp.ResponseWriter, c *resp.Command) {
+			keys := make([]string, c.ArgN())
+			for i, arg := range c.Args {
+				keys[i] = arg.String()
+			}
+			subject.Invalidate(keys...)
+			w.AppendInt(int64(len(keys)))
+		}, Flags("write"), Keys(1, -1, 1))
+
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should track keys read by clients", func() {
+		c1, c2 := dial(), dial()
+		defer c1.Close()
+		defer c2.Close()
+
+		Expect(cmd(c1, "CLIENT", "TRACKING", "ON").Err()).To(MatchError("ERR client tracking requires RESP3, see HELLO"))
+		Expect(cmd(c1, "HELLO", "3").Err()).NotTo(HaveOccurred())
+		Expect(cmd(c1, "CLIENT", "TRACKING", "ON").Str()).To(Equal("OK"))
+		Expect(cmd(c1, "GET", "foo").IsNil()).To(BeTrue())
+		Expect(cmd(c1, "GET", "bar").IsNil()).To(BeTrue())
+
+		Expect(cmd(c2, "DEL", "foo", "baz").Int()).To(Equal(int64(2)))
+		Expect(receive(c1)).To(Equal([]interface{}{"foo"}))
+
+		// keys must be read again to be tracked
+		Expect(cmd(c2, "DEL", "foo").Int()).To(Equal(int64(1)))
+		Expect(cmd(c1, "PING").Str()).To(Equal("PONG"))
+
+		Expect(cmd(c1, "CLIENT", "TRACKING", "OFF").Str()).To(Equal("OK"))
+		Expect(cmd(c2, "DEL", "bar").Int()).To(Equal(int64(1)))
+		Expect(cmd(c1, "PING").Str()).To(Equal("PONG"))
+	})
+
+	It("should track keys read by shard workers and async handlers", func() {
+		srv := NewServer(&Config{
+			ShardedDispatch: &ShardConfig{
+				Shards:       2,
+				KeyExtractor: func(cmd *resp.Command) []byte {
+					if !strings.EqualFold(cmd.Name, "get") {
+						return nil
+					}
+					return cmd.Arg(0)
+				},
+			},
+		})
+		srv.Handle("hello", Hello())
+		srv.HandleClient()
+		srv.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendNil()
+		}, Arity(2), Flags("readonly"), Keys(1, 1, 1))
+		srv.HandleAsyncFunc("aget", func(p *Promise, c *resp.Command) {
+			p.Resolve(func(w resp.ResponseWriter) { w.AppendNil() })
+		}, Arity(2), Flags("readonly"), Keys(1, 1, 1))
+
+		sl, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer sl.Close()
+		go srv.Serve(sl)
+
+		cn, err := net.Dial("tcp", sl.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		c := client.Wrap(cn)
+		defer c.Close()
+
+		Expect(cmd(c, "HELLO", "3").Err()).NotTo(HaveOccurred())
+		Expect(cmd(c, "CLIENT", "TRACKING", "ON").Str()).To(Equal("OK"))
+		Expect(cmd(c, "GET", "foo").IsNil()).To(BeTrue())
+		Expect(cmd(c, "AGET", "bar").IsNil()).To(BeTrue())
+
+		srv.Invalidate("foo")
+		Expect(receive(c)).To(Equal([]interface{}{"foo"}))
+		srv.Invalidate("bar")
+		Expect(receive(c)).To(Equal([]interface{}{"bar"}))
+	})
+
+	It("should register the release hook once per client", func() {
+		c := dial()
+		Expect(cmd(c, "HELLO", "3").Err()).NotTo(HaveOccurred())
+		Expect(cmd(c, "CLIENT", "TRACKING", "ON").Str()).To(Equal("OK"))
+
+		clients := subject.liveClients()
+		Expect(clients).To(HaveLen(1))
+		hooks := func() int {
+			clients[0].mu.Lock()
+			defer clients[0].mu.Unlock()
+			return len(clients[0].hooks)
+		}
+		n := hooks()
+		for i := 0; i < 3; i++ {
+			Expect(cmd(c, "CLIENT", "TRACKING", "OFF").Str()).To(Equal("OK"))
+			Expect(cmd(c, "CLIENT", "TRACKING", "ON").Str()).To(Equal("OK"))
+		}
+		Expect(hooks()).To(Equal(n))
+
+		Expect(c.Close()).To(Succeed())
+		Eventually(func() int {
+			subject.tracking.mu.Lock()
+			defer subject.tracking.mu.Unlock()
+			return len(subject.tracking.hooked)
+		}).Should(BeZero())
+		Expect(subject.tracking.active()).To(BeFalse())
+	})
+
+	It("should broadcast invalidations by prefix", func() {
+		c := dial()
+		defer c.Close()
+
+		Expect(cmd(c, "HELLO", "3").Err()).NotTo(HaveOccurred())
+		Expect(cmd(c, "CLIENT", "TRACKING", "ON", "PREFIX", "user:").Err()).To(MatchError("ERR PREFIX option requires BCAST mode to be enabled"))
+		Expect(cmd(c, "CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "PREFIX", "group:").Str()).To(Equal("OK"))
+		Expect(cmd(c, "CLIENT", "TRACKING", "ON", "OPTIN").Err()).To(MatchError("ERR syntax error"))
+
+		subject.Invalidate("user:1", "other", "group:2")
+		Expect(receive(c)).To(Equal([]interface{}{"user:1", "group:2"}))
+
+		subject.Invalidate()
+		Expect(receive(c)).To(BeNil())
+	})
+
+})
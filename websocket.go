@@ -0,0 +1,298 @@
+package redeo
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client key of the opening handshake.
+// https://tools.ietf.org/html/rfc6455#section-1.3
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var errWebSocketFrame = errors.New("redeo: invalid websocket frame")
+
+// WebSocketHandler returns an http.Handler which serves RESP over
+// WebSocket connections, e.g. for browser-based dashboards. Text and
+// binary messages carry commands, their payloads are concatenated into
+// a single RESP stream. Replies are sent as binary messages.
+//
+// Connections are served like those accepted via Serve and are subject
+// to the same limits, TLS and the PROXY protocol are left to the
+// http.Server. Cross-origin upgrades are rejected, unless accepted by
+// Config.CheckOrigin.
+func (srv *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Sec-Websocket-Key")
+		if r.Method != http.MethodGet || key == "" ||
+			!headerContains(r.Header, "Connection", "upgrade") ||
+			!headerContains(r.Header, "Upgrade", "websocket") {
+			http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Sec-Websocket-Version") != "13" {
+			w.Header().Set("Sec-Websocket-Version", "13")
+			http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+			return
+		}
+		if !srv.checkOrigin(r) {
+			http.Error(w, "cross-origin websocket upgrade", http.StatusForbidden)
+			return
+		}
+
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "websocket not supported", http.StatusInternalServerError)
+			return
+		}
+		cn, brw, err := hj.Hijack()
+		if err != nil {
+			return
+		}
+
+		sum := sha1.Sum([]byte(key + websocketGUID))
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+		if err := brw.Flush(); err != nil {
+			_ = cn.Close()
+			return
+		}
+
+		_ = srv.serveHTTPConn(&wsConn{Conn: cn, rd: brw.Reader})
+	})
+}
+
+// serveHTTPConn serves a connection hijacked from an http.Server.
+func (srv *Server) serveHTTPConn(cn net.Conn) error {
+	if !srv.acquireSlot() {
+		srv.reject(cn)
+		return errMaxClients
+	}
+	if srv.shuttingDown() {
+		srv.releaseSlot()
+		_ = cn.Close()
+		return ErrServerClosed
+	}
+
+	return srv.serveClient(srv.newClient(cn), true)
+}
+
+// checkOrigin returns true if the upgrade request is accepted, see
+// Config.CheckOrigin.
+func (srv *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if fn := srv.conf().CheckOrigin; fn != nil {
+		return fn(r)
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// headerContains returns true if a comma-separated header contains the
+// token, case-insensitively.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// --------------------------------------------------------------------
+
+// wsConn exposes the payload of WebSocket messages as a stream.
+type wsConn struct {
+	net.Conn
+	rd *bufio.Reader
+
+	remain int64   // unread bytes of the current frame
+	mask   [4]byte // the masking key of the current frame
+	pos    int     // the position within the masking key
+
+	wmu    sync.Mutex
+	closed bool // true once a close frame was sent
+}
+
+// Read reads the payload of data frames. Control frames are handled
+// transparently.
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remain == 0 {
+		op, err := c.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case wsContinuation, wsText, wsBinary:
+		case wsPing, wsPong, wsClose:
+			payload := make([]byte, c.remain)
+			if _, err := c.readPayload(payload); err != nil {
+				return 0, err
+			}
+			switch op {
+			case wsPing:
+				if err := c.writeFrame(wsPong, payload); err != nil {
+					return 0, err
+				}
+			case wsClose:
+				_ = c.writeClose(payload)
+				return 0, io.EOF
+			}
+		default:
+			return 0, errWebSocketFrame
+		}
+	}
+
+	if int64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	return c.readPayload(p)
+}
+
+// nextFrame reads the next frame header and returns its opcode.
+func (c *wsConn) nextFrame() (byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.rd, hdr[:]); err != nil {
+		return 0, err
+	}
+
+	op := hdr[0] & 0x0f
+	if hdr[1]&0x80 == 0 { // clients must mask their frames
+		return 0, errWebSocketFrame
+	}
+
+	n := int64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rd, ext[:]); err != nil {
+			return 0, err
+		}
+		n = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rd, ext[:]); err != nil {
+			return 0, err
+		}
+		n = int64(binary.BigEndian.Uint64(ext[:]))
+		if n < 0 {
+			return 0, errWebSocketFrame
+		}
+	}
+	if op >= wsClose && n > 125 {
+		return 0, errWebSocketFrame
+	}
+
+	if _, err := io.ReadFull(c.rd, c.mask[:]); err != nil {
+		return 0, err
+	}
+	c.remain, c.pos = n, 0
+	return op, nil
+}
+
+// readPayload reads and unmasks up to len(p) bytes of the current frame.
+func (c *wsConn) readPayload(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n, err := c.rd.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= c.mask[c.pos&3]
+		c.pos++
+	}
+	c.remain -= int64(n)
+	if err == io.EOF && c.remain != 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Write sends p as a binary message.
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame and closes the connection.
+func (c *wsConn) Close() error {
+	_ = c.SetWriteDeadline(time.Now().Add(time.Second))
+	_ = c.writeClose([]byte{0x03, 0xe8}) // 1000, normal closure
+	return c.Conn.Close()
+}
+
+// writeClose sends a close frame, once.
+func (c *wsConn) writeClose(payload []byte) error {
+	if len(payload) > 2 {
+		payload = payload[:2]
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.writeFrameLocked(wsClose, payload)
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	return c.writeFrameLocked(op, payload)
+}
+
+func (c *wsConn) writeFrameLocked(op byte, payload []byte) error {
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op // final fragment
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = append(hdr, byte(n>>8), byte(n))
+	default:
+		hdr[1] = 127
+		hdr = hdr[:10]
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+
+	bufs := net.Buffers{hdr, payload}
+	_, err := bufs.WriteTo(c.Conn)
+	return err
+}
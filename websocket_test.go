@@ -0,0 +1,144 @@
+package redeo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server.WebSocketHandler", func() {
+	var subject *Server
+	var hs *httptest.Server
+
+	var writeFrame = func(w io.Writer, op byte, payload string) {
+		mask := [4]byte{1, 2, 3, 4}
+		frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+		frame = append(frame, mask[:]...)
+		for i := 0; i < len(payload); i++ {
+			frame = append(frame, payload[i]^mask[i%4])
+		}
+		_, err := w.Write(frame)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	var readFrame = func(r io.Reader) (byte, string) {
+		var hdr [2]byte
+		_, err := io.ReadFull(r, hdr[:])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr[1] & 0x80).To(BeZero())
+
+		n := int(hdr[1] & 0x7f)
+		if n == 126 {
+			var ext [2]byte
+			_, err = io.ReadFull(r, ext[:])
+			Expect(err).NotTo(HaveOccurred())
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		}
+		payload := make([]byte, n)
+		_, err = io.ReadFull(r, payload)
+		Expect(err).NotTo(HaveOccurred())
+		return hdr[0] & 0x0f, string(payload)
+	}
+
+	var upgrade = func(origin string) (net.Conn, *bufio.Reader, *http.Response) {
+		cn, err := net.Dial("tcp", hs.Listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+
+		hdr := ""
+		if origin != "" {
+			hdr = "Origin: " + origin + "\r\n"
+		}
+		_, err = io.WriteString(cn, "GET / HTTP/1.1\r\n"+
+			"Host: localhost\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: keep-alive, Upgrade\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+			"Sec-WebSocket-Version: 13\r\n"+hdr+"\r\n")
+		Expect(err).NotTo(HaveOccurred())
+
+		rd := bufio.NewReader(cn)
+		res, err := http.ReadResponse(rd, nil)
+		Expect(err).NotTo(HaveOccurred())
+		return cn, rd, res
+	}
+
+	var dial = func() (net.Conn, *bufio.Reader) {
+		cn, rd, res := upgrade("")
+		Expect(res.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+		Expect(res.Header.Get("Sec-WebSocket-Accept")).To(Equal("s3pPLMBiTxaQ9kYGzzhZRbK+xOo="))
+		return cn, rd
+	}
+
+	BeforeEach(func() {
+		subject = NewServer(nil)
+		subject.Handle("ping", Ping())
+		subject.Handle("echo", Echo())
+		hs = httptest.NewServer(subject.WebSocketHandler())
+	})
+
+	AfterEach(func() {
+		hs.Close()
+	})
+
+	It("should reject plain requests", func() {
+		res, err := http.Get(hs.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should reject cross-origin upgrades", func() {
+		cn, _, res := upgrade("http://localhost")
+		cn.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+		cn, _, res = upgrade("http://evil.example")
+		cn.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+
+		hs.Close()
+		subject = NewServer(&Config{CheckOrigin: func(r *http.Request) bool {
+			return r.Header.Get("Origin") == "http://evil.example"
+		}})
+		hs = httptest.NewServer(subject.WebSocketHandler())
+		cn, _, res = upgrade("http://evil.example")
+		cn.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+	})
+
+	It("should serve commands", func() {
+		cn, rd := dial()
+		defer cn.Close()
+
+		writeFrame(cn, wsText, "PING\r\n")
+		op, payload := readFrame(rd)
+		Expect(op).To(Equal(byte(wsBinary)))
+		Expect(payload).To(Equal("+PONG\r\n"))
+
+		// commands may span messages
+		writeFrame(cn, wsBinary, "*2\r\n$4\r\nECHO\r\n")
+		writeFrame(cn, wsPing, "hi")
+		writeFrame(cn, wsBinary, "$5\r\nhello\r\n")
+
+		op, payload = readFrame(rd)
+		Expect(op).To(Equal(byte(wsPong)))
+		Expect(payload).To(Equal("hi"))
+		op, payload = readFrame(rd)
+		Expect(op).To(Equal(byte(wsBinary)))
+		Expect(payload).To(Equal("$5\r\nhello\r\n"))
+		Expect(subject.Info().NumClients()).To(Equal(1))
+
+		writeFrame(cn, wsClose, "\x03\xe8")
+		op, payload = readFrame(rd)
+		Expect(op).To(Equal(byte(wsClose)))
+		Expect(payload).To(Equal("\x03\xe8"))
+		Eventually(subject.Info().NumClients).Should(Equal(0))
+	})
+
+})
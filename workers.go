@@ -0,0 +1,82 @@
+package redeo
+
+import "io"
+
+// workerPool bounds the number of clients executing commands at the same
+// time, see Config.Workers. A nil pool is unbounded.
+type workerPool chan struct{}
+
+func newWorkerPool(n int) workerPool {
+	if n <= 0 {
+		return nil
+	}
+	return make(workerPool, n)
+}
+
+// acquire waits for a free worker, unless the client holds one.
+func (p workerPool) acquire(c *Client) {
+	if p != nil && !c.working {
+		p <- struct{}{}
+		c.working = true
+	}
+}
+
+// release frees the worker held by the client.
+func (p workerPool) release(c *Client) {
+	if p != nil && c.working {
+		<-p
+		c.working = false
+	}
+}
+
+// awaitRequest waits for the next request of an idle client without
+// holding a read buffer. It acquires a worker once the request arrives.
+func (srv *Server) awaitRequest(c *Client) error {
+	if srv.workers == nil {
+		return nil
+	}
+
+	srv.workers.release(c)
+	if c.rd.Buffered() == 0 {
+		c.buffers.putReader(c.rd)
+		err := c.ic.wait()
+		c.rd = srv.limitReader(c.buffers.reader(&c.ic))
+		if err != nil {
+			return err
+		}
+	}
+	srv.workers.acquire(c)
+	return nil
+}
+
+// --------------------------------------------------------------------
+
+// idleConn reads the first byte of a request while the client holds no
+// read buffer and replays it to the reader acquired thereafter.
+type idleConn struct {
+	io.Reader
+	b    [1]byte
+	held bool
+}
+
+// wait blocks until the first byte is received.
+func (ic *idleConn) wait() error {
+	n, err := ic.Reader.Read(ic.b[:])
+	ic.held = n == 1
+	return err
+}
+
+// Read replays the held byte along with the rest of the request, as
+// readers expect complete lines to be returned by a single read.
+func (ic *idleConn) Read(p []byte) (int, error) {
+	if !ic.held || len(p) == 0 {
+		return ic.Reader.Read(p)
+	}
+
+	p[0], ic.held = ic.b[0], false
+	if len(p) == 1 {
+		return 1, nil
+	}
+	n, err := ic.Reader.Read(p[1:])
+	return n + 1, err
+}
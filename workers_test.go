@@ -0,0 +1,92 @@
+package redeo
+
+import (
+	"net"
+	"time"
+
+	"github.com/wangaoone/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config.Workers", func() {
+	var subject *Server
+	var lis net.Listener
+	var entered, released chan struct{}
+	var blockers chan *Blocker
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		entered, released = make(chan struct{}, 1), make(chan struct{})
+		blockers = make(chan *Blocker, 1)
+
+		subject = NewServer(&Config{Workers: 1})
+		subject.Handle("ping", Ping())
+		subject.HandleFunc("hold", func(w resp.ResponseWriter, c *resp.Command) {
+			entered <- struct{}{}
+			<-released
+			w.AppendOK()
+		})
+		subject.HandleFunc("wait", func(w resp.ResponseWriter, c *resp.Command) {
+			blockers <- GetClient(c.Context()).Block(0)
+		})
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should limit the clients executing commands", func() {
+		cn1, cw1, cr1 := dialRaw(lis)
+		defer cn1.Close()
+		cn2, cw2, cr2 := dialRaw(lis)
+		defer cn2.Close()
+
+		// idle clients serve requests once a worker is free
+		cw2.WriteCmd("PING")
+		Expect(cw2.Flush()).To(Succeed())
+		Expect(cr2.ReadInlineString()).To(Equal("PONG"))
+
+		cw1.WriteCmd("HOLD")
+		Expect(cw1.Flush()).To(Succeed())
+		Eventually(entered).Should(Receive())
+
+		pong := make(chan string, 1)
+		go func() {
+			s, _ := cr2.ReadInlineString()
+			pong <- s
+		}()
+		cw2.WriteCmd("PING")
+		Expect(cw2.Flush()).To(Succeed())
+		Consistently(pong, 50*time.Millisecond).ShouldNot(Receive())
+
+		close(released)
+		Expect(cr1.ReadInlineString()).To(Equal("OK"))
+		Eventually(pong).Should(Receive(Equal("PONG")))
+	})
+
+	It("should release workers while clients are blocked", func() {
+		cn1, cw1, cr1 := dialRaw(lis)
+		defer cn1.Close()
+		cn2, cw2, cr2 := dialRaw(lis)
+		defer cn2.Close()
+
+		cw1.WriteCmd("WAIT")
+		Expect(cw1.Flush()).To(Succeed())
+
+		var b *Blocker
+		Eventually(blockers).Should(Receive(&b))
+
+		cw2.WriteCmd("PING")
+		Expect(cw2.Flush()).To(Succeed())
+		Expect(cr2.ReadInlineString()).To(Equal("PONG"))
+
+		Expect(b.Resolve(func(w resp.ResponseWriter) { w.AppendOK() })).To(BeTrue())
+		Expect(cr1.ReadInlineString()).To(Equal("OK"))
+	})
+
+})